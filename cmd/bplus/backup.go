@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// backer is a tree or a database, either of which can be backed up.
+type backer interface {
+	Backup(w io.Writer) error
+	BackupIncremental(w io.Writer, base io.Reader) error
+	Close() error
+}
+
+// runBackup writes a backup of the tree or database in a file to a new file, or with
+// -incremental, only the pages that have changed since a backup taken earlier. The file
+// is opened read-only, so other processes can read it while it's backed up but can't
+// write to it. With -verify, the backup is restored to a temporary file and checked.
+func runBackup(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	base := fs.String("incremental", "", "only back up the pages changed since this backup")
+	verify := fs.Bool("verify", false, "restore the backup to a temporary file and check it")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return errUsage
+	}
+	path, dst := fs.Arg(0), fs.Arg(1)
+	var b backer
+	tree, err := bplus.OpenTree(path, bplus.WithReadOnly())
+	if err == bplus.ErrDB {
+		b, err = bplus.OpenDB(path, bplus.WithReadOnly())
+	} else if err == nil {
+		b = tree
+	}
+	if err != nil {
+		return err
+	}
+	n, err := writeBackup(dst, func(w io.Writer) error {
+		if *base == "" {
+			return b.Backup(w)
+		}
+		file, err := os.Open(*base)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return b.BackupIncremental(w, file)
+	})
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	kind := "a backup"
+	if *base != "" {
+		kind = "an incremental backup"
+	}
+	fmt.Fprintf(out, "wrote %s of %s to %s, %d bytes\n", kind, path, dst, n)
+	if !*verify {
+		return nil
+	}
+	return verifyRestore(out, func(filename string) error {
+		return restore(dst, *base, filename)
+	})
+}
+
+// writeBackup creates the file dst and has write write a backup to it, returning the
+// number of bytes written. The file is synced before it's closed, and removed if the
+// backup can't be written.
+func writeBackup(dst string, write func(w io.Writer) error) (int64, error) {
+	file, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return 0, err
+	}
+	w := &countingWriter{w: file}
+	err = write(w)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return w.n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// runRestore rebuilds a file from a backup, and optionally an incremental backup taken
+// against it, or the write-ahead log segments archived since it was taken, up to a log
+// sequence number. With -verify, the restored file is checked.
+func runRestore(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	incremental := fs.String("incremental", "", "apply this incremental backup on top")
+	archive := fs.String("archive", "", "replay the log segments archived in this directory")
+	lsn := fs.Uint64("lsn", 0, "stop replaying the archive after this log sequence number")
+	verify := fs.Bool("verify", false, "check the restored file")
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 2 || *incremental != "" && *archive != "" ||
+		*lsn != 0 && *archive == "" {
+		return errUsage
+	}
+	backup, path := fs.Arg(0), fs.Arg(1)
+	if *archive != "" {
+		err = recoverTo(out, backup, *archive, path, *lsn)
+	} else if *incremental != "" {
+		err = restore(*incremental, backup, path)
+	} else {
+		err = restore(backup, "", path)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "restored %s to %s\n", backup, path)
+	if !*verify {
+		return nil
+	}
+	return checkRestored(out, path)
+}
+
+// restore restores the backup in the file named backup to filename, which must not
+// exist yet. If base isn't empty, the backup is an incremental backup taken against the
+// backup in the file named base, which is restored first.
+func restore(backup, base, filename string) error {
+	r, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if base == "" {
+		return bplus.RestoreFrom(r, filename)
+	}
+	b, err := os.Open(base)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	return bplus.RestoreIncremental(b, r, filename)
+}
+
+// recoverTo restores a backup to filename and replays the segments archived in dir on
+// top of it, up to lsn, or all of them if it's zero.
+func recoverTo(out io.Writer, backup, dir, filename string, lsn uint64) error {
+	r, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// NewDirArchive would create a directory that doesn't exist.
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	archive, err := store.NewDirArchive(dir)
+	if err != nil {
+		return err
+	}
+	segments, err := archive.Segments()
+	if err != nil {
+		return err
+	}
+	lsn, err = bplus.RecoverTo(r, segments, filename, store.RecoveryTarget{LSN: lsn})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "recovered to lsn %d\n", lsn)
+	return nil
+}
+
+// verifyRestore has restore restore a backup to a file in a temporary directory, and
+// checks the file, which is removed afterwards.
+func verifyRestore(out io.Writer, restore func(filename string) error) error {
+	dir, err := os.MkdirTemp("", "bplus-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "restored")
+	err = restore(filename)
+	if err != nil {
+		return fmt.Errorf("restoring the backup: %v", err)
+	}
+	return checkRestored(out, filename)
+}
+
+// checkRestored checks a restored file, returning errCorrupt if it has problems.
+func checkRestored(out io.Writer, filename string) error {
+	report, err := check(filename, out)
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return errCorrupt
+	}
+	return nil
+}
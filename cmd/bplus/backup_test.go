@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	put := func(from, to int) {
+		t.Helper()
+		var script strings.Builder
+		for i := from; i < to; i++ {
+			fmt.Fprintf(&script, "put key%03d value%d\n", i, i)
+		}
+		stdin = strings.NewReader(script.String())
+		if err := run([]string{"shell", path}, &bytes.Buffer{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() { stdin = os.Stdin }()
+	put(0, 300)
+
+	full := filepath.Join(dir, "full.bak")
+	var out bytes.Buffer
+	if err := run([]string{"backup", "-verify", path, full}, &out); err != nil {
+		t.Fatal(err, out.String())
+	}
+	for _, s := range []string{
+		"wrote a backup of " + path + " to " + full,
+		"8 pages, 1 trees, 300 records, 0 problems",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+	if err := run([]string{"backup", path, full}, &out); !os.IsExist(err) {
+		t.Fatalf("expected the backup not to be overwritten, got %v", err)
+	}
+
+	put(300, 310)
+	inc := filepath.Join(dir, "inc.bak")
+	out.Reset()
+	args := []string{"backup", "-incremental", full, "-verify", path, inc}
+	if err := run(args, &out); err != nil {
+		t.Fatal(err, out.String())
+	}
+	for _, s := range []string{
+		"wrote an incremental backup",
+		"1 trees, 310 records, 0 problems",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+	fullInfo, err := os.Stat(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	incInfo, err := os.Stat(inc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incInfo.Size() >= fullInfo.Size() {
+		t.Fatalf("expected %v < %v", incInfo.Size(), fullInfo.Size())
+	}
+
+	restored := filepath.Join(dir, "restored")
+	out.Reset()
+	args = []string{"restore", "-incremental", inc, "-verify", full, restored}
+	if err := run(args, &out); err != nil {
+		t.Fatal(err, out.String())
+	}
+	if !strings.Contains(out.String(), "310 records, 0 problems") {
+		t.Fatalf("expected 310 records in:\n%s", out.String())
+	}
+	out.Reset()
+	args = []string{"restore", "-incremental", inc, full, filepath.Join(dir, "again")}
+	if err := run(args, &out); err != nil {
+		t.Fatal(err)
+	}
+	// The incremental backup can only be applied to the backup it was taken against.
+	later := filepath.Join(dir, "later.bak")
+	if err := run([]string{"backup", path, later}, &out); err != nil {
+		t.Fatal(err)
+	}
+	args = []string{"restore", "-incremental", inc, later, filepath.Join(dir, "wrong")}
+	if err := run(args, &out); err != store.ErrWrongBase {
+		t.Fatalf("expected %v == %v", err, store.ErrWrongBase)
+	}
+	if err := run([]string{"restore", "-lsn", "3", full, restored}, &out); err != errUsage {
+		t.Fatalf("expected %v == %v", err, errUsage)
+	}
+}
+
+func TestRestoreArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	archiveDir := filepath.Join(dir, "archive")
+	archive, err := store.NewDirArchive(archiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := bplus.NewTree(path, bplus.WithWALArchive(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	put := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			key := fmt.Sprintf("key%03d", i)
+			if err := tree.Insert(bplus.Key(key), bplus.Value("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	put(0, 10)
+	full := filepath.Join(dir, "full.bak")
+	file, err := os.Create(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Backup(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	put(10, 20)
+	target := tree.LSN()
+	put(20, 30)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := filepath.Join(dir, "restored")
+	var out bytes.Buffer
+	args := []string{"restore", "-archive", archiveDir, "-lsn", fmt.Sprint(target),
+		"-verify", full, restored}
+	if err := run(args, &out); err != nil {
+		t.Fatal(err, out.String())
+	}
+	for _, s := range []string{
+		fmt.Sprintf("recovered to lsn %d\n", target),
+		"1 trees, 20 records, 0 problems",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+	args = []string{"restore", "-archive", filepath.Join(dir, "missing"), full,
+		filepath.Join(dir, "again")}
+	if err := run(args, &out); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing archive, got %v", err)
+	}
+}
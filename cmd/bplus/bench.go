@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+const (
+	// zipfSkew is how skewed the zipfian key distribution is. YCSB uses 0.99, but the
+	// generator in math/rand needs more than 1.
+	zipfSkew = 1.1
+	// latencyPrecision is what latencies are rounded to when they're printed.
+	latencyPrecision = 100 * time.Nanosecond
+)
+
+// The operations a workload is made of.
+const (
+	opRead = iota
+	opUpdate
+	opInsert
+	opScan
+	numOps
+)
+
+var opNames = [numOps]string{"read", "update", "insert", "scan"}
+
+// workload is the mix of operations run against a tree after it's been loaded, as
+// percentages of each of them.
+type workload [numOps]int
+
+// workloads are the workloads bench can run, named after what they do rather than after
+// the YCSB workloads they're modelled on. The load workload only loads the tree.
+var workloads = map[string]workload{
+	"load":         {},
+	"update-heavy": {opRead: 50, opUpdate: 50}, // YCSB A
+	"read-heavy":   {opRead: 95, opUpdate: 5},  // YCSB B
+	"read-only":    {opRead: 100},              // YCSB C
+	"scan":         {opScan: 95, opInsert: 5},  // YCSB E
+	"write-heavy":  {opRead: 10, opUpdate: 50, opInsert: 40},
+}
+
+// benchConfig holds the flags of bench.
+type benchConfig struct {
+	workloadName string
+	workload     workload
+	records      int
+	ops          int
+	zipfian      bool
+	valueSize    int
+	maxScan      int
+	threads      int
+	seed         int64
+	// nextInsert is the number of the next record inserted by the workload.
+	nextInsert atomic.Int64
+}
+
+// runBench creates a tree in a new file, loads it with records, and then runs a workload
+// against it, printing the throughput of each phase and the latency percentiles of each
+// operation. The file is removed afterwards unless -keep is set.
+func runBench(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	name := fs.String("workload", "read-heavy", "the mix of operations to run")
+	records := fs.Int("records", 100000, "the number of records to load")
+	ops := fs.Int("ops", 100000, "the number of operations to run after loading")
+	dist := fs.String("dist", "zipfian", "how keys are chosen: zipfian or uniform")
+	valueSize := fs.Int("value", 100, "the size of the values in bytes")
+	maxScan := fs.Int("scan", 100, "the most records a scan reads")
+	threads := fs.Int("threads", 1, "the number of goroutines running operations")
+	cache := fs.Int("cache", 1024, "the size of the page cache in pages")
+	branchingFactor := fs.Int("bf", 0, "the branching factor, or 0 for the default")
+	syncCommits := fs.Bool("sync", false, "sync the file after every write")
+	seed := fs.Int64("seed", 1, "the seed keys and values are chosen with")
+	keep := fs.Bool("keep", false, "keep the file once the benchmark is done")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	w, ok := workloads[*name]
+	if !ok || *dist != "zipfian" && *dist != "uniform" || *records < 1 || *ops < 0 ||
+		*valueSize < 0 || *maxScan < 1 || *threads < 1 {
+		return errUsage
+	}
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	opts := []bplus.Option{bplus.WithCacheSize(*cache)}
+	if *branchingFactor != 0 {
+		opts = append(opts, bplus.WithBranchingFactor(*branchingFactor))
+	}
+	if *syncCommits {
+		opts = append(opts, bplus.WithDurability(store.SyncEveryCommit, 0))
+	}
+	tree, err := bplus.NewTree(path, opts...)
+	if err != nil {
+		return err
+	}
+	c := &benchConfig{
+		workload:     w,
+		records:      *records,
+		ops:          *ops,
+		zipfian:      *dist == "zipfian",
+		valueSize:    *valueSize,
+		maxScan:      *maxScan,
+		threads:      *threads,
+		seed:         *seed,
+		workloadName: *name,
+	}
+	err = c.run(tree, out)
+	if closeErr := tree.Close(); err == nil {
+		err = closeErr
+	}
+	if !*keep {
+		if removeErr := os.Remove(path); err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// run loads the tree and runs the workload against it.
+func (c *benchConfig) run(tree *bplus.Tree, out io.Writer) error {
+	c.nextInsert.Store(int64(c.records))
+	load := func(thread int, r *rand.Rand, latencies *[numOps][]time.Duration) error {
+		for i := thread; i < c.records; i += c.threads {
+			start := time.Now()
+			err := tree.Insert(benchKey(i), benchValue(r, c.valueSize))
+			if err != nil {
+				return err
+			}
+			latencies[opInsert] = append(latencies[opInsert], time.Since(start))
+		}
+		return nil
+	}
+	fmt.Fprintf(out, "load: %d records of %d bytes, %d threads\n",
+		c.records, c.valueSize, c.threads)
+	err := c.phase(out, load)
+	if err != nil || c.workloadName == "load" || c.ops == 0 {
+		return err
+	}
+	dist := "uniform"
+	if c.zipfian {
+		dist = "zipfian"
+	}
+	fmt.Fprintf(out, "\n%s: %d operations, %s keys, %d threads\n",
+		c.workloadName, c.ops, dist, c.threads)
+	return c.phase(out, func(thread int, r *rand.Rand, l *[numOps][]time.Duration) error {
+		return c.runOps(tree, thread, r, l)
+	})
+}
+
+// phase runs fn on every thread, and prints the throughput and latencies of what they
+// did.
+func (c *benchConfig) phase(
+	out io.Writer,
+	fn func(thread int, r *rand.Rand, latencies *[numOps][]time.Duration) error,
+) error {
+	var wg sync.WaitGroup
+	latencies := make([][numOps][]time.Duration, c.threads)
+	errs := make([]error, c.threads)
+	start := time.Now()
+	for thread := 0; thread < c.threads; thread++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(c.seed + int64(thread)))
+			errs[thread] = fn(thread, r, &latencies[thread])
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	var merged [numOps][]time.Duration
+	total := 0
+	for _, l := range latencies {
+		for op := range l {
+			merged[op] = append(merged[op], l[op]...)
+			total += len(l[op])
+		}
+	}
+	fmt.Fprintf(out, "%d operations in %s, %.0f ops/s\n\n",
+		total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+	fmt.Fprintf(out, "%-6s  %8s  %10s  %10s  %10s  %10s\n",
+		"op", "count", "p50", "p95", "p99", "max")
+	for op, l := range merged {
+		if len(l) == 0 {
+			continue
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(out, "%-6s  %8d  %10s  %10s  %10s  %10s\n", opNames[op], len(l),
+			percentile(l, 0.5), percentile(l, 0.95), percentile(l, 0.99),
+			l[len(l)-1].Round(latencyPrecision))
+	}
+	return nil
+}
+
+// runOps runs a thread's share of the workload's operations, choosing each of them at
+// random in the workload's proportions.
+func (c *benchConfig) runOps(
+	tree *bplus.Tree,
+	thread int,
+	r *rand.Rand,
+	latencies *[numOps][]time.Duration,
+) error {
+	choose := func() int { return r.Intn(c.records) }
+	if c.zipfian && c.records > 1 {
+		zipf := rand.NewZipf(r, zipfSkew, 1, uint64(c.records-1))
+		choose = func() int { return int(zipf.Uint64()) }
+	}
+	for i := thread; i < c.ops; i += c.threads {
+		op := c.chooseOp(r)
+		var err error
+		start := time.Now()
+		switch op {
+		case opRead:
+			_, err = tree.Read(benchKey(choose()))
+		case opUpdate:
+			value := benchValue(r, c.valueSize)
+			err = tree.Update(benchKey(choose()), func(bplus.Value) (bplus.Value, error) {
+				return value, nil
+			})
+		case opInsert:
+			i := c.nextInsert.Add(1) - 1
+			err = tree.Insert(benchKey(int(i)), benchValue(r, c.valueSize))
+		case opScan:
+			err = scan(tree, benchKey(choose()), 1+r.Intn(c.maxScan))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", opNames[op], err)
+		}
+		latencies[op] = append(latencies[op], time.Since(start))
+	}
+	return nil
+}
+
+// chooseOp chooses an operation at random in the workload's proportions.
+func (c *benchConfig) chooseOp(r *rand.Rand) int {
+	n := r.Intn(100)
+	for op, percent := range c.workload {
+		if n < percent {
+			return op
+		}
+		n -= percent
+	}
+	return opRead
+}
+
+// scan reads up to n records from start on.
+func scan(tree *bplus.Tree, start bplus.Key, n int) error {
+	cursor := tree.Cursor()
+	defer cursor.Close()
+	_, err := cursor.Seek(start)
+	for i := 1; i < n && err == nil; i++ {
+		_, err = cursor.Next()
+	}
+	if err == bplus.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// benchKey returns the key of the ith record. Like YCSB's, keys are hashed so that
+// records are inserted in random order, and the most popular keys of the zipfian
+// distribution are spread over the tree rather than all being next to each other.
+func benchKey(i int) bplus.Key {
+	h := fnv.New64a()
+	fmt.Fprint(h, i)
+	return bplus.Key(fmt.Sprintf("user%016x", h.Sum64()))
+}
+
+// benchValue returns a random value of the given size.
+func benchValue(r *rand.Rand, size int) bplus.Value {
+	value := make(bplus.Value, size)
+	r.Read(value)
+	return value
+}
+
+// percentile returns the pth percentile of the sorted latencies, rounded to
+// latencyPrecision.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1))].Round(latencyPrecision)
+}
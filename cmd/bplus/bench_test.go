@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	for name, w := range workloads {
+		var out bytes.Buffer
+		args := []string{"bench", "-workload", name, "-records", "500", "-ops", "500",
+			"-threads", "4", "-bf", "16", "-scan", "10", path}
+		if err := run(args, &out); err != nil {
+			t.Fatal(name, err)
+		}
+		expected := []string{
+			"load: 500 records of 100 bytes, 4 threads\n",
+			"\ninsert       500  ",
+		}
+		for op, percent := range w {
+			if percent > 0 {
+				expected = append(expected, "\n"+opNames[op]+" ")
+			}
+		}
+		if name != "load" {
+			expected = append(expected, "\n"+name+": 500 operations, zipfian keys")
+		}
+		for _, s := range expected {
+			if !strings.Contains(out.String(), s) {
+				t.Fatalf("expected %q in:\n%s", s, out.String())
+			}
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected the tree to be removed, got %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	args := []string{"bench", "-workload", "scan", "-dist", "uniform", "-records", "100",
+		"-ops", "100", "-keep", path}
+	if err := run(args, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(args, &out); err == nil || !strings.Contains(err.Error(), "exists") {
+		t.Fatalf("expected an error for an existing file, got %v", err)
+	}
+	for _, args := range [][]string{
+		{"bench", "-workload", "bogus", path},
+		{"bench", "-dist", "bogus", path},
+		{"bench", "-threads", "0", path},
+	} {
+		if err := run(args, &out); err != errUsage {
+			t.Fatalf("expected %v == %v for %q", err, errUsage, args)
+		}
+	}
+}
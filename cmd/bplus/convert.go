@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/convert"
+)
+
+// textCodecs are the codecs of the -keys and -values flags, by name.
+var textCodecs = map[string]bplus.TextCodec{
+	"raw":    bplus.RawText,
+	"hex":    bplus.HexText,
+	"base64": bplus.Base64Text,
+}
+
+// convertFlags parses the flags of import and export.
+func convertFlags(name string, args []string) (convert.Options, []string, error) {
+	var opts convert.Options
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	bucket := fs.String("bucket", "", "the slash-separated path of a bbolt bucket")
+	keys := fs.String("keys", "raw", "how keys are written in csv and jsonl files")
+	values := fs.String("values", "raw", "how values are written in csv and jsonl files")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 3 {
+		return opts, nil, errUsage
+	}
+	var ok bool
+	if opts.Keys, ok = textCodecs[*keys]; !ok {
+		return opts, nil, errUsage
+	}
+	if opts.Values, ok = textCodecs[*values]; !ok {
+		return opts, nil, errUsage
+	}
+	if *bucket != "" {
+		opts.Bucket = bytes.Split([]byte(*bucket), []byte("/"))
+	}
+	return opts, fs.Args(), nil
+}
+
+// checkFormat returns an error explaining which formats are built in if name isn't one.
+func checkFormat(name string) error {
+	formats := convert.Formats()
+	for _, format := range formats {
+		if format == name {
+			return nil
+		}
+	}
+	if len(formats) == 0 {
+		formats = []string{"none"}
+	}
+	return fmt.Errorf("%v %q, built-in formats: %s (see the bbolt, leveldb and pebble "+
+		"build tags)", convert.ErrUnknownFormat, name, strings.Join(formats, ", "))
+}
+
+func runImport(args []string, out io.Writer) error {
+	opts, args, err := convertFlags("import", args)
+	if err != nil {
+		return err
+	}
+	if err := checkFormat(args[0]); err != nil {
+		return err
+	}
+	tree, err := openTree(args[2], true)
+	if err != nil {
+		return err
+	}
+	n, err := convert.Import(args[0], args[1], tree, opts)
+	if closeErr := tree.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "imported %d records\n", n)
+	return nil
+}
+
+func runExport(args []string, out io.Writer) error {
+	opts, args, err := convertFlags("export", args)
+	if err != nil {
+		return err
+	}
+	if err := checkFormat(args[0]); err != nil {
+		return err
+	}
+	tree, err := openTree(args[1], false, bplus.WithReadOnly())
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
+	n, err := convert.Export(args[0], tree, args[2], opts)
+	if err != nil {
+		return err
+	}
+	// A dump to standard output isn't followed by a summary.
+	if args[2] != "-" {
+		fmt.Fprintf(out, "exported %d records\n", n)
+	}
+	return nil
+}
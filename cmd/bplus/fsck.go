@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// errCorrupt is returned by fsck when it finds problems with a file.
+var errCorrupt = errors.New("file is corrupt")
+
+// runFsck checks a file for corruption, printing each problem found, and with -repair
+// salvages what can still be read of it into a new file. With -gc, the orphaned pages of
+// a file without problems are freed.
+func runFsck(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	repair := fs.String("repair", "", "salvage what can be read into this new file")
+	gc := fs.Bool("gc", false, "free the pages that are neither free nor in use")
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 || *gc && *repair != "" {
+		return errUsage
+	}
+	report, err := check(fs.Arg(0), out)
+	if err != nil {
+		return err
+	}
+	if *repair == "" {
+		if !report.OK() {
+			return errCorrupt
+		}
+		if *gc && len(report.Orphans) > 0 {
+			return collectGarbage(fs.Arg(0), out)
+		}
+		return nil
+	}
+	salvaged, err := bplus.Salvage(fs.Arg(0), *repair)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "salvaged %d trees and %d records into %s\n",
+		salvaged.Trees, salvaged.Records, *repair)
+	if len(salvaged.LostPages) > 0 {
+		fmt.Fprintf(out, "lost the records under pages %v\n", salvaged.LostPages)
+	}
+	if salvaged.Dropped > 0 {
+		fmt.Fprintf(out, "dropped %d records that were out of place or unreadable\n",
+			salvaged.Dropped)
+	}
+	for _, name := range salvaged.LostTrees {
+		fmt.Fprintf(out, "lost tree %q\n", name)
+	}
+	return nil
+}
+
+// check checks a file with bplus.Check, printing each problem found and a summary.
+func check(path string, out io.Writer) (*bplus.CheckReport, error) {
+	report, err := bplus.Check(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintf(out, "page %d: %s\n", p.PageID, p.Reason)
+	}
+	fmt.Fprintf(out, "%d pages, %d trees, %d records, %d problems, %d orphaned pages\n",
+		report.Pages, report.Trees, report.Records, len(report.Problems),
+		len(report.Orphans))
+	return report, nil
+}
+
+// collectGarbage frees the orphaned pages of the tree or database in a file.
+func collectGarbage(path string, out io.Writer) error {
+	var freed []store.PageID
+	tree, err := bplus.OpenTree(path)
+	if err == bplus.ErrDB {
+		var db *bplus.DB
+		db, err = bplus.OpenDB(path)
+		if err != nil {
+			return err
+		}
+		freed, err = db.CollectGarbage()
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	} else if err == nil {
+		freed, err = tree.CollectGarbage()
+		if closeErr := tree.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "freed orphaned pages %v\n", freed)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestFsck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	var script strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&script, "put key%03d value%d\n", i, i)
+	}
+	stdin = strings.NewReader(script.String())
+	defer func() { stdin = os.Stdin }()
+	var out bytes.Buffer
+	if err := run([]string{"shell", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := run([]string{"fsck", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "8 pages, 1 trees, 300 records, 0 problems, 0 orphaned pages\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q == %q", out.String(), expected)
+	}
+
+	// A page allocated and never used is orphaned, and freed by -gc.
+	s, err := store.NewPageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := run([]string{"fsck", "-gc", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected = "9 pages, 1 trees, 300 records, 0 problems, 1 orphaned pages\n" +
+		"freed orphaned pages [8]\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q == %q", out.String(), expected)
+	}
+	out.Reset()
+	if err := run([]string{"fsck", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), " 0 orphaned pages\n") {
+		t.Fatalf("expected no orphaned pages in:\n%s", out.String())
+	}
+
+	// Page 4 is the tree's first leaf, holding key000 to key063.
+	file, err := os.OpenFile(path, os.O_RDWR, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("corrupt"), 4*store.PageSize+100); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	out.Reset()
+	if err := run([]string{"fsck", path}, &out); err != errCorrupt {
+		t.Fatalf("expected %v == %v", err, errCorrupt)
+	}
+	if !strings.HasPrefix(out.String(), "page 4: checksum mismatch\n") {
+		t.Fatalf("expected a checksum mismatch in:\n%s", out.String())
+	}
+
+	out.Reset()
+	repaired := filepath.Join(dir, "repaired")
+	if err := run([]string{"fsck", "-repair", repaired, path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"salvaged 1 trees and 236 records into " + repaired + "\n",
+		"lost the records under pages [4]\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+	out.Reset()
+	if err := run([]string{"fsck", repaired}, &out); err != nil {
+		t.Fatal(err, out.String())
+	}
+}
@@ -0,0 +1,122 @@
+// Command bplus works with B+ tree files from package bplus from the command line.
+//
+// Usage:
+//
+//	bplus <command> [arguments]
+//
+// Run bplus without arguments for the list of commands.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// stderr is where usage and errors are written.
+var stderr io.Writer = os.Stderr
+
+// errUsage is returned by a command that was run with the wrong arguments.
+var errUsage = errors.New("usage")
+
+// command is a subcommand of bplus.
+type command struct {
+	name string
+	// args describes the command's arguments, for its usage line.
+	args    string
+	summary string
+	run     func(args []string, out io.Writer) error
+}
+
+// commands are the subcommands of bplus, in the order they're listed.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"shell", "[-readonly] <file>",
+			"get, put, delete and scan the records of a tree from a shell", runShell},
+		{"import", "[-bucket a/b] [-keys codec] [-values codec] <format> <src> <file>",
+			"copy the records of a CSV, JSON lines or other store's file into a tree",
+			runImport},
+		{"export", "[-bucket a/b] [-keys codec] [-values codec] <format> <file> <dst>",
+			"copy the records of a tree into a CSV, JSON lines or other store's file",
+			runExport},
+		{"page", "<file> <id>",
+			"print the fields of a page of a file and an annotated hex dump of it",
+			runPage},
+		{"tree", "[-dot] [-depth n] <file>",
+			"print the pages of a tree, how full they are and the keys under them",
+			runTree},
+		{"fsck", "[-repair dst | -gc] <file>",
+			"check a file for corruption, salvage it into a new file or free lost pages",
+			runFsck},
+		{"backup", "[-incremental base] [-verify] <file> <dst>",
+			"write a backup of a file, or of the pages changed since another backup",
+			runBackup},
+		{"restore", "[-incremental inc | -archive dir [-lsn n]] [-verify] <backup> <file>",
+			"rebuild a file from a backup", runRestore},
+		{"bench", "[-workload name] [-records n] [-ops n] [-dist d] [flags] <file>",
+			"load a new tree and time a YCSB-style workload against it", runBench},
+		{"stats", "[-top n] <file>",
+			"print what a file's pages are used for and how full its trees are",
+			runStats},
+	}
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err == errUsage {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "bplus:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by args[0].
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errUsage
+	}
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(args[1:], out)
+		if err == errUsage {
+			fmt.Fprintf(stderr, "usage: bplus %s %s\n", c.name, c.args)
+		}
+		return err
+	}
+	fmt.Fprintf(stderr, "bplus: unknown command %q\n", args[0])
+	usage(stderr)
+	return errUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: bplus <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "The commands are:")
+	fmt.Fprintln(w)
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t%-10s %s\n", c.name, c.summary)
+	}
+}
+
+// openTree opens the tree in the file at path, creating it if create is set and it
+// doesn't exist yet.
+func openTree(path string, create bool, opts ...bplus.Option) (*bplus.Tree, error) {
+	info, err := os.Stat(path)
+	if create && (os.IsNotExist(err) || err == nil && info.Size() == 0) {
+		return bplus.NewTree(path, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bplus.OpenTree(path, opts...)
+}
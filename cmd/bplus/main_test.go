@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	stderr = io.Discard
+	os.Exit(m.Run())
+}
+
+func TestRunUsage(t *testing.T) {
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		nil,
+		{"bogus"},
+		{"import", "bbolt"},
+		{"export", "-bogus", "bbolt", "a", "b"},
+	} {
+		if err := run(args, &out); err != errUsage {
+			t.Fatalf("expected %v == %v for %q", err, errUsage, args)
+		}
+	}
+}
+
+func TestImportUnknownFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	var out bytes.Buffer
+	err = run([]string{"import", "nope", "src", path}, &out)
+	if err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Fatalf("expected an unknown format error, got %v", err)
+	}
+	// The tree isn't created for a format that isn't built in.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the tree not to exist, got %v", err)
+	}
+}
+
+func TestImportExportText(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src.csv")
+	if err := ioutil.WriteFile(src, []byte("61,1\n62,2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tree")
+	var out bytes.Buffer
+	if err := run([]string{"import", "-keys", "hex", "csv", src, path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "imported 2 records\n" {
+		t.Fatalf("expected %q == %q", out.String(), "imported 2 records\n")
+	}
+	dst := filepath.Join(dir, "dst.jsonl")
+	if err := run([]string{"export", "jsonl", path, dst}, &out); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"key":"a","value":"1"}` + "\n" + `{"key":"b","value":"2"}` + "\n"
+	if string(dump) != expected {
+		t.Fatalf("expected %q == %q", dump, expected)
+	}
+	err = run([]string{"export", "-values", "bogus", "csv", path, dst}, &out)
+	if err != errUsage {
+		t.Fatalf("expected %v == %v", err, errUsage)
+	}
+}
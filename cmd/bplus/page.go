@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+const (
+	// dumpWidth is the number of bytes on each line of a hex dump.
+	dumpWidth = 16
+	// maxFieldValue is the longest value of a field printed in full, past which it's cut
+	// short, since keys and values can take up most of a page.
+	maxFieldValue = 64
+)
+
+// runPage prints what a page of a file is used for, its fields, and a hex dump of it
+// annotated with where each field starts. Pages are read as they're loaded, after
+// they're decrypted, so encrypted files can't be read.
+func runPage(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("page", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return errUsage
+	}
+	id, err := strconv.ParseUint(fs.Arg(1), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid page id %q", fs.Arg(1))
+	}
+	s, err := store.NewPageStore(fs.Arg(0), store.WithReadOnly())
+	if err != nil {
+		return err
+	}
+	info, err := bplus.InspectPage(s, store.PageID(id))
+	if info != nil {
+		printPage(out, info)
+	}
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// printPage prints the fields of a page followed by its hex dump.
+func printPage(out io.Writer, info *store.PageInfo) {
+	fmt.Fprintf(out, "page %d (%s, offset 0x%X)\n\n",
+		info.ID, info.Kind, int64(info.ID)*store.PageSize)
+	width := len("field")
+	for _, f := range info.Fields {
+		width = max(width, len(f.Name))
+	}
+	fmt.Fprintf(out, "offset  size  %-*s  value\n", width, "field")
+	for _, f := range info.Fields {
+		value := f.Value
+		if len(value) > maxFieldValue {
+			value = value[:maxFieldValue] + "..."
+		}
+		fmt.Fprintf(out, "%6d  %4d  %-*s  %s\n", f.Offset, f.Size, width, f.Name, value)
+	}
+	fmt.Fprintln(out)
+	dumpPage(out, info)
+}
+
+// dumpPage prints a page in hex and ASCII like hexdump -C, with the names of the fields
+// that start on each line after it. Lines that repeat the one before them and start no
+// fields are left out, and marked with a *.
+func dumpPage(out io.Writer, info *store.PageInfo) {
+	starts := map[int][]string{}
+	for _, f := range info.Fields {
+		starts[f.Offset] = append(starts[f.Offset], f.Name)
+	}
+	skipping := false
+	for offset := 0; offset < len(info.Buf); offset += dumpWidth {
+		line := info.Buf[offset : offset+dumpWidth]
+		var names []string
+		for i := offset; i < offset+dumpWidth; i++ {
+			names = append(names, starts[i]...)
+		}
+		if offset > 0 && len(names) == 0 &&
+			bytes.Equal(line, info.Buf[offset-dumpWidth:offset]) {
+			if !skipping {
+				fmt.Fprintln(out, "*")
+			}
+			skipping = true
+			continue
+		}
+		skipping = false
+		var b strings.Builder
+		fmt.Fprintf(&b, "%08x ", offset)
+		for i, c := range line {
+			if i == dumpWidth/2 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, " %02x", c)
+		}
+		b.WriteString("  |")
+		for _, c := range line {
+			if c < ' ' || c > '~' {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('|')
+		if len(names) > 0 {
+			b.WriteString("  " + strings.Join(names, ", "))
+		}
+		fmt.Fprintln(out, b.String())
+	}
+}
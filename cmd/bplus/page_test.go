@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	stdin = strings.NewReader("put apple red\nput banana yellow\n")
+	defer func() { stdin = os.Stdin }()
+	var out bytes.Buffer
+	if err := run([]string{"shell", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := run([]string{"page", path, "0"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"page 0 (header, offset 0x0)",
+		"     0     4  magic number      0x4A414B45",
+		"00000000  45 4b 41 4a",
+		"|EKAJ............|  magic number\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+
+	// The root of a new tree is the page after the header, its copy and the free-space
+	// map, and its only leaf is the one after that.
+	out.Reset()
+	if err := run([]string{"page", path, "4"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"page 4 (data, offset 0x4000)",
+		"     1     4  records   2",
+		"     5     9  key 0     \"apple\"",
+		"|.........apple..|  flags, records, key 0, value 0\n",
+		"\n*\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+
+	for _, id := range []string{"x", "-1", "99"} {
+		if err := run([]string{"page", path, id}, &out); err == nil {
+			t.Fatalf("expected an error for page %q", id)
+		}
+	}
+	if err := run([]string{"page", path}, &out); err != errUsage {
+		t.Fatalf("expected %v == %v", err, errUsage)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// stdin is where the shell reads commands from.
+var stdin io.Reader = os.Stdin
+
+// errQuit is returned by the shell's quit command.
+var errQuit = errors.New("quit")
+
+// shellCommand is a command of the shell.
+type shellCommand struct {
+	name    string
+	args    string
+	summary string
+	run     func(s *shell, args [][]byte) error
+}
+
+// shellCommands are the commands of the shell, in the order help lists them.
+var shellCommands []shellCommand
+
+func init() {
+	shellCommands = []shellCommand{
+		{"get", "<key>", "print the value of a key", (*shell).get},
+		{"put", "<key> <value>", "set the value of a key", (*shell).put},
+		{"del", "<key>", "delete a key", (*shell).del},
+		{"scan", "[start [end]]", "print the records from start up to end", (*shell).scan},
+		{"stat", "", "print statistics about the tree and its file", (*shell).stat},
+		{"verify", "", "check the tree's invariants", (*shell).verify},
+		{"help", "", "list the commands", (*shell).help},
+		{"quit", "", "leave the shell", func(*shell, [][]byte) error { return errQuit }},
+	}
+}
+
+// shell runs commands read from a terminal or a script against a tree.
+type shell struct {
+	tree *bplus.Tree
+	out  io.Writer
+}
+
+func runShell(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	readOnly := fs.Bool("readonly", false, "open the tree read-only")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	var opts []bplus.Option
+	if *readOnly {
+		opts = append(opts, bplus.WithReadOnly())
+	}
+	tree, err := openTree(fs.Arg(0), !*readOnly, opts...)
+	if err != nil {
+		return err
+	}
+	s := &shell{tree: tree, out: out}
+	err = s.run(stdin, isTerminal(stdin))
+	if closeErr := tree.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isTerminal returns whether r is a terminal, which the shell prompts for commands.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// run runs the commands read from r, one per line, until it runs out or one quits. An
+// error running a command is printed rather than stopping the shell.
+func (s *shell) run(r io.Reader, prompt bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for {
+		if prompt {
+			fmt.Fprint(s.out, "bplus> ")
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		err := s.execute(scanner.Text())
+		if err == errQuit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(s.out, "error:", err)
+		}
+	}
+}
+
+// execute runs a line of input.
+func (s *shell) execute(line string) error {
+	fields, err := splitFields(line)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	name := string(fields[0])
+	for _, c := range shellCommands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(s, fields[1:])
+		if err == errUsage {
+			return fmt.Errorf("usage: %s %s", c.name, c.args)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown command %q, see help", name)
+}
+
+// splitFields splits a line into fields separated by spaces. A field can be written as a
+// Go string literal in double quotes, to put spaces or any other bytes in it.
+func splitFields(line string) ([][]byte, error) {
+	var fields [][]byte
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return fields, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexFunc(line, unicode.IsSpace)
+			if end < 0 {
+				end = len(line)
+			}
+			fields = append(fields, []byte(line[:end]))
+			line = line[end:]
+			continue
+		}
+		prefix, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("bad quoted field %s", line)
+		}
+		field, _ := strconv.Unquote(prefix)
+		fields = append(fields, []byte(field))
+		line = line[len(prefix):]
+	}
+}
+
+// quote writes b as a field, quoted if it has to be to read it back.
+func quote(b []byte) string {
+	if len(b) == 0 {
+		return `""`
+	}
+	s := string(b)
+	if s[0] == '"' || strings.IndexFunc(s, func(r rune) bool {
+		return r == unicode.ReplacementChar || !unicode.IsPrint(r) || unicode.IsSpace(r)
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (s *shell) get(args [][]byte) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	value, err := s.tree.Read(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, quote(value))
+	return nil
+}
+
+func (s *shell) put(args [][]byte) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	return s.tree.Upsert(args[0], args[1])
+}
+
+func (s *shell) del(args [][]byte) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return s.tree.Delete(args[0])
+}
+
+func (s *shell) scan(args [][]byte) error {
+	if len(args) > 2 {
+		return errUsage
+	}
+	var start, end []byte
+	if len(args) > 0 {
+		start = args[0]
+	}
+	if len(args) > 1 {
+		end = args[1]
+	}
+	it := s.tree.Ascend(start)
+	for k, v := range it.Records() {
+		if end != nil && string(k) >= string(end) {
+			break
+		}
+		fmt.Fprintf(s.out, "%s %s\n", quote(k), quote(v))
+	}
+	return it.Err()
+}
+
+func (s *shell) stat(args [][]byte) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	height, err := s.tree.Height()
+	if err != nil {
+		return err
+	}
+	stats, err := s.tree.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "records:       %d\n", s.tree.Len())
+	fmt.Fprintf(s.out, "height:        %d\n", height)
+	fmt.Fprintf(s.out, "lsn:           %d\n", s.tree.LSN())
+	fmt.Fprintf(s.out, "file size:     %d\n", stats.FileSize)
+	fmt.Fprintf(s.out, "pages:         %d\n", stats.Pages)
+	fmt.Fprintf(s.out, "free pages:    %d\n", stats.FreePages)
+	fmt.Fprintf(s.out, "fragmentation: %.2f\n", stats.Fragmentation)
+	return nil
+}
+
+func (s *shell) verify(args [][]byte) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	report, err := s.tree.Verify()
+	if err != nil {
+		return err
+	}
+	for _, v := range report.Violations {
+		fmt.Fprintf(s.out, "page %d: %s\n", v.PageID, v.Reason)
+	}
+	fmt.Fprintf(s.out, "%d pages, %d records, depth %d, %d violations\n",
+		report.Pages, report.Records, report.Depth, len(report.Violations))
+	return nil
+}
+
+func (s *shell) help(args [][]byte) error {
+	for _, c := range shellCommands {
+		fmt.Fprintf(s.out, "%-22s %s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
+	}
+	return nil
+}
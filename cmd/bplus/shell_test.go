@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitFields(t *testing.T) {
+	fields, err := splitFields(`  put "a key" "\x00\n" plain`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"put", "a key", "\x00\n", "plain"}
+	if len(fields) != len(expected) {
+		t.Fatalf("expected %q == %q", fields, expected)
+	}
+	for i := range fields {
+		if string(fields[i]) != expected[i] {
+			t.Fatalf("expected %q == %q", fields[i], expected[i])
+		}
+	}
+	if _, err := splitFields(`get "open`); err == nil {
+		t.Fatalf("expected an error for an unterminated quote")
+	}
+	for _, s := range expected {
+		fields, err := splitFields(quote([]byte(s)))
+		if err != nil || len(fields) != 1 || string(fields[0]) != s {
+			t.Fatalf("expected %q to round trip, got %q, %v", s, fields, err)
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	script := strings.Join([]string{
+		"put a 1",
+		`put "b c" "two words"`,
+		"put d 4",
+		"get a",
+		"get missing",
+		"del d",
+		"scan",
+		"scan b",
+		"get",
+		"bogus",
+		"verify",
+		"quit",
+		"put e 5",
+	}, "\n")
+	defer func() { stdin = os.Stdin }()
+	stdin = strings.NewReader(script)
+	var out bytes.Buffer
+	if err := run([]string{"shell", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"1",
+		"error: key not found",
+		`a 1`,
+		`"b c" "two words"`,
+		`"b c" "two words"`,
+		"error: usage: get <key>",
+		`error: unknown command "bogus", see help`,
+		"2 pages, 2 records, depth 1, 0 violations",
+	}, "\n") + "\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q == %q", out.String(), expected)
+	}
+
+	// The shell stopped at quit, and a read-only shell can't write.
+	stdin = strings.NewReader("get e\nput e 5\nscan\n")
+	out.Reset()
+	if err := run([]string{"shell", "-readonly", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "error: key not found\nerror: ") ||
+		!strings.HasSuffix(out.String(), "\na 1\n\"b c\" \"two words\"\n") {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+}
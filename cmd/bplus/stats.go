@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// namedTree is a tree of a file, which is unnamed unless the file holds a database.
+type namedTree struct {
+	name string
+	tree *bplus.Tree
+	root *bplus.TreeNode
+}
+
+// runStats prints what the pages of a file are used for and, for each tree in it, its
+// height, how full each level is, and its largest values. Every page of every tree is
+// read, along with every value for the largest of them.
+func runStats(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	top := fs.Int("top", 5, "the number of largest values to print")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || *top < 0 {
+		return errUsage
+	}
+	path := fs.Arg(0)
+	tree, err := openTree(path, false, bplus.WithReadOnly())
+	if err == nil {
+		err = printStats(out, tree.Stats, tree.Orphans, []namedTree{{tree: tree}}, *top)
+		if closeErr := tree.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	if err != bplus.ErrDB {
+		return err
+	}
+	db, err := bplus.OpenDB(path, bplus.WithReadOnly())
+	if err != nil {
+		return err
+	}
+	var trees []namedTree
+	names, err := db.TreeNames()
+	for _, name := range names {
+		var tree *bplus.Tree
+		tree, err = db.OpenTree(name)
+		if err != nil {
+			break
+		}
+		trees = append(trees, namedTree{name: name, tree: tree})
+	}
+	if err == nil {
+		err = printStats(out, db.Stats, db.Orphans, trees, *top)
+	}
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// printStats prints the statistics of a file and the trees in it.
+func printStats(
+	out io.Writer,
+	stats func() (store.Stats, error),
+	orphans func() ([]store.PageID, error),
+	trees []namedTree,
+	top int,
+) error {
+	fileStats, err := stats()
+	if err != nil {
+		return err
+	}
+	orphaned, err := orphans()
+	if err != nil {
+		return err
+	}
+	branches, leaves, pointers := 0, 0, 0
+	for i := range trees {
+		trees[i].root, err = trees[i].tree.Structure()
+		if err != nil {
+			return err
+		}
+		var walk func(n *bplus.TreeNode)
+		walk = func(n *bplus.TreeNode) {
+			if n.Leaf {
+				leaves++
+			} else {
+				branches++
+			}
+			for _, c := range n.Children {
+				walk(c)
+			}
+		}
+		walk(trees[i].root)
+		pointers += trees[i].root.ValuePointers
+	}
+	// The rest are the header, the pages of the free-space map, and a database's
+	// catalog.
+	other := fileStats.Pages - branches - leaves - fileStats.FreePages - len(orphaned)
+	fmt.Fprintf(out, "file size:     %d bytes\n", fileStats.FileSize)
+	fmt.Fprintf(out, "pages:         %d\n", fileStats.Pages)
+	fmt.Fprintf(out, "  branch:      %d\n", branches)
+	fmt.Fprintf(out, "  leaf:        %d\n", leaves)
+	fmt.Fprintf(out, "  free:        %d\n", fileStats.FreePages)
+	fmt.Fprintf(out, "  orphaned:    %d\n", len(orphaned))
+	fmt.Fprintf(out, "  other:       %d\n", other)
+	fmt.Fprintf(out, "fragmentation: %.2f\n", fileStats.Fragmentation)
+	fmt.Fprintf(out, "value log:     %d values\n", pointers)
+	for _, t := range trees {
+		fmt.Fprintln(out)
+		if t.name != "" {
+			fmt.Fprintf(out, "tree %s\n", quote([]byte(t.name)))
+		}
+		err = printTreeStats(out, t, top)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTreeStats prints the height of a tree, how full each of its levels is, and its
+// largest values.
+func printTreeStats(out io.Writer, t namedTree, top int) error {
+	levels := levels(t.root)
+	fmt.Fprintf(out, "records:       %d\n", t.root.Records)
+	fmt.Fprintf(out, "height:        %d\n", len(levels))
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "depth  pages  records  occupancy")
+	for depth, l := range levels {
+		fmt.Fprintf(out, "%5d  %5d  %7d  %8.0f%%\n", depth, l.pages, l.records, 100*l.occupancy)
+	}
+	if top == 0 || t.root.Records == 0 {
+		return nil
+	}
+	largest, err := largestValues(t.tree, top)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "largest values:")
+	for _, r := range largest {
+		fmt.Fprintf(out, "  %-*s  %d bytes\n", maxKeyText+3, shortKey(r.Key), len(r.Value))
+	}
+	return nil
+}
+
+// largestValues returns the n records of the tree with the largest values, largest
+// first, keeping the first of those with the same size.
+func largestValues(tree *bplus.Tree, n int) ([]bplus.Record, error) {
+	var largest []bplus.Record
+	it := tree.All()
+	for k, v := range it.Records() {
+		if len(largest) == n && len(v) <= len(largest[n-1].Value) {
+			continue
+		}
+		i := sort.Search(len(largest), func(i int) bool {
+			return len(largest[i].Value) < len(v)
+		})
+		// The key and value are copied, since they may be reused by the next record.
+		r := bplus.Record{Key: append(bplus.Key{}, k...), Value: append(bplus.Value{}, v...)}
+		largest = append(largest, bplus.Record{})
+		copy(largest[i+1:], largest[i:])
+		largest[i] = r
+		largest = largest[:min(len(largest), n)]
+	}
+	return largest, it.Err()
+}
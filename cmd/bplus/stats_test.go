@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	var script strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&script, "put key%03d %s\n", i, strings.Repeat("v", i%50+1))
+	}
+	stdin = strings.NewReader(script.String())
+	defer func() { stdin = os.Stdin }()
+	var out bytes.Buffer
+	if err := run([]string{"shell", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := run([]string{"stats", "-top", "2", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"  branch:      1\n",
+		"  leaf:        5\n",
+		"  other:       3\n",
+		"  orphaned:    0\n",
+		"value log:     0 values\n",
+		"records:       300\n",
+		"height:        2\n",
+		"    1      5      300",
+		"largest values:\n  key049",
+		"  50 bytes\n  key099",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "key149") {
+		t.Fatalf("expected only 2 values in:\n%s", out.String())
+	}
+
+	if err := run([]string{"stats", "-top", "-1", path}, &out); err != errUsage {
+		t.Fatalf("expected %v == %v", err, errUsage)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// maxKeyText is the longest key printed in full in a key range, past which it's cut
+// short.
+const maxKeyText = 24
+
+func runTree(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("tree", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dot := fs.Bool("dot", false, "print the tree as a Graphviz DOT graph")
+	depth := fs.Int("depth", -1, "print the pages down to this depth, or all of them")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	tree, err := openTree(fs.Arg(0), false, bplus.WithReadOnly())
+	if err != nil {
+		return err
+	}
+	root, err := tree.Structure()
+	if err == nil {
+		if *dot {
+			printDOT(out, root, *depth)
+		} else {
+			printTree(out, root, *depth)
+		}
+	}
+	if closeErr := tree.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// level sums up the pages at one depth of a tree.
+type level struct {
+	pages     int
+	records   int
+	occupancy float64
+}
+
+// levels returns a summary of each level of the tree, from the root down.
+func levels(root *bplus.TreeNode) []level {
+	var levels []level
+	var walk func(n *bplus.TreeNode)
+	walk = func(n *bplus.TreeNode) {
+		if n.Depth == len(levels) {
+			levels = append(levels, level{})
+		}
+		l := &levels[n.Depth]
+		l.pages++
+		l.records += n.Records
+		l.occupancy += n.Occupancy()
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(root)
+	for i := range levels {
+		levels[i].occupancy /= float64(levels[i].pages)
+	}
+	return levels
+}
+
+// printTree prints a summary of each level of the tree, and then every page down to
+// maxDepth, or every page if it's negative, indented by its depth.
+func printTree(out io.Writer, root *bplus.TreeNode, maxDepth int) {
+	levels := levels(root)
+	pages := 0
+	for _, l := range levels {
+		pages += l.pages
+	}
+	fmt.Fprintf(out, "%d levels, %d pages, %d records\n\n", len(levels), pages, root.Records)
+	fmt.Fprintln(out, "depth  pages  records  occupancy")
+	for depth, l := range levels {
+		fmt.Fprintf(out, "%5d  %5d  %7d  %8.0f%%\n", depth, l.pages, l.records, 100*l.occupancy)
+	}
+	fmt.Fprintln(out)
+	var walk func(n *bplus.TreeNode)
+	walk = func(n *bplus.TreeNode) {
+		if maxDepth >= 0 && n.Depth > maxDepth {
+			return
+		}
+		fmt.Fprintf(out, "%spage %d  %s  %d records  %s\n",
+			strings.Repeat("  ", n.Depth), n.ID, occupancy(n), n.Records, keyRange(n))
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(root)
+}
+
+// printDOT prints the pages of the tree down to maxDepth, or every page if it's
+// negative, as a Graphviz DOT graph, with an edge from each branch to its children.
+func printDOT(out io.Writer, root *bplus.TreeNode, maxDepth int) {
+	fmt.Fprintln(out, "digraph tree {")
+	fmt.Fprintln(out, "\tnode [shape=box, fontname=monospace];")
+	var walk func(n *bplus.TreeNode)
+	walk = func(n *bplus.TreeNode) {
+		label := fmt.Sprintf("page %d\n%s\n%d records\n%s",
+			n.ID, occupancy(n), n.Records, keyRange(n))
+		fmt.Fprintf(out, "\tp%d [label=\"%s\"];\n", n.ID, dotEscape(label))
+		if maxDepth >= 0 && n.Depth >= maxDepth {
+			return
+		}
+		for _, c := range n.Children {
+			fmt.Fprintf(out, "\tp%d -> p%d;\n", n.ID, c.ID)
+			walk(c)
+		}
+	}
+	walk(root)
+	fmt.Fprintln(out, "}")
+}
+
+// occupancy describes what a page is and how full it is, such as "leaf 3/3 100%".
+func occupancy(n *bplus.TreeNode) string {
+	kind, entries := "branch", "keys"
+	if n.Leaf {
+		kind, entries = "leaf", "records"
+	}
+	return fmt.Sprintf("%s %d/%d %s %.0f%%",
+		kind, n.Entries, n.MaxEntries, entries, 100*n.Occupancy())
+}
+
+// keyRange writes out the smallest and largest keys under a page.
+func keyRange(n *bplus.TreeNode) string {
+	if n.First == nil {
+		return "[]"
+	}
+	return fmt.Sprintf("[%s .. %s]", shortKey(n.First), shortKey(n.Last))
+}
+
+// shortKey quotes a key, cutting it short if it's long.
+func shortKey(key bplus.Key) string {
+	s := quote(key)
+	if len(s) > maxKeyText {
+		s = s[:maxKeyText] + "..."
+	}
+	return s
+}
+
+// dotEscape escapes a label for a quoted DOT string, keeping its newlines as line
+// breaks.
+func dotEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bplus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree")
+	var script strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&script, "put key%03d \"value \\\"%d\\\"\"\n", i, i)
+	}
+	stdin = strings.NewReader(script.String())
+	defer func() { stdin = os.Stdin }()
+	var out bytes.Buffer
+	if err := run([]string{"shell", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := run([]string{"tree", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"2 levels, 5 pages, 300 records\n",
+		"    1      4      300",
+		"page 3  branch 3/127 keys 3%  300 records  [key000 .. key299]\n",
+		"\n  page 4  leaf 64/127 records 50%  64 records  [key000 .. key063]\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := run([]string{"tree", "-dot", "-depth", "0", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "digraph tree {\n" +
+		"\tnode [shape=box, fontname=monospace];\n" +
+		"\tp3 [label=\"page 3\\nbranch 3/127 keys 3%\\n300 records\\n[key000 .. key299]\"];\n" +
+		"}\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q == %q", out.String(), expected)
+	}
+	if dotEscape("\"a\\b\"\n") != `\"a\\b\"\n` {
+		t.Fatalf("expected %q == %q", dotEscape("\"a\\b\"\n"), `\"a\\b\"\n`)
+	}
+
+	if err := run([]string{"tree", filepath.Join(dir, "missing")}, &out); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,249 @@
+package bolt
+
+import (
+	"encoding/binary"
+)
+
+// The tags in front of values, which say whether a record holds a value or a nested
+// bucket. A nested bucket's record holds its id and its sequence, as 8 big-endian bytes
+// each.
+const (
+	tagValue  byte = 0
+	tagBucket byte = 1
+
+	bucketValueSize = 1 + idSize + 8
+)
+
+// Bucket is a collection of records in a database, see bbolt's Bucket. Buckets are
+// valid for as long as the transaction they were got from.
+type Bucket struct {
+	tx *Tx
+	id uint64
+	// key is the key of the bucket's record in its parent, or nil for the root bucket of
+	// a transaction, which holds the top-level buckets.
+	key []byte
+}
+
+// prefix returns the prefix of the keys of the bucket with id.
+func prefix(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+// recordKey returns the key of the bucket's record for key.
+func (b *Bucket) recordKey(key []byte) []byte {
+	return append(prefix(b.id), key...)
+}
+
+// Tx returns the transaction the bucket was got from.
+func (b *Bucket) Tx() *Tx {
+	return b.tx
+}
+
+// Writable returns whether the bucket can be written to.
+func (b *Bucket) Writable() bool {
+	return b.tx.writable
+}
+
+// Bucket returns the nested bucket called name, or nil if there isn't one.
+func (b *Bucket) Bucket(name []byte) *Bucket {
+	key := b.recordKey(name)
+	value, ok := b.tx.get(key)
+	if !ok || len(value) != bucketValueSize || value[0] != tagBucket {
+		return nil
+	}
+	return &Bucket{tx: b.tx, id: binary.BigEndian.Uint64(value[1:]), key: key}
+}
+
+// CreateBucket creates a nested bucket called name. It returns ErrBucketExists if there's
+// already a bucket called name, and ErrIncompatibleValue if name is the key of a record.
+func (b *Bucket) CreateBucket(name []byte) (*Bucket, error) {
+	if err := b.tx.checkWritable(); err != nil {
+		return nil, err
+	}
+	if len(name) == 0 {
+		return nil, ErrBucketNameRequired
+	}
+	if len(name) > MaxKeySize {
+		return nil, ErrKeyTooLarge
+	}
+	key := b.recordKey(name)
+	if value, ok := b.tx.get(key); ok {
+		if value[0] == tagBucket {
+			return nil, ErrBucketExists
+		}
+		return nil, ErrIncompatibleValue
+	}
+	id := b.tx.nextID()
+	value := make([]byte, bucketValueSize)
+	value[0] = tagBucket
+	binary.BigEndian.PutUint64(value[1:], id)
+	b.tx.put(key, value)
+	return &Bucket{tx: b.tx, id: id, key: key}, nil
+}
+
+// CreateBucketIfNotExists returns the nested bucket called name, creating it if it
+// doesn't exist yet.
+func (b *Bucket) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	if err := b.tx.checkWritable(); err != nil {
+		return nil, err
+	}
+	if child := b.Bucket(name); child != nil {
+		return child, nil
+	}
+	return b.CreateBucket(name)
+}
+
+// DeleteBucket deletes the nested bucket called name, with all of its records and the
+// buckets nested in it. It returns ErrBucketNotFound if there's no bucket called name,
+// and ErrIncompatibleValue if name is the key of a record.
+func (b *Bucket) DeleteBucket(name []byte) error {
+	if err := b.tx.checkWritable(); err != nil {
+		return err
+	}
+	child := b.Bucket(name)
+	if child == nil {
+		if _, ok := b.tx.get(b.recordKey(name)); ok {
+			return ErrIncompatibleValue
+		}
+		return ErrBucketNotFound
+	}
+	child.deleteAll()
+	b.tx.delete(child.key)
+	return nil
+}
+
+// deleteAll deletes all of the bucket's records and the buckets nested in it.
+func (b *Bucket) deleteAll() {
+	start, end := prefix(b.id), prefix(b.id+1)
+	key, after := start, false
+	for {
+		k, v, ok := b.tx.seek(key, end, after)
+		if !ok {
+			return
+		}
+		if v[0] == tagBucket && len(v) == bucketValueSize {
+			child := &Bucket{tx: b.tx, id: binary.BigEndian.Uint64(v[1:])}
+			child.deleteAll()
+		}
+		b.tx.delete(k)
+		key, after = k, true
+	}
+}
+
+// Get returns the value of key, or nil if it doesn't exist or is a nested bucket. The
+// value may be modified by the caller.
+func (b *Bucket) Get(key []byte) []byte {
+	value, ok := b.tx.get(b.recordKey(key))
+	if !ok || len(value) == 0 || value[0] != tagValue {
+		return nil
+	}
+	return value[1:]
+}
+
+// Put sets the value of key. It returns ErrIncompatibleValue if key is a nested bucket.
+func (b *Bucket) Put(key, value []byte) error {
+	if err := b.tx.checkWritable(); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	k := b.recordKey(key)
+	if old, ok := b.tx.get(k); ok && old[0] == tagBucket {
+		return ErrIncompatibleValue
+	}
+	b.tx.put(k, append([]byte{tagValue}, value...))
+	return nil
+}
+
+// Delete deletes key, if it exists. It returns ErrIncompatibleValue if key is a nested
+// bucket.
+func (b *Bucket) Delete(key []byte) error {
+	if err := b.tx.checkWritable(); err != nil {
+		return err
+	}
+	k := b.recordKey(key)
+	old, ok := b.tx.get(k)
+	if !ok {
+		return nil
+	}
+	if old[0] == tagBucket {
+		return ErrIncompatibleValue
+	}
+	b.tx.delete(k)
+	return nil
+}
+
+// Cursor returns a cursor over the bucket's records.
+func (b *Bucket) Cursor() *Cursor {
+	return &Cursor{bucket: b}
+}
+
+// ForEach calls fn with every record in the bucket in order of their keys, stopping at
+// the first error it returns. The value of a nested bucket is nil. The bucket mustn't be
+// written to from fn.
+func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachBucket calls fn with the name of every nested bucket in order, stopping at the
+// first error it returns.
+func (b *Bucket) ForEachBucket(fn func(k []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			continue
+		}
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sequence returns the bucket's sequence, see NextSequence.
+func (b *Bucket) Sequence() uint64 {
+	if b.key == nil {
+		return 0
+	}
+	value, ok := b.tx.get(b.key)
+	if !ok || len(value) != bucketValueSize {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value[1+idSize:])
+}
+
+// SetSequence sets the bucket's sequence.
+func (b *Bucket) SetSequence(v uint64) error {
+	if err := b.tx.checkWritable(); err != nil {
+		return err
+	}
+	if b.key == nil {
+		return ErrIncompatibleValue
+	}
+	value := make([]byte, bucketValueSize)
+	value[0] = tagBucket
+	binary.BigEndian.PutUint64(value[1:], b.id)
+	binary.BigEndian.PutUint64(value[1+idSize:], v)
+	b.tx.put(b.key, value)
+	return nil
+}
+
+// NextSequence increments the bucket's sequence and returns it, for use as an id for the
+// bucket's records.
+func (b *Bucket) NextSequence() (uint64, error) {
+	seq := b.Sequence() + 1
+	if err := b.SetSequence(seq); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
@@ -0,0 +1,156 @@
+package bolt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	db := newDB(t)
+	err := db.Update(func(tx *Tx) error {
+		if _, err := tx.CreateBucket(nil); err != ErrBucketNameRequired {
+			t.Fatalf("expected %v == %v", err, ErrBucketNameRequired)
+		}
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket([]byte("widgets")); err != ErrBucketExists {
+			t.Fatalf("expected %v == %v", err, ErrBucketExists)
+		}
+		same, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+		if err != nil || same.id != b.id {
+			t.Fatalf("expected %v == %v, got %v", same, b, err)
+		}
+		if err := b.Put(nil, []byte("v")); err != ErrKeyRequired {
+			t.Fatalf("expected %v == %v", err, ErrKeyRequired)
+		}
+		long := bytes.Repeat([]byte("k"), MaxKeySize+1)
+		if err := b.Put(long, []byte("v")); err != ErrKeyTooLarge {
+			t.Fatalf("expected %v == %v", err, ErrKeyTooLarge)
+		}
+		b.Put([]byte("foo"), []byte("bar"))
+		b.Put([]byte("empty"), nil)
+		if value := b.Get([]byte("empty")); value == nil || len(value) != 0 {
+			t.Fatalf("expected an empty value, got %q", value)
+		}
+		if _, err := b.CreateBucket([]byte("foo")); err != ErrIncompatibleValue {
+			t.Fatalf("expected %v == %v", err, ErrIncompatibleValue)
+		}
+		if err := b.DeleteBucket([]byte("foo")); err != ErrIncompatibleValue {
+			t.Fatalf("expected %v == %v", err, ErrIncompatibleValue)
+		}
+		if err := b.DeleteBucket([]byte("nope")); err != ErrBucketNotFound {
+			t.Fatalf("expected %v == %v", err, ErrBucketNotFound)
+		}
+		if err := b.Delete([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if value := b.Get([]byte("foo")); value != nil {
+			t.Fatalf("expected %q == nil", value)
+		}
+		return b.Delete([]byte("missing"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNestedBuckets(t *testing.T) {
+	db := newDB(t)
+	err := db.Update(func(tx *Tx) error {
+		parent, err := tx.CreateBucket([]byte("parent"))
+		if err != nil {
+			return err
+		}
+		child, err := parent.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		grandchild, err := child.CreateBucket([]byte("grandchild"))
+		if err != nil {
+			return err
+		}
+		child.Put([]byte("k"), []byte("v"))
+		grandchild.Put([]byte("k"), []byte("v"))
+		parent.Put([]byte("a"), []byte("1"))
+		if value := parent.Get([]byte("child")); value != nil {
+			t.Fatalf("expected %q == nil", value)
+		}
+		if err := parent.Put([]byte("child"), nil); err != ErrIncompatibleValue {
+			t.Fatalf("expected %v == %v", err, ErrIncompatibleValue)
+		}
+		if err := parent.Delete([]byte("child")); err != ErrIncompatibleValue {
+			t.Fatalf("expected %v == %v", err, ErrIncompatibleValue)
+		}
+		var records []string
+		parent.ForEach(func(k, v []byte) error {
+			records = append(records, string(k)+"="+string(v))
+			return nil
+		})
+		if s := strings.Join(records, ","); s != "a=1,child=" {
+			t.Fatalf("expected %q == %q", s, "a=1,child=")
+		}
+		var buckets []string
+		parent.ForEachBucket(func(k []byte) error {
+			buckets = append(buckets, string(k))
+			return nil
+		})
+		if s := strings.Join(buckets, ","); s != "child" {
+			t.Fatalf("expected %q == %q", s, "child")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		parent := tx.Bucket([]byte("parent"))
+		if parent.Bucket([]byte("child")).Bucket([]byte("grandchild")) == nil {
+			t.Fatalf("expected the nested buckets to be committed")
+		}
+		return parent.DeleteBucket([]byte("child"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the parent, its record and the next bucket id are left.
+	if n := db.Tree().Len(); n != 3 {
+		t.Fatalf("expected %v == %v", n, 3)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	db := newDB(t)
+	err := db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := uint64(1); i <= 3; i++ {
+			seq, err := b.NextSequence()
+			if err != nil || seq != i {
+				t.Fatalf("expected %v == %v, got %v", seq, i, err)
+			}
+		}
+		return b.SetSequence(10)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(func(tx *Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if seq := b.Sequence(); seq != 10 {
+			t.Fatalf("expected %v == %v", seq, 10)
+		}
+		if _, err := b.NextSequence(); err != ErrTxNotWritable {
+			t.Fatalf("expected %v == %v", err, ErrTxNotWritable)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+package bolt
+
+// Cursor moves over the records of a bucket in order of their keys, see bbolt's Cursor.
+// It sees the writes made in its transaction, even after it was created. The value of a
+// nested bucket is nil, and the key and value returned are nil once the cursor moves
+// past either end of the bucket.
+type Cursor struct {
+	bucket *Bucket
+	// key is the record key the cursor is at, or nil if it hasn't been moved yet.
+	key []byte
+}
+
+// Bucket returns the bucket the cursor moves over.
+func (c *Cursor) Bucket() *Bucket {
+	return c.bucket
+}
+
+// First moves the cursor to the bucket's first record.
+func (c *Cursor) First() ([]byte, []byte) {
+	id := c.bucket.id
+	return c.move(c.bucket.tx.seek(prefix(id), prefix(id+1), false))
+}
+
+// Last moves the cursor to the bucket's last record.
+func (c *Cursor) Last() ([]byte, []byte) {
+	id := c.bucket.id
+	return c.move(c.bucket.tx.seekBack(prefix(id+1), prefix(id), true))
+}
+
+// Next moves the cursor to the next record.
+func (c *Cursor) Next() ([]byte, []byte) {
+	if c.key == nil {
+		return c.First()
+	}
+	return c.move(c.bucket.tx.seek(c.key, prefix(c.bucket.id+1), true))
+}
+
+// Prev moves the cursor to the previous record.
+func (c *Cursor) Prev() ([]byte, []byte) {
+	if c.key == nil {
+		return c.Last()
+	}
+	return c.move(c.bucket.tx.seekBack(c.key, prefix(c.bucket.id), true))
+}
+
+// Seek moves the cursor to the first record with a key from seek on.
+func (c *Cursor) Seek(seek []byte) ([]byte, []byte) {
+	id := c.bucket.id
+	return c.move(c.bucket.tx.seek(c.bucket.recordKey(seek), prefix(id+1), false))
+}
+
+// Delete deletes the record the cursor is at. It returns ErrIncompatibleValue if it's a
+// nested bucket.
+func (c *Cursor) Delete() error {
+	if err := c.bucket.tx.checkWritable(); err != nil {
+		return err
+	}
+	if c.key == nil {
+		return nil
+	}
+	value, ok := c.bucket.tx.get(c.key)
+	if !ok {
+		return nil
+	}
+	if value[0] == tagBucket {
+		return ErrIncompatibleValue
+	}
+	c.bucket.tx.delete(c.key)
+	return nil
+}
+
+// move moves the cursor to a record found by a seek, leaving it where it is if none was
+// found, and returns the record's key and value.
+func (c *Cursor) move(key, value []byte, ok bool) ([]byte, []byte) {
+	if !ok {
+		return nil, nil
+	}
+	c.key = key
+	if value[0] == tagBucket {
+		return key[idSize:], nil
+	}
+	return key[idSize:], value[1:]
+}
@@ -0,0 +1,78 @@
+package bolt
+
+import (
+	"strings"
+	"testing"
+)
+
+// walk returns the keys a cursor moves over from start with move, joined by commas.
+func walk(start func() ([]byte, []byte), move func() ([]byte, []byte)) string {
+	var keys []string
+	for k, _ := start(); k != nil; k, _ = move() {
+		keys = append(keys, string(k))
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestCursor(t *testing.T) {
+	db := newDB(t)
+	err := db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		// Records in neighbouring buckets mustn't be seen.
+		before, _ := tx.CreateBucket([]byte("before"))
+		before.Put([]byte("z"), nil)
+		for _, k := range []string{"b", "d", "f", "h"} {
+			b.Put([]byte(k), []byte(k))
+		}
+		after, _ := tx.CreateBucket([]byte("after"))
+		after.Put([]byte("a"), nil)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		// The cursor sees the transaction's writes merged with the committed records.
+		b.Put([]byte("a"), []byte("a"))
+		b.Put([]byte("e"), []byte("e"))
+		b.Delete([]byte("f"))
+		b.Put([]byte("d"), []byte("D"))
+		c := b.Cursor()
+		if keys := walk(c.First, c.Next); keys != "a,b,d,e,h" {
+			t.Fatalf("expected %q == %q", keys, "a,b,d,e,h")
+		}
+		if keys := walk(c.Last, c.Prev); keys != "h,e,d,b,a" {
+			t.Fatalf("expected %q == %q", keys, "h,e,d,b,a")
+		}
+		k, v := c.Seek([]byte("c"))
+		if string(k) != "d" || string(v) != "D" {
+			t.Fatalf("expected %q == %q and %q == %q", k, "d", v, "D")
+		}
+		if k, _ := c.Seek([]byte("i")); k != nil {
+			t.Fatalf("expected %q == nil", k)
+		}
+		c.Seek([]byte("d"))
+		if err := c.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if k, _ := c.Next(); string(k) != "e" {
+			t.Fatalf("expected %q == %q", k, "e")
+		}
+		if keys := walk(c.First, c.Next); keys != "a,b,e,h" {
+			t.Fatalf("expected %q == %q", keys, "a,b,e,h")
+		}
+		c = tx.Cursor()
+		if keys := walk(c.First, c.Next); keys != "after,before,widgets" {
+			t.Fatalf("expected %q == %q", keys, "after,before,widgets")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,211 @@
+// Package bolt mirrors the DB, Tx, Bucket and Cursor API of bbolt (go.etcd.io/bbolt) on
+// top of a B+ tree from package bplus, so that code written against bbolt can be moved
+// over by changing the import path, and the two can be compared directly.
+//
+// Every bucket, however deeply nested, is kept in the one tree, with the key of each of
+// its records prefixed by the bucket's id, as 8 big-endian bytes, which keeps the records
+// of a bucket together and in order. Values are prefixed by a tag that says whether
+// they're a record's value or a nested bucket, see Bucket.
+//
+// Unlike bbolt, transactions are isolated from each other by a lock rather than by
+// keeping old versions of pages around, so a writable transaction waits for the
+// read-only transactions open at the time to finish, and holds off new ones until it's
+// done. A goroutine mustn't begin a writable transaction while it has another
+// transaction open, which would wait for itself forever.
+package bolt
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// MaxKeySize is the largest key in bytes that can be stored in a bucket, which is the
+// tree's largest key less the bucket id in front of it.
+const MaxKeySize = bplus.MaxKeySize - idSize
+
+// The errors returned by bbolt, with the same text.
+var (
+	// ErrDatabaseNotOpen is returned when using a database that has been closed.
+	ErrDatabaseNotOpen = errors.New("database not open")
+	// ErrDatabaseReadOnly is returned when beginning a writable transaction on a
+	// database opened read-only.
+	ErrDatabaseReadOnly = errors.New("database is in read-only mode")
+	// ErrTxNotWritable is returned when writing in a read-only transaction.
+	ErrTxNotWritable = errors.New("tx not writable")
+	// ErrTxClosed is returned when committing or rolling back a transaction that has
+	// already been committed or rolled back.
+	ErrTxClosed = errors.New("tx closed")
+	// ErrBucketNotFound is returned when deleting a bucket that doesn't exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketExists is returned when creating a bucket that already exists.
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrBucketNameRequired is returned when creating a bucket with an empty name.
+	ErrBucketNameRequired = errors.New("bucket name required")
+	// ErrKeyRequired is returned when putting an empty key.
+	ErrKeyRequired = errors.New("key required")
+	// ErrKeyTooLarge is returned when putting a key larger than MaxKeySize.
+	ErrKeyTooLarge = errors.New("key too large")
+	// ErrIncompatibleValue is returned when putting or deleting a key that's a bucket, or
+	// creating or deleting a bucket whose name is the key of a record.
+	ErrIncompatibleValue = errors.New("incompatible value")
+)
+
+// Options configures how a database is opened, see Open.
+type Options struct {
+	// Timeout is how long to wait for another process to let go of the file before
+	// giving up. Zero waits forever.
+	Timeout time.Duration
+	// ReadOnly opens the database for reading only.
+	ReadOnly bool
+	// TreeOptions are passed on to the tree the database is kept in, see bplus.Option.
+	TreeOptions []bplus.Option
+}
+
+// DB is a database of buckets kept in a tree, see bbolt's DB.
+type DB struct {
+	tree     *bplus.Tree
+	path     string
+	readOnly bool
+
+	// txLock is held for reading by read-only transactions and for writing by writable
+	// ones while they're open.
+	txLock sync.RWMutex
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Open opens the database in the file at path, creating it with mode if it doesn't exist
+// yet. Options can be nil.
+func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	opts := append([]bplus.Option(nil), options.TreeOptions...)
+	if options.Timeout > 0 {
+		opts = append(opts, bplus.WithLockTimeout(options.Timeout))
+	}
+	if options.ReadOnly {
+		opts = append(opts, bplus.WithReadOnly())
+	}
+	var size int64
+	if options.ReadOnly {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		size = info.Size()
+	} else {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		size = info.Size()
+	}
+	open := bplus.OpenTree
+	if size == 0 {
+		open = bplus.NewTree
+	}
+	tree, err := open(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{tree: tree, path: path, readOnly: options.ReadOnly}, nil
+}
+
+// Path returns the path of the database's file.
+func (db *DB) Path() string {
+	return db.path
+}
+
+// IsReadOnly returns whether the database was opened read-only.
+func (db *DB) IsReadOnly() bool {
+	return db.readOnly
+}
+
+// Tree returns the tree the database is kept in.
+func (db *DB) Tree() *bplus.Tree {
+	return db.tree
+}
+
+// Close waits for the open transactions to finish, and closes the database.
+func (db *DB) Close() error {
+	db.txLock.Lock()
+	defer db.txLock.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.tree.Close()
+}
+
+// Begin starts a transaction, which must be committed or rolled back to let other
+// transactions go ahead, see the package documentation. Use View or Update where
+// possible, which take care of that.
+func (db *DB) Begin(writable bool) (*Tx, error) {
+	if writable && db.readOnly {
+		return nil, ErrDatabaseReadOnly
+	}
+	if writable {
+		db.txLock.Lock()
+	} else {
+		db.txLock.RLock()
+	}
+	db.mu.Lock()
+	closed := db.closed
+	db.mu.Unlock()
+	if closed {
+		db.unlock(writable)
+		return nil, ErrDatabaseNotOpen
+	}
+	return newTx(db, writable), nil
+}
+
+func (db *DB) unlock(writable bool) {
+	if writable {
+		db.txLock.Unlock()
+	} else {
+		db.txLock.RUnlock()
+	}
+}
+
+// Update runs fn in a writable transaction, which is committed if fn returns nil and
+// rolled back if it returns an error or panics.
+func (db *DB) Update(fn func(*Tx) error) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	return tx.run(fn)
+}
+
+// View runs fn in a read-only transaction.
+func (db *DB) View(fn func(*Tx) error) error {
+	tx, err := db.Begin(false)
+	if err != nil {
+		return err
+	}
+	return tx.run(fn)
+}
+
+// Batch runs fn in a writable transaction, like Update. It's there for code written for
+// bbolt, where it groups concurrent calls into one transaction.
+func (db *DB) Batch(fn func(*Tx) error) error {
+	return db.Update(fn)
+}
+
+// Sync syncs the database's file to disk.
+func (db *DB) Sync() error {
+	return db.tree.Sync()
+}
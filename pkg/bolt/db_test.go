@@ -0,0 +1,112 @@
+package bolt
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func tempPath(t *testing.T) string {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	return tmpfile.Name()
+}
+
+func openDB(t *testing.T, path string, options *Options) *DB {
+	t.Helper()
+	db, err := Open(path, 0600, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newDB(t *testing.T) *DB {
+	t.Helper()
+	options := &Options{TreeOptions: []bplus.Option{bplus.WithBranchingFactor(4)}}
+	return openDB(t, tempPath(t), options)
+}
+
+func TestOpenReopen(t *testing.T) {
+	path := tempPath(t)
+	os.Remove(path)
+	db := openDB(t, path, nil)
+	err := db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Begin(false); err != ErrDatabaseNotOpen {
+		t.Fatalf("expected %v == %v", err, ErrDatabaseNotOpen)
+	}
+
+	db = openDB(t, path, &Options{ReadOnly: true})
+	if !db.IsReadOnly() || db.Path() != path {
+		t.Fatalf("expected a read-only database at %s", path)
+	}
+	err = db.View(func(tx *Tx) error {
+		value := tx.Bucket([]byte("widgets")).Get([]byte("foo"))
+		if string(value) != "bar" {
+			t.Fatalf("expected %q == %q", value, "bar")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(*Tx) error { return nil }); err != ErrDatabaseReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrDatabaseReadOnly)
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	db := newDB(t)
+	errStop := errors.New("stop")
+	err := db.Update(func(tx *Tx) error {
+		tx.CreateBucket([]byte("widgets"))
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("expected %v == %v", err, errStop)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected the panic to be passed on")
+			}
+		}()
+		db.Update(func(tx *Tx) error {
+			tx.CreateBucket([]byte("widgets"))
+			panic("stop")
+		})
+	}()
+	err = db.View(func(tx *Tx) error {
+		if tx.Bucket([]byte("widgets")) != nil {
+			t.Fatalf("expected the bucket to be rolled back")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Tree().Len() != 0 {
+		t.Fatalf("expected %v == %v", db.Tree().Len(), 0)
+	}
+}
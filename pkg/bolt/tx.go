@@ -0,0 +1,296 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// idSize is the size of the bucket id in front of every key.
+const idSize = 8
+
+// nextIDKey holds the id the next bucket created is given. It's under the largest id,
+// which is never given to a bucket.
+var nextIDKey = bplus.Key{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// write is a put or delete of a key made in a transaction.
+type write struct {
+	value   []byte
+	deleted bool
+}
+
+// Tx is a transaction on a database, see bbolt's Tx. The writes made in a writable
+// transaction are held in memory, where they're seen by the transaction's own reads,
+// until Commit applies them to the tree all together.
+type Tx struct {
+	db       *DB
+	writable bool
+	// managed is set for the transactions of View and Update, which commit and roll them
+	// back themselves.
+	managed bool
+	done    bool
+	root    *Bucket
+	// writes holds the latest put or delete of every key written in the transaction, and
+	// keys holds their keys in order, or is nil until it's needed once they've changed.
+	writes         map[string]write
+	keys           []string
+	commitHandlers []func()
+}
+
+func newTx(db *DB, writable bool) *Tx {
+	tx := &Tx{db: db, writable: writable, writes: map[string]write{}}
+	tx.root = &Bucket{tx: tx}
+	return tx
+}
+
+// run runs fn in the transaction, which is committed if it's writable and fn returns nil,
+// and rolled back otherwise.
+func (tx *Tx) run(fn func(*Tx) error) error {
+	tx.managed = true
+	defer func() {
+		// fn panicked.
+		if !tx.done {
+			tx.managed = false
+			tx.Rollback()
+		}
+	}()
+	err := fn(tx)
+	tx.managed = false
+	if err != nil || !tx.writable {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DB returns the database the transaction is on.
+func (tx *Tx) DB() *DB {
+	return tx.db
+}
+
+// Writable returns whether the transaction can write to the database.
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Bucket returns the top-level bucket called name, or nil if there isn't one.
+func (tx *Tx) Bucket(name []byte) *Bucket {
+	return tx.root.Bucket(name)
+}
+
+// CreateBucket creates a top-level bucket called name, see Bucket.CreateBucket.
+func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
+	return tx.root.CreateBucket(name)
+}
+
+// CreateBucketIfNotExists returns the top-level bucket called name, creating it if it
+// doesn't exist yet.
+func (tx *Tx) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	return tx.root.CreateBucketIfNotExists(name)
+}
+
+// DeleteBucket deletes the top-level bucket called name, see Bucket.DeleteBucket.
+func (tx *Tx) DeleteBucket(name []byte) error {
+	return tx.root.DeleteBucket(name)
+}
+
+// ForEach calls fn with every top-level bucket in order of their names, stopping at the
+// first error it returns.
+func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
+	return tx.root.ForEachBucket(func(name []byte) error {
+		return fn(name, tx.root.Bucket(name))
+	})
+}
+
+// Cursor returns a cursor over the names of the top-level buckets, whose values are nil.
+func (tx *Tx) Cursor() *Cursor {
+	return tx.root.Cursor()
+}
+
+// OnCommit adds a function that's called once the transaction has been committed.
+func (tx *Tx) OnCommit(fn func()) {
+	tx.commitHandlers = append(tx.commitHandlers, fn)
+}
+
+// Commit applies the transaction's writes to the tree in a single batch, see
+// bplus.Tree.Apply, and ends the transaction, or rolls it back if they can't be applied.
+// A read-only transaction can't be committed, and has to be rolled back instead.
+func (tx *Tx) Commit() error {
+	if tx.managed {
+		panic("managed tx commit not allowed")
+	}
+	if tx.done {
+		return ErrTxClosed
+	}
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	var b bplus.WriteBatch
+	for key, w := range tx.writes {
+		if w.deleted {
+			b.Delete(bplus.Key(key))
+		} else {
+			b.Put(bplus.Key(key), w.value)
+		}
+	}
+	err := tx.db.tree.Apply(&b)
+	tx.end()
+	if err != nil {
+		return err
+	}
+	for _, fn := range tx.commitHandlers {
+		fn()
+	}
+	return nil
+}
+
+// Rollback throws away the transaction's writes and ends it.
+func (tx *Tx) Rollback() error {
+	if tx.managed {
+		panic("managed tx rollback not allowed")
+	}
+	if tx.done {
+		return ErrTxClosed
+	}
+	tx.end()
+	return nil
+}
+
+func (tx *Tx) end() {
+	tx.done = true
+	tx.writes = nil
+	tx.keys = nil
+	tx.db.unlock(tx.writable)
+}
+
+// checkWritable returns the error for writing in the transaction, if there is one.
+func (tx *Tx) checkWritable() error {
+	if tx.done {
+		return ErrTxClosed
+	}
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	return nil
+}
+
+// get returns the value of key as seen by the transaction, or false if it's not found.
+func (tx *Tx) get(key []byte) ([]byte, bool) {
+	if tx.done {
+		return nil, false
+	}
+	if w, ok := tx.writes[string(key)]; ok {
+		return w.value, !w.deleted
+	}
+	value, err := tx.db.tree.Read(key)
+	return value, err == nil
+}
+
+func (tx *Tx) put(key, value []byte) {
+	tx.writes[string(key)] = write{value: value}
+	tx.keys = nil
+}
+
+func (tx *Tx) delete(key []byte) {
+	tx.writes[string(key)] = write{deleted: true}
+	tx.keys = nil
+}
+
+// sortedKeys returns the keys written in the transaction in order.
+func (tx *Tx) sortedKeys() []string {
+	if tx.keys == nil {
+		tx.keys = make([]string, 0, len(tx.writes))
+		for key := range tx.writes {
+			tx.keys = append(tx.keys, key)
+		}
+		sort.Strings(tx.keys)
+	}
+	return tx.keys
+}
+
+// seek returns the first record the transaction sees with a key from key, or after key
+// if after is set, up to end, or false if there isn't one.
+func (tx *Tx) seek(key, end []byte, after bool) ([]byte, []byte, bool) {
+	if tx.done {
+		return nil, nil, false
+	}
+	var found, value []byte
+	for k, v := range tx.db.tree.Ascend(key).Records() {
+		if bytes.Compare(k, end) >= 0 {
+			break
+		}
+		if after && bytes.Equal(k, key) {
+			continue
+		}
+		// The transaction's own writes replace the tree's records.
+		if _, ok := tx.writes[string(k)]; ok {
+			continue
+		}
+		found, value = append([]byte{}, k...), append([]byte{}, v...)
+		break
+	}
+	keys := tx.sortedKeys()
+	for i := sort.SearchStrings(keys, string(key)); i < len(keys); i++ {
+		k := keys[i]
+		if k >= string(end) || found != nil && k >= string(found) {
+			break
+		}
+		if after && k == string(key) || tx.writes[k].deleted {
+			continue
+		}
+		found, value = []byte(k), tx.writes[k].value
+		break
+	}
+	return found, value, found != nil
+}
+
+// seekBack returns the last record the transaction sees with a key from start up to key,
+// or up to but not including key if before is set, or false if there isn't one.
+func (tx *Tx) seekBack(key, start []byte, before bool) ([]byte, []byte, bool) {
+	if tx.done {
+		return nil, nil, false
+	}
+	var found, value []byte
+	for k, v := range tx.db.tree.Descend(key).Records() {
+		if bytes.Compare(k, start) < 0 {
+			break
+		}
+		if before && bytes.Equal(k, key) || bytes.Compare(k, key) > 0 {
+			continue
+		}
+		if _, ok := tx.writes[string(k)]; ok {
+			continue
+		}
+		found, value = append([]byte{}, k...), append([]byte{}, v...)
+		break
+	}
+	keys := tx.sortedKeys()
+	i := sort.SearchStrings(keys, string(key))
+	if i == len(keys) || before || keys[i] != string(key) {
+		i--
+	}
+	for ; i >= 0; i-- {
+		k := keys[i]
+		if k < string(start) || found != nil && k <= string(found) {
+			break
+		}
+		if tx.writes[k].deleted {
+			continue
+		}
+		found, value = []byte(k), tx.writes[k].value
+		break
+	}
+	return found, value, found != nil
+}
+
+// nextID returns the id for a new bucket.
+func (tx *Tx) nextID() uint64 {
+	id := uint64(1)
+	if value, ok := tx.get(nextIDKey); ok && len(value) == idSize {
+		id = binary.BigEndian.Uint64(value)
+	}
+	tx.put(nextIDKey, binary.BigEndian.AppendUint64(nil, id+1))
+	return id
+}
@@ -0,0 +1,87 @@
+package bolt
+
+import (
+	"testing"
+)
+
+func TestTxCommit(t *testing.T) {
+	db := newDB(t)
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := tx.CreateBucket([]byte("widgets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put([]byte("foo"), []byte("bar"))
+	committed := false
+	tx.OnCommit(func() { committed = true })
+	if err := tx.Commit(); err != nil || !committed {
+		t.Fatalf("expected a commit, got %v", err)
+	}
+	if err := tx.Commit(); err != ErrTxClosed {
+		t.Fatalf("expected %v == %v", err, ErrTxClosed)
+	}
+	if err := tx.Rollback(); err != ErrTxClosed {
+		t.Fatalf("expected %v == %v", err, ErrTxClosed)
+	}
+
+	tx, err = db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = tx.Bucket([]byte("widgets"))
+	if value := b.Get([]byte("foo")); string(value) != "bar" {
+		t.Fatalf("expected %q == %q", value, "bar")
+	}
+	if err := b.Put([]byte("foo"), nil); err != ErrTxNotWritable {
+		t.Fatalf("expected %v == %v", err, ErrTxNotWritable)
+	}
+	if err := tx.Commit(); err != ErrTxNotWritable {
+		t.Fatalf("expected %v == %v", err, ErrTxNotWritable)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxManaged(t *testing.T) {
+	db := newDB(t)
+	for _, fn := range []func(*Tx) error{(*Tx).Commit, (*Tx).Rollback} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected a panic")
+				}
+			}()
+			db.Update(fn)
+		}()
+	}
+}
+
+func TestTxForEach(t *testing.T) {
+	db := newDB(t)
+	err := db.Update(func(tx *Tx) error {
+		for _, name := range []string{"b", "a", "c"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		var names string
+		err := tx.ForEach(func(name []byte, b *Bucket) error {
+			if b == nil {
+				t.Fatalf("expected a bucket for %s", name)
+			}
+			names += string(name)
+			return nil
+		})
+		if names != "abc" {
+			t.Fatalf("expected %q == %q", names, "abc")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
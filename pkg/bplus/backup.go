@@ -0,0 +1,153 @@
+package bplus
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// ErrBackupValueLog is returned when backing up a tree with a value log, whose values are
+// kept in files a backup of the tree's file doesn't cover.
+var ErrBackupValueLog = errors.New("trees with a value log can't be backed up")
+
+// Backup writes a copy of the tree's file as of its last change to w, page by page, which
+// RestoreFrom turns back into a file. See store.Backup for what a backup holds.
+//
+// In copy-on-write mode, see EnableCOW, the tree carries on being read and written while
+// the copy is written, and the changes made meanwhile aren't part of it. Otherwise the
+// pages are copied from the file as they are, so the tree can be read but not changed
+// until Backup returns. The copy is of the whole file, so for a tree in a database it
+// holds every other tree too, which are only held off being changed in copy-on-write
+// mode.
+func (tree *Tree) Backup(w io.Writer) (err error) {
+	defer tree.startSpan("bplus.Backup").end(&err)
+	return tree.backup(func(backup *store.Backup) error {
+		_, err := backup.WriteTo(w)
+		return err
+	})
+}
+
+// BackupIncremental writes the pages of the tree's file that have changed since base, a
+// backup of it taken earlier with Backup, to w, which RestoreIncremental applies on top
+// of base. See store.Backup.WriteIncrementalTo for what an incremental backup holds. The
+// tree can be used while it's taken as it can with Backup.
+func (tree *Tree) BackupIncremental(w io.Writer, base io.Reader) (err error) {
+	defer tree.startSpan("bplus.BackupIncremental").end(&err)
+	return tree.backup(func(backup *store.Backup) error {
+		_, err := backup.WriteIncrementalTo(w, base)
+		return err
+	})
+}
+
+// backup starts a backup of the tree's file and has write write it out.
+func (tree *Tree) backup(write func(backup *store.Backup) error) (err error) {
+	if tree.values != nil {
+		return ErrBackupValueLog
+	}
+	tree.timedRLock()
+	locked := true
+	defer func() {
+		if locked {
+			tree.lock.RUnlock()
+		}
+	}()
+	backup, err := tree.store.StartBackup()
+	if err != nil {
+		return err
+	}
+	if tree.store.COW() {
+		// The backup keeps the pages it reads from being reused, so writers can carry on.
+		tree.lock.RUnlock()
+		locked = false
+	}
+	err = write(backup)
+	closeErr := backup.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RestoreFrom rebuilds the file a backup was taken of with Tree.Backup in filename, which
+// mustn't exist yet. The tree can be opened from it with OpenTree, or OpenDB for a
+// database, with the same keys as the file the backup was taken of. If the
+// backup can't be restored, such as when it's corrupt, the file is removed again.
+func RestoreFrom(r io.Reader, filename string) error {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	err = store.Restore(r, file)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return err
+}
+
+// RestoreIncremental rebuilds the file an incremental backup was taken of with
+// Tree.BackupIncremental in filename, which mustn't exist yet, by restoring its base and
+// applying it on top, see RestoreFrom. store.ErrWrongBase is returned if it wasn't taken
+// against base, or store.ErrWrongStore if base is of another file altogether. If the
+// backup can't be restored, the file is removed again.
+func RestoreIncremental(base, incremental io.Reader, filename string) error {
+	err := RestoreFrom(base, filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR, 0660)
+	if err == nil {
+		err = store.ApplyIncremental(incremental, file)
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return err
+}
+
+// RecoverTo restores a backup taken with Tree.Backup into filename, which mustn't exist
+// yet, then brings it forward to the target by replaying the changes archived from the
+// tree's write-ahead log since, see WithWALArchive and store.RecoverTo. It returns the
+// log sequence number the file was recovered to. If the file can't be recovered, such as
+// when the archive has a gap, it's removed again.
+func RecoverTo(
+	backup io.Reader,
+	segments []store.WALSegment,
+	filename string,
+	target store.RecoveryTarget,
+) (uint64, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return 0, err
+	}
+	lsn, err := store.RecoverTo(backup, segments, file, target)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return lsn, err
+}
+
+// WALSegments returns the completed segments of the tree's write-ahead log, oldest first,
+// see WithWALSegments. Their groups can be replayed on top of a backup with RecoverTo.
+func (tree *Tree) WALSegments() []store.WALSegmentFile {
+	return tree.store.WALSegments()
+}
+
+// LSN returns the log sequence number of the last change committed to the tree's
+// write-ahead log, which RecoverTo can be asked to recover up to, see store.WALSegment.
+func (tree *Tree) LSN() uint64 {
+	return tree.store.LSN()
+}
@@ -0,0 +1,372 @@
+package bplus
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// writerFunc calls a function before each write to a buffer.
+type writerFunc struct {
+	buf    bytes.Buffer
+	before func()
+}
+
+func (w *writerFunc) Write(p []byte) (int, error) {
+	if w.before != nil {
+		w.before()
+	}
+	return w.buf.Write(p)
+}
+
+func TestBackupWhileWriting(t *testing.T) {
+	filename := tempFilename(t, "backup_while_writing")
+	defer os.Remove(filename)
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rewrite the tree part way through writing the backup, which mustn't change it.
+	w := &writerFunc{}
+	writes := 0
+	w.before = func() {
+		writes++
+		if writes != 10 {
+			return
+		}
+		for i := 0; i < 100; i += 2 {
+			if err := tree.Delete(intKey(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 100; i < 200; i++ {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tree.Backup(w); err != nil {
+		t.Fatal(err)
+	}
+	if writes < 10 {
+		t.Fatalf("expected the tree to be rewritten during the backup")
+	}
+	verifyTree(t, tree)
+
+	restoredFilename := filename + ".restored"
+	defer os.Remove(restoredFilename)
+	if err := RestoreFrom(&w.buf, restoredFilename); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenTree(restoredFilename, WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	verifyTree(t, restored)
+	if restored.Len() != 100 {
+		t.Fatalf("expected %v == %v", restored.Len(), 100)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := restored.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+}
+
+func TestBackupWAL(t *testing.T) {
+	filename := tempFilename(t, "backup_wal")
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".wal")
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := tree.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restoredFilename := filename + ".restored"
+	defer os.Remove(restoredFilename)
+	if err := RestoreFrom(&buf, restoredFilename); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenTree(restoredFilename, WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	// The copy has nothing to recover, since every change was in the file.
+	if restored.Recovery().Unclean {
+		t.Fatalf("expected the restored file not to need recovery")
+	}
+	verifyTree(t, restored)
+	if restored.Len() != 50 {
+		t.Fatalf("expected %v == %v", restored.Len(), 50)
+	}
+}
+
+func TestBackupValueLog(t *testing.T) {
+	filename := tempFilename(t, "backup_value_log")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(16, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Backup(&bytes.Buffer{}); err != ErrBackupValueLog {
+		t.Fatalf("expected %v == %v", err, ErrBackupValueLog)
+	}
+}
+
+func TestRestoreFromCorrupt(t *testing.T) {
+	tree, err := NewMemoryTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	var buf bytes.Buffer
+	if err := tree.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	filename := tempFilename(t, "restore_from_corrupt")
+	os.Remove(filename)
+	cut := bytes.NewReader(buf.Bytes()[:buf.Len()/2])
+	if err := RestoreFrom(cut, filename); err != store.ErrBackupCorrupt {
+		t.Fatalf("expected %v == %v", err, store.ErrBackupCorrupt)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed, got %v", err)
+	}
+}
+
+func TestRecoverTo(t *testing.T) {
+	filename := tempFilename(t, "recover_to")
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".wal")
+	dir := filename + ".archive"
+	defer os.RemoveAll(dir)
+	archive, err := store.NewDirArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithCacheSize(30),
+		WithWALArchive(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := tree.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var target uint64
+	for i := 50; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 74 {
+			target = tree.LSN()
+		}
+	}
+	segments, err := archive.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recoveredFilename := filename + ".recovered"
+	defer os.Remove(recoveredFilename)
+	lsn, err := RecoverTo(bytes.NewReader(buf.Bytes()), segments, recoveredFilename,
+		store.RecoveryTarget{LSN: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lsn != target {
+		t.Fatalf("expected %v == %v", lsn, target)
+	}
+	recovered, err := OpenTree(recoveredFilename, WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	verifyTree(t, recovered)
+	if recovered.Len() != 75 {
+		t.Fatalf("expected %v == %v", recovered.Len(), 75)
+	}
+	for i := 0; i < 75; i++ {
+		value, err := recovered.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+
+	// Recovering into a file that exists fails without touching it.
+	_, err = RecoverTo(bytes.NewReader(buf.Bytes()), segments, recoveredFilename,
+		store.RecoveryTarget{})
+	if !os.IsExist(err) {
+		t.Fatalf("expected the file to exist, got %v", err)
+	}
+}
+
+func TestRecoverToFromWALSegments(t *testing.T) {
+	filename := tempFilename(t, "recover_to_from_wal_segments")
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".wal")
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithCacheSize(30),
+		WithWALSegments(64<<10, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tree.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	inserted := map[uint64]int{}
+	for i := 0; i < 200; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		inserted[tree.LSN()] = i + 1
+	}
+	files := tree.WALSegments()
+	if len(files) == 0 {
+		t.Fatalf("expected the log to have completed segments")
+	}
+	var segments []store.WALSegment
+	for _, file := range files {
+		defer os.Remove(file.Filename)
+		segment, err := file.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, segment)
+	}
+
+	// The groups in the log's file that hasn't been completed yet aren't replayed.
+	recoveredFilename := filename + ".recovered"
+	defer os.Remove(recoveredFilename)
+	lsn, err := RecoverTo(&buf, segments, recoveredFilename, store.RecoveryTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lsn != files[len(files)-1].LastLSN {
+		t.Fatalf("expected %v == %v", lsn, files[len(files)-1].LastLSN)
+	}
+	recovered, err := OpenTree(recoveredFilename, WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	verifyTree(t, recovered)
+	if recovered.Len() != inserted[lsn] {
+		t.Fatalf("expected %v == %v", recovered.Len(), inserted[lsn])
+	}
+}
+
+func TestBackupIncremental(t *testing.T) {
+	filename := tempFilename(t, "backup_incremental")
+	defer os.Remove(filename)
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var base bytes.Buffer
+	if err := tree.Backup(&base); err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < 110; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var incremental bytes.Buffer
+	err = tree.BackupIncremental(&incremental, bytes.NewReader(base.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incremental.Len() >= base.Len() {
+		t.Fatalf("expected %v < %v", incremental.Len(), base.Len())
+	}
+
+	restoredFilename := filename + ".restored"
+	defer os.Remove(restoredFilename)
+	err = RestoreIncremental(
+		bytes.NewReader(base.Bytes()),
+		bytes.NewReader(incremental.Bytes()),
+		restoredFilename,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenTree(restoredFilename, WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, restored)
+	if restored.Len() != 110 {
+		t.Fatalf("expected %v == %v", restored.Len(), 110)
+	}
+	if err := restored.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An incremental backup can't be applied to any other backup.
+	var other bytes.Buffer
+	if err := tree.Backup(&other); err != nil {
+		t.Fatal(err)
+	}
+	otherFilename := filename + ".other"
+	err = RestoreIncremental(&other, bytes.NewReader(incremental.Bytes()), otherFilename)
+	if err != store.ErrWrongBase {
+		t.Fatalf("expected %v == %v", err, store.ErrWrongBase)
+	}
+	if _, err := os.Stat(otherFilename); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package bplus
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// WriteBatch accumulates puts and deletes so that they can be applied to a tree together
+// with Tree.Apply. The zero value is an empty batch ready to use.
+type WriteBatch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    Key
+	value  Value
+	delete bool
+	// pointer marks an operation whose value points at its value in the value log.
+	pointer bool
+}
+
+// Put adds an upsert of key to the batch. The key and value are copied so the caller is
+// free to reuse them.
+func (b *WriteBatch) Put(key Key, value Value) {
+	b.ops = append(b.ops, batchOp{
+		key:   append(Key{}, key...),
+		value: append(Value{}, value...),
+	})
+}
+
+// Delete adds a delete of key to the batch. Deleting a key that isn't in the tree is not
+// an error.
+func (b *WriteBatch) Delete(key Key) {
+	b.ops = append(b.ops, batchOp{key: append(Key{}, key...), delete: true})
+}
+
+// Len returns the number of operations in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so that it can be reused.
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// sorted returns the operations sorted by key, keeping only the last operation for keys
+// that were written more than once.
+func (b *WriteBatch) sorted() []batchOp {
+	ops := append([]batchOp{}, b.ops...)
+	sort.SliceStable(ops, func(i, j int) bool {
+		return bytes.Compare(ops[i].key, ops[j].key) < 0
+	})
+	deduped := ops[:0]
+	for i, op := range ops {
+		if i+1 < len(ops) && bytes.Equal(op.key, ops[i+1].key) {
+			continue
+		}
+		deduped = append(deduped, op)
+	}
+	return deduped
+}
+
+// Apply applies every operation in a batch to the tree. If the batch writes a key more
+// than once, the last operation wins. The operations are sorted and applied in a single
+// pass down the tree, so each page is loaded and written at most once no matter how many
+// of the operations land in it. Every operation is validated before the tree is touched,
+// so a batch with an oversized key or record is rejected as a whole.
+func (tree *Tree) Apply(b *WriteBatch) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.applyHooked(b)
+}
+
+// applyHooked applies a batch with the tree's lock held, telling the tree's hooks and
+// watches about it.
+func (tree *Tree) applyHooked(b *WriteBatch) error {
+	events, err := tree.batchEvents(b)
+	if err != nil {
+		return err
+	}
+	err = tree.before(events)
+	if err != nil {
+		return err
+	}
+	err = tree.apply(b)
+	if err != nil {
+		return err
+	}
+	tree.after(events)
+	return nil
+}
+
+// apply applies a batch with the tree's lock held.
+func (tree *Tree) apply(b *WriteBatch) (err error) {
+	if tree.store.ReadOnly() {
+		return store.ErrReadOnly
+	}
+	ops := b.sorted()
+	hasPuts := false
+	for _, op := range ops {
+		if len(op.key) > MaxKeySize {
+			return ErrKeyTooLarge
+		}
+		if op.delete {
+			continue
+		}
+		hasPuts = true
+		if !tree.fits(Record{Key: op.key, Value: op.value}) {
+			return ErrRecordTooLarge
+		}
+	}
+	err = tree.logBatchValues(ops)
+	if err != nil {
+		return err
+	}
+	tree.version.Add(1)
+	tree.store.Begin()
+	defer tree.commit(&err)
+	if len(tree.root.pointers) == 0 {
+		if !hasPuts {
+			return nil
+		}
+		err := tree.allocateFirstLeaf()
+		if err != nil {
+			return err
+		}
+	}
+	splits, _, err := tree.applyBatch(tree.root.PageHandle, ops)
+	if err != nil {
+		return err
+	}
+	tree.root.fromBuffer()
+	err = tree.growRoot(splits)
+	if err != nil {
+		return err
+	}
+	err = tree.shrinkRoot()
+	if err != nil || !tree.blink() || len(ops) == 0 {
+		return err
+	}
+	// The key after the last key in the batch is the last key with a zero byte appended.
+	end := append(append(Key{}, ops[len(ops)-1].key...), 0)
+	return tree.relink(ops[0].key, end)
+}
+
+// applyBatch applies sorted operations which all belong in the subtree rooted at node. It
+// returns the pages node was split into and whether node now underflows.
+func (tree *Tree) applyBatch(
+	node *store.PageHandle,
+	ops []batchOp,
+) ([]split, bool, error) {
+	if isLeafPage(node) {
+		return tree.applyBatchToLeaf(node, ops)
+	}
+	branch := &branchPage{PageHandle: node}
+	branch.fromBuffer()
+	changed := false
+	underflowing := map[store.PageID]bool{}
+	// Children are visited from the right so that adding the pages a child was split into
+	// doesn't shift the children still to be visited.
+	end := len(ops)
+	for end > 0 {
+		i := branch.childIndex(ops[end-1].key)
+		start := end - 1
+		for start > 0 && branch.childIndex(ops[start-1].key) == i {
+			start--
+		}
+		child, err := tree.store.Load(branch.pointers[i])
+		if err != nil {
+			return nil, false, err
+		}
+		splits, underflow, err := tree.applyBatch(child, ops[start:end])
+		count := pageCount(child)
+		// In copy-on-write mode, a child that was written has moved to a fresh page.
+		if child.ID != branch.pointers[i] {
+			changed = true
+		}
+		releaseErr := tree.release(child)
+		if err != nil {
+			return nil, false, err
+		}
+		if releaseErr != nil {
+			return nil, false, releaseErr
+		}
+		if count != branch.counts[i] {
+			branch.counts[i] = count
+			changed = true
+		}
+		if len(splits) > 0 {
+			branch.insertSplits(i, splits)
+			changed = true
+		}
+		if underflow {
+			underflowing[branch.pointers[i]] = true
+			changed = true
+		}
+		end = start
+	}
+	if !changed {
+		return nil, false, nil
+	}
+	for i := len(branch.pointers) - 1; i >= 0; i-- {
+		if i < len(branch.pointers) && underflowing[branch.pointers[i]] {
+			err := tree.rebalance(branch, i)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	splits, err := tree.writeBranchSplitting(branch)
+	if err != nil {
+		return nil, false, err
+	}
+	underflow := len(splits) == 0 && tree.underflows(len(branch.keys), branch.size())
+	return splits, underflow, nil
+}
+
+func (tree *Tree) applyBatchToLeaf(
+	node *store.PageHandle,
+	ops []batchOp,
+) ([]split, bool, error) {
+	leaf := &leafPage{PageHandle: node}
+	leaf.fromBuffer()
+	records := make([]Record, 0, len(leaf.records)+len(ops))
+	changed := false
+	i := 0
+	for _, op := range ops {
+		for i < len(leaf.records) && bytes.Compare(leaf.records[i].Key, op.key) < 0 {
+			records = append(records, leaf.records[i])
+			i++
+		}
+		found := i < len(leaf.records) && bytes.Equal(leaf.records[i].Key, op.key)
+		if found {
+			// The existing record is either deleted or replaced.
+			i++
+			changed = true
+		}
+		if !op.delete {
+			record := Record{Key: op.key, Value: op.value, pointer: op.pointer}
+			records = append(records, record)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, false, nil
+	}
+	leaf.records = append(records, leaf.records[i:]...)
+	splits, err := tree.writeLeafSplitting(leaf)
+	if err != nil {
+		return nil, false, err
+	}
+	underflow := len(splits) == 0 && tree.underflows(len(leaf.records), leaf.size())
+	return splits, underflow, nil
+}
@@ -0,0 +1,116 @@
+package bplus
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestApplyBatch(t *testing.T) {
+	tree, err := newTree("apply_batch", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	var b WriteBatch
+	for _, i := range r.Perm(1000) {
+		b.Put(intKey(i), intValue(i))
+	}
+	if err := tree.Apply(&b); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+
+	// The last operation on a key wins.
+	b.Reset()
+	b.Put(intKey(1), intValue(100))
+	b.Delete(intKey(1))
+	b.Delete(intKey(2))
+	b.Put(intKey(2), intValue(200))
+	b.Delete(intKey(5000))
+	if err := tree.Apply(&b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Read(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	value, err := tree.Read(intKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(200)) {
+		t.Fatalf("expected %v == %v", value, intValue(200))
+	}
+}
+
+func TestApplyBatchRejectsInvalidBatch(t *testing.T) {
+	tree, err := newTree("apply_batch_invalid", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b WriteBatch
+	b.Put(intKey(1), intValue(1))
+	b.Put(intKey(2), make([]byte, maxRecordSize))
+	if err := tree.Apply(&b); err != ErrRecordTooLarge {
+		t.Fatalf("expected %v == %v", err, ErrRecordTooLarge)
+	}
+	if _, err := tree.Read(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected nothing from the batch to be applied, got %v", err)
+	}
+}
+
+func TestApplyBatchRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, bf := range []int{3, 4, 16, 1000} {
+		tree, err := newTree("apply_batch_randomized", bf, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := map[int]int{}
+		var b WriteBatch
+		for round := 0; round < 15; round++ {
+			b.Reset()
+			for n := 0; n < 200; n++ {
+				i := r.Intn(1000)
+				if r.Intn(3) == 0 {
+					b.Delete(intKey(i))
+					delete(model, i)
+				} else {
+					b.Put(intKey(i), bytes.Repeat(intValue(round), 10))
+					model[i] = round
+				}
+			}
+			if err := tree.Apply(&b); err != nil {
+				t.Fatal(err)
+			}
+			if tree.Len() != len(model) {
+				t.Fatalf("expected %d == %d", tree.Len(), len(model))
+			}
+			verifyTree(t, tree)
+			for i := 0; i < 1000; i++ {
+				value, err := tree.Read(intKey(i))
+				round, ok := model[i]
+				if !ok {
+					if err != ErrKeyNotFound {
+						t.Fatalf("expected %d to be missing", i)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(i, err)
+				}
+				if !bytes.Equal(value, bytes.Repeat(intValue(round), 10)) {
+					t.Fatalf("unexpected value for %d", i)
+				}
+			}
+		}
+	}
+}
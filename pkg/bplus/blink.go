@@ -0,0 +1,509 @@
+package bplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// A B-link tree is a tree whose pages each also point at their right sibling and record
+// their high key, the smallest key that belongs to the right of them. A goroutine that
+// reads a page after it was split, and so finds key at or past its high key, follows the
+// link to the right until it finds the page that key moved to. That lets Read and put
+// latch one page at a time on their way down rather than crabbing, since a split never
+// has to wait for a reader to let go of the parent.
+//
+// Writers latch the leaf for writing and change it, splitting it if it overflows. The
+// new pages are linked in before the leaf is let go of, so they're reachable straight
+// away, and only afterwards is each branch on the path latched in turn, from the bottom
+// up, to add the new pages and count again the records under the children that changed.
+// A branch that a concurrent split moved the path off of is found by following links, and
+// a root that was split since the path was recorded by descending again. Counting again
+// rather than adding differences means writers racing up the same path can't count a
+// record twice. While holding a branch, a writer latches its children for reading, but
+// nobody holds a page while waiting for the one above it, so latches can't deadlock.
+//
+// Only splits happen concurrently. Merges and frees hold the tree's lock for writing, and
+// relink fixes the links around the pages they changed. Copy-on-write mode isn't
+// supported, as a page moving would change the link of its left sibling.
+
+// blinkFlag is set in the file header of trees created by NewBLinkTree.
+const blinkFlag = 8
+
+const (
+	// blinkPageFlag is set in the identifier byte of the pages of a B-link tree.
+	blinkPageFlag = 2
+	// noHighKey is stored as the length of the high key of the last page on a level.
+	noHighKey = 0xFFFFFFFF
+)
+
+// ErrBLinkCOW is returned when enabling copy-on-write mode on a B-link tree.
+var ErrBLinkCOW = errors.New("B-link trees can't use copy-on-write mode")
+
+// NewBLinkTree constructs a persisted B-link tree in the given file, see blink.go. It's
+// used like any other tree, and can be reopened with OpenTree, but every page keeps room
+// for a link to its right sibling and a high key, so pages hold fewer records.
+func NewBLinkTree(filename string, opts ...Option) (*Tree, error) {
+	tree, err := NewTree(filename, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.setFlags(tree.flags | blinkFlag)
+	if err != nil {
+		return nil, err
+	}
+	return tree, tree.writeBranch(tree.root)
+}
+
+// BLink returns whether the tree is a B-link tree created with NewBLinkTree.
+func (tree *Tree) BLink() bool {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.blink()
+}
+
+func (tree *Tree) blink() bool {
+	return tree.flags&blinkFlag != 0
+}
+
+// trailerSize is the space at the end of every page of the tree that records can't use,
+// which includes the space the store keeps for itself, see store.PageStore.ContentSize.
+func (tree *Tree) trailerSize() int {
+	size := store.UsablePageSize - tree.store.ContentSize()
+	if tree.blink() {
+		size += blinkTrailerSize(tree.wide())
+	}
+	return size
+}
+
+// blinkTrailerSize is the space kept at the end of every page of a B-link tree for its
+// link, the length of its high key, and the high key.
+func blinkTrailerSize(wide bool) int {
+	return pageIDSize(wide) + 4 + MaxKeySize
+}
+
+// rightLink is stored at the end of the contents of every page of a B-link tree, see
+// store.PageHandle.Contents.
+type rightLink struct {
+	blink bool
+	// wide is set for pages that store page ids in 64 bits, see widePageFlag. The link is
+	// one of them, so it's decoded along with it.
+	wide bool
+	// link is the page to the right on the same level, or 0 for the last page.
+	link store.PageID
+	// high is the smallest key that belongs to the right of the page, or nil for the last
+	// page on a level.
+	high Key
+}
+
+// covers reports whether key belongs in the page or to the left of it.
+func (l *rightLink) covers(key Key) bool {
+	return l.high == nil || bytes.Compare(key, l.high) < 0
+}
+
+func (l *rightLink) linkToBuffer(buf []byte) {
+	if l.wide {
+		buf[0] |= widePageFlag
+	}
+	if !l.blink {
+		return
+	}
+	buf[0] |= blinkPageFlag
+	current := len(buf) - blinkTrailerSize(l.wide)
+	current += pageIDToBuffer(buf[current:], l.link, l.wide)
+	if l.high == nil {
+		binary.LittleEndian.PutUint32(buf[current:], noHighKey)
+		return
+	}
+	keyToBuffer(buf[current:], l.high)
+}
+
+func (l *rightLink) linkFromBuffer(buf []byte) {
+	*l = rightLink{
+		blink: buf[0]&blinkPageFlag != 0,
+		wide:  buf[0]&widePageFlag != 0,
+	}
+	if !l.blink {
+		return
+	}
+	current := len(buf) - blinkTrailerSize(l.wide)
+	var n int
+	l.link, n = pageIDFromBuffer(buf[current:], l.wide)
+	current += n
+	if binary.LittleEndian.Uint32(buf[current:]) != noHighKey {
+		l.high, _ = keyFromBuffer(buf[current:])
+	}
+}
+
+// sameLink reports whether two pages link to the same page with the same high key.
+func sameLink(a, b rightLink) bool {
+	if a.link != b.link || (a.high == nil) != (b.high == nil) {
+		return false
+	}
+	return bytes.Equal(a.high, b.high)
+}
+
+// descendBLink finds the leaf key belongs in while latching one page at a time, moving
+// right past pages that were split after their parent was read. It returns the leaf
+// still latched, for writing if exclusive is set, along with the branches it descended
+// through, starting with the root. The leaf is nil if the tree is empty.
+func (tree *Tree) descendBLink(
+	key Key,
+	exclusive bool,
+) (*latchedPage, []store.PageID, error) {
+	tree.rootLatch.RLock()
+	root := &branchPage{PageHandle: tree.root.PageHandle}
+	root.fromBuffer()
+	tree.rootLatch.RUnlock()
+	if len(root.pointers) == 0 {
+		return nil, nil, nil
+	}
+	path := []store.PageID{root.ID}
+	pageID := root.pointers[root.childIndex(key)]
+	for {
+		page, err := tree.latchChild(pageID, false, exclusive)
+		if err != nil {
+			return nil, nil, err
+		}
+		var link rightLink
+		link.linkFromBuffer(page.Contents())
+		if !link.covers(key) {
+			pageID = link.link
+		} else if isLeafPage(page.PageHandle) {
+			return page, path, nil
+		} else {
+			branch := decodeBranch(page.PageHandle)
+			path = append(path, page.id)
+			pageID = branch.pointers[branch.childIndex(key)]
+			freeBranch(branch)
+		}
+		err = tree.unlatch(page)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// latchCovering latches pageID for writing, moving right until it reaches the page key
+// belongs in.
+func (tree *Tree) latchCovering(pageID store.PageID, key Key) (*latchedPage, error) {
+	for {
+		page, err := tree.latchChild(pageID, true, true)
+		if err != nil {
+			return nil, err
+		}
+		var link rightLink
+		link.linkFromBuffer(page.Contents())
+		if link.covers(key) {
+			return page, nil
+		}
+		pageID = link.link
+		err = tree.unlatch(page)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// putBLink makes a change to a B-link tree with only the leaf latched, and then brings
+// the branches above it up to date one at a time.
+func (tree *Tree) putBLink(key Key, kind putKind, fn putFunc) error {
+	page, path, err := tree.descendBLink(key, true)
+	if err != nil {
+		return err
+	}
+	if page == nil {
+		if kind != putAdds {
+			return ErrKeyNotFound
+		}
+		tree.rootLatch.Lock()
+		if len(tree.root.pointers) == 0 {
+			err = tree.allocateFirstLeaf()
+		}
+		tree.rootLatch.Unlock()
+		if err != nil {
+			return err
+		}
+		return tree.putBLink(key, kind, fn)
+	}
+	leaf := &leafPage{PageHandle: page.PageHandle}
+	leaf.fromBuffer()
+	before := liveCount(leaf.records)
+	splits, err := tree.insertIntoLeaf(leaf, key, fn)
+	after := liveCount(leaf.records)
+	for _, s := range splits {
+		after += s.count
+	}
+	unlatchErr := tree.unlatch(page)
+	if err != nil {
+		return err
+	}
+	if unlatchErr != nil {
+		return unlatchErr
+	}
+	if len(splits) == 0 && after == before {
+		return nil
+	}
+	return tree.propagateBLink(key, path, splits)
+}
+
+// propagateBLink adds the pages a leaf was split into to the branches above it, and
+// counts again the records under the children that changed, latching one branch at a
+// time from the bottom of path up. It stops at the first level where nothing changes.
+func (tree *Tree) propagateBLink(key Key, path []store.PageID, splits []split) error {
+	// keys holds a key in each page that changed on the level below, in order.
+	keys := []Key{key}
+	for height := 1; len(path) > 0; height++ {
+		pageID := path[len(path)-1]
+		path = path[:len(path)-1]
+		if len(path) == 0 {
+			done, err := tree.propagateToRoot(pageID, keys, splits)
+			if done || err != nil {
+				return err
+			}
+			// The root was split after the path was recorded, so the branches now above
+			// the old root are found by descending again.
+			leaf, full, err := tree.descendBLink(firstKey(keys, splits), false)
+			if err != nil {
+				return err
+			}
+			err = tree.unlatch(leaf)
+			if err != nil {
+				return err
+			}
+			path = full[:len(full)-height]
+		}
+		var err error
+		keys, splits, err = tree.propagateLevel(pageID, keys, splits)
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+	}
+	return nil
+}
+
+// propagateLevel brings the branches on one level up to date, starting from pageID and
+// moving right. A concurrent split can leave the changes on the level below spread over
+// more than one branch, so each key and split is added to the branch it belongs in. It
+// returns a key in each branch that changed, and the splits of those branches.
+func (tree *Tree) propagateLevel(
+	pageID store.PageID,
+	keys []Key,
+	splits []split,
+) ([]Key, []split, error) {
+	var changedKeys []Key
+	var changedSplits []split
+	for len(keys) > 0 || len(splits) > 0 {
+		first := firstKey(keys, splits)
+		page, err := tree.latchCovering(pageID, first)
+		if err != nil {
+			return nil, nil, err
+		}
+		pageID = page.id
+		branch := &branchPage{PageHandle: page.PageHandle}
+		branch.fromBuffer()
+		n, m := 0, 0
+		for n < len(keys) && branch.covers(keys[n]) {
+			n++
+		}
+		for m < len(splits) && branch.covers(splits[m].key) {
+			m++
+		}
+		changed, err := tree.addSplits(branch, keys[:n], splits[:m])
+		keys, splits = keys[n:], splits[m:]
+		if err == nil && changed {
+			var branchSplits []split
+			branchSplits, err = tree.writeBranchSplitting(branch)
+			changedKeys = append(changedKeys, first)
+			changedSplits = append(changedSplits, branchSplits...)
+		}
+		unlatchErr := tree.unlatch(page)
+		if err != nil {
+			return nil, nil, err
+		}
+		if unlatchErr != nil {
+			return nil, nil, unlatchErr
+		}
+	}
+	return changedKeys, changedSplits, nil
+}
+
+// firstKey returns the smallest of the keys and the keys of the splits, which are both
+// in order.
+func firstKey(keys []Key, splits []split) Key {
+	if len(splits) > 0 && (len(keys) == 0 || bytes.Compare(splits[0].key, keys[0]) < 0) {
+		return splits[0].key
+	}
+	return keys[0]
+}
+
+// propagateToRoot does what propagateLevel does for the root, as long as pageID is still
+// the root, growing the tree if the root is split. It returns false if pageID is no
+// longer the root.
+func (tree *Tree) propagateToRoot(
+	pageID store.PageID,
+	keys []Key,
+	splits []split,
+) (bool, error) {
+	tree.rootLatch.Lock()
+	defer tree.rootLatch.Unlock()
+	if tree.root.ID != pageID {
+		return false, nil
+	}
+	root := &branchPage{PageHandle: tree.root.PageHandle}
+	root.fromBuffer()
+	changed, err := tree.addSplits(root, keys, splits)
+	if err != nil || !changed {
+		return true, err
+	}
+	splits, err = tree.writeBranchSplitting(root)
+	if err != nil {
+		return true, err
+	}
+	tree.root.fromBuffer()
+	return true, tree.growRoot(splits)
+}
+
+// addSplits adds the pages children of branch were split into, and counts again the
+// records under them, the children to their left, and the children keys belong in. It
+// reports whether the branch changed.
+func (tree *Tree) addSplits(
+	branch *branchPage,
+	keys []Key,
+	splits []split,
+) (bool, error) {
+	recount := map[store.PageID]bool{}
+	for _, key := range keys {
+		recount[branch.pointers[branch.childIndex(key)]] = true
+	}
+	for _, s := range splits {
+		i := branch.childIndex(s.key)
+		recount[branch.pointers[i]] = true
+		recount[s.right] = true
+		branch.insertSplits(i, []split{s})
+	}
+	changed := len(splits) > 0
+	for i, pointer := range branch.pointers {
+		if !recount[pointer] {
+			continue
+		}
+		child, err := tree.latchChild(pointer, false, false)
+		if err != nil {
+			return false, err
+		}
+		count := pageCount(child.PageHandle)
+		err = tree.unlatch(child)
+		if err != nil {
+			return false, err
+		}
+		if count != branch.counts[i] {
+			branch.counts[i] = count
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// relinkTarget is a page that relink points its left sibling at.
+type relinkTarget struct {
+	id store.PageID
+	// high is the high key the page should have.
+	high Key
+}
+
+// relink brings the links and high keys of a B-link tree back in line with its branches
+// after a change to the keys in [start, end] that may have merged, freed or split pages.
+// Merges only happen between siblings, so the pages whose links can be out of date are
+// the ones that hold keys in the range and the pages on either side of them on each
+// level. A nil end relinks every page from start to the end of the tree.
+func (tree *Tree) relink(start, end Key) error {
+	// A branch that became the root when the root shrank still has the link it had as a
+	// child.
+	if !sameLink(tree.root.rightLink, rightLink{}) {
+		tree.root.rightLink = rightLink{}
+		err := tree.writeBranch(tree.root)
+		if err != nil {
+			return err
+		}
+	}
+	parents := []*branchPage{tree.root}
+	// after is the page to the right of the parents on their level, or 0 if there isn't
+	// one.
+	var after store.PageID
+	for len(parents) > 0 {
+		var targets []relinkTarget
+		next := store.PageID(0)
+		for j, parent := range parents {
+			first, last := 0, len(parent.pointers)-1
+			if j == 0 && parent.childIndex(start) > 0 {
+				first = parent.childIndex(start) - 1
+			}
+			if j == len(parents)-1 && end != nil && parent.childIndex(end)+1 < last {
+				last = parent.childIndex(end) + 1
+				next = parent.pointers[last+1]
+			}
+			for i := first; i <= last; i++ {
+				high := parent.high
+				if i < len(parent.keys) {
+					high = parent.keys[i]
+				}
+				targets = append(targets, relinkTarget{id: parent.pointers[i], high: high})
+			}
+		}
+		if next == 0 && after != 0 {
+			_, branch, err := tree.loadNode(after)
+			if err != nil {
+				return err
+			}
+			next = branch.pointers[0]
+		}
+		parents = parents[:0]
+		for i, target := range targets {
+			link := rightLink{blink: true, link: next, high: target.high}
+			if i+1 < len(targets) {
+				link.link = targets[i+1].id
+			}
+			branch, err := tree.relinkPage(target.id, link)
+			if err != nil {
+				return err
+			}
+			if branch != nil {
+				parents = append(parents, branch)
+			}
+		}
+		after = next
+	}
+	return nil
+}
+
+// relinkPage gives a page the link and high key relink worked out for it. It returns the
+// decoded page if it's a branch.
+func (tree *Tree) relinkPage(pageID store.PageID, link rightLink) (*branchPage, error) {
+	page, err := tree.store.Load(pageID)
+	if err != nil {
+		return nil, err
+	}
+	var branch *branchPage
+	if isLeafPage(page) {
+		leaf := &leafPage{PageHandle: page}
+		leaf.fromBuffer()
+		if !sameLink(leaf.rightLink, link) {
+			leaf.rightLink = link
+			err = tree.writeLeaf(leaf)
+		}
+	} else {
+		branch = &branchPage{PageHandle: page}
+		branch.fromBuffer()
+		if !sameLink(branch.rightLink, link) {
+			branch.rightLink = link
+			err = tree.writeBranch(branch)
+		}
+	}
+	releaseErr := tree.release(page)
+	if err != nil {
+		return nil, err
+	}
+	return branch, releaseErr
+}
@@ -0,0 +1,148 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestBLinkTree(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "blink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewBLinkTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableCOW(); err != ErrBLinkCOW {
+		t.Fatalf("expected %v == %v", err, ErrBLinkCOW)
+	}
+	r := rand.New(rand.NewSource(1))
+	present := map[int]bool{}
+	for _, i := range r.Perm(2000) {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		present[i] = true
+	}
+	verifyTree(t, tree)
+	// Deletes merge pages, which has to leave the links between them intact.
+	for _, i := range r.Perm(2000)[:600] {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(i, err)
+		}
+		delete(present, i)
+	}
+	verifyTree(t, tree)
+	if _, err := tree.DeleteRange(intKey(500), intKey(1200)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1200; i++ {
+		delete(present, i)
+	}
+	verifyTree(t, tree)
+	var batch WriteBatch
+	for i := 1000; i < 1600; i++ {
+		if i%3 == 0 {
+			batch.Delete(intKey(i))
+			delete(present, i)
+		} else {
+			batch.Put(intKey(i), intValue(i))
+			present[i] = true
+		}
+	}
+	if err := tree.Apply(&batch); err != nil {
+		t.Fatal(err)
+	}
+	report := verifyTree(t, tree)
+	if report.Records != len(present) {
+		t.Fatalf("expected %v == %v", report.Records, len(present))
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tree.BLink() {
+		t.Fatal("expected the reopened tree to be a B-link tree")
+	}
+	for i := 0; i < 2000; i++ {
+		value, err := tree.Read(intKey(i))
+		if !present[i] {
+			if err != ErrKeyNotFound {
+				t.Fatalf("found deleted value %+v for %d", value, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+	if tree.Len() != len(present) {
+		t.Fatalf("expected %v == %v", tree.Len(), len(present))
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBLinkTreeBulkLoad(t *testing.T) {
+	tree, err := newBLinkTree("blink_bulk_load", 8, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]Record, 3000)
+	for i := range records {
+		records[i] = Record{Key: intKey(i * 2), Value: intValue(i * 2)}
+	}
+	if err := tree.BulkLoad(records, 0.9); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	for i := 1; i < 1000; i += 2 {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	verifyTree(t, tree)
+}
+
+func TestBLinkConcurrentReadsAndWrites(t *testing.T) {
+	modes := map[string]func(*Tree) error{
+		"plain": func(*Tree) error { return nil },
+		"wal":   (*Tree).EnableWAL,
+	}
+	for name, enable := range modes {
+		t.Run(name, func(t *testing.T) {
+			tree, err := newBLinkTree("blink_concurrent_"+name, 4, 256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := enable(tree); err != nil {
+				t.Fatal(err)
+			}
+			testConcurrentReadsAndWrites(t, tree)
+		})
+	}
+}
+
+func newBLinkTree(filename string, branchingFactor, cacheCapacity int) (*Tree, error) {
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		return nil, err
+	}
+	tmpfile.Close()
+	return NewBLinkTree(
+		tmpfile.Name(),
+		WithBranchingFactor(branchingFactor),
+		WithCacheSize(cacheCapacity),
+	)
+}
@@ -1,8 +1,13 @@
 package bplus
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jpittis/bplus/pkg/store"
 )
@@ -10,10 +15,15 @@ import (
 var (
 	// ErrKeyNotFound is returned when a key is not present in the tree.
 	ErrKeyNotFound = errors.New("key not found")
+	// ErrTreeExists is returned when creating a tree in a file that already has one.
+	ErrTreeExists = errors.New("tree already exists")
+	// ErrTreeNotFound is returned when opening a tree in a file that doesn't have one.
+	ErrTreeNotFound = errors.New("tree not found")
 )
 
-// Key is the key used to lookup values in a B+ tree.
-type Key uint32
+// Key is the key used to lookup values in a B+ tree. Keys are ordered by comparing their
+// bytes.
+type Key []byte
 
 // Value is the data stored in the B+ tree.
 type Value []byte
@@ -22,29 +32,217 @@ type Value []byte
 type Record struct {
 	Key   Key
 	Value Value
+	// tombstone marks a record which has been deleted but not yet removed from its leaf.
+	tombstone bool
+	// pointer marks a record whose Value points at its value in the value log, see
+	// valuePointer.
+	pointer bool
 }
 
-// Tree implemented a persisted B+ tree with a page cache.
+// Tree implemented a persisted B+ tree with a page cache. A tree is safe for concurrent
+// use by many goroutines, see latch.go.
 type Tree struct {
-	store           *store.PageStore
+	store *store.PageStore
+	// lock is held for reading by Read and the writes built on put, which latch the pages
+	// they visit, and for writing by everything else.
+	lock sync.RWMutex
+	// rootLatch latches the root, and guards which page is the root, for the goroutines
+	// holding lock for reading. Every other page is latched with latches.
+	rootLatch       sync.RWMutex
+	latches         latchTable
 	root            *branchPage
 	branchingFactor int
+	// readAhead is the number of leaves cursors prefetch, see WithReadAhead.
+	readAhead int
+	// version is incremented by every change to the tree, so that cursors can tell when
+	// the pages they've decoded may be out of date.
+	version atomic.Uint64
+	// flags records the options the tree was created with.
+	flags uint32
+	// db is the database the tree is stored in under name, or nil if the tree has the file
+	// to itself and its root is recorded in the file header.
+	db   *DB
+	name string
+	// recordedRoot is the root last recorded in the file header or catalog.
+	recordedRoot store.PageID
+	// values is the tree's value log, or nil if it keeps every value in its leaves.
+	values *valueLog
+	// tracer traces the tree's operations, or is nil if they aren't traced, see trace.go.
+	tracer Tracer
+	// slowOpThreshold is how long an operation takes to be logged, or zero if slow
+	// operations aren't logged, see slow.go.
+	slowOpThreshold time.Duration
+	// lockWait is the time operations have spent waiting for lock while they're timed.
+	lockWait atomic.Int64
+	// watches and hooks are told about the changes made to the tree, see Watch and
+	// AddHooks.
+	watches watchList
+	hooks   hookList
 }
 
-// NewTree constructs a persisted B+ tree in the given file.
-func NewTree(filename string, branchingFactor, cacheCapacity int) (*Tree, error) {
-	s, err := store.NewPageStore(filename, cacheCapacity)
+// NewTree constructs a persisted B+ tree in the given file. Use OpenTree to reattach to a
+// tree that was previously created in the file.
+func NewTree(filename string, opts ...Option) (*Tree, error) {
+	o := newOptions(opts)
+	err := o.validate()
 	if err != nil {
 		return nil, err
 	}
+	s, err := store.NewPageStore(filename, o.store...)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := createTree(s, o)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.useValueLog(filename, o)
+	if err != nil {
+		tree.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+// NewMemoryTree constructs a B+ tree kept in memory rather than in a file, see
+// store.MemoryBackend. It works like any other tree, but its records are gone once it's
+// closed, and it can't use a write-ahead log or double-write buffer.
+func NewMemoryTree(opts ...Option) (*Tree, error) {
+	o := newOptions(opts)
+	err := o.validate()
+	if err != nil {
+		return nil, err
+	}
+	if o.valueThreshold != 0 {
+		return nil, ErrValueLogUnsupported
+	}
+	s, err := store.NewMemoryPageStore(o.store...)
+	if err != nil {
+		return nil, err
+	}
+	return createTree(s, o)
+}
+
+// createTree constructs a tree in the page store, which must not hold one yet.
+func createTree(s *store.PageStore, o options) (*Tree, error) {
+	if s.Root() != 0 {
+		// Let go of the file, and its lock, since the tree in it isn't being opened.
+		s.Close()
+		return nil, ErrTreeExists
+	}
 	tree := &Tree{
 		store:           s,
-		branchingFactor: branchingFactor,
+		branchingFactor: o.branchingFactor,
+		readAhead:       o.readAhead,
+		tracer:          o.tracer(),
+		slowOpThreshold: o.slowOpThreshold,
 	}
-	err = tree.allocateRootNode()
+	err := tree.allocateRootNode()
 	return tree, err
 }
 
+// OpenTree reattaches to a persisted B+ tree that was created in the given file by
+// NewTree.
+func OpenTree(filename string, opts ...Option) (*Tree, error) {
+	tree, err := openTree(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	return plainTree(tree)
+}
+
+// NewBackendTree constructs a B+ tree in a page store kept in backend rather than in a
+// file, see store.OpenBackend. Like a tree kept in memory, it can't use a double-write
+// buffer or value log, and it can only use a write-ahead log kept in a backend of its
+// own, see WithWALBackend. Use OpenBackendTree to reattach to it.
+func NewBackendTree(backend store.Backend, opts ...Option) (*Tree, error) {
+	o := newOptions(opts)
+	err := o.validate()
+	if err != nil {
+		return nil, err
+	}
+	if o.valueThreshold != 0 {
+		return nil, ErrValueLogUnsupported
+	}
+	s, err := store.OpenBackend(backend, o.store...)
+	if err != nil {
+		return nil, err
+	}
+	return createTree(s, o)
+}
+
+// OpenBackendTree reattaches to a tree that was created in backend by NewBackendTree.
+func OpenBackendTree(backend store.Backend, opts ...Option) (*Tree, error) {
+	o := newOptions(opts)
+	if o.valueThreshold != 0 {
+		return nil, ErrValueLogUnsupported
+	}
+	s, err := store.OpenBackend(backend, o.store...)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := attachTree(s, o)
+	if err != nil {
+		return nil, err
+	}
+	return plainTree(tree)
+}
+
+// plainTree returns the tree unless it's a multimap or database, which have to be opened
+// as one, in which case it's closed.
+func plainTree(tree *Tree) (*Tree, error) {
+	if tree.flags&multimapFlag != 0 {
+		tree.Close()
+		return nil, ErrMultimap
+	}
+	if tree.flags&dbFlag != 0 {
+		tree.Close()
+		return nil, ErrDB
+	}
+	return tree, nil
+}
+
+func openTree(filename string, opts []Option) (*Tree, error) {
+	o := newOptions(opts)
+	s, err := store.NewPageStore(filename, o.store...)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := attachTree(s, o)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.useValueLog(filename, o)
+	if err != nil {
+		tree.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+// attachTree reattaches to the tree in the page store, closing the store if it can't.
+func attachTree(s *store.PageStore, o options) (*Tree, error) {
+	if s.Root() == 0 {
+		s.Close()
+		return nil, ErrTreeNotFound
+	}
+	tree := &Tree{
+		store:           s,
+		branchingFactor: s.BranchingFactor(),
+		readAhead:       o.readAhead,
+		flags:           s.Flags(),
+		recordedRoot:    s.Root(),
+		tracer:          o.tracer(),
+		slowOpThreshold: o.slowOpThreshold,
+	}
+	err := tree.loadRootNode(s.Root())
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
 func (tree *Tree) allocateRootNode() error {
 	pageID, err := tree.store.Allocate()
 	if err != nil {
@@ -54,88 +252,394 @@ func (tree *Tree) allocateRootNode() error {
 	if err != nil {
 		return err
 	}
-	tree.root = &branchPage{Page: page}
-	return nil
+	// The page may have come off the free list, so it's written out empty rather than
+	// decoded.
+	tree.root = &branchPage{PageHandle: page}
+	err = tree.writeBranch(tree.root)
+	if err != nil {
+		return err
+	}
+	return tree.setRoot(pageID)
 }
 
-// Read a value from the tree, return an error if it's not found.
-func (tree *Tree) Read(key Key) (Value, error) {
-	if len(tree.root.keys) == 0 {
-		return nil, ErrKeyNotFound
+// EnableWAL sends every write to the file through a write-ahead log kept alongside it,
+// or in the backend given with WithWALBackend.
+// Each change to the tree, whether a single insert or a whole batch, is committed to the
+// log before any of its pages are written to the file, so a crash can't leave the tree
+// partly written: the change is finished when the file is next opened, or if it hadn't
+// been committed to the log yet, it never happened. Changes that return an error are
+// still committed along with whatever pages they wrote before failing.
+func (tree *Tree) EnableWAL() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.store.EnableWAL()
+}
+
+// Checkpoint syncs the changes committed to the tree's write-ahead log to the file and
+// empties the log, so that they don't have to be replayed after a crash, see
+// WithCheckpoints. Without checkpoints, every change already does.
+func (tree *Tree) Checkpoint() (err error) {
+	defer tree.startSpan("bplus.Checkpoint").end(&err)
+	tree.timedLock()
+	defer tree.lock.Unlock()
+	return tree.store.Checkpoint()
+}
+
+// EnableCOW turns on copy-on-write mode, an alternative to a write-ahead log. Each change
+// to the tree writes the pages it modifies to fresh pages, leaving the pages of the tree
+// as it was before the change untouched, and then records the new root in the file in a
+// single write. A crash part way through a change leaves the tree as it was before the
+// change, without any log to replay, at the cost of rewriting every page on the path
+// from the root to each modified page.
+func (tree *Tree) EnableCOW() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.blink() {
+		return ErrBLinkCOW
+	}
+	return tree.store.EnableCOW()
+}
+
+// EnableDoubleWrite guards the tree against torn writes, where the power fails part way
+// through writing a page and leaves it half old and half new. Every page is copied to a
+// buffer kept alongside the file before it's written, and a torn page is restored from
+// the copy when the file is next opened. It can be combined with a write-ahead log or
+// copy-on-write mode, which protect changes that span many pages but not the file header.
+func (tree *Tree) EnableDoubleWrite() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.store.EnableDoubleWrite()
+}
+
+// Sync syncs every change made to the tree so far to disk.
+func (tree *Tree) Sync() (err error) {
+	defer tree.startSpan("bplus.flush").end(&err)
+	tree.timedLock()
+	defer tree.lock.Unlock()
+	if tree.values != nil {
+		err := tree.values.sync()
+		if err != nil {
+			return err
+		}
 	}
-	leaf, err := tree.search(key, tree.root.Page)
+	return tree.store.Sync()
+}
+
+// Shrink gives the space taken up by the pages freed as the tree shrinks back to the file
+// system, cutting them off the end of the file and, if punchHoles is set, punching them
+// out of the middle of it. See store.PageStore.Shrink.
+func (tree *Tree) Shrink(punchHoles bool) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.store.Shrink(punchHoles)
+}
+
+// SetDurability chooses when changes to the tree are synced to disk: after every change,
+// periodically on the given interval, or only when Sync is called. See store.Durability.
+func (tree *Tree) SetDurability(
+	durability store.Durability,
+	interval time.Duration,
+) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.store.SetDurability(durability, interval)
+}
+
+// StartFlusher starts writing changes to the tree back to the file in the background, on
+// the given interval and whenever they add up to threshold bytes. See
+// store.PageStore.StartFlusher.
+func (tree *Tree) StartFlusher(interval time.Duration, threshold int) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.store.StartFlusher(interval, threshold)
+}
+
+// StopFlusher stops the flusher started by StartFlusher. Closing the tree stops it too.
+func (tree *Tree) StopFlusher() {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	tree.store.StopFlusher()
+}
+
+// Close syncs the tree to disk and closes its file. Using the tree afterwards returns
+// store.ErrClosed.
+func (tree *Tree) Close() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	tree.watches.closeAll()
+	// The root has been pinned since the tree was opened.
+	err := tree.release(tree.root.PageHandle)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	for _, r := range leaf.records {
-		if r.Key == key {
-			return r.Value, nil
+	if tree.values != nil {
+		err = tree.values.close()
+		if err != nil {
+			tree.store.Close()
+			return err
 		}
 	}
-	return nil, ErrKeyNotFound
+	return tree.store.Close()
 }
 
-func (tree *Tree) search(key Key, node *store.Page) (*leafPage, error) {
-	if isLeafPage(node) {
-		leaf := &leafPage{Page: node}
-		leaf.fromBuffer()
-		return leaf, nil
+// EnableLeakDetection records where every page the tree loads from now on was loaded,
+// until it's released, so that pages the tree forgets to release can be found with
+// Leaks and are reported by Close. It's slow, so it's meant for debugging.
+func (tree *Tree) EnableLeakDetection() {
+	tree.store.EnableLeakDetection()
+}
+
+// Leaks returns the pages loaded since leak detection was enabled that haven't been
+// released yet, apart from the root, which stays loaded while the tree is open. Pages
+// held by open cursors are still loaded.
+func (tree *Tree) Leaks() []store.Leak {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	var leaks []store.Leak
+	for _, leak := range tree.store.Leaks() {
+		if leak.Handle != tree.root.PageHandle {
+			leaks = append(leaks, leak)
+		}
 	}
-	branch := &branchPage{Page: node}
-	branch.fromBuffer()
-	var childPageID store.PageID
-	if key < branch.keys[0] {
-		childPageID = branch.pointers[0]
-		goto foundChild
-	}
-	// Skip the last key.
-	for i := 1; i < len(branch.keys); i++ {
-		if key < branch.keys[i] {
-			childPageID = branch.pointers[i]
-			goto foundChild
+	return leaks
+}
+
+// Recovery returns what was recovered from the write-ahead log when the tree's file was
+// opened.
+func (tree *Tree) Recovery() store.RecoveryStats {
+	return tree.store.Recovery()
+}
+
+// Stats returns how the pages of the tree's file are used, see store.PageStore.Stats.
+func (tree *Tree) Stats() (store.Stats, error) {
+	return tree.store.Stats()
+}
+
+// CacheStats returns what the tree's page cache has done since the tree was opened, see
+// store.PageStore.CacheStats.
+func (tree *Tree) CacheStats() store.CacheStats {
+	return tree.store.CacheStats()
+}
+
+// KeyType returns the type of the keys recorded in the tree's file, see
+// store.PageStore.SetKeyType.
+func (tree *Tree) KeyType() uint32 {
+	return tree.store.KeyType()
+}
+
+// SetKeyType records the type of the keys stored in the tree in its file, for layers that
+// encode keys of one type, such as package typed, to check when the tree is reopened.
+func (tree *Tree) SetKeyType(keyType uint32) error {
+	return tree.store.SetKeyType(keyType)
+}
+
+// CommitLatency returns a histogram of how long the writes to the tree's file have taken
+// to commit, see store.PageStore.CommitLatency.
+func (tree *Tree) CommitLatency() store.LatencyHistogram {
+	return tree.store.CommitLatency()
+}
+
+// commit ends the group of writes the tree started with store.Begin, keeping the error
+// that stopped the change if there was one.
+func (tree *Tree) commit(err *error) {
+	if *err == nil {
+		// In copy-on-write mode the root moves to a fresh page when it's written. The
+		// root is only latched for writing to record it, so that concurrent writers that
+		// left it as it was don't wait on each other here.
+		tree.rootLatch.RLock()
+		moved := tree.root.ID != tree.recordedRoot
+		tree.rootLatch.RUnlock()
+		if moved {
+			tree.rootLatch.Lock()
+			if tree.root.ID != tree.recordedRoot {
+				*err = tree.setRoot(tree.root.ID)
+			}
+			tree.rootLatch.Unlock()
 		}
 	}
-	childPageID = branch.pointers[len(branch.pointers)-1]
-foundChild:
-	childPage, err := tree.store.Load(childPageID)
+	span := tree.startSpan("bplus.commit")
+	commitErr := tree.store.Commit()
+	span.end(&commitErr)
+	if *err == nil {
+		*err = commitErr
+	}
+}
+
+// setRoot records the page id of the root so that the tree can be found again when the
+// file is reopened.
+func (tree *Tree) setRoot(pageID store.PageID) error {
+	tree.recordedRoot = pageID
+	if tree.db != nil {
+		return tree.db.saveTree(tree.name, catalogEntry{
+			root:            pageID,
+			branchingFactor: tree.branchingFactor,
+			flags:           tree.flags,
+		})
+	}
+	return tree.store.SetRoot(pageID, tree.branchingFactor)
+}
+
+// setFlags records the options the tree was created with alongside its root.
+func (tree *Tree) setFlags(flags uint32) error {
+	if tree.store.ReadOnly() {
+		return store.ErrReadOnly
+	}
+	tree.flags = flags
+	if tree.db != nil {
+		return tree.setRoot(tree.root.ID)
+	}
+	return tree.store.SetFlags(flags)
+}
+
+func (tree *Tree) loadRootNode(pageID store.PageID) error {
+	page, err := tree.store.Load(pageID)
+	if err != nil {
+		return err
+	}
+	tree.root = &branchPage{PageHandle: page}
+	tree.root.fromBuffer()
+	return nil
+}
+
+// Read a value from the tree, return an error if it's not found.
+func (tree *Tree) Read(key Key) (value Value, err error) {
+	defer tree.startSpan("bplus.Read").end(&err)
+	tree.timedRLock()
+	defer tree.lock.RUnlock()
+	r, err := tree.lookup(key)
 	if err != nil {
 		return nil, err
 	}
-	return tree.search(key, childPage)
+	// The value is read from the value log with the lock held, so the value log can't be
+	// collected from under it.
+	r, err = tree.values.resolve(r)
+	return r.Value, err
 }
 
-// Insert a key value pair into the tree. Duplicate keys are not allowed.
-func (tree *Tree) Insert(key Key, value Value) error {
-	return errors.New("not implemented")
+// lookup returns a copy of the record of key as it's stored in its leaf, with the tree's
+// lock held. The record's key is key itself.
+func (tree *Tree) lookup(key Key) (Record, error) {
+	page, err := tree.findLeaf(key, false)
+	if err != nil {
+		return Record{}, err
+	}
+	if page == nil {
+		return Record{}, ErrKeyNotFound
+	}
+	leaf := decodeLeaf(page.PageHandle)
+	defer freeLeaf(leaf)
+	err = tree.unlatch(page)
+	if err != nil {
+		return Record{}, err
+	}
+	i, found := leaf.search(key)
+	if !found || leaf.records[i].tombstone {
+		return Record{}, ErrKeyNotFound
+	}
+	r := leaf.records[i]
+	return Record{
+		Key:     key,
+		Value:   append(Value{}, r.Value...),
+		pointer: r.pointer,
+	}, nil
 }
 
-// Delete a key value pair from the tree.
-func (tree *Tree) Delete(key Key) error {
-	return errors.New("not implemented")
+// release gives a page back to the page cache once the tree is done with it. The root
+// stays pinned for the lifetime of the tree by the Load in loadRootNode, so releasing it
+// after loading it again leaves it in the cache.
+func (tree *Tree) release(page *store.PageHandle) error {
+	return page.Release()
 }
 
 type leafPage struct {
-	*store.Page
+	*store.PageHandle
+	rightLink
 	records []Record
+	// arena is the copy of the page the records' keys and values are sliced out of.
+	arena []byte
+}
+
+// search returns the index of the first record whose key is not less than key, and
+// whether that record's key is equal to key.
+func (p *leafPage) search(key Key) (int, bool) {
+	i := sort.Search(len(p.records), func(i int) bool {
+		return bytes.Compare(p.records[i].Key, key) >= 0
+	})
+	return i, i < len(p.records) && bytes.Equal(p.records[i].Key, key)
+}
+
+// widePageFlag is set in the identifier byte of pages that store page ids and counts in
+// 64 bits, which are written in files in store.FormatVersion2 and later. Pages without it
+// store them in 32 bits.
+const widePageFlag = 4
+
+func isLeafPage(page *store.PageHandle) bool {
+	return page.Contents()[0]&1 == 1
+}
+
+// wide reports whether the tree's file stores page ids in 64 bits.
+func (tree *Tree) wide() bool {
+	return tree.store.PageIDSize() == 8
+}
+
+// pageIDSize returns the number of bytes a page id or count takes up in a page.
+func pageIDSize(wide bool) int {
+	if wide {
+		return 8
+	}
+	return 4
+}
+
+func pageIDToBuffer(buf []byte, pageID store.PageID, wide bool) int {
+	return uintToBuffer(buf, uint64(pageID), wide)
 }
 
-func isLeafPage(page *store.Page) bool {
-	return page.Buf[0] == 1
+func pageIDFromBuffer(buf []byte, wide bool) (store.PageID, int) {
+	n, size := uintFromBuffer(buf, wide)
+	return store.PageID(n), size
+}
+
+func uintToBuffer(buf []byte, n uint64, wide bool) int {
+	if wide {
+		binary.LittleEndian.PutUint64(buf, n)
+		return 8
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(n))
+	return 4
+}
+
+func uintFromBuffer(buf []byte, wide bool) (uint64, int) {
+	if wide {
+		return binary.LittleEndian.Uint64(buf), 8
+	}
+	return uint64(binary.LittleEndian.Uint32(buf)), 4
 }
 
 func (p *leafPage) toBuffer() {
 	p.Buf[0] = 1
+	p.linkToBuffer(p.Contents())
 	binary.LittleEndian.PutUint32(p.Buf[1:5], uint32(len(p.records)))
 	current := 5
 	for _, r := range p.records {
 		current += keyToBuffer(p.Buf[current:], r.Key)
+		if r.tombstone {
+			binary.LittleEndian.PutUint32(p.Buf[current:], tombstoneLength)
+			current += 4
+			continue
+		}
+		if r.pointer {
+			binary.LittleEndian.PutUint32(p.Buf[current:], valuePointerLength)
+			current += 4 + copy(p.Buf[current+4:], r.Value)
+			continue
+		}
 		current += valueToBuffer(p.Buf[current:], r.Value)
 	}
 }
 
 func keyToBuffer(buf []byte, key Key) int {
-	binary.LittleEndian.PutUint32(buf[0:4], uint32(key))
-	return 4
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(key)))
+	copy(buf[4:], key)
+	return 4 + len(key)
 }
 
 func valueToBuffer(buf []byte, value Value) int {
@@ -147,71 +651,195 @@ func valueToBuffer(buf []byte, value Value) int {
 }
 
 func (p *leafPage) fromBuffer() {
+	p.records, p.arena = nil, nil
+	p.decode()
+}
+
+// decode decodes the leaf, slicing its keys and values out of a copy of the page so that
+// they take a single allocation between them rather than one each. The records and copy
+// of the last page decoded into p are reused, so nothing may still refer to them, see
+// decodeLeaf.
+func (p *leafPage) decode() {
 	// Skip first byte because it's the leaf page identifier.
-	numRecords := binary.LittleEndian.Uint32(p.Buf[1:5])
-	p.records = make([]Record, numRecords)
+	p.linkFromBuffer(p.Contents())
+	p.arena = append(p.arena[:0], p.Contents()...)
+	buf := p.arena
+	p.records = resize(p.records, int(binary.LittleEndian.Uint32(buf[1:5])))
 	current := 5
+	var b []byte
 	var n int
-	for i := 0; i < int(numRecords); i++ {
-		p.records[i].Key, n = keyFromBuffer(p.Buf[current:])
+	for i := range p.records {
+		b, n = sliceFromBuffer(buf[current:])
+		p.records[i].Key = Key(b)
 		current += n
-		p.records[i].Value, n = valueFromBuffer(p.Buf[current:])
+		switch binary.LittleEndian.Uint32(buf[current:]) {
+		case tombstoneLength:
+			p.records[i].tombstone = true
+			current += 4
+		case valuePointerLength:
+			p.records[i].pointer = true
+			end := current + 4 + valuePointerSize
+			p.records[i].Value = Value(buf[current+4 : end : end])
+			current = end
+		default:
+			b, n = sliceFromBuffer(buf[current:])
+			p.records[i].Value = Value(b)
+			current += n
+		}
+	}
+}
+
+// leafValue finds the value of key in a leaf without decoding the leaf, and returns it as
+// a slice of the page rather than a copy, along with whether it points into the value
+// log. It returns false if the key isn't in the leaf or has been deleted.
+func leafValue(page *store.PageHandle, key Key) (Value, bool, bool) {
+	buf := page.Contents()
+	numRecords := int(binary.LittleEndian.Uint32(buf[1:5]))
+	current := 5
+	for i := 0; i < numRecords; i++ {
+		keyLen := int(binary.LittleEndian.Uint32(buf[current:]))
+		c := bytes.Compare(buf[current+4:current+4+keyLen], key)
+		if c > 0 {
+			break
+		}
+		current += 4 + keyLen
+		length := binary.LittleEndian.Uint32(buf[current:])
+		current += 4
+		var n int
+		switch length {
+		case tombstoneLength:
+		case valuePointerLength:
+			n = valuePointerSize
+		default:
+			n = int(length)
+		}
+		if c == 0 {
+			// The capacity is cut down so that appending to the value can't write over the
+			// page.
+			value := Value(buf[current : current+n : current+n])
+			return value, length == valuePointerLength, length != tombstoneLength
+		}
 		current += n
 	}
+	return nil, false, false
 }
 
 func keyFromBuffer(buf []byte) (Key, int) {
-	key := Key(binary.LittleEndian.Uint32(buf[0:4]))
-	return key, 4
+	keyLen := int(binary.LittleEndian.Uint32(buf[0:4]))
+	key := Key(make([]byte, keyLen))
+	copy(key, buf[4:4+keyLen])
+	return key, keyLen + 4
 }
 
-func valueFromBuffer(buf []byte) (Value, int) {
-	valueLen := int(binary.LittleEndian.Uint32(buf[0:4]))
-	value := Value(make([]byte, valueLen))
-	for i := 0; i < valueLen; i++ {
-		value[i] = buf[i+4]
+// sliceFromBuffer returns the length prefixed bytes at the start of buf as a slice of buf
+// rather than a copy, with its capacity cut down so that appending to it can't write over
+// what follows it.
+func sliceFromBuffer(buf []byte) ([]byte, int) {
+	n := 4 + int(binary.LittleEndian.Uint32(buf[0:4]))
+	return buf[4:n:n], n
+}
+
+// resize returns a zeroed slice of length n, reusing s if it's large enough.
+func resize[T any](s []T, n int) []T {
+	if cap(s) < n {
+		return make([]T, n)
 	}
-	return value, valueLen + 4
+	s = s[:n]
+	clear(s)
+	return s
 }
 
 type branchPage struct {
-	*store.Page
+	*store.PageHandle
+	rightLink
 	keys     []Key
 	pointers []store.PageID
+	// counts holds the number of records in the subtree under each pointer.
+	counts []int
+	// arena is the copy of the page the keys are sliced out of.
+	arena []byte
+}
+
+// count returns the number of records in the subtree rooted at the branch.
+func (p *branchPage) count() int {
+	count := 0
+	for _, c := range p.counts {
+		count += c
+	}
+	return count
+}
+
+// pageCount returns the number of records in the subtree rooted at a page.
+func pageCount(page *store.PageHandle) int {
+	if isLeafPage(page) {
+		leaf := &leafPage{PageHandle: page}
+		leaf.fromBuffer()
+		return liveCount(leaf.records)
+	}
+	branch := &branchPage{PageHandle: page}
+	branch.fromBuffer()
+	return branch.count()
+}
+
+// childIndex returns the index of the pointer to follow when looking for key.
+func (p *branchPage) childIndex(key Key) int {
+	return sort.Search(len(p.keys), func(i int) bool {
+		return bytes.Compare(key, p.keys[i]) < 0
+	})
 }
 
 func (p *branchPage) toBuffer() {
 	p.Buf[0] = 0
+	p.linkToBuffer(p.Contents())
 	binary.LittleEndian.PutUint32(p.Buf[1:5], uint32(len(p.keys)))
 	current := 5
 	for _, key := range p.keys {
-		binary.LittleEndian.PutUint32(p.Buf[current:], uint32(key))
-		current += 4
+		current += keyToBuffer(p.Buf[current:], key)
 	}
 	binary.LittleEndian.PutUint32(p.Buf[current:], uint32(len(p.pointers)))
 	current += 4
 	for _, pointer := range p.pointers {
-		binary.LittleEndian.PutUint32(p.Buf[current:], uint32(pointer))
-		current += 4
+		current += pageIDToBuffer(p.Buf[current:], pointer, p.wide)
+	}
+	for _, count := range p.counts {
+		current += uintToBuffer(p.Buf[current:], uint64(count), p.wide)
 	}
 }
 
 func (p *branchPage) fromBuffer() {
+	p.keys, p.pointers, p.counts, p.arena = nil, nil, nil, nil
+	p.decode()
+}
+
+// decode decodes the branch, slicing its keys out of a copy of the page like
+// leafPage.decode, and reusing what was decoded into p last, see decodeBranch.
+func (p *branchPage) decode() {
 	// Skip first leaf identifier byte.
-	numKeys := binary.LittleEndian.Uint32(p.Buf[1:5])
-	p.keys = make([]Key, numKeys)
+	p.linkFromBuffer(p.Contents())
+	p.arena = append(p.arena[:0], p.Contents()...)
+	buf := p.arena
+	p.keys = resize(p.keys, int(binary.LittleEndian.Uint32(buf[1:5])))
 	current := 5
-	for i := 0; i < int(numKeys); i++ {
-		key := Key(binary.LittleEndian.Uint32(p.Buf[current:]))
-		p.keys[i] = key
-		current += 4
+	var b []byte
+	var n int
+	for i := range p.keys {
+		b, n = sliceFromBuffer(buf[current:])
+		p.keys[i] = Key(b)
+		current += n
 	}
-	numPointers := binary.LittleEndian.Uint32(p.Buf[current:])
+	numPointers := int(binary.LittleEndian.Uint32(buf[current:]))
 	current += 4
-	p.pointers = make([]store.PageID, numPointers)
-	for i := 0; i < int(numPointers); i++ {
-		pointer := store.PageID(binary.LittleEndian.Uint32(p.Buf[current:]))
-		p.pointers[i] = pointer
-		current += 4
+	p.pointers = resize(p.pointers, numPointers)
+	for i := range p.pointers {
+		p.pointers[i], n = pageIDFromBuffer(buf[current:], p.wide)
+		current += n
+	}
+	// There is a count for every pointer.
+	p.counts = resize(p.counts, numPointers)
+	for i := range p.counts {
+		var count uint64
+		count, n = uintFromBuffer(buf[current:], p.wide)
+		p.counts[i] = int(count)
+		current += n
 	}
 }
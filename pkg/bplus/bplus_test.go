@@ -1,7 +1,10 @@
 package bplus
 
 import (
+	"bytes"
 	"io/ioutil"
+	"os"
+	"strconv"
 	"testing"
 
 	"github.com/jpittis/bplus/pkg/store"
@@ -10,14 +13,26 @@ import (
 // Let's manually build a B+ tree that we know is in the correct format and use that to
 // test our search and read functionality.
 func TestBPlusTree(t *testing.T) {
-	tree, err := newTree("b_plus_tree", 4, 20)
+	tmpfile, err := ioutil.TempFile("", "b_plus_tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	// The pages are laid out from page 1 on, which files with a free-space map keep the
+	// map in.
+	tree, err := NewTree(
+		tmpfile.Name(),
+		WithBranchingFactor(4),
+		WithCacheSize(20),
+		WithFormatVersion(store.FormatVersion2),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Before we do anything, let's make sure an empty tree returns an err on read rather
 	// than crashing.
-	value, err := tree.Read(Key(0))
+	value, err := tree.Read(Key{0})
 	if err != ErrKeyNotFound {
 		t.Fatalf("found expected value %+v", value)
 	}
@@ -44,10 +59,10 @@ func TestBPlusTree(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	leaf12 := &leafPage{Page: leaf12Page}
+	leaf12 := &leafPage{PageHandle: leaf12Page}
 	leaf12.records = []Record{
-		{Key: 1, Value: []byte{1}},
-		{Key: 2, Value: []byte{2}},
+		{Key: Key{1}, Value: []byte{1}},
+		{Key: Key{2}, Value: []byte{2}},
 	}
 	leaf12.toBuffer()
 
@@ -55,10 +70,10 @@ func TestBPlusTree(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	leaf34 := &leafPage{Page: leaf34Page}
+	leaf34 := &leafPage{PageHandle: leaf34Page}
 	leaf34.records = []Record{
-		{Key: 3, Value: []byte{3}},
-		{Key: 4, Value: []byte{4}},
+		{Key: Key{3}, Value: []byte{3}},
+		{Key: Key{4}, Value: []byte{4}},
 	}
 	leaf34.toBuffer()
 
@@ -66,10 +81,10 @@ func TestBPlusTree(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	leaf56 := &leafPage{Page: leaf56Page}
+	leaf56 := &leafPage{PageHandle: leaf56Page}
 	leaf56.records = []Record{
-		{Key: 5, Value: []byte{5}},
-		{Key: 6, Value: []byte{6}},
+		{Key: Key{5}, Value: []byte{5}},
+		{Key: Key{6}, Value: []byte{6}},
 	}
 	leaf56.toBuffer()
 
@@ -77,10 +92,10 @@ func TestBPlusTree(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	leaf78 := &leafPage{Page: leaf78Page}
+	leaf78 := &leafPage{PageHandle: leaf78Page}
 	leaf78.records = []Record{
-		{Key: 7, Value: []byte{7}},
-		{Key: 8, Value: []byte{8}},
+		{Key: Key{7}, Value: []byte{7}},
+		{Key: Key{8}, Value: []byte{8}},
 	}
 	leaf78.toBuffer()
 
@@ -88,10 +103,10 @@ func TestBPlusTree(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	leaf910 := &leafPage{Page: leaf910Page}
+	leaf910 := &leafPage{PageHandle: leaf910Page}
 	leaf910.records = []Record{
-		{Key: 9, Value: []byte{9}},
-		{Key: 10, Value: []byte{10}},
+		{Key: Key{9}, Value: []byte{9}},
+		{Key: Key{10}, Value: []byte{10}},
 	}
 	leaf910.toBuffer()
 
@@ -100,29 +115,40 @@ func TestBPlusTree(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	branch35 := &branchPage{Page: branch35Page}
-	branch35.keys = []Key{3, 5}
+	branch35 := &branchPage{PageHandle: branch35Page}
+	branch35.keys = []Key{{3}, {5}}
 	branch35.pointers = []store.PageID{2, 3, 4}
+	branch35.counts = []int{2, 2, 2}
 	branch35.toBuffer()
 
 	branch9Page, err := tree.store.Load(store.PageID(8))
 	if err != nil {
 		t.Fatal(err)
 	}
-	branch9 := &branchPage{Page: branch9Page}
-	branch9.keys = []Key{9}
+	branch9 := &branchPage{PageHandle: branch9Page}
+	branch9.keys = []Key{{9}}
 	branch9.pointers = []store.PageID{5, 6}
+	branch9.counts = []int{2, 2}
 	branch9.toBuffer()
 
 	// And finally the root node.
 	root := tree.root
-	root.keys = []Key{7}
+	root.keys = []Key{{7}}
 	root.pointers = []store.PageID{7, 8}
+	root.counts = []int{6, 4}
 	root.toBuffer()
 
+	// Reads release the pages they touch, so the pages need to be written to disk.
+	for id := 1; id < 9; id++ {
+		err = tree.store.Write(store.PageID(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	// Search for all the keys and make sure they're found.
 	for key := 1; key < 11; key++ {
-		value, err := tree.Read(Key(key))
+		value, err := tree.Read(Key{byte(key)})
 		if err != nil {
 			t.Fatal(key, err)
 		}
@@ -131,21 +157,494 @@ func TestBPlusTree(t *testing.T) {
 		}
 	}
 	// Test that we can't find some keys that shoudn't be in the tree.
-	value, err = tree.Read(Key(0))
+	value, err = tree.Read(Key{0})
 	if err != ErrKeyNotFound {
 		t.Fatalf("found expected value %+v", value)
 	}
-	value, err = tree.Read(Key(11))
+	value, err = tree.Read(Key{11})
 	if err != ErrKeyNotFound {
 		t.Fatalf("found expected value %+v", value)
 	}
 }
 
+func TestOpenTreeReattachesToExistingTree(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "open_tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A root with a single key pointing at two leaves.
+	leftID, err := tree.store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rightID, err := tree.store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftPage, err := tree.store.Load(leftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := &leafPage{PageHandle: leftPage}
+	left.records = []Record{{Key: Key{1}, Value: []byte{1}}}
+	left.toBuffer()
+	rightPage, err := tree.store.Load(rightID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	right := &leafPage{PageHandle: rightPage}
+	right.records = []Record{{Key: Key{2}, Value: []byte{2}}}
+	right.toBuffer()
+	tree.root.keys = []Key{{2}}
+	tree.root.pointers = []store.PageID{leftID, rightID}
+	tree.root.counts = []int{1, 1}
+	tree.root.toBuffer()
+	for _, id := range []store.PageID{leftID, rightID, tree.root.ID} {
+		err = tree.store.Write(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	crash(t, tree)
+	_, err = NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != ErrTreeExists {
+		t.Fatalf("expected %v == %v", err, ErrTreeExists)
+	}
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.branchingFactor != 4 {
+		t.Fatalf("expected %d == 4", reopened.branchingFactor)
+	}
+	for key := 1; key < 3; key++ {
+		value, err := reopened.Read(Key{byte(key)})
+		if err != nil {
+			t.Fatal(key, err)
+		}
+		if int(value[0]) != key {
+			t.Fatalf("expected %d == %d", value[0], key)
+		}
+	}
+}
+
+func TestOpenTreeWithoutTree(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "open_tree_without_tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	_, err = OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != ErrTreeNotFound {
+		t.Fatalf("expected %v == %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestTreeWithWAL(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "tree_with_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(50), intKey(150)); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	crash(t, tree)
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 100 {
+		t.Fatalf("expected %v == %v", reopened.Len(), 100)
+	}
+	verifyTree(t, reopened)
+}
+
+func TestTreeWithCheckpoints(t *testing.T) {
+	filename := tempFilename(t, "tree_with_checkpoints")
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".wal")
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithCacheSize(20),
+		WithCheckpoints(1<<20, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < 150; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crash(t, tree)
+	reopened, err := OpenTree(filename, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	// The inserts made since the checkpoint are replayed from the log.
+	if reopened.Recovery().RecordsReplayed == 0 {
+		t.Fatalf("expected the inserts since the checkpoint to be replayed")
+	}
+	if reopened.Len() != 150 {
+		t.Fatalf("expected %v == %v", reopened.Len(), 150)
+	}
+	verifyTree(t, reopened)
+}
+
+func TestTreeClose(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "tree_close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(100), intKey(200)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Read(intKey(1)); err != store.ErrClosed {
+		t.Fatalf("expected %v == %v", err, store.ErrClosed)
+	}
+	if err := tree.Insert(intKey(1000), intValue(1000)); err != store.ErrClosed {
+		t.Fatalf("expected %v == %v", err, store.ErrClosed)
+	}
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Recovery().ClosedCleanly {
+		t.Fatal("expected the tree to have been closed cleanly")
+	}
+	if reopened.Len() != 100 {
+		t.Fatalf("expected %v == %v", reopened.Len(), 100)
+	}
+	verifyTree(t, reopened)
+}
+
+func TestTreeReleasesEveryPage(t *testing.T) {
+	tree, err := newTree("tree_releases_every_page", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.EnableLeakDetection()
+	for i := 0; i < 300; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 300; i += 3 {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(100), intKey(150)); err != nil {
+		t.Fatal(err)
+	}
+	b := &WriteBatch{}
+	for i := 0; i < 50; i++ {
+		b.Put(intKey(i), intValue(-i))
+	}
+	if err := tree.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Read(intKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	for range tree.Ascend(intKey(200)).Records() {
+	}
+	c := tree.Cursor()
+	if _, err := c.Seek(intKey(10)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Leaks()) != 1 {
+		t.Fatalf("expected %v == %v", len(tree.Leaks()), 1)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if leaks := tree.Leaks(); len(leaks) != 0 {
+		t.Fatalf("expected no leaks, got page %d loaded at:\n%s", leaks[0].PageID,
+			leaks[0].Stack)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryTree(t *testing.T) {
+	tree, err := NewMemoryTree(WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.EnableWAL(); err != store.ErrNoFile {
+		t.Fatalf("expected %v == %v", err, store.ErrNoFile)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(100), intKey(900)); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	if tree.Len() != 200 {
+		t.Fatalf("expected %v == %v", tree.Len(), 200)
+	}
+	value, err := tree.Read(intKey(950))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(950)) {
+		t.Fatalf("expected %v == %v", value, intValue(950))
+	}
+}
+
+func TestBackendTree(t *testing.T) {
+	backend := store.NewMemoryBackend()
+	tree, err := NewBackendTree(backend, WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBackendTree(backend); err != ErrTreeExists {
+		t.Fatalf("expected %v == %v", err, ErrTreeExists)
+	}
+	_, err = OpenBackendTree(backend, WithValueLog(64, 0))
+	if err != ErrValueLogUnsupported {
+		t.Fatalf("expected %v == %v", err, ErrValueLogUnsupported)
+	}
+
+	tree, err = OpenBackendTree(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	verifyTree(t, tree)
+	if tree.Len() != 100 {
+		t.Fatalf("expected %v == %v", tree.Len(), 100)
+	}
+	if _, err := OpenBackendTree(store.NewMemoryBackend()); err != ErrTreeNotFound {
+		t.Fatalf("expected %v == %v", err, ErrTreeNotFound)
+	}
+}
+
+// crash abandons the file of the tree as if the process had died, so that it can be
+// opened again.
+func crash(t *testing.T, tree *Tree) {
+	t.Helper()
+	if err := tree.store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func newTree(filename string, branchingFactor, cacheCapacity int) (*Tree, error) {
 	tmpfile, err := ioutil.TempFile("", filename)
 	if err != nil {
 		return nil, err
 	}
 	tmpfile.Close()
-	return NewTree(tmpfile.Name(), branchingFactor, cacheCapacity)
+	return NewTree(
+		tmpfile.Name(),
+		WithBranchingFactor(branchingFactor),
+		WithCacheSize(cacheCapacity),
+	)
+}
+
+func TestBranchPageWidths(t *testing.T) {
+	for _, wide := range []bool{false, true} {
+		for _, blink := range []bool{false, true} {
+			branch := &branchPage{
+				PageHandle: &store.PageHandle{Page: &store.Page{}},
+				rightLink:  rightLink{blink: blink, wide: wide, link: 9, high: intKey(7)},
+				keys:       []Key{intKey(1), intKey(5)},
+				pointers:   []store.PageID{3, 1<<31 + 4, 6},
+				counts:     []int{10, 20, 30},
+			}
+			if !blink {
+				branch.rightLink = rightLink{wide: wide}
+			}
+			branch.toBuffer()
+			decoded := &branchPage{PageHandle: branch.PageHandle}
+			decoded.fromBuffer()
+			if decoded.wide != wide || decoded.blink != blink {
+				t.Fatalf("expected %v == %v", decoded.rightLink, branch.rightLink)
+			}
+			if !sameLink(decoded.rightLink, branch.rightLink) {
+				t.Fatalf("expected %v == %v", decoded.rightLink, branch.rightLink)
+			}
+			for i, pointer := range branch.pointers {
+				if decoded.pointers[i] != pointer || decoded.counts[i] != branch.counts[i] {
+					t.Fatalf("expected %v == %v", decoded.pointers, branch.pointers)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkLeafSearch(b *testing.B) {
+	for _, n := range []int{16, 128, 1024} {
+		leaf := &leafPage{records: make([]Record, n)}
+		for i := range leaf.records {
+			leaf.records[i] = Record{Key: intKey(i * 2)}
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				leaf.search(intKey(i % (n * 2)))
+			}
+		})
+	}
+}
+
+func BenchmarkChildIndex(b *testing.B) {
+	for _, n := range []int{16, 128, 1024} {
+		branch := &branchPage{keys: make([]Key, n)}
+		for i := range branch.keys {
+			branch.keys[i] = intKey(i * 2)
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				branch.childIndex(intKey(i % (n * 2)))
+			}
+		})
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	for _, bf := range []int{16, 256} {
+		tree, err := newTree("benchmark_read", bf, 64)
+		if err != nil {
+			b.Fatal(err)
+		}
+		records := make([]Record, 100000)
+		for i := range records {
+			records[i] = Record{Key: intKey(i), Value: intValue(i)}
+		}
+		if err := tree.BulkLoad(records, 1); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(strconv.Itoa(bf), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := tree.Read(intKey(i % len(records))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTreeLargerThanCache(t *testing.T) {
+	tree, err := newTree("larger_than_cache", 4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2000; i += 3 {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		_, err := tree.Read(intKey(i))
+		if i%3 == 0 && err != ErrKeyNotFound {
+			t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+		}
+		if i%3 != 0 && err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	verifyTree(t, tree)
+}
+
+func TestTreeShrink(t *testing.T) {
+	tree, err := newTree("tree_shrink", 4, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 2000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deleting from the middle frees pages inside the file.
+	if _, err := tree.DeleteRange(intKey(500), intKey(1500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Shrink(true); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	// The tree grows into the punched pages again.
+	for i := 500; i < 1500; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyTree(t, tree)
+	for i := 0; i < 2000; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
 }
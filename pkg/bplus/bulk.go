@@ -0,0 +1,220 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+var (
+	// ErrTreeNotEmpty is returned when bulk loading into a tree that already has records.
+	ErrTreeNotEmpty = errors.New("tree not empty")
+	// ErrRecordsNotSorted is returned when bulk loading records whose keys are not in
+	// strictly increasing order.
+	ErrRecordsNotSorted = errors.New("records not sorted")
+	// ErrInvalidFillFactor is returned when a fill factor is not within (0, 1].
+	ErrInvalidFillFactor = errors.New("invalid fill factor")
+)
+
+// BulkLoad builds the tree bottom up from records sorted by key. Pages are packed until
+// they are fillFactor full, by both the branching factor and the page size, which is much
+// faster than repeated inserts and produces a denser file. The tree must be empty.
+func (tree *Tree) BulkLoad(records []Record, fillFactor float64) (err error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.store.ReadOnly() {
+		return store.ErrReadOnly
+	}
+	if fillFactor <= 0 || fillFactor > 1 {
+		return ErrInvalidFillFactor
+	}
+	if len(tree.root.pointers) != 0 {
+		return ErrTreeNotEmpty
+	}
+	for i, r := range records {
+		if len(r.Key) > MaxKeySize {
+			return ErrKeyTooLarge
+		}
+		if !tree.fits(r) {
+			return ErrRecordTooLarge
+		}
+		if i > 0 && bytes.Compare(records[i-1].Key, r.Key) >= 0 {
+			return ErrRecordsNotSorted
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	events := tree.loadEvents(records)
+	err = tree.before(events)
+	if err != nil {
+		return err
+	}
+	records, err = tree.logRecordValues(records)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			tree.after(events)
+		}
+	}()
+	tree.version.Add(1)
+	tree.store.Begin()
+	defer tree.commit(&err)
+
+	// Each level is described by the smallest key, page id, and number of records of each
+	// of its pages.
+	level, err := tree.bulkLoadLeaves(records, fillFactor)
+	if err != nil {
+		return err
+	}
+	for !tree.fitsInRoot(level.keys) {
+		level, err = tree.bulkLoadBranches(level, fillFactor)
+		if err != nil {
+			return err
+		}
+	}
+	tree.root.keys = level.keys[1:]
+	tree.root.pointers = level.pointers
+	tree.root.counts = level.counts
+	err = tree.writeBranch(tree.root)
+	if err != nil || !tree.blink() {
+		return err
+	}
+	return tree.relink(nil, nil)
+}
+
+// bulkLevel describes the pages of one level of a tree being bulk loaded.
+type bulkLevel struct {
+	keys     []Key
+	pointers []store.PageID
+	counts   []int
+}
+
+func (tree *Tree) bulkLoadLeaves(
+	records []Record,
+	fillFactor float64,
+) (bulkLevel, error) {
+	sizes := make([]int, len(records))
+	for i, r := range records {
+		sizes[i] = r.size()
+	}
+	headerSize := leafHeaderSize + tree.trailerSize()
+	groups := pack(sizes, headerSize, tree.branchingFactor-1, 1, fillFactor)
+	var level bulkLevel
+	for _, g := range groups {
+		leaf, err := tree.allocateLeaf()
+		if err != nil {
+			return level, err
+		}
+		leaf.records = records[g.start:g.end]
+		err = tree.writeLeaf(leaf)
+		if err != nil {
+			return level, err
+		}
+		level.keys = append(level.keys, leaf.records[0].Key)
+		level.pointers = append(level.pointers, leaf.ID)
+		level.counts = append(level.counts, len(leaf.records))
+		err = tree.release(leaf.PageHandle)
+		if err != nil {
+			return level, err
+		}
+	}
+	return level, nil
+}
+
+func (tree *Tree) bulkLoadBranches(
+	children bulkLevel,
+	fillFactor float64,
+) (bulkLevel, error) {
+	sizes := make([]int, len(children.keys))
+	for i, k := range children.keys {
+		sizes[i] = branchEntrySize(k)
+	}
+	headerSize := branchHeaderSize + tree.trailerSize()
+	groups := pack(sizes, headerSize, tree.branchingFactor, 2, fillFactor)
+	var level bulkLevel
+	for _, g := range groups {
+		branch, err := tree.allocateBranch()
+		if err != nil {
+			return level, err
+		}
+		// The smallest key of the first child is implied by the key in the parent.
+		branch.keys = children.keys[g.start+1 : g.end]
+		branch.pointers = children.pointers[g.start:g.end]
+		branch.counts = children.counts[g.start:g.end]
+		err = tree.writeBranch(branch)
+		if err != nil {
+			return level, err
+		}
+		level.keys = append(level.keys, children.keys[g.start])
+		level.pointers = append(level.pointers, branch.ID)
+		level.counts = append(level.counts, branch.count())
+		err = tree.release(branch.PageHandle)
+		if err != nil {
+			return level, err
+		}
+	}
+	return level, nil
+}
+
+// fitsInRoot reports whether the root can point directly at pages with the given
+// smallest keys.
+func (tree *Tree) fitsInRoot(keys []Key) bool {
+	size := branchHeaderSize
+	for _, k := range keys[1:] {
+		size += branchEntrySize(k)
+	}
+	return !tree.overflows(len(keys)-1, size)
+}
+
+type group struct {
+	start, end int
+}
+
+// pack greedily groups entries with the given encoded sizes into pages holding at most
+// fillFactor of maxEntries entries and fillFactor of a page, with at least minEntries
+// entries each. If the last page ends up less than half as full as the page before it,
+// the two are evened out.
+func pack(
+	sizes []int,
+	headerSize, maxEntries, minEntries int,
+	fillFactor float64,
+) []group {
+	targetEntries := int(fillFactor * float64(maxEntries))
+	if targetEntries < minEntries {
+		targetEntries = minEntries
+	}
+	targetSize := int(fillFactor * float64(store.UsablePageSize))
+	var groups []group
+	start, size := 0, headerSize
+	for i, s := range sizes {
+		full := i-start >= targetEntries || size+s > targetSize
+		if full && i-start >= minEntries {
+			groups = append(groups, group{start, i})
+			start, size = i, headerSize
+		}
+		size += s
+	}
+	groups = append(groups, group{start, len(sizes)})
+
+	n := len(groups)
+	if n < 2 {
+		return groups
+	}
+	last, prev := groups[n-1], groups[n-2]
+	if (last.end-last.start)*2 < prev.end-prev.start {
+		mid := prev.start + splitIndex(sizes[prev.start:last.end], headerSize)
+		if mid-prev.start < minEntries || last.end-mid < minEntries {
+			// Too few entries to go around, so the last page is folded into the one
+			// before it.
+			groups[n-2].end = last.end
+			return groups[:n-1]
+		}
+		groups[n-2].end = mid
+		groups[n-1].start = mid
+	}
+	return groups
+}
@@ -0,0 +1,88 @@
+package bplus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	for _, fillFactor := range []float64{0.5, 0.9, 1} {
+		tree, err := newTree("bulk_load", 8, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := make([]Record, 5000)
+		for i := range records {
+			records[i] = Record{Key: intKey(i * 2), Value: intValue(i * 2)}
+		}
+		err = tree.BulkLoad(records, fillFactor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i++ {
+			value, err := tree.Read(intKey(i))
+			if i%2 == 1 {
+				if err != ErrKeyNotFound {
+					t.Fatalf("found unexpected value %+v for %d", value, i)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(i, err)
+			}
+			if !bytes.Equal(value, intValue(i)) {
+				t.Fatalf("expected %v == %v", value, intValue(i))
+			}
+		}
+		// The bulk loaded tree should keep working with regular inserts.
+		for i := 1; i < 200; i += 2 {
+			err := tree.Insert(intKey(i), intValue(i))
+			if err != nil {
+				t.Fatal(i, err)
+			}
+		}
+		for i := 0; i < 200; i++ {
+			if _, err := tree.Read(intKey(i)); err != nil {
+				t.Fatal(i, err)
+			}
+		}
+	}
+}
+
+func TestBulkLoadSmall(t *testing.T) {
+	tree, err := newTree("bulk_load_small", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.BulkLoad([]Record{{Key: intKey(1), Value: intValue(1)}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := tree.Read(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(1)) {
+		t.Fatalf("expected %v == %v", value, intValue(1))
+	}
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+	tree, err := newTree("bulk_load_errors", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsorted := []Record{{Key: intKey(2)}, {Key: intKey(1)}}
+	if err := tree.BulkLoad(unsorted, 1); err != ErrRecordsNotSorted {
+		t.Fatalf("expected %v == %v", err, ErrRecordsNotSorted)
+	}
+	if err := tree.BulkLoad(nil, 0); err != ErrInvalidFillFactor {
+		t.Fatalf("expected %v == %v", err, ErrInvalidFillFactor)
+	}
+	if err := tree.Insert(intKey(1), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.BulkLoad([]Record{{Key: intKey(2)}}, 1); err != ErrTreeNotEmpty {
+		t.Fatalf("expected %v == %v", err, ErrTreeNotEmpty)
+	}
+}
@@ -0,0 +1,104 @@
+package bplus
+
+// copyFillFactor is how full Copy packs pages, leaving a little room in each so that the
+// first writes to the copy don't split every page they touch.
+const copyFillFactor = 0.9
+
+// Copy rewrites the tree, multimap or database in the file src into the file dst, which
+// must not hold a tree yet. The copy is written in store.CurrentFormatVersion, or the
+// version given by WithFormatVersion, and keeps the branching factor and options every
+// tree was created with. The other options configure how both files are opened.
+//
+// Every tree is rebuilt with BulkLoad from its records, so the copy is densely packed and
+// has none of the free pages or tombstones of src, but all of the records of a tree are
+// held in memory while it's copied.
+func Copy(src, dst string, opts ...Option) (err error) {
+	from, err := openTree(src, opts)
+	if err != nil {
+		return err
+	}
+	defer closeTree(from, &err)
+	to, err := NewTree(dst, opts...)
+	if err != nil {
+		return err
+	}
+	defer closeTree(to, &err)
+	if from.values != nil && to.values == nil {
+		// The copy keeps its large values in a value log of its own.
+		err = to.useValueLog(dst, options{
+			valueThreshold:      from.values.threshold,
+			valueLogSegmentSize: from.values.segmentSize,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if from.flags&dbFlag == 0 {
+		return copyTree(from, to)
+	}
+	// The catalog maps names to roots in src, so rather than being copied itself it's
+	// rebuilt by creating every tree again in dst.
+	err = copySettings(from, to)
+	if err != nil {
+		return err
+	}
+	fromDB := &DB{catalog: from, trees: map[string]*Tree{}}
+	toDB := &DB{catalog: to, trees: map[string]*Tree{}}
+	names, err := fromDB.TreeNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fromTree, err := fromDB.OpenTree(name)
+		if err != nil {
+			return err
+		}
+		toTree, err := toDB.CreateTree(name)
+		if err != nil {
+			return err
+		}
+		err = copyTree(fromTree, toTree)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree bulk loads every record of from into the empty tree to.
+func copyTree(from, to *Tree) error {
+	var records []Record
+	it := from.All()
+	for key, value := range it.Records() {
+		records = append(records, Record{Key: key, Value: value})
+	}
+	err := it.Err()
+	if err != nil {
+		return err
+	}
+	err = copySettings(from, to)
+	if err != nil {
+		return err
+	}
+	return to.BulkLoad(records, copyFillFactor)
+}
+
+// copySettings gives to the branching factor and flags of from.
+func copySettings(from, to *Tree) error {
+	// The branching factor is recorded along with the root.
+	to.branchingFactor = from.branchingFactor
+	err := to.setRoot(to.root.ID)
+	if err != nil {
+		return err
+	}
+	return to.setFlags(from.flags)
+}
+
+// closeTree closes the tree, returning the error it fails with through err unless err
+// already holds one.
+func closeTree(tree *Tree, err *error) {
+	closeErr := tree.Close()
+	if *err == nil {
+		*err = closeErr
+	}
+}
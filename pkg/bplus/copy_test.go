@@ -0,0 +1,149 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestCopyTree(t *testing.T) {
+	constructors := map[string]func(string, ...Option) (*Tree, error){
+		"plain": NewTree,
+		"blink": NewBLinkTree,
+	}
+	for name, newTree := range constructors {
+		t.Run(name, func(t *testing.T) {
+			src := tempFilename(t, "copy_src_"+name)
+			// The source is in the old format, so its pages are read narrow and the copy's
+			// are written wide.
+			tree, err := newTree(
+				src,
+				WithBranchingFactor(4),
+				WithCacheSize(40),
+				WithFormatVersion(store.FormatVersion1),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tree.wide() {
+				t.Fatal("expected a tree in the old format to store 32 bit page ids")
+			}
+			if err := tree.SetLazyDelete(true); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 500; i++ {
+				if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 500; i += 3 {
+				if err := tree.Delete(intKey(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			verifyTree(t, tree)
+			if err := tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			dst := tempFilename(t, "copy_dst_"+name)
+			if err := Copy(src, dst, WithCacheSize(40)); err != nil {
+				t.Fatal(err)
+			}
+			tree, err = OpenTree(dst, WithCacheSize(40))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			if !tree.wide() {
+				t.Fatal("expected the copy to store 64 bit page ids")
+			}
+			blink := name == "blink"
+			if tree.branchingFactor != 4 || !tree.LazyDelete() || tree.BLink() != blink {
+				t.Fatalf("expected the copy to keep the options of %v", name)
+			}
+			verifyTree(t, tree)
+			for i := 0; i < 500; i++ {
+				value, err := tree.Read(intKey(i))
+				if i%3 == 0 {
+					if err != ErrKeyNotFound {
+						t.Fatalf("found deleted value %+v for %d", value, i)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(i, err)
+				}
+				if !bytes.Equal(value, intValue(i)) {
+					t.Fatalf("expected %v == %v", value, intValue(i))
+				}
+			}
+		})
+	}
+}
+
+func TestCopyDB(t *testing.T) {
+	src := tempFilename(t, "copy_db_src")
+	db, err := NewDB(src, WithBranchingFactor(4), WithCacheSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"users", "orders"}
+	for i, name := range names {
+		tree, err := db.CreateTree(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 200; j++ {
+			if err := tree.Insert(intKey(j), intValue(i*1000+j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := tempFilename(t, "copy_db_dst")
+	if err := Copy(src, dst, WithCacheSize(40)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Copy(src, dst, WithCacheSize(40)); err != ErrTreeExists {
+		t.Fatalf("expected %v == %v", err, ErrTreeExists)
+	}
+	db, err = OpenDB(dst, WithCacheSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i, name := range names {
+		tree, err := db.OpenTree(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyTree(t, tree)
+		if tree.Len() != 200 {
+			t.Fatalf("expected %v == %v", tree.Len(), 200)
+		}
+		for j := 0; j < 200; j++ {
+			value, err := tree.Read(intKey(j))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(value, intValue(i*1000+j)) {
+				t.Fatalf("expected %v == %v", value, intValue(i*1000+j))
+			}
+		}
+	}
+}
+
+func tempFilename(t testing.TB, pattern string) string {
+	tmpfile, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	return tmpfile.Name()
+}
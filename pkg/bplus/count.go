@@ -0,0 +1,60 @@
+package bplus
+
+import (
+	"bytes"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Len returns the number of records in the tree. Branch pages keep the number of records
+// under each of their children, so this doesn't need to touch any page but the root.
+// Writes still in flight may not be counted yet, see latch.go.
+func (tree *Tree) Len() int {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	tree.rootLatch.RLock()
+	defer tree.rootLatch.RUnlock()
+	return tree.root.count()
+}
+
+// CountRange returns the number of records with a key in [start, end). Subtrees that fall
+// entirely within the range are counted from their parent without being loaded, so only
+// the pages along the two edges of the range are read.
+func (tree *Tree) CountRange(start, end Key) (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if len(tree.root.pointers) == 0 || bytes.Compare(start, end) >= 0 {
+		return 0, nil
+	}
+	return tree.countRange(tree.root.ID, start, end)
+}
+
+// countRange counts the records in [start, end) in the subtree rooted at pageID.
+func (tree *Tree) countRange(pageID store.PageID, start, end Key) (int, error) {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return 0, err
+	}
+	if leaf != nil {
+		i, _ := leaf.search(start)
+		j, _ := leaf.search(end)
+		if i >= j {
+			return 0, nil
+		}
+		return liveCount(leaf.records[i:j]), nil
+	}
+	first := branch.childIndex(start)
+	last := branch.childIndex(end)
+	count := 0
+	// Every child strictly between the first and last child is entirely within the range.
+	for i := first + 1; i < last; i++ {
+		count += branch.counts[i]
+	}
+	n, err := tree.countRange(branch.pointers[first], start, end)
+	count += n
+	if err != nil || last == first {
+		return count, err
+	}
+	n, err = tree.countRange(branch.pointers[last], start, end)
+	return count + n, err
+}
@@ -0,0 +1,132 @@
+package bplus
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestLen(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "len")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 0 {
+		t.Fatalf("expected %v == %v", tree.Len(), 0)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(500) {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.Len() != 500 {
+		t.Fatalf("expected %v == %v", tree.Len(), 500)
+	}
+	// Replacing values doesn't change the number of records.
+	for i := 0; i < 100; i++ {
+		if err := tree.Upsert(intKey(i), intValue(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.Len() != 500 {
+		t.Fatalf("expected %v == %v", tree.Len(), 500)
+	}
+	for i := 0; i < 50; i++ {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(100), intKey(200)); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 350 {
+		t.Fatalf("expected %v == %v", tree.Len(), 350)
+	}
+	b := &WriteBatch{}
+	for i := 0; i < 100; i++ {
+		// Puts the 50 deleted keys back and deletes 50 more.
+		if i < 50 {
+			b.Put(intKey(i), intValue(i))
+		} else {
+			b.Delete(intKey(i))
+		}
+	}
+	if err := tree.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 350 {
+		t.Fatalf("expected %v == %v", tree.Len(), 350)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, tree)
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 350 {
+		t.Fatalf("expected %v == %v", reopened.Len(), 350)
+	}
+}
+
+func TestLenAfterBulkLoad(t *testing.T) {
+	tree, err := newTree("len_after_bulk_load", 8, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]Record, 3000)
+	for i := range records {
+		records[i] = Record{Key: intKey(i), Value: intValue(i)}
+	}
+	if err := tree.BulkLoad(records, 0.7); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 3000 {
+		t.Fatalf("expected %v == %v", tree.Len(), 3000)
+	}
+	n, err := tree.CountRange(intKey(1000), intKey(2500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1500 {
+		t.Fatalf("expected %v == %v", n, 1500)
+	}
+}
+
+func TestCountRangeRandomized(t *testing.T) {
+	tree, err := newTree("count_range_randomized", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	present := map[int]bool{}
+	for _, i := range r.Perm(2000)[:1000] {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		present[i] = true
+	}
+	for i := 0; i < 200; i++ {
+		start, end := r.Intn(2100), r.Intn(2100)
+		expected := 0
+		for k := start; k < end; k++ {
+			if present[k] {
+				expected++
+			}
+		}
+		n, err := tree.CountRange(intKey(start), intKey(end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Fatalf("expected %v == %v for [%d, %d)", n, expected, start, end)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestTreeWithCOW(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "tree_with_cow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableWAL(); err == nil {
+		t.Fatal("expected enabling a write-ahead log to fail")
+	}
+	r := rand.New(rand.NewSource(1))
+	present := map[int]bool{}
+	for _, i := range r.Perm(1000) {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		present[i] = true
+	}
+	verifyTree(t, tree)
+	for _, i := range r.Perm(1000)[:300] {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(i, err)
+		}
+		delete(present, i)
+	}
+	verifyTree(t, tree)
+	n, err := tree.DeleteRange(intKey(400), intKey(600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 400; i < 600; i++ {
+		if present[i] {
+			n--
+			delete(present, i)
+		}
+	}
+	if n != 0 {
+		t.Fatalf("expected %v == %v", n, 0)
+	}
+	verifyTree(t, tree)
+	var batch WriteBatch
+	for i := 0; i < 1000; i += 3 {
+		batch.Put(intKey(i), intValue(i))
+		present[i] = true
+	}
+	if err := tree.Apply(&batch); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+
+	crash(t, tree)
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != len(present) {
+		t.Fatalf("expected %v == %v", reopened.Len(), len(present))
+	}
+	for i := 0; i < 1000; i++ {
+		value, err := reopened.Read(intKey(i))
+		if !present[i] {
+			if err != ErrKeyNotFound {
+				t.Fatalf("found deleted value %+v for %d", value, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+	verifyTree(t, reopened)
+}
+
+func TestCOWLeavesCommittedTreeUntouched(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "cow_untouched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stop in the middle of a change, as if the process crashed before committing it.
+	tree.store.Begin()
+	if err := tree.Upsert(intKey(1), intValue(-1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(intKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, tree)
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := reopened.Read(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(1)) {
+		t.Fatalf("expected %v == %v", value, intValue(1))
+	}
+	if reopened.Len() != 100 {
+		t.Fatalf("expected %v == %v", reopened.Len(), 100)
+	}
+	verifyTree(t, reopened)
+}
+
+func TestDBWithCOW(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "db_with_cow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	db, err := NewDB(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		tree, err := db.CreateTree(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 200; i++ {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.DropTree("a"); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, db.catalog)
+	reopened, err := OpenDB(tmpfile.Name(), WithCacheSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.OpenTree("a"); err != ErrTreeNotFound {
+		t.Fatalf("expected %v == %v", err, ErrTreeNotFound)
+	}
+	tree, err := reopened.OpenTree("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 200 {
+		t.Fatalf("expected %v == %v", tree.Len(), 200)
+	}
+	verifyTree(t, tree)
+}
+
+func TestCOWWithShardedCache(t *testing.T) {
+	// A cache this big is split into shards, and pages move between them when they're
+	// copied.
+	tree, err := newTree("cow_with_sharded_cache", 4, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < 1000; i += 2 {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+	verifyTree(t, tree)
+}
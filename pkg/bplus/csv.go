@@ -0,0 +1,48 @@
+package bplus
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+)
+
+// ErrCSVFields is returned when importing a CSV row that doesn't have exactly a key and a
+// value.
+var ErrCSVFields = errors.New("CSV row does not have two fields")
+
+// ImportCSV writes the records of the CSV file read from r to the tree, overwriting the
+// values of keys it already has, and returns how many it wrote. Every row holds a key and
+// a value, as written by f, and there's no header. The records are written in batches,
+// see Apply, so an import that fails part way leaves the batches before it behind.
+func (tree *Tree) ImportCSV(r io.Reader, f TextFormat) (n int, err error) {
+	defer tree.startSpan("bplus.ImportCSV").end(&err)
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return tree.importText(f, func() (int, string, string, error) {
+		row, err := cr.Read()
+		if err != nil {
+			return 0, "", "", err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(row) != 2 {
+			return 0, "", "", &TextError{Line: line, Err: ErrCSVFields}
+		}
+		return line, row[0], row[1], nil
+	})
+}
+
+// ExportCSV writes every record in the tree to w as a CSV file, in order, see ImportCSV,
+// and returns how many it wrote.
+func (tree *Tree) ExportCSV(w io.Writer, f TextFormat) (n int, err error) {
+	defer tree.startSpan("bplus.ExportCSV").end(&err)
+	cw := csv.NewWriter(w)
+	n, err = tree.exportText(f, func(key, value string) error {
+		return cw.Write([]string{key, value})
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	return n, err
+}
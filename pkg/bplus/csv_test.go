@@ -0,0 +1,95 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	filename := tempFilename(t, "csv")
+	defer os.Remove(filename)
+	tree, err := NewTree(filename, WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	input := "a,1\n\"b,c\",\"say \"\"hi\"\"\"\nd,\n"
+	n, err := tree.ImportCSV(strings.NewReader(input), TextFormat{})
+	if err != nil || n != 3 {
+		t.Fatalf("expected %v == %v, got %v", n, 3, err)
+	}
+	value, err := tree.Read(Key("b,c"))
+	if err != nil || string(value) != `say "hi"` {
+		t.Fatalf("expected %q == %q, got %v", value, `say "hi"`, err)
+	}
+	var out bytes.Buffer
+	n, err = tree.ExportCSV(&out, TextFormat{})
+	if err != nil || n != 3 {
+		t.Fatalf("expected %v == %v, got %v", n, 3, err)
+	}
+	if out.String() != input {
+		t.Fatalf("expected %q == %q", out.String(), input)
+	}
+
+	// Binary keys and values can't be written raw, but can be in hex or base64.
+	tree.Upsert(Key{0xff, 0x00}, Value{0x01})
+	if _, err := tree.ExportCSV(&out, TextFormat{}); err != ErrInvalidText {
+		t.Fatalf("expected %v == %v", err, ErrInvalidText)
+	}
+	out.Reset()
+	f := TextFormat{Keys: HexText, Values: Base64Text}
+	if _, err := tree.ExportCSV(&out, f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "61,MQ==\n") {
+		t.Fatalf("expected %q to start with %q", out.String(), "61,MQ==\n")
+	}
+	copyName := tempFilename(t, "csv_copy")
+	defer os.Remove(copyName)
+	other, err := NewTree(copyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if n, err := other.ImportCSV(&out, f); err != nil || n != 4 {
+		t.Fatalf("expected %v == %v, got %v", n, 4, err)
+	}
+	value, err = other.Read(Key{0xff, 0x00})
+	if err != nil || !bytes.Equal(value, Value{0x01}) {
+		t.Fatalf("expected %v == %v, got %v", value, Value{0x01}, err)
+	}
+}
+
+func TestImportCSVErrors(t *testing.T) {
+	filename := tempFilename(t, "csv_errors")
+	defer os.Remove(filename)
+	tree, err := NewTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	var textErr *TextError
+	_, err = tree.ImportCSV(strings.NewReader("a,1\nb,2,3\n"), TextFormat{})
+	if !errors.As(err, &textErr) || textErr.Line != 2 || textErr.Err != ErrCSVFields {
+		t.Fatalf("expected a field count error on line 2, got %v", err)
+	}
+	_, err = tree.ImportCSV(strings.NewReader("zz,1\n"), TextFormat{Keys: HexText})
+	if !errors.As(err, &textErr) || textErr.Line != 1 {
+		t.Fatalf("expected a hex error on line 1, got %v", err)
+	}
+
+	// The batches before a bad row are written.
+	var input strings.Builder
+	for i := 0; i < textBatch; i++ {
+		fmt.Fprintf(&input, "key%d,value\n", i)
+	}
+	input.WriteString("bad\n")
+	n, err := tree.ImportCSV(strings.NewReader(input.String()), TextFormat{})
+	if err == nil || n != textBatch {
+		t.Fatalf("expected %v == %v, got %v", n, textBatch, err)
+	}
+}
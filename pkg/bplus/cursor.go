@@ -0,0 +1,414 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// ErrCursorClosed is returned when using a cursor after it has been closed.
+var ErrCursorClosed = errors.New("cursor closed")
+
+// readAheadTrigger is the number of leaves a cursor has to move through in a row, in the
+// same direction, before it starts prefetching the leaves ahead of it.
+const readAheadTrigger = 2
+
+// Cursor walks the records of a tree in key order. A cursor is positioned with First,
+// Last or Seek and then moved a record at a time with Next and Prev. Each of these
+// returns the record the cursor ends up on, or ErrKeyNotFound if there isn't one, after
+// which the cursor has to be positioned again before it can be moved.
+//
+// The leaf the cursor is positioned in stays pinned in the page cache until the cursor
+// moves to another leaf or is closed, unless it was read straight out of the file's
+// memory mapping, see WithMmap, so every cursor must be closed once it's no longer
+// needed. The tree may be changed while a cursor is open, in which case the cursor picks
+// up from the key it was positioned on, skipping over it if it was deleted. A cursor
+// mustn't be used by more than one goroutine at once, but it only holds the tree's lock
+// for reading, so cursors move alongside Read and put, latching the pages they decode
+// like Read does, see latch.go.
+//
+// Once a cursor has moved through a few leaves in a row, it prefetches the leaves ahead
+// of it in the background, so that scanning a tree doesn't wait on reading every leaf in
+// turn, see WithReadAhead.
+type Cursor struct {
+	tree *Tree
+	// path holds the branches from the root down to the leaf, and the index of the child
+	// followed in each of them.
+	path []cursorFrame
+	// leaf is the pinned leaf the cursor is positioned in, or nil if it isn't positioned.
+	leaf  *leafPage
+	index int
+	// low and high bound the keys that belonged in the leaf when it was decoded. Either
+	// is nil at that end of the tree.
+	low, high Key
+	// version is the version of the tree when the cursor was positioned.
+	version uint64
+	closed  bool
+	// run counts the leaves the cursor has moved through in a row in the direction step.
+	run  int
+	step int
+	// ahead is the index of the furthest child of aheadOf, the branch above the leaf, that
+	// has been prefetched.
+	aheadOf store.PageID
+	ahead   int
+}
+
+type cursorFrame struct {
+	branch *branchPage
+	// id is the branch's page id, since branches read straight out of the store's
+	// memory mapping have no Page to take it from, see store.PageStore.View.
+	id    store.PageID
+	index int
+}
+
+// cursorTarget is what a cursor descends to: the leaf key belongs in, or, if before is
+// set, the leaf holding the keys just below key. A nil key is past every key when before
+// is set.
+type cursorTarget struct {
+	key    Key
+	before bool
+}
+
+// pick returns the index of the child of b the target is under.
+func (t cursorTarget) pick(b *branchPage) int {
+	if !t.before {
+		return b.childIndex(t.key)
+	}
+	return sort.Search(len(b.keys), func(i int) bool {
+		return t.key != nil && bytes.Compare(t.key, b.keys[i]) <= 0
+	})
+}
+
+// covered reports whether the target is in the page with the given link or to the left
+// of it.
+func (t cursorTarget) covered(l *rightLink) bool {
+	if !t.before {
+		return l.covers(t.key)
+	}
+	return l.high == nil || (t.key != nil && bytes.Compare(t.key, l.high) <= 0)
+}
+
+// Cursor returns a new cursor over the tree which isn't positioned yet.
+func (tree *Tree) Cursor() *Cursor {
+	return &Cursor{tree: tree}
+}
+
+// First positions the cursor on the record with the smallest key.
+func (c *Cursor) First() (Record, error) {
+	c.tree.timedRLock()
+	defer c.tree.lock.RUnlock()
+	err := c.descendFromRoot(cursorTarget{})
+	if err != nil {
+		return Record{}, err
+	}
+	c.index = 0
+	return c.forward()
+}
+
+// Last positions the cursor on the record with the largest key.
+func (c *Cursor) Last() (Record, error) {
+	c.tree.timedRLock()
+	defer c.tree.lock.RUnlock()
+	err := c.descendFromRoot(cursorTarget{before: true})
+	if err != nil {
+		return Record{}, err
+	}
+	c.index = len(c.leaf.records) - 1
+	return c.backward()
+}
+
+// Seek positions the cursor on the record with the smallest key greater than or equal to
+// key.
+func (c *Cursor) Seek(key Key) (Record, error) {
+	c.tree.timedRLock()
+	defer c.tree.lock.RUnlock()
+	return c.seek(key)
+}
+
+func (c *Cursor) seek(key Key) (Record, error) {
+	err := c.locate(key)
+	if err != nil {
+		return Record{}, err
+	}
+	return c.forward()
+}
+
+// Next moves the cursor to the record with the next largest key.
+func (c *Cursor) Next() (Record, error) {
+	c.tree.timedRLock()
+	defer c.tree.lock.RUnlock()
+	if c.closed {
+		return Record{}, ErrCursorClosed
+	}
+	if c.leaf == nil {
+		return Record{}, ErrKeyNotFound
+	}
+	if c.version != c.tree.version.Load() {
+		// The smallest key greater than the current key.
+		next := append(append(Key{}, c.leaf.records[c.index].Key...), 0)
+		return c.seek(next)
+	}
+	c.index++
+	return c.forward()
+}
+
+// Prev moves the cursor to the record with the next smallest key.
+func (c *Cursor) Prev() (Record, error) {
+	c.tree.timedRLock()
+	defer c.tree.lock.RUnlock()
+	if c.closed {
+		return Record{}, ErrCursorClosed
+	}
+	if c.leaf == nil {
+		return Record{}, ErrKeyNotFound
+	}
+	if c.version != c.tree.version.Load() {
+		err := c.locate(c.leaf.records[c.index].Key)
+		if err != nil {
+			return Record{}, err
+		}
+	}
+	c.index--
+	return c.backward()
+}
+
+// Close releases the page the cursor is positioned in. Closing a cursor more than once
+// has no effect.
+func (c *Cursor) Close() error {
+	c.tree.timedRLock()
+	defer c.tree.lock.RUnlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.reset()
+}
+
+// locate positions the cursor at the first record with a key greater than or equal to
+// key within the leaf key belongs in, which may be one past the end of the leaf.
+func (c *Cursor) locate(key Key) error {
+	err := c.descendFromRoot(cursorTarget{key: key})
+	if err != nil {
+		return err
+	}
+	c.index, _ = c.leaf.search(key)
+	return nil
+}
+
+// forward moves the cursor to the right, through the leaves and past tombstones, until
+// it's on a record.
+func (c *Cursor) forward() (Record, error) {
+	for c.index >= len(c.leaf.records) || c.leaf.records[c.index].tombstone {
+		if c.index < len(c.leaf.records) {
+			c.index++
+			continue
+		}
+		found, err := c.nextLeaf(1)
+		if err != nil || !found {
+			return Record{}, c.end(err)
+		}
+	}
+	return c.tree.values.resolve(c.leaf.records[c.index])
+}
+
+// backward moves the cursor to the left, through the leaves and past tombstones, until
+// it's on a record.
+func (c *Cursor) backward() (Record, error) {
+	for c.index < 0 || c.leaf.records[c.index].tombstone {
+		if c.index >= 0 {
+			c.index--
+			continue
+		}
+		found, err := c.nextLeaf(-1)
+		if err != nil || !found {
+			return Record{}, c.end(err)
+		}
+	}
+	return c.tree.values.resolve(c.leaf.records[c.index])
+}
+
+// end unpositions the cursor after it has run off either end of the tree.
+func (c *Cursor) end(err error) error {
+	resetErr := c.reset()
+	if err != nil {
+		return err
+	}
+	if resetErr != nil {
+		return resetErr
+	}
+	return ErrKeyNotFound
+}
+
+// nextLeaf moves the cursor to the leaf to the right of the current one, or to the left
+// if step is -1, onto the first record past the current leaf in that direction. It
+// returns false if the current leaf is the last one in that direction. Writers may have
+// changed the branches since the cursor decoded them, so the leaf is found by descending
+// again to the bound of the current leaf.
+func (c *Cursor) nextLeaf(step int) (bool, error) {
+	target := cursorTarget{key: c.high}
+	if step < 0 {
+		target = cursorTarget{key: c.low, before: true}
+	}
+	if target.key == nil {
+		return false, nil
+	}
+	c.path = c.path[:0]
+	err := c.descend(target)
+	if err != nil {
+		return false, err
+	}
+	c.index, _ = c.leaf.search(target.key)
+	if step < 0 {
+		c.index--
+	}
+	c.readAhead(step)
+	return true, nil
+}
+
+func (c *Cursor) descendFromRoot(target cursorTarget) error {
+	if c.closed {
+		return ErrCursorClosed
+	}
+	err := c.reset()
+	if err != nil {
+		return err
+	}
+	c.run = 0
+	return c.descend(target)
+}
+
+// readAhead prefetches the leaves after the one the cursor just moved to in the
+// direction step, once the cursor looks like it's scanning. Only the leaves under the
+// same branch are prefetched, and they're prefetched again once the cursor is half way
+// through them.
+func (c *Cursor) readAhead(step int) {
+	if step == c.step {
+		c.run++
+	} else {
+		c.step = step
+		c.run = 1
+		c.aheadOf = 0
+	}
+	window := c.tree.readAhead
+	if window <= 0 || c.run < readAheadTrigger || len(c.path) == 0 {
+		return
+	}
+	frame := c.path[len(c.path)-1]
+	if frame.id != c.aheadOf {
+		c.aheadOf = frame.id
+		c.ahead = frame.index
+	}
+	if (c.ahead-frame.index)*step > window/2 {
+		return
+	}
+	var pageIDs []store.PageID
+	for i := c.ahead + step; i >= 0 && i < len(frame.branch.pointers); i += step {
+		if (i-frame.index)*step > window {
+			break
+		}
+		pageIDs = append(pageIDs, frame.branch.pointers[i])
+		c.ahead = i
+	}
+	if len(pageIDs) > 0 {
+		c.tree.store.Prefetch(pageIDs...)
+	}
+}
+
+// descend walks down from the root to the leaf target is in, crabbing from page to page
+// with each page latched for reading while it's decoded, and pins the leaf in place of
+// the current one. In a B-link tree it moves right past pages that were split after
+// their parent was decoded. It records the version of the tree it started from and the
+// bounds of the leaf.
+func (c *Cursor) descend(target cursorTarget) error {
+	tree := c.tree
+	c.version = tree.version.Load()
+	tree.rootLatch.RLock()
+	if len(tree.root.pointers) == 0 {
+		tree.rootLatch.RUnlock()
+		return ErrKeyNotFound
+	}
+	root := &branchPage{PageHandle: tree.root.PageHandle}
+	root.fromBuffer()
+	childID, low, high := c.follow(root, tree.root.ID, target, nil, nil)
+	page, err := tree.latchChild(childID, false, false)
+	tree.rootLatch.RUnlock()
+	for err == nil {
+		var link rightLink
+		link.linkFromBuffer(page.Contents())
+		if !target.covered(&link) {
+			low = append(Key{}, link.high...)
+			err = tree.unlatch(page)
+			if err == nil {
+				page, err = tree.latchChild(link.link, false, false)
+			}
+			continue
+		}
+		if link.blink {
+			high = nil
+			if link.high != nil {
+				high = append(Key{}, link.high...)
+			}
+		}
+		if isLeafPage(page.PageHandle) {
+			err = c.releaseLeaf()
+			if err != nil {
+				tree.unlatch(page)
+				return err
+			}
+			c.leaf = &leafPage{PageHandle: page.PageHandle}
+			c.leaf.fromBuffer()
+			// The leaf stays pinned after it's let go of.
+			tree.latches.release(page.id, false)
+			c.low, c.high = low, high
+			return nil
+		}
+		branch := &branchPage{PageHandle: page.PageHandle}
+		branch.fromBuffer()
+		childID, low, high = c.follow(branch, page.id, target, low, high)
+		var child *latchedPage
+		child, err = tree.latchChild(childID, false, false)
+		unlatchErr := tree.unlatch(page)
+		if err == nil && unlatchErr != nil {
+			tree.unlatch(child)
+			err = unlatchErr
+		}
+		page = child
+	}
+	return err
+}
+
+// follow adds the child of branch that target is under to the path, and returns the
+// child along with its bounds given the bounds of branch.
+func (c *Cursor) follow(
+	branch *branchPage,
+	id store.PageID,
+	target cursorTarget,
+	low, high Key,
+) (store.PageID, Key, Key) {
+	i := target.pick(branch)
+	c.path = append(c.path, cursorFrame{branch: branch, id: id, index: i})
+	if i > 0 {
+		low = branch.keys[i-1]
+	}
+	if i < len(branch.keys) {
+		high = branch.keys[i]
+	}
+	return branch.pointers[i], low, high
+}
+
+// reset unpositions the cursor.
+func (c *Cursor) reset() error {
+	c.path = c.path[:0]
+	return c.releaseLeaf()
+}
+
+func (c *Cursor) releaseLeaf() error {
+	if c.leaf == nil {
+		return nil
+	}
+	err := c.tree.release(c.leaf.PageHandle)
+	c.leaf = nil
+	return err
+}
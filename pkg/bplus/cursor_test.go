@@ -0,0 +1,404 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestCursor(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, bf := range []int{3, 4, 16} {
+		tree, err := newTree("cursor", bf, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []int
+		for _, i := range r.Perm(2000)[:1000] {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, i)
+		}
+		sort.Ints(keys)
+
+		c := tree.Cursor()
+		var got []int
+		for rec, err := c.First(); err != ErrKeyNotFound; rec, err = c.Next() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, keyInt(rec.Key))
+		}
+		expectInts(t, got, keys)
+
+		got = nil
+		for rec, err := c.Last(); err != ErrKeyNotFound; rec, err = c.Prev() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, keyInt(rec.Key))
+		}
+		for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+			got[i], got[j] = got[j], got[i]
+		}
+		expectInts(t, got, keys)
+
+		// Seek lands on the smallest key greater than or equal to the one sought.
+		for n := 0; n < 100; n++ {
+			seek := r.Intn(2100)
+			i := sort.SearchInts(keys, seek)
+			rec, err := c.Seek(intKey(seek))
+			if i == len(keys) {
+				if err != ErrKeyNotFound {
+					t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyInt(rec.Key) != keys[i] || !bytes.Equal(rec.Value, intValue(keys[i])) {
+				t.Fatalf("expected %v == %v", keyInt(rec.Key), keys[i])
+			}
+			if i > 0 {
+				rec, err = c.Prev()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if keyInt(rec.Key) != keys[i-1] {
+					t.Fatalf("expected %v == %v", keyInt(rec.Key), keys[i-1])
+				}
+			}
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.First(); err != ErrCursorClosed {
+			t.Fatalf("expected %v == %v", err, ErrCursorClosed)
+		}
+	}
+}
+
+func TestCursorEmptyTree(t *testing.T) {
+	tree, err := newTree("cursor_empty_tree", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tree.Cursor()
+	defer c.Close()
+	if _, err := c.First(); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if _, err := c.Last(); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if _, err := c.Next(); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestCursorStaysPositionedAcrossChanges(t *testing.T) {
+	tree, err := newTree("cursor_stays_positioned", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i += 2 {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := tree.Cursor()
+	defer c.Close()
+	if _, err := c.Seek(intKey(500)); err != nil {
+		t.Fatal(err)
+	}
+	// Delete the current record and a range after it, and insert a record right after it.
+	if err := tree.Delete(intKey(500)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.DeleteRange(intKey(502), intKey(600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(601), intValue(601)); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyInt(rec.Key) != 600 {
+		t.Fatalf("expected %v == %v", keyInt(rec.Key), 600)
+	}
+	rec, err = c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyInt(rec.Key) != 601 {
+		t.Fatalf("expected %v == %v", keyInt(rec.Key), 601)
+	}
+	if err := tree.Delete(intKey(601)); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = c.Prev()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyInt(rec.Key) != 600 {
+		t.Fatalf("expected %v == %v", keyInt(rec.Key), 600)
+	}
+}
+
+// TestCursorAlongsideWriters moves cursors through trees while other goroutines split
+// their pages, and checks that every key that was there all along is seen once, in order.
+func TestCursorAlongsideWriters(t *testing.T) {
+	for _, blink := range []bool{false, true} {
+		tmpfile, err := ioutil.TempFile("", "cursor_alongside_writers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+		create := NewTree
+		if blink {
+			create = NewBLinkTree
+		}
+		tree, err := create(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []int
+		for i := 0; i < 1000; i += 2 {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected, i)
+		}
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 2*g + 1; i < 1000; i += 8 {
+					if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(g)
+		}
+		c := tree.Cursor()
+		for pass := 0; pass < 4; pass++ {
+			first, next := c.First, c.Next
+			if pass%2 == 1 {
+				first, next = c.Last, c.Prev
+			}
+			var even []int
+			last := -1
+			for rec, err := first(); err != ErrKeyNotFound; rec, err = next() {
+				if err != nil {
+					t.Fatal(err)
+				}
+				i := keyInt(rec.Key)
+				if last >= 0 && (i > last) != (pass%2 == 0) {
+					t.Fatalf("expected %v to come after %v", i, last)
+				}
+				last = i
+				if i%2 == 0 {
+					even = append(even, i)
+				}
+			}
+			if pass%2 == 1 {
+				sort.Ints(even)
+			}
+			expectInts(t, even, expected)
+		}
+		wg.Wait()
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		verifyTree(t, tree)
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCursorCloseReleasesPages(t *testing.T) {
+	tree, err := newTree("cursor_close_releases_pages", 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]Record, 1000)
+	for i := range records {
+		records[i] = Record{Key: intKey(i), Value: intValue(i)}
+	}
+	if err := tree.BulkLoad(records, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The header and root take up two slots, and each cursor pins a different leaf. A
+	// leaf is latched before its parent is let go of, which takes another slot, so the
+	// cursors leave one too few for a read.
+	var cursors []*Cursor
+	for i := 0; i < 5; i++ {
+		c := tree.Cursor()
+		if _, err := c.Seek(intKey(i * 100)); err != nil {
+			t.Fatal(err)
+		}
+		cursors = append(cursors, c)
+	}
+	if _, err := tree.Read(intKey(999)); err != store.ErrPageCacheFull {
+		t.Fatalf("expected %v == %v", err, store.ErrPageCacheFull)
+	}
+	for _, c := range cursors {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Read(intKey(999)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCursorMmapTakesNoCacheSlots(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "cursor_mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(16), WithCacheSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]Record, 1000)
+	for i := range records {
+		records[i] = Record{Key: intKey(i), Value: intValue(i)}
+	}
+	if err := tree.BulkLoad(records, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = OpenTree(tmpfile.Name(), WithCacheSize(8), WithMmap())
+	if err == store.ErrMmapUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	// The leaves are clean, so they're read straight out of the mapping, and many more
+	// cursors can be open at once than would fit their leaves in the cache.
+	var cursors []*Cursor
+	for i := 0; i < 50; i++ {
+		c := tree.Cursor()
+		if _, err := c.Seek(intKey(i * 20)); err != nil {
+			t.Fatal(err)
+		}
+		cursors = append(cursors, c)
+	}
+	if _, err := tree.Read(intKey(999)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cursors {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCursorReadAhead(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "cursor_read_ahead")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(
+		tmpfile.Name(),
+		WithBranchingFactor(32),
+		WithCacheSize(64),
+		WithReadAhead(8),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	records := make([]Record, 5000)
+	for i := range records {
+		records[i] = Record{Key: intKey(i), Value: intValue(i)}
+	}
+	if err := tree.BulkLoad(records, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c := tree.Cursor()
+	defer c.Close()
+	// Positioning the cursor doesn't count as moving from leaf to leaf.
+	if _, err := c.First(); err != nil {
+		t.Fatal(err)
+	}
+	if c.aheadOf != 0 {
+		t.Fatal("expected nothing to be prefetched before the cursor moves between leaves")
+	}
+	var got []int
+	for rec, err := c.First(); err != ErrKeyNotFound; rec, err = c.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, keyInt(rec.Key))
+		frame := c.path[len(c.path)-1]
+		if c.aheadOf == frame.id && c.ahead-frame.index > 8 {
+			t.Fatalf("expected %v to be no more than 8 leaves ahead", c.ahead-frame.index)
+		}
+		if c.run >= readAheadTrigger && c.aheadOf != frame.id {
+			t.Fatal("expected the leaves ahead of a scanning cursor to be prefetched")
+		}
+	}
+	expectInts(t, got, sortedInts(5000))
+
+	got = nil
+	for rec, err := c.Last(); err != ErrKeyNotFound; rec, err = c.Prev() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, keyInt(rec.Key))
+	}
+	if len(got) != 5000 || got[0] != 4999 || got[4999] != 0 {
+		t.Fatalf("expected a backwards scan of every record, got %v", len(got))
+	}
+	if c.step != -1 {
+		t.Fatalf("expected %v == %v", c.step, -1)
+	}
+}
+
+func sortedInts(n int) []int {
+	ints := make([]int, n)
+	for i := range ints {
+		ints[i] = i
+	}
+	return ints
+}
+
+// keyInt decodes a key created by intKey.
+func keyInt(key Key) int {
+	return int(key[0])<<24 | int(key[1])<<16 | int(key[2])<<8 | int(key[3])
+}
+
+func expectInts(t *testing.T, got, expected []int) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v == %v", len(got), len(expected))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v == %v at %d", got[i], expected[i], i)
+		}
+	}
+}
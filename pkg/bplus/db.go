@@ -0,0 +1,284 @@
+package bplus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+var (
+	// ErrDB is returned when opening a file that holds a database of named trees with
+	// OpenTree.
+	ErrDB = errors.New("file holds a database of named trees")
+	// ErrNotDB is returned when opening a file that holds a single tree with OpenDB.
+	ErrNotDB = errors.New("file does not hold a database of named trees")
+)
+
+// dbFlag is set in the file header of files created by NewDB.
+const dbFlag = 4
+
+// catalogEntrySize is the size of a catalog entry: the root page id, branching factor and
+// flags of a tree, with the root taking up as many bytes as the file's page ids.
+func catalogEntrySize(wide bool) int {
+	return pageIDSize(wide) + 8
+}
+
+// DB is a single file that holds many named B+ trees. The root of each tree is recorded
+// in a catalog, which is itself a B+ tree whose root is recorded in the file header,
+// mapping the name of every tree to its root. All of the trees share the file's page
+// cache and free list.
+type DB struct {
+	catalog *Tree
+	// trees holds the trees that have been created or opened, so that each tree is only
+	// loaded once.
+	trees map[string]*Tree
+}
+
+type catalogEntry struct {
+	root            store.PageID
+	branchingFactor int
+	flags           uint32
+}
+
+// NewDB constructs a database in the given file. Trees created in the database have the
+// branching factor given by WithBranchingFactor. Use OpenDB to reattach to a database
+// that was previously created in the file.
+func NewDB(filename string, opts ...Option) (*DB, error) {
+	if newOptions(opts).valueThreshold != 0 {
+		return nil, ErrValueLogUnsupported
+	}
+	catalog, err := NewTree(filename, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = catalog.setFlags(dbFlag)
+	return &DB{catalog: catalog, trees: map[string]*Tree{}}, err
+}
+
+// OpenDB reattaches to a database that was created in the given file by NewDB.
+func OpenDB(filename string, opts ...Option) (*DB, error) {
+	catalog, err := openTree(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	if catalog.flags&dbFlag == 0 {
+		catalog.Close()
+		return nil, ErrNotDB
+	}
+	return &DB{catalog: catalog, trees: map[string]*Tree{}}, nil
+}
+
+// CreateTree creates an empty tree called name, or returns ErrTreeExists if the database
+// already has a tree with that name.
+func (db *DB) CreateTree(name string) (tree *Tree, err error) {
+	_, err = db.catalog.Read(Key(name))
+	if err == nil {
+		return nil, ErrTreeExists
+	}
+	if err != ErrKeyNotFound {
+		return nil, err
+	}
+	db.catalog.store.Begin()
+	defer db.catalog.commit(&err)
+	tree = &Tree{
+		store:           db.catalog.store,
+		branchingFactor: db.catalog.branchingFactor,
+		readAhead:       db.catalog.readAhead,
+		tracer:          db.catalog.tracer,
+		slowOpThreshold: db.catalog.slowOpThreshold,
+		db:              db,
+		name:            name,
+	}
+	err = tree.allocateRootNode()
+	if err != nil {
+		return nil, err
+	}
+	db.trees[name] = tree
+	return tree, nil
+}
+
+// OpenTree returns the tree called name, or ErrTreeNotFound if the database doesn't have
+// a tree with that name.
+func (db *DB) OpenTree(name string) (*Tree, error) {
+	if tree, ok := db.trees[name]; ok {
+		return tree, nil
+	}
+	entry, err := db.loadTree(name)
+	if err != nil {
+		return nil, err
+	}
+	tree := &Tree{
+		store:           db.catalog.store,
+		branchingFactor: entry.branchingFactor,
+		readAhead:       db.catalog.readAhead,
+		tracer:          db.catalog.tracer,
+		slowOpThreshold: db.catalog.slowOpThreshold,
+		flags:           entry.flags,
+		db:              db,
+		name:            name,
+		recordedRoot:    entry.root,
+	}
+	err = tree.loadRootNode(entry.root)
+	if err != nil {
+		return nil, err
+	}
+	db.trees[name] = tree
+	return tree, nil
+}
+
+// DropTree removes the tree called name from the database and returns all of its pages to
+// the free list, or returns ErrTreeNotFound if the database doesn't have a tree with that
+// name. The tree must not be used once it has been dropped.
+func (db *DB) DropTree(name string) (err error) {
+	tree, err := db.OpenTree(name)
+	if err != nil {
+		return err
+	}
+	db.catalog.store.Begin()
+	defer db.catalog.commit(&err)
+	for _, child := range tree.root.pointers {
+		_, err = tree.freeSubtree(child)
+		if err != nil {
+			return err
+		}
+	}
+	err = tree.store.Free(tree.root.ID)
+	if err != nil {
+		return err
+	}
+	// Release the pin taken when the root was loaded.
+	err = tree.release(tree.root.PageHandle)
+	if err != nil {
+		return err
+	}
+	delete(db.trees, name)
+	return db.catalog.Delete(Key(name))
+}
+
+// Backup writes a copy of the database's file to w, with every tree in it, see
+// Tree.Backup.
+func (db *DB) Backup(w io.Writer) error {
+	return db.catalog.Backup(w)
+}
+
+// BackupIncremental writes the pages of the database's file that have changed since
+// base, a backup of it taken earlier with Backup, to w, see Tree.BackupIncremental.
+func (db *DB) BackupIncremental(w io.Writer, base io.Reader) error {
+	return db.catalog.BackupIncremental(w, base)
+}
+
+// EnableWAL sends every write to the file through a write-ahead log, so that a crash
+// can't leave any of the trees in the file partly written. See Tree.EnableWAL.
+func (db *DB) EnableWAL() error {
+	return db.catalog.EnableWAL()
+}
+
+// EnableCOW turns on copy-on-write mode for every tree in the file. See Tree.EnableCOW.
+func (db *DB) EnableCOW() error {
+	return db.catalog.EnableCOW()
+}
+
+// EnableDoubleWrite guards every tree in the file against torn writes. See
+// Tree.EnableDoubleWrite.
+func (db *DB) EnableDoubleWrite() error {
+	return db.catalog.EnableDoubleWrite()
+}
+
+// Sync syncs every change made to the trees in the file so far to disk.
+func (db *DB) Sync() error {
+	return db.catalog.Sync()
+}
+
+// Checkpoint syncs the changes to the trees in the file committed to its write-ahead log
+// to the file, and empties the log. See Tree.Checkpoint.
+func (db *DB) Checkpoint() error {
+	return db.catalog.Checkpoint()
+}
+
+// WALSegments returns the completed segments of the file's write-ahead log. See
+// Tree.WALSegments.
+func (db *DB) WALSegments() []store.WALSegmentFile {
+	return db.catalog.WALSegments()
+}
+
+// SetDurability chooses when changes to the trees in the file are synced to disk. See
+// Tree.SetDurability.
+func (db *DB) SetDurability(durability store.Durability, interval time.Duration) error {
+	return db.catalog.SetDurability(durability, interval)
+}
+
+// StartFlusher starts writing changes to the trees in the file back to the file in the
+// background. See Tree.StartFlusher.
+func (db *DB) StartFlusher(interval time.Duration, threshold int) error {
+	return db.catalog.StartFlusher(interval, threshold)
+}
+
+// StopFlusher stops the flusher started by StartFlusher.
+func (db *DB) StopFlusher() {
+	db.catalog.StopFlusher()
+}
+
+// Stats returns how the pages of the file are used by all of its trees together. See
+// Tree.DiskUsage for the pages of a single tree.
+func (db *DB) Stats() (store.Stats, error) {
+	return db.catalog.Stats()
+}
+
+// CacheStats returns what the page cache shared by all of the file's trees has done since
+// the file was opened.
+func (db *DB) CacheStats() store.CacheStats {
+	return db.catalog.CacheStats()
+}
+
+// CommitLatency returns a histogram of how long the writes to all of the file's trees
+// have taken to commit.
+func (db *DB) CommitLatency() store.LatencyHistogram {
+	return db.catalog.CommitLatency()
+}
+
+// Close syncs every tree in the file to disk and closes the file. Using the database or
+// any of its trees afterwards returns store.ErrClosed.
+func (db *DB) Close() error {
+	return db.catalog.Close()
+}
+
+// TreeNames returns the names of every tree in the database in sorted order.
+func (db *DB) TreeNames() ([]string, error) {
+	var names []string
+	it := db.catalog.All()
+	for key := range it.Records() {
+		names = append(names, string(key))
+	}
+	return names, it.Err()
+}
+
+// loadTree reads the catalog entry of the tree called name.
+func (db *DB) loadTree(name string) (catalogEntry, error) {
+	value, err := db.catalog.Read(Key(name))
+	if err == ErrKeyNotFound {
+		return catalogEntry{}, ErrTreeNotFound
+	}
+	if err != nil {
+		return catalogEntry{}, err
+	}
+	// Files in store.FormatVersion1 hold roots in 32 bits.
+	root, n := pageIDFromBuffer(value, len(value) == catalogEntrySize(true))
+	return catalogEntry{
+		root:            root,
+		branchingFactor: int(binary.LittleEndian.Uint32(value[n : n+4])),
+		flags:           binary.LittleEndian.Uint32(value[n+4 : n+8]),
+	}, nil
+}
+
+// saveTree records the catalog entry of the tree called name.
+func (db *DB) saveTree(name string, entry catalogEntry) error {
+	wide := db.catalog.wide()
+	value := make(Value, catalogEntrySize(wide))
+	n := pageIDToBuffer(value, entry.root, wide)
+	binary.LittleEndian.PutUint32(value[n:n+4], uint32(entry.branchingFactor))
+	binary.LittleEndian.PutUint32(value[n+4:n+8], entry.flags)
+	return db.catalog.Upsert(Key(name), value)
+}
@@ -0,0 +1,147 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDB(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	db, err := NewDB(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"users", "orders", "items"}
+	for i, name := range names {
+		tree, err := db.CreateTree(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every tree gets the same keys with different values.
+		for j := 0; j < 200; j++ {
+			if err := tree.Insert(intKey(j), intValue(i*1000+j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		verifyTree(t, tree)
+	}
+	if _, err := db.CreateTree("users"); err != ErrTreeExists {
+		t.Fatalf("expected %v == %v", err, ErrTreeExists)
+	}
+	if _, err := db.OpenTree("missing"); err != ErrTreeNotFound {
+		t.Fatalf("expected %v == %v", err, ErrTreeNotFound)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, db.catalog)
+	if _, err := OpenTree(tmpfile.Name(), WithCacheSize(40)); err != ErrDB {
+		t.Fatalf("expected %v == %v", err, ErrDB)
+	}
+
+	reopened, err := OpenDB(tmpfile.Name(), WithCacheSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.TreeNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"items", "orders", "users"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v == %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v == %v", got, expected)
+		}
+	}
+	for i, name := range names {
+		tree, err := reopened.OpenTree(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Len() != 200 {
+			t.Fatalf("expected %v == %v", tree.Len(), 200)
+		}
+		for j := 0; j < 200; j++ {
+			value, err := tree.Read(intKey(j))
+			if err != nil {
+				t.Fatal(name, j, err)
+			}
+			if !bytes.Equal(value, intValue(i*1000+j)) {
+				t.Fatalf("expected %v == %v", value, intValue(i*1000+j))
+			}
+		}
+		verifyTree(t, tree)
+	}
+}
+
+func TestDropTree(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "drop_tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	db, err := NewDB(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := db.CreateTree("kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := db.CreateTree("dropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if err := dropped.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DropTree("dropped"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DropTree("dropped"); err != ErrTreeNotFound {
+		t.Fatalf("expected %v == %v", err, ErrTreeNotFound)
+	}
+	// The pages of the dropped tree are reused before the file grows.
+	for i := 0; i < 500; i++ {
+		if err := kept.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != before.Size() {
+		t.Fatalf("expected %v == %v", after.Size(), before.Size())
+	}
+	verifyTree(t, kept)
+	recreated, err := db.CreateTree("dropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recreated.Len() != 0 {
+		t.Fatalf("expected %v == %v", recreated.Len(), 0)
+	}
+	verifyTree(t, recreated)
+}
@@ -0,0 +1,211 @@
+package bplus
+
+import (
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// placement is where a page sits in the tree. The root has no parent and a depth of 0.
+type placement struct {
+	id     store.PageID
+	parent *placement
+	depth  int
+}
+
+// Defragment moves up to maxMoves of the tree's pages from the end of the file into free
+// pages nearer the front of it, pointing their parents at their new pages, then cuts the
+// free pages left at the end of the file off, see store.PageStore.Shrink. It returns the
+// number of pages moved, which is 0 once there's nothing left to move.
+//
+// The tree is locked for as long as a call takes, and each call only reads the tree's
+// branches, so a large file can be compacted while it's in use by calling Defragment
+// with a small maxMoves until it returns 0. Each call's moves are made in one group, see
+// store.PageStore.Begin.
+func (tree *Tree) Defragment(maxMoves int) (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.store.ReadOnly() {
+		return 0, store.ErrReadOnly
+	}
+	err := tree.store.SortFreeList()
+	if err != nil {
+		return 0, err
+	}
+	n, err := tree.defragment(maxMoves)
+	if err != nil {
+		return n, err
+	}
+	return n, tree.store.Shrink(false)
+}
+
+// defragment moves up to maxMoves pages, starting with the last page in the file.
+func (tree *Tree) defragment(maxMoves int) (n int, err error) {
+	placements, err := tree.placements()
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(placements, func(i, j int) bool {
+		return placements[i].id > placements[j].id
+	})
+	tree.version.Add(1)
+	tree.store.Begin()
+	defer tree.commit(&err)
+	moved := map[store.PageID]store.PageID{}
+	var oldIDs []store.PageID
+	var parents []*placement
+	for _, p := range placements {
+		if n == maxMoves {
+			break
+		}
+		newID, err := tree.store.Move(p.id)
+		if err != nil {
+			return n, err
+		}
+		if newID == p.id {
+			// The first free page comes after this page, and so after every page left.
+			break
+		}
+		moved[p.id] = newID
+		oldIDs = append(oldIDs, p.id)
+		if p.parent != nil {
+			parents = append(parents, p.parent)
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	err = tree.repoint(parents, moved)
+	if err != nil {
+		return n, err
+	}
+	if tree.blink() {
+		// The pages to the left of the moved pages still link to where they were.
+		err = tree.relink(nil, nil)
+		if err != nil {
+			return n, err
+		}
+	}
+	// Freeing the pages only once they've all been moved keeps the free pages that are
+	// nearest the front of the file at the front of the free list.
+	for _, oldID := range oldIDs {
+		err = tree.store.Free(oldID)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// placements returns where every page of the tree sits in it. Only branches are read,
+// since the leaves are found in the pointers of the branches above them.
+func (tree *Tree) placements() ([]*placement, error) {
+	root := &placement{id: tree.root.ID}
+	placements := []*placement{root}
+	if len(tree.root.pointers) == 0 {
+		return placements, nil
+	}
+	height, err := tree.leafDepth()
+	if err != nil {
+		return nil, err
+	}
+	level := []*placement{root}
+	branches := []*branchPage{tree.root}
+	for depth := 1; depth <= height; depth++ {
+		var next []*placement
+		var nextBranches []*branchPage
+		for i, parent := range level {
+			for _, pointer := range branches[i].pointers {
+				p := &placement{id: pointer, parent: parent, depth: depth}
+				placements = append(placements, p)
+				if depth == height {
+					continue
+				}
+				_, branch, err := tree.loadNode(pointer)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, p)
+				nextBranches = append(nextBranches, branch)
+			}
+		}
+		level, branches = next, nextBranches
+	}
+	return placements, nil
+}
+
+// repoint points the parents of moved pages at the pages they moved to. In copy-on-write
+// mode, writing a parent moves it too, so every branch above it is written as well.
+func (tree *Tree) repoint(
+	parents []*placement,
+	moved map[store.PageID]store.PageID,
+) error {
+	if root, ok := moved[tree.root.ID]; ok {
+		oldRoot := tree.root
+		err := tree.loadRootNode(root)
+		if err != nil {
+			return err
+		}
+		err = tree.release(oldRoot.PageHandle)
+		if err != nil {
+			return err
+		}
+	}
+	written := map[*placement]bool{}
+	var branches []*placement
+	for _, p := range parents {
+		for ; p != nil && !written[p]; p = p.parent {
+			written[p] = true
+			branches = append(branches, p)
+			if !tree.store.COW() {
+				break
+			}
+		}
+	}
+	// Children are written before their parents, which copy-on-write mode relies on to
+	// point the parents at where the children were copied to.
+	sort.SliceStable(branches, func(i, j int) bool {
+		return branches[i].depth > branches[j].depth
+	})
+	for _, p := range branches {
+		err := tree.repointBranch(p, moved)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repointBranch points a branch at the pages its children moved to.
+func (tree *Tree) repointBranch(p *placement, moved map[store.PageID]store.PageID) error {
+	if p.parent == nil {
+		repointChildren(tree.root, moved)
+		return tree.writeBranch(tree.root)
+	}
+	pageID := p.id
+	if newID, ok := moved[pageID]; ok {
+		pageID = newID
+	}
+	page, err := tree.store.Load(pageID)
+	if err != nil {
+		return err
+	}
+	branch := &branchPage{PageHandle: page}
+	branch.fromBuffer()
+	repointChildren(branch, moved)
+	err = tree.writeBranch(branch)
+	releaseErr := tree.release(page)
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+func repointChildren(branch *branchPage, moved map[store.PageID]store.PageID) {
+	for i, pointer := range branch.pointers {
+		if newID, ok := moved[pointer]; ok {
+			branch.pointers[i] = newID
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestDefragment(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		new  func(filename string, opts ...Option) (*Tree, error)
+		cow  bool
+	}{
+		{name: "tree", new: NewTree},
+		{name: "blink", new: NewBLinkTree},
+		{name: "cow", new: NewTree, cow: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tmpfile, err := ioutil.TempFile("", "defragment")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tmpfile.Close()
+			tree, err := test.new(
+				tmpfile.Name(),
+				WithBranchingFactor(4),
+				WithCacheSize(64),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.cow {
+				if err := tree.EnableCOW(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defragment(t, tree, tmpfile.Name())
+			if err := tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+			tree, err = OpenTree(tmpfile.Name(), WithCacheSize(64))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			verifyTree(t, tree)
+			expectDefragmented(t, tree)
+		})
+	}
+}
+
+// defragment fills a tree, deletes most of what's at the front of it, and defragments
+// it a few pages at a time.
+func defragment(t *testing.T, tree *Tree, filename string) {
+	t.Helper()
+	for i := 0; i < 2000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(0), intKey(1500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Shrink(false); err != nil {
+		t.Fatal(err)
+	}
+	before := fileSize(t, filename)
+	total := 0
+	for {
+		n, err := tree.Defragment(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 10 {
+			t.Fatalf("expected %v <= %v", n, 10)
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+		verifyTree(t, tree)
+	}
+	if total == 0 {
+		t.Fatal("expected pages to be moved")
+	}
+	if after := fileSize(t, filename); after >= before {
+		t.Fatalf("expected %v < %v", after, before)
+	}
+	placements, err := tree.placements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every page but the header, its copy and the free-space map belongs to the tree.
+	size := int64(len(placements)+3) * store.PageSize
+	if after := fileSize(t, filename); after != size {
+		t.Fatalf("expected %v == %v", after, size)
+	}
+	expectDefragmented(t, tree)
+}
+
+func expectDefragmented(t *testing.T, tree *Tree) {
+	t.Helper()
+	if tree.Len() != 500 {
+		t.Fatalf("expected %v == %v", tree.Len(), 500)
+	}
+	for i := 1500; i < 2000; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+}
+
+func TestDefragmentDBTree(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "defragment_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	db, err := NewDB(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := db.CreateTree("defragment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(0), intKey(1500)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		n, err := tree.Defragment(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	verifyTree(t, tree)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The tree's root was recorded in the catalog wherever it moved to.
+	db, err = OpenDB(tmpfile.Name(), WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tree, err = db.OpenTree("defragment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	expectDefragmented(t, tree)
+}
@@ -0,0 +1,368 @@
+package bplus
+
+import (
+	"bytes"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Delete a key value pair from the tree, return ErrKeyNotFound if it's not found. With
+// lazy deletion on, the record is replaced by a tombstone, see SetLazyDelete.
+func (tree *Tree) Delete(key Key) (err error) {
+	defer tree.startSpan("bplus.Delete").end(&err)
+	if tree.LazyDelete() {
+		return tree.deleteLazily(key)
+	}
+	tree.timedLock()
+	defer tree.lock.Unlock()
+	// The smallest key greater than key is key with a zero byte appended to it.
+	end := append(append(Key{}, key...), 0)
+	n, err := tree.removeRange(key, end)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// DeleteRange removes every record with a key in [start, end) and returns the number of
+// records removed. Subtrees that fall entirely within the range are unlinked and freed as
+// a whole rather than one record at a time.
+func (tree *Tree) DeleteRange(start, end Key) (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.removeRange(start, end)
+}
+
+// removeRange removes the records in [start, end) with the tree's lock held.
+func (tree *Tree) removeRange(start, end Key) (n int, err error) {
+	if tree.store.ReadOnly() {
+		return 0, store.ErrReadOnly
+	}
+	if len(tree.root.pointers) == 0 || bytes.Compare(start, end) >= 0 {
+		return 0, nil
+	}
+	events, err := tree.rangeEvents(start, end)
+	if err != nil {
+		return 0, err
+	}
+	err = tree.before(events)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err == nil {
+			tree.after(events)
+		}
+	}()
+	tree.version.Add(1)
+	tree.store.Begin()
+	defer tree.commit(&err)
+	n, _, err = tree.deleteRange(tree.root.PageHandle, start, end)
+	if err != nil {
+		return n, err
+	}
+	tree.root.fromBuffer()
+	err = tree.shrinkRoot()
+	if err != nil || !tree.blink() {
+		return n, err
+	}
+	return n, tree.relink(start, end)
+}
+
+// deleteRange removes records in [start, end) from the subtree rooted at node. It returns
+// the number of records removed and whether node now underflows.
+func (tree *Tree) deleteRange(node *store.PageHandle, start, end Key) (int, bool, error) {
+	if isLeafPage(node) {
+		leaf := &leafPage{PageHandle: node}
+		leaf.fromBuffer()
+		i, _ := leaf.search(start)
+		j, _ := leaf.search(end)
+		if i >= j {
+			return 0, false, nil
+		}
+		// Tombstones are removed along with the records but were already deleted.
+		n := liveCount(leaf.records[i:j])
+		leaf.records = append(leaf.records[:i], leaf.records[j:]...)
+		err := tree.writeLeaf(leaf)
+		return n, tree.underflows(len(leaf.records), leaf.size()), err
+	}
+
+	branch := &branchPage{PageHandle: node}
+	branch.fromBuffer()
+	first := branch.childIndex(start)
+	last := branch.childIndex(end)
+	count := 0
+	// Every child strictly between the first and last child is entirely within the range.
+	for i := first + 1; i < last; i++ {
+		n, err := tree.freeSubtree(branch.pointers[i])
+		count += n
+		if err != nil {
+			return count, false, err
+		}
+	}
+	changed := last > first+1
+	if changed {
+		branch.pointers = append(branch.pointers[:first+1], branch.pointers[last:]...)
+		branch.counts = append(branch.counts[:first+1], branch.counts[last:]...)
+		branch.keys = append(branch.keys[:first], branch.keys[last-1:]...)
+		last = first + 1
+	}
+
+	underflowing := []int{}
+	for i := first; i <= last; i++ {
+		child, err := tree.store.Load(branch.pointers[i])
+		if err != nil {
+			return count, false, err
+		}
+		n, underflow, err := tree.deleteRange(child, start, end)
+		count += n
+		branch.counts[i] = pageCount(child)
+		// In copy-on-write mode, a child that was written has moved to a fresh page.
+		if child.ID != branch.pointers[i] {
+			changed = true
+		}
+		releaseErr := tree.release(child)
+		if err != nil {
+			return count, false, err
+		}
+		if releaseErr != nil {
+			return count, false, releaseErr
+		}
+		if underflow {
+			underflowing = append(underflowing, i)
+		}
+	}
+	if count == 0 && !changed {
+		return 0, false, nil
+	}
+	// Rebalance from the right so that merges don't shift the indexes still to be done.
+	for i := len(underflowing) - 1; i >= 0; i-- {
+		if underflowing[i] < len(branch.pointers) {
+			err := tree.rebalance(branch, underflowing[i])
+			if err != nil {
+				return count, false, err
+			}
+		}
+	}
+	err := tree.writeBranch(branch)
+	return count, tree.underflows(len(branch.keys), branch.size()), err
+}
+
+// underflows reports whether a page with n keys and the given encoded size is too empty
+// and should be merged with or borrow from a sibling. Pages are only considered
+// underflowing if they are under half full by both the branching factor and page size.
+func (tree *Tree) underflows(n, size int) bool {
+	return n < (tree.branchingFactor-1)/2 && size < store.UsablePageSize/2
+}
+
+// rebalance fixes the underflowing child at index i of branch by merging it with a
+// sibling, or if the two don't fit in a page, evenly redistributing their entries.
+func (tree *Tree) rebalance(branch *branchPage, i int) error {
+	if len(branch.pointers) < 2 {
+		return nil
+	}
+	// An earlier rebalance may have already fixed this child.
+	leaf, child, err := tree.loadNode(branch.pointers[i])
+	if err != nil {
+		return err
+	}
+	if leaf != nil && !tree.underflows(len(leaf.records), leaf.size()) {
+		return nil
+	}
+	if child != nil && !tree.underflows(len(child.keys), child.size()) {
+		return nil
+	}
+	left := i
+	if left == len(branch.pointers)-1 {
+		left--
+	}
+	leftPage, err := tree.store.Load(branch.pointers[left])
+	if err != nil {
+		return err
+	}
+	rightPage, err := tree.store.Load(branch.pointers[left+1])
+	if err != nil {
+		return err
+	}
+	var merged bool
+	if isLeafPage(leftPage) {
+		merged, err = tree.rebalanceLeaves(branch, left, leftPage, rightPage)
+	} else {
+		merged, err = tree.rebalanceBranches(branch, left, leftPage, rightPage)
+	}
+	if err != nil {
+		return err
+	}
+	err = tree.release(leftPage)
+	if err != nil {
+		return err
+	}
+	err = tree.release(rightPage)
+	if err != nil {
+		return err
+	}
+	if !merged {
+		return nil
+	}
+	rightID := branch.pointers[left+1]
+	branch.keys = append(branch.keys[:left], branch.keys[left+1:]...)
+	branch.pointers = append(branch.pointers[:left+1], branch.pointers[left+2:]...)
+	branch.counts = append(branch.counts[:left+1], branch.counts[left+2:]...)
+	err = tree.store.Free(rightID)
+	if err != nil {
+		return err
+	}
+	// Merging two nearly empty pages can leave a page that is still underflowing.
+	return tree.rebalance(branch, left)
+}
+
+func (tree *Tree) rebalanceLeaves(
+	parent *branchPage,
+	i int,
+	leftPage, rightPage *store.PageHandle,
+) (bool, error) {
+	left := &leafPage{PageHandle: leftPage}
+	left.fromBuffer()
+	right := &leafPage{PageHandle: rightPage}
+	right.fromBuffer()
+	records := append(left.records, right.records...)
+	left.records = records
+	if !tree.overflows(len(records), left.size()) {
+		parent.counts[i] = liveCount(records)
+		return true, tree.writeLeaf(left)
+	}
+	sizes := make([]int, len(records))
+	for j, r := range records {
+		sizes[j] = r.size()
+	}
+	mid := splitIndex(sizes, leafHeaderSize+tree.trailerSize())
+	left.records = records[:mid]
+	right.records = records[mid:]
+	parent.keys[i] = right.records[0].Key
+	parent.counts[i] = liveCount(left.records)
+	parent.counts[i+1] = liveCount(right.records)
+	err := tree.writeLeaf(left)
+	if err != nil {
+		return false, err
+	}
+	return false, tree.writeLeaf(right)
+}
+
+func (tree *Tree) rebalanceBranches(
+	parent *branchPage,
+	i int,
+	leftPage, rightPage *store.PageHandle,
+) (bool, error) {
+	left := &branchPage{PageHandle: leftPage}
+	left.fromBuffer()
+	right := &branchPage{PageHandle: rightPage}
+	right.fromBuffer()
+	// The separator in the parent comes down between the two halves.
+	left.keys = append(append(left.keys, parent.keys[i]), right.keys...)
+	left.pointers = append(left.pointers, right.pointers...)
+	left.counts = append(left.counts, right.counts...)
+	// The children on either side of where the two branches meet may both be left over
+	// from a range delete, so they're rebalanced before deciding whether the branches fit
+	// in one page.
+	junction := len(left.pointers) - len(right.pointers) - 1
+	for _, j := range []int{junction + 1, junction} {
+		if j < len(left.pointers) {
+			err := tree.rebalance(left, j)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	if !tree.overflows(len(left.keys), left.size()) {
+		parent.counts[i] = left.count()
+		return true, tree.writeBranch(left)
+	}
+	keys, pointers, counts := left.keys, left.pointers, left.counts
+	sizes := make([]int, len(keys))
+	for j, k := range keys {
+		sizes[j] = branchEntrySize(k)
+	}
+	mid := splitIndex(sizes, branchHeaderSize+tree.trailerSize())
+	left.keys = keys[:mid]
+	left.pointers = pointers[:mid+1]
+	left.counts = counts[:mid+1]
+	parent.keys[i] = keys[mid]
+	right.keys = keys[mid+1:]
+	right.pointers = pointers[mid+1:]
+	right.counts = counts[mid+1:]
+	parent.counts[i] = left.count()
+	parent.counts[i+1] = right.count()
+	err := tree.writeBranch(left)
+	if err != nil {
+		return false, err
+	}
+	return false, tree.writeBranch(right)
+}
+
+// shrinkRoot removes levels from the top of the tree while the root has a single branch
+// child, and frees the last leaf once the tree is empty.
+func (tree *Tree) shrinkRoot() error {
+	for len(tree.root.pointers) == 1 {
+		childID := tree.root.pointers[0]
+		leaf, branch, err := tree.loadNode(childID)
+		if err != nil {
+			return err
+		}
+		if leaf != nil {
+			if len(leaf.records) != 0 {
+				return nil
+			}
+			tree.root.keys = nil
+			tree.root.pointers = nil
+			tree.root.counts = nil
+			err = tree.writeBranch(tree.root)
+			if err != nil {
+				return err
+			}
+			return tree.store.Free(childID)
+		}
+		oldRoot := tree.root
+		oldRootID := oldRoot.ID
+		err = tree.loadRootNode(branch.ID)
+		if err != nil {
+			return err
+		}
+		err = tree.setRoot(branch.ID)
+		if err != nil {
+			return err
+		}
+		err = tree.release(oldRoot.PageHandle)
+		if err != nil {
+			return err
+		}
+		err = tree.store.Free(oldRootID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeSubtree frees every page in a subtree and returns the number of records it held.
+func (tree *Tree) freeSubtree(pageID store.PageID) (int, error) {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	if leaf != nil {
+		count = liveCount(leaf.records)
+	} else {
+		for _, child := range branch.pointers {
+			n, err := tree.freeSubtree(child)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, tree.store.Free(pageID)
+}
@@ -0,0 +1,176 @@
+package bplus
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	tree, err := newTree("delete", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(1000) {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted := map[int]bool{}
+	for _, i := range r.Perm(1000)[:500] {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(i, err)
+		}
+		deleted[i] = true
+	}
+	for i := 0; i < 1000; i++ {
+		value, err := tree.Read(intKey(i))
+		if deleted[i] {
+			if err != ErrKeyNotFound {
+				t.Fatalf("found deleted value %+v for %d", value, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+	if err := tree.Delete(intKey(1000)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	for i := 0; i < 1000; i++ {
+		if deleted[i] {
+			continue
+		}
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if len(tree.root.pointers) != 0 {
+		t.Fatalf("expected an empty root, got %d pointers", len(tree.root.pointers))
+	}
+	if _, err := tree.Min(); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	// The emptied tree should be reusable.
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := tree.Read(intKey(i)); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	for _, bf := range []int{4, 7, 1000} {
+		tree, err := newTree("delete_range", bf, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := tree.Insert(intKey(i), bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n, err := tree.DeleteRange(intKey(100), intKey(900))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 800 {
+			t.Fatalf("expected %d == 800", n)
+		}
+		n, err = tree.DeleteRange(intKey(100), intKey(900))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatalf("expected %d == 0", n)
+		}
+		for i := 0; i < 1000; i++ {
+			_, err := tree.Read(intKey(i))
+			if i >= 100 && i < 900 {
+				if err != ErrKeyNotFound {
+					t.Fatalf("expected %d to be deleted", i)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(i, err)
+			}
+		}
+		ceiling, err := tree.Ceiling(intKey(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ceiling.Key, intKey(900)) {
+			t.Fatalf("expected %v == %v", ceiling.Key, intKey(900))
+		}
+		n, err = tree.DeleteRange(intKey(0), intKey(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 200 {
+			t.Fatalf("expected %d == 200", n)
+		}
+		if len(tree.root.pointers) != 0 {
+			t.Fatalf("expected an empty root, got %d pointers", len(tree.root.pointers))
+		}
+	}
+}
+
+func TestDeleteRangeRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, bf := range []int{3, 4, 5, 16} {
+		tree, err := newTree("delete_range_randomized", bf, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := map[int]bool{}
+		for round := 0; round < 30; round++ {
+			for _, i := range r.Perm(1000)[:50] {
+				err := tree.Upsert(intKey(i), intValue(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				model[i] = true
+			}
+			start := r.Intn(1000)
+			end := start + r.Intn(150)
+			n, err := tree.DeleteRange(intKey(start), intKey(end))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := 0
+			for i := start; i < end; i++ {
+				if model[i] {
+					expected++
+					delete(model, i)
+				}
+			}
+			if n != expected {
+				t.Fatalf("expected %d == %d", n, expected)
+			}
+			if tree.Len() != len(model) {
+				t.Fatalf("expected %d == %d", tree.Len(), len(model))
+			}
+			verifyTree(t, tree)
+			for i := 0; i < 1000; i++ {
+				_, err := tree.Read(intKey(i))
+				if model[i] && err != nil {
+					t.Fatal(i, err)
+				}
+				if !model[i] && err != ErrKeyNotFound {
+					t.Fatalf("expected %d to be missing", i)
+				}
+			}
+		}
+	}
+}
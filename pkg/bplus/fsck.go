@@ -0,0 +1,391 @@
+package bplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// CheckReport is the result of checking a file with Check.
+type CheckReport struct {
+	// Pages is the number of pages in the file, including the header.
+	Pages int
+	// Trees is the number of trees in the file, not counting a database's catalog.
+	Trees int
+	// Records is the number of records in the trees.
+	Records int
+	// Problems lists everything found wrong with the file, in the order it was found.
+	Problems []Violation
+	// Orphans lists the pages that are neither free nor used by any tree, which take up
+	// space but do no harm, see Tree.CollectGarbage. They're only looked for in files
+	// without problems, and whether they match their checksums doesn't matter.
+	Orphans []store.PageID
+}
+
+// OK reports whether no problems were found.
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// problem adds a problem to the report, unless it's already there, which happens when
+// the trees of a database each find the same problem in the pages they share.
+func (r *CheckReport) problem(v Violation) {
+	for _, p := range r.Problems {
+		if p == v {
+			return
+		}
+	}
+	r.Problems = append(r.Problems, v)
+}
+
+// Check checks the tree, multimap or database in a file for corruption without changing
+// it. The header has to fit the file, see store.PageStore.CheckHeader, every page has to
+// match its checksum, and every tree has to pass Verify, which also checks the file's
+// free space. Pages that no tree uses and that aren't free are listed in the report as
+// orphans. The file is opened read-only, and the options configure how it's opened.
+// Problems are collected in the report, and an error is only returned if the file can't
+// be opened or a page can't be read.
+func Check(filename string, opts ...Option) (report *CheckReport, err error) {
+	opts = append(opts[:len(opts):len(opts)], WithReadOnly())
+	tree, err := openTree(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTree(tree, &err)
+	report = &CheckReport{}
+	s := tree.store
+	var headerErr *store.HeaderError
+	err = s.CheckHeader()
+	if errors.As(err, &headerErr) {
+		report.problem(Violation{PageID: 0, Reason: headerErr.Error()})
+	} else if err != nil {
+		return report, err
+	}
+	stats, err := s.Stats()
+	if err != nil {
+		return report, err
+	}
+	report.Pages = stats.Pages
+	var mismatched []store.PageID
+	for pageID := store.PageID(1); int(pageID) < stats.Pages; pageID++ {
+		page, err := s.Load(pageID)
+		var mismatch *store.ErrChecksumMismatch
+		if errors.As(err, &mismatch) {
+			mismatched = append(mismatched, pageID)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		err = page.Release()
+		if err != nil {
+			return report, err
+		}
+	}
+	orphans := tree.Orphans
+	if tree.flags&dbFlag == 0 {
+		report.Trees = 1
+		report.Records, err = checkTree(report, tree, "")
+		if err != nil {
+			return report, err
+		}
+	} else {
+		db := &DB{catalog: tree, trees: map[string]*Tree{}}
+		err = checkDB(report, db)
+		if err != nil {
+			return report, err
+		}
+		orphans = db.Orphans
+	}
+	if report.OK() {
+		report.Orphans, err = orphans()
+		if err != nil {
+			return report, err
+		}
+	}
+	// A page that a crash tore while it was being written leaks it, which is harmless.
+	orphaned := map[store.PageID]bool{}
+	for _, pageID := range report.Orphans {
+		orphaned[pageID] = true
+	}
+	for _, pageID := range mismatched {
+		if !orphaned[pageID] {
+			report.problem(Violation{PageID: pageID, Reason: "checksum mismatch"})
+		}
+	}
+	return report, nil
+}
+
+// checkDB verifies the catalog of a database and every tree in it.
+func checkDB(report *CheckReport, db *DB) error {
+	_, err := checkTree(report, db.catalog, "catalog: ")
+	if err != nil {
+		return err
+	}
+	names, err := db.TreeNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		named, err := db.OpenTree(name)
+		if err != nil {
+			return err
+		}
+		report.Trees++
+		records, err := checkTree(report, named, fmt.Sprintf("tree %q: ", name))
+		if err != nil {
+			return err
+		}
+		report.Records += records
+	}
+	return nil
+}
+
+// checkTree verifies a tree, adding the invariants it breaks to the report with their
+// reasons prefixed, and returns the number of records in it.
+func checkTree(report *CheckReport, tree *Tree, prefix string) (int, error) {
+	verified, err := tree.Verify()
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range verified.Violations {
+		report.problem(Violation{PageID: v.PageID, Reason: prefix + v.Reason})
+	}
+	return verified.Records, nil
+}
+
+// SalvageReport is the result of salvaging a file with Salvage.
+type SalvageReport struct {
+	// Trees is the number of trees salvaged, not counting a database's catalog.
+	Trees int
+	// Records is the number of records salvaged.
+	Records int
+	// LostPages lists the pages of the trees that couldn't be read, whose records are
+	// lost along with those of every page under them, in the order they were found.
+	LostPages []store.PageID
+	// Dropped is the number of records found that couldn't be salvaged, because they
+	// were outside the separators above them or their values couldn't be read from the
+	// value log.
+	Dropped int
+	// LostTrees lists the trees of a database whose roots couldn't be read.
+	LostTrees []string
+}
+
+// Salvage copies what can still be read of the tree, multimap or database in the file
+// src into the file dst, which must not hold a tree yet, to recover from corruption
+// that Check finds. Like Copy, every tree is rebuilt from its records, so the copy starts
+// with no free pages and has a free list, or free-space map, of its own. Rather than
+// giving up at the first page that can't be read, each tree is walked from its root, and
+// the records of every page that can be read are kept, as long as they're between the
+// separators above them. The pages that can't be read are skipped, and the records under
+// them are lost. The root of src has to be readable.
+func Salvage(src, dst string, opts ...Option) (report *SalvageReport, err error) {
+	fromOpts := append(opts[:len(opts):len(opts)], WithReadOnly())
+	from, err := openTree(src, fromOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTree(from, &err)
+	to, err := NewTree(dst, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTree(to, &err)
+	if from.values != nil && to.values == nil {
+		err = to.useValueLog(dst, options{
+			valueThreshold:      from.values.threshold,
+			valueLogSegmentSize: from.values.segmentSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	report = &SalvageReport{}
+	if from.flags&dbFlag == 0 {
+		report.Trees = 1
+		return report, salvageTree(report, from, to)
+	}
+	err = copySettings(from, to)
+	if err != nil {
+		return report, err
+	}
+	// The catalog is salvaged like any other tree, for the names and roots of the trees.
+	catalog, err := newSalvager(from, report).walkRoot()
+	if err != nil {
+		return report, err
+	}
+	toDB := &DB{catalog: to, trees: map[string]*Tree{}}
+	for _, r := range catalog {
+		wide := len(r.Value) == catalogEntrySize(true)
+		if !wide && len(r.Value) != catalogEntrySize(false) {
+			report.LostTrees = append(report.LostTrees, string(r.Key))
+			continue
+		}
+		root, n := pageIDFromBuffer(r.Value, wide)
+		named := &Tree{
+			store:           from.store,
+			branchingFactor: int(binary.LittleEndian.Uint32(r.Value[n : n+4])),
+			flags:           binary.LittleEndian.Uint32(r.Value[n+4 : n+8]),
+		}
+		err = named.loadRootNode(root)
+		if err != nil {
+			report.LostTrees = append(report.LostTrees, string(r.Key))
+			continue
+		}
+		toTree, err := toDB.CreateTree(string(r.Key))
+		if err != nil {
+			return report, err
+		}
+		err = salvageTree(report, named, toTree)
+		if err != nil {
+			return report, err
+		}
+		report.Trees++
+	}
+	return report, nil
+}
+
+// salvageTree bulk loads what can be read of the records of from into the empty tree to.
+func salvageTree(report *SalvageReport, from, to *Tree) error {
+	records, err := newSalvager(from, report).walkRoot()
+	if err != nil {
+		return err
+	}
+	err = copySettings(from, to)
+	if err != nil {
+		return err
+	}
+	report.Records += len(records)
+	return to.BulkLoad(records, copyFillFactor)
+}
+
+// salvager walks a tree collecting the records it can read, see Salvage.
+type salvager struct {
+	tree    *Tree
+	report  *SalvageReport
+	visited map[store.PageID]bool
+	// pages is the number of pages in the file, past which pointers can't point.
+	pages   int
+	records []Record
+}
+
+func newSalvager(tree *Tree, report *SalvageReport) *salvager {
+	return &salvager{tree: tree, report: report, visited: map[store.PageID]bool{}}
+}
+
+// walkRoot returns the records that can be read from the tree, sorted by key, keeping
+// the first of any records with the same key.
+func (s *salvager) walkRoot() ([]Record, error) {
+	stats, err := s.tree.store.Stats()
+	if err != nil {
+		return nil, err
+	}
+	s.pages = stats.Pages
+	root := s.tree.root
+	s.visited[root.ID] = true
+	s.walkBranch(root, keyBounds{})
+	records := s.records
+	sort.SliceStable(records, func(i, j int) bool {
+		return bytes.Compare(records[i].Key, records[j].Key) < 0
+	})
+	unique := records[:0]
+	for i, r := range records {
+		if i > 0 && bytes.Equal(r.Key, records[i-1].Key) {
+			s.report.Dropped++
+			continue
+		}
+		unique = append(unique, r)
+	}
+	return unique, nil
+}
+
+func (s *salvager) walk(pageID store.PageID, bounds keyBounds) {
+	if s.visited[pageID] {
+		// The page's records have already been salvaged through another pointer.
+		return
+	}
+	if pageID == 0 || int(pageID) >= s.pages {
+		s.report.LostPages = append(s.report.LostPages, pageID)
+		return
+	}
+	s.visited[pageID] = true
+	leaf, branch, err := s.load(pageID)
+	if err != nil {
+		s.report.LostPages = append(s.report.LostPages, pageID)
+		return
+	}
+	if branch != nil {
+		s.walkBranch(branch, bounds)
+		return
+	}
+	for _, r := range leaf.records {
+		if r.tombstone {
+			continue
+		}
+		if !bounds.contains(r.Key) {
+			s.report.Dropped++
+			continue
+		}
+		if r.pointer {
+			if s.tree.values == nil {
+				s.report.Dropped++
+				continue
+			}
+			r.Value, err = s.tree.values.read(r.Key, valuePointerFromBuffer(r.Value))
+			if err != nil {
+				s.report.Dropped++
+				continue
+			}
+			r.pointer = false
+		}
+		s.records = append(s.records, r)
+	}
+}
+
+// walkBranch walks the children of a branch, narrowing the bounds of each by the
+// separators either side of it. A branch with too few keys for its pointers has the
+// children past its last key bounded by its last key and its own upper bound.
+func (s *salvager) walkBranch(branch *branchPage, bounds keyBounds) {
+	for i, pointer := range branch.pointers {
+		child := bounds
+		if n := min(i, len(branch.keys)); n > 0 {
+			child.lower = branch.keys[n-1]
+		}
+		if i < len(branch.keys) {
+			child.upper = branch.keys[i]
+			child.bounded = true
+		}
+		s.walk(pointer, child)
+	}
+}
+
+// load loads a page of the tree, returning ErrPageCorrupt rather than panicking if it's
+// laid out so badly that it can't be decoded.
+func (s *salvager) load(
+	pageID store.PageID,
+) (leaf *leafPage, branch *branchPage, err error) {
+	page, err := s.tree.store.Load(pageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if recover() != nil {
+			leaf, branch, err = nil, nil, ErrPageCorrupt
+		}
+		if releaseErr := page.Release(); err == nil {
+			err = releaseErr
+		}
+	}()
+	if isLeafPage(page) {
+		leaf = &leafPage{PageHandle: page}
+		leaf.fromBuffer()
+	} else {
+		branch = &branchPage{PageHandle: page}
+		branch.fromBuffer()
+	}
+	return leaf, branch, nil
+}
@@ -0,0 +1,168 @@
+package bplus
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestCheck(t *testing.T) {
+	filename := tempFilename(t, "check")
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leafID := firstLeaf(t, tree)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Check(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Trees != 1 || report.Records != 100 {
+		t.Fatalf("expected a clean report of 1 tree and 100 records, got %+v", report)
+	}
+
+	flipByte(t, filename, int64(leafID)*store.PageSize+100)
+	report, err = Check(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.Problems[0].PageID != leafID {
+		t.Fatalf("expected a problem with page %d, got %v", leafID, report.Problems)
+	}
+	if report.Problems[0].Reason != "checksum mismatch" {
+		t.Fatalf("expected %v == checksum mismatch", report.Problems[0].Reason)
+	}
+
+	// Every record but the ones in the corrupt leaf is salvaged into a sound file.
+	salvaged := tempFilename(t, "check_salvaged")
+	salvageReport, err := Salvage(filename, salvaged, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(salvageReport.LostPages) != 1 || salvageReport.LostPages[0] != leafID {
+		t.Fatalf("expected %v == [%v]", salvageReport.LostPages, leafID)
+	}
+	report, err = Check(salvaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Records != salvageReport.Records {
+		t.Fatalf("expected a clean report of %d records, got %+v",
+			salvageReport.Records, report)
+	}
+	copied, err := OpenTree(salvaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	if _, err := copied.Read(intKey(0)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	for i := 100 - salvageReport.Records; i < 100; i++ {
+		value, err := copied.Read(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+}
+
+func TestCheckDB(t *testing.T) {
+	filename := tempFilename(t, "check_db")
+	db, err := NewDB(filename, WithBranchingFactor(4), WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		tree, err := db.CreateTree(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 50; i++ {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Check(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Trees != 2 || report.Records != 100 {
+		t.Fatalf("expected a clean report of 2 trees and 100 records, got %+v", report)
+	}
+
+	salvaged := tempFilename(t, "check_db_salvaged")
+	salvageReport, err := Salvage(filename, salvaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if salvageReport.Trees != 2 || salvageReport.Records != 100 {
+		t.Fatalf("expected 2 trees and 100 records, got %+v", salvageReport)
+	}
+	copied, err := OpenDB(salvaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	tree, err := copied.OpenTree("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := tree.Read(intKey(49))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(49)) {
+		t.Fatalf("expected %v == %v", value, intValue(49))
+	}
+}
+
+// firstLeaf returns the leaf holding the smallest key of a tree.
+func firstLeaf(t *testing.T, tree *Tree) store.PageID {
+	t.Helper()
+	pageID := tree.root.pointers[0]
+	for {
+		_, branch, err := tree.loadNode(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if branch == nil {
+			return pageID
+		}
+		pageID = branch.pointers[0]
+	}
+}
+
+// flipByte changes a byte of a file behind the back of whoever has it open.
+func flipByte(t *testing.T, filename string, offset int64) {
+	t.Helper()
+	file, err := os.OpenFile(filename, os.O_RDWR, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0]++
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
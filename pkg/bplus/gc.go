@@ -0,0 +1,113 @@
+package bplus
+
+import "github.com/jpittis/bplus/pkg/store"
+
+// Orphans returns the pages of the tree's file that are neither free nor used by the
+// tree, in order, which a crash or a bug has lost track of, see store.PageStore.Orphans.
+// The trees of a database share their file, so their pages are found with
+// DB.Orphans instead, and ErrDB is returned for them.
+func (tree *Tree) Orphans() ([]store.PageID, error) {
+	if tree.db != nil || tree.flags&dbFlag != 0 {
+		return nil, ErrDB
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	inUse, err := pagesInUse(tree)
+	if err != nil {
+		return nil, err
+	}
+	return tree.store.Orphans(inUse)
+}
+
+// CollectGarbage walks the tree from its root and frees every page of its file that it
+// doesn't reach and that isn't free already, returning the pages it freed. A page that
+// can't be read stops the walk, since the pages under it would be freed along with the
+// orphaned ones, so a file that Check finds problems with has to be salvaged first. The
+// trees of a database are collected together with DB.CollectGarbage instead, and ErrDB is
+// returned for them.
+func (tree *Tree) CollectGarbage() ([]store.PageID, error) {
+	if tree.db != nil || tree.flags&dbFlag != 0 {
+		return nil, ErrDB
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	inUse, err := pagesInUse(tree)
+	if err != nil {
+		return nil, err
+	}
+	return tree.store.Reclaim(inUse)
+}
+
+// Orphans returns the pages of the database's file that are neither free nor used by the
+// catalog or any of the trees in it, in order, see Tree.Orphans.
+func (db *DB) Orphans() ([]store.PageID, error) {
+	trees, err := db.lockAll()
+	if err != nil {
+		return nil, err
+	}
+	defer unlockAll(trees)
+	inUse, err := pagesInUse(trees...)
+	if err != nil {
+		return nil, err
+	}
+	return db.catalog.store.Orphans(inUse)
+}
+
+// CollectGarbage walks the catalog and every tree in the database from their roots and
+// frees every page of the file that none of them reach and that isn't free already,
+// returning the pages it freed, see Tree.CollectGarbage. Every tree in the database is
+// opened, and none of them can be written until it returns.
+func (db *DB) CollectGarbage() ([]store.PageID, error) {
+	trees, err := db.lockAll()
+	if err != nil {
+		return nil, err
+	}
+	defer unlockAll(trees)
+	inUse, err := pagesInUse(trees...)
+	if err != nil {
+		return nil, err
+	}
+	return db.catalog.store.Reclaim(inUse)
+}
+
+// lockAll opens every tree in the database and locks the catalog and each of the trees,
+// in that order, returning them.
+func (db *DB) lockAll() ([]*Tree, error) {
+	names, err := db.TreeNames()
+	if err != nil {
+		return nil, err
+	}
+	trees := []*Tree{db.catalog}
+	for _, name := range names {
+		tree, err := db.OpenTree(name)
+		if err != nil {
+			return nil, err
+		}
+		trees = append(trees, tree)
+	}
+	for _, tree := range trees {
+		tree.lock.Lock()
+	}
+	return trees, nil
+}
+
+func unlockAll(trees []*Tree) {
+	for i := len(trees) - 1; i >= 0; i-- {
+		trees[i].lock.Unlock()
+	}
+}
+
+// pagesInUse returns every page of the trees, which must all be locked.
+func pagesInUse(trees ...*Tree) (map[store.PageID]bool, error) {
+	inUse := map[store.PageID]bool{}
+	for _, tree := range trees {
+		placements, err := tree.placements()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range placements {
+			inUse[p.id] = true
+		}
+	}
+	return inUse, nil
+}
@@ -0,0 +1,112 @@
+package bplus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	for _, version := range []int{store.FormatVersion2, store.FormatVersion3} {
+		filename := tempFilename(t, "collect_garbage")
+		tree, err := NewTree(
+			filename,
+			WithBranchingFactor(4),
+			WithCacheSize(64),
+			WithFormatVersion(version),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Pages allocated and never used are what a crash part way through a write leaks.
+		var leaked []store.PageID
+		for i := 0; i < 3; i++ {
+			pageID, err := tree.store.Allocate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaked = append(leaked, pageID)
+		}
+		for i := 0; i < 100; i += 2 {
+			if err := tree.Delete(intKey(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		orphans, err := tree.Orphans()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(orphans, leaked) {
+			t.Fatalf("expected %v == %v", orphans, leaked)
+		}
+		freed, err := tree.CollectGarbage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(freed, leaked) {
+			t.Fatalf("expected %v == %v", freed, leaked)
+		}
+		verifyTree(t, tree)
+		if orphans, err := tree.Orphans(); err != nil || len(orphans) != 0 {
+			t.Fatalf("expected %v == [] (%v)", orphans, err)
+		}
+		// The freed pages are reused as the tree grows.
+		for i := 100; i < 300; i++ {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		verifyTree(t, tree)
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectGarbageDB(t *testing.T) {
+	filename := tempFilename(t, "collect_garbage_db")
+	db, err := NewDB(filename, WithBranchingFactor(4), WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var trees []*Tree
+	for _, name := range []string{"a", "b"} {
+		tree, err := db.CreateTree(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 50; i++ {
+			if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		trees = append(trees, tree)
+	}
+	if _, err := trees[0].CollectGarbage(); err != ErrDB {
+		t.Fatalf("expected %v == %v", err, ErrDB)
+	}
+	leaked, err := db.catalog.store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	freed, err := db.CollectGarbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(freed, []store.PageID{leaked}) {
+		t.Fatalf("expected %v == %v", freed, []store.PageID{leaked})
+	}
+	for _, tree := range trees {
+		verifyTree(t, tree)
+	}
+	if orphans, err := db.Orphans(); err != nil || len(orphans) != 0 {
+		t.Fatalf("expected %v == [] (%v)", orphans, err)
+	}
+}
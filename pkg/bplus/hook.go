@@ -0,0 +1,110 @@
+package bplus
+
+import (
+	"sync"
+)
+
+// Hooks are called as changes are made to a tree, see AddHooks, which makes them a place
+// to validate writes, keep an audit log, or keep indexes derived from the tree up to
+// date. Changes are described the way they're sent to watchers, see WatchEvent, and any
+// of the hooks can be left nil.
+//
+// Every write, be it an Insert, a Delete, a DeleteRange or an Apply, is committed on its
+// own, and the hooks are called with the changes it makes while the write holds the
+// tree's lock, so they mustn't use the tree, although they're free to use other trees.
+type Hooks struct {
+	// BeforeWrite is called with each change a write is about to make. Returning an
+	// error vetoes the write, which leaves the tree untouched and returns the error.
+	BeforeWrite func(change WatchEvent) error
+	// BeforeCommit is called with every change a write is about to make, once
+	// BeforeWrite has been called with each of them, and vetoes the write in the same way.
+	BeforeCommit func(changes []WatchEvent) error
+	// AfterWrite is called with each change a write made once it's been committed.
+	AfterWrite func(change WatchEvent)
+	// AfterCommit is called with every change a write made once it's been committed,
+	// after AfterWrite has been called with each of them.
+	AfterCommit func(changes []WatchEvent)
+}
+
+// hookList holds the hooks added to a tree.
+type hookList struct {
+	mu    sync.RWMutex
+	hooks []Hooks
+}
+
+// AddHooks adds hooks that are called with every change made to the tree from now on.
+// Hooks are called in the order they were added, and the first to veto a write stops
+// the hooks after it from being called. Writes that make no changes, such as deleting a
+// key that isn't in the tree, don't call any hooks.
+func (tree *Tree) AddHooks(hooks Hooks) {
+	tree.hooks.mu.Lock()
+	defer tree.hooks.mu.Unlock()
+	tree.hooks.hooks = append(tree.hooks.hooks, hooks)
+}
+
+// list returns the hooks added so far.
+func (l *hookList) list() []Hooks {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.hooks
+}
+
+// observing returns whether there are hooks or watchers to tell about changes to the
+// tree, so that writes only work out what they change when there's someone to tell.
+func (tree *Tree) observing() bool {
+	return len(tree.hooks.list()) > 0 || tree.watches.watching()
+}
+
+// observingKey returns whether there are hooks, or watchers of key.
+func (tree *Tree) observingKey(key Key) bool {
+	return len(tree.hooks.list()) > 0 || tree.watches.watchingKey(key)
+}
+
+// observingRange returns whether there are hooks, or watchers of a key in [start, end).
+func (tree *Tree) observingRange(start, end Key) bool {
+	return len(tree.hooks.list()) > 0 || tree.watches.watchingRange(start, end)
+}
+
+// before calls the hooks with the changes a write is about to make, and returns the
+// error of the first hook that vetoes it.
+func (tree *Tree) before(changes []WatchEvent) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, hooks := range tree.hooks.list() {
+		if hooks.BeforeWrite != nil {
+			for _, change := range changes {
+				err := hooks.BeforeWrite(change)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if hooks.BeforeCommit != nil {
+			err := hooks.BeforeCommit(changes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// after calls the hooks with the changes a write committed, and sends them to their
+// watchers.
+func (tree *Tree) after(changes []WatchEvent) {
+	if len(changes) == 0 {
+		return
+	}
+	for _, hooks := range tree.hooks.list() {
+		if hooks.AfterWrite != nil {
+			for _, change := range changes {
+				hooks.AfterWrite(change)
+			}
+		}
+		if hooks.AfterCommit != nil {
+			hooks.AfterCommit(changes)
+		}
+	}
+	tree.watches.notify(changes)
+}
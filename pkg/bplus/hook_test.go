@@ -0,0 +1,157 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	tree, err := newTree("hooks", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	errEmpty := errors.New("empty value")
+	var audit []WatchEvent
+	commits := 0
+	tree.AddHooks(Hooks{
+		BeforeWrite: func(change WatchEvent) error {
+			if change.Kind != WatchDelete && len(change.Value) == 0 {
+				return errEmpty
+			}
+			return nil
+		},
+		AfterWrite: func(change WatchEvent) {
+			audit = append(audit, change)
+		},
+		AfterCommit: func(changes []WatchEvent) {
+			commits++
+		},
+	})
+	if err := tree.Insert(Key("a"), Value("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Upsert(Key("a"), nil); err != errEmpty {
+		t.Fatalf("expected %v == %v", err, errEmpty)
+	}
+	if err := tree.Upsert(Key("a"), Value("2")); err != nil {
+		t.Fatal(err)
+	}
+	var batch WriteBatch
+	batch.Put(Key("b"), Value("3"))
+	batch.Put(Key("c"), nil)
+	if err := tree.Apply(&batch); err != errEmpty {
+		t.Fatalf("expected %v == %v", err, errEmpty)
+	}
+	// A vetoed write leaves the tree untouched.
+	if _, err := tree.Read(Key("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := tree.Delete(Key("a")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []WatchEvent{
+		{Kind: WatchInsert, Key: Key("a"), Value: Value("1")},
+		{Kind: WatchUpdate, Key: Key("a"), Value: Value("2"), Old: Value("1")},
+		{Kind: WatchDelete, Key: Key("a"), Old: Value("2")},
+	}
+	if len(audit) != len(expected) {
+		t.Fatalf("expected %v == %v", len(audit), len(expected))
+	}
+	for i, change := range audit {
+		e := expected[i]
+		if change.Kind != e.Kind || !bytes.Equal(change.Key, e.Key) ||
+			!bytes.Equal(change.Value, e.Value) || !bytes.Equal(change.Old, e.Old) {
+			t.Fatalf("expected %v == %v", change, e)
+		}
+	}
+	if commits != 3 {
+		t.Fatalf("expected %v == %v", commits, 3)
+	}
+}
+
+func TestHooksBeforeCommit(t *testing.T) {
+	tree, err := newTree("hooks_before_commit", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	errTooMany := errors.New("too many changes")
+	tree.AddHooks(Hooks{
+		BeforeCommit: func(changes []WatchEvent) error {
+			if len(changes) > 10 {
+				return errTooMany
+			}
+			return nil
+		},
+	})
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(0), intKey(20)); err != errTooMany {
+		t.Fatalf("expected %v == %v", err, errTooMany)
+	}
+	if tree.Len() != 20 {
+		t.Fatalf("expected %v == %v", tree.Len(), 20)
+	}
+	n, err := tree.DeleteRange(intKey(0), intKey(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("expected %v == %v", n, 10)
+	}
+}
+
+func TestHooksMaintainIndex(t *testing.T) {
+	tree, err := newTree("hooks_maintain_index", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	index, err := newTree("hooks_maintain_index_index", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	// The index maps every value to its key.
+	tree.AddHooks(Hooks{
+		AfterWrite: func(change WatchEvent) {
+			if change.Old != nil {
+				if err := index.Delete(Key(change.Old)); err != nil {
+					t.Error(err)
+				}
+			}
+			if change.Kind != WatchDelete {
+				if err := index.Insert(Key(change.Value), Value(change.Key)); err != nil {
+					t.Error(err)
+				}
+			}
+		},
+	})
+	if err := tree.Insert(Key("a"), Value("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Upsert(Key("a"), Value("y")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(Key("b"), Value("z")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(Key("b")); err != nil {
+		t.Fatal(err)
+	}
+	if index.Len() != 1 {
+		t.Fatalf("expected %v == %v", index.Len(), 1)
+	}
+	key, err := index.Read(Key("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, Key("a")) {
+		t.Fatalf("expected %v == %v", key, Key("a"))
+	}
+}
@@ -0,0 +1,855 @@
+package bplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// MaxKeySize is the largest key in bytes that can be stored in a tree. Keys are bounded
+// so that a branch page split always produces two halves that fit in a page.
+const MaxKeySize = 1024
+
+const (
+	// leafHeaderSize is the leaf identifier byte followed by the number of records.
+	leafHeaderSize = 5
+	// branchHeaderSize is the branch identifier byte, the number of keys, the number of
+	// pointers, and the one pointer and count that don't have a matching key. Branches
+	// are sized as if their pointers and counts took up 64 bits, see widePageFlag, which
+	// leaves room to spare in files that store them in 32.
+	branchHeaderSize = 25
+	// maxBranchEntrySize is the most bytes a key and the pointer and count to its right
+	// can take up in a branch page, see branchEntrySize.
+	maxBranchEntrySize = 20 + MaxKeySize
+	// maxRecordSize is the largest encoded record that can be stored in a leaf. At most a
+	// third of a page guarantees that splitting an overflowing leaf produces two halves
+	// that fit in a page.
+	maxRecordSize = (store.UsablePageSize - leafHeaderSize) / 3
+)
+
+var (
+	// ErrDuplicateKey is returned when inserting a key that is already in the tree.
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrKeyTooLarge is returned when a key is larger than MaxKeySize.
+	ErrKeyTooLarge = errors.New("key too large")
+	// ErrRecordTooLarge is returned when a key value pair is too large to fit in a leaf.
+	ErrRecordTooLarge = errors.New("record too large")
+	// ErrValueMismatch is returned by CompareAndSwap when the stored value isn't the
+	// expected one.
+	ErrValueMismatch = errors.New("value mismatch")
+	// ErrNotCounter is returned by Increment when the stored value isn't an 8 byte
+	// counter.
+	ErrNotCounter = errors.New("value is not a counter")
+)
+
+var (
+	// errSkipWrite is returned by a putFunc to leave the leaf untouched.
+	errSkipWrite = errors.New("skip write")
+	// errWriteTombstone is returned by a putFunc given a key that's in the tree to replace
+	// its record with a tombstone.
+	errWriteTombstone = errors.New("write tombstone")
+)
+
+// putFunc is given the current value of a key, or nil and false if the key isn't in the
+// tree, and returns the value to store in its place.
+type putFunc func(old Value, found bool) (Value, error)
+
+// split describes a new page split off to the right of a page that overflowed. The parent
+// needs a new key and a pointer to the new page.
+type split struct {
+	key   Key
+	right store.PageID
+	count int
+}
+
+// Insert a key value pair into the tree. Duplicate keys are not allowed.
+func (tree *Tree) Insert(key Key, value Value) (err error) {
+	defer tree.startSpan("bplus.Insert").end(&err)
+	return tree.put(key, putAdds, func(old Value, found bool) (Value, error) {
+		if found {
+			return nil, ErrDuplicateKey
+		}
+		return value, nil
+	})
+}
+
+// Upsert inserts a key value pair into the tree, overwriting the value if the key is
+// already present.
+func (tree *Tree) Upsert(key Key, value Value) error {
+	return tree.put(key, putAdds, func(old Value, found bool) (Value, error) {
+		return value, nil
+	})
+}
+
+// GetOrInsert returns the value of key if it's already in the tree, otherwise it inserts
+// value. The returned bool is true if the value was already present.
+func (tree *Tree) GetOrInsert(key Key, value Value) (Value, bool, error) {
+	var existing Value
+	var loaded bool
+	err := tree.put(key, putAdds, func(old Value, found bool) (Value, error) {
+		if found {
+			existing, loaded = old, true
+			return nil, errSkipWrite
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if loaded {
+		return existing, true, nil
+	}
+	return value, false, nil
+}
+
+// Update replaces the value of key with the value fn returns when passed the current
+// value, or returns ErrKeyNotFound if key isn't in the tree. If fn returns an error the
+// tree is left untouched and the error is returned. The leaf is found and loaded once,
+// and only written if the value changed.
+func (tree *Tree) Update(key Key, fn func(old Value) (Value, error)) error {
+	return tree.put(key, putReplaces, func(old Value, found bool) (Value, error) {
+		if !found {
+			return nil, ErrKeyNotFound
+		}
+		value, err := fn(old)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(value, old) {
+			return nil, errSkipWrite
+		}
+		return value, nil
+	})
+}
+
+// CompareAndSwap replaces the value of key with value only if the stored value is equal
+// to expected. It returns ErrValueMismatch if the values differ and ErrKeyNotFound if key
+// isn't in the tree.
+func (tree *Tree) CompareAndSwap(key Key, expected, value Value) error {
+	return tree.Update(key, func(old Value) (Value, error) {
+		if !bytes.Equal(old, expected) {
+			return nil, ErrValueMismatch
+		}
+		return value, nil
+	})
+}
+
+// Increment adds delta to the counter stored under key and returns the new count.
+// Counters are stored as 8 byte little endian int64s, and a missing key is treated as a
+// counter of zero. The count is read and written back in a single descent of the tree.
+func (tree *Tree) Increment(key Key, delta int64) (int64, error) {
+	var count int64
+	err := tree.put(key, putAdds, func(old Value, found bool) (Value, error) {
+		if found && len(old) != 8 {
+			return nil, ErrNotCounter
+		}
+		count = delta
+		if found {
+			count += int64(binary.LittleEndian.Uint64(old))
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(count)), nil
+	})
+	return count, err
+}
+
+// putKind describes what a putFunc may do to the number of records in the tree, which
+// decides how much of the tree a put has to latch, see latch.go.
+type putKind int
+
+const (
+	// putReplaces is for a putFunc that only ever replaces the value of a key that's
+	// already in the tree.
+	putReplaces putKind = iota
+	// putAdds is for a putFunc that may add a key that isn't in the tree yet.
+	putAdds
+	// putRemoves is for a putFunc that may replace a record with a tombstone.
+	putRemoves
+)
+
+// put descends to the leaf that key belongs in and stores the value returned by fn,
+// splitting pages on the way back up if they overflow. The change is made with only the
+// leaf latched if it can be, and otherwise with the path down to the leaf latched.
+func (tree *Tree) put(key Key, kind putKind, fn putFunc) (err error) {
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	if tree.store.ReadOnly() {
+		return store.ErrReadOnly
+	}
+	tree.timedRLock()
+	defer tree.lock.RUnlock()
+	if tree.observingKey(key) {
+		var event *WatchEvent
+		fn = tree.watchPut(key, fn, &event)
+		defer func() {
+			if err == nil && event != nil {
+				tree.after([]WatchEvent{*event})
+			}
+		}()
+	}
+	// Cursors read alongside puts, so the version is bumped again once the change is
+	// made, for a cursor that decoded a page part way through it to see that it changed.
+	tree.version.Add(1)
+	tree.store.Begin()
+	defer tree.commit(&err)
+	defer tree.version.Add(1)
+	// In copy-on-write mode, writing the leaf moves it, which changes its parent.
+	if tree.blink() {
+		err = tree.putBLink(key, kind, fn)
+		if err == errSkipWrite {
+			return nil
+		}
+		return err
+	}
+	if kind != putRemoves && !tree.store.COW() {
+		done, err := tree.putInPlace(key, fn)
+		if done || err != nil {
+			return err
+		}
+	}
+	err = tree.putLatched(key, kind, fn)
+	if err == errSkipWrite {
+		return nil
+	}
+	return err
+}
+
+// putInPlace makes the change with only the leaf latched for writing if key is already
+// in the tree and the leaf has room for its record to grow as large as a record can be,
+// in which case the change can't split the leaf or change the number of records in it.
+// Otherwise it returns false without calling fn.
+func (tree *Tree) putInPlace(key Key, fn putFunc) (bool, error) {
+	page, err := tree.findLeaf(key, true)
+	if err != nil || page == nil {
+		return false, err
+	}
+	leaf := &leafPage{PageHandle: page.PageHandle}
+	leaf.fromBuffer()
+	i, found := leaf.search(key)
+	inPlace := found && !leaf.records[i].tombstone &&
+		!tree.overflows(len(leaf.records), leaf.size()-leaf.records[i].size()+maxRecordSize)
+	if inPlace {
+		_, err = tree.insertIntoLeaf(leaf, key, fn)
+	}
+	unlatchErr := tree.unlatch(page)
+	if err == errSkipWrite {
+		err = nil
+	}
+	if err == nil {
+		err = unlatchErr
+	}
+	return inPlace, err
+}
+
+// pathFrame is a branch latched for writing on the way down to a leaf, along with the
+// index of the child followed. The root is latched with rootLatch, so its page is nil.
+type pathFrame struct {
+	branch *branchPage
+	page   *latchedPage
+	index  int
+}
+
+// putLatched makes the change with the path from the root down to the leaf latched for
+// writing. The branches above a page that has room for a split from below are let go of
+// on the way down, and their counts are brought up to date once the change is made, see
+// recountAbove.
+func (tree *Tree) putLatched(key Key, kind putKind, fn putFunc) (err error) {
+	tree.rootLatch.Lock()
+	root := &branchPage{PageHandle: tree.root.PageHandle}
+	root.fromBuffer()
+	rootID := root.ID
+	path := []pathFrame{{branch: root}}
+	defer func() {
+		unlatchErr := tree.unlatchPath(path)
+		if err == nil {
+			err = unlatchErr
+		}
+	}()
+	if len(root.pointers) == 0 {
+		if kind != putAdds {
+			return ErrKeyNotFound
+		}
+		err := tree.allocateFirstLeaf()
+		if err != nil {
+			return err
+		}
+		root.fromBuffer()
+	}
+	// In copy-on-write mode writing a page moves it, which changes its parent, so the
+	// whole path has to stay latched.
+	cow := tree.store.COW()
+	depth := 0
+	var leaf *latchedPage
+	for leaf == nil {
+		frame := &path[len(path)-1]
+		frame.index = frame.branch.childIndex(key)
+		child, err := tree.latchChild(frame.branch.pointers[frame.index], true, true)
+		if err != nil {
+			return err
+		}
+		depth++
+		if isLeafPage(child.PageHandle) {
+			leaf = child
+			break
+		}
+		branch := &branchPage{PageHandle: child.PageHandle}
+		branch.fromBuffer()
+		if !cow && !tree.overflows(len(branch.keys)+1, branch.size()+maxBranchEntrySize) {
+			err := tree.unlatchPath(path)
+			path = nil
+			if err != nil {
+				tree.unlatch(child)
+				return err
+			}
+		}
+		path = append(path, pathFrame{branch: branch, page: child})
+	}
+
+	l := &leafPage{PageHandle: leaf.PageHandle}
+	l.fromBuffer()
+	if !cow && !tree.overflows(len(l.records)+1, l.size()+maxRecordSize) {
+		err := tree.unlatchPath(path)
+		path = nil
+		if err != nil {
+			tree.unlatch(leaf)
+			return err
+		}
+	}
+	splits, err := tree.insertIntoLeaf(l, key, fn)
+	count := liveCount(l.records)
+	// In copy-on-write mode, a page that was written has moved to a fresh page.
+	moved := leaf.ID != leaf.id
+	unlatchErr := tree.unlatch(leaf)
+	if err != nil {
+		return err
+	}
+	if unlatchErr != nil {
+		return unlatchErr
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		frame := path[i]
+		branch := frame.branch
+		if len(splits) == 0 && count == branch.counts[frame.index] && !moved {
+			return nil
+		}
+		branch.counts[frame.index] = count
+		branch.insertSplits(frame.index, splits)
+		splits, err = tree.writeBranchSplitting(branch)
+		if err != nil {
+			return err
+		}
+		count = branch.count()
+		moved = frame.page != nil && frame.page.ID != frame.page.id
+	}
+	if len(path) > 0 && path[0].page == nil {
+		tree.root.fromBuffer()
+		return tree.growRoot(splits)
+	}
+	// The branches that were let go of still count the records under the page the path
+	// now starts at as they were.
+	height := len(path) + 1
+	err = tree.unlatchPath(path)
+	path = nil
+	if err != nil {
+		return err
+	}
+	return tree.recountAbove(key, height, rootID, depth)
+}
+
+// unlatchPath lets go of the branches latched by putLatched.
+func (tree *Tree) unlatchPath(path []pathFrame) error {
+	var err error
+	for _, frame := range path {
+		if frame.page == nil {
+			tree.rootLatch.Unlock()
+			continue
+		}
+		unlatchErr := tree.unlatch(frame.page)
+		if err == nil {
+			err = unlatchErr
+		}
+	}
+	return err
+}
+
+// recountAbove brings the counts of the branches putLatched let go of up to date after a
+// change to the leaf key belongs in, the way propagateBLink does in a B-link tree: one
+// branch at a time, from the given height above the leaves up to the root, counting the
+// records under the child on the way to key again. rootID and leafDepth are the root and
+// the depth of the leaves when the change was made.
+//
+// A concurrent split carries a branch's counts over to the pages it was split into, so
+// descending again to each branch finds the count that has to change even if the path
+// moved. Counting again rather than adding differences means writers racing up the same
+// path can't count a record twice, and it stops at the first branch whose count is
+// already right, since whoever changed it is on its way up from there.
+func (tree *Tree) recountAbove(
+	key Key,
+	height int,
+	rootID store.PageID,
+	leafDepth int,
+) error {
+	for height <= leafDepth {
+		changed, found, err := tree.recountAt(key, rootID, leafDepth-height)
+		if err != nil {
+			return err
+		}
+		if !found {
+			// The root was split since, which leaves every other branch where it was but
+			// further from the root.
+			rootID, leafDepth, err = tree.depthOf(key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !changed {
+			return nil
+		}
+		height++
+	}
+	return nil
+}
+
+// recountAt counts the records under the child on the way to key again in the branch
+// depth levels below the root, latching it for writing. It reports whether the count
+// changed, and returns false if rootID is no longer the root.
+func (tree *Tree) recountAt(key Key, rootID store.PageID, depth int) (bool, bool, error) {
+	if depth == 0 {
+		tree.rootLatch.Lock()
+		defer tree.rootLatch.Unlock()
+		if tree.root.ID != rootID {
+			return false, false, nil
+		}
+		root := &branchPage{PageHandle: tree.root.PageHandle}
+		root.fromBuffer()
+		changed, err := tree.addSplits(root, []Key{key}, nil)
+		if err != nil || !changed {
+			return false, true, err
+		}
+		err = tree.writeBranch(root)
+		tree.root.fromBuffer()
+		return true, true, err
+	}
+	tree.rootLatch.RLock()
+	if tree.root.ID != rootID {
+		tree.rootLatch.RUnlock()
+		return false, false, nil
+	}
+	root := decodeBranch(tree.root.PageHandle)
+	childID := root.pointers[root.childIndex(key)]
+	freeBranch(root)
+	page, err := tree.latchChild(childID, depth == 1, false)
+	tree.rootLatch.RUnlock()
+	for d := 1; err == nil && d < depth; d++ {
+		branch := decodeBranch(page.PageHandle)
+		childID := branch.pointers[branch.childIndex(key)]
+		freeBranch(branch)
+		var child *latchedPage
+		child, err = tree.latchChild(childID, d+1 == depth, false)
+		unlatchErr := tree.unlatch(page)
+		if err == nil && unlatchErr != nil {
+			tree.unlatch(child)
+			err = unlatchErr
+		}
+		page = child
+	}
+	if err != nil {
+		return false, true, err
+	}
+	branch := &branchPage{PageHandle: page.PageHandle}
+	branch.fromBuffer()
+	changed, err := tree.addSplits(branch, []Key{key}, nil)
+	if err == nil && changed {
+		err = tree.writeBranch(branch)
+	}
+	unlatchErr := tree.unlatch(page)
+	if err == nil {
+		err = unlatchErr
+	}
+	return changed, true, err
+}
+
+// depthOf returns the root and the depth of the leaves below it, crabbing down to the
+// leaf key belongs in with every page latched for reading.
+func (tree *Tree) depthOf(key Key) (store.PageID, int, error) {
+	tree.rootLatch.RLock()
+	rootID := tree.root.ID
+	root := decodeBranch(tree.root.PageHandle)
+	childID := root.pointers[root.childIndex(key)]
+	freeBranch(root)
+	page, err := tree.latchChild(childID, false, false)
+	tree.rootLatch.RUnlock()
+	depth := 1
+	for ; err == nil && !isLeafPage(page.PageHandle); depth++ {
+		branch := decodeBranch(page.PageHandle)
+		childID := branch.pointers[branch.childIndex(key)]
+		freeBranch(branch)
+		var child *latchedPage
+		child, err = tree.latchChild(childID, false, false)
+		unlatchErr := tree.unlatch(page)
+		if err == nil && unlatchErr != nil {
+			tree.unlatch(child)
+			err = unlatchErr
+		}
+		page = child
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return rootID, depth, tree.unlatch(page)
+}
+
+// allocateFirstLeaf gives an empty root a single empty leaf to insert into.
+func (tree *Tree) allocateFirstLeaf() error {
+	leaf, err := tree.allocateLeaf()
+	if err != nil {
+		return err
+	}
+	err = tree.writeLeaf(leaf)
+	if err != nil {
+		return err
+	}
+	err = tree.release(leaf.PageHandle)
+	if err != nil {
+		return err
+	}
+	tree.root.keys = nil
+	tree.root.pointers = []store.PageID{leaf.ID}
+	tree.root.counts = []int{0}
+	return tree.writeBranch(tree.root)
+}
+
+func (tree *Tree) insertIntoLeaf(leaf *leafPage, key Key, fn putFunc) ([]split, error) {
+	i, found := leaf.search(key)
+	// A tombstone is replaced as if the key wasn't there.
+	exists := found && !leaf.records[i].tombstone
+	var old Value
+	if exists {
+		r, err := tree.values.resolve(leaf.records[i])
+		if err != nil {
+			return nil, err
+		}
+		old = r.Value
+	}
+	value, err := fn(old, exists)
+	if err == errWriteTombstone {
+		leaf.records[i] = Record{Key: key, tombstone: true}
+		return tree.writeLeafSplitting(leaf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !tree.fits(Record{Key: key, Value: value}) {
+		return nil, ErrRecordTooLarge
+	}
+	record, err := tree.newRecord(key, value)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		leaf.records[i] = record
+	} else {
+		leaf.records = append(leaf.records, Record{})
+		copy(leaf.records[i+1:], leaf.records[i:])
+		leaf.records[i] = record
+	}
+	return tree.writeLeafSplitting(leaf)
+}
+
+// insertSplits adds the pages that the child at index i was split into to the right of
+// the child.
+func (p *branchPage) insertSplits(i int, splits []split) {
+	keys := make([]Key, 0, len(p.keys)+len(splits))
+	keys = append(keys, p.keys[:i]...)
+	pointers := make([]store.PageID, 0, len(p.pointers)+len(splits))
+	pointers = append(pointers, p.pointers[:i+1]...)
+	counts := make([]int, 0, len(p.counts)+len(splits))
+	counts = append(counts, p.counts[:i+1]...)
+	for _, s := range splits {
+		keys = append(keys, s.key)
+		pointers = append(pointers, s.right)
+		counts = append(counts, s.count)
+	}
+	p.keys = append(keys, p.keys[i:]...)
+	p.pointers = append(pointers, p.pointers[i+1:]...)
+	p.counts = append(counts, p.counts[i+1:]...)
+}
+
+// overflows reports whether a page with n keys and the given encoded size needs to be
+// split, either because it has too many keys for the branching factor or because it no
+// longer fits in a page.
+func (tree *Tree) overflows(n, size int) bool {
+	return n > tree.branchingFactor-1 || size > store.UsablePageSize-tree.trailerSize()
+}
+
+// writeLeafSplitting writes a leaf, first splitting it into as many pages as it takes for
+// each of them to fit. It returns a split for every new page to the right of the leaf.
+func (tree *Tree) writeLeafSplitting(leaf *leafPage) ([]split, error) {
+	pieces := tree.splitRecords(leaf.records)
+	leaf.records = pieces[0]
+	var splits []split
+	page := leaf
+	for _, records := range pieces[1:] {
+		right, err := tree.allocateLeaf()
+		if err != nil {
+			return nil, err
+		}
+		right.records = records
+		// In a B-link tree each piece links to the next, and the last takes over the link
+		// of the leaf.
+		right.rightLink = page.rightLink
+		page.link, page.high = right.ID, records[0].Key
+		err = tree.writeLeafPiece(page, leaf)
+		if err != nil {
+			return nil, err
+		}
+		splits = append(splits, split{
+			key:   records[0].Key,
+			right: right.ID,
+			count: liveCount(records),
+		})
+		page = right
+	}
+	return splits, tree.writeLeafPiece(page, leaf)
+}
+
+// writeLeafPiece writes a piece of a leaf being split, releasing it unless it's the
+// leaf.
+func (tree *Tree) writeLeafPiece(piece, leaf *leafPage) error {
+	err := tree.writeLeaf(piece)
+	if err != nil || piece == leaf {
+		return err
+	}
+	return tree.release(piece.PageHandle)
+}
+
+// splitRecords halves records until every piece fits in a leaf.
+func (tree *Tree) splitRecords(records []Record) [][]Record {
+	leaf := &leafPage{records: records}
+	if !tree.overflows(len(records), leaf.size()) {
+		return [][]Record{records}
+	}
+	sizes := make([]int, len(records))
+	for i, r := range records {
+		sizes[i] = r.size()
+	}
+	mid := splitIndex(sizes, leafHeaderSize+tree.trailerSize())
+	return append(tree.splitRecords(records[:mid]), tree.splitRecords(records[mid:])...)
+}
+
+// writeBranchSplitting writes a branch, first splitting it into as many pages as it takes
+// for each of them to fit. It returns a split for every new page to the right of the
+// branch.
+func (tree *Tree) writeBranchSplitting(branch *branchPage) ([]split, error) {
+	pieces := tree.splitBranch(&branchPage{
+		keys:     branch.keys,
+		pointers: branch.pointers,
+		counts:   branch.counts,
+	})
+	branch.keys = pieces[0].keys
+	branch.pointers = pieces[0].pointers
+	branch.counts = pieces[0].counts
+	var splits []split
+	page := branch
+	for _, piece := range pieces[1:] {
+		right, err := tree.allocateBranch()
+		if err != nil {
+			return nil, err
+		}
+		right.keys = piece.keys
+		right.pointers = piece.pointers
+		right.counts = piece.counts
+		// In a B-link tree each piece links to the next, and the last takes over the link
+		// of the branch.
+		right.rightLink = page.rightLink
+		page.link, page.high = right.ID, piece.separator
+		err = tree.writeBranchPiece(page, branch)
+		if err != nil {
+			return nil, err
+		}
+		splits = append(splits, split{
+			key:   piece.separator,
+			right: right.ID,
+			count: right.count(),
+		})
+		page = right
+	}
+	return splits, tree.writeBranchPiece(page, branch)
+}
+
+// writeBranchPiece writes a piece of a branch being split, releasing it unless it's the
+// branch.
+func (tree *Tree) writeBranchPiece(piece, branch *branchPage) error {
+	err := tree.writeBranch(piece)
+	if err != nil || piece == branch {
+		return err
+	}
+	return tree.release(piece.PageHandle)
+}
+
+// branchPiece is part of a branch that was split. The separator is the key that moves up
+// into the parent to the left of the piece.
+type branchPiece struct {
+	separator Key
+	keys      []Key
+	pointers  []store.PageID
+	counts    []int
+}
+
+// splitBranch halves a branch until every piece fits in a page.
+func (tree *Tree) splitBranch(branch *branchPage) []branchPiece {
+	if !tree.overflows(len(branch.keys), branch.size()) {
+		return []branchPiece{{
+			keys:     branch.keys,
+			pointers: branch.pointers,
+			counts:   branch.counts,
+		}}
+	}
+	sizes := make([]int, len(branch.keys))
+	for i, k := range branch.keys {
+		sizes[i] = branchEntrySize(k)
+	}
+	mid := splitIndex(sizes, branchHeaderSize+tree.trailerSize())
+	left := tree.splitBranch(&branchPage{
+		keys:     branch.keys[:mid],
+		pointers: branch.pointers[:mid+1],
+		counts:   branch.counts[:mid+1],
+	})
+	right := tree.splitBranch(&branchPage{
+		keys:     branch.keys[mid+1:],
+		pointers: branch.pointers[mid+1:],
+		counts:   branch.counts[mid+1:],
+	})
+	// The middle key moves up into the parent rather than into either half.
+	right[0].separator = branch.keys[mid]
+	return append(left, right...)
+}
+
+// splitIndex picks where to split entries with the given encoded sizes. It prefers
+// splitting evenly by count, but falls back to splitting evenly by size when one of the
+// halves wouldn't fit in a page.
+func splitIndex(sizes []int, headerSize int) int {
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	mid := len(sizes) / 2
+	left := 0
+	for _, size := range sizes[:mid] {
+		left += size
+	}
+	space := store.UsablePageSize - headerSize
+	if left <= space && total-left <= space {
+		return mid
+	}
+	left = 0
+	for i, size := range sizes {
+		left += size
+		if left*2 >= total {
+			if i+1 == len(sizes) {
+				return i
+			}
+			return i + 1
+		}
+	}
+	return mid
+}
+
+// growRoot handles the root splitting by allocating a new root above the pieces. It
+// repeats until the new root fits in a page.
+func (tree *Tree) growRoot(splits []split) error {
+	for len(splits) > 0 {
+		oldRoot := tree.root
+		root, err := tree.allocateBranch()
+		if err != nil {
+			return err
+		}
+		root.pointers = []store.PageID{oldRoot.ID}
+		root.counts = []int{oldRoot.count()}
+		root.insertSplits(0, splits)
+		splits, err = tree.writeBranchSplitting(root)
+		if err != nil {
+			return err
+		}
+		err = tree.setRoot(root.ID)
+		if err != nil {
+			return err
+		}
+		tree.root = root
+		err = tree.release(oldRoot.PageHandle)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tree *Tree) allocateLeaf() (*leafPage, error) {
+	page, err := tree.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	return &leafPage{PageHandle: page}, nil
+}
+
+func (tree *Tree) allocateBranch() (*branchPage, error) {
+	page, err := tree.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	return &branchPage{PageHandle: page}, nil
+}
+
+func (tree *Tree) allocatePage() (*store.PageHandle, error) {
+	pageID, err := tree.store.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	return tree.store.Load(pageID)
+}
+
+func (tree *Tree) writeLeaf(leaf *leafPage) error {
+	leaf.blink, leaf.wide = tree.blink(), tree.wide()
+	leaf.toBuffer()
+	return tree.store.Write(leaf.ID)
+}
+
+func (tree *Tree) writeBranch(branch *branchPage) error {
+	// In copy-on-write mode, children that have been written have moved to fresh pages.
+	for i, pointer := range branch.pointers {
+		branch.pointers[i] = tree.store.Resolve(pointer)
+	}
+	branch.blink, branch.wide = tree.blink(), tree.wide()
+	branch.toBuffer()
+	return tree.store.Write(branch.ID)
+}
+
+// size is the number of bytes a record takes up in a leaf page.
+func (r Record) size() int {
+	return 8 + len(r.Key) + len(r.Value)
+}
+
+func (p *leafPage) size() int {
+	size := leafHeaderSize
+	for _, r := range p.records {
+		size += r.size()
+	}
+	return size
+}
+
+// branchEntrySize is the number of bytes a key and the pointer and count to its right
+// take up in a branch page.
+func branchEntrySize(key Key) int {
+	return 20 + len(key)
+}
+
+func (p *branchPage) size() int {
+	size := branchHeaderSize
+	for _, k := range p.keys {
+		size += branchEntrySize(k)
+	}
+	return size
+}
@@ -0,0 +1,300 @@
+package bplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestInsertAndRead(t *testing.T) {
+	tree, err := newTree("insert_and_read", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(1000) {
+		err := tree.Insert(intKey(i), intValue(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+	err = tree.Insert(intKey(7), intValue(8))
+	if err != ErrDuplicateKey {
+		t.Fatalf("expected %v == %v", err, ErrDuplicateKey)
+	}
+	value, err := tree.Read(intKey(1000))
+	if err != ErrKeyNotFound {
+		t.Fatalf("found unexpected value %+v", value)
+	}
+}
+
+func TestInsertSplitsLargeRecordsBySize(t *testing.T) {
+	tree, err := newTree("insert_large_records", 1000, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		err := tree.Insert(intKey(i), bytes.Repeat([]byte{byte(i)}, 1000))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if len(value) != 1000 || value[0] != byte(i) {
+			t.Fatalf("unexpected value for %d", i)
+		}
+	}
+	err = tree.Insert(intKey(100), make([]byte, maxRecordSize))
+	if err != ErrRecordTooLarge {
+		t.Fatalf("expected %v == %v", err, ErrRecordTooLarge)
+	}
+	err = tree.Insert(make([]byte, MaxKeySize+1), nil)
+	if err != ErrKeyTooLarge {
+		t.Fatalf("expected %v == %v", err, ErrKeyTooLarge)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	tree, err := newTree("upsert", 1000, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		err := tree.Upsert(intKey(i), intValue(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Growing every value forces the single leaf to split.
+	for i := 0; i < 10; i++ {
+		err := tree.Upsert(intKey(i), bytes.Repeat([]byte{byte(i)}, 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tree.root.pointers) < 2 {
+		t.Fatalf("expected the leaf to split, root has %d pointers", len(tree.root.pointers))
+	}
+	for i := 0; i < 10; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(value) != 1000 || value[0] != byte(i) {
+			t.Fatalf("unexpected value for %d", i)
+		}
+	}
+}
+
+func TestGetOrInsert(t *testing.T) {
+	tree, err := newTree("get_or_insert", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, loaded, err := tree.GetOrInsert(intKey(1), intValue(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded || !bytes.Equal(value, intValue(1)) {
+		t.Fatalf("expected %v to be inserted", value)
+	}
+	value, loaded, err = tree.GetOrInsert(intKey(1), intValue(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded || !bytes.Equal(value, intValue(1)) {
+		t.Fatalf("expected %v to be loaded", value)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tree, err := newTree("update", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Update(intKey(1), nil); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	double := func(old Value) (Value, error) {
+		return intValue(int(binary.LittleEndian.Uint32(old)) * 2), nil
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Update(intKey(i), double); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i*2)) {
+			t.Fatalf("expected %v == %v", value, intValue(i*2))
+		}
+	}
+	if err := tree.Update(intKey(100), double); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	// An error from fn leaves the value as it was.
+	errFailed := errors.New("failed")
+	err = tree.Update(intKey(5), func(old Value) (Value, error) {
+		return intValue(0), errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("expected %v == %v", err, errFailed)
+	}
+	value, err := tree.Read(intKey(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(10)) {
+		t.Fatalf("expected %v == %v", value, intValue(10))
+	}
+	if tree.Len() != 100 {
+		t.Fatalf("expected %v == %v", tree.Len(), 100)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	tree, err := newTree("compare_and_swap", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(1), intValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	err = tree.CompareAndSwap(intKey(1), intValue(2), intValue(3))
+	if err != ErrValueMismatch {
+		t.Fatalf("expected %v == %v", err, ErrValueMismatch)
+	}
+	if err := tree.CompareAndSwap(intKey(1), intValue(1), intValue(3)); err != nil {
+		t.Fatal(err)
+	}
+	value, err := tree.Read(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(3)) {
+		t.Fatalf("expected %v == %v", value, intValue(3))
+	}
+	// The old value no longer matches once it has been swapped out.
+	err = tree.CompareAndSwap(intKey(1), intValue(1), intValue(4))
+	if err != ErrValueMismatch {
+		t.Fatalf("expected %v == %v", err, ErrValueMismatch)
+	}
+	if err := tree.CompareAndSwap(intKey(2), nil, intValue(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	tree, err := newTree("increment", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		for j := 0; j <= i; j++ {
+			if _, err := tree.Increment(intKey(i), 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < 50; i++ {
+		count, err := tree.Increment(intKey(i), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != int64(2*(i+1)-1) {
+			t.Fatalf("expected %v == %v", count, 2*(i+1)-1)
+		}
+	}
+	count, err := tree.Increment(intKey(100), -5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != -5 {
+		t.Fatalf("expected %v == %v", count, -5)
+	}
+	value, err := tree.Read(intKey(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(binary.LittleEndian.Uint64(value)) != -5 {
+		t.Fatalf("expected %v == %v", int64(binary.LittleEndian.Uint64(value)), -5)
+	}
+	if err := tree.Insert(intKey(200), intValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Increment(intKey(200), 1); err != ErrNotCounter {
+		t.Fatalf("expected %v == %v", err, ErrNotCounter)
+	}
+}
+
+func TestInsertSurvivesReopen(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "insert_survives_reopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		err := tree.Insert(intKey(i), intValue(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, tree)
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := reopened.Read(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+}
+
+// intKey encodes i big endian so that keys sort in the same order as the integers.
+func intKey(i int) Key {
+	key := make(Key, 4)
+	binary.BigEndian.PutUint32(key, uint32(i))
+	return key
+}
+
+func intValue(i int) Value {
+	value := make(Value, 4)
+	binary.LittleEndian.PutUint32(value, uint32(i))
+	return value
+}
@@ -0,0 +1,188 @@
+package bplus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// ErrPageCorrupt is returned by InspectPage for a page whose fields run past the end of
+// its contents.
+var ErrPageCorrupt = errors.New("page is corrupt")
+
+// InspectPage inspects a page of a tree's file, see store.PageStore.Inspect, and decodes
+// data pages as pages of a tree: the page's flags, then either the records of a leaf or
+// the keys, pointers and counts of a branch, and the link and high key of the pages of
+// B-link trees. The page is decoded as far as it can be, so for a corrupt page the
+// fields up to where it stops making sense are returned along with ErrPageCorrupt.
+func InspectPage(s *store.PageStore, pageID store.PageID) (*store.PageInfo, error) {
+	info, err := s.Inspect(pageID)
+	if err != nil || info.Kind != store.PageData {
+		return info, err
+	}
+	r := &fieldReader{buf: info.Buf[:info.ContentSize]}
+	r.page()
+	info.Fields = append(r.fields, info.Fields...)
+	if r.corrupt {
+		return info, ErrPageCorrupt
+	}
+	return info, nil
+}
+
+// fieldReader reads the fields of a page of a tree, laid out as leafPage.toBuffer and
+// branchPage.toBuffer write them.
+type fieldReader struct {
+	buf     []byte
+	offset  int
+	fields  []store.Field
+	corrupt bool
+}
+
+// next reads the next size bytes of the page as a field, or returns nil and marks the
+// page corrupt if they run past the end of it.
+func (r *fieldReader) next(size int, name string, value func(b []byte) string) []byte {
+	if r.corrupt || size < 0 || r.offset+size > len(r.buf) {
+		r.corrupt = true
+		return nil
+	}
+	b := r.buf[r.offset : r.offset+size]
+	r.fields = append(r.fields, store.Field{
+		Offset: r.offset,
+		Size:   size,
+		Name:   name,
+		Value:  value(b),
+	})
+	r.offset += size
+	return b
+}
+
+// count reads a 32-bit count.
+func (r *fieldReader) count(name string) int {
+	b := r.next(4, name, func(b []byte) string {
+		return fmt.Sprint(binary.LittleEndian.Uint32(b))
+	})
+	if b == nil {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b))
+}
+
+// bytes reads a length prefixed key or value.
+func (r *fieldReader) bytes(name string) {
+	if r.offset+4 > len(r.buf) {
+		r.corrupt = true
+		return
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf[r.offset:]))
+	r.next(4+n, name, func(b []byte) string {
+		return fmt.Sprintf("%q", b[4:])
+	})
+}
+
+// uint reads a page id or count of a branch, which is 64 bits in wide pages.
+func (r *fieldReader) uint(name string, wide bool) {
+	r.next(pageIDSize(wide), name, func(b []byte) string {
+		n, _ := uintFromBuffer(b, wide)
+		return fmt.Sprint(n)
+	})
+}
+
+func (r *fieldReader) page() {
+	if len(r.buf) == 0 {
+		r.corrupt = true
+		return
+	}
+	flags := r.buf[0]
+	r.next(1, "flags", func(b []byte) string {
+		return pageFlags(flags)
+	})
+	wide := flags&widePageFlag != 0
+	if flags&1 == 1 {
+		r.leaf()
+	} else {
+		r.branch(wide)
+	}
+	if flags&blinkPageFlag != 0 {
+		r.link(wide)
+	}
+}
+
+// pageFlags writes out the flags in the identifier byte of a page.
+func pageFlags(flags byte) string {
+	names := []string{"branch"}
+	if flags&1 == 1 {
+		names[0] = "leaf"
+	}
+	if flags&blinkPageFlag != 0 {
+		names = append(names, "B-link")
+	}
+	if flags&widePageFlag != 0 {
+		names = append(names, "wide")
+	}
+	return strings.Join(names, ", ")
+}
+
+func (r *fieldReader) leaf() {
+	records := r.count("records")
+	for i := 0; i < records && !r.corrupt; i++ {
+		r.bytes(fmt.Sprintf("key %d", i))
+		if r.offset+4 > len(r.buf) {
+			r.corrupt = true
+			return
+		}
+		name := fmt.Sprintf("value %d", i)
+		switch binary.LittleEndian.Uint32(r.buf[r.offset:]) {
+		case tombstoneLength:
+			r.next(4, name, func([]byte) string {
+				return "tombstone"
+			})
+		case valuePointerLength:
+			r.next(4+valuePointerSize, name, func(b []byte) string {
+				p := valuePointerFromBuffer(b[4:])
+				return fmt.Sprintf("value log segment %d, offset %d, length %d",
+					p.segment, p.offset, p.length)
+			})
+		default:
+			r.bytes(name)
+		}
+	}
+}
+
+func (r *fieldReader) branch(wide bool) {
+	keys := r.count("keys")
+	for i := 0; i < keys && !r.corrupt; i++ {
+		r.bytes(fmt.Sprintf("key %d", i))
+	}
+	pointers := r.count("pointers")
+	for i := 0; i < pointers && !r.corrupt; i++ {
+		r.uint(fmt.Sprintf("pointer %d", i), wide)
+	}
+	// There is a count for every pointer.
+	for i := 0; i < pointers && !r.corrupt; i++ {
+		r.uint(fmt.Sprintf("count %d", i), wide)
+	}
+}
+
+// link reads the right link and high key kept at the end of the pages of B-link trees.
+func (r *fieldReader) link(wide bool) {
+	if r.corrupt {
+		return
+	}
+	r.offset = len(r.buf) - blinkTrailerSize(wide)
+	if r.offset < 0 {
+		r.corrupt = true
+		return
+	}
+	r.uint("right link", wide)
+	if r.offset+4 <= len(r.buf) &&
+		binary.LittleEndian.Uint32(r.buf[r.offset:]) == noHighKey {
+		r.next(4, "high key", func([]byte) string {
+			return "none"
+		})
+		return
+	}
+	r.bytes("high key")
+}
@@ -0,0 +1,115 @@
+package bplus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestInspectPage(t *testing.T) {
+	tree, err := newTree("inspect_page", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := InspectPage(tree.store, tree.root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPageField(t, root, "flags", "branch, wide")
+	expectPageField(t, root, "pointers", "3")
+	expectPageField(t, root, "pointer 0", "")
+	expectPageField(t, root, "count 2", "")
+
+	// Follow the first pointer down to the first leaf.
+	info := root
+	for info.Fields[0].Value != "leaf, wide" {
+		pageID, err := strconv.Atoi(pageField(t, info, "pointer 0").Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err = InspectPage(tree.store, store.PageID(pageID))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectPageField(t, info, "key 0", fmt.Sprintf("%q", intKey(0)))
+	key := pageField(t, info, "key 0")
+	if key.Offset != 5 || key.Size != 4+len(intKey(0)) {
+		t.Fatalf("expected %v %v == 5 %v", key.Offset, key.Size, 4+len(intKey(0)))
+	}
+	expectPageField(t, info, "checksum", "")
+
+	header, err := InspectPage(tree.store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Kind != store.PageHeader {
+		t.Fatalf("expected %v == %v", header.Kind, store.PageHeader)
+	}
+}
+
+func TestInspectBLinkPage(t *testing.T) {
+	tree, err := newBLinkTree("inspect_blink_page", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := InspectPage(tree.store, tree.root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPageField(t, root, "flags", "branch, B-link, wide")
+	expectPageField(t, root, "right link", "0")
+	expectPageField(t, root, "high key", "none")
+}
+
+func TestInspectCorruptPage(t *testing.T) {
+	buf := make([]byte, 64)
+	buf[0] = 1
+	binary.LittleEndian.PutUint32(buf[1:5], 1000)
+	r := &fieldReader{buf: buf}
+	r.page()
+	if !r.corrupt {
+		t.Fatalf("expected a page of 1000 records in 64 bytes to be corrupt")
+	}
+	// The records that fit are still decoded.
+	if last := r.fields[len(r.fields)-1]; last.Name != "value 6" {
+		t.Fatalf("expected %v == value 6", last.Name)
+	}
+}
+
+// pageField returns the field of a page with the given name.
+func pageField(t *testing.T, info *store.PageInfo, name string) store.Field {
+	t.Helper()
+	for _, f := range info.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	t.Fatalf("expected a %q field in %v", name, info.Fields)
+	return store.Field{}
+}
+
+// expectPageField checks that a page has a field with the given value, or with any
+// value if value is empty.
+func expectPageField(t *testing.T, info *store.PageInfo, name, value string) {
+	t.Helper()
+	f := pageField(t, info, name)
+	if value != "" && f.Value != value {
+		t.Fatalf("expected %v == %v", f.Value, value)
+	}
+}
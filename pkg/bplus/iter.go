@@ -0,0 +1,110 @@
+package bplus
+
+import (
+	"bytes"
+	"iter"
+)
+
+// Iter is a sequence of keys and values read from a tree, or from a snapshot or
+// transaction of it. Range over Records to walk the sequence, and then call Err to find
+// out whether it was stopped early by an error. Every Iter keeps its own error, so
+// goroutines walking the same tree at once don't see each other's errors, but a single
+// Iter mustn't be walked by more than one goroutine at once.
+type Iter struct {
+	walk func(yield func(Key, Value) bool) error
+	err  error
+}
+
+// NewIter returns an Iter that walks its sequence with walk, which yields the keys and
+// values until yield asks it to stop, and returns the error that stopped it early, if
+// any.
+func NewIter(walk func(yield func(Key, Value) bool) error) *Iter {
+	return &Iter{walk: walk}
+}
+
+// Records returns the sequence of keys and values. Each range over it walks the sequence
+// from the start.
+func (it *Iter) Records() iter.Seq2[Key, Value] {
+	return func(yield func(Key, Value) bool) {
+		it.err = it.walk(yield)
+	}
+}
+
+// Err returns the error that stopped the most recent walk of the sequence early, or nil
+// if it ran until the end or the caller stopped it.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// All returns a sequence of every key and value in the tree in ascending key order.
+func (tree *Tree) All() *Iter {
+	return tree.iterate((*Cursor).First, (*Cursor).Next)
+}
+
+// Ascend returns a sequence of the keys and values in the tree from the smallest key
+// greater than or equal to start, in ascending key order.
+func (tree *Tree) Ascend(start Key) *Iter {
+	seek := func(c *Cursor) (Record, error) {
+		return c.Seek(start)
+	}
+	return tree.iterate(seek, (*Cursor).Next)
+}
+
+// Descend returns a sequence of the keys and values in the tree from the largest key less
+// than or equal to start, in descending key order.
+func (tree *Tree) Descend(start Key) *Iter {
+	seek := func(c *Cursor) (Record, error) {
+		r, err := c.Seek(start)
+		if err == ErrKeyNotFound {
+			return c.Last()
+		}
+		if err != nil || bytes.Equal(r.Key, start) {
+			return r, err
+		}
+		return c.Prev()
+	}
+	return tree.iterate(seek, (*Cursor).Prev)
+}
+
+// ScanPrefix returns a sequence of the keys and values in the tree whose keys start with
+// prefix, in ascending key order. The sequence stops at the first key without the prefix
+// rather than walking to the end of the tree.
+func (tree *Tree) ScanPrefix(prefix []byte) *Iter {
+	return NewIter(func(yield func(Key, Value) bool) error {
+		it := tree.Ascend(prefix)
+		for k, v := range it.Records() {
+			if !bytes.HasPrefix(k, prefix) || !yield(k, v) {
+				break
+			}
+		}
+		return it.Err()
+	})
+}
+
+// iterate returns a sequence that walks a cursor from the record returned by first,
+// moving it with next. The cursor is closed when the sequence stops.
+func (tree *Tree) iterate(first, next func(*Cursor) (Record, error)) *Iter {
+	return NewIter(func(yield func(Key, Value) bool) (iterErr error) {
+		var records int64
+		span := tree.startSpan("bplus.Scan")
+		c := tree.Cursor()
+		defer func() {
+			err := c.Close()
+			if iterErr == nil {
+				iterErr = err
+			}
+			span.setAttribute(RecordsAttribute, records)
+			span.end(&iterErr)
+		}()
+		for r, err := first(c); err != ErrKeyNotFound; r, err = next(c) {
+			if err != nil {
+				return err
+			}
+			records++
+			if !yield(r.Key, r.Value) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,191 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	tree, err := newTree("all", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if err := tree.Insert(intKey(i*2), intValue(i*2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []int
+	it := tree.All()
+	for k, v := range it.Records() {
+		if !bytes.Equal(v, intValue(keyInt(k))) {
+			t.Fatalf("expected %v == %v", v, intValue(keyInt(k)))
+		}
+		got = append(got, keyInt(k))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var expected []int
+	for i := 0; i < 500; i++ {
+		expected = append(expected, i*2)
+	}
+	expectInts(t, got, expected)
+}
+
+func TestAscendDescend(t *testing.T) {
+	tree, err := newTree("ascend_descend", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i*2), intValue(i*2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []int
+	for k := range tree.Ascend(intKey(189)).Records() {
+		got = append(got, keyInt(k))
+	}
+	expectInts(t, got, []int{190, 192, 194, 196, 198})
+
+	got = nil
+	for k := range tree.Descend(intKey(9)).Records() {
+		got = append(got, keyInt(k))
+	}
+	expectInts(t, got, []int{8, 6, 4, 2, 0})
+
+	got = nil
+	for k := range tree.Descend(intKey(8)).Records() {
+		got = append(got, keyInt(k))
+	}
+	expectInts(t, got, []int{8, 6, 4, 2, 0})
+
+	got = nil
+	for k := range tree.Descend(intKey(1000)).Records() {
+		got = append(got, keyInt(k))
+		if len(got) == 3 {
+			break
+		}
+	}
+	expectInts(t, got, []int{198, 196, 194})
+
+	it := tree.Ascend(intKey(1000))
+	for range it.Records() {
+		t.Fatal("expected nothing after the largest key")
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllReleasesPages(t *testing.T) {
+	tree, err := newTree("all_releases_pages", 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]Record, 1000)
+	for i := range records {
+		records[i] = Record{Key: intKey(i), Value: intValue(i)}
+	}
+	if err := tree.BulkLoad(records, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Stopping early many times over would fill the cache if pages were left pinned.
+	for i := 0; i < 100; i++ {
+		it := tree.Ascend(intKey(i * 10))
+		for range it.Records() {
+			break
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Read(intKey(999)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanPrefix(t *testing.T) {
+	tree, err := newTree("scan_prefix", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"a", "ab", "abc", "abd", "ac", "b", "ba", "user:1", "user:2", "users"}
+	for _, k := range keys {
+		if err := tree.Insert(Key(k), Value(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for prefix, expected := range map[string][]string{
+		"":      keys,
+		"a":     {"a", "ab", "abc", "abd", "ac"},
+		"ab":    {"ab", "abc", "abd"},
+		"abc":   {"abc"},
+		"b":     {"b", "ba"},
+		"user:": {"user:1", "user:2"},
+		"c":     nil,
+		"zzz":   nil,
+	} {
+		var got []string
+		it := tree.ScanPrefix([]byte(prefix))
+		for k, v := range it.Records() {
+			if !bytes.Equal(k, v) {
+				t.Fatalf("expected %v == %v", k, v)
+			}
+			got = append(got, string(k))
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("expected %v == %v for prefix %q", got, expected, prefix)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("expected %v == %v for prefix %q", got, expected, prefix)
+			}
+		}
+	}
+}
+
+func TestIterErrIsPerSequence(t *testing.T) {
+	filename := tempFilename(t, "iter_err_per_sequence")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(intKey(1), largeValue(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(2), intValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(valueLogSegments(t, filename)[0], os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, 100); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	// The scan over the corrupt value fails, and one walked after it doesn't clear its
+	// error.
+	failed := tree.All()
+	for range failed.Records() {
+		t.Fatal("expected the corrupt value to stop the scan")
+	}
+	ok := tree.Ascend(intKey(2))
+	for range ok.Records() {
+	}
+	if err := ok.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := failed.Err(); !errors.Is(err, ErrValueLogCorrupt) {
+		t.Fatalf("expected %v == %v", err, ErrValueLogCorrupt)
+	}
+}
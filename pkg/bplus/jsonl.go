@@ -0,0 +1,69 @@
+package bplus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// jsonRecord is a line of a JSON lines file.
+type jsonRecord struct {
+	Key   *string `json:"key"`
+	Value *string `json:"value"`
+}
+
+// ErrJSONFields is returned when importing a JSON line that doesn't have both a key and a
+// value.
+var ErrJSONFields = errors.New("JSON line does not have a key and a value")
+
+// ImportJSONL writes the records of the JSON lines file read from r to the tree,
+// overwriting the values of keys it already has, and returns how many it wrote. Every
+// line is an object like {"key":"k","value":"v"}, with the key and value written by f,
+// and blank lines are skipped. The records are written in batches, see Apply, so an
+// import that fails part way leaves the batches before it behind.
+func (tree *Tree) ImportJSONL(r io.Reader, f TextFormat) (n int, err error) {
+	defer tree.startSpan("bplus.ImportJSONL").end(&err)
+	br := bufio.NewReader(r)
+	line := 0
+	return tree.importText(f, func() (int, string, string, error) {
+		for {
+			text, err := br.ReadBytes('\n')
+			if err == io.EOF && len(text) == 0 {
+				return 0, "", "", io.EOF
+			}
+			if err != nil && err != io.EOF {
+				return 0, "", "", err
+			}
+			line++
+			if len(bytes.TrimSpace(text)) == 0 {
+				continue
+			}
+			var record jsonRecord
+			if err := json.Unmarshal(text, &record); err != nil {
+				return 0, "", "", &TextError{Line: line, Err: err}
+			}
+			if record.Key == nil || record.Value == nil {
+				return 0, "", "", &TextError{Line: line, Err: ErrJSONFields}
+			}
+			return line, *record.Key, *record.Value, nil
+		}
+	})
+}
+
+// ExportJSONL writes every record in the tree to w as a JSON lines file, in order, see
+// ImportJSONL, and returns how many it wrote.
+func (tree *Tree) ExportJSONL(w io.Writer, f TextFormat) (n int, err error) {
+	defer tree.startSpan("bplus.ExportJSONL").end(&err)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	n, err = tree.exportText(f, func(key, value string) error {
+		return enc.Encode(jsonRecord{Key: &key, Value: &value})
+	})
+	if flushErr := bw.Flush(); err == nil {
+		err = flushErr
+	}
+	return n, err
+}
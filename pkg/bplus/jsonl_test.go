@@ -0,0 +1,59 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONLRoundTrip(t *testing.T) {
+	filename := tempFilename(t, "jsonl")
+	defer os.Remove(filename)
+	tree, err := NewTree(filename, WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	input := `{"key":"a","value":"<1>"}` + "\n\n" + `{"value":"2","key":"b"}`
+	n, err := tree.ImportJSONL(strings.NewReader(input), TextFormat{})
+	if err != nil || n != 2 {
+		t.Fatalf("expected %v == %v, got %v", n, 2, err)
+	}
+	var out bytes.Buffer
+	n, err = tree.ExportJSONL(&out, TextFormat{Values: HexText})
+	if err != nil || n != 2 {
+		t.Fatalf("expected %v == %v, got %v", n, 2, err)
+	}
+	expected := `{"key":"a","value":"3c313e"}` + "\n" + `{"key":"b","value":"32"}` + "\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q == %q", out.String(), expected)
+	}
+}
+
+func TestImportJSONLErrors(t *testing.T) {
+	filename := tempFilename(t, "jsonl_errors")
+	defer os.Remove(filename)
+	tree, err := NewTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for _, test := range []struct {
+		input string
+		line  int
+		err   error
+	}{
+		{`{"key":"aa","value":"1"}` + "\n" + `{"key":"bb"}`, 2, ErrJSONFields},
+		{"\n" + `{"key":`, 2, nil},
+		{`{"key":"zz","value":"1"}`, 1, nil},
+	} {
+		var textErr *TextError
+		_, err := tree.ImportJSONL(strings.NewReader(test.input), TextFormat{Keys: HexText})
+		if !errors.As(err, &textErr) || textErr.Line != test.line ||
+			test.err != nil && textErr.Err != test.err {
+			t.Fatalf("expected an error on line %d for %q, got %v", test.line, test.input, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package bplus
+
+import (
+	"sync"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// A tree can be read and written by many goroutines at once. Read, cursors and the
+// writes built on put hold the tree's lock for reading and latch the pages they visit on
+// their way down from the root, latching each page before letting go of its parent, so
+// that no other goroutine can change the path between them. Everything else holds the
+// tree's lock for writing, which waits for them to finish and keeps them out until it's
+// done.
+//
+// Readers latch every page for reading and let go of each parent as soon as the child is
+// latched. Writers first try the same, latching only the leaf for writing, which is
+// enough to replace the value of a key when the leaf has room for it. Otherwise they
+// start again from the root latching every page for writing, and let go of the pages
+// above a page as soon as it has room for any split from below, so writers to different
+// parts of the tree only wait on each other where their paths still need splitting.
+// Branch pages count the records under each of their children, and the counts above the
+// pages a writer let go of are brought up to date afterwards, one branch at a time, the
+// way a B-link tree does, see recountAbove. Until then Len can be off by the writes still
+// in flight. In copy-on-write mode, where writing a page moves it and so changes its
+// parent, every write keeps the whole path latched.
+
+// latchTable hands out a latch for every page that a goroutine has latched or is waiting
+// to latch. The root is latched with the tree's rootLatch instead, because the root moves
+// when it's split, and the latch has to guard which page is the root as well.
+type latchTable struct {
+	sync.Mutex
+	latches map[store.PageID]*latch
+}
+
+type latch struct {
+	sync.RWMutex
+	// users counts the goroutines holding or waiting for the latch, so that it's only
+	// dropped from the table once none of them need it.
+	users int
+}
+
+// acquire latches a page, for writing if exclusive is set and otherwise for reading.
+func (t *latchTable) acquire(pageID store.PageID, exclusive bool) {
+	t.Lock()
+	if t.latches == nil {
+		t.latches = map[store.PageID]*latch{}
+	}
+	l, ok := t.latches[pageID]
+	if !ok {
+		l = &latch{}
+		t.latches[pageID] = l
+	}
+	l.users++
+	t.Unlock()
+	if exclusive {
+		l.Lock()
+	} else {
+		l.RLock()
+	}
+}
+
+// release lets go of a latch taken with acquire.
+func (t *latchTable) release(pageID store.PageID, exclusive bool) {
+	t.Lock()
+	defer t.Unlock()
+	l := t.latches[pageID]
+	if exclusive {
+		l.Unlock()
+	} else {
+		l.RUnlock()
+	}
+	l.users--
+	if l.users == 0 {
+		delete(t.latches, pageID)
+	}
+}
+
+// latchedPage is a page loaded and latched on the way down the tree.
+type latchedPage struct {
+	*store.PageHandle
+	// id is the page id the page was latched under. In copy-on-write mode the page moves
+	// to a fresh page when it's written, but it stays latched under its old id.
+	id        store.PageID
+	exclusive bool
+}
+
+// latchChild latches and loads the child of a latched page. A page that turns out to be
+// a leaf is latched for writing if exclusiveLeaf is set, and every other page for
+// writing if exclusive is set. Either way the child stays where it is until its parent
+// is let go of, so a leaf latched for reading can be latched again for writing.
+//
+// Pages latched for reading are only viewed, so with WithMmap a clean page is read
+// straight out of the mapping without taking a cache slot. Pages latched for writing
+// are loaded into the cache, where they're changed.
+func (tree *Tree) latchChild(
+	pageID store.PageID,
+	exclusive, exclusiveLeaf bool,
+) (*latchedPage, error) {
+	tree.latches.acquire(pageID, exclusive)
+	load := tree.store.View
+	if exclusive {
+		load = tree.store.Load
+	}
+	page, err := load(pageID)
+	if err != nil {
+		tree.latches.release(pageID, exclusive)
+		return nil, err
+	}
+	child := &latchedPage{PageHandle: page, id: pageID, exclusive: exclusive}
+	if exclusiveLeaf && !exclusive && isLeafPage(page) {
+		tree.latches.release(pageID, false)
+		tree.latches.acquire(pageID, true)
+		child.exclusive = true
+		if page.ReadOnly() {
+			// Another writer may have changed the leaf in the cache in between, and it's
+			// going to be changed in the cache anyway.
+			loaded, err := tree.store.Load(pageID)
+			releaseErr := tree.release(page)
+			if err == nil && releaseErr != nil {
+				tree.release(loaded)
+				err = releaseErr
+			}
+			if err != nil {
+				tree.latches.release(pageID, true)
+				return nil, err
+			}
+			child.PageHandle = loaded
+		}
+	}
+	return child, nil
+}
+
+// unlatch releases a page and lets go of its latch.
+func (tree *Tree) unlatch(page *latchedPage) error {
+	err := tree.release(page.PageHandle)
+	tree.latches.release(page.id, page.exclusive)
+	return err
+}
+
+// findLeaf crabs down from the root to the leaf key belongs in, latching every page for
+// reading, and returns the leaf still loaded and latched. The leaf is latched for writing
+// if exclusive is set. It returns nil if the tree is empty. In a B-link tree it instead
+// latches one page at a time, see blink.go.
+func (tree *Tree) findLeaf(key Key, exclusive bool) (*latchedPage, error) {
+	if tree.blink() {
+		page, _, err := tree.descendBLink(key, exclusive)
+		return page, err
+	}
+	tree.rootLatch.RLock()
+	root := decodeBranch(tree.root.PageHandle)
+	if len(root.pointers) == 0 {
+		freeBranch(root)
+		tree.rootLatch.RUnlock()
+		return nil, nil
+	}
+	childID := root.pointers[root.childIndex(key)]
+	freeBranch(root)
+	page, err := tree.latchChild(childID, false, exclusive)
+	tree.rootLatch.RUnlock()
+	for err == nil && !isLeafPage(page.PageHandle) {
+		branch := decodeBranch(page.PageHandle)
+		childID := branch.pointers[branch.childIndex(key)]
+		freeBranch(branch)
+		var child *latchedPage
+		child, err = tree.latchChild(childID, false, exclusive)
+		unlatchErr := tree.unlatch(page)
+		if err == nil && unlatchErr != nil {
+			tree.unlatch(child)
+			err = unlatchErr
+		}
+		page = child
+	}
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
@@ -0,0 +1,210 @@
+package bplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	modes := map[string]func(*Tree) error{
+		"plain": func(*Tree) error { return nil },
+		"wal":   (*Tree).EnableWAL,
+		"cow":   (*Tree).EnableCOW,
+	}
+	for name, enable := range modes {
+		t.Run(name, func(t *testing.T) {
+			tree, err := newTree("concurrent_"+name, 4, 256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := enable(tree); err != nil {
+				t.Fatal(err)
+			}
+			testConcurrentReadsAndWrites(t, tree)
+		})
+	}
+}
+
+func testConcurrentReadsAndWrites(t *testing.T, tree *Tree) {
+	const (
+		goroutines = 8
+		existing   = 200
+		inserts    = 100
+	)
+	// Every goroutine has a counter of its own after the keys they share.
+	for i := 0; i < existing+goroutines; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			errs <- concurrentWorker(tree, g, existing, inserts)
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyTree(t, tree)
+	expected := existing + goroutines + goroutines*inserts
+	if tree.Len() != expected {
+		t.Fatalf("expected %v == %v", tree.Len(), expected)
+	}
+	for i := 0; i < existing; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) && !bytes.Equal(value, intValue(-i)) {
+			t.Fatalf("unexpected value %v for key %v", value, i)
+		}
+	}
+	for g := 0; g < goroutines; g++ {
+		value, err := tree.Read(intKey(existing + g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every goroutine bumped its counter once per insert.
+		count := int(binary.LittleEndian.Uint32(value))
+		if count != existing+g+inserts {
+			t.Fatalf("expected %v == %v", count, existing+g+inserts)
+		}
+	}
+	for g := 0; g < goroutines; g++ {
+		for j := 0; j < inserts; j++ {
+			key := concurrentKey(g, j)
+			value, err := tree.Read(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(value, Value(key)) {
+				t.Fatalf("expected %v == %v", value, key)
+			}
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// concurrentWorker inserts keys of its own, which splits pages, while overwriting and
+// reading the values of keys shared with the other workers.
+func concurrentWorker(tree *Tree, g, existing, inserts int) error {
+	for j := 0; j < inserts; j++ {
+		err := tree.Insert(concurrentKey(g, j), Value(concurrentKey(g, j)))
+		if err != nil {
+			return err
+		}
+		i := (g*31 + j*7) % existing
+		// Overwrites replace the value in place, so they only latch the leaf.
+		value := intValue(i)
+		if j%2 == 0 {
+			value = intValue(-i)
+		}
+		if err := tree.Upsert(intKey(i), value); err != nil {
+			return err
+		}
+		value, err = tree.Read(intKey((i + 1) % existing))
+		if err != nil {
+			return err
+		}
+		if len(value) != 4 {
+			return ErrValueMismatch
+		}
+		err = tree.Update(intKey(existing+g), func(old Value) (Value, error) {
+			count := binary.LittleEndian.Uint32(old) + 1
+			return binary.LittleEndian.AppendUint32(nil, count), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// concurrentKey returns a key that sorts after every integer key, so that inserting it
+// doesn't collide with the keys shared between the workers.
+func concurrentKey(g, j int) Key {
+	return Key{0xFF, byte(g), byte(j)}
+}
+
+// TestConcurrentWritersOverlap checks that writers adding and removing keys far apart in
+// a tree with room to spare don't wait on each other, by holding every writer in its
+// putFunc, with its leaf latched, until all of them are there at once.
+func TestConcurrentWritersOverlap(t *testing.T) {
+	errSerialized := errors.New("writers waited on each other")
+	for name, kind := range map[string]putKind{"adds": putAdds, "removes": putRemoves} {
+		t.Run(name, func(t *testing.T) {
+			tree, err := newTree("overlap_"+name, 16, 256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			records := make([]Record, 1000)
+			for i := range records {
+				records[i] = Record{Key: intKey(2 * i), Value: intValue(2 * i)}
+			}
+			// Half full pages can take another record or split without splitting their
+			// parents.
+			if err := tree.BulkLoad(records, 0.5); err != nil {
+				t.Fatal(err)
+			}
+			const writers = 4
+			var arrived sync.WaitGroup
+			arrived.Add(writers)
+			all := make(chan struct{})
+			go func() {
+				arrived.Wait()
+				close(all)
+			}()
+			errs := make(chan error, writers)
+			for g := 0; g < writers; g++ {
+				go func(g int) {
+					// Adds go between the keys in the tree, and removes replace them with
+					// tombstones.
+					key := intKey(g*500 + 1)
+					if kind == putRemoves {
+						key = intKey(g * 500)
+					}
+					errs <- tree.put(key, kind, func(Value, bool) (Value, error) {
+						arrived.Done()
+						select {
+						case <-all:
+						case <-time.After(5 * time.Second):
+							return nil, errSerialized
+						}
+						if kind == putRemoves {
+							return nil, errWriteTombstone
+						}
+						return intValue(g), nil
+					})
+				}(g)
+			}
+			for g := 0; g < writers; g++ {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+			}
+			verifyTree(t, tree)
+			expected := len(records) + writers
+			if kind == putRemoves {
+				expected = len(records) - writers
+			}
+			if tree.Len() != expected {
+				t.Fatalf("expected %v == %v", tree.Len(), expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package bplus
+
+import (
+	"bytes"
+	"sort"
+	"sync/atomic"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Min returns the record with the smallest key in the tree, or ErrKeyNotFound if the tree
+// is empty.
+func (tree *Tree) Min() (Record, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.boundary(false)
+}
+
+// Max returns the record with the largest key in the tree, or ErrKeyNotFound if the tree
+// is empty.
+func (tree *Tree) Max() (Record, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.boundary(true)
+}
+
+// Floor returns the record with the largest key less than or equal to key, or
+// ErrKeyNotFound if there isn't one.
+func (tree *Tree) Floor(key Key) (Record, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if len(tree.root.pointers) == 0 {
+		return Record{}, ErrKeyNotFound
+	}
+	r, ok, err := tree.floor(tree.root.ID, key)
+	if err != nil {
+		return Record{}, err
+	}
+	if !ok {
+		return Record{}, ErrKeyNotFound
+	}
+	return tree.values.resolve(r)
+}
+
+// Ceiling returns the record with the smallest key greater than or equal to key, or
+// ErrKeyNotFound if there isn't one.
+func (tree *Tree) Ceiling(key Key) (Record, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if len(tree.root.pointers) == 0 {
+		return Record{}, ErrKeyNotFound
+	}
+	r, ok, err := tree.ceiling(tree.root.ID, key)
+	if err != nil {
+		return Record{}, err
+	}
+	if !ok {
+		return Record{}, ErrKeyNotFound
+	}
+	return tree.values.resolve(r)
+}
+
+// ReadRef reads the value of key like Read, but without copying it out of the page it's
+// stored in, or returns ErrKeyNotFound if it's not found. The value aliases the page,
+// which stays loaded and latched for reading until release is called, so the value must
+// not be changed, or used once it's been released. Writes to the page, and everything
+// that holds the tree's lock for writing, wait until then, so release should be called
+// as soon as the value has been used, and before the goroutine uses the tree again.
+// Values kept in the value log are read from it and copied, see WithValueLog.
+func (tree *Tree) ReadRef(key Key) (value Value, release func() error, err error) {
+	tree.lock.RLock()
+	page, err := tree.findLeaf(key, false)
+	if err != nil || page == nil {
+		tree.lock.RUnlock()
+		if err == nil {
+			err = ErrKeyNotFound
+		}
+		return nil, nil, err
+	}
+	value, pointer, found := leafValue(page.PageHandle, key)
+	if found && !pointer {
+		ref := &valueRef{tree: tree, page: page}
+		return value, ref.release, nil
+	}
+	defer tree.lock.RUnlock()
+	if !found {
+		err = ErrKeyNotFound
+	} else {
+		var r Record
+		r, err = tree.values.resolve(Record{Key: key, Value: value, pointer: true})
+		value = r.Value
+	}
+	unlatchErr := tree.unlatch(page)
+	if err == nil {
+		err = unlatchErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, func() error { return nil }, nil
+}
+
+// valueRef holds the page latched for a value returned by ReadRef until it's released,
+// which it's cheaper to allocate than a closure made by sync.OnceValue.
+type valueRef struct {
+	tree     *Tree
+	page     *latchedPage
+	released atomic.Bool
+}
+
+func (ref *valueRef) release() error {
+	if ref.released.Swap(true) {
+		return nil
+	}
+	defer ref.tree.lock.RUnlock()
+	return ref.tree.unlatch(ref.page)
+}
+
+// MultiGet reads the values of many keys at once. The returned values are in the same
+// order as keys, with a nil value for every key that isn't in the tree (values found in
+// the tree are never nil, even when empty). The keys are sorted and answered in a single
+// pass down the tree so that each page is loaded once rather than once per key.
+func (tree *Tree) MultiGet(keys []Key) ([]Value, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	values := make([]Value, len(keys))
+	if len(tree.root.pointers) == 0 || len(keys) == 0 {
+		return values, nil
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return bytes.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+	return values, tree.multiGet(tree.root.ID, keys, order, values)
+}
+
+// multiGet finds the keys at the indexes in order, which are sorted by key and all belong
+// in the subtree rooted at pageID, and stores their values.
+func (tree *Tree) multiGet(
+	pageID store.PageID,
+	keys []Key,
+	order []int,
+	values []Value,
+) error {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return err
+	}
+	if leaf != nil {
+		i := 0
+		for _, k := range order {
+			for i < len(leaf.records) && bytes.Compare(leaf.records[i].Key, keys[k]) < 0 {
+				i++
+			}
+			if i < len(leaf.records) && bytes.Equal(leaf.records[i].Key, keys[k]) &&
+				!leaf.records[i].tombstone {
+				r, err := tree.values.resolve(leaf.records[i])
+				if err != nil {
+					return err
+				}
+				values[k] = r.Value
+			}
+		}
+		return nil
+	}
+	start := 0
+	for start < len(order) {
+		child := branch.childIndex(keys[order[start]])
+		end := start + 1
+		for end < len(order) && branch.childIndex(keys[order[end]]) == child {
+			end++
+		}
+		err := tree.multiGet(branch.pointers[child], keys, order[start:end], values)
+		if err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+func (tree *Tree) boundary(last bool) (Record, error) {
+	if len(tree.root.pointers) == 0 {
+		return Record{}, ErrKeyNotFound
+	}
+	r, ok, err := tree.edge(tree.root.ID, last)
+	if err != nil {
+		return Record{}, err
+	}
+	if !ok {
+		return Record{}, ErrKeyNotFound
+	}
+	return tree.values.resolve(r)
+}
+
+// floor descends towards key and, if the subtree key belongs in has nothing less than or
+// equal to it, falls back on the largest record of the subtrees to its left.
+func (tree *Tree) floor(pageID store.PageID, key Key) (Record, bool, error) {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return Record{}, false, err
+	}
+	if leaf != nil {
+		i, found := leaf.search(key)
+		if !found {
+			i--
+		}
+		for ; i >= 0; i-- {
+			if !leaf.records[i].tombstone {
+				return leaf.records[i], true, nil
+			}
+		}
+		return Record{}, false, nil
+	}
+	i := branch.childIndex(key)
+	r, ok, err := tree.floor(branch.pointers[i], key)
+	if err != nil || ok {
+		return r, ok, err
+	}
+	for j := i - 1; j >= 0; j-- {
+		r, ok, err = tree.edge(branch.pointers[j], true)
+		if err != nil || ok {
+			return r, ok, err
+		}
+	}
+	return Record{}, false, nil
+}
+
+// ceiling descends towards key and, if the subtree key belongs in has nothing greater
+// than or equal to it, falls back on the smallest record of the subtrees to its right.
+func (tree *Tree) ceiling(pageID store.PageID, key Key) (Record, bool, error) {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return Record{}, false, err
+	}
+	if leaf != nil {
+		i, _ := leaf.search(key)
+		for ; i < len(leaf.records); i++ {
+			if !leaf.records[i].tombstone {
+				return leaf.records[i], true, nil
+			}
+		}
+		return Record{}, false, nil
+	}
+	i := branch.childIndex(key)
+	r, ok, err := tree.ceiling(branch.pointers[i], key)
+	if err != nil || ok {
+		return r, ok, err
+	}
+	for j := i + 1; j < len(branch.pointers); j++ {
+		r, ok, err = tree.edge(branch.pointers[j], false)
+		if err != nil || ok {
+			return r, ok, err
+		}
+	}
+	return Record{}, false, nil
+}
+
+// edge returns the smallest, or if last is set the largest, record in a subtree.
+func (tree *Tree) edge(pageID store.PageID, last bool) (Record, bool, error) {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return Record{}, false, err
+	}
+	if leaf != nil {
+		for i := range leaf.records {
+			j := i
+			if last {
+				j = len(leaf.records) - 1 - i
+			}
+			if !leaf.records[j].tombstone {
+				return leaf.records[j], true, nil
+			}
+		}
+		return Record{}, false, nil
+	}
+	for i := range branch.pointers {
+		j := i
+		if last {
+			j = len(branch.pointers) - 1 - i
+		}
+		r, ok, err := tree.edge(branch.pointers[j], last)
+		if err != nil || ok {
+			return r, ok, err
+		}
+	}
+	return Record{}, false, nil
+}
+
+// loadNode loads and decodes a page, then gives it back to the page cache because only
+// the decoded copy is needed. Exactly one of the returned pages is non-nil.
+func (tree *Tree) loadNode(pageID store.PageID) (*leafPage, *branchPage, error) {
+	page, err := tree.store.Load(pageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var leaf *leafPage
+	var branch *branchPage
+	if isLeafPage(page) {
+		leaf = &leafPage{PageHandle: page}
+		leaf.fromBuffer()
+	} else {
+		branch = &branchPage{PageHandle: page}
+		branch.fromBuffer()
+	}
+	return leaf, branch, tree.release(page)
+}
@@ -0,0 +1,240 @@
+package bplus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMinMax(t *testing.T) {
+	tree, err := newTree("min_max", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Min(); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if _, err := tree.Max(); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	for i := 100; i < 600; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	min, err := tree.Min()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(min.Key, intKey(100)) {
+		t.Fatalf("expected %v == %v", min.Key, intKey(100))
+	}
+	max, err := tree.Max()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(max.Key, intKey(599)) {
+		t.Fatalf("expected %v == %v", max.Key, intKey(599))
+	}
+}
+
+func TestFloorCeiling(t *testing.T) {
+	tree, err := newTree("floor_ceiling", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Insert only the even keys from 10 up to and including 1000.
+	for i := 10; i <= 1000; i += 2 {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 10; i <= 1000; i++ {
+		floor, err := tree.Floor(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(floor.Key, intKey(i-i%2)) {
+			t.Fatalf("expected floor of %d to be %v, got %v", i, intKey(i-i%2), floor.Key)
+		}
+		ceiling, err := tree.Ceiling(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(ceiling.Key, intKey(i+i%2)) {
+			t.Fatalf("expected ceiling of %d to be %v, got %v", i, intKey(i+i%2), ceiling.Key)
+		}
+		if !bytes.Equal(ceiling.Value, intValue(i+i%2)) {
+			t.Fatalf("expected %v == %v", ceiling.Value, intValue(i+i%2))
+		}
+	}
+	if _, err := tree.Floor(intKey(9)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if _, err := tree.Ceiling(intKey(1001)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	tree, err := newTree("multi_get", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i += 2 {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Insert(intKey(1001), Value{}); err != nil {
+		t.Fatal(err)
+	}
+	keys := []Key{intKey(998), intKey(3), intKey(0), intKey(500), intKey(1001), intKey(0)}
+	values, err := tree.MultiGet(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Value{intValue(998), nil, intValue(0), intValue(500), {}, intValue(0)}
+	for i := range keys {
+		if (values[i] == nil) != (expected[i] == nil) || !bytes.Equal(values[i], expected[i]) {
+			t.Fatalf("expected %v == %v for key %v", values[i], expected[i], keys[i])
+		}
+	}
+}
+
+func TestReadRef(t *testing.T) {
+	tree, err := newTree("read_ref", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		value, release, err := tree.ReadRef(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+		if err := release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := tree.ReadRef(intKey(1000)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := tree.SetLazyDelete(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(intKey(5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tree.ReadRef(intKey(5)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+
+	// A write to the leaf waits until the value is released.
+	value, release, err := tree.ReadRef(intKey(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error)
+	go func() {
+		written <- tree.Upsert(intKey(10), intValue(-10))
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("expected the write to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !bytes.Equal(value, intValue(10)) {
+		t.Fatalf("expected %v == %v", value, intValue(10))
+	}
+	// Appending to the value copies it rather than writing over the page.
+	_ = append(value, 1, 2, 3, 4)
+	if err := release(); err != nil {
+		t.Fatal(err)
+	}
+	// Releasing more than once has no effect.
+	if err := release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	value, err = tree.Read(intKey(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(-10)) {
+		t.Fatalf("expected %v == %v", value, intValue(-10))
+	}
+	value, err = tree.Read(intKey(11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(11)) {
+		t.Fatalf("expected %v == %v", value, intValue(11))
+	}
+}
+
+func TestReadRefAllocations(t *testing.T) {
+	tree, err := newTree("read_ref_allocations", 32, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(intKey(i), bytes.Repeat(intValue(i), 25)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The values are copied by Read but not by ReadRef, so it allocates fewer bytes, if not
+	// fewer times.
+	reads := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := tree.Read(intKey(7)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}).AllocedBytesPerOp()
+	refs := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, release, err := tree.ReadRef(intKey(7))
+			if err != nil {
+				b.Fatal(err)
+			}
+			release()
+		}
+	}).AllocedBytesPerOp()
+	if refs >= reads {
+		t.Fatalf("expected %v < %v", refs, reads)
+	}
+}
+
+func TestReadRefValueLog(t *testing.T) {
+	filename := tempFilename(t, "read_ref_value_log")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(intKey(1), largeValue(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	value, release, err := tree.ReadRef(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if !bytes.Equal(value, largeValue(1, 1000)) {
+		t.Fatalf("expected %v == %v", len(value), 1000)
+	}
+}
@@ -0,0 +1,168 @@
+package bplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/jpittis/bplus/pkg/keyencode"
+)
+
+var (
+	// ErrMultimap is returned when opening a multimap with OpenTree.
+	ErrMultimap = errors.New("tree is a multimap")
+	// ErrNotMultimap is returned when opening a tree that isn't a multimap with
+	// OpenMultimap.
+	ErrNotMultimap = errors.New("tree is not a multimap")
+)
+
+// multimapFlag is set in the file header of trees created by NewMultimap.
+const multimapFlag = 1
+
+// Multimap is a persisted B+ tree that allows many values to be stored under the same
+// key. The values of a key are kept in the order they were inserted.
+//
+// Each value is stored in the underlying tree under its own key, made of the key encoded
+// with keyencode.AppendBytes followed by a sequence number that counts up for every value
+// inserted under the key. The encoding takes up to twice as many bytes as the key itself,
+// so keys are limited to about half of MaxKeySize.
+type Multimap struct {
+	tree *Tree
+}
+
+// NewMultimap constructs a persisted multimap in the given file. Use OpenMultimap to
+// reattach to a multimap that was previously created in the file.
+func NewMultimap(filename string, opts ...Option) (*Multimap, error) {
+	tree, err := NewTree(filename, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.setFlags(tree.flags | multimapFlag)
+	return &Multimap{tree: tree}, err
+}
+
+// OpenMultimap reattaches to a persisted multimap that was created in the given file by
+// NewMultimap.
+func OpenMultimap(filename string, opts ...Option) (*Multimap, error) {
+	tree, err := openTree(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	if tree.flags&multimapFlag == 0 {
+		tree.Close()
+		return nil, ErrNotMultimap
+	}
+	return &Multimap{tree: tree}, nil
+}
+
+// Insert adds value to the values of key, after any values already inserted under key.
+func (m *Multimap) Insert(key Key, value Value) error {
+	start, end := multimapRange(key)
+	seq := uint64(0)
+	last, err := m.tree.Floor(end)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	if err == nil && bytes.HasPrefix(last.Key, start) {
+		seq = binary.BigEndian.Uint64(last.Key[len(start):]) + 1
+	}
+	return m.tree.Insert(multimapKey(start, seq), value)
+}
+
+// Read returns the first value inserted under key, or ErrKeyNotFound if key has no
+// values.
+func (m *Multimap) Read(key Key) (Value, error) {
+	start, _ := multimapRange(key)
+	r, err := m.tree.Ceiling(start)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(r.Key, start) {
+		return nil, ErrKeyNotFound
+	}
+	return r.Value, nil
+}
+
+// Values returns every value of key in the order they were inserted.
+func (m *Multimap) Values(key Key) ([]Value, error) {
+	start, _ := multimapRange(key)
+	var values []Value
+	next := start
+	for {
+		r, err := m.tree.Ceiling(next)
+		if err == ErrKeyNotFound {
+			return values, nil
+		}
+		if err != nil {
+			return values, err
+		}
+		if !bytes.HasPrefix(r.Key, start) {
+			return values, nil
+		}
+		values = append(values, r.Value)
+		// The smallest key greater than the record's key.
+		next = append(r.Key, 0)
+	}
+}
+
+// Count returns the number of values of key.
+func (m *Multimap) Count(key Key) (int, error) {
+	return m.tree.CountRange(multimapRange(key))
+}
+
+// Len returns the number of values in the multimap across all keys.
+func (m *Multimap) Len() int {
+	return m.tree.Len()
+}
+
+// Delete removes every value of key, or returns ErrKeyNotFound if key has no values.
+func (m *Multimap) Delete(key Key) error {
+	n, err := m.tree.DeleteRange(multimapRange(key))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// DeleteValue removes the first value of key that is equal to value, or returns
+// ErrKeyNotFound if key has no such value.
+func (m *Multimap) DeleteValue(key Key, value Value) error {
+	start, _ := multimapRange(key)
+	next := start
+	for {
+		r, err := m.tree.Ceiling(next)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(r.Key, start) {
+			return ErrKeyNotFound
+		}
+		if bytes.Equal(r.Value, value) {
+			return m.tree.Delete(r.Key)
+		}
+		next = append(r.Key, 0)
+	}
+}
+
+// multimapRange returns the range [start, end) of underlying keys holding the values of
+// key. The start of the range is key encoded with keyencode.AppendBytes, which is never a
+// prefix of the encoding of a different key and sorts in the same order as the keys.
+func multimapRange(key Key) (Key, Key) {
+	start := keyencode.AppendBytes(nil, key)
+	// The encoding ends with 0x00 0x01, so bumping the last byte gives the smallest key
+	// past every key that starts with it.
+	end := append(append(Key{}, start[:len(start)-1]...), 2)
+	return start, end
+}
+
+// multimapKey returns the underlying key of the value with sequence number seq in the
+// range starting at start.
+func multimapKey(start Key, seq uint64) Key {
+	key := make(Key, len(start)+8)
+	copy(key, start)
+	binary.BigEndian.PutUint64(key[len(start):], seq)
+	return key
+}
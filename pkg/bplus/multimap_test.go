@@ -0,0 +1,159 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMultimap(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "multimap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	m, err := NewMultimap(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keys which are prefixes of each other or contain zero bytes must not mix values.
+	keys := []Key{{}, {0}, {0, 0}, {1}, {1, 0}, {1, 0, 1}, {1, 1}, {2}}
+	for round := 0; round < 20; round++ {
+		for i, key := range keys {
+			if err := m.Insert(key, intValue(i*100+round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if m.Len() != 20*len(keys) {
+		t.Fatalf("expected %v == %v", m.Len(), 20*len(keys))
+	}
+	for i, key := range keys {
+		values, err := m.Values(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 20 {
+			t.Fatalf("expected %v == %v", len(values), 20)
+		}
+		for round, value := range values {
+			if !bytes.Equal(value, intValue(i*100+round)) {
+				t.Fatalf("expected %v == %v", value, intValue(i*100+round))
+			}
+		}
+		value, err := m.Read(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i*100)) {
+			t.Fatalf("expected %v == %v", value, intValue(i*100))
+		}
+		n, err := m.Count(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 20 {
+			t.Fatalf("expected %v == %v", n, 20)
+		}
+	}
+
+	// Delete one value from the middle of a key's values.
+	if err := m.DeleteValue(Key{1, 0}, intValue(405)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteValue(Key{1, 0}, intValue(405)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	values, err := m.Values(Key{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 19 || !bytes.Equal(values[5], intValue(406)) {
+		t.Fatalf("unexpected values %v", values)
+	}
+	// Values inserted after a delete still come last.
+	if err := m.Insert(Key{1, 0}, intValue(420)); err != nil {
+		t.Fatal(err)
+	}
+	values, err = m.Values(Key{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(values[len(values)-1], intValue(420)) {
+		t.Fatalf("expected %v == %v", values[len(values)-1], intValue(420))
+	}
+
+	// Delete every value of a key.
+	if err := m.Delete(Key{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(Key{1}); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if _, err := m.Read(Key{1}); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	n, err := m.Count(Key{1, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Fatalf("expected %v == %v", n, 20)
+	}
+	if m.Len() != 20*(len(keys)-1) {
+		t.Fatalf("expected %v == %v", m.Len(), 20*(len(keys)-1))
+	}
+	verifyTree(t, m.tree)
+}
+
+func TestOpenMultimap(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "open_multimap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	m, err := NewMultimap(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := m.Insert(Key{1}, intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, m.tree)
+	if _, err := OpenTree(tmpfile.Name(), WithCacheSize(20)); err != ErrMultimap {
+		t.Fatalf("expected %v == %v", err, ErrMultimap)
+	}
+	reopened, err := OpenMultimap(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := reopened.Count(Key{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("expected %v == %v", n, 10)
+	}
+
+	tmpfile, err = ioutil.TempFile("", "open_multimap_not_multimap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, tree)
+	if _, err := OpenMultimap(tmpfile.Name(), WithCacheSize(20)); err != ErrNotMultimap {
+		t.Fatalf("expected %v == %v", err, ErrNotMultimap)
+	}
+}
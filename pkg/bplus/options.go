@@ -0,0 +1,224 @@
+package bplus
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// DefaultBranchingFactor is the branching factor of trees created without
+// WithBranchingFactor. Pages also split once they run out of room, so with large keys or
+// values they hold fewer entries than this.
+const DefaultBranchingFactor = 128
+
+// MinBranchingFactor is the smallest branching factor a tree can have: a branch that
+// splits has to leave at least two children on either side.
+const MinBranchingFactor = 3
+
+// ErrInvalidBranchingFactor is returned when creating a tree with a branching factor
+// below MinBranchingFactor.
+var ErrInvalidBranchingFactor = errors.New("invalid branching factor")
+
+// DefaultReadAhead is the number of leaves a cursor prefetches as it scans a tree, unless
+// it's opened with WithReadAhead.
+const DefaultReadAhead = 8
+
+// Option configures a tree as it's created or opened, see NewTree and OpenTree.
+type Option func(*options)
+
+type options struct {
+	branchingFactor int
+	readAhead       int
+	store           []store.Option
+	// valueThreshold and valueLogSegmentSize configure the value log, see WithValueLog.
+	valueThreshold      int
+	valueLogSegmentSize int64
+	// tracerProvider provides the tracer operations are traced with, see
+	// WithTracerProvider.
+	tracerProvider TracerProvider
+	// slowOpThreshold is how long an operation takes to be logged, see
+	// WithSlowOpThreshold.
+	slowOpThreshold time.Duration
+}
+
+func newOptions(opts []Option) options {
+	o := options{branchingFactor: DefaultBranchingFactor, readAhead: DefaultReadAhead}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBranchingFactor sets the most children a branch of a new tree can have, which must
+// be at least MinBranchingFactor. A tree keeps the branching factor it was created with,
+// so it's ignored when opening a tree.
+func WithBranchingFactor(branchingFactor int) Option {
+	return func(o *options) {
+		o.branchingFactor = branchingFactor
+	}
+}
+
+// validate returns an error if the options can't create a tree.
+func (o options) validate() error {
+	if o.branchingFactor < MinBranchingFactor {
+		return ErrInvalidBranchingFactor
+	}
+	return nil
+}
+
+// WithReadAhead sets how many leaves ahead of a cursor are prefetched once it's moving
+// from leaf to leaf, see Cursor. Zero turns read-ahead off.
+func WithReadAhead(leaves int) Option {
+	return func(o *options) {
+		o.readAhead = leaves
+	}
+}
+
+// WithValueLog keeps values of threshold bytes or more in a value log kept in files
+// alongside the tree's, with the leaves only holding a pointer to each of them, which
+// keeps the tree small when many of its values are large. Values too large to fit in a
+// leaf at all are always kept in the log. The log is written in segments of up to
+// segmentSize bytes, or DefaultValueLogSegmentSize if it's zero, and the space taken up
+// by values that have been overwritten or deleted is reclaimed by CollectValueLog.
+//
+// A tree opened with WithValueLog keeps using the value log from then on, with
+// DefaultValueThreshold and DefaultValueLogSegmentSize when it's opened without it.
+// Each write that appends to the value log syncs it before the tree points at the value.
+// Trees kept in memory or in a database can't use a value log.
+func WithValueLog(threshold int, segmentSize int64) Option {
+	return func(o *options) {
+		o.valueThreshold = max(threshold, 1)
+		o.valueLogSegmentSize = segmentSize
+	}
+}
+
+// WithTracerProvider traces the tree's reads, inserts, deletes, scans, commits and syncs
+// with a tracer from provider, recording the pages each of them loaded and the cache
+// misses they ran into, see Span. Trees aren't traced by default, which costs nothing but
+// a check of whether they are.
+func WithTracerProvider(provider TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = provider
+	}
+}
+
+// WithLogger logs what happens inside the tree and its page store to logger, see
+// store.WithLogger, along with torn and corrupt value log entries and collected value log
+// segments. Trees log nothing by default.
+func WithLogger(logger *slog.Logger) Option {
+	return withStoreOption(store.WithLogger(logger))
+}
+
+// WithSlowOpThreshold logs reads, writes, deletes, scans, commits and syncs that take
+// threshold or longer, along with the pages they loaded, the cache misses among them,
+// the time they spent on I/O and the time they waited for the tree's lock, to the logger
+// given to WithLogger. Flushes of dirty pages that take as long are logged too, see
+// store.WithSlowFlushThreshold. Zero turns logging slow operations off.
+func WithSlowOpThreshold(threshold time.Duration) Option {
+	flush := withStoreOption(store.WithSlowFlushThreshold(threshold))
+	return func(o *options) {
+		o.slowOpThreshold = threshold
+		flush(o)
+	}
+}
+
+// WithCacheSize sets the number of pages kept in memory, see store.WithCacheSize.
+func WithCacheSize(pages int) Option {
+	return withStoreOption(store.WithCacheSize(pages))
+}
+
+// WithEvictionPolicy chooses how pages are evicted from memory, see
+// store.WithEvictionPolicy.
+func WithEvictionPolicy(newPolicy func(capacity int) store.EvictionPolicy) Option {
+	return withStoreOption(store.WithEvictionPolicy(newPolicy))
+}
+
+// WithDurability chooses when changes are synced to disk, see Tree.SetDurability.
+func WithDurability(durability store.Durability, interval time.Duration) Option {
+	return withStoreOption(store.WithDurability(durability, interval))
+}
+
+// WithReadOnly opens the file for reading only. Every change to the tree returns
+// store.ErrReadOnly, see store.WithReadOnly.
+func WithReadOnly() Option {
+	return withStoreOption(store.WithReadOnly())
+}
+
+// WithRefuseUncleanShutdown refuses to open a file that was left part way through being
+// written, with store.ErrUncleanShutdown, see store.WithRefuseUncleanShutdown. Such a
+// file can be opened without the option and checked with Check.
+func WithRefuseUncleanShutdown() Option {
+	return withStoreOption(store.WithRefuseUncleanShutdown())
+}
+
+// WithLockTimeout sets how long to wait for another process to let go of the file before
+// giving up with store.ErrLocked, see store.WithLockTimeout.
+func WithLockTimeout(timeout time.Duration) Option {
+	return withStoreOption(store.WithLockTimeout(timeout))
+}
+
+// WithMmap memory-maps the file, so that pages that are only read, by lookups and
+// cursors, are read straight out of the mapping without taking a cache slot, and pages
+// that are changed are copied into the cache without a read syscall, see store.WithMmap.
+func WithMmap() Option {
+	return withStoreOption(store.WithMmap())
+}
+
+// WithIOUring reads and writes the file through an io_uring where there is one, see
+// store.WithIOUring.
+func WithIOUring() Option {
+	return withStoreOption(store.WithIOUring())
+}
+
+// WithPreallocation extends the file chunk bytes at a time as it grows, see
+// store.WithPreallocation.
+func WithPreallocation(chunk int64, doubling bool) Option {
+	return withStoreOption(store.WithPreallocation(chunk, doubling))
+}
+
+// WithEncryption encrypts the pages written to the file with the keys, see
+// store.WithEncryption.
+func WithEncryption(keys store.KeyProvider) Option {
+	return withStoreOption(store.WithEncryption(keys))
+}
+
+// WithFormatVersion creates the file in an older format, see store.WithFormatVersion.
+func WithFormatVersion(version int) Option {
+	return withStoreOption(store.WithFormatVersion(version))
+}
+
+// WithWALArchive hands every change committed to the tree's write-ahead log to archiver,
+// so that a backup can be brought forward to a point in time with RecoverTo, see
+// store.WithWALArchive. Changes are only archived once the log has been enabled with
+// EnableWAL.
+func WithWALArchive(archiver store.WALArchiver) Option {
+	return withStoreOption(store.WithWALArchive(archiver))
+}
+
+// WithCheckpoints leaves the changes committed to the tree's write-ahead log in it until
+// a checkpoint, taken once the log has grown to bytes and every interval, rather than
+// syncing the file after every change, see store.WithCheckpoints and Tree.Checkpoint.
+func WithCheckpoints(bytes int64, interval time.Duration) Option {
+	return withStoreOption(store.WithCheckpoints(bytes, interval))
+}
+
+// WithWALSegments splits the tree's write-ahead log into segments of about size bytes,
+// keeping retention of them once they're no longer needed, so that they can be archived
+// or replicated, see store.WithWALSegments and Tree.WALSegments.
+func WithWALSegments(size int64, retention int) Option {
+	return withStoreOption(store.WithWALSegments(size, retention))
+}
+
+// WithWALBackend keeps the tree's write-ahead log in backend, so that a tree kept in a
+// backend of its own can enable it, see store.WithWALBackend and NewBackendTree.
+func WithWALBackend(backend store.Backend) Option {
+	return withStoreOption(store.WithWALBackend(backend))
+}
+
+func withStoreOption(opt store.Option) Option {
+	return func(o *options) {
+		o.store = append(o.store, opt)
+	}
+}
@@ -0,0 +1,274 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "default_options")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.branchingFactor != DefaultBranchingFactor {
+		t.Fatalf("expected %v == %v", tree.branchingFactor, DefaultBranchingFactor)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The branching factor the tree was created with wins over the one it's opened with.
+	reopened, err := OpenTree(tmpfile.Name(), WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.branchingFactor != DefaultBranchingFactor {
+		t.Fatalf("expected %v == %v", reopened.branchingFactor, DefaultBranchingFactor)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvalidBranchingFactor(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "invalid_branching_factor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	for _, branchingFactor := range []int{-1, 0, 1, 2} {
+		opt := WithBranchingFactor(branchingFactor)
+		if _, err := NewTree(tmpfile.Name(), opt); err != ErrInvalidBranchingFactor {
+			t.Fatalf("expected %v == %v", err, ErrInvalidBranchingFactor)
+		}
+		if _, err := NewBLinkTree(tmpfile.Name(), opt); err != ErrInvalidBranchingFactor {
+			t.Fatalf("expected %v == %v", err, ErrInvalidBranchingFactor)
+		}
+		if _, err := NewMemoryTree(opt); err != ErrInvalidBranchingFactor {
+			t.Fatalf("expected %v == %v", err, ErrInvalidBranchingFactor)
+		}
+	}
+	tree, err := NewMemoryTree(WithBranchingFactor(MinBranchingFactor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyTree(t, tree)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "read_only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	if _, err := NewTree(tmpfile.Name(), WithReadOnly()); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20), WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Insert(intKey(100), intValue(100)); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	if err := reopened.Upsert(intKey(0), intValue(1)); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	if err := reopened.Delete(intKey(0)); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	var batch WriteBatch
+	batch.Put(intKey(100), intValue(100))
+	if err := reopened.Apply(&batch); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	if err := reopened.SetLazyDelete(true); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	if err := reopened.EnableWAL(); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := reopened.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+	verifyTree(t, reopened)
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The file can still be opened for writing afterwards.
+	writable, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !writable.Recovery().ClosedCleanly {
+		t.Fatal("expected the file to still be marked as closed cleanly")
+	}
+	if writable.Len() != 100 {
+		t.Fatalf("expected %v == %v", writable.Len(), 100)
+	}
+	if err := writable.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMmap(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithMmap())
+	if err == store.ErrMmapUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages added to the end of the file are loaded through the mapping as it grows.
+	for i := 0; i < 2000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.DeleteRange(intKey(1000), intKey(2000)); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = OpenTree(tmpfile.Name(), WithCacheSize(20), WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	verifyTree(t, tree)
+	for i := 0; i < 1000; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+}
+
+func TestIOUring(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "uring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	// Trees work the same with or without an io_uring to read and write through.
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithIOUring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = OpenTree(tmpfile.Name(), WithCacheSize(20), WithIOUring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	verifyTree(t, tree)
+	if tree.Len() != 1000 {
+		t.Fatalf("expected %v == %v", tree.Len(), 1000)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	keys := store.StaticKey(bytes.Repeat([]byte{1}, 32))
+	for name, newTree := range map[string]func(string, ...Option) (*Tree, error){
+		"tree":   NewTree,
+		"b-link": NewBLinkTree,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpfile, err := ioutil.TempFile("", "encryption")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tmpfile.Close()
+			tree, err := newTree(
+				tmpfile.Name(),
+				WithBranchingFactor(16),
+				WithCacheSize(20),
+				WithEncryption(keys),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Full pages leave the space the store keeps for the nonce and tag alone, and
+			// B-link trees keep their links just before it.
+			value := bytes.Repeat([]byte{7}, 300)
+			for i := 0; i < 1000; i++ {
+				if err := tree.Insert(intKey(i), value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			verifyTree(t, tree)
+			if err := tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := OpenTree(tmpfile.Name()); err != store.ErrEncrypted {
+				t.Fatalf("expected %v == %v", err, store.ErrEncrypted)
+			}
+			tree, err = OpenTree(tmpfile.Name(), WithCacheSize(20), WithEncryption(keys))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			verifyTree(t, tree)
+			for i := 0; i < 1000; i++ {
+				got, err := tree.Read(intKey(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, value) {
+					t.Fatalf("expected %v == %v", got, value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package bplus
+
+import (
+	"sync"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Pages decoded only long enough to find the way down the tree, or to copy a record out
+// of, are decoded into leafPages and branchPages taken from pools and given back once
+// they're done with, so that their records, keys and copies of the page are reused
+// rather than allocated on every read. Pages whose records or keys outlive the decode,
+// such as the pages held by cursors or returned by Min and Floor, are decoded with
+// fromBuffer instead.
+var (
+	leafPool   = sync.Pool{New: func() any { return &leafPage{} }}
+	branchPool = sync.Pool{New: func() any { return &branchPage{} }}
+)
+
+// decodeLeaf decodes a leaf into a leafPage from the pool, which must be given back with
+// freeLeaf once nothing refers to its records anymore.
+func decodeLeaf(page *store.PageHandle) *leafPage {
+	leaf := leafPool.Get().(*leafPage)
+	leaf.PageHandle = page
+	leaf.decode()
+	return leaf
+}
+
+func freeLeaf(leaf *leafPage) {
+	leaf.PageHandle = nil
+	leaf.rightLink = rightLink{}
+	leafPool.Put(leaf)
+}
+
+// decodeBranch is decodeLeaf for branches, which must be given back with freeBranch.
+func decodeBranch(page *store.PageHandle) *branchPage {
+	branch := branchPool.Get().(*branchPage)
+	branch.PageHandle = page
+	branch.decode()
+	return branch
+}
+
+func freeBranch(branch *branchPage) {
+	branch.PageHandle = nil
+	branch.rightLink = rightLink{}
+	branchPool.Put(branch)
+}
+
+// entryPool holds the buffers entries are built in before they're appended to the value
+// log.
+var entryPool = sync.Pool{New: func() any { return new([]byte) }}
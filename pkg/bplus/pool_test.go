@@ -0,0 +1,139 @@
+package bplus
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestPooledDecode(t *testing.T) {
+	// A pooled page is decoded the same however large the page it was last used for.
+	for _, n := range []int{64, 3, 0, 20} {
+		leaf := encodedLeaf(n)
+		pooled := decodeLeaf(leaf.PageHandle)
+		if len(pooled.records) != n {
+			t.Fatalf("expected %v == %v", len(pooled.records), n)
+		}
+		for i, r := range pooled.records {
+			expected := leaf.records[i]
+			equal := bytes.Equal(r.Key, expected.Key) &&
+				bytes.Equal(r.Value, expected.Value) &&
+				r.tombstone == expected.tombstone
+			if !equal {
+				t.Fatalf("expected %v == %v", r, expected)
+			}
+		}
+		freeLeaf(pooled)
+
+		branch := encodedBranch(n)
+		pooledBranch := decodeBranch(branch.PageHandle)
+		if len(pooledBranch.keys) != n || len(pooledBranch.pointers) != n+1 {
+			t.Fatalf("expected %v == %v", len(pooledBranch.keys), n)
+		}
+		for i, key := range pooledBranch.keys {
+			if !bytes.Equal(key, intKey(i)) {
+				t.Fatalf("expected %v == %v", key, intKey(i))
+			}
+		}
+		for i, pointer := range pooledBranch.pointers {
+			if pointer != store.PageID(i+2) {
+				t.Fatalf("expected %v == %v", pointer, i+2)
+			}
+		}
+		freeBranch(pooledBranch)
+	}
+}
+
+func BenchmarkDecodeLeaf(b *testing.B) {
+	leaf := encodedLeaf(64)
+	b.Run("fromBuffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			decoded := &leafPage{PageHandle: leaf.PageHandle}
+			decoded.fromBuffer()
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			freeLeaf(decodeLeaf(leaf.PageHandle))
+		}
+	})
+}
+
+func BenchmarkDecodeBranch(b *testing.B) {
+	branch := encodedBranch(64)
+	b.Run("fromBuffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			decoded := &branchPage{PageHandle: branch.PageHandle}
+			decoded.fromBuffer()
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			freeBranch(decodeBranch(branch.PageHandle))
+		}
+	})
+}
+
+func BenchmarkValueLogAppend(b *testing.B) {
+	filename := tempFilename(b, "benchmark_value_log_append")
+	defer removeValueLog(filename)
+	discard := slog.New(slog.DiscardHandler)
+	for _, size := range []int{1024, 16384} {
+		log, err := openValueLog(filename, 0, DefaultValueLogSegmentSize, false, discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+		value := largeValue(1, size)
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				if _, err := log.append(intKey(i), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		if err := log.close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// encodedLeaf returns a leaf of n records, every fifth of them a tombstone, encoded into
+// a page of its own.
+func encodedLeaf(n int) *leafPage {
+	leaf := &leafPage{PageHandle: &store.PageHandle{Page: &store.Page{}}}
+	for i := 0; i < n; i++ {
+		leaf.records = append(leaf.records, Record{
+			Key:       intKey(i),
+			Value:     intValue(-i),
+			tombstone: i%5 == 4,
+		})
+		if leaf.records[i].tombstone {
+			leaf.records[i].Value = nil
+		}
+	}
+	leaf.toBuffer()
+	return leaf
+}
+
+// encodedBranch returns a branch of n keys encoded into a page of its own.
+func encodedBranch(n int) *branchPage {
+	branch := &branchPage{PageHandle: &store.PageHandle{Page: &store.Page{}}}
+	for i := 0; i <= n; i++ {
+		if i < n {
+			branch.keys = append(branch.keys, intKey(i))
+		}
+		branch.pointers = append(branch.pointers, store.PageID(i+2))
+		branch.counts = append(branch.counts, 1)
+	}
+	branch.toBuffer()
+	return branch
+}
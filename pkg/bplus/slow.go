@@ -0,0 +1,66 @@
+package bplus
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// timed returns whether the tree's operations are timed, which they are when they're
+// traced or slow ones are logged.
+func (tree *Tree) timed() bool {
+	return tree.tracer != nil || tree.slowOpThreshold > 0
+}
+
+// timedLock locks the tree's lock, counting the time spent waiting for it if the tree's
+// operations are timed.
+func (tree *Tree) timedLock() {
+	if !tree.timed() {
+		tree.lock.Lock()
+		return
+	}
+	start := time.Now()
+	tree.lock.Lock()
+	tree.lockWait.Add(int64(time.Since(start)))
+}
+
+// timedRLock read locks the tree's lock, counting the time spent waiting for it if the
+// tree's operations are timed.
+func (tree *Tree) timedRLock() {
+	if !tree.timed() {
+		tree.lock.RLock()
+		return
+	}
+	start := time.Now()
+	tree.lock.RLock()
+	tree.lockWait.Add(int64(time.Since(start)))
+}
+
+// logSlow logs the operation the span timed if it took the slow operation threshold or
+// longer, breaking down where the time went.
+func (s *span) logSlow(stats store.CacheStats, err error) {
+	threshold := s.tree.slowOpThreshold
+	took := time.Since(s.begun)
+	if threshold <= 0 || took < threshold {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("op", s.name),
+		slog.Duration("took", took),
+		slog.Uint64("pages_loaded", stats.Hits+stats.Misses-s.start.Hits-s.start.Misses),
+		slog.Uint64("cache_misses", stats.Misses-s.start.Misses),
+		slog.Duration("io", stats.IOTime-s.start.IOTime),
+		slog.Duration("lock_wait", time.Duration(s.tree.lockWait.Load()-s.lockWait)),
+	}
+	for _, a := range s.attributes {
+		attrs = append(attrs, slog.Int64(strings.TrimPrefix(a.key, "bplus."), a.value))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	s.tree.store.Logger().LogAttrs(context.Background(), slog.LevelWarn, "slow operation",
+		attrs...)
+}
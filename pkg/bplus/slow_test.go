@@ -0,0 +1,111 @@
+package bplus
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowOpLogging(t *testing.T) {
+	var logs bytes.Buffer
+	filename := tempFilename(t, "slow_op")
+	tree, err := NewTree(filename,
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithSlowOpThreshold(time.Nanosecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(intKey(1), intValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	expectSlowOp(t, &logs, "op=bplus.Insert took=")
+	expectSlowOp(t, &logs, "op=bplus.commit took=")
+
+	logs.Reset()
+	if _, err := tree.Read(intKey(2)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	expectSlowOp(t, &logs, "op=bplus.Read took=")
+	expectSlowOp(t, &logs, " pages_loaded=1 cache_misses=0 io=")
+	expectSlowOp(t, &logs, ` error="key not found"`)
+
+	logs.Reset()
+	for range tree.Ascend(intKey(0)).Records() {
+	}
+	expectSlowOp(t, &logs, "op=bplus.Scan took=")
+	expectSlowOp(t, &logs, " records=1")
+
+	logs.Reset()
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// The dirty pages are flushed by the store, which logs the flush as well.
+	expectSlowOp(t, &logs, "op=bplus.flush took=")
+	expectSlowOp(t, &logs, `msg="slow flush" pages=`)
+}
+
+func TestSlowOpLockWait(t *testing.T) {
+	var logs bytes.Buffer
+	tree, err := NewMemoryTree(
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithSlowOpThreshold(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(intKey(1), intValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	logs.Reset()
+
+	tree.lock.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := tree.Read(intKey(1))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	tree.lock.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expectSlowOp(t, &logs, "op=bplus.Read took=")
+	// Reading a single leaf doesn't take anywhere near the threshold on its own, so the
+	// time was spent waiting for the lock.
+	if strings.Contains(logs.String(), "lock_wait=0s") {
+		t.Fatalf("expected the wait for the lock to be logged, got %q", logs.String())
+	}
+}
+
+func TestSlowOpThreshold(t *testing.T) {
+	var logs bytes.Buffer
+	tree, err := NewMemoryTree(
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithSlowOpThreshold(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(intKey(1), intValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Read(intKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("expected nothing to be logged, got %q", logs.String())
+	}
+}
+
+func expectSlowOp(t *testing.T, logs *bytes.Buffer, expected string) {
+	t.Helper()
+	if !strings.Contains(logs.String(), expected) {
+		t.Fatalf("expected %q to be logged, got %q", expected, logs.String())
+	}
+}
@@ -0,0 +1,149 @@
+package bplus
+
+import (
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Snapshot is a read-only view of a tree as it was when the snapshot was taken. Changes
+// made to the tree afterwards aren't seen by the snapshot, however many there are, so a
+// reader can work from one consistent version of the tree while writers carry on.
+//
+// Snapshots rely on copy-on-write mode, see EnableCOW: a change never overwrites the
+// pages of the version before it, and the pages it replaces aren't reused until every
+// snapshot that can read them has been closed. A snapshot that is left open keeps those
+// pages from being reused, so the file grows with every change made while it's open.
+type Snapshot struct {
+	snap *store.Snapshot
+	root *branchPage
+	// values is the tree's value log, which keeps the segments the snapshot may read from
+	// until it's closed.
+	values *valueLog
+}
+
+// Snapshot takes a snapshot of the tree as of its last change. The tree must be in
+// copy-on-write mode. The snapshot must be closed once it's no longer needed.
+func (tree *Tree) Snapshot() (*Snapshot, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	snap, err := tree.store.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{snap: snap}
+	_, root, err := s.loadNode(tree.recordedRoot)
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	s.root = root
+	s.values = tree.values
+	s.values.pin()
+	return s, nil
+}
+
+// Len returns the number of records in the snapshot.
+func (s *Snapshot) Len() int {
+	return s.root.count()
+}
+
+// Read a value from the snapshot, return ErrKeyNotFound if it's not found.
+func (s *Snapshot) Read(key Key) (Value, error) {
+	if len(s.root.pointers) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	branch := s.root
+	for {
+		leaf, child, err := s.loadNode(branch.pointers[branch.childIndex(key)])
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			branch = child
+			continue
+		}
+		i, found := leaf.search(key)
+		if !found || leaf.records[i].tombstone {
+			return nil, ErrKeyNotFound
+		}
+		r, err := s.values.resolve(leaf.records[i])
+		return r.Value, err
+	}
+}
+
+// All returns a sequence of every key and value in the snapshot in ascending key order.
+func (s *Snapshot) All() *Iter {
+	return s.Ascend(nil)
+}
+
+// Ascend returns a sequence of the keys and values in the snapshot from the smallest key
+// greater than or equal to start, in ascending key order.
+func (s *Snapshot) Ascend(start Key) *Iter {
+	return NewIter(func(yield func(Key, Value) bool) error {
+		_, err := s.ascend(s.root, start, yield)
+		return err
+	})
+}
+
+// ascend yields the records in the subtree under branch from start onwards. It returns
+// false once yield asks to stop.
+func (s *Snapshot) ascend(
+	branch *branchPage,
+	start Key,
+	yield func(Key, Value) bool,
+) (bool, error) {
+	for i := branch.childIndex(start); i < len(branch.pointers); i++ {
+		leaf, child, err := s.loadNode(branch.pointers[i])
+		if err != nil {
+			return false, err
+		}
+		if child != nil {
+			more, err := s.ascend(child, start, yield)
+			if !more || err != nil {
+				return false, err
+			}
+			continue
+		}
+		j, _ := leaf.search(start)
+		for _, r := range leaf.records[j:] {
+			if r.tombstone {
+				continue
+			}
+			r, err := s.values.resolve(r)
+			if err != nil {
+				return false, err
+			}
+			if !yield(r.Key, r.Value) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// Close closes the snapshot, letting the pages only it could read be reused.
+func (s *Snapshot) Close() error {
+	err := s.snap.Close()
+	if err != nil {
+		return err
+	}
+	return s.values.unpin()
+}
+
+// loadNode is Tree.loadNode for the snapshot's version of the tree. Pages are released
+// straight away because a write to the tree may move them.
+func (s *Snapshot) loadNode(pageID store.PageID) (*leafPage, *branchPage, error) {
+	page, err := s.snap.Load(pageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var leaf *leafPage
+	var branch *branchPage
+	if isLeafPage(page) {
+		leaf = &leafPage{PageHandle: page}
+		leaf.fromBuffer()
+	} else {
+		branch = &branchPage{PageHandle: page}
+		branch.fromBuffer()
+	}
+	return leaf, branch, page.Release()
+}
@@ -0,0 +1,125 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestSnapshot(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Snapshot(); err != store.ErrSnapshotNeedsCOW {
+		t.Fatalf("expected %v == %v", err, store.ErrSnapshotNeedsCOW)
+	}
+	if err := tree.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := tree.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite the whole tree while the snapshot is open.
+	for i := 0; i < 100; i += 2 {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < 100; i += 2 {
+		if err := tree.Upsert(intKey(i), intValue(-i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 100; i < 200; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyTree(t, tree)
+
+	if empty.Len() != 0 {
+		t.Fatalf("expected %v == %v", empty.Len(), 0)
+	}
+	if _, err := empty.Read(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if snap.Len() != 100 {
+		t.Fatalf("expected %v == %v", snap.Len(), 100)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := snap.Read(intKey(i))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, intValue(i)) {
+			t.Fatalf("expected %v == %v", value, intValue(i))
+		}
+	}
+	if _, err := snap.Read(intKey(150)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	var keys []int
+	it := snap.Ascend(intKey(90))
+	for k, v := range it.Records() {
+		if !bytes.Equal(v, intValue(keyInt(k))) {
+			t.Fatalf("expected %v == %v", v, intValue(keyInt(k)))
+		}
+		keys = append(keys, keyInt(k))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expectInts(t, keys, []int{90, 91, 92, 93, 94, 95, 96, 97, 98, 99})
+
+	if err := empty.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Close(); err != store.ErrSnapshotClosed {
+		t.Fatalf("expected %v == %v", err, store.ErrSnapshotClosed)
+	}
+	if _, err := snap.Read(intKey(1)); err != store.ErrSnapshotClosed {
+		t.Fatalf("expected %v == %v", err, store.ErrSnapshotClosed)
+	}
+	// The pages kept for the snapshots are reused once they're closed.
+	size := fileSize(t, tmpfile.Name())
+	for i := 0; i < 100; i += 2 {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fileSize(t, tmpfile.Name()) != size {
+		t.Fatalf("expected %v == %v", fileSize(t, tmpfile.Name()), size)
+	}
+	verifyTree(t, tree)
+}
+
+func fileSize(t *testing.T, filename string) int64 {
+	t.Helper()
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
@@ -0,0 +1,227 @@
+package bplus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// ErrStreamClosed is returned when using a value stream after it has been closed.
+var ErrStreamClosed = errors.New("value stream closed")
+
+// ReadStream returns a reader over the value of key, or ErrKeyNotFound if it's not found.
+// A value kept in the value log is read from it as the reader is read, rather than all at
+// once, so values of many megabytes can be read without holding them in memory. The value
+// is checked against its checksum once it's been read to the end. The reader sees the
+// value as it was when ReadStream was called, even if it's overwritten or the value log
+// is collected, and must be closed.
+func (tree *Tree) ReadStream(key Key) (io.ReadCloser, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	r, err := tree.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if !r.pointer {
+		return io.NopCloser(bytes.NewReader(r.Value)), nil
+	}
+	if tree.values == nil {
+		return nil, ErrValueLogCorrupt
+	}
+	return tree.values.openReader(r.Key, valuePointerFromBuffer(r.Value))
+}
+
+// WriteStream returns a writer that stores everything written to it as the value of key
+// once it's closed, overwriting the value if the key is already present. The value is
+// written straight to a segment of the value log of its own as it's written, rather than
+// being held in memory, so the tree must have a value log, see WithValueLog. Nothing is
+// stored if the writer fails, and the part of the value written so far is reclaimed by
+// CollectValueLog.
+func (tree *Tree) WriteStream(key Key) (io.WriteCloser, error) {
+	if len(key) > MaxKeySize {
+		return nil, ErrKeyTooLarge
+	}
+	if tree.store.ReadOnly() {
+		return nil, store.ErrReadOnly
+	}
+	if tree.values == nil {
+		return nil, ErrNoValueLog
+	}
+	return tree.values.createWriter(tree, key)
+}
+
+// valueReader reads a value from the value log, see ReadStream.
+type valueReader struct {
+	log     *valueLog
+	pointer valuePointer
+	section *io.SectionReader
+	crc     hash.Hash32
+	sum     uint32
+	closed  bool
+}
+
+// openReader opens a reader over the value of key that p points at, which keeps the
+// segment it's in from being removed until it's closed.
+func (l *valueLog) openReader(key Key, p valuePointer) (*valueReader, error) {
+	l.lock.Lock()
+	f := l.segments[p.segment]
+	l.lock.Unlock()
+	if f == nil {
+		return nil, p.readError(ErrValueLogCorrupt)
+	}
+	header := make([]byte, valueEntryHeaderSize+len(key))
+	_, err := f.ReadAt(header, int64(p.offset))
+	if err == io.EOF {
+		return nil, p.readError(ErrValueLogCorrupt)
+	}
+	if err != nil {
+		return nil, p.readError(err)
+	}
+	valid := binary.LittleEndian.Uint32(header[4:8]) == uint32(len(key)) &&
+		binary.LittleEndian.Uint32(header[8:12]) == p.length &&
+		bytes.Equal(header[valueEntryHeaderSize:], key)
+	if !valid {
+		l.logCorrupt(p)
+		return nil, p.readError(ErrValueLogCorrupt)
+	}
+	r := &valueReader{
+		log:     l,
+		pointer: p,
+		section: io.NewSectionReader(f, int64(p.offset)+int64(len(header)), int64(p.length)),
+		crc:     crc32.New(castagnoli),
+		sum:     binary.LittleEndian.Uint32(header[0:4]),
+	}
+	r.crc.Write(header[4:])
+	l.pin()
+	return r, nil
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, ErrStreamClosed
+	}
+	n, err := r.section.Read(p)
+	r.crc.Write(p[:n])
+	if err == io.EOF && r.crc.Sum32() != r.sum {
+		r.log.logCorrupt(r.pointer)
+		return n, r.pointer.readError(ErrValueLogCorrupt)
+	}
+	return n, err
+}
+
+func (r *valueReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.log.unpin()
+}
+
+// valueWriter writes a value to a segment of the value log of its own, see WriteStream.
+// The entry's header is left as zeros until the writer is closed, so a value that was
+// never finished doesn't match its checksum.
+type valueWriter struct {
+	tree    *Tree
+	key     Key
+	segment uint32
+	f       *os.File
+	w       *bufio.Writer
+	length  int64
+	err     error
+	closed  bool
+}
+
+// createWriter creates the segment a value stream writes to, which isn't collected while
+// the stream is open.
+func (l *valueLog) createWriter(tree *Tree, key Key) (*valueWriter, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	segment, f, err := l.createSegment()
+	if err != nil {
+		return nil, err
+	}
+	l.writing[segment] = true
+	w := &valueWriter{
+		tree:    tree,
+		key:     append(Key{}, key...),
+		segment: segment,
+		f:       f,
+		w:       bufio.NewWriter(f),
+	}
+	w.w.Write(make([]byte, valueEntryHeaderSize))
+	w.w.Write(key)
+	return w, nil
+}
+
+func (w *valueWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrStreamClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.length+int64(len(p)) >= valuePointerLength {
+		w.err = ErrRecordTooLarge
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.length += int64(n)
+	w.err = err
+	return n, err
+}
+
+// Close finishes writing the value and stores it as the value of the writer's key.
+func (w *valueWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	log := w.tree.values
+	err := w.finish()
+	log.lock.Lock()
+	delete(log.writing, w.segment)
+	log.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	var b WriteBatch
+	p := valuePointer{segment: w.segment, length: uint32(w.length)}
+	b.ops = append(b.ops, batchOp{key: w.key, value: p.toBuffer(), pointer: true})
+	return w.tree.Apply(&b)
+}
+
+// finish fills in the header of the value's entry and syncs its segment.
+func (w *valueWriter) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, valueEntryHeaderSize)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(w.key)))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(w.length))
+	// The checksum covers the lengths, which aren't known until now, so the value is
+	// read back rather than held on to.
+	crc := crc32.New(castagnoli)
+	crc.Write(header[4:])
+	entry := io.NewSectionReader(w.f, valueEntryHeaderSize, int64(len(w.key))+w.length)
+	_, err = io.Copy(crc, entry)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(header[0:4], crc.Sum32())
+	_, err = w.f.WriteAt(header, 0)
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
@@ -0,0 +1,228 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestValueStreams(t *testing.T) {
+	filename := tempFilename(t, "value_streams")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := largeValue(7, 3<<20)
+	w, err := tree.WriteStream(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written a little at a time, as a value too large to hold in memory would be.
+	for i := 0; i < len(expected); i += 100000 {
+		chunk := expected[i:min(i+100000, len(expected))]
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Nothing is stored until the writer is closed.
+	if _, err := tree.Read(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("more")); err != ErrStreamClosed {
+		t.Fatalf("expected %v == %v", err, ErrStreamClosed)
+	}
+	expectStream(t, tree, intKey(1), expected)
+	value, err := tree.Read(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, expected) {
+		t.Fatalf("expected %v == %v", len(value), len(expected))
+	}
+
+	// Values written any other way can be streamed too.
+	if err := tree.Insert(intKey(2), largeValue(2, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(3), largeValue(3, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	expectStream(t, tree, intKey(2), largeValue(2, 16))
+	expectStream(t, tree, intKey(3), largeValue(3, 5000))
+	if _, err := tree.ReadStream(intKey(4)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = OpenTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	expectStream(t, tree, intKey(1), expected)
+}
+
+func TestValueStreamOverwrite(t *testing.T) {
+	filename := tempFilename(t, "value_stream_overwrite")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	writeStream(t, tree, intKey(1), largeValue(1, 100000))
+	r, err := tree.ReadStream(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reader keeps reading the value it was opened on after it's overwritten and its
+	// segment is collected.
+	writeStream(t, tree, intKey(1), largeValue(2, 100000))
+	collected, err := tree.CollectValueLog(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected == 0 {
+		t.Fatalf("expected segments to be collected")
+	}
+	value, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, largeValue(1, 100000)) {
+		t.Fatalf("expected %v == %v", len(value), 100000)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectStream(t, tree, intKey(1), largeValue(2, 100000))
+}
+
+func TestUnfinishedValueStream(t *testing.T) {
+	filename := tempFilename(t, "unfinished_value_stream")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tree.WriteStream(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(largeValue(1, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	// A stream that's still being written isn't collected.
+	if _, err := tree.CollectValueLog(1); err != nil {
+		t.Fatal(err)
+	}
+	segments := valueLogSegments(t, filename)
+	if len(segments) != 2 {
+		t.Fatalf("expected %v == %v", len(segments), 2)
+	}
+	// The tree is closed before the stream is finished, as if the process crashed.
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatalf("expected closing the stream to fail")
+	}
+
+	tree, err = OpenTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if _, err := tree.Read(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	// The unfinished value is cut off the segment, which values are appended to from then
+	// on.
+	info, err := os.Stat(segments[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("expected %v == %v", info.Size(), 0)
+	}
+	writeStream(t, tree, intKey(2), largeValue(2, 1000))
+	expectStream(t, tree, intKey(2), largeValue(2, 1000))
+}
+
+func TestValueStreamErrors(t *testing.T) {
+	tree, err := NewMemoryTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if _, err := tree.WriteStream(intKey(1)); err != ErrNoValueLog {
+		t.Fatalf("expected %v == %v", err, ErrNoValueLog)
+	}
+	if _, err := tree.WriteStream(make(Key, MaxKeySize+1)); err != ErrKeyTooLarge {
+		t.Fatalf("expected %v == %v", err, ErrKeyTooLarge)
+	}
+
+	filename := tempFilename(t, "value_stream_corrupt")
+	defer removeValueLog(filename)
+	logged, err := NewTree(filename, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logged.Close()
+	writeStream(t, logged, intKey(1), largeValue(1, 10000))
+	segments := valueLogSegments(t, filename)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, 5000); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	r, err := logged.ReadStream(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrValueLogCorrupt) {
+		t.Fatalf("expected %v == %v", err, ErrValueLogCorrupt)
+	}
+}
+
+func writeStream(t *testing.T, tree *Tree, key Key, value Value) {
+	t.Helper()
+	w, err := tree.WriteStream(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(value); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectStream(t *testing.T, tree *Tree, key Key, expected Value) {
+	t.Helper()
+	r, err := tree.ReadStream(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	value, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, expected) {
+		t.Fatalf("expected %v == %v", len(value), len(expected))
+	}
+}
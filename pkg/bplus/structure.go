@@ -0,0 +1,115 @@
+package bplus
+
+import "github.com/jpittis/bplus/pkg/store"
+
+// TreeNode is a page of a tree as Structure finds it, with the pages below it.
+type TreeNode struct {
+	ID store.PageID
+	// Depth is the number of levels the page is below the root.
+	Depth int
+	Leaf  bool
+	// Entries is the number of records in a leaf, counting deleted records that are
+	// waiting to be removed, or the number of keys in a branch, and MaxEntries is the most
+	// the page can hold, which is one less than the branching factor.
+	Entries    int
+	MaxEntries int
+	// Bytes is the space the page's entries take up, and Capacity is the space there is
+	// for them.
+	Bytes    int
+	Capacity int
+	// Records is the number of records in the subtree under the page, and ValuePointers is
+	// how many of them have their values kept in the value log, see WithValueLog.
+	Records       int
+	ValuePointers int
+	// First and Last are the smallest and largest keys in the subtree under the page, or
+	// nil if it has no records.
+	First Key
+	Last  Key
+	// Children are the pages the pointers of a branch point at, in order.
+	Children []*TreeNode
+}
+
+// Occupancy returns how full the page is, from 0 for an empty page to 1 for a page that
+// can't take another entry, by whichever of its number of entries or the space they take
+// up is nearer its limit.
+func (n *TreeNode) Occupancy() float64 {
+	return max(
+		float64(n.Entries)/float64(n.MaxEntries),
+		float64(n.Bytes)/float64(n.Capacity),
+	)
+}
+
+// Structure reads every page of the tree, from the root down, and returns the root's
+// node, for seeing how records are spread over the pages. The whole tree is held in
+// memory, a node for every page, and the tree is locked while it's read.
+func (tree *Tree) Structure() (*TreeNode, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.branchNode(tree.root, 0)
+}
+
+func (tree *Tree) branchNode(branch *branchPage, depth int) (*TreeNode, error) {
+	n := &TreeNode{
+		ID:         branch.ID,
+		Depth:      depth,
+		Entries:    len(branch.keys),
+		MaxEntries: tree.branchingFactor - 1,
+		Bytes:      branch.size(),
+		Capacity:   store.UsablePageSize - tree.trailerSize(),
+	}
+	for _, pointer := range branch.pointers {
+		leaf, child, err := tree.loadNode(pointer)
+		if err != nil {
+			return nil, err
+		}
+		var c *TreeNode
+		if leaf != nil {
+			c = tree.leafNode(leaf, depth+1)
+		} else {
+			c, err = tree.branchNode(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		n.Children = append(n.Children, c)
+		n.Records += c.Records
+		n.ValuePointers += c.ValuePointers
+		if c.First != nil && n.First == nil {
+			n.First = c.First
+		}
+		if c.Last != nil {
+			n.Last = c.Last
+		}
+	}
+	return n, nil
+}
+
+func (tree *Tree) leafNode(leaf *leafPage, depth int) *TreeNode {
+	n := &TreeNode{
+		ID:         leaf.ID,
+		Depth:      depth,
+		Leaf:       true,
+		Entries:    len(leaf.records),
+		MaxEntries: tree.branchingFactor - 1,
+		Bytes:      leaf.size(),
+		Capacity:   store.UsablePageSize - tree.trailerSize(),
+		Records:    liveCount(leaf.records),
+	}
+	// The keys are copied so that the copy of the page they're sliced out of isn't kept.
+	for _, r := range leaf.records {
+		if r.tombstone {
+			continue
+		}
+		if r.pointer {
+			n.ValuePointers++
+		}
+		if n.First == nil {
+			n.First = append(Key{}, r.Key...)
+		}
+		n.Last = r.Key
+	}
+	if n.Last != nil {
+		n.Last = append(Key{}, n.Last...)
+	}
+	return n
+}
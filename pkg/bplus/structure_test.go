@@ -0,0 +1,95 @@
+package bplus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStructure(t *testing.T) {
+	tree, err := newTree("structure", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	root, err := tree.Structure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty tree is only its root.
+	if len(root.Children) != 0 || root.Records != 0 || root.First != nil {
+		t.Fatalf("expected an empty root, got %+v", root)
+	}
+
+	for i := 0; i < 500; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := verifyTree(t, tree)
+	root, err = tree.Structure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Records != 500 {
+		t.Fatalf("expected %v == %v", root.Records, 500)
+	}
+	if !bytes.Equal(root.First, intKey(0)) || !bytes.Equal(root.Last, intKey(499)) {
+		t.Fatalf("expected %x %x == %x %x", root.First, root.Last, intKey(0), intKey(499))
+	}
+	pages := 0
+	var walk func(n *TreeNode)
+	walk = func(n *TreeNode) {
+		pages++
+		if n.Leaf != (n.Depth == report.Depth) {
+			t.Fatalf("expected only the pages at depth %v to be leaves, got %+v", report.Depth, n)
+		}
+		if o := n.Occupancy(); o <= 0 || o > 1 {
+			t.Fatalf("expected an occupancy in (0, 1], got %v for %+v", o, n)
+		}
+		records := 0
+		for i, c := range n.Children {
+			if c.Depth != n.Depth+1 {
+				t.Fatalf("expected %v == %v", c.Depth, n.Depth+1)
+			}
+			if i > 0 && bytes.Compare(n.Children[i-1].Last, c.First) >= 0 {
+				t.Fatalf("expected %x < %x", n.Children[i-1].Last, c.First)
+			}
+			records += c.Records
+			walk(c)
+		}
+		if !n.Leaf && records != n.Records {
+			t.Fatalf("expected %v == %v", records, n.Records)
+		}
+	}
+	walk(root)
+	if pages != report.Pages {
+		t.Fatalf("expected %v == %v", pages, report.Pages)
+	}
+}
+
+func TestStructureValuePointers(t *testing.T) {
+	filename := tempFilename(t, "structure_value_pointers")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithBranchingFactor(8), WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	// Every other value is too large to be kept in its leaf.
+	for i := 0; i < 100; i++ {
+		size := 16
+		if i%2 == 1 {
+			size = 100
+		}
+		if err := tree.Insert(intKey(i), largeValue(i, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := tree.Structure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Records != 100 || root.ValuePointers != 50 {
+		t.Fatalf("expected 100 records and 50 value pointers, got %+v", root)
+	}
+}
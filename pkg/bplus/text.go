@@ -0,0 +1,168 @@
+package bplus
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// textBatch is the most records an import writes at once.
+const textBatch = 1000
+
+// ErrInvalidText is returned when exporting a key or value with RawText that isn't valid
+// UTF-8, which would be mangled on the way out.
+var ErrInvalidText = errors.New("key or value is not valid UTF-8")
+
+// TextCodec converts the keys or values of a tree to and from the text they're written
+// as by the CSV and JSON lines exports, see ExportCSV and ExportJSONL.
+type TextCodec interface {
+	EncodeText([]byte) (string, error)
+	DecodeText(string) ([]byte, error)
+}
+
+var (
+	// RawText writes bytes as they are, which suits keys and values that are already text.
+	// Bytes that aren't valid UTF-8 can't be written.
+	RawText TextCodec = rawText{}
+	// HexText writes bytes in lowercase hexadecimal.
+	HexText TextCodec = hexText{}
+	// Base64Text writes bytes in standard, padded base64.
+	Base64Text TextCodec = base64Text{}
+)
+
+// TextFormat says how keys and values are written as text. A nil codec is RawText.
+type TextFormat struct {
+	Keys   TextCodec
+	Values TextCodec
+}
+
+func (f TextFormat) codecs() (TextCodec, TextCodec) {
+	keys, values := f.Keys, f.Values
+	if keys == nil {
+		keys = RawText
+	}
+	if values == nil {
+		values = RawText
+	}
+	return keys, values
+}
+
+// TextError records an error hit importing a record of a CSV or JSON lines file, along
+// with the line the record is on, such as "line 12: encoding/hex: invalid byte: U+0067
+// 'g'". The error it wraps can be checked for with errors.Is.
+type TextError struct {
+	Line int
+	Err  error
+}
+
+func (e *TextError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *TextError) Unwrap() error {
+	return e.Err
+}
+
+type rawText struct{}
+
+func (rawText) EncodeText(b []byte) (string, error) {
+	if !utf8.Valid(b) {
+		return "", ErrInvalidText
+	}
+	return string(b), nil
+}
+
+func (rawText) DecodeText(s string) ([]byte, error) {
+	return []byte(s), nil
+}
+
+type hexText struct{}
+
+func (hexText) EncodeText(b []byte) (string, error) {
+	return hex.EncodeToString(b), nil
+}
+
+func (hexText) DecodeText(s string) ([]byte, error) {
+	return hex.DecodeString(s)
+}
+
+type base64Text struct{}
+
+func (base64Text) EncodeText(b []byte) (string, error) {
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (base64Text) DecodeText(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// importText writes the records read by next to the tree in batches, and returns how
+// many it wrote. next returns the line the record it read is on, and io.EOF once there
+// are no more.
+func (tree *Tree) importText(
+	f TextFormat, next func() (line int, key, value string, err error),
+) (int, error) {
+	keys, values := f.codecs()
+	var b WriteBatch
+	n := 0
+	for {
+		line, key, value, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		k, err := keys.DecodeText(key)
+		if err != nil {
+			return n, &TextError{Line: line, Err: err}
+		}
+		v, err := values.DecodeText(value)
+		if err != nil {
+			return n, &TextError{Line: line, Err: err}
+		}
+		b.Put(k, v)
+		if b.Len() == textBatch {
+			if err := tree.Apply(&b); err != nil {
+				return n, err
+			}
+			n += b.Len()
+			b.Reset()
+		}
+	}
+	if b.Len() > 0 {
+		if err := tree.Apply(&b); err != nil {
+			return n, err
+		}
+		n += b.Len()
+	}
+	return n, nil
+}
+
+// exportText calls write with the text of every record in the tree, in order, and
+// returns how many it wrote.
+func (tree *Tree) exportText(
+	f TextFormat, write func(key, value string) error,
+) (int, error) {
+	keys, values := f.codecs()
+	n := 0
+	it := tree.All()
+	for k, v := range it.Records() {
+		key, err := keys.EncodeText(k)
+		if err != nil {
+			return n, err
+		}
+		value, err := values.EncodeText(v)
+		if err != nil {
+			return n, err
+		}
+		if err := write(key, value); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, it.Err()
+}
@@ -0,0 +1,101 @@
+package bplus
+
+import (
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// lazyDeleteFlag is set in the file header of trees that delete records lazily.
+const lazyDeleteFlag = 2
+
+// tombstoneLength is stored in place of the length of a value to mark a tombstone. No
+// value can be this long because it wouldn't fit in a page.
+const tombstoneLength = 0xFFFFFFFF
+
+// SetLazyDelete turns lazy deletion on or off. With lazy deletion on, Delete replaces the
+// record with a tombstone rather than removing it from its leaf, which saves merging and
+// rebalancing pages on every delete at the cost of the space the tombstones take up
+// until Compact is called. Tombstones are never seen by readers and are replaced by any
+// write to their key. The setting is stored in the file, so it survives reopening the
+// tree.
+func (tree *Tree) SetLazyDelete(enabled bool) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	flags := tree.flags &^ lazyDeleteFlag
+	if enabled {
+		flags |= lazyDeleteFlag
+	}
+	return tree.setFlags(flags)
+}
+
+// LazyDelete returns whether Delete writes tombstones rather than removing records.
+func (tree *Tree) LazyDelete() bool {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.flags&lazyDeleteFlag != 0
+}
+
+// Compact removes every tombstone from the tree, rebalancing the pages they leave under
+// full, and returns the number of tombstones removed.
+func (tree *Tree) Compact() (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if len(tree.root.pointers) == 0 {
+		return 0, nil
+	}
+	var batch WriteBatch
+	for _, pointer := range tree.root.pointers {
+		err := tree.collectTombstones(pointer, &batch)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	return batch.Len(), tree.apply(&batch)
+}
+
+// collectTombstones adds a delete to the batch for every tombstone in the subtree rooted
+// at pageID.
+func (tree *Tree) collectTombstones(pageID store.PageID, batch *WriteBatch) error {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return err
+	}
+	if leaf != nil {
+		for _, r := range leaf.records {
+			if r.tombstone {
+				batch.Delete(r.Key)
+			}
+		}
+		return nil
+	}
+	for _, pointer := range branch.pointers {
+		err = tree.collectTombstones(pointer, batch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLazily replaces the record of key with a tombstone.
+func (tree *Tree) deleteLazily(key Key) error {
+	return tree.put(key, putRemoves, func(old Value, found bool) (Value, error) {
+		if !found {
+			return nil, ErrKeyNotFound
+		}
+		return nil, errWriteTombstone
+	})
+}
+
+// liveCount returns the number of records that aren't tombstones.
+func liveCount(records []Record) int {
+	count := 0
+	for _, r := range records {
+		if !r.tombstone {
+			count++
+		}
+	}
+	return count
+}
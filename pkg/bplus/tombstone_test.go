@@ -0,0 +1,222 @@
+package bplus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestLazyDelete(t *testing.T) {
+	tree, err := newTree("lazy_delete", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetLazyDelete(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(500) {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Delete every odd key, so that there are tombstones between every live record.
+	for i := 1; i < 500; i += 2 {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if err := tree.Delete(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if tree.Len() != 250 {
+		t.Fatalf("expected %v == %v", tree.Len(), 250)
+	}
+	for i := 0; i < 500; i++ {
+		value, err := tree.Read(intKey(i))
+		if i%2 == 1 {
+			if err != ErrKeyNotFound {
+				t.Fatalf("found deleted value %+v for %d", value, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	var keys []int
+	for key := range tree.All().Records() {
+		keys = append(keys, keyInt(key))
+	}
+	var expected []int
+	for i := 0; i < 500; i += 2 {
+		expected = append(expected, i)
+	}
+	expectInts(t, keys, expected)
+	keys = keys[:0]
+	for key := range tree.Descend(intKey(499)).Records() {
+		keys = append(keys, keyInt(key))
+		if len(keys) == 2 {
+			break
+		}
+	}
+	expectInts(t, keys, []int{498, 496})
+	floor, err := tree.Floor(intKey(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyInt(floor.Key) != 6 {
+		t.Fatalf("expected %v == %v", keyInt(floor.Key), 6)
+	}
+	ceiling, err := tree.Ceiling(intKey(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyInt(ceiling.Key) != 8 {
+		t.Fatalf("expected %v == %v", keyInt(ceiling.Key), 8)
+	}
+	max, err := tree.Max()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyInt(max.Key) != 498 {
+		t.Fatalf("expected %v == %v", keyInt(max.Key), 498)
+	}
+	n, err := tree.CountRange(intKey(0), intKey(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 50 {
+		t.Fatalf("expected %v == %v", n, 50)
+	}
+	// Writing to a deleted key replaces its tombstone.
+	if err := tree.Insert(intKey(1), intValue(10)); err != nil {
+		t.Fatal(err)
+	}
+	value, err := tree.Read(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(10)) {
+		t.Fatalf("expected %v == %v", value, intValue(10))
+	}
+	if tree.Len() != 251 {
+		t.Fatalf("expected %v == %v", tree.Len(), 251)
+	}
+	verifyTree(t, tree)
+}
+
+func TestCompact(t *testing.T) {
+	tree, err := newTree("compact", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := tree.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected %v == %v", n, 0)
+	}
+	if err := tree.SetLazyDelete(true); err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(1000) {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted := map[int]bool{}
+	for _, i := range r.Perm(1000)[:800] {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(i, err)
+		}
+		deleted[i] = true
+	}
+	before := verifyTree(t, tree)
+	n, err = tree.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 800 {
+		t.Fatalf("expected %v == %v", n, 800)
+	}
+	after := verifyTree(t, tree)
+	if after.Pages >= before.Pages {
+		t.Fatalf("expected %v < %v", after.Pages, before.Pages)
+	}
+	if tree.Len() != 200 {
+		t.Fatalf("expected %v == %v", tree.Len(), 200)
+	}
+	for i := 0; i < 1000; i++ {
+		_, err := tree.Read(intKey(i))
+		if deleted[i] != (err == ErrKeyNotFound) {
+			t.Fatal(i, err)
+		}
+	}
+	n, err = tree.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected %v == %v", n, 0)
+	}
+}
+
+func TestLazyDeleteSurvivesReopen(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "lazy_delete_survives_reopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetLazyDelete(true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, tree)
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.LazyDelete() {
+		t.Fatal("expected lazy deletion to be on")
+	}
+	if reopened.Len() != 50 {
+		t.Fatalf("expected %v == %v", reopened.Len(), 50)
+	}
+	if _, err := reopened.Read(intKey(0)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := reopened.SetLazyDelete(false); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting physically removes a record whether or not there are tombstones around it.
+	if err := reopened.Delete(intKey(50)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Delete(intKey(0)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	verifyTree(t, reopened)
+}
@@ -0,0 +1,114 @@
+package bplus
+
+import (
+	"time"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// TracerProvider provides the tracer a tree traces its operations with, see
+// WithTracerProvider. It's shaped after the TracerProvider of OpenTelemetry, which can be
+// plugged in with a small adapter, without the package depending on it.
+type TracerProvider interface {
+	Tracer(name string) Tracer
+}
+
+// Tracer starts a span for each operation traced.
+type Tracer interface {
+	Start(name string) Span
+}
+
+// Span is an operation being traced.
+type Span interface {
+	// SetAttribute records something about the operation, such as the pages it touched.
+	SetAttribute(key string, value int64)
+	// End ends the span once the operation is done, with the error it returned if any.
+	End(err error)
+}
+
+// TracerName is the name trees ask their TracerProvider for a tracer by.
+const TracerName = "github.com/jpittis/bplus/pkg/bplus"
+
+// Attributes set on every span. The pages loaded and the cache misses are counted by the
+// tree's page store as a whole while the span is open, so they include the pages loaded
+// by operations running at the same time.
+const (
+	// PagesLoadedAttribute is the number of pages loaded from the cache or the file.
+	PagesLoadedAttribute = "bplus.pages_loaded"
+	// CacheMissesAttribute is the number of pages that had to be read from the file.
+	CacheMissesAttribute = "bplus.cache_misses"
+	// RecordsAttribute is the number of records a scan went through.
+	RecordsAttribute = "bplus.records"
+)
+
+// tracer returns the tracer from the options' TracerProvider, or nil if there isn't one.
+func (o options) tracer() Tracer {
+	if o.tracerProvider == nil {
+		return nil
+	}
+	return o.tracerProvider.Tracer(TracerName)
+}
+
+// span times an operation for its Span, if the tree is traced, and for the slow
+// operation log, see slow.go. It holds what the cache had done and how long the tree's
+// lock had been waited on when it started.
+type span struct {
+	tree       *Tree
+	span       Span
+	name       string
+	begun      time.Time
+	start      store.CacheStats
+	lockWait   int64
+	attributes []attribute
+}
+
+type attribute struct {
+	key   string
+	value int64
+}
+
+// startSpan starts timing an operation, or returns nil without doing anything else if
+// the tree isn't traced and doesn't log slow operations.
+func (tree *Tree) startSpan(name string) *span {
+	if !tree.timed() {
+		return nil
+	}
+	s := &span{
+		tree:     tree,
+		name:     name,
+		begun:    time.Now(),
+		start:    tree.store.CacheStats(),
+		lockWait: tree.lockWait.Load(),
+	}
+	if tree.tracer != nil {
+		s.span = tree.tracer.Start(name)
+	}
+	return s
+}
+
+// end ends the span with the error the operation returned, and does nothing if the
+// operation isn't timed.
+func (s *span) end(err *error) {
+	if s == nil {
+		return
+	}
+	stats := s.tree.store.CacheStats()
+	if s.span != nil {
+		loaded := stats.Hits + stats.Misses - s.start.Hits - s.start.Misses
+		s.span.SetAttribute(PagesLoadedAttribute, int64(loaded))
+		s.span.SetAttribute(CacheMissesAttribute, int64(stats.Misses-s.start.Misses))
+		s.span.End(*err)
+	}
+	s.logSlow(stats, *err)
+}
+
+// setAttribute records something about the operation if it's timed.
+func (s *span) setAttribute(key string, value int64) {
+	if s == nil {
+		return
+	}
+	s.attributes = append(s.attributes, attribute{key, value})
+	if s.span != nil {
+		s.span.SetAttribute(key, value)
+	}
+}
@@ -0,0 +1,177 @@
+package bplus
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordingTracer records the spans it starts, and is its own TracerProvider.
+type recordingTracer struct {
+	lock  sync.Mutex
+	name  string
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name       string
+	attributes map[string]int64
+	err        error
+	ended      bool
+}
+
+func (t *recordingTracer) Tracer(name string) Tracer {
+	t.name = name
+	return t
+}
+
+func (t *recordingTracer) Start(name string) Span {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s := &recordedSpan{name: name, attributes: map[string]int64{}}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value int64) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+// take returns the spans started since it was last called.
+func (t *recordingTracer) take() []*recordedSpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	spans := t.spans
+	t.spans = nil
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	filename := tempFilename(t, "tracing")
+	tree, err := NewTree(filename, WithBranchingFactor(4), WithTracerProvider(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if tracer.name != TracerName {
+		t.Fatalf("expected %v == %v", tracer.name, TracerName)
+	}
+	for i := 0; i < 50; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spans := tracer.take()
+	// Every insert commits its writes inside its own span.
+	if len(spans) != 100 {
+		t.Fatalf("expected %v == %v", len(spans), 100)
+	}
+	expectSpan(t, spans[0], "bplus.Insert", nil)
+	expectSpan(t, spans[1], "bplus.commit", nil)
+	if spans[0].attributes[PagesLoadedAttribute] == 0 {
+		t.Fatalf("expected the insert to load pages, got %v", spans[0].attributes)
+	}
+
+	if _, err := tree.Read(intKey(7)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Read(intKey(100)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	spans = tracer.take()
+	expectSpans(t, spans, 2)
+	expectSpan(t, spans[0], "bplus.Read", nil)
+	expectSpan(t, spans[1], "bplus.Read", ErrKeyNotFound)
+	if _, ok := spans[0].attributes[CacheMissesAttribute]; !ok {
+		t.Fatalf("expected the cache misses to be recorded, got %v", spans[0].attributes)
+	}
+
+	for range tree.Ascend(intKey(40)).Records() {
+	}
+	spans = tracer.take()
+	expectSpans(t, spans, 1)
+	expectSpan(t, spans[0], "bplus.Scan", nil)
+	if spans[0].attributes[RecordsAttribute] != 10 {
+		t.Fatalf("expected %v == %v", spans[0].attributes[RecordsAttribute], 10)
+	}
+
+	if err := tree.Delete(intKey(100)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	spans = tracer.take()
+	expectSpans(t, spans, 3)
+	expectSpan(t, spans[0], "bplus.Delete", ErrKeyNotFound)
+	expectSpan(t, spans[1], "bplus.commit", nil)
+	expectSpan(t, spans[2], "bplus.flush", nil)
+}
+
+func TestTracingDB(t *testing.T) {
+	tracer := &recordingTracer{}
+	filename := tempFilename(t, "tracing_db")
+	db, err := NewDB(filename, WithTracerProvider(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tree, err := db.CreateTree("traced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.take()
+	// The trees of a database are traced like the database.
+	if _, err := tree.Read(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	spans := tracer.take()
+	expectSpans(t, spans, 1)
+	expectSpan(t, spans[0], "bplus.Read", ErrKeyNotFound)
+}
+
+func BenchmarkUntracedRead(b *testing.B) {
+	tree, err := NewMemoryTree()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(intKey(1), intValue(1)); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.Read(intKey(1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func expectSpans(t *testing.T, spans []*recordedSpan, n int) {
+	t.Helper()
+	if len(spans) != n {
+		names := make([]string, len(spans))
+		for i, s := range spans {
+			names[i] = s.name
+		}
+		t.Fatalf("expected %v spans, got %v", n, names)
+	}
+}
+
+func expectSpan(t *testing.T, s *recordedSpan, name string, err error) {
+	t.Helper()
+	if s.name != name {
+		t.Fatalf("expected %v == %v", s.name, name)
+	}
+	if !s.ended {
+		t.Fatalf("expected span %v to be ended", s.name)
+	}
+	if s.err != err {
+		t.Fatalf("expected %v == %v", s.err, err)
+	}
+}
@@ -0,0 +1,333 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+var (
+	// ErrTxDone is returned when using a transaction after it has been committed or rolled
+	// back.
+	ErrTxDone = errors.New("transaction has already been committed or rolled back")
+	// ErrTxReadOnly is returned when writing in a read-only transaction.
+	ErrTxReadOnly = errors.New("transaction is read-only")
+	// ErrTxConflict is returned when committing a transaction that read something from
+	// the tree which has changed since.
+	ErrTxConflict = errors.New("transaction conflicts with a change to the tree")
+)
+
+// Tx is a transaction started with Tree.Begin. The writes made in a writable transaction
+// are held in memory, where they are seen by the transaction's own reads but not by the
+// tree, until Commit applies them to the tree all together. Rollback throws them away.
+//
+// Reads that aren't answered by the transaction's own writes go to the tree, and a key
+// read more than once is seen with the value it had the first time. A writable
+// transaction remembers what it read from the tree, and Commit checks that none of it has
+// changed before applying the writes, with the tree locked so that nothing can change in
+// between. Otherwise Commit returns ErrTxConflict and the writes are thrown away, so two
+// transactions that read and then write the same key can't both commit. A read-only
+// transaction may see changes made to the tree while it's open.
+type Tx struct {
+	tree     *Tree
+	writable bool
+	done     bool
+	// writes holds the latest put or delete of every key written in the transaction.
+	writes map[string]batchOp
+	// reads holds every key the transaction read from the tree, and scans every range it
+	// scanned, along with what it saw.
+	reads map[string]txRead
+	scans []txScan
+}
+
+// txRead is the value a transaction read for a key, or not found if it wasn't in the
+// tree.
+type txRead struct {
+	value Value
+	found bool
+}
+
+// txScan is a range of keys [start, end) a transaction scanned in the tree, and the
+// records it saw in it. A nil end is the end of the tree.
+type txScan struct {
+	start, end Key
+	records    []Record
+}
+
+// Begin starts a transaction on the tree. Only a writable transaction can Put and Delete.
+func (tree *Tree) Begin(writable bool) *Tx {
+	return &Tx{
+		tree:     tree,
+		writable: writable,
+		writes:   map[string]batchOp{},
+		reads:    map[string]txRead{},
+	}
+}
+
+// Writable returns whether the transaction can write to the tree.
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Get returns the value of key as seen by the transaction, or ErrKeyNotFound if it's not
+// found.
+func (tx *Tx) Get(key Key) (Value, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if op, ok := tx.writes[string(key)]; ok {
+		if op.delete {
+			return nil, ErrKeyNotFound
+		}
+		return op.value, nil
+	}
+	if read, ok := tx.reads[string(key)]; ok {
+		if !read.found {
+			return nil, ErrKeyNotFound
+		}
+		return read.value, nil
+	}
+	value, err := tx.tree.Read(key)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	tx.reads[string(key)] = txRead{value: value, found: err == nil}
+	return value, err
+}
+
+// Put inserts a key value pair, overwriting the value if the key is already present. The
+// key and value are copied so the caller is free to reuse them.
+func (tx *Tx) Put(key Key, value Value) error {
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	if !tx.tree.fits(Record{Key: key, Value: value}) {
+		return ErrRecordTooLarge
+	}
+	tx.writes[string(key)] = batchOp{
+		key:   append(Key{}, key...),
+		value: append(Value{}, value...),
+	}
+	return nil
+}
+
+// Delete a key value pair, return ErrKeyNotFound if the transaction doesn't see it.
+func (tx *Tx) Delete(key Key) error {
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Get(key)
+	if err != nil {
+		return err
+	}
+	tx.writes[string(key)] = batchOp{key: append(Key{}, key...), delete: true}
+	return nil
+}
+
+// Scan returns a sequence of the keys and values with a key in [start, end) as seen by
+// the transaction, in ascending key order. A nil end scans to the end of the tree.
+func (tx *Tx) Scan(start, end Key) *Iter {
+	return NewIter(func(yield func(Key, Value) bool) error {
+		if tx.done {
+			return ErrTxDone
+		}
+		inRange := func(key Key) bool {
+			if end != nil && bytes.Compare(key, end) >= 0 {
+				return false
+			}
+			return bytes.Compare(key, start) >= 0
+		}
+		var writes []batchOp
+		for _, op := range tx.writes {
+			if inRange(op.key) {
+				writes = append(writes, op)
+			}
+		}
+		sort.Slice(writes, func(i, j int) bool {
+			return bytes.Compare(writes[i].key, writes[j].key) < 0
+		})
+		// yieldWrites yields the writes with keys less than key, or every write left if
+		// key is nil, and reports whether the caller wants more.
+		yieldWrites := func(key Key) bool {
+			for len(writes) > 0 && (key == nil || bytes.Compare(writes[0].key, key) < 0) {
+				op := writes[0]
+				writes = writes[1:]
+				if !op.delete && !yield(op.key, op.value) {
+					return false
+				}
+			}
+			return true
+		}
+		// The part of the range seen in the tree is remembered, up to the last key read
+		// from it if the scan was stopped early.
+		scan := txScan{start: append(Key{}, start...)}
+		complete := false
+		defer func() {
+			if !tx.writable {
+				return
+			}
+			if complete {
+				scan.end = end
+			} else if len(scan.records) > 0 {
+				last := scan.records[len(scan.records)-1].Key
+				scan.end = append(append(Key{}, last...), 0)
+			} else {
+				return
+			}
+			tx.scans = append(tx.scans, scan)
+		}()
+		it := tx.tree.Ascend(start)
+		for k, v := range it.Records() {
+			if !inRange(k) {
+				complete = true
+				break
+			}
+			if tx.writable {
+				scan.records = append(scan.records, Record{
+					Key:   append(Key{}, k...),
+					Value: append(Value{}, v...),
+				})
+			}
+			if !yieldWrites(k) {
+				return nil
+			}
+			if len(writes) > 0 && bytes.Equal(writes[0].key, k) {
+				// The transaction's own write replaces the tree's record.
+				continue
+			}
+			if !yield(k, v) {
+				return nil
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+		complete = true
+		yieldWrites(nil)
+		return nil
+	})
+}
+
+// Commit applies every write made in the transaction to the tree in a single batch, see
+// Tree.Apply, unless something the transaction read from the tree has changed since, in
+// which case it returns ErrTxConflict and applies nothing. Committing a read-only
+// transaction just ends it.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.writes) == 0 {
+		return nil
+	}
+	var batch WriteBatch
+	for _, op := range tx.writes {
+		batch.ops = append(batch.ops, op)
+	}
+	tx.writes = nil
+	tx.tree.lock.Lock()
+	defer tx.tree.lock.Unlock()
+	ok, err := tx.validate()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTxConflict
+	}
+	return tx.tree.applyHooked(&batch)
+}
+
+// validate reports whether the tree, whose lock is held, still holds everything the
+// transaction read from it.
+func (tx *Tx) validate() (bool, error) {
+	for key, read := range tx.reads {
+		r, err := tx.tree.lookup(Key(key))
+		if err == ErrKeyNotFound {
+			if read.found {
+				return false, nil
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !read.found {
+			return false, nil
+		}
+		r, err = tx.tree.values.resolve(r)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(r.Value, read.value) {
+			return false, nil
+		}
+	}
+	for _, scan := range tx.scans {
+		ok, err := tx.validateScan(scan)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// validateScan reports whether the range scanned in the tree, whose lock is held, still
+// holds exactly the records the transaction saw in it.
+func (tx *Tx) validateScan(scan txScan) (ok bool, err error) {
+	c := tx.tree.Cursor()
+	defer func() {
+		resetErr := c.reset()
+		if err == nil {
+			err = resetErr
+		}
+	}()
+	r, err := c.seek(scan.start)
+	for _, seen := range scan.records {
+		if err == ErrKeyNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(r.Key, seen.Key) || !bytes.Equal(r.Value, seen.Value) {
+			return false, nil
+		}
+		c.index++
+		r, err = c.forward()
+	}
+	if err == ErrKeyNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// Any other record in the range was added since the scan.
+	return scan.end != nil && bytes.Compare(r.Key, scan.end) >= 0, nil
+}
+
+// Rollback throws away every write made in the transaction.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.writes = nil
+	tx.reads = nil
+	tx.scans = nil
+	return nil
+}
+
+func (tx *Tx) checkWritable() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
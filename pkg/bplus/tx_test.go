@@ -0,0 +1,303 @@
+package bplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestTxCommit(t *testing.T) {
+	tree, err := newTree("tx_commit", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i += 2 {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx := tree.Begin(true)
+	// Odd keys are added, multiples of four deleted and multiples of ten overwritten.
+	for i := 1; i < 100; i += 2 {
+		if err := tx.Put(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i += 4 {
+		if err := tx.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Delete(intKey(0)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	for i := 10; i < 100; i += 10 {
+		if err := tx.Put(intKey(i), intValue(-i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := func(i int) (Value, bool) {
+		switch {
+		case i%10 == 0 && i != 0:
+			return intValue(-i), true
+		case i%4 == 0:
+			return nil, false
+		}
+		return intValue(i), true
+	}
+
+	// The tree doesn't see the writes until they're committed.
+	if _, err := tree.Read(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	var keys []int
+	it := tx.Scan(intKey(5), intKey(50))
+	for k, v := range it.Records() {
+		value, ok := expected(keyInt(k))
+		if !ok || !bytes.Equal(v, value) {
+			t.Fatalf("expected %v == %v", v, value)
+		}
+		keys = append(keys, keyInt(k))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var want []int
+	for i := 5; i < 50; i++ {
+		if _, ok := expected(i); ok {
+			want = append(want, i)
+		}
+	}
+	expectInts(t, keys, want)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != ErrTxDone {
+		t.Fatalf("expected %v == %v", err, ErrTxDone)
+	}
+	if err := tx.Put(intKey(1), intValue(1)); err != ErrTxDone {
+		t.Fatalf("expected %v == %v", err, ErrTxDone)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := tree.Read(intKey(i))
+		want, ok := expected(i)
+		if !ok {
+			if err != ErrKeyNotFound {
+				t.Fatalf("found deleted value %+v for %d", value, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(value, want) {
+			t.Fatalf("expected %v == %v", value, want)
+		}
+	}
+	verifyTree(t, tree)
+}
+
+func TestTxRollback(t *testing.T) {
+	tree, err := newTree("tx_rollback", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(1), intValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	tx := tree.Begin(true)
+	if err := tx.Put(intKey(2), intValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(intKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Get(intKey(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	value, err := tx.Get(intKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(2)) {
+		t.Fatalf("expected %v == %v", value, intValue(2))
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != ErrTxDone {
+		t.Fatalf("expected %v == %v", err, ErrTxDone)
+	}
+	if _, err := tx.Get(intKey(1)); err != ErrTxDone {
+		t.Fatalf("expected %v == %v", err, ErrTxDone)
+	}
+	if tree.Len() != 1 {
+		t.Fatalf("expected %v == %v", tree.Len(), 1)
+	}
+	if _, err := tree.Read(intKey(2)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestTxReadOnly(t *testing.T) {
+	tree, err := newTree("tx_read_only", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx := tree.Begin(false)
+	if tx.Writable() {
+		t.Fatal("expected a read-only transaction")
+	}
+	if err := tx.Put(intKey(10), intValue(10)); err != ErrTxReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrTxReadOnly)
+	}
+	if err := tx.Delete(intKey(1)); err != ErrTxReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrTxReadOnly)
+	}
+	var keys []int
+	for k := range tx.Scan(intKey(3), nil).Records() {
+		keys = append(keys, keyInt(k))
+	}
+	expectInts(t, keys, []int{3, 4, 5, 6, 7, 8, 9})
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxRejectsLargeKey(t *testing.T) {
+	tree, err := newTree("tx_large_key", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := tree.Begin(true)
+	if err := tx.Put(make(Key, MaxKeySize+1), nil); err != ErrKeyTooLarge {
+		t.Fatalf("expected %v == %v", err, ErrKeyTooLarge)
+	}
+	if err := tx.Put(Key{1}, make(Value, maxRecordSize)); err != ErrRecordTooLarge {
+		t.Fatalf("expected %v == %v", err, ErrRecordTooLarge)
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	tree, err := newTree("tx_conflict", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i += 2 {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both transactions read key 2 and write it back, so only the first can commit.
+	first, second := tree.Begin(true), tree.Begin(true)
+	for _, tx := range []*Tx{first, second} {
+		value, err := tx.Get(intKey(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(intKey(2), append(value, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Commit(); err != ErrTxConflict {
+		t.Fatalf("expected %v == %v", err, ErrTxConflict)
+	}
+	value, err := tree.Read(intKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, append(intValue(2), 1)) {
+		t.Fatalf("expected %v == %v", value, append(intValue(2), 1))
+	}
+
+	// A key added to a range that was scanned conflicts, one outside of it doesn't.
+	scanned := tree.Begin(true)
+	for range scanned.Scan(intKey(4), intKey(10)).Records() {
+	}
+	if err := scanned.Put(intKey(100), intValue(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(11), intValue(11)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(5), intValue(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := scanned.Commit(); err != ErrTxConflict {
+		t.Fatalf("expected %v == %v", err, ErrTxConflict)
+	}
+	unaffected := tree.Begin(true)
+	for range unaffected.Scan(intKey(4), intKey(10)).Records() {
+	}
+	if _, err := unaffected.Get(intKey(3)); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := unaffected.Put(intKey(100), intValue(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(13), intValue(13)); err != nil {
+		t.Fatal(err)
+	}
+	if err := unaffected.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+}
+
+func TestTxConcurrentIncrements(t *testing.T) {
+	tree, err := newTree("tx_concurrent_increments", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(0), intValue(0)); err != nil {
+		t.Fatal(err)
+	}
+	const goroutines, increments = 8, 50
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				tx := tree.Begin(true)
+				value, err := tx.Get(intKey(0))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n := int(binary.LittleEndian.Uint32(value))
+				if err := tx.Put(intKey(0), intValue(n+1)); err != nil {
+					t.Error(err)
+					return
+				}
+				err = tx.Commit()
+				if err == ErrTxConflict {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				i++
+			}
+		}()
+	}
+	wg.Wait()
+	value, err := tree.Read(intKey(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, intValue(goroutines*increments)) {
+		t.Fatalf("expected %v == %v", value, intValue(goroutines*increments))
+	}
+}
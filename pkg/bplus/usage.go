@@ -0,0 +1,65 @@
+package bplus
+
+import "github.com/jpittis/bplus/pkg/store"
+
+// DiskUsage describes the pages a tree takes up in its file.
+type DiskUsage struct {
+	// Branches is the number of branch pages in the tree, including the root.
+	Branches int
+	// Leaves is the number of leaf pages in the tree.
+	Leaves int
+	// Bytes is the space the tree's pages take up in the file.
+	Bytes int64
+}
+
+// DiskUsage returns the pages the tree takes up in its file. Only the branch pages are
+// read, since the leaves are counted from the pointers to them. Each tree in a database
+// only counts its own pages. The file's header and free pages aren't part of any tree,
+// see Stats.
+func (tree *Tree) DiskUsage() (DiskUsage, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	placements, err := tree.placements()
+	if err != nil {
+		return DiskUsage{}, err
+	}
+	height := placements[len(placements)-1].depth
+	var usage DiskUsage
+	for _, p := range placements {
+		if height > 0 && p.depth == height {
+			usage.Leaves++
+		} else {
+			usage.Branches++
+		}
+	}
+	usage.Bytes = int64(len(placements)) * store.PageSize
+	return usage, nil
+}
+
+// Height returns the number of pages on the path from the root down to a leaf, which is
+// 1 for an empty tree, where the root is all there is. Only the leftmost path is read.
+func (tree *Tree) Height() (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	depth, err := tree.leafDepth()
+	return depth + 1, err
+}
+
+// leafDepth returns the depth of the leaves below the root, or 0 if the tree is empty.
+// Every leaf is at the same depth, so it's found down the leftmost path.
+func (tree *Tree) leafDepth() (int, error) {
+	if len(tree.root.pointers) == 0 {
+		return 0, nil
+	}
+	depth := 1
+	for pageID := tree.root.pointers[0]; ; depth++ {
+		leaf, branch, err := tree.loadNode(pageID)
+		if err != nil {
+			return 0, err
+		}
+		if leaf != nil {
+			return depth, nil
+		}
+		pageID = branch.pointers[0]
+	}
+}
@@ -0,0 +1,142 @@
+package bplus
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestDiskUsage(t *testing.T) {
+	tree, err := newTree("disk_usage", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	// An empty tree is only its root.
+	expectDiskUsage(t, tree, DiskUsage{Branches: 1, Bytes: store.PageSize})
+	for i := 0; i < 500; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := verifyTree(t, tree)
+	usage, err := tree.DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Branches+usage.Leaves != report.Pages {
+		t.Fatalf("expected %v == %v", usage.Branches+usage.Leaves, report.Pages)
+	}
+	if usage.Leaves <= usage.Branches {
+		t.Fatalf("expected more leaves than branches, got %+v", usage)
+	}
+	if usage.Bytes != int64(report.Pages)*store.PageSize {
+		t.Fatalf("expected %v == %v", usage.Bytes, int64(report.Pages)*store.PageSize)
+	}
+	// Every page of a lone tree's file is either the tree's, free, or the store's own.
+	stats, err := tree.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pages != report.Pages+stats.FreePages+3 {
+		t.Fatalf("expected %v == %v", stats.Pages, report.Pages+stats.FreePages+3)
+	}
+}
+
+func TestDiskUsageDBTrees(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "disk_usage_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	db, err := NewDB(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	small, err := db.CreateTree("small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := db.CreateTree("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if i < 10 {
+			if err := small.Insert(intKey(i), intValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := large.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	smallUsage, err := small.DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	largeUsage, err := large.DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smallUsage.Bytes >= largeUsage.Bytes {
+		t.Fatalf("expected %v < %v", smallUsage.Bytes, largeUsage.Bytes)
+	}
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(stats.Pages)*store.PageSize < smallUsage.Bytes+largeUsage.Bytes {
+		t.Fatalf("expected the file to hold both trees, got %+v", stats)
+	}
+}
+
+func TestHeight(t *testing.T) {
+	tree, err := newTree("height", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	expectHeight(t, tree, 1)
+	if err := tree.Insert(intKey(0), intValue(0)); err != nil {
+		t.Fatal(err)
+	}
+	expectHeight(t, tree, 2)
+	// Each level of four-way branches holds four times as many records as the one above.
+	for i := 1; i < 200; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	height, err := tree.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height < 4 || height > 6 {
+		t.Fatalf("expected 4 <= %v <= 6", height)
+	}
+}
+
+func expectHeight(t *testing.T, tree *Tree, expected int) {
+	t.Helper()
+	height, err := tree.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != expected {
+		t.Fatalf("expected %v == %v", height, expected)
+	}
+}
+
+func expectDiskUsage(t *testing.T, tree *Tree, expected DiskUsage) {
+	t.Helper()
+	usage, err := tree.DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != expected {
+		t.Fatalf("expected %+v == %+v", usage, expected)
+	}
+}
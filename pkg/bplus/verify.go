@@ -0,0 +1,304 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Violation is a broken invariant found by Verify.
+type Violation struct {
+	// PageID is the page the invariant is broken in.
+	PageID store.PageID
+	// Reason describes what is wrong with the page.
+	Reason string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("page %d: %s", v.PageID, v.Reason)
+}
+
+// VerifyReport is the result of walking a tree with Verify.
+type VerifyReport struct {
+	// Pages is the number of distinct pages reachable from the root, including the root.
+	Pages int
+	// Records is the number of records found in the leaves.
+	Records int
+	// Depth is the number of levels below the root, or 0 for an empty tree.
+	Depth int
+	// Violations lists every broken invariant in the order it was found.
+	Violations []Violation
+}
+
+// OK reports whether no violations were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *VerifyReport) violation(
+	pageID store.PageID,
+	format string,
+	args ...interface{},
+) {
+	r.Violations = append(r.Violations, Violation{
+		PageID: pageID,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// Verify walks every page of the tree and checks the B+ tree invariants: keys are sorted
+// within each page, every key lies between the separators above it, all leaves are at the
+// same depth, no page overflows or is left empty, no page is reachable twice, and the
+// record counts kept in branch pages match the records in the leaves. Pages are only
+// rebalanced when records are removed, so a page being under half full is not a
+// violation. In a B-link tree, every page must also link to the next page on its level
+// and have the separator above it to its right as its high key. No page the tree uses can
+// be free, and the file's free space has to add up, see store.PageStore.CheckFreeSpace.
+// Broken invariants and pages that don't match their checksums are collected in the
+// report rather than stopping the walk, and an error is only returned if a page couldn't
+// be read.
+func (tree *Tree) Verify() (*VerifyReport, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	report := &VerifyReport{Pages: 1}
+	err := tree.store.CheckFreeSpace()
+	if errors.Is(err, store.ErrFreeSpaceCorrupt) {
+		// The error names the page of the free list that's corrupt, if it's one of them.
+		var pageErr *store.PageError
+		var pageID store.PageID
+		if errors.As(err, &pageErr) {
+			pageID = pageErr.PageID
+		}
+		report.violation(pageID, "free space is corrupt")
+	} else if err != nil {
+		return report, err
+	}
+	freePages, err := tree.store.FreePageIDs()
+	if err != nil {
+		return report, err
+	}
+	free := map[store.PageID]bool{}
+	for _, pageID := range freePages {
+		free[pageID] = true
+	}
+	if free[tree.root.ID] {
+		report.violation(tree.root.ID, "page is in use but free")
+	}
+	if tree.db != nil {
+		entry, err := tree.db.loadTree(tree.name)
+		if err != nil {
+			return report, err
+		}
+		if entry.root != tree.root.ID {
+			report.violation(tree.root.ID, "catalog records root %d", entry.root)
+		}
+	} else if tree.store.Root() != tree.root.ID {
+		report.violation(tree.root.ID, "file header records root %d", tree.store.Root())
+	}
+	if len(tree.root.pointers) == 0 {
+		if len(tree.root.keys) != 0 || tree.root.count() != 0 {
+			report.violation(tree.root.ID, "empty root has keys or records")
+		}
+		return report, nil
+	}
+	v := &verifier{
+		tree:    tree,
+		report:  report,
+		visited: map[store.PageID]bool{tree.root.ID: true},
+		free:    free,
+		depth:   -1,
+		last:    map[int]lastOnLevel{},
+	}
+	_, err = v.verifyBranch(tree.root, keyBounds{}, 0)
+	for _, last := range v.last {
+		if last.link != 0 {
+			report.violation(last.id, "last page on its level links to page %d", last.link)
+		}
+	}
+	return report, err
+}
+
+// keyBounds holds the range [lower, upper) that the keys of a subtree must lie in. The
+// range has no upper bound unless bounded is set.
+type keyBounds struct {
+	lower   Key
+	upper   Key
+	bounded bool
+}
+
+func (b keyBounds) contains(key Key) bool {
+	if bytes.Compare(key, b.lower) < 0 {
+		return false
+	}
+	return !b.bounded || bytes.Compare(key, b.upper) < 0
+}
+
+type verifier struct {
+	tree    *Tree
+	report  *VerifyReport
+	visited map[store.PageID]bool
+	// free holds the pages that are free in the file.
+	free map[store.PageID]bool
+	// depth is the depth of the first leaf found, or -1 before one is found.
+	depth int
+	// last is the page most recently visited at each depth of a B-link tree.
+	last map[int]lastOnLevel
+}
+
+// lastOnLevel is the page most recently visited on a level of a B-link tree, and the
+// page it links to, which must be the next page visited on the level.
+type lastOnLevel struct {
+	id   store.PageID
+	link store.PageID
+}
+
+// verify checks the subtree rooted at pageID, which is depth levels below the root, and
+// returns the number of records in it.
+func (v *verifier) verify(pageID store.PageID, bounds keyBounds, depth int) (int, error) {
+	if v.visited[pageID] {
+		v.report.violation(pageID, "page is reachable more than once")
+		return 0, nil
+	}
+	v.visited[pageID] = true
+	v.report.Pages++
+	if pageID == 0 {
+		v.report.violation(pageID, "pointer to the file header")
+		return 0, nil
+	}
+	if v.free[pageID] {
+		v.report.violation(pageID, "page is in use but free")
+	}
+	leaf, branch, err := v.tree.loadNode(pageID)
+	var mismatch *store.ErrChecksumMismatch
+	if errors.As(err, &mismatch) {
+		v.report.violation(pageID, "checksum mismatch")
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if leaf != nil {
+		v.verifyLink(pageID, leaf.rightLink, bounds, depth)
+		v.verifyLeaf(leaf, bounds, depth)
+		return liveCount(leaf.records), nil
+	}
+	v.verifyLink(pageID, branch.rightLink, bounds, depth)
+	return v.verifyBranch(branch, bounds, depth)
+}
+
+// verifyLink checks the link and high key of a page of a B-link tree, which is visited
+// after every page to its left on the same level.
+func (v *verifier) verifyLink(
+	pageID store.PageID,
+	link rightLink,
+	bounds keyBounds,
+	depth int,
+) {
+	if !v.tree.blink() {
+		return
+	}
+	if !link.blink {
+		v.report.violation(pageID, "page of a B-link tree has no link")
+	}
+	if bounds.bounded != (link.high != nil) || !bytes.Equal(bounds.upper, link.high) {
+		v.report.violation(pageID, "high key %x, expected %x", link.high, bounds.upper)
+	}
+	if last, ok := v.last[depth]; ok && last.link != pageID {
+		v.report.violation(last.id, "links to page %d, expected %d", last.link, pageID)
+	}
+	v.last[depth] = lastOnLevel{id: pageID, link: link.link}
+}
+
+func (v *verifier) verifyLeaf(leaf *leafPage, bounds keyBounds, depth int) {
+	v.report.Records += liveCount(leaf.records)
+	if v.depth == -1 {
+		v.depth = depth
+		v.report.Depth = depth
+	} else if depth != v.depth {
+		v.report.violation(leaf.ID, "leaf at depth %d, expected %d", depth, v.depth)
+	}
+	if len(leaf.records) == 0 && !v.isOnlyLeaf(leaf.ID) {
+		v.report.violation(leaf.ID, "leaf has no records")
+	}
+	if v.tree.overflows(len(leaf.records), leaf.size()) {
+		v.report.violation(leaf.ID, "leaf overflows with %d records", len(leaf.records))
+	}
+	for i, r := range leaf.records {
+		if i > 0 && bytes.Compare(leaf.records[i-1].Key, r.Key) >= 0 {
+			v.report.violation(leaf.ID, "key %x at %d is out of order", r.Key, i)
+		}
+		if !bounds.contains(r.Key) {
+			v.report.violation(leaf.ID, "key %x is outside its separators", r.Key)
+		}
+	}
+}
+
+// isOnlyLeaf reports whether pageID is the single leaf of a tree, which is allowed to be
+// empty.
+func (v *verifier) isOnlyLeaf(pageID store.PageID) bool {
+	root := v.tree.root
+	return len(root.pointers) == 1 && root.pointers[0] == pageID
+}
+
+func (v *verifier) verifyBranch(
+	branch *branchPage,
+	bounds keyBounds,
+	depth int,
+) (int, error) {
+	isRoot := branch.ID == v.tree.root.ID
+	if len(branch.pointers) != len(branch.keys)+1 {
+		v.report.violation(
+			branch.ID,
+			"branch has %d keys and %d pointers",
+			len(branch.keys),
+			len(branch.pointers),
+		)
+		return 0, nil
+	}
+	if len(branch.counts) != len(branch.pointers) {
+		v.report.violation(branch.ID, "branch has %d counts", len(branch.counts))
+	}
+	if !isRoot && len(branch.pointers) < 2 {
+		v.report.violation(branch.ID, "branch has a single child")
+	}
+	if v.tree.overflows(len(branch.keys), branch.size()) {
+		v.report.violation(branch.ID, "branch overflows with %d keys", len(branch.keys))
+	}
+	for i, k := range branch.keys {
+		if i > 0 && bytes.Compare(branch.keys[i-1], k) >= 0 {
+			v.report.violation(branch.ID, "key %x at %d is out of order", k, i)
+		}
+		if !bounds.contains(k) {
+			v.report.violation(branch.ID, "key %x is outside its separators", k)
+		}
+	}
+	count := 0
+	for i, pointer := range branch.pointers {
+		// The keys of a child lie between the separators on either side of its pointer.
+		child := bounds
+		if i > 0 {
+			child.lower = branch.keys[i-1]
+		}
+		if i < len(branch.keys) {
+			child.upper = branch.keys[i]
+			child.bounded = true
+		}
+		n, err := v.verify(pointer, child, depth+1)
+		if err != nil {
+			return count, err
+		}
+		count += n
+		if i < len(branch.counts) && branch.counts[i] != n {
+			v.report.violation(
+				branch.ID,
+				"count of child %d is %d, but it has %d records",
+				pointer,
+				branch.counts[i],
+				n,
+			)
+		}
+	}
+	return count, nil
+}
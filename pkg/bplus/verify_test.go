@@ -0,0 +1,211 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestVerify(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for _, bf := range []int{3, 4, 16, 1000} {
+		tree, err := newTree("verify", bf, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyTree(t, tree)
+		model := map[int]bool{}
+		for round := 0; round < 20; round++ {
+			for _, i := range r.Perm(2000)[:200] {
+				// Vary the record sizes so that pages split by size as well as by count.
+				value := bytes.Repeat(intValue(i), 1+r.Intn(100))
+				if err := tree.Upsert(intKey(i), value); err != nil {
+					t.Fatal(err)
+				}
+				model[i] = true
+			}
+			start := r.Intn(2000)
+			end := start + r.Intn(300)
+			if _, err := tree.DeleteRange(intKey(start), intKey(end)); err != nil {
+				t.Fatal(err)
+			}
+			for i := start; i < end; i++ {
+				delete(model, i)
+			}
+			report := verifyTree(t, tree)
+			if report.Records != len(model) {
+				t.Fatalf("expected %v == %v", report.Records, len(model))
+			}
+		}
+	}
+}
+
+func TestVerifyFindsViolations(t *testing.T) {
+	tree, err := newTree("verify_finds_violations", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyTree(t, tree)
+
+	// Make the root count one record too many.
+	tree.root.counts[0]++
+	expectViolation(t, tree, tree.root.ID)
+	tree.root.counts[0]--
+
+	// Swap two keys in the first leaf.
+	leafID := tree.root.pointers[0]
+	for {
+		leaf, branch, err := tree.loadNode(leafID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf != nil {
+			break
+		}
+		leafID = branch.pointers[0]
+	}
+	leaf, _, err := tree.loadNode(leafID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := leaf.records
+	swapped := append([]Record{records[1], records[0]}, records[2:]...)
+	writeTestLeaf(t, tree, leafID, swapped)
+	expectViolation(t, tree, leafID)
+	writeTestLeaf(t, tree, leafID, records)
+	verifyTree(t, tree)
+
+	// Point the root at the same child twice.
+	tree.root.pointers[1] = tree.root.pointers[0]
+	expectViolation(t, tree, tree.root.pointers[0])
+}
+
+// verifyTree fails the test if the tree has any broken invariants.
+func verifyTree(t *testing.T, tree *Tree) *VerifyReport {
+	t.Helper()
+	report, err := tree.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("expected a valid tree, got %v", report.Violations)
+	}
+	return report
+}
+
+func expectViolation(t *testing.T, tree *Tree, pageID store.PageID) {
+	t.Helper()
+	report, err := tree.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range report.Violations {
+		if v.PageID == pageID {
+			return
+		}
+	}
+	t.Fatalf("expected a violation in page %d, got %v", pageID, report.Violations)
+}
+
+func writeTestLeaf(t *testing.T, tree *Tree, pageID store.PageID, records []Record) {
+	t.Helper()
+	page, err := tree.store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &leafPage{PageHandle: page, records: records}
+	if err := tree.writeLeaf(leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.release(page); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyFindsCorruptPages(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "verify_corrupt_pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := NewTree(tmpfile.Name(), WithBranchingFactor(4), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leafID := tree.root.pointers[0]
+	for {
+		_, branch, err := tree.loadNode(leafID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if branch == nil {
+			break
+		}
+		leafID = branch.pointers[0]
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, tree)
+	// Flip a byte of the leaf holding the smallest key behind the tree's back.
+	file, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(leafID)*store.PageSize + 100
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0]++
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	reopened, err := OpenTree(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mismatch *store.ErrChecksumMismatch
+	_, err = reopened.Read(intKey(0))
+	if !errors.As(err, &mismatch) || mismatch.PageID != leafID {
+		t.Fatalf("expected a checksum mismatch in page %d, got %v", leafID, err)
+	}
+	expectViolation(t, reopened, leafID)
+}
+
+func TestVerifyFindsFreePagesInUse(t *testing.T) {
+	tree, err := newTree("verify_finds_free_pages", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyTree(t, tree)
+	// Free a page the tree still points at.
+	pageID := tree.root.pointers[0]
+	if err := tree.store.Free(pageID); err != nil {
+		t.Fatal(err)
+	}
+	expectViolation(t, tree, pageID)
+}
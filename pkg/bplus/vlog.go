@@ -0,0 +1,675 @@
+package bplus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// valueLogFlag is set in the file header of trees that keep large values in a value log.
+const valueLogFlag = 16
+
+// valuePointerLength is stored in place of the length of a value kept in the value log,
+// and is followed by a pointer to the value, see valuePointer. Like tombstoneLength, no
+// value stored in a leaf can be this long.
+const valuePointerLength = 0xFFFFFFFE
+
+const (
+	// valuePointerSize is the segment, offset and length of a value in the value log.
+	valuePointerSize = 16
+	// valueEntryHeaderSize is the checksum, key length and value length at the start of
+	// every entry in the value log.
+	valueEntryHeaderSize = 12
+)
+
+const (
+	// DefaultValueThreshold is the size from which values are kept in the value log of a
+	// tree that was created with WithValueLog but is opened without it.
+	DefaultValueThreshold = 1024
+	// DefaultValueLogSegmentSize is the size at which a value log moves on to a new
+	// segment, unless it's opened with a size of its own, see WithValueLog.
+	DefaultValueLogSegmentSize = 64 << 20
+)
+
+var (
+	// ErrValueLogUnsupported is returned when using WithValueLog with a tree kept in
+	// memory or in a database, since the value log is kept in files alongside the tree's.
+	ErrValueLogUnsupported = errors.New("value logs need a tree with a file to itself")
+	// ErrValueLogCorrupt is returned, wrapped in a ValueLogError, when a value read from
+	// the value log doesn't match its checksum or the record pointing at it.
+	ErrValueLogCorrupt = errors.New("value log entry is corrupt")
+	// ErrNoValueLog is returned by CollectValueLog on a tree without a value log.
+	ErrNoValueLog = errors.New("tree has no value log")
+	// ErrInvalidDiscardRatio is returned when a discard ratio is not within (0, 1].
+	ErrInvalidDiscardRatio = errors.New("invalid discard ratio")
+)
+
+// ValueLogError records an error hit reading an entry of the value log, along with the
+// segment the entry is in and where it starts in the segment, such as
+// "read value log segment 3 at offset 0x1F00: value log entry is corrupt". The error it
+// wraps can be checked for with errors.Is.
+type ValueLogError struct {
+	// Op is what was being done with the entry.
+	Op      string
+	Segment uint32
+	Offset  uint64
+	Err     error
+}
+
+func (e *ValueLogError) Error() string {
+	return fmt.Sprintf("%s value log segment %d at offset 0x%X: %v",
+		e.Op, e.Segment, e.Offset, e.Err)
+}
+
+func (e *ValueLogError) Unwrap() error {
+	return e.Err
+}
+
+// castagnoli is the CRC-32 table entries in the value log are checksummed with.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// valuePointer is where a value is kept in the value log.
+type valuePointer struct {
+	segment uint32
+	offset  uint64
+	length  uint32
+}
+
+// readError wraps an error hit reading the entry p points at in a ValueLogError.
+func (p valuePointer) readError(err error) error {
+	return &ValueLogError{Op: "read", Segment: p.segment, Offset: p.offset, Err: err}
+}
+
+func (p valuePointer) toBuffer() Value {
+	buf := make(Value, valuePointerSize)
+	binary.LittleEndian.PutUint32(buf[0:4], p.segment)
+	binary.LittleEndian.PutUint64(buf[4:12], p.offset)
+	binary.LittleEndian.PutUint32(buf[12:16], p.length)
+	return buf
+}
+
+func valuePointerFromBuffer(buf []byte) valuePointer {
+	return valuePointer{
+		segment: binary.LittleEndian.Uint32(buf[0:4]),
+		offset:  binary.LittleEndian.Uint64(buf[4:12]),
+		length:  binary.LittleEndian.Uint32(buf[12:16]),
+	}
+}
+
+// valueLog keeps the large values of a tree in append-only segment files alongside the
+// tree's file, named after it with a ".vlog." suffix and the segment's number. Leaves
+// hold a pointer to each value in the log in place of the value, so that large values
+// don't fill up the tree's pages, see WithValueLog.
+//
+// Every entry in a segment is a checksum of the rest of the entry, the length of its key
+// and of its value, and then the key and value. The key lets the garbage collector find
+// the record that points at an entry, see CollectValueLog.
+type valueLog struct {
+	path        string
+	threshold   int
+	segmentSize int64
+	lock        sync.Mutex
+	segments    map[uint32]*os.File
+	// head is the segment values are appended to, which is headSize bytes long, and last
+	// is the highest numbered segment.
+	head     uint32
+	headSize int64
+	last     uint32
+	// writing holds the segments value streams are still being written to, see
+	// WriteStream.
+	writing map[uint32]bool
+	// readers is the number of open snapshots and value streams, which may read values
+	// from segments that have been collected since they were opened.
+	readers int
+	// retired holds the collected segments that are removed once no readers are open.
+	retired []uint32
+	// logger logs torn entries cut off the log and corrupt entries read from it.
+	logger *slog.Logger
+}
+
+// openValueLog opens the value log kept alongside the file at path, creating its first
+// segment if it doesn't have one. An entry torn by a crash part way through appending it
+// is cut off the end of the head segment, unless the log is opened read only.
+func openValueLog(
+	path string,
+	threshold int,
+	segmentSize int64,
+	readOnly bool,
+	logger *slog.Logger,
+) (*valueLog, error) {
+	l := &valueLog{
+		path:        path,
+		threshold:   threshold,
+		segmentSize: segmentSize,
+		segments:    map[uint32]*os.File{},
+		writing:     map[uint32]bool{},
+		logger:      logger,
+	}
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + ".vlog."
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+		segment, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			continue
+		}
+		f, err := os.OpenFile(l.segmentPath(uint32(segment)), flag, 0644)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		l.segments[uint32(segment)] = f
+		l.last = max(l.last, uint32(segment))
+	}
+	if len(l.segments) == 0 {
+		if readOnly {
+			return l, nil
+		}
+		l.head, _, err = l.createSegment()
+		return l, err
+	}
+	l.head = l.last
+	end, err := l.scan(l.head, func(int64, Key, Value) error { return nil })
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+	l.headSize = end
+	if readOnly {
+		return l, nil
+	}
+	info, err := l.segments[l.head].Stat()
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+	if info.Size() > end {
+		l.logger.Warn("cut torn entries off the value log", "segment", l.head,
+			"bytes", info.Size()-end)
+	}
+	err = l.segments[l.head].Truncate(end)
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *valueLog) segmentPath(segment uint32) string {
+	return l.path + fmt.Sprintf(".vlog.%06d", segment)
+}
+
+// createSegment creates an empty segment numbered after every other segment, with the
+// log's lock held.
+func (l *valueLog) createSegment() (uint32, *os.File, error) {
+	segment := l.last + 1
+	f, err := os.OpenFile(l.segmentPath(segment), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, nil, err
+	}
+	l.segments[segment] = f
+	l.last = segment
+	return segment, f, nil
+}
+
+// append adds a value to the end of the log and returns where it was written. It isn't
+// synced until sync is called, which must be before a record points at it.
+func (l *valueLog) append(key Key, value Value) (valuePointer, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	size := int64(valueEntryHeaderSize + len(key) + len(value))
+	if l.headSize > 0 && l.headSize+size > l.segmentSize {
+		// The sealed segment is synced now, because sync only syncs the head.
+		err := l.segments[l.head].Sync()
+		if err != nil {
+			return valuePointer{}, err
+		}
+		head, _, err := l.createSegment()
+		if err != nil {
+			return valuePointer{}, err
+		}
+		l.head, l.headSize = head, 0
+	}
+	buf := entryPool.Get().(*[]byte)
+	defer entryPool.Put(buf)
+	entry := resize(*buf, int(size))
+	*buf = entry
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(len(key)))
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(len(value)))
+	copy(entry[valueEntryHeaderSize:], key)
+	copy(entry[valueEntryHeaderSize+len(key):], value)
+	binary.LittleEndian.PutUint32(entry[0:4], crc32.Checksum(entry[4:], castagnoli))
+	_, err := l.segments[l.head].WriteAt(entry, l.headSize)
+	if err != nil {
+		return valuePointer{}, err
+	}
+	p := valuePointer{
+		segment: l.head,
+		offset:  uint64(l.headSize),
+		length:  uint32(len(value)),
+	}
+	l.headSize += size
+	return p, nil
+}
+
+// sync syncs the values appended to the log so far to disk.
+func (l *valueLog) sync() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.segments[l.head] == nil {
+		// A log opened read only may not have any segments.
+		return nil
+	}
+	return l.segments[l.head].Sync()
+}
+
+// read reads the value of key that p points at.
+func (l *valueLog) read(key Key, p valuePointer) (Value, error) {
+	l.lock.Lock()
+	f := l.segments[p.segment]
+	l.lock.Unlock()
+	if f == nil {
+		return nil, p.readError(ErrValueLogCorrupt)
+	}
+	entry := make([]byte, valueEntryHeaderSize+len(key)+int(p.length))
+	_, err := f.ReadAt(entry, int64(p.offset))
+	if err == io.EOF {
+		return nil, p.readError(ErrValueLogCorrupt)
+	}
+	if err != nil {
+		return nil, p.readError(err)
+	}
+	valid := binary.LittleEndian.Uint32(entry[0:4]) ==
+		crc32.Checksum(entry[4:], castagnoli) &&
+		binary.LittleEndian.Uint32(entry[4:8]) == uint32(len(key)) &&
+		binary.LittleEndian.Uint32(entry[8:12]) == p.length &&
+		bytes.Equal(entry[valueEntryHeaderSize:valueEntryHeaderSize+len(key)], key)
+	if !valid {
+		l.logCorrupt(p)
+		return nil, p.readError(ErrValueLogCorrupt)
+	}
+	return Value(entry[valueEntryHeaderSize+len(key):]), nil
+}
+
+// logCorrupt logs a corrupt entry that p points at.
+func (l *valueLog) logCorrupt(p valuePointer) {
+	l.logger.Error("value log entry is corrupt", "segment", p.segment, "offset", p.offset)
+}
+
+// resolve returns the record with its value read from the value log if it points there.
+// It's safe to call on a nil log with records that don't.
+func (l *valueLog) resolve(r Record) (Record, error) {
+	if !r.pointer {
+		return r, nil
+	}
+	if l == nil {
+		return Record{}, ErrValueLogCorrupt
+	}
+	value, err := l.read(r.Key, valuePointerFromBuffer(r.Value))
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Key: r.Key, Value: value}, nil
+}
+
+// scan calls fn with the offset, key and value of every entry in a segment in turn, and
+// returns the offset just past the last whole entry, where a torn entry may start.
+func (l *valueLog) scan(segment uint32, fn func(int64, Key, Value) error) (int64, error) {
+	l.lock.Lock()
+	f := l.segments[segment]
+	l.lock.Unlock()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, 0, info.Size()))
+	var offset int64
+	header := make([]byte, valueEntryHeaderSize)
+	for {
+		_, err = io.ReadFull(r, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		keyLen := binary.LittleEndian.Uint32(header[4:8])
+		valueLen := binary.LittleEndian.Uint32(header[8:12])
+		size := int64(valueEntryHeaderSize) + int64(keyLen) + int64(valueLen)
+		if keyLen > MaxKeySize || offset+size > info.Size() {
+			return offset, nil
+		}
+		body := make([]byte, int(keyLen)+int(valueLen))
+		_, err = io.ReadFull(r, body)
+		if err != nil {
+			return offset, err
+		}
+		sum := crc32.Update(crc32.Checksum(header[4:], castagnoli), castagnoli, body)
+		if sum != binary.LittleEndian.Uint32(header[0:4]) {
+			return offset, nil
+		}
+		err = fn(offset, Key(body[:keyLen]), Value(body[keyLen:]))
+		if err != nil {
+			return offset, err
+		}
+		offset += size
+	}
+}
+
+// sealed returns the segments values are no longer written to, oldest first.
+func (l *valueLog) sealed() []uint32 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var segments []uint32
+	for segment := range l.segments {
+		if segment != l.head && !l.writing[segment] && !l.isRetired(segment) {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments
+}
+
+func (l *valueLog) isRetired(segment uint32) bool {
+	for _, retired := range l.retired {
+		if retired == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// retire removes a segment nothing in the tree points at anymore, or once every open
+// reader has been closed if there are any.
+func (l *valueLog) retire(segment uint32) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.retired = append(l.retired, segment)
+	if l.readers > 0 {
+		return nil
+	}
+	return l.removeRetired()
+}
+
+// removeRetired removes the retired segments with the log's lock held. A segment that
+// can't be removed is tried again the next time.
+func (l *valueLog) removeRetired() error {
+	var err error
+	remaining := l.retired[:0]
+	for _, segment := range l.retired {
+		closeErr := l.segments[segment].Close()
+		if closeErr == nil || errors.Is(closeErr, os.ErrClosed) {
+			closeErr = os.Remove(l.segmentPath(segment))
+		}
+		if closeErr != nil && !errors.Is(closeErr, os.ErrNotExist) {
+			remaining = append(remaining, segment)
+			if err == nil {
+				err = closeErr
+			}
+			continue
+		}
+		delete(l.segments, segment)
+	}
+	l.retired = remaining
+	return err
+}
+
+// pin keeps the segments that are collected from being removed until unpin is called,
+// for a snapshot or value stream that may still read from them. Both are safe to call on
+// a nil log.
+func (l *valueLog) pin() {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.readers++
+}
+
+func (l *valueLog) unpin() error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.readers--
+	if l.readers > 0 {
+		return nil
+	}
+	return l.removeRetired()
+}
+
+// close removes the retired segments and closes the rest. Segments retired while a
+// reader was open are left behind if the tree is closed before the reader, and are
+// collected again once the tree is reopened.
+func (l *valueLog) close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var err error
+	if l.readers == 0 {
+		err = l.removeRetired()
+	}
+	for _, f := range l.segments {
+		closeErr := f.Close()
+		if err == nil && !errors.Is(closeErr, os.ErrClosed) {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// useValueLog opens the tree's value log if it has one or opts asks for one, which
+// records that the tree has a value log in its file.
+func (tree *Tree) useValueLog(filename string, o options) error {
+	if o.valueThreshold == 0 && tree.flags&valueLogFlag == 0 {
+		return nil
+	}
+	if tree.flags&dbFlag != 0 {
+		return ErrValueLogUnsupported
+	}
+	threshold, segmentSize := o.valueThreshold, o.valueLogSegmentSize
+	if threshold == 0 {
+		threshold = DefaultValueThreshold
+	}
+	if segmentSize == 0 {
+		segmentSize = DefaultValueLogSegmentSize
+	}
+	readOnly := tree.store.ReadOnly()
+	values, err := openValueLog(
+		filename, threshold, segmentSize, readOnly, tree.store.Logger())
+	if err != nil {
+		return err
+	}
+	tree.values = values
+	if tree.flags&valueLogFlag != 0 {
+		return nil
+	}
+	return tree.setFlags(tree.flags | valueLogFlag)
+}
+
+// fits reports whether a record can be stored in the tree, in a leaf or with its value in
+// the value log.
+func (tree *Tree) fits(r Record) bool {
+	return tree.values != nil || r.size() <= maxRecordSize
+}
+
+// logValue returns the record that stores value under key. If the tree has a value log
+// and the value is large, the value is appended to the log, which has to be synced before
+// the record is written, and the record points at it.
+func (tree *Tree) logValue(key Key, value Value) (Record, error) {
+	r := Record{Key: key, Value: value}
+	if tree.values == nil {
+		return r, nil
+	}
+	if len(value) < tree.values.threshold && r.size() <= maxRecordSize {
+		return r, nil
+	}
+	p, err := tree.values.append(key, value)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Key: key, Value: p.toBuffer(), pointer: true}, nil
+}
+
+// newRecord is logValue for a single record, syncing the value log if the value was
+// appended to it.
+func (tree *Tree) newRecord(key Key, value Value) (Record, error) {
+	r, err := tree.logValue(key, value)
+	if err != nil || !r.pointer {
+		return r, err
+	}
+	return r, tree.values.sync()
+}
+
+// logBatchValues appends the large values of a batch's puts to the value log, pointing
+// the operations at them, and syncs the log once for the whole batch.
+func (tree *Tree) logBatchValues(ops []batchOp) error {
+	logged := false
+	for i, op := range ops {
+		if op.delete || op.pointer {
+			continue
+		}
+		r, err := tree.logValue(op.key, op.value)
+		if err != nil {
+			return err
+		}
+		ops[i].value, ops[i].pointer = r.Value, r.pointer
+		logged = logged || r.pointer
+	}
+	if !logged {
+		return nil
+	}
+	return tree.values.sync()
+}
+
+// logRecordValues is logBatchValues for records being bulk loaded, which are copied
+// rather than changed in place since they belong to the caller.
+func (tree *Tree) logRecordValues(records []Record) ([]Record, error) {
+	if tree.values == nil {
+		return records, nil
+	}
+	logged := make([]Record, len(records))
+	for i, r := range records {
+		var err error
+		logged[i], err = tree.logValue(r.Key, r.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return logged, tree.values.sync()
+}
+
+// CollectValueLog reclaims the space in the value log taken up by values that have been
+// overwritten or deleted, and returns the number of segments it collected. Each segment
+// other than the one being appended to is checked in turn, and if at least discardRatio
+// of it is garbage, the values still in use are appended to the log again, the records
+// are pointed at their new copies, and the segment is removed. Writes to the tree wait
+// while a segment is collected.
+//
+// Segments that open snapshots or value streams may read from are only removed once they
+// have been closed, and segments still being written by value streams aren't collected.
+func (tree *Tree) CollectValueLog(discardRatio float64) (int, error) {
+	if discardRatio <= 0 || discardRatio > 1 {
+		return 0, ErrInvalidDiscardRatio
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.values == nil {
+		return 0, ErrNoValueLog
+	}
+	collected := 0
+	for _, segment := range tree.values.sealed() {
+		done, err := tree.collectSegment(segment, discardRatio)
+		if err != nil {
+			return collected, err
+		}
+		if done {
+			collected++
+		}
+	}
+	return collected, nil
+}
+
+// collectSegment collects a segment of the value log if at least discardRatio of it is
+// garbage, with the tree's lock held, and returns whether it was collected.
+func (tree *Tree) collectSegment(segment uint32, discardRatio float64) (bool, error) {
+	var live int64
+	size, err := tree.values.scan(segment, func(offset int64, key Key, value Value) error {
+		ok, err := tree.liveValue(segment, offset, key)
+		if ok {
+			live += int64(valueEntryHeaderSize + len(key) + len(value))
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if size > 0 && float64(size-live)/float64(size) < discardRatio {
+		return false, nil
+	}
+	if live > 0 {
+		var b WriteBatch
+		_, err = tree.values.scan(segment, func(offset int64, key Key, value Value) error {
+			ok, err := tree.liveValue(segment, offset, key)
+			if err != nil || !ok {
+				return err
+			}
+			p, err := tree.values.append(key, value)
+			b.ops = append(b.ops, batchOp{key: key, value: p.toBuffer(), pointer: true})
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		err = tree.values.sync()
+		if err != nil {
+			return false, err
+		}
+		err = tree.apply(&b)
+		if err != nil {
+			return false, err
+		}
+	}
+	// Nothing may point into the segment on disk by the time it's removed.
+	err = tree.store.Sync()
+	if err != nil {
+		return false, err
+	}
+	tree.store.Logger().Info("collected value log segment", "segment", segment,
+		"bytes", size, "live_bytes", live)
+	return true, tree.values.retire(segment)
+}
+
+// liveValue reports whether the record of key points at the value at offset in segment.
+func (tree *Tree) liveValue(segment uint32, offset int64, key Key) (bool, error) {
+	r, err := tree.lookup(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil || !r.pointer {
+		return false, err
+	}
+	p := valuePointerFromBuffer(r.Value)
+	return p.segment == segment && p.offset == uint64(offset), nil
+}
@@ -0,0 +1,464 @@
+package bplus
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestValueLog(t *testing.T) {
+	filename := tempFilename(t, "value_log")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithBranchingFactor(8), WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Values from 16 bytes up to several pages, so that some are kept in the leaves and
+	// some are too large to be kept in a leaf at all.
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), largeValue(i, 16+i*100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyTree(t, tree)
+	expectLargeValues(t, tree, 0, 100, 0)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly, err := OpenTree(filename, WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLargeValues(t, readOnly, 0, 100, 0)
+	if err := readOnly.Insert(intKey(100), largeValue(100, 1000)); err != store.ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, store.ErrReadOnly)
+	}
+	if err := readOnly.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tree keeps using the value log once it's been opened with one.
+	tree, err = OpenTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	expectLargeValues(t, tree, 0, 100, 0)
+	err = tree.Update(intKey(50), func(old Value) (Value, error) {
+		if !bytes.Equal(old, largeValue(50, 5016)) {
+			t.Fatalf("expected %v == %v", len(old), 5016)
+		}
+		return largeValue(-50, 8000), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := tree.MultiGet([]Key{intKey(50), intKey(99), intKey(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Value{largeValue(-50, 8000), largeValue(99, 9916), nil}
+	for i := range values {
+		if !bytes.Equal(values[i], expected[i]) {
+			t.Fatalf("expected %v == %v", len(values[i]), len(expected[i]))
+		}
+	}
+	r, err := tree.Max()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Value, largeValue(99, 9916)) {
+		t.Fatalf("expected %v == %v", len(r.Value), 9916)
+	}
+}
+
+func TestValueLogBatchAndBulkLoad(t *testing.T) {
+	filename := tempFilename(t, "value_log_batch")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(256, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	var records []Record
+	for i := 0; i < 50; i++ {
+		records = append(records, Record{Key: intKey(i), Value: largeValue(i, 2000)})
+	}
+	if err := tree.BulkLoad(records, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The caller's records are left as they were.
+	if !bytes.Equal(records[0].Value, largeValue(0, 2000)) || records[0].pointer {
+		t.Fatalf("expected the records to be left untouched")
+	}
+	var b WriteBatch
+	for i := 50; i < 100; i++ {
+		b.Put(intKey(i), largeValue(i, 2000))
+	}
+	b.Delete(intKey(0))
+	if err := tree.Apply(&b); err != nil {
+		t.Fatal(err)
+	}
+	verifyTree(t, tree)
+	expectLargeValues(t, tree, 1, 100, 2000)
+}
+
+func TestCollectValueLog(t *testing.T) {
+	filename := tempFilename(t, "collect_value_log")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 8192))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), largeValue(i, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := valueLogSegments(t, filename)
+	if len(before) < 10 {
+		t.Fatalf("expected at least 10 segments, got %v", len(before))
+	}
+	// Only a few of the values are overwritten, which isn't enough for any segment to be
+	// collected.
+	for i := 0; i < 100; i += 10 {
+		if err := tree.Upsert(intKey(i), largeValue(-i, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	collected, err := tree.CollectValueLog(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected != 0 {
+		t.Fatalf("expected %v == %v", collected, 0)
+	}
+	for i := 0; i < 100; i++ {
+		if i%10 != 0 && i%2 == 0 {
+			if err := tree.Delete(intKey(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	collected, err = tree.CollectValueLog(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected == 0 {
+		t.Fatalf("expected segments to be collected")
+	}
+	for _, segment := range before[:collected] {
+		if _, err := os.Stat(segment); !os.IsNotExist(err) {
+			t.Fatalf("expected %v to be removed, got %v", segment, err)
+		}
+	}
+	expectCollectedValues := func(tree *Tree) {
+		for i := 0; i < 100; i++ {
+			value, err := tree.Read(intKey(i))
+			switch {
+			case i%10 == 0:
+				if err != nil || !bytes.Equal(value, largeValue(-i, 1000)) {
+					t.Fatalf("expected %v of %v, got %v", len(value), i, err)
+				}
+			case i%2 == 0:
+				if err != ErrKeyNotFound {
+					t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+				}
+			default:
+				if err != nil || !bytes.Equal(value, largeValue(i, 1000)) {
+					t.Fatalf("expected %v of %v, got %v", len(value), i, err)
+				}
+			}
+		}
+	}
+	expectCollectedValues(tree)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = OpenTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	expectCollectedValues(tree)
+	verifyTree(t, tree)
+}
+
+func TestCollectValueLogWithSnapshot(t *testing.T) {
+	filename := tempFilename(t, "collect_value_log_snapshot")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(intKey(i), largeValue(i, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := tree.Upsert(intKey(i), largeValue(-i, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := valueLogSegments(t, filename)
+	collected, err := tree.CollectValueLog(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected == 0 {
+		t.Fatalf("expected segments to be collected")
+	}
+	// The snapshot still reads the values the tree no longer points at.
+	if segments := valueLogSegments(t, filename); len(segments) != len(before) {
+		t.Fatalf("expected %v == %v", len(segments), len(before))
+	}
+	for i := 0; i < 20; i++ {
+		value, err := snap.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, largeValue(i, 1000)) {
+			t.Fatalf("expected %v == %v", len(value), 1000)
+		}
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segments := valueLogSegments(t, filename)
+	if len(segments) != len(before)-collected {
+		t.Fatalf("expected %v == %v", len(segments), len(before)-collected)
+	}
+}
+
+func TestValueLogTornEntry(t *testing.T) {
+	filename := tempFilename(t, "value_log_torn")
+	defer removeValueLog(filename)
+	tree, err := NewTree(filename, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(1), largeValue(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A crash part way through appending a value leaves part of an entry behind.
+	segments := valueLogSegments(t, filename)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{1, 2, 3, 4, 5, 6, 7}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var logs bytes.Buffer
+	tree, err = OpenTree(filename, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `msg="cut torn entries off the value log" segment=1 bytes=7`
+	if !strings.Contains(logs.String(), expected) {
+		t.Fatalf("expected %q to be logged, got %q", expected, logs.String())
+	}
+	if err := tree.Insert(intKey(2), largeValue(2, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	collected, err := tree.CollectValueLog(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected != 0 {
+		t.Fatalf("expected %v == %v", collected, 0)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = OpenTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	expectLargeValues(t, tree, 1, 3, 1000)
+}
+
+func TestValueLogErrors(t *testing.T) {
+	if _, err := NewMemoryTree(WithValueLog(64, 0)); err != ErrValueLogUnsupported {
+		t.Fatalf("expected %v == %v", err, ErrValueLogUnsupported)
+	}
+	filename := tempFilename(t, "value_log_db")
+	defer removeValueLog(filename)
+	if _, err := NewDB(filename, WithValueLog(64, 0)); err != ErrValueLogUnsupported {
+		t.Fatalf("expected %v == %v", err, ErrValueLogUnsupported)
+	}
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDB(filename, WithValueLog(64, 0)); err != ErrValueLogUnsupported {
+		t.Fatalf("expected %v == %v", err, ErrValueLogUnsupported)
+	}
+
+	tree, err := NewMemoryTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if _, err := tree.CollectValueLog(0.5); err != ErrNoValueLog {
+		t.Fatalf("expected %v == %v", err, ErrNoValueLog)
+	}
+	if _, err := tree.CollectValueLog(0); err != ErrInvalidDiscardRatio {
+		t.Fatalf("expected %v == %v", err, ErrInvalidDiscardRatio)
+	}
+	if err := tree.Insert(intKey(1), largeValue(1, 2000)); err != ErrRecordTooLarge {
+		t.Fatalf("expected %v == %v", err, ErrRecordTooLarge)
+	}
+}
+
+func TestValueLogCorrupt(t *testing.T) {
+	filename := tempFilename(t, "value_log_corrupt")
+	defer removeValueLog(filename)
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	tree, err := NewTree(filename, WithValueLog(64, 0), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(intKey(1), largeValue(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	segments := valueLogSegments(t, filename)
+	f, err := os.OpenFile(segments[0], os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, 100); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	_, err = tree.Read(intKey(1))
+	if !errors.Is(err, ErrValueLogCorrupt) {
+		t.Fatalf("expected %v == %v", err, ErrValueLogCorrupt)
+	}
+	message := "read value log segment 1 at offset 0x0: value log entry is corrupt"
+	if err.Error() != message {
+		t.Fatalf("expected %v == %v", err, message)
+	}
+	expected := `level=ERROR msg="value log entry is corrupt" segment=1 offset=0`
+	if !strings.Contains(logs.String(), expected) {
+		t.Fatalf("expected %q to be logged, got %q", expected, logs.String())
+	}
+}
+
+func TestCopyValueLog(t *testing.T) {
+	src := tempFilename(t, "copy_value_log_src")
+	defer removeValueLog(src)
+	dst := tempFilename(t, "copy_value_log_dst")
+	os.Remove(dst)
+	defer removeValueLog(dst)
+	tree, err := NewTree(src, WithValueLog(64, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(intKey(i), largeValue(i, 3000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Copy(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = OpenTree(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if tree.values == nil {
+		t.Fatalf("expected the copy to have a value log")
+	}
+	expectLargeValues(t, tree, 0, 20, 3000)
+}
+
+// largeValue returns a value of size bytes that differs for every i.
+func largeValue(i, size int) Value {
+	value := bytes.Repeat(intValue(i), size/4+1)
+	return value[:size]
+}
+
+// expectLargeValues checks the values of the keys in [start, end), which are
+// largeValue(i, size), or largeValue(i, 16+i*100) if size is zero, both one at a time and
+// with a cursor.
+func expectLargeValues(t *testing.T, tree *Tree, start, end, size int) {
+	t.Helper()
+	expected := func(i int) Value {
+		if size == 0 {
+			return largeValue(i, 16+i*100)
+		}
+		return largeValue(i, size)
+	}
+	for i := start; i < end; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, expected(i)) {
+			t.Fatalf("expected %v == %v", len(value), len(expected(i)))
+		}
+	}
+	i := start
+	it := tree.All()
+	for key, value := range it.Records() {
+		if !bytes.Equal(key, intKey(i)) || !bytes.Equal(value, expected(i)) {
+			t.Fatalf("expected %v == %v", key, intKey(i))
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != end {
+		t.Fatalf("expected %v == %v", i, end)
+	}
+}
+
+func valueLogSegments(t *testing.T, filename string) []string {
+	t.Helper()
+	segments, err := filepath.Glob(filename + ".vlog.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segments
+}
+
+func removeValueLog(filename string) {
+	segments, _ := filepath.Glob(filename + ".vlog.*")
+	for _, segment := range append(segments, filename) {
+		os.Remove(segment)
+	}
+}
@@ -0,0 +1,360 @@
+package bplus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// WatchBufferSize is the number of events a watcher can fall behind by before it's
+// dropped, see Watch.
+const WatchBufferSize = 128
+
+// WatchEventKind is the kind of change a WatchEvent describes.
+type WatchEventKind int
+
+const (
+	// WatchInsert is sent when a key that wasn't in the tree is written.
+	WatchInsert WatchEventKind = iota
+	// WatchUpdate is sent when the value of a key that was already in the tree is
+	// written, even if it's written with the value it already had.
+	WatchUpdate
+	// WatchDelete is sent when a key is deleted from the tree.
+	WatchDelete
+	// WatchOverflow is the last event sent to a watcher that fell too far behind, see
+	// Watch. It has no key or value.
+	WatchOverflow
+)
+
+var watchEventKindNames = [...]string{"Insert", "Update", "Delete", "Overflow"}
+
+func (k WatchEventKind) String() string {
+	if k < 0 || int(k) >= len(watchEventKindNames) {
+		return fmt.Sprintf("WatchEventKind(%d)", int(k))
+	}
+	return watchEventKindNames[k]
+}
+
+// WatchEvent describes a change made to a watched key. The key and value are shared
+// with every other watcher of the key, so they mustn't be changed.
+type WatchEvent struct {
+	Kind WatchEventKind
+	Key  Key
+	// Value is the value written, which is nil for deletes and for values written with
+	// WriteStream, which can be read with ReadStream instead.
+	Value Value
+	// Old is the value the key had before the change, which is nil for inserts.
+	Old Value
+}
+
+// watchList holds the watchers of a tree.
+type watchList struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+	// count is the number of watchers, so that writes can tell without taking mu that
+	// there's no one to tell about them.
+	count atomic.Int32
+}
+
+type watcher struct {
+	key    Key
+	prefix bool
+	events chan WatchEvent
+	// stop stops waiting for the watcher's context to be done.
+	stop func() bool
+}
+
+// matches returns whether the watcher is watching key.
+func (w *watcher) matches(key Key) bool {
+	if w.prefix {
+		return bytes.HasPrefix(key, w.key)
+	}
+	return bytes.Equal(key, w.key)
+}
+
+// overlaps returns whether the watcher is watching any key in [start, end).
+func (w *watcher) overlaps(start, end Key) bool {
+	if bytes.Compare(w.key, end) >= 0 {
+		return false
+	}
+	if w.prefix {
+		// The keys with the prefix run from the prefix itself up to the first key
+		// greater than it that doesn't start with it.
+		return bytes.Compare(w.key, start) >= 0 || bytes.HasPrefix(start, w.key)
+	}
+	return bytes.Compare(w.key, start) >= 0
+}
+
+// Watch sends an event on the returned channel every time key is inserted, updated or
+// deleted, until ctx is done, when the channel is closed. Events are sent once the
+// change has been committed, in the order the changes were made, although changes to the
+// key by writers racing each other may be sent in either order. Deleting a range sends
+// an event for every watched key in it.
+//
+// Writes never wait for watchers. A watcher that falls WatchBufferSize events behind is
+// sent a WatchOverflow event in place of the event that didn't fit, and its channel is
+// closed, so it has to read the key again, and watch it again, to catch up. Closing the
+// tree closes the channels of all of its watchers.
+func (tree *Tree) Watch(ctx context.Context, key Key) <-chan WatchEvent {
+	return tree.watches.add(ctx, &watcher{key: append(Key{}, key...)})
+}
+
+// WatchPrefix is Watch for every key that starts with prefix. An empty prefix watches
+// the whole tree.
+func (tree *Tree) WatchPrefix(ctx context.Context, prefix Key) <-chan WatchEvent {
+	return tree.watches.add(ctx, &watcher{key: append(Key{}, prefix...), prefix: true})
+}
+
+func (l *watchList) add(ctx context.Context, w *watcher) <-chan WatchEvent {
+	// One slot is kept for the WatchOverflow event.
+	w.events = make(chan WatchEvent, WatchBufferSize+1)
+	l.mu.Lock()
+	if l.watchers == nil {
+		l.watchers = map[*watcher]struct{}{}
+	}
+	l.watchers[w] = struct{}{}
+	l.count.Add(1)
+	l.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.remove(w)
+	})
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.watchers[w]; !ok {
+		// The watcher was removed before there was a stop function to call.
+		stop()
+	}
+	w.stop = stop
+	return w.events
+}
+
+// remove closes a watcher's channel, if it hasn't been removed already, with mu held.
+func (l *watchList) remove(w *watcher) {
+	if _, ok := l.watchers[w]; !ok {
+		return
+	}
+	delete(l.watchers, w)
+	l.count.Add(-1)
+	close(w.events)
+	if w.stop != nil {
+		w.stop()
+	}
+}
+
+// closeAll removes every watcher, once the tree is closed.
+func (l *watchList) closeAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for w := range l.watchers {
+		l.remove(w)
+	}
+}
+
+// watching returns whether anything is watching the tree, so that writes only work out
+// what changed when there's someone to tell.
+func (l *watchList) watching() bool {
+	return l.count.Load() > 0
+}
+
+// watchingKey returns whether anything is watching key.
+func (l *watchList) watchingKey(key Key) bool {
+	if !l.watching() {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for w := range l.watchers {
+		if w.matches(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchingRange returns whether anything is watching a key in [start, end).
+func (l *watchList) watchingRange(start, end Key) bool {
+	if !l.watching() {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for w := range l.watchers {
+		if w.overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify sends events to the watchers of their keys.
+func (l *watchList) notify(events []WatchEvent) {
+	if len(events) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, event := range events {
+		for w := range l.watchers {
+			if !w.matches(event.Key) {
+				continue
+			}
+			// Only notify sends to the channel, so the last slot can only be taken by
+			// the WatchOverflow event.
+			if len(w.events) < WatchBufferSize {
+				w.events <- event
+				continue
+			}
+			w.events <- WatchEvent{Kind: WatchOverflow}
+			l.remove(w)
+		}
+	}
+}
+
+// watchPut wraps fn so that it records the event for the change it makes, which is sent
+// once the change has been committed, and so that the hooks can veto the change before
+// it's made.
+func (tree *Tree) watchPut(key Key, fn putFunc, event **WatchEvent) putFunc {
+	return func(old Value, found bool) (Value, error) {
+		*event = nil
+		value, err := fn(old, found)
+		e := WatchEvent{Kind: WatchInsert, Key: append(Key{}, key...)}
+		switch {
+		case err == errWriteTombstone:
+			e.Kind = WatchDelete
+		case err != nil:
+			return value, err
+		case found:
+			e.Kind = WatchUpdate
+		}
+		if found {
+			e.Old = append(Value{}, old...)
+		}
+		if e.Kind != WatchDelete {
+			e.Value = append(Value{}, value...)
+		}
+		hookErr := tree.before([]WatchEvent{e})
+		if hookErr != nil {
+			return nil, hookErr
+		}
+		*event = &e
+		return value, err
+	}
+}
+
+// batchEvents returns the events for the changes a batch is about to make, with the
+// tree's lock held.
+func (tree *Tree) batchEvents(b *WriteBatch) ([]WatchEvent, error) {
+	if !tree.observing() {
+		return nil, nil
+	}
+	var events []WatchEvent
+	for _, op := range b.sorted() {
+		if !tree.observingKey(op.key) {
+			continue
+		}
+		r, err := tree.lookup(op.key)
+		if err != nil && err != ErrKeyNotFound {
+			return nil, err
+		}
+		found := err == nil
+		event := WatchEvent{Kind: WatchInsert, Key: op.key}
+		switch {
+		case op.delete && !found:
+			continue
+		case op.delete:
+			event.Kind = WatchDelete
+		case found:
+			event.Kind = WatchUpdate
+		}
+		if found {
+			r, err = tree.values.resolve(r)
+			if err != nil {
+				return nil, err
+			}
+			event.Old = r.Value
+		}
+		if !op.delete && !op.pointer {
+			event.Value = op.value
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// loadEvents returns the insert events for the watched records about to be bulk loaded.
+func (tree *Tree) loadEvents(records []Record) []WatchEvent {
+	var events []WatchEvent
+	for _, r := range records {
+		if tree.observingKey(r.Key) {
+			events = append(events, WatchEvent{
+				Kind:  WatchInsert,
+				Key:   append(Key{}, r.Key...),
+				Value: append(Value{}, r.Value...),
+			})
+		}
+	}
+	return events
+}
+
+// rangeEvents returns the delete events for the watched keys in [start, end), which are
+// about to be deleted, with the tree's lock held.
+func (tree *Tree) rangeEvents(start, end Key) ([]WatchEvent, error) {
+	if len(tree.root.pointers) == 0 || !tree.observingRange(start, end) {
+		return nil, nil
+	}
+	var events []WatchEvent
+	for _, pointer := range tree.root.pointers {
+		err := tree.collectDeletes(pointer, start, end, &events)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// collectDeletes adds a delete event for every watched key in [start, end) in the subtree
+// rooted at pageID that isn't a tombstone.
+func (tree *Tree) collectDeletes(
+	pageID store.PageID,
+	start, end Key,
+	events *[]WatchEvent,
+) error {
+	leaf, branch, err := tree.loadNode(pageID)
+	if err != nil {
+		return err
+	}
+	if leaf != nil {
+		i, _ := leaf.search(start)
+		j, _ := leaf.search(end)
+		for _, r := range leaf.records[i:max(i, j)] {
+			if r.tombstone || !tree.observingKey(r.Key) {
+				continue
+			}
+			r, err = tree.values.resolve(r)
+			if err != nil {
+				return err
+			}
+			*events = append(*events, WatchEvent{
+				Kind: WatchDelete,
+				Key:  append(Key{}, r.Key...),
+				Old:  append(Value{}, r.Value...),
+			})
+		}
+		return nil
+	}
+	first := branch.childIndex(start)
+	last := branch.childIndex(end)
+	for _, pointer := range branch.pointers[first : last+1] {
+		err = tree.collectDeletes(pointer, start, end, events)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
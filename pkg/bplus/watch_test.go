@@ -0,0 +1,168 @@
+package bplus
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// expectEvents receives len(expected) events from events and checks them against
+// expected.
+func expectEvents(t *testing.T, events <-chan WatchEvent, expected []WatchEvent) {
+	t.Helper()
+	for _, e := range expected {
+		event, ok := <-events
+		if !ok {
+			t.Fatalf("expected %v %q, got a closed channel", e.Kind, e.Key)
+		}
+		if event.Kind != e.Kind || !bytes.Equal(event.Key, e.Key) ||
+			!bytes.Equal(event.Value, e.Value) {
+			t.Fatalf("expected %v == %v", event, e)
+		}
+	}
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Fatalf("expected no more events, got %v", event)
+		}
+	default:
+	}
+}
+
+func TestWatch(t *testing.T) {
+	tree, err := newTree("watch", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := tree.Watch(ctx, Key("b"))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := tree.Insert(Key(key), Value(key+"1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Upsert(Key("b"), Value("b2")); err != nil {
+		t.Fatal(err)
+	}
+	// Writes that fail or leave the key untouched aren't sent.
+	if err := tree.Insert(Key("b"), Value("b3")); err != ErrDuplicateKey {
+		t.Fatalf("expected %v == %v", err, ErrDuplicateKey)
+	}
+	err = tree.Update(Key("b"), func(old Value) (Value, error) { return old, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(Key("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(Key("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	expectEvents(t, events, []WatchEvent{
+		{Kind: WatchInsert, Key: Key("b"), Value: Value("b1")},
+		{Kind: WatchUpdate, Key: Key("b"), Value: Value("b2")},
+		{Kind: WatchDelete, Key: Key("b")},
+	})
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatalf("expected the channel to be closed")
+	}
+	if err := tree.Insert(Key("b"), Value("b4")); err != nil {
+		t.Fatal(err)
+	}
+	if tree.watches.watching() {
+		t.Fatalf("expected the watcher to be removed")
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	tree, err := newTree("watch_prefix", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert(Key("user/1"), Value("1")); err != nil {
+		t.Fatal(err)
+	}
+	events := tree.WatchPrefix(context.Background(), Key("user/"))
+
+	var batch WriteBatch
+	batch.Put(Key("user/1"), Value("one"))
+	batch.Put(Key("user/2"), Value("two"))
+	batch.Put(Key("group/1"), Value("one"))
+	// Deleting a key that isn't in the tree changes nothing.
+	batch.Delete(Key("user/3"))
+	if err := tree.Apply(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetLazyDelete(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(Key("user/1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetLazyDelete(false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tree.Insert(append(Key("user/x"), byte(i)), Value("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := tree.DeleteRange(Key("group/"), Key("user/x\x02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tombstone of user/1 was already deleted.
+	if n != 4 {
+		t.Fatalf("expected %v == %v", n, 4)
+	}
+	expectEvents(t, events, []WatchEvent{
+		{Kind: WatchUpdate, Key: Key("user/1"), Value: Value("one")},
+		{Kind: WatchInsert, Key: Key("user/2"), Value: Value("two")},
+		{Kind: WatchDelete, Key: Key("user/1")},
+		{Kind: WatchInsert, Key: Key("user/x\x00"), Value: Value("x")},
+		{Kind: WatchInsert, Key: Key("user/x\x01"), Value: Value("x")},
+		{Kind: WatchInsert, Key: Key("user/x\x02"), Value: Value("x")},
+		{Kind: WatchDelete, Key: Key("user/2")},
+		{Kind: WatchDelete, Key: Key("user/x\x00")},
+		{Kind: WatchDelete, Key: Key("user/x\x01")},
+	})
+
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-events; ok {
+		t.Fatalf("expected the channel to be closed")
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	tree, err := newTree("watch_overflow", 4, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	events := tree.WatchPrefix(context.Background(), nil)
+	for i := 0; i < WatchBufferSize+10; i++ {
+		if err := tree.Insert(intKey(i), intValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := 0
+	for event := range events {
+		if count == WatchBufferSize {
+			if event.Kind != WatchOverflow {
+				t.Fatalf("expected %v == %v", event.Kind, WatchOverflow)
+			}
+		} else if keyInt(event.Key) != count {
+			t.Fatalf("expected %v == %v", keyInt(event.Key), count)
+		}
+		count++
+	}
+	if count != WatchBufferSize+1 {
+		t.Fatalf("expected %v == %v", count, WatchBufferSize+1)
+	}
+}
@@ -0,0 +1,346 @@
+// Package bplustest checks a tree against a model of what it should hold.
+//
+// Check applies a sequence of operations both to a tree and to a map standing in for it,
+// comparing what each operation returns from the tree with what the model says it
+// should, and verifies the tree's invariants as it goes, see bplus.Tree.Verify. The
+// operations are generated from a seed by RandomOps, for tests run in CI, or decoded
+// from arbitrary bytes by DecodeOps, for fuzzing. A mismatch is reported with the step
+// and operation it happened at, so the sequence up to it can be replayed.
+package bplustest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// Kind is the kind of an operation.
+type Kind int
+
+const (
+	// Insert inserts a record, which fails with bplus.ErrDuplicateKey if it's present.
+	Insert Kind = iota
+	// Upsert inserts a record or overwrites its value.
+	Upsert
+	// Delete deletes a record, which fails with bplus.ErrKeyNotFound if it's missing.
+	Delete
+	// Read reads the value of a key.
+	Read
+	// DeleteRange deletes the records with a key in [Key, End).
+	DeleteRange
+	// CountRange counts the records with a key in [Key, End).
+	CountRange
+	// Scan reads up to ScanLimit records in order from Key.
+	Scan
+	// Floor finds the record with the largest key less than or equal to Key.
+	Floor
+	// Ceiling finds the record with the smallest key greater than or equal to Key.
+	Ceiling
+	kinds
+)
+
+var kindNames = [...]string{
+	"Insert", "Upsert", "Delete", "Read", "DeleteRange", "CountRange", "Scan", "Floor",
+	"Ceiling",
+}
+
+func (k Kind) String() string {
+	if k < 0 || k >= kinds {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// ScanLimit is the most records a Scan reads.
+const ScanLimit = 16
+
+// Op is an operation applied to the tree and the model.
+type Op struct {
+	Kind  Kind
+	Key   bplus.Key
+	End   bplus.Key
+	Value bplus.Value
+}
+
+func (op Op) String() string {
+	switch op.Kind {
+	case Insert, Upsert:
+		return fmt.Sprintf("%v %q (%d bytes)", op.Kind, op.Key, len(op.Value))
+	case DeleteRange, CountRange:
+		return fmt.Sprintf("%v [%q, %q)", op.Kind, op.Key, op.End)
+	}
+	return fmt.Sprintf("%v %q", op.Kind, op.Key)
+}
+
+// Config configures the operations generated and how often the tree is verified.
+type Config struct {
+	// Keys is the number of distinct keys operations are drawn from, kept small enough
+	// that operations often hit keys that are already in the tree.
+	Keys int
+	// MaxValueSize is the largest value written, in bytes.
+	MaxValueSize int
+	// VerifyEvery verifies the tree after every VerifyEvery operations. The tree is
+	// always verified once all of them have been applied.
+	VerifyEvery int
+}
+
+// DefaultConfig is a Config that exercises splits and merges of small trees.
+var DefaultConfig = Config{Keys: 512, MaxValueSize: 64, VerifyEvery: 100}
+
+// key returns the i'th of the keys operations are drawn from.
+func key(i int) bplus.Key {
+	return bplus.Key(fmt.Sprintf("key%06d", i))
+}
+
+// RandomOps generates n operations from seed.
+func RandomOps(seed int64, n int, config Config) []Op {
+	r := rand.New(rand.NewSource(seed))
+	ops := make([]Op, n)
+	for i := range ops {
+		kind := Kind(r.Intn(int(kinds)))
+		start := r.Intn(config.Keys)
+		value := make(bplus.Value, r.Intn(config.MaxValueSize+1))
+		r.Read(value)
+		ops[i] = newOp(kind, start, start+r.Intn(config.Keys/8+1), value)
+	}
+	return ops
+}
+
+// DecodeOps decodes operations from arbitrary bytes, four bytes each, so that a fuzzer
+// can search for sequences of them that break the tree.
+func DecodeOps(data []byte, config Config) []Op {
+	var ops []Op
+	for ; len(data) >= 4; data = data[4:] {
+		kind := Kind(int(data[0]) % int(kinds))
+		start := int(data[1]) * config.Keys / 256
+		value := bytes.Repeat([]byte{data[3]}, int(data[3])*config.MaxValueSize/255)
+		ops = append(ops, newOp(kind, start, start+int(data[2])%(config.Keys/8+1), value))
+	}
+	return ops
+}
+
+func newOp(kind Kind, start, end int, value bplus.Value) Op {
+	op := Op{Kind: kind, Key: key(start)}
+	switch kind {
+	case Insert, Upsert:
+		op.Value = value
+	case DeleteRange, CountRange:
+		op.End = key(end)
+	}
+	return op
+}
+
+// Mismatch is returned by Check when the tree doesn't return what the model says it
+// should, or fails to verify.
+type Mismatch struct {
+	// Step is the index of the operation, or the number of operations applied before the
+	// tree failed to verify.
+	Step int
+	// Op is the operation, or the zero Op if the tree failed to verify.
+	Op Op
+	// Got is what the tree returned, and Want what the model said it should.
+	Got, Want string
+}
+
+func (m *Mismatch) Error() string {
+	if m.Op.Key == nil {
+		return fmt.Sprintf("verify after step %d: %s", m.Step, m.Got)
+	}
+	return fmt.Sprintf("step %d, %v: got %s, want %s", m.Step, m.Op, m.Got, m.Want)
+}
+
+// Check applies the operations to the tree and to a model of it, starting from what the
+// tree holds, and returns a Mismatch at the first operation the tree gets wrong. An
+// error the tree returns that the model doesn't expect, such as a failed write, is a
+// mismatch too.
+func Check(tree *bplus.Tree, ops []Op, config Config) error {
+	m, err := load(tree)
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		got, want := m.apply(tree, op)
+		if got != want {
+			return &Mismatch{Step: i, Op: op, Got: got, Want: want}
+		}
+		if config.VerifyEvery > 0 && (i+1)%config.VerifyEvery == 0 {
+			err = verify(tree, m, i+1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return verify(tree, m, len(ops))
+}
+
+// model is what the tree should hold.
+type model map[string]string
+
+// load reads what the tree holds into a model.
+func load(tree *bplus.Tree) (model, error) {
+	m := model{}
+	it := tree.All()
+	for k, v := range it.Records() {
+		m[string(k)] = string(v)
+	}
+	return m, it.Err()
+}
+
+// keys returns the keys of the model in order.
+func (m model) keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// apply applies an operation to the tree and the model, and describes what each of them
+// returned.
+func (m model) apply(tree *bplus.Tree, op Op) (got, want string) {
+	k := string(op.Key)
+	value, present := m[k]
+	switch op.Kind {
+	case Insert:
+		got = describe(nil, tree.Insert(op.Key, op.Value))
+		want = describe(nil, nil)
+		if present {
+			want = describe(nil, bplus.ErrDuplicateKey)
+		} else {
+			m[k] = string(op.Value)
+		}
+	case Upsert:
+		got = describe(nil, tree.Upsert(op.Key, op.Value))
+		want = describe(nil, nil)
+		m[k] = string(op.Value)
+	case Delete:
+		got = describe(nil, tree.Delete(op.Key))
+		want = describe(nil, nil)
+		if !present {
+			want = describe(nil, bplus.ErrKeyNotFound)
+		}
+		delete(m, k)
+	case Read:
+		v, err := tree.Read(op.Key)
+		got = describe(fmt.Sprintf("%q", v), err)
+		want = describe(fmt.Sprintf("%q", value), nil)
+		if !present {
+			want = describe(nil, bplus.ErrKeyNotFound)
+		}
+	case DeleteRange:
+		n, err := tree.DeleteRange(op.Key, op.End)
+		got = describe(n, err)
+		want = describe(m.deleteRange(k, string(op.End)), nil)
+	case CountRange:
+		n, err := tree.CountRange(op.Key, op.End)
+		got = describe(n, err)
+		want = describe(len(m.inRange(k, string(op.End))), nil)
+	case Scan:
+		got, want = m.scan(tree, k)
+	case Floor, Ceiling:
+		got, want = m.nearest(tree, op)
+	}
+	return got, want
+}
+
+// inRange returns the keys of the model in [start, end) in order.
+func (m model) inRange(start, end string) []string {
+	var keys []string
+	for _, k := range m.keys() {
+		if k >= start && k < end {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (m model) deleteRange(start, end string) int {
+	keys := m.inRange(start, end)
+	for _, k := range keys {
+		delete(m, k)
+	}
+	return len(keys)
+}
+
+// scan reads up to ScanLimit records from start from both the tree and the model.
+func (m model) scan(tree *bplus.Tree, start string) (got, want string) {
+	var records []string
+	it := tree.Ascend(bplus.Key(start))
+	for k, v := range it.Records() {
+		records = append(records, fmt.Sprintf("%q=%q", k, v))
+		if len(records) == ScanLimit {
+			break
+		}
+	}
+	got = describe(records, it.Err())
+	records = nil
+	for _, k := range m.keys() {
+		if k >= start && len(records) < ScanLimit {
+			records = append(records, fmt.Sprintf("%q=%q", k, m[k]))
+		}
+	}
+	return got, describe(records, nil)
+}
+
+// nearest finds the floor or ceiling of the operation's key in both the tree and the
+// model.
+func (m model) nearest(tree *bplus.Tree, op Op) (got, want string) {
+	var r bplus.Record
+	var err error
+	if op.Kind == Floor {
+		r, err = tree.Floor(op.Key)
+	} else {
+		r, err = tree.Ceiling(op.Key)
+	}
+	got = describe(fmt.Sprintf("%q=%q", r.Key, r.Value), err)
+	keys := m.keys()
+	if op.Kind == Floor {
+		for i := len(keys) - 1; i >= 0; i-- {
+			if keys[i] <= string(op.Key) {
+				return got, describe(fmt.Sprintf("%q=%q", keys[i], m[keys[i]]), nil)
+			}
+		}
+	} else {
+		for _, k := range keys {
+			if k >= string(op.Key) {
+				return got, describe(fmt.Sprintf("%q=%q", k, m[k]), nil)
+			}
+		}
+	}
+	return got, describe(nil, bplus.ErrKeyNotFound)
+}
+
+// describe describes what an operation returned. Results are left out when there's an
+// error.
+func describe(result interface{}, err error) string {
+	if err != nil {
+		return fmt.Sprintf("error %q", err)
+	}
+	if result == nil {
+		return "ok"
+	}
+	return fmt.Sprint(result)
+}
+
+// verify verifies the tree's invariants and that it holds exactly what the model does.
+func verify(tree *bplus.Tree, m model, step int) error {
+	report, err := tree.Verify()
+	if err != nil {
+		return &Mismatch{Step: step, Got: err.Error()}
+	}
+	if !report.OK() {
+		return &Mismatch{Step: step, Got: report.Violations[0].String()}
+	}
+	if report.Records != len(m) || tree.Len() != len(m) {
+		return &Mismatch{
+			Step: step,
+			Got:  fmt.Sprintf("%d records, %d by Len", report.Records, tree.Len()),
+			Want: fmt.Sprintf("%d records", len(m)),
+		}
+	}
+	return nil
+}
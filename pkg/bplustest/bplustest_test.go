@@ -0,0 +1,86 @@
+package bplustest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newTree(t testing.TB) *bplus.Tree {
+	tree, err := bplus.NewMemoryTree(bplus.WithBranchingFactor(4), bplus.WithCacheSize(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+func TestCheckRandomOps(t *testing.T) {
+	config := Config{Keys: 128, MaxValueSize: 32, VerifyEvery: 50}
+	for seed := int64(0); seed < 5; seed++ {
+		err := Check(newTree(t), RandomOps(seed, 2000, config), config)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+	}
+}
+
+func TestCheckExistingRecords(t *testing.T) {
+	tree := newTree(t)
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(key(i), bplus.Value("existing")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ops := []Op{
+		{Kind: Insert, Key: key(3), Value: bplus.Value("again")},
+		{Kind: Read, Key: key(5)},
+		{Kind: CountRange, Key: key(0), End: key(10)},
+	}
+	if err := Check(tree, ops, DefaultConfig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckMismatch(t *testing.T) {
+	// A model that doesn't match the tree stands in for a tree that lost a record.
+	tree := newTree(t)
+	m := model{string(key(7)): "missing"}
+	got, want := m.apply(tree, Op{Kind: Read, Key: key(7)})
+	if got == want {
+		t.Fatalf("expected %v != %v", got, want)
+	}
+	err := verify(tree, m, 3)
+	var mismatch *Mismatch
+	if !errors.As(err, &mismatch) || mismatch.Step != 3 {
+		t.Fatalf("expected a mismatch after step 3, got %v", err)
+	}
+}
+
+func TestDecodeOps(t *testing.T) {
+	ops := DecodeOps([]byte{0, 255, 0, 255, byte(DeleteRange), 0, 3, 0, 1}, DefaultConfig)
+	if len(ops) != 2 {
+		t.Fatalf("expected %v == %v", len(ops), 2)
+	}
+	if ops[0].Kind != Insert || string(ops[0].Key) != string(key(510)) {
+		t.Fatalf("expected %v == %v", ops[0], Op{Kind: Insert, Key: key(510)})
+	}
+	if len(ops[0].Value) != DefaultConfig.MaxValueSize {
+		t.Fatalf("expected %v == %v", len(ops[0].Value), DefaultConfig.MaxValueSize)
+	}
+	if ops[1].Kind != DeleteRange || string(ops[1].End) != string(key(3)) {
+		t.Fatalf("expected %v == %v", ops[1], Op{Kind: DeleteRange, Key: key(0), End: key(3)})
+	}
+}
+
+func FuzzCheck(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 10, 0, 2, 0, 20, 3, 1, 0, 0, byte(DeleteRange), 0, 5, 0})
+	f.Add([]byte{1, 100, 0, 255, byte(Floor), 101, 0, 0, byte(Ceiling), 99, 0, 0})
+	config := Config{Keys: 64, MaxValueSize: 32, VerifyEvery: 16}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := Check(newTree(t), DecodeOps(data, config), config); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+//go:build bbolt
+
+package convert
+
+import (
+	"errors"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// boltTimeout is how long to wait for another process to let go of a bbolt file.
+const boltTimeout = time.Second
+
+// ErrBucketRequired is returned when importing or exporting bbolt without a bucket.
+var ErrBucketRequired = errors.New("bbolt bucket required")
+
+func init() {
+	formats["bbolt"] = format{importFile: ImportBolt, exportFile: ExportBolt}
+}
+
+// ImportBolt copies the records of the bucket opts.Bucket of the bbolt database at path
+// into tree, skipping its nested buckets. It's only built with the bbolt tag.
+func ImportBolt(path string, tree *bplus.Tree, opts Options) (int, error) {
+	if len(opts.Bucket) == 0 {
+		return 0, ErrBucketRequired
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: boltTimeout})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var n int
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(opts.Bucket[0])
+		for _, name := range opts.Bucket[1:] {
+			if b == nil {
+				break
+			}
+			b = b.Bucket(name)
+		}
+		if b == nil {
+			return bbolt.ErrBucketNotFound
+		}
+		n, err = Load(tree, func(fn func(key, value []byte) error) error {
+			return b.ForEach(func(k, v []byte) error {
+				// A nil value is a nested bucket.
+				if v == nil {
+					return nil
+				}
+				return fn(k, v)
+			})
+		})
+		return err
+	})
+	return n, err
+}
+
+// ExportBolt copies the records of tree into the bucket opts.Bucket of the bbolt database
+// at path, creating the database and the buckets if they don't exist yet. It's only
+// built with the bbolt tag.
+func ExportBolt(tree *bplus.Tree, path string, opts Options) (int, error) {
+	if len(opts.Bucket) == 0 {
+		return 0, ErrBucketRequired
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: boltTimeout})
+	if err != nil {
+		return 0, err
+	}
+	n, err := Dump(tree, func(records []bplus.Record) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(opts.Bucket[0])
+			for _, name := range opts.Bucket[1:] {
+				if err != nil {
+					return err
+				}
+				b, err = b.CreateBucketIfNotExists(name)
+			}
+			if err != nil {
+				return err
+			}
+			for _, r := range records {
+				if err := b.Put(r.Key, r.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
@@ -0,0 +1,132 @@
+//go:build bbolt
+
+package convert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// boltRecords returns every record of the bucket in the bbolt database at path,
+// in order, skipping nested buckets.
+func boltRecords(t *testing.T, path string, bucket [][]byte) []bplus.Record {
+	t.Helper()
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var records []bplus.Record
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucket[0])
+		for _, name := range bucket[1:] {
+			b = b.Bucket(name)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				records = append(records, bplus.Record{
+					Key:   append(bplus.Key{}, k...),
+					Value: append(bplus.Value{}, v...),
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert_bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bucket := [][]byte{[]byte("outer"), []byte("inner")}
+	records := testRecords(2*batchSize + 10)
+
+	// Write the source database by hand, with a nested bucket the import skips.
+	src := filepath.Join(dir, "src.db")
+	db, err := bbolt.Open(src, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		outer, err := tx.CreateBucket(bucket[0])
+		if err != nil {
+			return err
+		}
+		inner, err := outer.CreateBucket(bucket[1])
+		if err != nil {
+			return err
+		}
+		if _, err := inner.CreateBucket([]byte("nested")); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := inner.Put(r.Key, r.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := newTree(t)
+	opts := Options{Bucket: bucket}
+	if n, err := Import("bbolt", src, tree, opts); err != nil || n != len(records) {
+		t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+	}
+	if !reflect.DeepEqual(treeRecords(t, tree), records) {
+		t.Fatalf("expected the imported records to match the bucket's")
+	}
+
+	dst := filepath.Join(dir, "dst.db")
+	if n, err := Export("bbolt", tree, dst, opts); err != nil || n != len(records) {
+		t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+	}
+	if !reflect.DeepEqual(boltRecords(t, dst, bucket), records) {
+		t.Fatalf("expected the exported records to match the tree's")
+	}
+}
+
+func TestBoltErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert_bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "empty.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree := newTree(t)
+	if _, err := Import("bbolt", path, tree, Options{}); err != ErrBucketRequired {
+		t.Fatalf("expected %v == %v", err, ErrBucketRequired)
+	}
+	if _, err := Export("bbolt", tree, path, Options{}); err != ErrBucketRequired {
+		t.Fatalf("expected %v == %v", err, ErrBucketRequired)
+	}
+	opts := Options{Bucket: [][]byte{[]byte("missing")}}
+	if _, err := Import("bbolt", path, tree, opts); err != bbolt.ErrBucketNotFound {
+		t.Fatalf("expected %v == %v", err, bbolt.ErrBucketNotFound)
+	}
+}
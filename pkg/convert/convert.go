@@ -0,0 +1,144 @@
+// Package convert copies records between trees and files in other formats, to make
+// switching to trees, or away from them, cheap, and loading and dumping records easy. CSV
+// and JSON lines files, see bplus.Tree.ImportCSV and bplus.Tree.ImportJSONL, are always
+// built in, while the files of other embedded key-value stores are only built in with
+// their build tags, which need the stores' packages:
+//
+//	bbolt    go.etcd.io/bbolt
+//	leveldb  github.com/syndtr/goleveldb
+//	pebble   github.com/cockroachdb/pebble
+//
+// Records are copied in batches, see bplus.Tree.Apply, so a copy that fails part way
+// leaves the records copied so far behind.
+package convert
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// batchSize is the most records written at once.
+const batchSize = 1000
+
+// ErrUnknownFormat is returned when importing or exporting a format that isn't built in.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// Records calls fn with every record of a source in turn, stopping at the first error it
+// returns, like bbolt's Bucket.ForEach. The key and value are only valid during the call.
+type Records func(fn func(key, value []byte) error) error
+
+// Options configures an import or export.
+type Options struct {
+	// Bucket is the path of the bbolt bucket to copy the records of, from the top-level
+	// bucket down, which has to be set for bbolt. Nested buckets in the bucket are
+	// skipped on import, and the buckets are created on export if they don't exist.
+	Bucket [][]byte
+	// Keys and Values say how keys and values are written in the csv and jsonl formats,
+	// see bplus.TextFormat.
+	Keys, Values bplus.TextCodec
+}
+
+// format imports and exports the files of a store.
+type format struct {
+	importFile func(path string, tree *bplus.Tree, opts Options) (int, error)
+	exportFile func(tree *bplus.Tree, path string, opts Options) (int, error)
+}
+
+// formats holds the built-in formats by name, which register themselves.
+var formats = map[string]format{}
+
+// Formats returns the names of the built-in formats, in order.
+func Formats() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Import copies the records of the database at path, in the named format, into tree, and
+// returns how many it copied.
+func Import(name, path string, tree *bplus.Tree, opts Options) (int, error) {
+	f, ok := formats[name]
+	if !ok {
+		return 0, ErrUnknownFormat
+	}
+	return f.importFile(path, tree, opts)
+}
+
+// Export copies the records of tree into the database at path, in the named format,
+// creating it if it doesn't exist yet, and returns how many it copied.
+func Export(name string, tree *bplus.Tree, path string, opts Options) (int, error) {
+	f, ok := formats[name]
+	if !ok {
+		return 0, ErrUnknownFormat
+	}
+	return f.exportFile(tree, path, opts)
+}
+
+// Load writes every record of records to tree, overwriting the values of keys it already
+// has, and returns how many it wrote.
+func Load(tree *bplus.Tree, records Records) (int, error) {
+	var b bplus.WriteBatch
+	n := 0
+	err := records(func(key, value []byte) error {
+		b.Put(key, value)
+		if b.Len() < batchSize {
+			return nil
+		}
+		err := tree.Apply(&b)
+		if err != nil {
+			return err
+		}
+		n += b.Len()
+		b.Reset()
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if b.Len() > 0 {
+		err = tree.Apply(&b)
+		if err != nil {
+			return n, err
+		}
+		n += b.Len()
+	}
+	return n, nil
+}
+
+// Dump calls write with every record of tree, in order, in batches of up to batchSize
+// records, and returns how many it wrote. The records are copies, which write is free to
+// keep.
+func Dump(tree *bplus.Tree, write func([]bplus.Record) error) (int, error) {
+	var records []bplus.Record
+	n := 0
+	it := tree.All()
+	for k, v := range it.Records() {
+		records = append(records, bplus.Record{
+			Key:   append(bplus.Key{}, k...),
+			Value: append(bplus.Value{}, v...),
+		})
+		if len(records) < batchSize {
+			continue
+		}
+		if err := write(records); err != nil {
+			return n, err
+		}
+		n += len(records)
+		records = nil
+	}
+	if err := it.Err(); err != nil {
+		return n, err
+	}
+	if len(records) > 0 {
+		if err := write(records); err != nil {
+			return n, err
+		}
+		n += len(records)
+	}
+	return n, nil
+}
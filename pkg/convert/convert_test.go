@@ -0,0 +1,177 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newTree(t *testing.T) *bplus.Tree {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	tree, err := bplus.NewTree(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+// sliceRecords returns the records of a slice.
+func sliceRecords(records []bplus.Record) Records {
+	return func(fn func(key, value []byte) error) error {
+		for _, r := range records {
+			if err := fn(r.Key, r.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// treeRecords returns every record of tree, in order.
+func treeRecords(t *testing.T, tree *bplus.Tree) []bplus.Record {
+	t.Helper()
+	var records []bplus.Record
+	_, err := Dump(tree, func(batch []bplus.Record) error {
+		records = append(records, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func testRecords(n int) []bplus.Record {
+	var records []bplus.Record
+	for i := 0; i < n; i++ {
+		records = append(records, bplus.Record{
+			Key:   bplus.Key(fmt.Sprintf("key%05d", i)),
+			Value: bplus.Value(fmt.Sprintf("value%d", i)),
+		})
+	}
+	return records
+}
+
+func TestLoadDump(t *testing.T) {
+	tree := newTree(t)
+	records := testRecords(2*batchSize + 10)
+	n, err := Load(tree, sliceRecords(records))
+	if err != nil || n != len(records) {
+		t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+	}
+	if tree.Len() != len(records) {
+		t.Fatalf("expected %v == %v", tree.Len(), len(records))
+	}
+
+	var dumped []bplus.Record
+	var batches int
+	n, err = Dump(tree, func(batch []bplus.Record) error {
+		batches++
+		dumped = append(dumped, batch...)
+		return nil
+	})
+	if err != nil || n != len(records) || batches != 3 {
+		t.Fatalf("expected %v == %v in %v == 3 batches, got %v", n, len(records), batches,
+			err)
+	}
+	if !reflect.DeepEqual(dumped, records) {
+		t.Fatalf("expected the dumped records to match the loaded ones")
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	tree := newTree(t)
+	errSource := errors.New("source failed")
+	n, err := Load(tree, func(fn func(key, value []byte) error) error {
+		for _, r := range testRecords(batchSize + 1) {
+			if err := fn(r.Key, r.Value); err != nil {
+				return err
+			}
+		}
+		return errSource
+	})
+	// The full batch was written before the source failed.
+	if err != errSource || n != batchSize || tree.Len() != batchSize {
+		t.Fatalf("expected %v == %v and %v == %v", err, errSource, n, batchSize)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	// fake keeps databases in memory, by path.
+	databases := map[string][]bplus.Record{}
+	formats["fake"] = format{
+		importFile: func(path string, tree *bplus.Tree, _ Options) (int, error) {
+			return Load(tree, sliceRecords(databases[path]))
+		},
+		exportFile: func(tree *bplus.Tree, path string, _ Options) (int, error) {
+			return Dump(tree, func(records []bplus.Record) error {
+				databases[path] = append(databases[path], records...)
+				return nil
+			})
+		},
+	}
+	defer delete(formats, "fake")
+	found := false
+	for _, name := range Formats() {
+		found = found || name == "fake"
+	}
+	if !found {
+		t.Fatalf("expected fake in %v", Formats())
+	}
+
+	databases["src"] = testRecords(10)
+	tree := newTree(t)
+	if n, err := Import("fake", "src", tree, Options{}); err != nil || n != 10 {
+		t.Fatalf("expected %v == %v, got %v", n, 10, err)
+	}
+	if n, err := Export("fake", tree, "dst", Options{}); err != nil || n != 10 {
+		t.Fatalf("expected %v == %v, got %v", n, 10, err)
+	}
+	if !reflect.DeepEqual(databases["dst"], databases["src"]) {
+		t.Fatalf("expected %v == %v", databases["dst"], databases["src"])
+	}
+	if _, err := Import("nope", "src", tree, Options{}); err != ErrUnknownFormat {
+		t.Fatalf("expected %v == %v", err, ErrUnknownFormat)
+	}
+	if _, err := Export("nope", tree, "dst", Options{}); err != ErrUnknownFormat {
+		t.Fatalf("expected %v == %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestTextFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tree := newTree(t)
+	records := testRecords(10)
+	Load(tree, sliceRecords(records))
+	opts := Options{Values: bplus.Base64Text}
+	for _, name := range []string{"csv", "jsonl"} {
+		path := filepath.Join(dir, name)
+		if n, err := Export(name, tree, path, opts); err != nil || n != len(records) {
+			t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+		}
+		other := newTree(t)
+		if n, err := Import(name, path, other, opts); err != nil || n != len(records) {
+			t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+		}
+		if !reflect.DeepEqual(treeRecords(t, other), records) {
+			t.Fatalf("expected the %s copy to match the tree", name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+//go:build leveldb
+
+package convert
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func init() {
+	formats["leveldb"] = format{importFile: ImportLevelDB, exportFile: ExportLevelDB}
+}
+
+// ImportLevelDB copies the records of the LevelDB database in the directory path into
+// tree. It's only built with the leveldb tag.
+func ImportLevelDB(path string, tree *bplus.Tree, _ Options) (int, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	n, err := Load(tree, func(fn func(key, value []byte) error) error {
+		for it.Next() {
+			if err := fn(it.Key(), it.Value()); err != nil {
+				return err
+			}
+		}
+		return it.Error()
+	})
+	return n, err
+}
+
+// ExportLevelDB copies the records of tree into the LevelDB database in the directory
+// path, creating it if it doesn't exist yet. It's only built with the leveldb tag.
+func ExportLevelDB(tree *bplus.Tree, path string, _ Options) (int, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return 0, err
+	}
+	n, err := Dump(tree, func(records []bplus.Record) error {
+		b := new(leveldb.Batch)
+		for _, r := range records {
+			b.Put(r.Key, r.Value)
+		}
+		return db.Write(b, &opt.WriteOptions{Sync: true})
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
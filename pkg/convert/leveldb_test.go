@@ -0,0 +1,95 @@
+//go:build leveldb
+
+package convert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// levelDBRecords returns every record of the LevelDB database in the directory path, in
+// order.
+func levelDBRecords(t *testing.T, path string) []bplus.Record {
+	t.Helper()
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var records []bplus.Record
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		records = append(records, bplus.Record{
+			Key:   append(bplus.Key{}, it.Key()...),
+			Value: append(bplus.Value{}, it.Value()...),
+		})
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestLevelDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert_leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	records := testRecords(2*batchSize + 10)
+
+	src := filepath.Join(dir, "src")
+	db, err := leveldb.OpenFile(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := db.Put(r.Key, r.Value, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := newTree(t)
+	if n, err := Import("leveldb", src, tree, Options{}); err != nil || n != len(records) {
+		t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+	}
+	if !reflect.DeepEqual(treeRecords(t, tree), records) {
+		t.Fatalf("expected the imported records to match the database's")
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if n, err := Export("leveldb", tree, dst, Options{}); err != nil || n != len(records) {
+		t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+	}
+	if !reflect.DeepEqual(levelDBRecords(t, dst), records) {
+		t.Fatalf("expected the exported records to match the tree's")
+	}
+}
+
+func TestLevelDBMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert_leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tree := newTree(t)
+	// Importing a database that doesn't exist fails rather than creating it.
+	path := filepath.Join(dir, "missing")
+	if _, err := Import("leveldb", path, tree, Options{}); err == nil {
+		t.Fatal("expected importing a missing database to fail")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected %v to be left missing, got %v", path, err)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build pebble
+
+package convert
+
+import (
+	"github.com/cockroachdb/pebble"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func init() {
+	formats["pebble"] = format{importFile: ImportPebble, exportFile: ExportPebble}
+}
+
+// ImportPebble copies the records of the Pebble database in the directory path into
+// tree. It's only built with the pebble tag.
+func ImportPebble(path string, tree *bplus.Tree, _ Options) (int, error) {
+	db, err := pebble.Open(path, &pebble.Options{ReadOnly: true, ErrorIfNotExists: true})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	it, err := db.NewIter(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	return Load(tree, func(fn func(key, value []byte) error) error {
+		for valid := it.First(); valid; valid = it.Next() {
+			value, err := it.ValueAndErr()
+			if err != nil {
+				return err
+			}
+			if err := fn(it.Key(), value); err != nil {
+				return err
+			}
+		}
+		return it.Error()
+	})
+}
+
+// ExportPebble copies the records of tree into the Pebble database in the directory
+// path, creating it if it doesn't exist yet. It's only built with the pebble tag.
+func ExportPebble(tree *bplus.Tree, path string, _ Options) (int, error) {
+	db, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return 0, err
+	}
+	n, err := Dump(tree, func(records []bplus.Record) error {
+		b := db.NewBatch()
+		defer b.Close()
+		for _, r := range records {
+			if err := b.Set(r.Key, r.Value, nil); err != nil {
+				return err
+			}
+		}
+		return b.Commit(pebble.Sync)
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
@@ -0,0 +1,95 @@
+//go:build pebble
+
+package convert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// pebbleRecords returns every record of the Pebble database in the directory path, in
+// order.
+func pebbleRecords(t *testing.T, path string) []bplus.Record {
+	t.Helper()
+	db, err := pebble.Open(path, &pebble.Options{ErrorIfNotExists: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	it, err := db.NewIter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var records []bplus.Record
+	for valid := it.First(); valid; valid = it.Next() {
+		records = append(records, bplus.Record{
+			Key:   append(bplus.Key{}, it.Key()...),
+			Value: append(bplus.Value{}, it.Value()...),
+		})
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestPebble(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert_pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	records := testRecords(2*batchSize + 10)
+
+	src := filepath.Join(dir, "src")
+	db, err := pebble.Open(src, &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := db.Set(r.Key, r.Value, pebble.NoSync); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := newTree(t)
+	if n, err := Import("pebble", src, tree, Options{}); err != nil || n != len(records) {
+		t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+	}
+	if !reflect.DeepEqual(treeRecords(t, tree), records) {
+		t.Fatalf("expected the imported records to match the database's")
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if n, err := Export("pebble", tree, dst, Options{}); err != nil || n != len(records) {
+		t.Fatalf("expected %v == %v, got %v", n, len(records), err)
+	}
+	if !reflect.DeepEqual(pebbleRecords(t, dst), records) {
+		t.Fatalf("expected the exported records to match the tree's")
+	}
+}
+
+func TestPebbleMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert_pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tree := newTree(t)
+	// Importing a database that doesn't exist fails rather than creating it.
+	path := filepath.Join(dir, "missing")
+	if _, err := Import("pebble", path, tree, Options{}); err == nil {
+		t.Fatal("expected importing a missing database to fail")
+	}
+}
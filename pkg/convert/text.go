@@ -0,0 +1,60 @@
+package convert
+
+import (
+	"io"
+	"os"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func init() {
+	formats["csv"] = format{
+		importFile: importText((*bplus.Tree).ImportCSV),
+		exportFile: exportText((*bplus.Tree).ExportCSV),
+	}
+	formats["jsonl"] = format{
+		importFile: importText((*bplus.Tree).ImportJSONL),
+		exportFile: exportText((*bplus.Tree).ExportJSONL),
+	}
+}
+
+// importText returns the import of a text format, which reads the file at path, or
+// standard input if path is "-".
+func importText(
+	read func(*bplus.Tree, io.Reader, bplus.TextFormat) (int, error),
+) func(string, *bplus.Tree, Options) (int, error) {
+	return func(path string, tree *bplus.Tree, opts Options) (int, error) {
+		f := bplus.TextFormat{Keys: opts.Keys, Values: opts.Values}
+		if path == "-" {
+			return read(tree, os.Stdin, f)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		return read(tree, file, f)
+	}
+}
+
+// exportText returns the export of a text format, which writes the file at path, or
+// standard output if path is "-".
+func exportText(
+	write func(*bplus.Tree, io.Writer, bplus.TextFormat) (int, error),
+) func(*bplus.Tree, string, Options) (int, error) {
+	return func(tree *bplus.Tree, path string, opts Options) (int, error) {
+		f := bplus.TextFormat{Keys: opts.Keys, Values: opts.Values}
+		if path == "-" {
+			return write(tree, os.Stdout, f)
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			return 0, err
+		}
+		n, err := write(tree, file, f)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return n, err
+	}
+}
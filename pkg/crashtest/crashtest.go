@@ -0,0 +1,212 @@
+// Package crashtest crash tests the way a program uses a tree.
+//
+// Run runs a workload of steps against a tree kept on a store.CrashBackend, in
+// copy-on-write mode or with a write-ahead log, and crashes it part way through, once for
+// each write the workload makes to the disk, or for as many of them as it's asked to.
+// After each crash, what was left on the disk is reopened, the tree is verified, and a
+// check supplied with the workload decides whether the tree holds what the steps
+// completed before the crash should have left in it. Crashes are drawn from a seed, so a
+// failing crash can be run again exactly.
+package crashtest
+
+import (
+	"fmt"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Step is one step of a workload, such as an insert or a batch of them.
+type Step func(tree *bplus.Tree) error
+
+// Crash describes a crash the workload was put through.
+type Crash struct {
+	// Seed is the seed of the backend that crashed, which decides what was kept of the
+	// writes that hadn't been synced.
+	Seed int64
+	// Write is the write the backend crashed at, counted from the start of the first
+	// step.
+	Write int
+	// Completed is the number of steps that returned before the crash. The step after
+	// them was interrupted by it, so its changes may or may not have survived.
+	Completed int
+}
+
+// Check checks the tree recovered from a crash, returning an error if it doesn't hold
+// what the steps completed before the crash should have left in it.
+type Check func(tree *bplus.Tree, crash Crash) error
+
+// Config configures Run.
+type Config struct {
+	// Seed seeds the crashes. The crash at each write is seeded with Seed plus the write.
+	Seed int64
+	// Crashes is the most crashes to run, spread evenly over the writes the workload
+	// makes. Zero crashes the workload at every write.
+	Crashes int
+	// Options are used to create the tree and to reopen it after a crash, such as
+	// bplus.WithCheckpoints.
+	Options []bplus.Option
+	// WAL writes the tree through a write-ahead log, kept in a second file on the disk
+	// that crashes along with the tree's, see store.CrashBackend.NewFile. Otherwise the
+	// tree is in copy-on-write mode.
+	WAL bool
+	// TornPages crashes the disk part way through writing a page, as well as between
+	// the pages of a write. Copy-on-write mode relies on pages being written atomically,
+	// as a double-write buffer can't be used without a file, so the header of a tree
+	// torn part way through a commit can't be recovered.
+	TornPages bool
+}
+
+// Failure is returned by Run when a tree couldn't be recovered from a crash, failed to
+// verify, or failed the workload's check.
+type Failure struct {
+	Crash Crash
+	Err   error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("crash at write %d after %d steps with seed %d: %v",
+		f.Crash.Write, f.Crash.Completed, f.Crash.Seed, f.Err)
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Run runs the steps once without crashing to count the writes they make, then again
+// for each crash, and returns the first Failure, or nil if the tree recovered from every
+// crash.
+func Run(config Config, steps []Step, check Check) error {
+	backend, _, tree, err := create(config, config.Seed)
+	if err != nil {
+		return err
+	}
+	start := backend.Writes()
+	for i, step := range steps {
+		err = step(tree)
+		if err != nil {
+			tree.Close()
+			return stepFailed(i, err)
+		}
+	}
+	writes := backend.Writes() - start
+	err = tree.Close()
+	if err != nil {
+		return err
+	}
+	for _, write := range crashPoints(writes, config.Crashes) {
+		err = crash(config, steps, check, write)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepFailed returns the error for a step that failed without the backend crashing, which
+// the workload has to be fixed for.
+func stepFailed(step int, err error) error {
+	return fmt.Errorf("step %d failed without a crash: %v", step, err)
+}
+
+// crashPoints returns the writes to crash at, out of the writes the workload makes.
+func crashPoints(writes, crashes int) []int {
+	if crashes <= 0 || crashes > writes {
+		crashes = writes
+	}
+	points := make([]int, crashes)
+	for i := range points {
+		points[i] = 1 + i*writes/crashes
+	}
+	return points
+}
+
+// create creates a tree on a new backend that crashes the way seed draws, in
+// copy-on-write mode or with its write-ahead log in a second file on the same disk,
+// which is returned along with the backend.
+func create(
+	config Config,
+	seed int64,
+) (*store.CrashBackend, *store.CrashBackend, *bplus.Tree, error) {
+	atomic := store.PageSize
+	if config.TornPages {
+		atomic = store.SectorSize
+	}
+	backend := store.NewCrashBackend(seed, atomic)
+	var wal *store.CrashBackend
+	opts := config.Options
+	if config.WAL {
+		wal = backend.NewFile()
+		opts = withWAL(opts, wal)
+	}
+	tree, err := bplus.NewBackendTree(backend, opts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if config.WAL {
+		err = tree.EnableWAL()
+	} else {
+		err = tree.EnableCOW()
+	}
+	if err == nil {
+		err = tree.Sync()
+	}
+	if err != nil {
+		tree.Close()
+		return nil, nil, nil, err
+	}
+	return backend, wal, tree, nil
+}
+
+// withWAL returns the options with the write-ahead log kept in wal.
+func withWAL(opts []bplus.Option, wal store.Backend) []bplus.Option {
+	return append(opts[:len(opts):len(opts)], bplus.WithWALBackend(wal))
+}
+
+// crash runs the steps until the backend crashes at write, then checks the tree
+// recovered from what was left on the disk.
+func crash(config Config, steps []Step, check Check, write int) error {
+	seed := config.Seed + int64(write)
+	backend, wal, tree, err := create(config, seed)
+	if err != nil {
+		return err
+	}
+	backend.CrashAt(write)
+	c := Crash{Seed: seed, Write: write}
+	for i, step := range steps {
+		err = step(tree)
+		if err != nil && !backend.Crashed() {
+			tree.Close()
+			return &Failure{Crash: c, Err: stepFailed(i, err)}
+		}
+		if err != nil {
+			break
+		}
+		c.Completed++
+	}
+	// The tree can't be closed cleanly once the backend has crashed, which is the point.
+	tree.Close()
+	disk := backend.Crash()
+	opts := config.Options
+	if wal != nil {
+		opts = withWAL(opts, wal.Crash())
+	}
+	recovered, err := bplus.OpenBackendTree(disk, opts...)
+	if err != nil {
+		return &Failure{Crash: c, Err: err}
+	}
+	defer recovered.Close()
+	report, err := recovered.Verify()
+	if err != nil {
+		return &Failure{Crash: c, Err: err}
+	}
+	if !report.OK() {
+		v := report.Violations[0]
+		return &Failure{Crash: c, Err: fmt.Errorf("page %d: %s", v.PageID, v.Reason)}
+	}
+	err = check(recovered, c)
+	if err != nil {
+		return &Failure{Crash: c, Err: err}
+	}
+	return nil
+}
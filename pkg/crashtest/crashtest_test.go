@@ -0,0 +1,176 @@
+package crashtest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func key(i int) bplus.Key {
+	return binary.BigEndian.AppendUint32(nil, uint32(i))
+}
+
+// workload inserts n keys and then deletes every third one, returning the steps along
+// with the keys the tree should hold after each number of them.
+func workload(n int) ([]Step, []map[int]bool) {
+	var steps []Step
+	states := []map[int]bool{{}}
+	next := func(change func(keys map[int]bool)) {
+		keys := map[int]bool{}
+		for k := range states[len(states)-1] {
+			keys[k] = true
+		}
+		change(keys)
+		states = append(states, keys)
+	}
+	for i := 0; i < n; i++ {
+		steps = append(steps, func(tree *bplus.Tree) error {
+			return tree.Insert(key(i), bplus.Value(key(i)))
+		})
+		next(func(keys map[int]bool) { keys[i] = true })
+	}
+	for i := 0; i < n; i += 3 {
+		steps = append(steps, func(tree *bplus.Tree) error {
+			return tree.Delete(key(i))
+		})
+		next(func(keys map[int]bool) { delete(keys, i) })
+	}
+	return steps, states
+}
+
+// holds returns an error unless the tree holds exactly the keys.
+func holds(tree *bplus.Tree, keys map[int]bool) error {
+	found := 0
+	it := tree.Ascend(nil)
+	for k, v := range it.Records() {
+		i := int(binary.BigEndian.Uint32(k))
+		if !keys[i] || string(v) != string(k) {
+			return fmt.Errorf("unexpected record %d", i)
+		}
+		found++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if found != len(keys) {
+		return fmt.Errorf("expected %d records, found %d", len(keys), found)
+	}
+	return nil
+}
+
+// committed returns a check that every completed step was committed, and that the step
+// the crash interrupted may have been too, counting the crashes checked.
+func committed(steps []Step, states []map[int]bool, checked *int) Check {
+	return func(tree *bplus.Tree, crash Crash) error {
+		*checked++
+		err := holds(tree, states[crash.Completed])
+		if err != nil && crash.Completed < len(steps) {
+			err = holds(tree, states[crash.Completed+1])
+		}
+		return err
+	}
+}
+
+func TestRun(t *testing.T) {
+	steps, states := workload(40)
+	checked := 0
+	config := Config{Seed: 1, Options: []bplus.Option{bplus.WithBranchingFactor(4)}}
+	err := Run(config, steps, committed(steps, states, &checked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked < len(steps) {
+		t.Fatalf("expected at least %v crashes, got %v", len(steps), checked)
+	}
+}
+
+func TestRunWAL(t *testing.T) {
+	steps, states := workload(40)
+	checked := 0
+	config := Config{
+		Seed:      1,
+		Options:   []bplus.Option{bplus.WithBranchingFactor(4)},
+		WAL:       true,
+		TornPages: true,
+	}
+	err := Run(config, steps, committed(steps, states, &checked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked < len(steps) {
+		t.Fatalf("expected at least %v crashes, got %v", len(steps), checked)
+	}
+}
+
+func TestRunWALCheckpoints(t *testing.T) {
+	steps, states := workload(40)
+	checked := 0
+	config := Config{
+		Seed: 1,
+		Options: []bplus.Option{
+			bplus.WithBranchingFactor(4),
+			// Checkpoint every few steps, so that crashes land before, during and
+			// after checkpoints.
+			bplus.WithCheckpoints(8*store.PageSize, 0),
+		},
+		WAL: true,
+	}
+	err := Run(config, steps, committed(steps, states, &checked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked < len(steps) {
+		t.Fatalf("expected at least %v crashes, got %v", len(steps), checked)
+	}
+}
+
+func TestRunCrashes(t *testing.T) {
+	steps, _ := workload(40)
+	var writes []int
+	config := Config{Seed: 1, Crashes: 10}
+	err := Run(config, steps, func(tree *bplus.Tree, crash Crash) error {
+		writes = append(writes, crash.Write)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 10 || writes[0] != 1 {
+		t.Fatalf("expected 10 crashes starting at the first write, got %v", writes)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	steps, _ := workload(10)
+	broken := errors.New("broken")
+	err := Run(Config{Seed: 7}, steps, func(tree *bplus.Tree, crash Crash) error {
+		if crash.Write == 3 {
+			return broken
+		}
+		return nil
+	})
+	var failure *Failure
+	if !errors.As(err, &failure) || failure.Crash.Write != 3 || failure.Crash.Seed != 10 {
+		t.Fatalf("expected a failure at the third write, got %v", err)
+	}
+	if !errors.Is(err, broken) {
+		t.Fatalf("expected %v == %v", err, broken)
+	}
+}
+
+func TestRunStepFailure(t *testing.T) {
+	steps := []Step{func(tree *bplus.Tree) error {
+		return tree.Delete(key(1))
+	}}
+	err := Run(Config{}, steps, func(tree *bplus.Tree, crash Crash) error {
+		return nil
+	})
+	expected := "step 0 failed without a crash: key not found"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %v == %v", err, expected)
+	}
+}
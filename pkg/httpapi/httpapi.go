@@ -0,0 +1,240 @@
+// Package httpapi serves a B+ tree from package bplus over HTTP as a REST API, for quick
+// integrations and debugging. A Handler can be mounted into an existing mux under a
+// prefix with http.StripPrefix:
+//
+//	mux.Handle("/tree/", http.StripPrefix("/tree", httpapi.NewHandler(tree)))
+//
+// It serves:
+//
+//	GET    /keys/{key}  the value of key, as the body of the response
+//	PUT    /keys/{key}  sets the value of key to the body of the request
+//	DELETE /keys/{key}  deletes key
+//	GET    /keys        the records in a range, see below
+//	GET    /stats       statistics about the tree, see Stats
+//
+// Keys in paths and query parameters are the key's bytes, escaped the way URLs are. The
+// range of records listed by GET /keys is given by the query parameters start, the
+// smallest key to list, end, the key to stop before, and prefix, which lists the keys
+// that start with it. At most limit records are listed, DefaultLimit unless it's given,
+// with the next key to start from in the response if there are more. Records are listed
+// as JSON, see Range, in which keys and values are encoded in base64.
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+const (
+	// DefaultLimit is the number of records listed by GET /keys unless a limit is given.
+	DefaultLimit = 100
+	// MaxLimit is the most records GET /keys lists, whatever the limit given.
+	MaxLimit = 10000
+	// MaxValueSize is the largest value that can be PUT, in bytes.
+	MaxValueSize = 64 << 20
+)
+
+// Record is a record listed by GET /keys.
+type Record struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Range is the response to GET /keys.
+type Range struct {
+	Records []Record `json:"records"`
+	// Next is the key to start from to list the rest of the range, which is only set if
+	// the listing stopped at the limit.
+	Next []byte `json:"next,omitempty"`
+}
+
+// Stats is the response to GET /stats.
+type Stats struct {
+	// Len is the number of records in the tree.
+	Len int `json:"len"`
+	// Height is the number of levels in the tree.
+	Height int `json:"height"`
+	// LSN is the log sequence number of the last group of writes committed.
+	LSN   uint64           `json:"lsn"`
+	File  store.Stats      `json:"file"`
+	Cache store.CacheStats `json:"cache"`
+}
+
+// Handler serves a tree over HTTP, see the package documentation.
+type Handler struct {
+	tree *bplus.Tree
+}
+
+// NewHandler returns a handler serving tree.
+func NewHandler(tree *bplus.Tree) *Handler {
+	return &Handler{tree: tree}
+}
+
+// ServeHTTP serves a request. Requests are routed by hand rather than with the patterns
+// of http.ServeMux, which older GODEBUG settings turn off.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case path == "/keys":
+		if allow(w, req, "GET") {
+			h.list(w, req)
+		}
+	case path == "/stats":
+		if allow(w, req, "GET") {
+			h.stats(w, req)
+		}
+	case strings.HasPrefix(path, "/keys/"):
+		if !allow(w, req, "GET", "PUT", "DELETE") {
+			return
+		}
+		key := bplus.Key(strings.TrimPrefix(path, "/keys/"))
+		switch req.Method {
+		case "GET":
+			h.get(w, key)
+		case "PUT":
+			h.put(w, req, key)
+		case "DELETE":
+			h.delete(w, key)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// allow returns whether the request uses one of the methods, and responds that it isn't
+// allowed otherwise.
+func allow(w http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if req.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+func (h *Handler) get(w http.ResponseWriter, key bplus.Key) {
+	value, err := h.tree.Read(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
+}
+
+func (h *Handler) put(w http.ResponseWriter, req *http.Request, key bplus.Key) {
+	value, err := io.ReadAll(http.MaxBytesReader(w, req.Body, MaxValueSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.tree.Upsert(key, value)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, key bplus.Key) {
+	err := h.tree.Delete(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) list(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	limit := DefaultLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, MaxLimit)
+	}
+	start := []byte(query.Get("start"))
+	prefix := []byte(query.Get("prefix"))
+	if bytes.Compare(prefix, start) > 0 {
+		start = prefix
+	}
+	var end []byte
+	if query.Has("end") {
+		end = []byte(query.Get("end"))
+	}
+	r := Range{Records: []Record{}}
+	it := h.tree.Ascend(start)
+	for key, value := range it.Records() {
+		if !bytes.HasPrefix(key, prefix) || end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		if len(r.Records) == limit {
+			r.Next = key
+			break
+		}
+		r.Records = append(r.Records, Record{Key: key, Value: value})
+	}
+	if err := it.Err(); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, req *http.Request) {
+	height, err := h.tree.Height()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	file, err := h.tree.Stats()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, Stats{
+		Len:    h.tree.Len(),
+		Height: height,
+		LSN:    h.tree.LSN(),
+		File:   file,
+		Cache:  h.tree.CacheStats(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with the status that best describes err.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case bplus.ErrKeyNotFound:
+		status = http.StatusNotFound
+	case bplus.ErrKeyTooLarge, bplus.ErrRecordTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case store.ErrReadOnly:
+		status = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newServer(t *testing.T) (*bplus.Tree, *httptest.Server) {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", "httpapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	tree, err := bplus.NewTree(tmpfile.Name(), bplus.WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	// The handler is mounted under a prefix, the way it would be in an existing mux.
+	mux := http.NewServeMux()
+	mux.Handle("/tree/", http.StripPrefix("/tree", NewHandler(tree)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return tree, server
+}
+
+// do sends a request and returns the status and body of the response.
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestKeys(t *testing.T) {
+	tree, server := newServer(t)
+	url := server.URL + "/tree/keys/users/1%3F"
+	if status, _ := do(t, "GET", url, ""); status != http.StatusNotFound {
+		t.Fatalf("expected %v == %v", status, http.StatusNotFound)
+	}
+	if status, _ := do(t, "PUT", url, "alice"); status != http.StatusNoContent {
+		t.Fatalf("expected %v == %v", status, http.StatusNoContent)
+	}
+	// Keys are the unescaped path, slashes and all.
+	value, err := tree.Read(bplus.Key("users/1?"))
+	if err != nil || string(value) != "alice" {
+		t.Fatalf("expected %q == %q, got %v", value, "alice", err)
+	}
+	status, body := do(t, "GET", url, "")
+	if status != http.StatusOK || body != "alice" {
+		t.Fatalf("expected %v == %v and %q == %q", status, http.StatusOK, body, "alice")
+	}
+	if status, _ := do(t, "DELETE", url, ""); status != http.StatusNoContent {
+		t.Fatalf("expected %v == %v", status, http.StatusNoContent)
+	}
+	if status, _ := do(t, "DELETE", url, ""); status != http.StatusNotFound {
+		t.Fatalf("expected %v == %v", status, http.StatusNotFound)
+	}
+	if status, _ := do(t, "POST", url, ""); status != http.StatusMethodNotAllowed {
+		t.Fatalf("expected %v == %v", status, http.StatusMethodNotAllowed)
+	}
+	big := strings.Repeat("x", 8192)
+	if status, _ := do(t, "PUT", url, big); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected %v == %v", status, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func listKeys(t *testing.T, server *httptest.Server, query string) ([]string, string) {
+	t.Helper()
+	status, body := do(t, "GET", server.URL+"/tree/keys?"+query, "")
+	if status != http.StatusOK {
+		t.Fatalf("expected %v == %v: %s", status, http.StatusOK, body)
+	}
+	var r Range
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, record := range r.Records {
+		if string(record.Value) != "v"+string(record.Key) {
+			t.Fatalf("expected %q == %q", record.Value, "v"+string(record.Key))
+		}
+		keys = append(keys, string(record.Key))
+	}
+	return keys, string(r.Next)
+}
+
+func TestRange(t *testing.T) {
+	tree, server := newServer(t)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("%c%d", 'a'+i%3, i/3)
+		if err := tree.Insert(bplus.Key(key), bplus.Value("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		query string
+		keys  string
+		next  string
+	}{
+		{"limit=3", "a0 a1 a2", "a3"},
+		{"start=a8&limit=3", "a8 a9 b0", "b1"},
+		{"start=b5&end=b8", "b5 b6 b7", ""},
+		{"prefix=c&start=c7", "c7 c8 c9", ""},
+		{"prefix=b&limit=10", "b0 b1 b2 b3 b4 b5 b6 b7 b8 b9", ""},
+		{"start=d", "", ""},
+	} {
+		keys, next := listKeys(t, server, test.query)
+		if strings.Join(keys, " ") != test.keys || next != test.next {
+			t.Fatalf("expected %v == %v and %q == %q for %s",
+				keys, test.keys, next, test.next, test.query)
+		}
+	}
+	if status, _ := do(t, "GET", server.URL+"/tree/keys?limit=x", ""); status != 400 {
+		t.Fatalf("expected %v == %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestStats(t *testing.T) {
+	tree, server := newServer(t)
+	for i := 0; i < 20; i++ {
+		if err := tree.Insert(bplus.Key(fmt.Sprint(i)), bplus.Value("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, body := do(t, "GET", server.URL+"/tree/stats", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected %v == %v", status, http.StatusOK)
+	}
+	var stats Stats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Len != 20 || stats.Height < 2 || stats.File.Pages == 0 {
+		t.Fatalf("expected stats of a tree of 20 records, got %+v", stats)
+	}
+}
@@ -0,0 +1,240 @@
+// Package index maintains secondary indexes over a B+ tree from package bplus, so that
+// records can be found by something other than their key.
+package index
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/keyencode"
+)
+
+var (
+	// ErrIndexExists is returned when adding an index with the name of an existing index.
+	ErrIndexExists = errors.New("index already exists")
+	// ErrIndexNotFound is returned when scanning an index that hasn't been added.
+	ErrIndexNotFound = errors.New("index not found")
+)
+
+// Extractor returns the secondary key a value is indexed under, or false if the value
+// shouldn't be indexed at all. It must return the same key every time it's given the
+// same value.
+type Extractor func(value bplus.Value) (bplus.Key, bool)
+
+// Indexed is a primary tree together with its secondary indexes. Every write to the
+// primary tree must go through Indexed to keep the indexes consistent with it, while
+// reads can go straight to the primary tree. Writes through Indexed are made one at a
+// time, and a write that fails part way through takes back what it wrote to the primary
+// tree and the indexes before returning.
+//
+// Each index is a tree of its own which holds an empty value under the secondary key of
+// every record, encoded with keyencode.AppendBytes, followed by the record's primary key.
+// Records with the same secondary key are kept in the order of their primary keys.
+type Indexed struct {
+	// mu serializes the writes, and guards indexes.
+	mu      sync.Mutex
+	primary *bplus.Tree
+	indexes map[string]*index
+}
+
+type index struct {
+	tree    *bplus.Tree
+	extract Extractor
+}
+
+// New returns a primary tree without any indexes.
+func New(primary *bplus.Tree) *Indexed {
+	return &Indexed{primary: primary, indexes: map[string]*index{}}
+}
+
+// Primary returns the primary tree.
+func (t *Indexed) Primary() *bplus.Tree {
+	return t.primary
+}
+
+// AddIndex adds an index over the primary tree which is stored in tree. If tree is empty
+// it's filled in from the records already in the primary tree, otherwise it's assumed
+// to be an index that was built with the same extractor before the trees were reopened.
+func (t *Indexed) AddIndex(name string, tree *bplus.Tree, extract Extractor) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.indexes[name]; ok {
+		return ErrIndexExists
+	}
+	idx := &index{tree: tree, extract: extract}
+	if tree.Len() == 0 {
+		var batch bplus.WriteBatch
+		it := t.primary.All()
+		for key, value := range it.Records() {
+			if secondary, ok := extract(value); ok {
+				batch.Put(indexKey(secondary, key), nil)
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+		err := tree.Apply(&batch)
+		if err != nil {
+			return err
+		}
+	}
+	t.indexes[name] = idx
+	return nil
+}
+
+// Insert a key value pair into the primary tree and its indexes. Duplicate keys are not
+// allowed.
+func (t *Indexed) Insert(key bplus.Key, value bplus.Value) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.insert(key, value)
+}
+
+// insert is Insert with the lock held.
+func (t *Indexed) insert(key bplus.Key, value bplus.Value) error {
+	return t.write(key, nil, false, value, true,
+		func() error { return t.primary.Insert(key, value) },
+		func() error { return t.primary.Delete(key) },
+	)
+}
+
+// Upsert inserts a key value pair into the primary tree, overwriting the value if the key
+// is already present, and moves the record in every index whose secondary key changed.
+func (t *Indexed) Upsert(key bplus.Key, value bplus.Value) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old, err := t.primary.Read(key)
+	if err == bplus.ErrKeyNotFound {
+		return t.insert(key, value)
+	}
+	if err != nil {
+		return err
+	}
+	return t.write(key, old, true, value, true,
+		func() error { return t.primary.Upsert(key, value) },
+		func() error { return t.primary.Upsert(key, old) },
+	)
+}
+
+// Delete a key value pair from the primary tree and its indexes, return
+// bplus.ErrKeyNotFound if it's not found.
+func (t *Indexed) Delete(key bplus.Key) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old, err := t.primary.Read(key)
+	if err != nil {
+		return err
+	}
+	return t.write(key, old, true, nil, false,
+		func() error { return t.primary.Delete(key) },
+		func() error { return t.primary.Insert(key, old) },
+	)
+}
+
+// write changes the record of key in the primary tree with writePrimary, from old, if
+// hadOld is set, to value, if hasValue is set, and then moves the record in every index
+// whose secondary key changed. If any of it fails, the indexes already written are put
+// back and the primary tree is put back with undoPrimary.
+func (t *Indexed) write(
+	key bplus.Key,
+	old bplus.Value, hadOld bool,
+	value bplus.Value, hasValue bool,
+	writePrimary, undoPrimary func() error,
+) error {
+	err := writePrimary()
+	if err != nil {
+		return err
+	}
+	var written []indexWrite
+	for _, idx := range t.indexes {
+		w := indexWrite{tree: idx.tree}
+		if hadOld {
+			if secondary, ok := idx.extract(old); ok {
+				w.remove = indexKey(secondary, key)
+			}
+		}
+		if hasValue {
+			if secondary, ok := idx.extract(value); ok {
+				w.add = indexKey(secondary, key)
+			}
+		}
+		// Index keys are never empty, so this only holds if the record stays put.
+		if string(w.remove) == string(w.add) {
+			continue
+		}
+		err = w.apply(false)
+		if err != nil {
+			return errors.Join(err, undo(written, undoPrimary))
+		}
+		written = append(written, w)
+	}
+	return nil
+}
+
+// undo puts back the indexes written by a write that failed, most recent first, and then
+// the primary tree.
+func undo(written []indexWrite, undoPrimary func() error) error {
+	for i := len(written) - 1; i >= 0; i-- {
+		err := written[i].apply(true)
+		if err != nil {
+			return err
+		}
+	}
+	return undoPrimary()
+}
+
+// indexWrite moves the record of a key in an index, removing it from remove and adding it
+// at add, either of which is nil if the record isn't indexed there.
+type indexWrite struct {
+	tree        *bplus.Tree
+	remove, add bplus.Key
+}
+
+// apply makes the move in a single batch, or takes it back if undo is set.
+func (w indexWrite) apply(undo bool) error {
+	remove, add := w.remove, w.add
+	if undo {
+		remove, add = add, remove
+	}
+	var batch bplus.WriteBatch
+	if remove != nil {
+		batch.Delete(remove)
+	}
+	if add != nil {
+		batch.Put(add, nil)
+	}
+	return w.tree.Apply(&batch)
+}
+
+// IndexScan returns a sequence of the keys and values in the primary tree of every record
+// indexed under secondary in the named index, in ascending primary key order.
+func (t *Indexed) IndexScan(name string, secondary bplus.Key) (*bplus.Iter, error) {
+	t.mu.Lock()
+	idx, ok := t.indexes[name]
+	t.mu.Unlock()
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	prefix := keyencode.AppendBytes(nil, secondary)
+	return bplus.NewIter(func(yield func(bplus.Key, bplus.Value) bool) error {
+		it := idx.tree.ScanPrefix(prefix)
+		for k := range it.Records() {
+			key := bplus.Key(k[len(prefix):])
+			value, err := t.primary.Read(key)
+			if err != nil {
+				return err
+			}
+			if !yield(key, value) {
+				break
+			}
+		}
+		return it.Err()
+	}), nil
+}
+
+// indexKey returns the key of the record of key in an index, which is never a prefix of
+// the keys of records with a different secondary key.
+func indexKey(secondary, key bplus.Key) bplus.Key {
+	return append(keyencode.AppendBytes(nil, secondary), key...)
+}
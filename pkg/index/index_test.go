@@ -0,0 +1,270 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// Values in the tests are a one byte color followed by a four byte size.
+func color(value bplus.Value) (bplus.Key, bool) {
+	return bplus.Key(value[:1]), true
+}
+
+// size only indexes values with a non-zero size.
+func size(value bplus.Value) (bplus.Key, bool) {
+	if binary.BigEndian.Uint32(value[1:]) == 0 {
+		return nil, false
+	}
+	return bplus.Key(value[1:]), true
+}
+
+func TestIndexed(t *testing.T) {
+	tree := New(newTree(t, "indexed_primary"))
+	if err := tree.AddIndex("color", newTree(t, "indexed_color"), color); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.AddIndex("size", newTree(t, "indexed_size"), size); err != nil {
+		t.Fatal(err)
+	}
+	err := tree.AddIndex("size", newTree(t, "indexed_size"), size)
+	if err != ErrIndexExists {
+		t.Fatalf("expected %v == %v", err, ErrIndexExists)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), record(byte(i%3), i%10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Insert(intKey(0), record(0, 0)); err != bplus.ErrDuplicateKey {
+		t.Fatalf("expected %v == %v", err, bplus.ErrDuplicateKey)
+	}
+	expectScan(t, tree, "color", bplus.Key{1}, every(1, 100, 3))
+	expectScan(t, tree, "size", sizeKey(4), every(4, 100, 10))
+	expectScan(t, tree, "size", sizeKey(0), nil)
+
+	// Moving a record to another color takes it out of the old color's index.
+	if err := tree.Upsert(intKey(1), record(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	expectScan(t, tree, "color", bplus.Key{1}, every(4, 100, 3))
+	expectScan(t, tree, "size", sizeKey(1), every(1, 100, 10))
+	// A zero size takes a record out of the size index altogether.
+	if err := tree.Upsert(intKey(4), record(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	expectScan(t, tree, "size", sizeKey(4), every(14, 100, 10))
+	if err := tree.Upsert(intKey(100), record(1, 4)); err != nil {
+		t.Fatal(err)
+	}
+	expectScan(t, tree, "size", sizeKey(4), append(every(14, 100, 10), 100))
+
+	for i := 0; i < 100; i += 2 {
+		if err := tree.Delete(intKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Delete(intKey(0)); err != bplus.ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, bplus.ErrKeyNotFound)
+	}
+	expectScan(t, tree, "size", sizeKey(3), every(3, 100, 10))
+	expectScan(t, tree, "size", sizeKey(4), []int{100})
+	if _, err := tree.IndexScan("weight", bplus.Key{1}); err != ErrIndexNotFound {
+		t.Fatalf("expected %v == %v", err, ErrIndexNotFound)
+	}
+}
+
+func TestAddIndexBuildsFromPrimary(t *testing.T) {
+	tree := New(newTree(t, "add_index_primary"))
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert(intKey(i), record(byte(i%3), i%10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	colors := newTree(t, "add_index_color")
+	if err := tree.AddIndex("color", colors, color); err != nil {
+		t.Fatal(err)
+	}
+	if colors.Len() != 100 {
+		t.Fatalf("expected %v == %v", colors.Len(), 100)
+	}
+	expectScan(t, tree, "color", bplus.Key{2}, every(2, 100, 3))
+
+	// Adding an index that's already filled in leaves it as it is.
+	reopened := New(tree.Primary())
+	if err := reopened.AddIndex("color", colors, color); err != nil {
+		t.Fatal(err)
+	}
+	if colors.Len() != 100 {
+		t.Fatalf("expected %v == %v", colors.Len(), 100)
+	}
+	expectScan(t, reopened, "color", bplus.Key{0}, every(0, 100, 3))
+}
+
+func TestIndexedTakesBackFailedWrites(t *testing.T) {
+	tree := New(newTree(t, "failed_writes_primary"))
+	if err := tree.AddIndex("color", newTree(t, "failed_writes_color"), color); err != nil {
+		t.Fatal(err)
+	}
+	// The size index can't be written to, so every write that moves a record in it fails.
+	tmpfile, err := ioutil.TempFile("", "failed_writes_size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	sizes, err := bplus.NewTree(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sizes.Insert(bplus.Key("placeholder"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sizes.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sizes, err = bplus.OpenTree(tmpfile.Name(), bplus.WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.AddIndex("size", sizes, size); err != nil {
+		t.Fatal(err)
+	}
+	// A zero size isn't indexed by size, so the record can be written.
+	if err := tree.Insert(intKey(1), record(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(intKey(2), record(1, 2)); err == nil {
+		t.Fatal("expected a write to a read-only index to fail")
+	}
+	if _, err := tree.Primary().Read(intKey(2)); err != bplus.ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, bplus.ErrKeyNotFound)
+	}
+	if err := tree.Upsert(intKey(1), record(2, 3)); err == nil {
+		t.Fatal("expected a write to a read-only index to fail")
+	}
+	value, err := tree.Primary().Read(intKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, record(1, 0)) {
+		t.Fatalf("expected %v == %v", value, record(1, 0))
+	}
+	expectScan(t, tree, "color", bplus.Key{1}, []int{1})
+	expectScan(t, tree, "color", bplus.Key{2}, nil)
+}
+
+func TestIndexedConcurrentUpserts(t *testing.T) {
+	tree := New(newTree(t, "concurrent_upserts_primary"))
+	colors := newTree(t, "concurrent_upserts_color")
+	if err := tree.AddIndex("color", colors, color); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := tree.Upsert(intKey(i), record(byte(c), i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// Every record is indexed under its final color, and only under it.
+	for c := 0; c < 8; c++ {
+		var expected []int
+		for i := 0; i < 20; i++ {
+			value, err := tree.Primary().Read(intKey(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value[0] == byte(c) {
+				expected = append(expected, i)
+			}
+		}
+		expectScan(t, tree, "color", bplus.Key{byte(c)}, expected)
+	}
+}
+
+func expectScan(
+	t *testing.T,
+	tree *Indexed,
+	name string,
+	secondary bplus.Key,
+	expected []int,
+) {
+	t.Helper()
+	records, err := tree.IndexScan(name, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for key, value := range records.Records() {
+		primary, err := tree.Primary().Read(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(primary, value) {
+			t.Fatalf("expected %v == %v", value, primary)
+		}
+		got = append(got, int(binary.BigEndian.Uint32(key)))
+	}
+	if err := records.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v == %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v == %v", got, expected)
+		}
+	}
+}
+
+// every returns the integers from start up to end, counting up by step.
+func every(start, end, step int) []int {
+	var ints []int
+	for i := start; i < end; i += step {
+		ints = append(ints, i)
+	}
+	return ints
+}
+
+func newTree(t *testing.T, filename string) *bplus.Tree {
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := bplus.NewTree(
+		tmpfile.Name(),
+		bplus.WithBranchingFactor(4),
+		bplus.WithCacheSize(20),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func intKey(i int) bplus.Key {
+	key := make(bplus.Key, 4)
+	binary.BigEndian.PutUint32(key, uint32(i))
+	return key
+}
+
+func record(color byte, size int) bplus.Value {
+	return append(bplus.Value{color}, sizeKey(size)...)
+}
+
+func sizeKey(size int) bplus.Key {
+	return intKey(size)
+}
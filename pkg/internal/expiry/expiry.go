@@ -0,0 +1,171 @@
+// Package expiry keeps track of when the keys of a tree expire, in a second tree, for the
+// servers that let clients set keys that expire.
+package expiry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// sweepBatch is the most keys a sweep deletes at once.
+const sweepBatch = 1000
+
+// ErrCorrupt is returned when the time a key expires at can't be decoded.
+var ErrCorrupt = errors.New("corrupt expiry")
+
+// The expiries tree holds two records for every key that expires: the time it expires
+// at under expiryPrefix followed by the key, and an empty value under deadlinePrefix
+// followed by the time, as 8 big-endian bytes of Unix nanoseconds, and the key, which
+// orders the keys by when they expire.
+const (
+	expiryPrefix   = 'k'
+	deadlinePrefix = 'd'
+)
+
+// Expiries keeps track of when the keys of a data tree expire. It isn't safe to use from
+// many goroutines at once, and the data tree mustn't be written to other than through it
+// while keys are set to expire, which would leave the expiries out of step with the keys.
+type Expiries struct {
+	data *bplus.Tree
+	tree *bplus.Tree
+}
+
+// New returns the expiries of the keys in data, which are kept in tree.
+func New(data, tree *bplus.Tree) *Expiries {
+	return &Expiries{data: data, tree: tree}
+}
+
+func expiryKey(key []byte) bplus.Key {
+	return append(bplus.Key{expiryPrefix}, key...)
+}
+
+func deadlineKey(deadline time.Time, key []byte) bplus.Key {
+	k := make(bplus.Key, 9, 9+len(key))
+	k[0] = deadlinePrefix
+	binary.BigEndian.PutUint64(k[1:9], uint64(deadline.UnixNano()))
+	return append(k, key...)
+}
+
+// Read returns the value of key, or ErrKeyNotFound if it doesn't exist, deleting it if it
+// has expired by now.
+func (e *Expiries) Read(key []byte, now time.Time) (bplus.Value, error) {
+	deadline, ok, err := e.Deadline(key)
+	if err != nil {
+		return nil, err
+	}
+	if ok && !deadline.After(now) {
+		_, err = e.Remove(key)
+		if err != nil {
+			return nil, err
+		}
+		return nil, bplus.ErrKeyNotFound
+	}
+	return e.data.Read(key)
+}
+
+// Deadline returns when key expires, or false if it doesn't.
+func (e *Expiries) Deadline(key []byte) (time.Time, bool, error) {
+	value, err := e.tree.Read(expiryKey(key))
+	if err == bplus.ErrKeyNotFound {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(value) != 8 {
+		return time.Time{}, false, ErrCorrupt
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), true, nil
+}
+
+// Set sets when key expires.
+func (e *Expiries) Set(key []byte, deadline time.Time) error {
+	var b bplus.WriteBatch
+	old, ok, err := e.Deadline(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		b.Delete(deadlineKey(old, key))
+	}
+	b.Put(expiryKey(key), binary.BigEndian.AppendUint64(nil, uint64(deadline.UnixNano())))
+	b.Put(deadlineKey(deadline, key), bplus.Value{})
+	return e.tree.Apply(&b)
+}
+
+// Clear stops key from expiring.
+func (e *Expiries) Clear(key []byte) error {
+	deadline, ok, err := e.Deadline(key)
+	if err != nil || !ok {
+		return err
+	}
+	var b bplus.WriteBatch
+	b.Delete(expiryKey(key))
+	b.Delete(deadlineKey(deadline, key))
+	return e.tree.Apply(&b)
+}
+
+// Remove deletes key and its expiry, and returns whether it existed.
+func (e *Expiries) Remove(key []byte) (bool, error) {
+	err := e.data.Delete(key)
+	if err != nil && err != bplus.ErrKeyNotFound {
+		return false, err
+	}
+	return err == nil, e.Clear(key)
+}
+
+// SweepEvery deletes the keys that have expired every interval, with mu held, until stop
+// is closed.
+func (e *Expiries) SweepEvery(
+	interval time.Duration, mu sync.Locker, stop chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for {
+			mu.Lock()
+			n, err := e.Sweep(time.Now())
+			mu.Unlock()
+			if err != nil || n < sweepBatch {
+				break
+			}
+		}
+	}
+}
+
+// Sweep deletes up to a batch of the keys that have expired by now, and returns how many
+// it deleted.
+func (e *Expiries) Sweep(now time.Time) (int, error) {
+	var keys []bplus.Key
+	end := deadlineKey(now, nil)
+	it := e.tree.Ascend(bplus.Key{deadlinePrefix})
+	for k := range it.Records() {
+		if k[0] != deadlinePrefix || bytes.Compare(k[:9], end) > 0 {
+			break
+		}
+		if len(keys) == sweepBatch {
+			break
+		}
+		keys = append(keys, append(bplus.Key{}, k[9:]...))
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		_, err := e.Remove(key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
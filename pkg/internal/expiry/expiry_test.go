@@ -0,0 +1,84 @@
+package expiry
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newTree(t *testing.T, filename string) *bplus.Tree {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	tree, err := bplus.NewTree(tmpfile.Name(), bplus.WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+func TestExpiries(t *testing.T) {
+	data := newTree(t, "expiry_data")
+	tree := newTree(t, "expiry_tree")
+	e := New(data, tree)
+	now := time.Unix(1000, 0)
+	for i := 0; i < 20; i++ {
+		key := []byte{byte(i)}
+		if err := data.Insert(key, bplus.Value("v")); err != nil {
+			t.Fatal(err)
+		}
+		// Every other key expires, a second apart, with its expiry set twice.
+		if i%2 == 0 {
+			if err := e.Set(key, now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Set(key, now.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	deadline, ok, err := e.Deadline([]byte{4})
+	if err != nil || !ok || !deadline.Equal(now.Add(4*time.Second)) {
+		t.Fatalf("expected %v == %v, got %v, %v", deadline, now.Add(4*time.Second), ok, err)
+	}
+	if _, ok, _ := e.Deadline([]byte{5}); ok {
+		t.Fatalf("expected key 5 not to expire")
+	}
+	// Key 0 has expired, and is deleted once it's read.
+	if _, err := e.Read([]byte{0}, now); err != bplus.ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, bplus.ErrKeyNotFound)
+	}
+	if _, err := e.Read([]byte{2}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Clear([]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	// Keys 4, 6 and 8 have expired by now.
+	n, err := e.Sweep(now.Add(9 * time.Second))
+	if err != nil || n != 3 {
+		t.Fatalf("expected %v == %v, got %v", n, 3, err)
+	}
+	if data.Len() != 16 {
+		t.Fatalf("expected %v == %v", data.Len(), 16)
+	}
+	// Keys 10 to 18 are left to expire, with two records each.
+	if tree.Len() != 10 {
+		t.Fatalf("expected %v == %v", tree.Len(), 10)
+	}
+	removed, err := e.Remove([]byte{10})
+	if err != nil || !removed {
+		t.Fatalf("expected key 10 to be removed, got %v", err)
+	}
+	if tree.Len() != 8 {
+		t.Fatalf("expected %v == %v", tree.Len(), 8)
+	}
+}
@@ -0,0 +1,198 @@
+// Package keyencode encodes values as bytes which sort in the same order as the values,
+// so that they can be used as B+ tree keys. Every encoding knows where it ends, so
+// encodings can be appended one after another to build keys out of many fields which
+// sort by the first field, then the second, and so on.
+package keyencode
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	// ErrInvalidEncoding is returned when decoding bytes that weren't produced by the
+	// matching encoder.
+	ErrInvalidEncoding = errors.New("invalid encoding")
+	// ErrUnsupportedType is returned when encoding or decoding a tuple field of a type
+	// that has no encoding.
+	ErrUnsupportedType = errors.New("unsupported type")
+)
+
+// AppendUint64 appends v as 8 big endian bytes.
+func AppendUint64(dst []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, v)
+}
+
+// DecodeUint64 decodes a uint64 from the front of buf and returns the rest of buf.
+func DecodeUint64(buf []byte) (uint64, []byte, error) {
+	if len(buf) < 8 {
+		return 0, nil, ErrInvalidEncoding
+	}
+	return binary.BigEndian.Uint64(buf), buf[8:], nil
+}
+
+// AppendInt64 appends v as 8 big endian bytes with the sign bit flipped so that negative
+// numbers sort before positive ones.
+func AppendInt64(dst []byte, v int64) []byte {
+	return AppendUint64(dst, uint64(v)^(1<<63))
+}
+
+// DecodeInt64 decodes an int64 from the front of buf and returns the rest of buf.
+func DecodeInt64(buf []byte) (int64, []byte, error) {
+	v, rest, err := DecodeUint64(buf)
+	return int64(v ^ (1 << 63)), rest, err
+}
+
+// AppendFloat64 appends v as 8 bytes. Positive numbers have their sign bit set and
+// negative numbers have every bit flipped, so that they sort by value. Negative zero
+// sorts just before positive zero and NaNs sort after positive infinity, or before
+// negative infinity if their sign bit is set.
+func AppendFloat64(dst []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return AppendUint64(dst, bits)
+}
+
+// DecodeFloat64 decodes a float64 from the front of buf and returns the rest of buf.
+func DecodeFloat64(buf []byte) (float64, []byte, error) {
+	bits, rest, err := DecodeUint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), rest, err
+}
+
+// AppendTime appends t as 12 bytes, the seconds since the Unix epoch encoded like
+// AppendInt64 followed by the nanoseconds within the second. The location of t is not
+// kept, so times decode in UTC.
+func AppendTime(dst []byte, t time.Time) []byte {
+	dst = AppendInt64(dst, t.Unix())
+	return binary.BigEndian.AppendUint32(dst, uint32(t.Nanosecond()))
+}
+
+// DecodeTime decodes a time from the front of buf and returns the rest of buf.
+func DecodeTime(buf []byte) (time.Time, []byte, error) {
+	sec, rest, err := DecodeInt64(buf)
+	if err != nil || len(rest) < 4 {
+		return time.Time{}, nil, ErrInvalidEncoding
+	}
+	nsec := binary.BigEndian.Uint32(rest)
+	return time.Unix(sec, int64(nsec)).UTC(), rest[4:], nil
+}
+
+// AppendBytes appends b with every zero byte escaped as 0x00 0xFF, followed by 0x00 0x01
+// to mark the end. The end marker sorts before any escaped byte, so b sorts before every
+// longer byte slice it's a prefix of no matter which fields follow it.
+func AppendBytes(dst []byte, b []byte) []byte {
+	for _, c := range b {
+		dst = append(dst, c)
+		if c == 0 {
+			dst = append(dst, 0xFF)
+		}
+	}
+	return append(dst, 0, 1)
+}
+
+// DecodeBytes decodes a byte slice from the front of buf and returns the rest of buf.
+func DecodeBytes(buf []byte) ([]byte, []byte, error) {
+	b := []byte{}
+	for i := 0; i < len(buf); i++ {
+		if buf[i] != 0 {
+			b = append(b, buf[i])
+			continue
+		}
+		if i+1 == len(buf) {
+			break
+		}
+		switch buf[i+1] {
+		case 0xFF:
+			b = append(b, 0)
+			i++
+		case 1:
+			return b, buf[i+2:], nil
+		default:
+			return nil, nil, ErrInvalidEncoding
+		}
+	}
+	return nil, nil, ErrInvalidEncoding
+}
+
+// AppendString appends s encoded like AppendBytes.
+func AppendString(dst []byte, s string) []byte {
+	return AppendBytes(dst, []byte(s))
+}
+
+// DecodeString decodes a string from the front of buf and returns the rest of buf.
+func DecodeString(buf []byte) (string, []byte, error) {
+	b, rest, err := DecodeBytes(buf)
+	return string(b), rest, err
+}
+
+// Tuple encodes fields one after another, so that tuples sort by their first field, then
+// their second, and so on. Fields can be uint64, int64, int, float64, time.Time, string
+// or []byte values.
+func Tuple(fields ...interface{}) ([]byte, error) {
+	var buf []byte
+	for _, field := range fields {
+		switch v := field.(type) {
+		case uint64:
+			buf = AppendUint64(buf, v)
+		case int64:
+			buf = AppendInt64(buf, v)
+		case int:
+			buf = AppendInt64(buf, int64(v))
+		case float64:
+			buf = AppendFloat64(buf, v)
+		case time.Time:
+			buf = AppendTime(buf, v)
+		case string:
+			buf = AppendString(buf, v)
+		case []byte:
+			buf = AppendBytes(buf, v)
+		default:
+			return nil, ErrUnsupportedType
+		}
+	}
+	return buf, nil
+}
+
+// DecodeTuple decodes a tuple encoded by Tuple into the fields pointed to by dsts, which
+// must point at the same types that were encoded. It returns the bytes following the
+// tuple.
+func DecodeTuple(buf []byte, dsts ...interface{}) ([]byte, error) {
+	var err error
+	for _, dst := range dsts {
+		switch d := dst.(type) {
+		case *uint64:
+			*d, buf, err = DecodeUint64(buf)
+		case *int64:
+			*d, buf, err = DecodeInt64(buf)
+		case *int:
+			var v int64
+			v, buf, err = DecodeInt64(buf)
+			*d = int(v)
+		case *float64:
+			*d, buf, err = DecodeFloat64(buf)
+		case *time.Time:
+			*d, buf, err = DecodeTime(buf)
+		case *string:
+			*d, buf, err = DecodeString(buf)
+		case *[]byte:
+			*d, buf, err = DecodeBytes(buf)
+		default:
+			return nil, ErrUnsupportedType
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,116 @@
+package keyencode
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEncodingsPreserveOrdering(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	ordered := [][]interface{}{
+		{uint64(0), uint64(1), uint64(255), uint64(256), uint64(math.MaxUint64)},
+		{int64(math.MinInt64), int64(-1000), int64(-1), int64(0), int64(1), int64(1000)},
+		{
+			math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64,
+			math.Copysign(0, -1), 0.0, math.SmallestNonzeroFloat64, 1.5, math.Inf(1),
+		},
+		{
+			epoch.Add(-time.Hour), epoch.Add(-time.Nanosecond), epoch,
+			epoch.Add(time.Nanosecond), epoch.Add(time.Second),
+			time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{"", "\x00", "\x00\x00", "\x00\x01", "a", "a\x00", "a\x00b", "ab", "b"},
+	}
+	for _, values := range ordered {
+		for i := 1; i < len(values); i++ {
+			a, err := Tuple(values[i-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := Tuple(values[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Compare(a, b) >= 0 {
+				t.Fatalf("expected %v to sort before %v", values[i-1], values[i])
+			}
+		}
+	}
+}
+
+func TestTuplesSortByEachFieldInTurn(t *testing.T) {
+	tuples := [][]interface{}{
+		{"a", int64(2)},
+		{"a", int64(10)},
+		{"a\x00", int64(1)},
+		{"ab", int64(-5)},
+		{"b", int64(0)},
+	}
+	for i := 1; i < len(tuples); i++ {
+		a, err := Tuple(tuples[i-1]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Tuple(tuples[i]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("expected %v to sort before %v", tuples[i-1], tuples[i])
+		}
+	}
+}
+
+func TestDecodeTuple(t *testing.T) {
+	when := time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)
+	buf, err := Tuple(uint64(7), int64(-7), 42, -2.5, when, "a\x00b", []byte{0, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf = append(buf, 9)
+	var (
+		u uint64
+		i int64
+		n int
+		f float64
+		w time.Time
+		s string
+		b []byte
+	)
+	rest, err := DecodeTuple(buf, &u, &i, &n, &f, &w, &s, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != 7 || i != -7 || n != 42 || f != -2.5 || !w.Equal(when) || s != "a\x00b" {
+		t.Fatalf("unexpected decoded tuple %v %v %v %v %v %q", u, i, n, f, w, s)
+	}
+	if !bytes.Equal(b, []byte{0, 0, 1}) {
+		t.Fatalf("expected %v == %v", b, []byte{0, 0, 1})
+	}
+	if !bytes.Equal(rest, []byte{9}) {
+		t.Fatalf("expected %v == %v", rest, []byte{9})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, _, err := DecodeUint64([]byte{1, 2, 3}); err != ErrInvalidEncoding {
+		t.Fatalf("expected %v == %v", err, ErrInvalidEncoding)
+	}
+	if _, _, err := DecodeTime(make([]byte, 10)); err != ErrInvalidEncoding {
+		t.Fatalf("expected %v == %v", err, ErrInvalidEncoding)
+	}
+	for _, buf := range [][]byte{{'a'}, {'a', 0}, {'a', 0, 2}} {
+		if _, _, err := DecodeBytes(buf); err != ErrInvalidEncoding {
+			t.Fatalf("expected %v == %v for %v", err, ErrInvalidEncoding, buf)
+		}
+	}
+	if _, err := Tuple(int32(1)); err != ErrUnsupportedType {
+		t.Fatalf("expected %v == %v", err, ErrUnsupportedType)
+	}
+	var v int32
+	if _, err := DecodeTuple(make([]byte, 8), &v); err != ErrUnsupportedType {
+		t.Fatalf("expected %v == %v", err, ErrUnsupportedType)
+	}
+}
@@ -0,0 +1,412 @@
+// Package memcache serves a B+ tree from package bplus over memcached's text protocol, so
+// that memcached clients can use a tree as a cache that survives restarts. It supports a
+// minimal set of commands, see Server.
+package memcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/internal/expiry"
+)
+
+const (
+	// DefaultSweepInterval is how often a server deletes the items that have expired
+	// unless it's created with WithSweepInterval.
+	DefaultSweepInterval = 100 * time.Millisecond
+	// MaxKeySize is the longest key an item can have, in bytes, as in memcached.
+	MaxKeySize = 250
+	// MaxValueSize is the largest value an item can have, in bytes, which is memcached's
+	// default.
+	MaxValueSize = 1 << 20
+	// maxRelativeExptime is the largest expiration time that's a number of seconds from
+	// now rather than a Unix time, which is 30 days.
+	maxRelativeExptime = 30 * 24 * 60 * 60
+	// flagsSize is the size of the flags stored in front of every value.
+	flagsSize = 4
+)
+
+var (
+	// ErrServerClosed is returned by Serve once the server has been closed.
+	ErrServerClosed = errors.New("memcache server closed")
+	// ErrCorrupt is returned when getting an item whose value is too short to have been
+	// stored through a server.
+	ErrCorrupt = errors.New("corrupt item")
+)
+
+// ServerOption configures a server, see NewServer.
+type ServerOption func(*Server)
+
+// WithSweepInterval sets how often a server deletes the items that have expired. Expired
+// items are never returned, even before they're deleted.
+func WithSweepInterval(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.sweepInterval = interval
+	}
+}
+
+// Server serves a tree to memcached clients. It supports:
+//
+//	get <key>*
+//	set <key> <flags> <exptime> <bytes> [noreply]
+//	delete <key> [noreply]
+//	touch <key> <exptime> [noreply]
+//	version
+//	quit
+//
+// Items are stored in the tree under their keys, with their 32 bit flags, as 4
+// big-endian bytes, in front of their values, while the times items expire at are kept
+// in a second tree. The two trees are written separately, so a crash can leave an item
+// without the expiration time it was set with.
+type Server struct {
+	data     *bplus.Tree
+	expiries *expiry.Expiries
+
+	sweepInterval time.Duration
+
+	// mu is held while a command runs, so that commands see each other's changes to both
+	// trees whole.
+	mu sync.Mutex
+
+	connMu    sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// NewServer returns a server that keeps items in data and the times they expire at in
+// expiries. Neither tree may be written to other than through the server while it's
+// serving, which would leave the expiries out of step with the items.
+func NewServer(data, expiries *bplus.Tree, opts ...ServerOption) *Server {
+	s := &Server{
+		data:          data,
+		expiries:      expiry.New(data, expiries),
+		sweepInterval: DefaultSweepInterval,
+		listeners:     map[net.Listener]struct{}{},
+		conns:         map[net.Conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Serve accepts clients on l and serves them until the server is closed, when it returns
+// ErrServerClosed. It deletes expired items in the background while it's serving.
+func (s *Server) Serve(l net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.connMu.Unlock()
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.expiries.SweepEvery(s.sweepInterval, &s.mu, stop)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.connMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.connMu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops serving clients and disconnects the ones connected.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// conn is a client's connection.
+type conn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// reply writes a line of a reply, unless the command asked for no reply.
+func (c conn) reply(noreply bool, line string) {
+	if !noreply {
+		c.w.WriteString(line)
+		c.w.WriteString("\r\n")
+	}
+}
+
+// serveConn runs the commands a client sends until it disconnects. Replies are flushed
+// once every command sent so far has run, so that pipelined commands are replied to
+// together.
+func (s *Server) serveConn(nc net.Conn) {
+	defer func() {
+		s.connMu.Lock()
+		delete(s.conns, nc)
+		s.connMu.Unlock()
+		nc.Close()
+	}()
+	c := conn{r: bufio.NewReaderSize(nc, 64<<10), w: bufio.NewWriter(nc)}
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			c.reply(false, "CLIENT_ERROR line too long")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) > 0 && fields[0] == "quit" {
+			c.w.Flush()
+			return
+		}
+		err = s.execute(c, fields)
+		if err != nil {
+			c.w.Flush()
+			return
+		}
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// execute runs a command and writes its reply. It only returns an error if the
+// connection failed.
+func (s *Server) execute(c conn, fields []string) error {
+	if len(fields) == 0 {
+		c.reply(false, "ERROR")
+		return nil
+	}
+	noreply := fields[len(fields)-1] == "noreply"
+	if noreply {
+		fields = fields[:len(fields)-1]
+	}
+	var err error
+	switch {
+	case fields[0] == "get" && len(fields) > 1:
+		err = s.get(c, fields[1:])
+	case fields[0] == "set" && len(fields) == 5:
+		return s.set(c, fields[1:], noreply)
+	case fields[0] == "delete" && len(fields) == 2:
+		err = s.delete(c, fields[1], noreply)
+	case fields[0] == "touch" && len(fields) == 3:
+		err = s.touch(c, fields[1], fields[2], noreply)
+	case fields[0] == "version" && len(fields) == 1:
+		c.reply(false, "VERSION bplus")
+	default:
+		c.reply(false, "ERROR")
+	}
+	if err != nil {
+		c.reply(noreply, "SERVER_ERROR "+err.Error())
+	}
+	return nil
+}
+
+func (s *Server) get(c conn, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		value, err := s.expiries.Read([]byte(key), now)
+		if err == bplus.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(value) < flagsSize {
+			return ErrCorrupt
+		}
+		flags := binary.BigEndian.Uint32(value)
+		data := value[flagsSize:]
+		c.w.WriteString("VALUE " + key + " " + strconv.FormatUint(uint64(flags), 10) +
+			" " + strconv.Itoa(len(data)) + "\r\n")
+		c.w.Write(data)
+		c.w.WriteString("\r\n")
+	}
+	c.reply(false, "END")
+	return nil
+}
+
+// set reads the data block that follows the command and stores it. It only returns an
+// error if the connection failed.
+func (s *Server) set(c conn, args []string, noreply bool) error {
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		c.reply(false, "CLIENT_ERROR bad command line format")
+		return nil
+	}
+	if size > MaxValueSize {
+		// The data block is thrown away, so the connection can carry on.
+		_, err := io.CopyN(io.Discard, c.r, int64(size)+2)
+		if err != nil {
+			return err
+		}
+		c.reply(noreply, "SERVER_ERROR object too large for cache")
+		return nil
+	}
+	value := make([]byte, flagsSize+size+2)
+	_, err := io.ReadFull(c.r, value[flagsSize:])
+	if err != nil {
+		return err
+	}
+	if string(value[flagsSize+size:]) != "\r\n" {
+		c.reply(false, "CLIENT_ERROR bad data chunk")
+		return errors.New("bad data chunk")
+	}
+	if !validKey(key) {
+		c.reply(false, "CLIENT_ERROR bad command line format")
+		return nil
+	}
+	binary.BigEndian.PutUint32(value, uint32(flags))
+	value = value[:flagsSize+size]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.store([]byte(key), value, exptime, time.Now())
+	if err != nil {
+		c.reply(noreply, "SERVER_ERROR "+err.Error())
+		return nil
+	}
+	c.reply(noreply, "STORED")
+	return nil
+}
+
+// store stores an item that expires at exptime, with mu held.
+func (s *Server) store(key, value []byte, exptime int64, now time.Time) error {
+	deadline, expired := deadline(exptime, now)
+	// An item that expires straight away is as good as deleted.
+	if expired {
+		_, err := s.expiries.Remove(key)
+		return err
+	}
+	// An item that's set loses the expiration time it had.
+	err := s.expiries.Clear(key)
+	if err != nil {
+		return err
+	}
+	err = s.data.Upsert(key, value)
+	if err != nil || deadline.IsZero() {
+		return err
+	}
+	return s.expiries.Set(key, deadline)
+}
+
+func (s *Server) delete(c conn, key string, noreply bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.expiries.Read([]byte(key), time.Now())
+	if err == bplus.ErrKeyNotFound {
+		c.reply(noreply, "NOT_FOUND")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.expiries.Remove([]byte(key))
+	if err != nil {
+		return err
+	}
+	c.reply(noreply, "DELETED")
+	return nil
+}
+
+func (s *Server) touch(c conn, key, exptimeField string, noreply bool) error {
+	exptime, err := strconv.ParseInt(exptimeField, 10, 64)
+	if err != nil {
+		c.reply(false, "CLIENT_ERROR invalid exptime argument")
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	_, err = s.expiries.Read([]byte(key), now)
+	if err == bplus.ErrKeyNotFound {
+		c.reply(noreply, "NOT_FOUND")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	deadline, expired := deadline(exptime, now)
+	switch {
+	case expired:
+		_, err = s.expiries.Remove([]byte(key))
+	case deadline.IsZero():
+		err = s.expiries.Clear([]byte(key))
+	default:
+		err = s.expiries.Set([]byte(key), deadline)
+	}
+	if err != nil {
+		return err
+	}
+	c.reply(noreply, "TOUCHED")
+	return nil
+}
+
+// deadline returns the time an item with an expiration time of exptime expires at, which
+// is the zero time if it never expires, or true if it has already expired. An
+// expiration time of up to 30 days is a number of seconds from now, while a longer one
+// is a Unix time.
+func deadline(exptime int64, now time.Time) (time.Time, bool) {
+	switch {
+	case exptime == 0:
+		return time.Time{}, false
+	case exptime < 0:
+		return time.Time{}, true
+	case exptime <= maxRelativeExptime:
+		return now.Add(time.Duration(exptime) * time.Second), false
+	}
+	deadline := time.Unix(exptime, 0)
+	return deadline, !deadline.After(now)
+}
+
+// validKey returns whether key can be an item's key, which is up to MaxKeySize bytes
+// without any control characters or spaces.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > MaxKeySize {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newTree(t *testing.T, filename string) *bplus.Tree {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	tree, err := bplus.NewTree(tmpfile.Name(), bplus.WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+// client sends commands to a server and reads its replies.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, opts ...ServerOption) (*bplus.Tree, *client) {
+	t.Helper()
+	data := newTree(t, "memcache_data")
+	server := NewServer(data, newTree(t, "memcache_expiries"), opts...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("expected %v == %v", err, ErrServerClosed)
+		}
+	})
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return data, &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// expect sends a command and checks the lines of its reply, which are separated by |.
+func (c *client) expect(command, expected string) {
+	c.t.Helper()
+	fmt.Fprint(c.conn, command)
+	var lines []string
+	for range strings.Split(expected, "|") {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+	}
+	if reply := strings.Join(lines, "|"); reply != expected {
+		c.t.Fatalf("expected %q == %q for %q", reply, expected, command)
+	}
+}
+
+func TestServer(t *testing.T) {
+	data, c := startServer(t)
+	c.expect("get k\r\n", "END")
+	c.expect("set k 42 0 5\r\nhello\r\n", "STORED")
+	c.expect("get k\r\n", "VALUE k 42 5|hello|END")
+	c.expect("set empty 0 0 0\r\n\r\n", "STORED")
+	c.expect("get k missing empty\r\n", "VALUE k 42 5|hello|VALUE empty 0 0||END")
+	// The flags are stored in front of the value.
+	value, err := data.Read(bplus.Key("k"))
+	if err != nil || string(value) != "\x00\x00\x00\x2ahello" {
+		t.Fatalf("expected %q == %q, got %v", value, "\x00\x00\x00\x2ahello", err)
+	}
+	c.expect("delete k\r\n", "DELETED")
+	c.expect("delete k\r\n", "NOT_FOUND")
+	c.expect("touch k 10\r\n", "NOT_FOUND")
+	c.expect("bogus\r\n", "ERROR")
+	c.expect("set k x 0 5\r\n", "CLIENT_ERROR bad command line format")
+	big := strings.Repeat("x", MaxValueSize+1)
+	c.expect("set big 0 0 "+fmt.Sprint(len(big))+"\r\n"+big+"\r\n",
+		"SERVER_ERROR object too large for cache")
+	c.expect("version\r\n", "VERSION bplus")
+
+	// Commands sent with noreply aren't replied to.
+	c.expect("set a 0 0 1 noreply\r\na\r\ndelete b noreply\r\nget a\r\n",
+		"VALUE a 0 1|a|END")
+	c.expect("set k 0 0 5\r\nhelloX\r\n", "CLIENT_ERROR bad data chunk")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected %v == %v", err, io.EOF)
+	}
+}
+
+func TestServerExpiration(t *testing.T) {
+	data, c := startServer(t, WithSweepInterval(10*time.Millisecond))
+	c.expect("set k 0 100 1\r\nv\r\n", "STORED")
+	c.expect("touch k 0\r\n", "TOUCHED")
+	c.expect("set gone 0 -1 1\r\nv\r\n", "STORED")
+	c.expect("get gone\r\n", "END")
+	past := fmt.Sprint(time.Now().Add(-time.Hour).Unix())
+	c.expect("set old 0 "+past+" 1\r\nv\r\n", "STORED")
+	c.expect("get old\r\n", "END")
+	c.expect("set soon 0 100 1\r\nv\r\n", "STORED")
+	c.expect("touch soon -1\r\n", "TOUCHED")
+	c.expect("get k soon\r\n", "VALUE k 0 1|v|END")
+
+	// Items that expire are swept away in the background.
+	c.expect("set short 0 1 1\r\nv\r\n", "STORED")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := data.Read(bplus.Key("short")); err == bplus.ErrKeyNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the expired item to be swept away")
+		}
+	}
+	c.expect("get short k\r\n", "VALUE k 0 1|v|END")
+}
+
+func TestDeadline(t *testing.T) {
+	now := time.Unix(2000000000, 0)
+	for _, test := range []struct {
+		exptime  int64
+		deadline time.Time
+		expired  bool
+	}{
+		{0, time.Time{}, false},
+		{-1, time.Time{}, true},
+		{60, now.Add(time.Minute), false},
+		{maxRelativeExptime, now.Add(30 * 24 * time.Hour), false},
+		{2000000060, time.Unix(2000000060, 0), false},
+		{1900000000, time.Unix(1900000000, 0), true},
+	} {
+		deadline, expired := deadline(test.exptime, now)
+		if !deadline.Equal(test.deadline) || expired != test.expired {
+			t.Fatalf("expected %v == %v and %v == %v for %d",
+				deadline, test.deadline, expired, test.expired, test.exptime)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package merkle keeps a Merkle tree of the records in a B+ tree from package bplus, so
+// that two replicas of a tree can find the records they disagree on by comparing a
+// handful of hashes rather than every record, and repair just those.
+//
+// Records are spread over a fixed number of segments by the hash of their key, rather
+// than by where they fall in the tree's pages, so that replicas that hold the same
+// records have the same Merkle tree however their pages were split. The hash of a
+// segment is the XOR of the hashes of its records, which lets it be kept up to date as
+// records are written without reading the rest of the segment, and the segments are the
+// leaves of a binary tree of hashes.
+package merkle
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+const (
+	// DefaultDepth is a depth that spreads records over 4096 segments.
+	DefaultDepth = 12
+	// MaxDepth is the deepest a Merkle tree can be, with 16M segments.
+	MaxDepth = 24
+)
+
+var (
+	// ErrDepth is returned when creating a Merkle tree deeper than MaxDepth, or comparing
+	// Merkle trees of different depths.
+	ErrDepth = errors.New("invalid merkle tree depth")
+	// ErrNodeNotFound is returned when asking for a node that isn't in the Merkle tree.
+	ErrNodeNotFound = errors.New("merkle tree node not found")
+)
+
+// Hash is the hash of a node in a Merkle tree. Nodes without any records under them have
+// the zero hash.
+type Hash [sha256.Size]byte
+
+// Source is a Merkle tree to compare against, which is usually that of a replica on
+// another machine, reached by sending it the arguments of Nodes. A Tree is a Source.
+type Source interface {
+	// Depth returns the depth of the Merkle tree.
+	Depth() int
+	// Nodes returns the hashes of the nodes at the given indexes of a level of the
+	// Merkle tree, where the root is the only node at level 0, and the children of the
+	// node at index i are at indexes 2i and 2i+1 of the level below it.
+	Nodes(level int, indexes []int) ([]Hash, error)
+}
+
+// Tree is a Merkle tree of the records in a B+ tree, which is kept up to date as records
+// are written. It's safe to use from many goroutines at once.
+type Tree struct {
+	tree  *bplus.Tree
+	depth int
+
+	mu sync.Mutex
+	// nodes holds the hashes of every node, level by level from the root, so the node at
+	// index i of level l is at 2^l - 1 + i.
+	nodes []Hash
+	// dirty holds the segments written since the nodes above them were last hashed.
+	dirty map[int]struct{}
+}
+
+// New returns a Merkle tree of the records in tree, which spreads them over 2^depth
+// segments. Every record is read to build it, so tree mustn't be written to until New
+// returns, after which the Merkle tree is kept up to date with hooks, see
+// bplus.Tree.AddHooks. Values written with WriteStream aren't supported.
+func New(tree *bplus.Tree, depth int) (*Tree, error) {
+	if depth < 0 || depth > MaxDepth {
+		return nil, ErrDepth
+	}
+	m := &Tree{
+		tree:  tree,
+		depth: depth,
+		nodes: make([]Hash, 1<<(depth+1)-1),
+		dirty: map[int]struct{}{},
+	}
+	it := tree.All()
+	for key, value := range it.Records() {
+		m.toggle(key, value)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	tree.AddHooks(bplus.Hooks{AfterCommit: m.changed})
+	return m, nil
+}
+
+// changed updates the segments of the records a write changed.
+func (m *Tree) changed(changes []bplus.WatchEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, change := range changes {
+		if change.Kind != bplus.WatchInsert {
+			m.toggle(change.Key, change.Old)
+		}
+		if change.Kind != bplus.WatchDelete {
+			m.toggle(change.Key, change.Value)
+		}
+	}
+}
+
+// toggle adds a record to its segment, or removes it if it's already there, with mu held
+// once New has returned.
+func (m *Tree) toggle(key bplus.Key, value bplus.Value) {
+	keyHash := sha256.Sum256(key)
+	h := sha256.New()
+	h.Write(keyHash[:])
+	h.Write(value)
+	segment := m.segment(keyHash)
+	leaf := m.position(m.depth, segment)
+	for i, b := range h.Sum(nil) {
+		m.nodes[leaf][i] ^= b
+	}
+	m.dirty[segment] = struct{}{}
+}
+
+// Depth returns the depth of the Merkle tree, which has 2^depth segments.
+func (m *Tree) Depth() int {
+	return m.depth
+}
+
+// Segment returns the segment a key is in.
+func (m *Tree) Segment(key bplus.Key) int {
+	return m.segment(sha256.Sum256(key))
+}
+
+func (m *Tree) segment(keyHash [sha256.Size]byte) int {
+	top := uint32(keyHash[0])<<24 | uint32(keyHash[1])<<16 | uint32(keyHash[2])<<8 |
+		uint32(keyHash[3])
+	return int(uint64(top) >> (32 - m.depth))
+}
+
+// position returns where the node at index of level is kept in nodes.
+func (m *Tree) position(level, index int) int {
+	return 1<<level - 1 + index
+}
+
+// Root returns the hash of the root of the Merkle tree, which is the same for replicas
+// that hold the same records.
+func (m *Tree) Root() Hash {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rehash()
+	return m.nodes[0]
+}
+
+// Nodes returns the hashes of the nodes at the given indexes of a level of the Merkle
+// tree, see Source, or ErrNodeNotFound if one of them isn't in it.
+func (m *Tree) Nodes(level int, indexes []int) ([]Hash, error) {
+	if level < 0 || level > m.depth {
+		return nil, ErrNodeNotFound
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rehash()
+	hashes := make([]Hash, len(indexes))
+	for i, index := range indexes {
+		if index < 0 || index >= 1<<level {
+			return nil, ErrNodeNotFound
+		}
+		hashes[i] = m.nodes[m.position(level, index)]
+	}
+	return hashes, nil
+}
+
+// rehash hashes the nodes above the segments written since it was last called, with mu
+// held.
+func (m *Tree) rehash() {
+	dirty := m.dirty
+	for level := m.depth - 1; level >= 0; level-- {
+		parents := make(map[int]struct{}, len(dirty))
+		for index := range dirty {
+			parents[index/2] = struct{}{}
+		}
+		for index := range parents {
+			left := m.nodes[m.position(level+1, 2*index)]
+			right := m.nodes[m.position(level+1, 2*index+1)]
+			m.nodes[m.position(level, index)] = hashChildren(left, right)
+		}
+		dirty = parents
+	}
+	m.dirty = map[int]struct{}{}
+}
+
+func hashChildren(left, right Hash) Hash {
+	if left == (Hash{}) && right == (Hash{}) {
+		return Hash{}
+	}
+	return sha256.Sum256(append(left[:], right[:]...))
+}
+
+// Diff compares the Merkle tree with other, level by level from the root, only
+// descending into the nodes whose hashes differ, and returns the segments the two
+// disagree on in ascending order. It returns ErrDepth if other has a different depth.
+//
+// Records written while the trees are being compared can make them disagree on more
+// segments, or fewer, than they do once the writes are done, so replicas are usually
+// compared over and over again.
+func (m *Tree) Diff(other Source) ([]int, error) {
+	if other.Depth() != m.depth {
+		return nil, ErrDepth
+	}
+	indexes := []int{0}
+	for level := 0; ; level++ {
+		ours, err := m.Nodes(level, indexes)
+		if err != nil {
+			return nil, err
+		}
+		theirs, err := other.Nodes(level, indexes)
+		if err != nil {
+			return nil, err
+		}
+		if len(theirs) != len(indexes) {
+			return nil, ErrNodeNotFound
+		}
+		var differ []int
+		for i, index := range indexes {
+			if ours[i] != theirs[i] {
+				differ = append(differ, index)
+			}
+		}
+		if level == m.depth || len(differ) == 0 {
+			return differ, nil
+		}
+		indexes = make([]int, 0, 2*len(differ))
+		for _, index := range differ {
+			indexes = append(indexes, 2*index, 2*index+1)
+		}
+	}
+}
+
+// Records returns a sequence of the keys and values of the records in the given segments
+// in ascending key order, which are the records a replica sends another to repair the
+// segments they disagree on. Finding them reads every record in the tree once, however
+// many segments are asked for.
+func (m *Tree) Records(segments []int) *bplus.Iter {
+	want := make(map[int]struct{}, len(segments))
+	for _, segment := range segments {
+		want[segment] = struct{}{}
+	}
+	return bplus.NewIter(func(yield func(bplus.Key, bplus.Value) bool) error {
+		it := m.tree.All()
+		for key, value := range it.Records() {
+			if _, ok := want[m.Segment(key)]; !ok {
+				continue
+			}
+			if !yield(key, value) {
+				break
+			}
+		}
+		return it.Err()
+	})
+}
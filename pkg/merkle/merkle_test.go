@@ -0,0 +1,147 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newTree(t *testing.T, filename string, branchingFactor int) *bplus.Tree {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	tree, err := bplus.NewTree(tmpfile.Name(), bplus.WithBranchingFactor(branchingFactor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+func intKey(i int) bplus.Key {
+	key := make(bplus.Key, 4)
+	binary.BigEndian.PutUint32(key, uint32(i))
+	return key
+}
+
+func newMerkle(t *testing.T, tree *bplus.Tree, depth int) *Tree {
+	t.Helper()
+	m, err := New(tree, depth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMerkle(t *testing.T) {
+	// The replicas hold the same records, written in a different order and split into
+	// pages differently.
+	a := newTree(t, "merkle_a", 4)
+	b := newTree(t, "merkle_b", 16)
+	for i := 0; i < 500; i++ {
+		if err := a.Insert(intKey(i), bplus.Value("value")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Insert(intKey(499-i), bplus.Value("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ma := newMerkle(t, a, 8)
+	mb := newMerkle(t, b, 8)
+	if ma.Root() != mb.Root() || ma.Root() == (Hash{}) {
+		t.Fatalf("expected %x == %x", ma.Root(), mb.Root())
+	}
+	differ, err := ma.Diff(mb)
+	if err != nil || len(differ) != 0 {
+		t.Fatalf("expected no segments to differ, got %v, %v", differ, err)
+	}
+
+	// The replicas diverge.
+	if err := b.Upsert(intKey(10), bplus.Value("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(intKey(20)); err != nil {
+		t.Fatal(err)
+	}
+	var batch bplus.WriteBatch
+	batch.Put(intKey(1000), bplus.Value("value"))
+	batch.Delete(intKey(30))
+	if err := b.Apply(&batch); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{}
+	for _, i := range []int{10, 20, 30, 1000} {
+		expected = append(expected, ma.Segment(intKey(i)))
+	}
+	sort.Ints(expected)
+	differ, err = ma.Diff(mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(differ) != len(expected) {
+		t.Fatalf("expected %v == %v", differ, expected)
+	}
+	for i := range differ {
+		if differ[i] != expected[i] {
+			t.Fatalf("expected %v == %v", differ, expected)
+		}
+	}
+
+	// The replicas are repaired by making a hold the records b has in the segments they
+	// disagree on.
+	theirs := map[string]bplus.Value{}
+	it := mb.Records(differ)
+	for key, value := range it.Records() {
+		theirs[string(key)] = value
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for key := range ma.Records(differ).Records() {
+		if _, ok := theirs[string(key)]; !ok {
+			batch.Delete(key)
+		}
+	}
+	for key, value := range theirs {
+		batch.Put(bplus.Key(key), value)
+	}
+	if err := a.Apply(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if ma.Root() != mb.Root() {
+		t.Fatalf("expected %x == %x", ma.Root(), mb.Root())
+	}
+
+	// A Merkle tree built from scratch agrees with the one kept up to date.
+	if root := newMerkle(t, b, 8).Root(); root != mb.Root() {
+		t.Fatalf("expected %x == %x", root, mb.Root())
+	}
+}
+
+func TestMerkleErrors(t *testing.T) {
+	tree := newTree(t, "merkle_errors", 4)
+	if _, err := New(tree, MaxDepth+1); err != ErrDepth {
+		t.Fatalf("expected %v == %v", err, ErrDepth)
+	}
+	m := newMerkle(t, tree, 4)
+	if m.Root() != (Hash{}) {
+		t.Fatalf("expected an empty tree to have the zero hash, got %x", m.Root())
+	}
+	if _, err := m.Diff(newMerkle(t, tree, 5)); err != ErrDepth {
+		t.Fatalf("expected %v == %v", err, ErrDepth)
+	}
+	if _, err := m.Nodes(1, []int{2}); err != ErrNodeNotFound {
+		t.Fatalf("expected %v == %v", err, ErrNodeNotFound)
+	}
+	if _, err := m.Nodes(5, []int{0}); err != ErrNodeNotFound {
+		t.Fatalf("expected %v == %v", err, ErrNodeNotFound)
+	}
+}
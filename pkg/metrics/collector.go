@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Source is where a store collector reads the statistics of a page store from, which is
+// a *store.PageStore, a *bplus.Tree or a *bplus.DB.
+type Source interface {
+	Stats() (store.Stats, error)
+	CacheStats() store.CacheStats
+	CommitLatency() store.LatencyHistogram
+}
+
+// storeCollector collects the metrics of a page store, see NewStoreCollector.
+type storeCollector struct {
+	labels []Label
+	source Source
+}
+
+// NewStoreCollector returns a collector of the metrics of the page store that source
+// reads from: its cache's hits, misses and evictions, the pages read from and written to
+// its file, how the pages of the file are used and how long commits take. The metrics are
+// labelled with file="name". The trees of a database share a store, so it's collected
+// once for the database rather than once per tree.
+func NewStoreCollector(name string, source Source) Collector {
+	return &storeCollector{labels: []Label{{Name: "file", Value: name}}, source: source}
+}
+
+func (c *storeCollector) Collect() ([]Family, error) {
+	stats, err := c.source.Stats()
+	if err != nil {
+		return nil, err
+	}
+	cache := c.source.CacheStats()
+	families := []Family{
+		c.family("bplus_cache_hits_total", "Loads of pages that were already cached.",
+			Counter, float64(cache.Hits)),
+		c.family("bplus_cache_misses_total", "Loads of pages that weren't cached.",
+			Counter, float64(cache.Misses)),
+		c.family("bplus_cache_evictions_total", "Pages evicted from the cache.",
+			Counter, float64(cache.Evictions)),
+		c.family("bplus_cache_hit_ratio", "Fraction of loads that hit the cache.",
+			Gauge, cache.HitRatio()),
+		c.family("bplus_page_reads_total", "Pages read from the file.",
+			Counter, float64(cache.Loads)),
+		c.family("bplus_page_writes_total", "Pages written to the file.",
+			Counter, float64(cache.Writes)),
+		c.family("bplus_syncs_total", "Times the file was synced to disk.",
+			Counter, float64(cache.Syncs)),
+		c.family("bplus_pages", "Pages allocated in the file, including free pages.",
+			Gauge, float64(stats.Pages)),
+		c.family("bplus_free_pages", "Pages freed and not allocated again.",
+			Gauge, float64(stats.FreePages)),
+		c.family("bplus_file_size_bytes", "Length of the file.",
+			Gauge, float64(stats.FileSize)),
+	}
+	latency := c.source.CommitLatency()
+	commits := Metric{
+		Labels: c.labels,
+		Count:  latency.Count,
+		Sum:    latency.Sum.Seconds(),
+	}
+	var count uint64
+	for i, bound := range store.LatencyBuckets {
+		count += latency.Counts[i]
+		commits.Buckets = append(commits.Buckets, Bucket{
+			UpperBound: bound.Seconds(),
+			Count:      count,
+		})
+	}
+	return append(families, Family{
+		Name:    "bplus_commit_duration_seconds",
+		Help:    "Time taken to commit groups of writes.",
+		Type:    Histogram,
+		Metrics: []Metric{commits},
+	}), nil
+}
+
+func (c *storeCollector) family(name, help string, t Type, value float64) Family {
+	return Family{
+		Name:    name,
+		Help:    help,
+		Type:    t,
+		Metrics: []Metric{{Labels: c.labels, Value: value}},
+	}
+}
+
+// treeCollector collects the metrics of a tree, see NewTreeCollector.
+type treeCollector struct {
+	labels []Label
+	tree   *bplus.Tree
+}
+
+// NewTreeCollector returns a collector of the metrics of a tree itself, its height and
+// the number of records in it, labelled with tree="name". The metrics of the page store
+// it's kept in are collected by NewStoreCollector.
+func NewTreeCollector(name string, tree *bplus.Tree) Collector {
+	return &treeCollector{labels: []Label{{Name: "tree", Value: name}}, tree: tree}
+}
+
+func (c *treeCollector) Collect() ([]Family, error) {
+	height, err := c.tree.Height()
+	if err != nil {
+		return nil, err
+	}
+	return []Family{
+		{
+			Name:    "bplus_tree_height",
+			Help:    "Pages on the path from the root of the tree to a leaf.",
+			Type:    Gauge,
+			Metrics: []Metric{{Labels: c.labels, Value: float64(height)}},
+		},
+		{
+			Name:    "bplus_tree_records",
+			Help:    "Records in the tree.",
+			Type:    Gauge,
+			Metrics: []Metric{{Labels: c.labels, Value: float64(c.tree.Len())}},
+		},
+	}, nil
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestCollectors(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "collectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	tree, err := bplus.NewTree(tmpfile.Name(), bplus.WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 100; i++ {
+		key := make(bplus.Key, 4)
+		binary.BigEndian.PutUint32(key, uint32(i))
+		if err := tree.Insert(key, bplus.Value(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var r Registry
+	r.Register(NewStoreCollector("test", tree))
+	r.Register(NewTreeCollector("records", tree))
+	families, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]Family{}
+	for _, f := range families {
+		byName[f.Name] = f
+	}
+
+	records := byName["bplus_tree_records"].Metrics[0]
+	if records.Value != 100 {
+		t.Fatalf("expected %v == %v", records.Value, 100)
+	}
+	if records.Labels[0] != (Label{Name: "tree", Value: "records"}) {
+		t.Fatalf("expected %v == %v", records.Labels[0], Label{Name: "tree", Value: "records"})
+	}
+	height, err := tree.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := byName["bplus_tree_height"].Metrics[0].Value; value != float64(height) {
+		t.Fatalf("expected %v == %v", value, height)
+	}
+
+	cache := tree.CacheStats()
+	hits := byName["bplus_cache_hits_total"].Metrics[0]
+	if hits.Value == 0 || hits.Value > float64(cache.Hits) {
+		t.Fatalf("expected 0 < %v <= %v", hits.Value, cache.Hits)
+	}
+	if hits.Labels[0] != (Label{Name: "file", Value: "test"}) {
+		t.Fatalf("expected %v == %v", hits.Labels[0], Label{Name: "file", Value: "test"})
+	}
+	stats, err := tree.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := byName["bplus_pages"].Metrics[0].Value; value != float64(stats.Pages) {
+		t.Fatalf("expected %v == %v", value, stats.Pages)
+	}
+
+	// Every insert is committed, and the buckets count them cumulatively.
+	commits := byName["bplus_commit_duration_seconds"]
+	if commits.Type != Histogram {
+		t.Fatalf("expected %v == %v", commits.Type, Histogram)
+	}
+	latency := commits.Metrics[0]
+	if latency.Count < 100 {
+		t.Fatalf("expected %v >= %v", latency.Count, 100)
+	}
+	if len(latency.Buckets) != len(store.LatencyBuckets) {
+		t.Fatalf("expected %v == %v", len(latency.Buckets), len(store.LatencyBuckets))
+	}
+	for i := 1; i < len(latency.Buckets); i++ {
+		if latency.Buckets[i].Count < latency.Buckets[i-1].Count {
+			t.Fatalf("expected buckets to be cumulative, got %+v", latency.Buckets)
+		}
+	}
+	if last := latency.Buckets[len(latency.Buckets)-1].Count; last > latency.Count {
+		t.Fatalf("expected %v <= %v", last, latency.Count)
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"expvar"
+	"strings"
+)
+
+// PublishExpvar publishes the registry's metrics through the expvar package as a single
+// variable named prefix, for programs that don't use Prometheus. The collectors are only
+// collected when the variable is read, such as when /debug/vars is served. Its value is
+// an object with a field for every family, holding the family's metric if it has one
+// without labels, and otherwise an object of its metrics keyed by their labels, written
+// as name=value pairs separated by commas. A histogram is an object of its count, sum and
+// cumulative buckets keyed by their upper bounds. Like expvar.Publish, it panics if
+// prefix is already published.
+func (r *Registry) PublishExpvar(prefix string) {
+	expvar.Publish(prefix, expvar.Func(func() any {
+		families, err := r.Gather()
+		if err != nil {
+			return map[string]string{"error": err.Error()}
+		}
+		vars := make(map[string]any, len(families))
+		for _, f := range families {
+			if len(f.Metrics) == 1 && len(f.Metrics[0].Labels) == 0 {
+				vars[f.Name] = expvarValue(f.Type, f.Metrics[0])
+				continue
+			}
+			metrics := make(map[string]any, len(f.Metrics))
+			for _, m := range f.Metrics {
+				metrics[labelKey(m.Labels)] = expvarValue(f.Type, m)
+			}
+			vars[f.Name] = metrics
+		}
+		return vars
+	}))
+}
+
+func expvarValue(t Type, m Metric) any {
+	if t != Histogram {
+		return m.Value
+	}
+	buckets := make(map[string]uint64, len(m.Buckets)+1)
+	for _, b := range m.Buckets {
+		buckets[formatFloat(b.UpperBound)] = b.Count
+	}
+	buckets["+Inf"] = m.Count
+	return map[string]any{"count": m.Count, "sum": m.Sum, "buckets": buckets}
+}
+
+func labelKey(labels []Label) string {
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = l.Name + "=" + l.Value
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	var r Registry
+	r.PublishExpvar("publish_expvar")
+	collector := &staticCollector{[]Family{
+		{Name: "records", Help: "Records.", Type: Gauge, Metrics: []Metric{{Value: 7}}},
+		{
+			Name: "hits_total",
+			Help: "Hits.",
+			Type: Counter,
+			Metrics: []Metric{
+				{Labels: []Label{{Name: "file", Value: "a"}}, Value: 1},
+				{Labels: []Label{{Name: "file", Value: "b"}, {Name: "x", Value: "y"}}, Value: 2},
+			},
+		},
+		{
+			Name: "latency_seconds",
+			Help: "Latency.",
+			Type: Histogram,
+			Metrics: []Metric{{
+				Buckets: []Bucket{{UpperBound: 0.5, Count: 1}},
+				Count:   2,
+				Sum:     1.25,
+			}},
+		},
+	}}
+	// The metrics are collected when the variable is read, not when it's published.
+	r.Register(collector)
+	var vars struct {
+		Records float64            `json:"records"`
+		Hits    map[string]float64 `json:"hits_total"`
+		Latency struct {
+			Count   uint64            `json:"count"`
+			Sum     float64           `json:"sum"`
+			Buckets map[string]uint64 `json:"buckets"`
+		} `json:"latency_seconds"`
+	}
+	v := expvar.Get("publish_expvar")
+	if err := json.Unmarshal([]byte(v.String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Records != 7 {
+		t.Fatalf("expected %v == %v", vars.Records, 7)
+	}
+	if vars.Hits["file=a"] != 1 || vars.Hits["file=b,x=y"] != 2 {
+		t.Fatalf("expected hits of 1 and 2, got %v", vars.Hits)
+	}
+	if vars.Latency.Count != 2 || vars.Latency.Sum != 1.25 {
+		t.Fatalf("expected %+v == {2 1.25}", vars.Latency)
+	}
+	if vars.Latency.Buckets["0.5"] != 1 || vars.Latency.Buckets["+Inf"] != 2 {
+		t.Fatalf("expected buckets of 1 and 2, got %v", vars.Latency.Buckets)
+	}
+
+	r.Register(&staticCollector{[]Family{
+		{Name: "records", Help: "Records.", Type: Counter, Metrics: []Metric{{Value: 1}}},
+	}})
+	var failed map[string]string
+	if err := json.Unmarshal([]byte(v.String()), &failed); err != nil {
+		t.Fatal(err)
+	}
+	if failed["error"] != ErrInconsistentFamily.Error() {
+		t.Fatalf("expected %v == %v", failed["error"], ErrInconsistentFamily)
+	}
+}
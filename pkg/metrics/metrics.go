@@ -0,0 +1,233 @@
+// Package metrics exports the statistics of trees and the page stores they're kept in as
+// Prometheus metrics.
+//
+// Collectors gather the metrics, and a Registry holds the collectors of a program and
+// serves what they gather over HTTP in the Prometheus text exposition format, for
+// Prometheus to scrape. The format is written directly rather than through the
+// Prometheus client library, so the package has no dependencies, and programs that
+// already use the client library can bridge a Collector into their own registry by
+// converting the families it returns.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInconsistentFamily is returned when collectors return families of the same name but
+// of different types or with different help.
+var ErrInconsistentFamily = errors.New("metric family collected inconsistently")
+
+// Type is the type of a metric family.
+type Type int
+
+const (
+	// Counter is a value that only goes up, such as a count of events.
+	Counter Type = iota
+	// Gauge is a value that goes up and down, such as a size.
+	Gauge
+	// Histogram counts observations, such as latencies, in buckets.
+	Histogram
+)
+
+func (t Type) String() string {
+	switch t {
+	case Counter:
+		return "counter"
+	case Gauge:
+		return "gauge"
+	case Histogram:
+		return "histogram"
+	}
+	return "untyped"
+}
+
+// Family is a set of metrics of the same name and type, told apart by their labels.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Metrics []Metric
+}
+
+// Label is a name and value that tells a metric apart from the rest of its family.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metric is a single value of a family. Counters and gauges only have a Value, while
+// histograms have Buckets, Count and Sum instead.
+type Metric struct {
+	Labels []Label
+	Value  float64
+	// Buckets holds the number of observations at most each bucket's upper bound. They're
+	// cumulative, as in Prometheus, so every bucket counts the observations of the buckets
+	// before it too. The bucket for every observation, +Inf, is left out.
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64
+}
+
+// Bucket is a bucket of a histogram.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Collector gathers metrics each time it's collected.
+type Collector interface {
+	Collect() ([]Family, error)
+}
+
+// Registry holds collectors, and gathers and serves their metrics. Its zero value is
+// empty and ready to use.
+type Registry struct {
+	lock       sync.Mutex
+	collectors []Collector
+}
+
+// Register adds a collector to the registry.
+func (r *Registry) Register(c Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Unregister removes a collector from the registry, and returns whether it was there.
+// Collectors are compared with ==, so they should be pointers, as the collectors made by
+// this package are.
+func (r *Registry) Unregister(c Collector) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, registered := range r.collectors {
+		if registered == c {
+			r.collectors = append(r.collectors[:i], r.collectors[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Gather collects every registered collector. Families of the same name from different
+// collectors, such as those of two trees, are merged into one. The families are sorted
+// by name.
+func (r *Registry) Gather() ([]Family, error) {
+	r.lock.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.lock.Unlock()
+	byName := map[string]*Family{}
+	var names []string
+	for _, c := range collectors {
+		families, err := c.Collect()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range families {
+			merged, ok := byName[f.Name]
+			if !ok {
+				f.Metrics = append([]Metric{}, f.Metrics...)
+				byName[f.Name] = &f
+				names = append(names, f.Name)
+				continue
+			}
+			if merged.Type != f.Type || merged.Help != f.Help {
+				return nil, ErrInconsistentFamily
+			}
+			merged.Metrics = append(merged.Metrics, f.Metrics...)
+		}
+	}
+	sort.Strings(names)
+	families := make([]Family, len(names))
+	for i, name := range names {
+		families[i] = *byName[name]
+	}
+	return families, nil
+}
+
+// ServeHTTP serves the registry's metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	families, err := r.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteText(w, families)
+}
+
+// WriteText writes families in the Prometheus text exposition format.
+func WriteText(w io.Writer, families []Family) error {
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		b.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		b.WriteString("# TYPE " + f.Name + " " + f.Type.String() + "\n")
+		for _, m := range f.Metrics {
+			if f.Type != Histogram {
+				writeSample(b, f.Name, m.Labels, m.Value)
+				continue
+			}
+			for _, bucket := range m.Buckets {
+				labels := withLabel(m.Labels, "le", formatFloat(bucket.UpperBound))
+				writeSample(b, f.Name+"_bucket", labels, float64(bucket.Count))
+			}
+			labels := withLabel(m.Labels, "le", "+Inf")
+			writeSample(b, f.Name+"_bucket", labels, float64(m.Count))
+			writeSample(b, f.Name+"_sum", m.Labels, m.Sum)
+			writeSample(b, f.Name+"_count", m.Labels, float64(m.Count))
+		}
+	}
+	return b.Flush()
+}
+
+func writeSample(b *bufio.Writer, name string, labels []Label, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.Name + `="` + escapeLabel(l.Value) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// withLabel returns a copy of labels with one more label on the end.
+func withLabel(labels []Label, name, value string) []Label {
+	return append(append([]Label{}, labels...), Label{Name: name, Value: value})
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+// staticCollector always collects the same families.
+type staticCollector struct {
+	families []Family
+}
+
+func (c *staticCollector) Collect() ([]Family, error) {
+	return c.families, nil
+}
+
+func TestWriteText(t *testing.T) {
+	families := []Family{
+		{
+			Name: "requests_total",
+			Help: "Requests served.\nBy \\ everyone.",
+			Type: Counter,
+			Metrics: []Metric{
+				{Labels: []Label{{Name: "path", Value: `/a"b`}}, Value: 3},
+				{Value: 1.5},
+			},
+		},
+		{
+			Name:    "ratio",
+			Help:    "A ratio.",
+			Type:    Gauge,
+			Metrics: []Metric{{Value: math.Inf(1)}},
+		},
+		{
+			Name: "latency_seconds",
+			Help: "Latency.",
+			Type: Histogram,
+			Metrics: []Metric{{
+				Labels:  []Label{{Name: "file", Value: "f"}},
+				Buckets: []Bucket{{UpperBound: 0.001, Count: 1}, {UpperBound: 0.5, Count: 3}},
+				Count:   4,
+				Sum:     2.25,
+			}},
+		},
+	}
+	var b bytes.Buffer
+	if err := WriteText(&b, families); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP requests_total Requests served.\nBy \\ everyone.
+# TYPE requests_total counter
+requests_total{path="/a\"b"} 3
+requests_total 1.5
+# HELP ratio A ratio.
+# TYPE ratio gauge
+ratio +Inf
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{file="f",le="0.001"} 1
+latency_seconds_bucket{file="f",le="0.5"} 3
+latency_seconds_bucket{file="f",le="+Inf"} 4
+latency_seconds_sum{file="f"} 2.25
+latency_seconds_count{file="f"} 4
+`
+	if b.String() != expected {
+		t.Fatalf("expected %v == %v", b.String(), expected)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	a := &staticCollector{[]Family{
+		{Name: "b", Help: "B.", Type: Gauge, Metrics: []Metric{{Value: 1}}},
+		{Name: "a", Help: "A.", Type: Counter, Metrics: []Metric{{Value: 2}}},
+	}}
+	b := &staticCollector{[]Family{
+		{Name: "b", Help: "B.", Type: Gauge, Metrics: []Metric{{Value: 3}}},
+	}}
+	r.Register(a)
+	r.Register(b)
+	families, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Families of the same name are merged, and they're sorted by name.
+	if len(families) != 2 || families[0].Name != "a" || families[1].Name != "b" {
+		t.Fatalf("expected families a and b, got %+v", families)
+	}
+	if len(families[1].Metrics) != 2 {
+		t.Fatalf("expected %v == %v", len(families[1].Metrics), 2)
+	}
+	// Merging doesn't change what the collectors return.
+	if len(b.families[0].Metrics) != 1 {
+		t.Fatalf("expected %v == %v", len(b.families[0].Metrics), 1)
+	}
+
+	server := httptest.NewServer(&r)
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected bytes.Buffer
+	if err := WriteText(&expected, families); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != expected.String() {
+		t.Fatalf("expected %v == %v", string(body), expected.String())
+	}
+	contentType := "text/plain; version=0.0.4; charset=utf-8"
+	if resp.Header.Get("Content-Type") != contentType {
+		t.Fatalf("expected %v == %v", resp.Header.Get("Content-Type"), contentType)
+	}
+
+	if !r.Unregister(b) {
+		t.Fatalf("expected the collector to be unregistered")
+	}
+	if r.Unregister(b) {
+		t.Fatalf("expected the collector to already be unregistered")
+	}
+	inconsistent := &staticCollector{[]Family{
+		{Name: "a", Help: "A.", Type: Gauge, Metrics: []Metric{{Value: 2}}},
+	}}
+	r.Register(inconsistent)
+	if _, err := r.Gather(); err != ErrInconsistentFamily {
+		t.Fatalf("expected %v == %v", err, ErrInconsistentFamily)
+	}
+}
@@ -0,0 +1,126 @@
+// Package migrate upgrades files written in older formats to store.CurrentFormatVersion.
+//
+// Files in older formats can still be opened and written as they are, but only files in
+// the current format get the benefits of the newer layouts, such as the 64 bit page ids
+// of store.FormatVersion2, the free-space map of store.FormatVersion3 and the copy of the
+// header of store.FormatVersion4. Files in formats newer than the current one are refused
+// with store.ErrUnsupportedVersion.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// ErrNoMigration is returned when a file is in a format that there's no migration from.
+var ErrNoMigration = errors.New("no migration from format version")
+
+// migration upgrades files from one format version to a newer one.
+type migration struct {
+	// from is the format version the migration upgrades files from.
+	from int
+	// migrate writes the file src, which is in the format from, to the new file dst in a
+	// newer format.
+	migrate func(src, dst string) error
+}
+
+// migrations holds a migration from every format version older than the current one,
+// which are applied one after another until a file is in the current format.
+var migrations = []migration{
+	{from: store.FormatVersion1, migrate: rebuild},
+	{from: store.FormatVersion2, migrate: rebuild},
+	{from: store.FormatVersion3, migrate: rebuild},
+}
+
+// rebuild rewrites the file in the current format by copying every tree in it, which
+// lays out all of its pages in the current format at once.
+func rebuild(src, dst string) error {
+	return bplus.Copy(src, dst)
+}
+
+// Version returns the format version of the file.
+func Version(filename string) (int, error) {
+	s, err := store.NewPageStore(filename, store.WithReadOnly())
+	if err == store.ErrNeedsRecovery {
+		// Recovering the file may change its header, so it's opened for writing once.
+		s, err = store.NewPageStore(filename)
+	}
+	if err != nil {
+		return 0, err
+	}
+	version := s.Version()
+	return version, s.Close()
+}
+
+// Migrate upgrades the file to the current format in place. The upgraded file is written
+// alongside it and then renamed over it, so a crash part way through leaves the file as
+// it was. Files already in the current format are left untouched.
+func Migrate(filename string) error {
+	version, err := Version(filename)
+	if err != nil {
+		return err
+	}
+	if version == store.CurrentFormatVersion {
+		return nil
+	}
+	tmp := filename + ".migrate"
+	err = MigrateTo(filename, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// MigrateTo writes the file src to the new file dst in the current format, leaving src
+// as it was. A file already in the current format is copied as it is, see bplus.Copy.
+func MigrateTo(src, dst string) error {
+	version, err := Version(src)
+	if err != nil {
+		return err
+	}
+	if version == store.CurrentFormatVersion {
+		return bplus.Copy(src, dst)
+	}
+	// Every migration but the last writes to a file of its own, which is removed once the
+	// next migration has read it.
+	from := src
+	for step := 1; ; step++ {
+		m, ok := find(version)
+		if !ok {
+			return ErrNoMigration
+		}
+		to := fmt.Sprintf("%s.%d", dst, step)
+		err = m.migrate(from, to)
+		if from != src {
+			os.Remove(from)
+		}
+		if err != nil {
+			os.Remove(to)
+			return err
+		}
+		from = to
+		version, err = Version(to)
+		if err != nil {
+			os.Remove(to)
+			return err
+		}
+		if version == store.CurrentFormatVersion {
+			return os.Rename(to, dst)
+		}
+	}
+}
+
+// find returns the migration from the format version.
+func find(version int) (migration, bool) {
+	for _, m := range migrations {
+		if m.from == version {
+			return m, true
+		}
+	}
+	return migration{}, false
+}
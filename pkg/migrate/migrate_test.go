@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestMigrate(t *testing.T) {
+	filename := newVersion1Tree(t, "migrate")
+	if err := Migrate(filename); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, filename, store.CurrentFormatVersion)
+	expectRecords(t, filename)
+	if _, err := os.Stat(filename + ".migrate"); !os.IsNotExist(err) {
+		t.Fatalf("expected the migrated file to be renamed, got %v", err)
+	}
+	// Migrating a file that's already in the current format leaves it alone.
+	if err := Migrate(filename); err != nil {
+		t.Fatal(err)
+	}
+	expectRecords(t, filename)
+}
+
+func TestMigrateTo(t *testing.T) {
+	src := newVersion1Tree(t, "migrate_to_src")
+	dst := src + ".migrated"
+	defer os.Remove(dst)
+	if err := MigrateTo(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, src, store.FormatVersion1)
+	expectVersion(t, dst, store.CurrentFormatVersion)
+	expectRecords(t, src)
+	expectRecords(t, dst)
+	if _, err := os.Stat(dst + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("expected the migration's own file to be renamed, got %v", err)
+	}
+}
+
+func TestVersionOfMissingFile(t *testing.T) {
+	if _, err := Version("/nonexistent/migrate"); !os.IsNotExist(err) {
+		t.Fatalf("expected %v to be a missing file", err)
+	}
+}
+
+func TestMigrateFromVersion2(t *testing.T) {
+	filename := newTreeInVersion(t, "migrate_version2", store.FormatVersion2)
+	if err := Migrate(filename); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, filename, store.CurrentFormatVersion)
+	expectRecords(t, filename)
+}
+
+func TestMigrateFromVersion3(t *testing.T) {
+	filename := newTreeInVersion(t, "migrate_version3", store.FormatVersion3)
+	if err := Migrate(filename); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, filename, store.CurrentFormatVersion)
+	expectRecords(t, filename)
+}
+
+func newVersion1Tree(t *testing.T, pattern string) string {
+	return newTreeInVersion(t, pattern, store.FormatVersion1)
+}
+
+func newTreeInVersion(t *testing.T, pattern string, version int) string {
+	tmpfile, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	tree, err := bplus.NewTree(
+		tmpfile.Name(),
+		bplus.WithBranchingFactor(4),
+		bplus.WithFormatVersion(version),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		if err := tree.Insert(intKey(i), intKey(i*2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, tmpfile.Name(), version)
+	return tmpfile.Name()
+}
+
+func expectVersion(t *testing.T, filename string, expected int) {
+	t.Helper()
+	version, err := Version(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected {
+		t.Fatalf("expected %v == %v", version, expected)
+	}
+}
+
+func expectRecords(t *testing.T, filename string) {
+	t.Helper()
+	tree, err := bplus.OpenTree(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if _, err := tree.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 300 {
+		t.Fatalf("expected %v == %v", tree.Len(), 300)
+	}
+	for i := 0; i < 300; i++ {
+		value, err := tree.Read(intKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, intKey(i*2)) {
+			t.Fatalf("expected %v == %v", value, intKey(i*2))
+		}
+	}
+}
+
+func intKey(i int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(i))
+}
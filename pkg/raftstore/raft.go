@@ -0,0 +1,69 @@
+//go:build raft
+
+package raftstore
+
+import (
+	"github.com/hashicorp/raft"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+var (
+	_ raft.LogStore    = (*RaftLogStore)(nil)
+	_ raft.StableStore = (*StableStore)(nil)
+)
+
+// RaftLogStore implements raft.LogStore with a LogStore, converting between raft.Log and
+// Log. It's only built with the raft tag, which needs github.com/hashicorp/raft.
+type RaftLogStore struct {
+	*LogStore
+}
+
+// NewRaftLogStore returns a raft.LogStore kept in tree, see NewLogStore.
+func NewRaftLogStore(tree *bplus.Tree) *RaftLogStore {
+	return &RaftLogStore{LogStore: NewLogStore(tree)}
+}
+
+// GetLog reads the entry at index into log, or returns raft.ErrLogNotFound if the log
+// doesn't have one.
+func (s *RaftLogStore) GetLog(index uint64, log *raft.Log) error {
+	var l Log
+	err := s.LogStore.GetLog(index, &l)
+	if err == ErrLogNotFound {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	*log = raft.Log{
+		Index:      l.Index,
+		Term:       l.Term,
+		Type:       raft.LogType(l.Type),
+		Data:       l.Data,
+		Extensions: l.Extensions,
+		AppendedAt: l.AppendedAt,
+	}
+	return nil
+}
+
+// StoreLog stores an entry, replacing the one at the same index if there is one.
+func (s *RaftLogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores many entries in a single write, so that either all of them are stored
+// or none of them are.
+func (s *RaftLogStore) StoreLogs(logs []*raft.Log) error {
+	converted := make([]*Log, len(logs))
+	for i, log := range logs {
+		converted[i] = &Log{
+			Index:      log.Index,
+			Term:       log.Term,
+			Type:       LogType(log.Type),
+			Data:       log.Data,
+			Extensions: log.Extensions,
+			AppendedAt: log.AppendedAt,
+		}
+	}
+	return s.LogStore.StoreLogs(converted)
+}
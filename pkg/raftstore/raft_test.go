@@ -0,0 +1,201 @@
+//go:build raft
+
+package raftstore
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func raftLog(index, term uint64, data string) *raft.Log {
+	return &raft.Log{
+		Index:      index,
+		Term:       term,
+		Type:       raft.LogCommand,
+		Data:       []byte(data),
+		AppendedAt: time.Unix(0, int64(index)),
+	}
+}
+
+func checkRaftIndexes(t *testing.T, s raft.LogStore, first, last uint64) {
+	t.Helper()
+	index, err := s.FirstIndex()
+	if err != nil || index != first {
+		t.Fatalf("expected %v == %v, got %v", index, first, err)
+	}
+	index, err = s.LastIndex()
+	if err != nil || index != last {
+		t.Fatalf("expected %v == %v, got %v", index, last, err)
+	}
+}
+
+func checkRaftLog(t *testing.T, s raft.LogStore, expected *raft.Log) {
+	t.Helper()
+	var log raft.Log
+	if err := s.GetLog(expected.Index, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Index != expected.Index || log.Term != expected.Term ||
+		log.Type != expected.Type || !bytes.Equal(log.Data, expected.Data) ||
+		!bytes.Equal(log.Extensions, expected.Extensions) ||
+		!log.AppendedAt.Equal(expected.AppendedAt) {
+		t.Fatalf("expected %+v == %+v", log, *expected)
+	}
+}
+
+// TestRaftLogStore runs the cases raft-boltdb checks its raft.LogStore against.
+func TestRaftLogStore(t *testing.T) {
+	s := NewRaftLogStore(newTree(t, "raft_log_store"))
+	checkRaftIndexes(t, s, 0, 0)
+	var log raft.Log
+	if err := s.GetLog(1, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected %v == %v", err, raft.ErrLogNotFound)
+	}
+
+	// A single entry, then a batch of them.
+	first := raftLog(1, 1, "first")
+	first.Type = raft.LogConfiguration
+	first.Extensions = []byte("extension")
+	if err := s.StoreLog(first); err != nil {
+		t.Fatal(err)
+	}
+	checkRaftIndexes(t, s, 1, 1)
+	checkRaftLog(t, s, first)
+	logs := []*raft.Log{first}
+	var batch []*raft.Log
+	for i := uint64(2); i <= 10; i++ {
+		batch = append(batch, raftLog(i, 2, "entry"))
+	}
+	if err := s.StoreLogs(batch); err != nil {
+		t.Fatal(err)
+	}
+	logs = append(logs, batch...)
+	checkRaftIndexes(t, s, 1, 10)
+	for _, expected := range logs {
+		checkRaftLog(t, s, expected)
+	}
+
+	// Storing an entry at an index that's already taken replaces it.
+	replaced := raftLog(10, 3, "replaced")
+	if err := s.StoreLog(replaced); err != nil {
+		t.Fatal(err)
+	}
+	checkRaftLog(t, s, replaced)
+
+	// Compacting the start of the log and truncating the end of it.
+	if err := s.DeleteRange(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	checkRaftIndexes(t, s, 4, 10)
+	if err := s.DeleteRange(9, 10); err != nil {
+		t.Fatal(err)
+	}
+	checkRaftIndexes(t, s, 4, 8)
+	for _, index := range []uint64{1, 3, 9, 10} {
+		if err := s.GetLog(index, &log); err != raft.ErrLogNotFound {
+			t.Fatalf("expected %v == %v for %v", err, raft.ErrLogNotFound, index)
+		}
+	}
+	for _, expected := range logs[3:8] {
+		checkRaftLog(t, s, expected)
+	}
+	if err := s.DeleteRange(4, 8); err != nil {
+		t.Fatal(err)
+	}
+	checkRaftIndexes(t, s, 0, 0)
+}
+
+// countingFSM counts the commands applied to it.
+type countingFSM struct {
+	lock    sync.Mutex
+	applied int
+}
+
+func (f *countingFSM) Apply(*raft.Log) interface{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.applied++
+	return nil
+}
+
+func (f *countingFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return countingSnapshot{}, nil
+}
+
+func (f *countingFSM) Restore(snapshot io.ReadCloser) error {
+	return snapshot.Close()
+}
+
+type countingSnapshot struct{}
+
+func (countingSnapshot) Persist(sink raft.SnapshotSink) error {
+	return sink.Close()
+}
+
+func (countingSnapshot) Release() {}
+
+func TestRaftLogStoreWithRaft(t *testing.T) {
+	logs := NewRaftLogStore(newTree(t, "raft_node_log"))
+	stable := NewStableStore(newTree(t, "raft_node_stable"))
+	snaps := raft.NewInmemSnapshotStore()
+	_, trans := raft.NewInmemTransport("")
+	config := raft.DefaultConfig()
+	config.LocalID = "node"
+	config.HeartbeatTimeout = 50 * time.Millisecond
+	config.ElectionTimeout = 50 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.CommitTimeout = 5 * time.Millisecond
+	configuration := raft.Configuration{Servers: []raft.Server{{
+		ID:      config.LocalID,
+		Address: trans.LocalAddr(),
+	}}}
+	err := raft.BootstrapCluster(config, logs, stable, snaps, trans, configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := &countingFSM{}
+	r, err := raft.NewRaft(config, fsm, logs, stable, snaps, trans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown()
+	select {
+	case <-r.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the node to become the leader")
+	}
+
+	var last raft.ApplyFuture
+	for i := 0; i < 20; i++ {
+		last = r.Apply([]byte("command"), time.Second)
+	}
+	if err := last.Error(); err != nil {
+		t.Fatal(err)
+	}
+	// Raft read and wrote its log through the store.
+	index, err := logs.LastIndex()
+	if err != nil || index < last.Index() {
+		t.Fatalf("expected %v >= %v, got %v", index, last.Index(), err)
+	}
+	var log raft.Log
+	if err := logs.GetLog(last.Index(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Type != raft.LogCommand || string(log.Data) != "command" {
+		t.Fatalf("expected a command, got %+v", log)
+	}
+	term, err := stable.GetUint64([]byte("CurrentTerm"))
+	if err != nil || term == 0 {
+		t.Fatalf("expected a current term, got %v and %v", term, err)
+	}
+	fsm.lock.Lock()
+	defer fsm.lock.Unlock()
+	if fsm.applied != 20 {
+		t.Fatalf("expected %v == %v", fsm.applied, 20)
+	}
+}
@@ -0,0 +1,232 @@
+// Package raftstore keeps the log and the stable state of a Raft node in B+ trees from
+// package bplus, so that a tree can be the persistence layer of a service built on
+// github.com/hashicorp/raft in place of raft-boltdb.
+//
+// LogStore and StableStore only depend on the standard library. StableStore already
+// implements raft.StableStore, while LogStore stores a Log of its own that mirrors
+// raft.Log. Building with the raft tag adds RaftLogStore, which implements
+// raft.LogStore by converting between the two:
+//
+//	logs, err := bplus.NewTree("raft.log", bplus.WithValueLog(4096, 0))
+//	...
+//	stable, err := bplus.NewTree("raft.stable")
+//	...
+//	r, err := raft.NewRaft(config, fsm,
+//		raftstore.NewRaftLogStore(logs), raftstore.NewStableStore(stable), snaps, trans)
+//
+// Entries too large to fit in a leaf can only be stored in a tree that keeps a value
+// log, see bplus.WithValueLog.
+package raftstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+var (
+	// ErrLogNotFound is returned when getting a log entry that isn't in the store. It has
+	// the same text as raft.ErrLogNotFound, which RaftLogStore returns in its place.
+	ErrLogNotFound = errors.New("log not found")
+	// ErrKeyNotFound is returned when getting a key that hasn't been set. Raft recognizes
+	// a missing key by the text of the error, which is the same as raft-boltdb's.
+	ErrKeyNotFound = errors.New("not found")
+	// ErrCorrupt is returned when a log entry, or a value set with SetUint64, can't be
+	// decoded.
+	ErrCorrupt = errors.New("corrupt raft store entry")
+)
+
+// LogType is the type of a log entry, see raft.LogType.
+type LogType uint8
+
+// Log is an entry in a Raft log, see raft.Log.
+type Log struct {
+	// Index holds the index of the log entry.
+	Index uint64
+	// Term holds the election term of the log entry.
+	Term uint64
+	// Type holds the type of the log entry.
+	Type LogType
+	// Data holds the log entry's type-specific data.
+	Data []byte
+	// Extensions holds an opaque byte slice of information for middleware.
+	Extensions []byte
+	// AppendedAt stores the time the leader first appended this log to its log store.
+	AppendedAt time.Time
+}
+
+// logHeaderSize is the size of an encoded log entry without its data and extensions: its
+// term, type, the time it was appended at and the length of its data.
+const logHeaderSize = 8 + 1 + 8 + 4
+
+// LogStore is a Raft log kept in a tree, with each entry stored under its index encoded
+// as 8 big-endian bytes so that the entries are kept in order. It's safe to use from
+// many goroutines at once.
+type LogStore struct {
+	tree *bplus.Tree
+}
+
+// NewLogStore returns a log kept in tree, which holds the entries stored in it before if
+// it was opened rather than created. The tree mustn't be written to other than through
+// the log.
+func NewLogStore(tree *bplus.Tree) *LogStore {
+	return &LogStore{tree: tree}
+}
+
+// FirstIndex returns the index of the first entry in the log, or 0 if it's empty.
+func (s *LogStore) FirstIndex() (uint64, error) {
+	record, err := s.tree.Min()
+	return s.index(record, err)
+}
+
+// LastIndex returns the index of the last entry in the log, or 0 if it's empty.
+func (s *LogStore) LastIndex() (uint64, error) {
+	record, err := s.tree.Max()
+	return s.index(record, err)
+}
+
+func (s *LogStore) index(record bplus.Record, err error) (uint64, error) {
+	if err == bplus.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(record.Key) != 8 {
+		return 0, ErrCorrupt
+	}
+	return binary.BigEndian.Uint64(record.Key), nil
+}
+
+// GetLog reads the entry at index into log, or returns ErrLogNotFound if the log doesn't
+// have one.
+func (s *LogStore) GetLog(index uint64, log *Log) error {
+	value, err := s.tree.Read(logKey(index))
+	if err == bplus.ErrKeyNotFound {
+		return ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return decodeLog(index, value, log)
+}
+
+// StoreLog stores an entry, replacing the one at the same index if there is one.
+func (s *LogStore) StoreLog(log *Log) error {
+	return s.StoreLogs([]*Log{log})
+}
+
+// StoreLogs stores many entries in a single write, so that either all of them are stored
+// or none of them are.
+func (s *LogStore) StoreLogs(logs []*Log) error {
+	var b bplus.WriteBatch
+	for _, log := range logs {
+		b.Put(logKey(log.Index), encodeLog(log))
+	}
+	return s.tree.Apply(&b)
+}
+
+// DeleteRange deletes the entries with indexes from min to max, inclusive.
+func (s *LogStore) DeleteRange(min, max uint64) error {
+	if min > max {
+		return nil
+	}
+	// Appending a zero byte to the last key gives the smallest key after it.
+	_, err := s.tree.DeleteRange(logKey(min), append(logKey(max), 0))
+	return err
+}
+
+func logKey(index uint64) bplus.Key {
+	key := make(bplus.Key, 8)
+	binary.BigEndian.PutUint64(key, index)
+	return key
+}
+
+func encodeLog(log *Log) bplus.Value {
+	buf := make([]byte, logHeaderSize, logHeaderSize+len(log.Data)+len(log.Extensions))
+	binary.LittleEndian.PutUint64(buf[0:8], log.Term)
+	buf[8] = byte(log.Type)
+	var appendedAt int64
+	if !log.AppendedAt.IsZero() {
+		appendedAt = log.AppendedAt.UnixNano()
+	}
+	binary.LittleEndian.PutUint64(buf[9:17], uint64(appendedAt))
+	binary.LittleEndian.PutUint32(buf[17:21], uint32(len(log.Data)))
+	buf = append(buf, log.Data...)
+	return append(buf, log.Extensions...)
+}
+
+func decodeLog(index uint64, buf []byte, log *Log) error {
+	if len(buf) < logHeaderSize {
+		return ErrCorrupt
+	}
+	dataSize := int(binary.LittleEndian.Uint32(buf[17:21]))
+	if len(buf)-logHeaderSize < dataSize {
+		return ErrCorrupt
+	}
+	*log = Log{
+		Index: index,
+		Term:  binary.LittleEndian.Uint64(buf[0:8]),
+		Type:  LogType(buf[8]),
+	}
+	if appendedAt := int64(binary.LittleEndian.Uint64(buf[9:17])); appendedAt != 0 {
+		log.AppendedAt = time.Unix(0, appendedAt)
+	}
+	rest := buf[logHeaderSize:]
+	if dataSize > 0 {
+		log.Data = rest[:dataSize]
+	}
+	if len(rest) > dataSize {
+		log.Extensions = rest[dataSize:]
+	}
+	return nil
+}
+
+// StableStore holds the keys a Raft node needs to keep across restarts, such as its
+// current term and who it last voted for, in a tree. It implements raft.StableStore and
+// is safe to use from many goroutines at once.
+type StableStore struct {
+	tree *bplus.Tree
+}
+
+// NewStableStore returns a stable store kept in tree. The tree mustn't be written to
+// other than through the stable store.
+func NewStableStore(tree *bplus.Tree) *StableStore {
+	return &StableStore{tree: tree}
+}
+
+// Set sets key to val.
+func (s *StableStore) Set(key, val []byte) error {
+	return s.tree.Upsert(key, val)
+}
+
+// Get returns the value of key, or ErrKeyNotFound if it hasn't been set.
+func (s *StableStore) Get(key []byte) ([]byte, error) {
+	value, err := s.tree.Read(key)
+	if err == bplus.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	return value, err
+}
+
+// SetUint64 sets key to val.
+func (s *StableStore) SetUint64(key []byte, val uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], val)
+	return s.Set(key, buf[:])
+}
+
+// GetUint64 returns the value of a key set by SetUint64, or ErrKeyNotFound if it hasn't
+// been set.
+func (s *StableStore) GetUint64(key []byte) (uint64, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, ErrCorrupt
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
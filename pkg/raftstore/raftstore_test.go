@@ -0,0 +1,145 @@
+package raftstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newTree(t *testing.T, filename string, opts ...bplus.Option) *bplus.Tree {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() {
+		os.Remove(tmpfile.Name())
+		logs, _ := filepath.Glob(tmpfile.Name() + ".vlog*")
+		for _, log := range logs {
+			os.Remove(log)
+		}
+	})
+	tree, err := bplus.NewTree(tmpfile.Name(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+func checkIndexes(t *testing.T, s *LogStore, first, last uint64) {
+	t.Helper()
+	index, err := s.FirstIndex()
+	if err != nil || index != first {
+		t.Fatalf("expected %v == %v, got %v", index, first, err)
+	}
+	index, err = s.LastIndex()
+	if err != nil || index != last {
+		t.Fatalf("expected %v == %v, got %v", index, last, err)
+	}
+}
+
+func TestLogStore(t *testing.T) {
+	s := NewLogStore(newTree(t, "raft_log"))
+	checkIndexes(t, s, 0, 0)
+	appendedAt := time.Unix(0, 1700000000123456789)
+	var logs []*Log
+	for i := uint64(1); i <= 300; i++ {
+		logs = append(logs, &Log{
+			Index:      i,
+			Term:       i / 100,
+			Type:       LogType(i % 3),
+			Data:       []byte{byte(i), byte(i >> 8)},
+			AppendedAt: appendedAt,
+		})
+	}
+	logs[10].Extensions = []byte("extension")
+	logs[20].Data = nil
+	if err := s.StoreLogs(logs[:299]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StoreLog(logs[299]); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, s, 1, 300)
+	for _, expected := range logs {
+		var log Log
+		if err := s.GetLog(expected.Index, &log); err != nil {
+			t.Fatal(err)
+		}
+		if log.Index != expected.Index || log.Term != expected.Term ||
+			log.Type != expected.Type || !bytes.Equal(log.Data, expected.Data) ||
+			!bytes.Equal(log.Extensions, expected.Extensions) ||
+			!log.AppendedAt.Equal(expected.AppendedAt) {
+			t.Fatalf("expected %+v == %+v", log, *expected)
+		}
+	}
+	var log Log
+	if err := s.GetLog(301, &log); err != ErrLogNotFound {
+		t.Fatalf("expected %v == %v", err, ErrLogNotFound)
+	}
+
+	// Raft compacts the start of the log and truncates conflicting entries from its end.
+	if err := s.DeleteRange(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRange(251, 300); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, s, 101, 250)
+	if err := s.GetLog(100, &log); err != ErrLogNotFound {
+		t.Fatalf("expected %v == %v", err, ErrLogNotFound)
+	}
+	if err := s.DeleteRange(0, ^uint64(0)); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, s, 0, 0)
+}
+
+func TestLogStoreLargeEntries(t *testing.T) {
+	s := NewLogStore(newTree(t, "raft_log_large", bplus.WithValueLog(1024, 0)))
+	data := bytes.Repeat([]byte("x"), 64<<10)
+	if err := s.StoreLog(&Log{Index: 1, Term: 1, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	var log Log
+	if err := s.GetLog(1, &log); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(log.Data, data) {
+		t.Fatalf("expected %v == %v", len(log.Data), len(data))
+	}
+}
+
+func TestStableStore(t *testing.T) {
+	s := NewStableStore(newTree(t, "raft_stable"))
+	if _, err := s.Get([]byte("LastVoteCand")); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if _, err := s.GetUint64([]byte("CurrentTerm")); err != ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, ErrKeyNotFound)
+	}
+	if err := s.Set([]byte("LastVoteCand"), []byte("node1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUint64([]byte("CurrentTerm"), 7); err != nil {
+		t.Fatal(err)
+	}
+	value, err := s.Get([]byte("LastVoteCand"))
+	if err != nil || string(value) != "node1" {
+		t.Fatalf("expected %q == %q, got %v", value, "node1", err)
+	}
+	term, err := s.GetUint64([]byte("CurrentTerm"))
+	if err != nil || term != 7 {
+		t.Fatalf("expected %v == %v, got %v", term, 7, err)
+	}
+	if _, err := s.GetUint64([]byte("LastVoteCand")); err != ErrCorrupt {
+		t.Fatalf("expected %v == %v", err, ErrCorrupt)
+	}
+}
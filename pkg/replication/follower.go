@@ -0,0 +1,130 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// Follower keeps a replica of a primary's file up to date, see Primary.
+type Follower struct {
+	filename string
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewFollower returns a follower that keeps a replica in filename, which is created by
+// the first snapshot it's sent if it doesn't exist yet.
+func NewFollower(filename string) *Follower {
+	return &Follower{filename: filename}
+}
+
+// Status returns how far the follower has got.
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+// Run connects to the primary listening on addr and writes what it's sent to the replica
+// until ctx is done, when it returns ctx's error, or until the connection fails. Run can
+// be called again to reconnect, and picks up from the last group the replica holds. A
+// snapshot is written over the replica, which is left unusable if it's cut short, and is
+// sent again when the follower reconnects.
+func (f *Follower) Run(ctx context.Context, addr string) error {
+	file, err := os.OpenFile(f.filename, os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// A replica that's empty, or was cut short, is sent a snapshot.
+	lsn, err := store.ReadLSN(file)
+	if err != nil {
+		lsn = 0
+	}
+	f.applied(lsn)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	err = f.follow(conn, file, lsn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// follow writes the messages the primary sends over conn to the replica kept in file.
+func (f *Follower) follow(conn net.Conn, file *os.File, lsn uint64) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	err := writeHello(w, lsn)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		kind, primaryLSN, err := readMessageHeader(r)
+		if err != nil {
+			return err
+		}
+		f.contacted(primaryLSN)
+		switch kind {
+		case msgSnapshot:
+			err = file.Truncate(0)
+			if err != nil {
+				return err
+			}
+			lsn, err = store.RecoverTo(r, nil, file, store.RecoveryTarget{})
+		case msgSegment:
+			var segment store.WALSegment
+			segment, err = readSegment(r)
+			if err != nil {
+				return err
+			}
+			lsn, err = store.ApplyWALSegment(file, segment)
+		case msgHeartbeat:
+			continue
+		default:
+			return ErrProtocol
+		}
+		if err != nil {
+			return err
+		}
+		f.applied(lsn)
+		err = writeUint64(w, lsn)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Follower) applied(lsn uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.LSN = lsn
+}
+
+func (f *Follower) contacted(primaryLSN uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.PrimaryLSN = primaryLSN
+	f.status.LastContact = time.Now()
+}
@@ -0,0 +1,204 @@
+package replication
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// tempFilename returns the name of a file that doesn't exist yet, which is removed along
+// with its write-ahead log once the test is done.
+func tempFilename(t *testing.T, pattern string) string {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	os.Remove(tmpfile.Name())
+	t.Cleanup(func() {
+		os.Remove(tmpfile.Name())
+		os.Remove(tmpfile.Name() + ".wal")
+	})
+	return tmpfile.Name()
+}
+
+// startPrimary creates a tree replicated by a primary listening on a local port.
+func startPrimary(t *testing.T, opts ...PrimaryOption) (*bplus.Tree, *Primary, string) {
+	t.Helper()
+	primary := NewPrimary(append([]PrimaryOption{
+		WithHeartbeatInterval(10 * time.Millisecond),
+	}, opts...)...)
+	tree, err := bplus.NewTree(tempFilename(t, "primary"), bplus.WithWALArchive(primary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	if err := tree.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- primary.Serve(l, tree)
+	}()
+	t.Cleanup(func() {
+		primary.Close()
+		if err := <-served; err != ErrPrimaryClosed {
+			t.Errorf("expected %v == %v", err, ErrPrimaryClosed)
+		}
+	})
+	return tree, primary, l.Addr().String()
+}
+
+// startFollower runs a follower until the test is done, or the returned function is
+// called.
+func startFollower(t *testing.T, follower *Follower, addr string) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() {
+		ran <- follower.Run(ctx, addr)
+	}()
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cancel()
+		if err := <-ran; err != context.Canceled {
+			t.Errorf("expected %v == %v", err, context.Canceled)
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// waitFor waits for the follower to catch up with the tree.
+func waitFor(t *testing.T, follower *Follower, tree *bplus.Tree) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := follower.Status()
+		if status.LSN == tree.LSN() && status.Lag() == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the follower to catch up with %d, got %+v", tree.LSN(), status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func insert(t *testing.T, tree *bplus.Tree, start, end int) {
+	t.Helper()
+	for i := start; i < end; i++ {
+		if err := tree.Upsert(intKey(i), bplus.Value(intKey(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func intKey(i int) bplus.Key {
+	key := make(bplus.Key, 8)
+	binary.BigEndian.PutUint64(key, uint64(i))
+	return key
+}
+
+// checkReplica opens the replica and checks it holds the records inserted.
+func checkReplica(t *testing.T, filename string, n int) {
+	t.Helper()
+	replica, err := bplus.OpenTree(filename, bplus.WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	if replica.Len() != n {
+		t.Fatalf("expected %v == %v", replica.Len(), n)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := replica.Read(intKey(i)); err != nil {
+			t.Fatalf("expected key %d in the replica, got %v", i, err)
+		}
+	}
+}
+
+func TestFollower(t *testing.T) {
+	tree, primary, addr := startPrimary(t)
+	insert(t, tree, 0, 100)
+	filename := tempFilename(t, "replica")
+	follower := NewFollower(filename)
+	stop := startFollower(t, follower, addr)
+	// The follower starts from a snapshot, then is sent the changes after it.
+	waitFor(t, follower, tree)
+	insert(t, tree, 100, 200)
+	waitFor(t, follower, tree)
+	// The primary hears the follower acknowledge the last group soon after.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		followers := primary.Followers()
+		if len(followers) == 1 && followers[0].LSN == tree.LSN() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one follower at %d, got %+v", tree.LSN(), followers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	checkReplica(t, filename, 200)
+
+	// Reconnecting picks up where the replica left off.
+	insert(t, tree, 200, 300)
+	stop = startFollower(t, follower, addr)
+	waitFor(t, follower, tree)
+	stop()
+	checkReplica(t, filename, 300)
+}
+
+func TestFollowerFellBehind(t *testing.T) {
+	// The backlog only holds the last segment.
+	tree, _, addr := startPrimary(t, WithBacklogSize(1))
+	insert(t, tree, 0, 10)
+	filename := tempFilename(t, "replica_fell_behind")
+	follower := NewFollower(filename)
+	stop := startFollower(t, follower, addr)
+	waitFor(t, follower, tree)
+	stop()
+	insert(t, tree, 10, 100)
+	// The follower is sent another snapshot.
+	stop = startFollower(t, follower, addr)
+	waitFor(t, follower, tree)
+	stop()
+	checkReplica(t, filename, 100)
+}
+
+func TestFollowerOtherPrimary(t *testing.T) {
+	tree, _, addr := startPrimary(t)
+	insert(t, tree, 0, 10)
+	filename := tempFilename(t, "replica_other_primary")
+	follower := NewFollower(filename)
+	stop := startFollower(t, follower, addr)
+	waitFor(t, follower, tree)
+	stop()
+	// A replica of one file isn't sent the changes to another.
+	other, _, otherAddr := startPrimary(t)
+	insert(t, other, 0, 20)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := follower.Run(ctx, otherAddr); err != store.ErrWrongStore {
+		t.Fatalf("expected %v == %v", err, store.ErrWrongStore)
+	}
+	checkReplica(t, filename, 10)
+}
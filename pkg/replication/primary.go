@@ -0,0 +1,301 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+const (
+	// DefaultBacklogSize is the bytes of segments a primary keeps for followers to catch up
+	// from unless it's created with WithBacklogSize.
+	DefaultBacklogSize = 16 << 20
+	// DefaultHeartbeatInterval is how often a primary tells followers it has nothing new
+	// unless it's created with WithHeartbeatInterval.
+	DefaultHeartbeatInterval = time.Second
+)
+
+// ErrPrimaryClosed is returned by Serve once the primary has been closed.
+var ErrPrimaryClosed = errors.New("primary closed")
+
+// Source is what a primary replicates, which is a tree, see bplus.Tree, or a page store.
+type Source interface {
+	// Backup writes a copy of the file to w, see store.Backup.
+	Backup(w io.Writer) error
+	// LSN returns the log sequence number of the last group of writes committed.
+	LSN() uint64
+}
+
+// PrimaryOption configures a primary, see NewPrimary.
+type PrimaryOption func(*Primary)
+
+// WithBacklogSize sets the bytes of segments a primary keeps for followers to catch up
+// from. Followers that fall further behind are sent a snapshot instead.
+func WithBacklogSize(bytes int64) PrimaryOption {
+	return func(p *Primary) {
+		p.backlogSize = bytes
+	}
+}
+
+// WithHeartbeatInterval sets how often a primary tells followers it has nothing new, so
+// that they know how far behind they are, see Status.
+func WithHeartbeatInterval(interval time.Duration) PrimaryOption {
+	return func(p *Primary) {
+		p.heartbeat = interval
+	}
+}
+
+// Primary serves the groups of writes committed to a file's write-ahead log to
+// followers. It's the file's archiver, see store.WALArchiver, so it has to be created
+// before the file is opened, and Serve is called once it has been.
+type Primary struct {
+	backlogSize int64
+	heartbeat   time.Duration
+
+	mu sync.Mutex
+	// backlog holds the most recent segments, oldest first, which take up backlogBytes.
+	backlog      []store.WALSegment
+	backlogBytes int64
+	// changed is closed, and replaced, every time a segment is added to the backlog.
+	changed   chan struct{}
+	listeners map[net.Listener]struct{}
+	followers map[*follower]struct{}
+	closed    bool
+}
+
+// follower is a follower connected to a primary.
+type follower struct {
+	conn net.Conn
+	// lsn is the last log sequence number the follower acknowledged.
+	lsn uint64
+}
+
+// FollowerStatus describes a follower connected to a primary.
+type FollowerStatus struct {
+	// Addr is the address the follower connected from.
+	Addr string
+	// LSN is the log sequence number of the last group of writes the follower has
+	// acknowledged writing to its replica.
+	LSN uint64
+}
+
+// NewPrimary returns a primary, which has to be handed every group committed to the
+// file's write-ahead log by being its archiver, see bplus.WithWALArchive.
+func NewPrimary(opts ...PrimaryOption) *Primary {
+	p := &Primary{
+		backlogSize: DefaultBacklogSize,
+		heartbeat:   DefaultHeartbeatInterval,
+		changed:     make(chan struct{}),
+		listeners:   map[net.Listener]struct{}{},
+		followers:   map[*follower]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Archive adds a segment to the backlog and wakes the followers waiting for it. It's
+// called as groups are committed, so it never waits for followers.
+func (p *Primary) Archive(segment store.WALSegment) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backlog = append(p.backlog, segment)
+	p.backlogBytes += int64(len(segment.Data))
+	for len(p.backlog) > 1 && p.backlogBytes > p.backlogSize {
+		p.backlogBytes -= int64(len(p.backlog[0].Data))
+		p.backlog = p.backlog[1:]
+	}
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return nil
+}
+
+// Serve accepts followers on l and replicates source to them until the primary is
+// closed, when it returns ErrPrimaryClosed. Sending a follower a snapshot backs source
+// up, which for a tree that isn't in copy-on-write mode holds off writes until the
+// follower has been sent the whole file, see bplus.Tree.Backup.
+func (p *Primary) Serve(l net.Listener, source Source) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.Close()
+		return ErrPrimaryClosed
+	}
+	p.listeners[l] = struct{}{}
+	p.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			delete(p.listeners, l)
+			p.mu.Unlock()
+			if closed {
+				return ErrPrimaryClosed
+			}
+			return err
+		}
+		f := &follower{conn: conn}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return ErrPrimaryClosed
+		}
+		p.followers[f] = struct{}{}
+		p.mu.Unlock()
+		go p.serveFollower(f, source)
+	}
+}
+
+// Followers returns the followers connected to the primary.
+func (p *Primary) Followers() []FollowerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var followers []FollowerStatus
+	for f := range p.followers {
+		followers = append(followers, FollowerStatus{
+			Addr: f.conn.RemoteAddr().String(),
+			LSN:  f.lsn,
+		})
+	}
+	return followers
+}
+
+// Close stops serving followers and disconnects the ones connected.
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	for f := range p.followers {
+		f.conn.Close()
+	}
+	return nil
+}
+
+// serveFollower sends a follower a snapshot if it needs one, then the segments committed
+// after the last group its replica holds, until the connection is closed.
+func (p *Primary) serveFollower(f *follower, source Source) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.followers, f)
+		p.mu.Unlock()
+		f.conn.Close()
+	}()
+	r := bufio.NewReader(f.conn)
+	w := bufio.NewWriter(f.conn)
+	lsn, err := readHello(r)
+	if err != nil {
+		return
+	}
+	_, _, ok := p.after(lsn, source.LSN())
+	if !ok {
+		lsn, err = p.sendSnapshot(r, w, source)
+		if err != nil {
+			return
+		}
+	}
+	p.acknowledged(f, lsn)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			lsn, err := readUint64(r)
+			if err != nil {
+				return
+			}
+			p.acknowledged(f, lsn)
+		}
+	}()
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+	for {
+		segments, changed, ok := p.after(lsn, source.LSN())
+		if !ok {
+			// The follower fell too far behind, and is sent a snapshot once it reconnects.
+			return
+		}
+		for _, segment := range segments {
+			err = writeSegment(w, segment.LastLSN, segment)
+			if err != nil {
+				return
+			}
+			lsn = segment.LastLSN
+		}
+		if len(segments) == 0 {
+			select {
+			case <-changed:
+			case <-done:
+				return
+			case <-ticker.C:
+				err = writeMessageHeader(w, msgHeartbeat, source.LSN())
+				if err != nil {
+					return
+				}
+			}
+		}
+		err = w.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendSnapshot sends a follower a backup of source, and returns the log sequence number
+// of the last group it holds, which the follower acknowledges once it has restored it.
+func (p *Primary) sendSnapshot(
+	r io.Reader, w *bufio.Writer, source Source,
+) (uint64, error) {
+	err := writeMessageHeader(w, msgSnapshot, source.LSN())
+	if err != nil {
+		return 0, err
+	}
+	err = source.Backup(w)
+	if err != nil {
+		return 0, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return 0, err
+	}
+	return readUint64(r)
+}
+
+func (p *Primary) acknowledged(f *follower, lsn uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f.lsn = lsn
+}
+
+// after returns the segments in the backlog with groups committed after lsn, and a
+// channel that's closed once there are more. It returns false if the groups right after
+// lsn aren't in the backlog, which is the case for a follower that has fallen too far
+// behind, or whose replica doesn't come from the source, and has to be sent a snapshot.
+// current is the source's log sequence number.
+func (p *Primary) after(lsn, current uint64) ([]store.WALSegment, chan struct{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if lsn == 0 || lsn > current {
+		return nil, nil, false
+	}
+	for i, segment := range p.backlog {
+		if segment.LastLSN <= lsn {
+			continue
+		}
+		if segment.FirstLSN > lsn+1 {
+			return nil, nil, false
+		}
+		return append([]store.WALSegment(nil), p.backlog[i:]...), p.changed, true
+	}
+	// Nothing has been committed since, unless it's missing from the backlog.
+	return nil, p.changed, lsn == current
+}
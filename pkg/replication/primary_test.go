@@ -0,0 +1,65 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+func TestPrimaryBacklog(t *testing.T) {
+	primary := NewPrimary(WithBacklogSize(30))
+	for lsn := uint64(1); lsn <= 5; lsn++ {
+		err := primary.Archive(store.WALSegment{
+			FirstLSN: lsn,
+			LastLSN:  lsn,
+			Data:     make([]byte, 10),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the last three segments fit in the backlog.
+	for _, test := range []struct {
+		lsn   uint64
+		first uint64
+		n     int
+		ok    bool
+	}{
+		{0, 0, 0, false},
+		{1, 0, 0, false},
+		{2, 3, 3, true},
+		{4, 5, 1, true},
+		{5, 0, 0, true},
+		{6, 0, 0, false},
+	} {
+		segments, _, ok := primary.after(test.lsn, 5)
+		if ok != test.ok || len(segments) != test.n {
+			t.Fatalf("expected %v == %v and %v == %v after %d",
+				ok, test.ok, len(segments), test.n, test.lsn)
+		}
+		if test.n > 0 && segments[0].FirstLSN != test.first {
+			t.Fatalf("expected %v == %v", segments[0].FirstLSN, test.first)
+		}
+	}
+	_, changed, _ := primary.after(5, 5)
+	err := primary.Archive(store.WALSegment{FirstLSN: 6, LastLSN: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatalf("expected the followers waiting to be woken")
+	}
+}
+
+func TestStatusLag(t *testing.T) {
+	status := Status{LSN: 3, PrimaryLSN: 10}
+	if status.Lag() != 7 {
+		t.Fatalf("expected %v == %v", status.Lag(), 7)
+	}
+	status.LSN = 11
+	if status.Lag() != 0 {
+		t.Fatalf("expected %v == %v", status.Lag(), 0)
+	}
+}
@@ -0,0 +1,159 @@
+// Package replication keeps replicas of a tree's file up to date by shipping the groups
+// of writes committed to its write-ahead log from a primary to followers over TCP.
+//
+// A Primary is handed every group committed to the primary's log by being its archiver,
+// see bplus.WithWALArchive, and keeps the most recent of them in a backlog. Followers
+// connect to it with the log sequence number of the last group their replica holds, and
+// are sent the groups after it from the backlog as they're committed, which they write
+// to the replica in order, see store.ApplyWALSegment. A follower without a replica, or
+// one that has fallen further behind than the backlog goes back, is sent a backup of
+// the primary's file to catch up from first, see bplus.Tree.Backup.
+//
+// A replica is a copy of the primary's file which a follower writes to directly, so it
+// can only be opened once the follower has stopped, such as when it's promoted to take
+// over from the primary. Every group is stamped with the store ID of the primary's file,
+// so a follower whose replica is of another file stops with store.ErrWrongStore rather
+// than writing it to the replica.
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/store"
+)
+
+// ErrProtocol is returned when the other end of a connection sends something that isn't
+// part of the replication protocol, or a version of it this package doesn't speak.
+var ErrProtocol = errors.New("replication protocol error")
+
+const (
+	// magicNumber is found in the first four bytes a follower sends.
+	magicNumber = 0x5245504C
+	// protocolVersion is the version of the protocol spoken over connections.
+	protocolVersion = 1
+)
+
+// A follower starts a connection with a hello, made up of the magic number, the protocol
+// version, and the log sequence number of the last group its replica holds, and
+// acknowledges every message that changes the replica with the log sequence number it
+// has got to. The primary sends messages that start with their kind and its own log
+// sequence number.
+const (
+	// msgSnapshot is followed by a backup of the primary's file, see store.Restore.
+	msgSnapshot byte = iota + 1
+	// msgSegment is followed by the first and last log sequence numbers of a segment,
+	// its length and its data, see store.WALSegment.
+	msgSegment
+	// msgHeartbeat is sent when there's nothing else to send.
+	msgHeartbeat
+)
+
+// helloSize is the size of a follower's hello.
+const helloSize = 4 + 4 + 8
+
+func writeHello(w io.Writer, lsn uint64) error {
+	buf := make([]byte, helloSize)
+	binary.LittleEndian.PutUint32(buf[0:4], magicNumber)
+	binary.LittleEndian.PutUint32(buf[4:8], protocolVersion)
+	binary.LittleEndian.PutUint64(buf[8:16], lsn)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readHello(r io.Reader) (uint64, error) {
+	buf := make([]byte, helloSize)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint32(buf[0:4]) != magicNumber ||
+		binary.LittleEndian.Uint32(buf[4:8]) != protocolVersion {
+		return 0, ErrProtocol
+	}
+	return binary.LittleEndian.Uint64(buf[8:16]), nil
+}
+
+func writeUint64(w io.Writer, v uint64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:])
+	return binary.LittleEndian.Uint64(buf[:]), err
+}
+
+// writeMessageHeader starts a message from the primary.
+func writeMessageHeader(w io.Writer, kind byte, lsn uint64) error {
+	var buf [9]byte
+	buf[0] = kind
+	binary.LittleEndian.PutUint64(buf[1:], lsn)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func readMessageHeader(r io.Reader) (byte, uint64, error) {
+	var buf [9]byte
+	_, err := io.ReadFull(r, buf[:])
+	return buf[0], binary.LittleEndian.Uint64(buf[1:]), err
+}
+
+func writeSegment(w io.Writer, lsn uint64, segment store.WALSegment) error {
+	err := writeMessageHeader(w, msgSegment, lsn)
+	if err != nil {
+		return err
+	}
+	var buf [20]byte
+	binary.LittleEndian.PutUint64(buf[0:8], segment.FirstLSN)
+	binary.LittleEndian.PutUint64(buf[8:16], segment.LastLSN)
+	binary.LittleEndian.PutUint32(buf[16:20], uint32(len(segment.Data)))
+	_, err = w.Write(buf[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(segment.Data)
+	return err
+}
+
+func readSegment(r io.Reader) (store.WALSegment, error) {
+	var buf [20]byte
+	_, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return store.WALSegment{}, err
+	}
+	segment := store.WALSegment{
+		FirstLSN: binary.LittleEndian.Uint64(buf[0:8]),
+		LastLSN:  binary.LittleEndian.Uint64(buf[8:16]),
+		Data:     make([]byte, binary.LittleEndian.Uint32(buf[16:20])),
+	}
+	_, err = io.ReadFull(r, segment.Data)
+	return segment, err
+}
+
+// Status describes how far a follower has got.
+type Status struct {
+	// LSN is the log sequence number of the last group of writes the replica holds.
+	LSN uint64
+	// PrimaryLSN is the log sequence number of the last group of writes the primary
+	// last said it had committed.
+	PrimaryLSN uint64
+	// LastContact is when the follower last heard from the primary, or the zero time if
+	// it never has.
+	LastContact time.Time
+}
+
+// Lag returns the number of groups of writes the replica is behind the primary, as far as
+// the follower knows. How long ago it last heard from the primary says how far behind
+// that may be.
+func (s Status) Lag() uint64 {
+	if s.PrimaryLSN < s.LSN {
+		return 0
+	}
+	return s.PrimaryLSN - s.LSN
+}
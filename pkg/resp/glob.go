@@ -0,0 +1,80 @@
+package resp
+
+// match returns whether s matches a glob-style pattern the way Redis matches the MATCH
+// option of SCAN: * matches any bytes, ? matches any one byte, [abc], [^abc] and [a-z]
+// match one of a set of bytes, and \ matches the byte after it literally.
+func match(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			ok, pattern = matchClass(pattern[1:], s[0])
+			if !ok {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass returns whether b is in the class that starts pattern, just after its [,
+// and the rest of the pattern after the class's ].
+func matchClass(pattern []byte, b byte) (bool, []byte) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	found := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		c := pattern[0]
+		if c == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			c = pattern[0]
+		}
+		if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+			lo, hi := c, pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			found = found || lo <= b && b <= hi
+			pattern = pattern[3:]
+			continue
+		}
+		found = found || c == b
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return found != negate, pattern
+}
@@ -0,0 +1,33 @@
+package resp
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything/at all", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbx", false},
+	} {
+		if match([]byte(test.pattern), []byte(test.s)) != test.match {
+			t.Fatalf("expected %q to match %q: %v", test.pattern, test.s, test.match)
+		}
+	}
+}
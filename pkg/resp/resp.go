@@ -0,0 +1,150 @@
+// Package resp serves a B+ tree from package bplus over the Redis serialization protocol
+// (RESP), so that Redis clients and tooling can use a tree as a persistent store of
+// strings. It supports a minimal set of commands, see Server.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxBulkSize is the largest argument a command can be sent, in bytes.
+const MaxBulkSize = 64 << 20
+
+// maxArgs is the most arguments a command can be sent.
+const maxArgs = 1 << 20
+
+// maxPreallocArgs and maxPreallocBulk are the most arguments, and bytes of an argument,
+// allocated for before they arrive, since the counts and sizes come from the client,
+// which could otherwise make the server allocate up to the limits above for every
+// command it only starts to send. Larger commands grow as they're read.
+const (
+	maxPreallocArgs = 64
+	maxPreallocBulk = 64 << 10
+)
+
+// ErrProtocol is returned when a client sends something that isn't a command.
+var ErrProtocol = errors.New("resp protocol error")
+
+// readCommand reads a command, which is an array of bulk strings, or an inline command
+// of arguments separated by spaces, as sent by telnet. It returns a nil command for an
+// empty line.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		var args [][]byte
+		for _, field := range strings.Fields(string(line)) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, ErrProtocol
+	}
+	args := make([][]byte, 0, min(max(n, 0), maxPreallocArgs))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, ErrProtocol
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > MaxBulkSize {
+			return nil, ErrProtocol
+		}
+		arg, err := readBulk(r, size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string of size bytes followed by CRLF, and returns it without
+// its end.
+func readBulk(r *bufio.Reader, size int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(min(size+2, maxPreallocBulk))
+	n, err := io.CopyN(&buf, r, int64(size+2))
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	arg := buf.Bytes()
+	if arg[size] != '\r' || arg[size+1] != '\n' {
+		return nil, ErrProtocol
+	}
+	return arg[:size], nil
+}
+
+// readLine reads a line ended by CRLF, or by a lone LF, and returns it without its end.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, ErrProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// writer writes replies.
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w writer) error(s string) {
+	w.WriteByte('-')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w writer) integer(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+// bulk writes a bulk string, or the null bulk string if b is nil.
+func (w writer) bulk(b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+// array starts an array of n elements, which are written after it.
+func (w writer) array(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,69 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		args  string
+		err   error
+	}{
+		{"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\na b\r\n\r\n", "SET|k|a b\r\n", nil},
+		{"*1\r\n$0\r\n\r\n", "", nil},
+		{"GET  key\r\n", "GET|key", nil},
+		{"PING\n", "PING", nil},
+		{"\r\n", "", nil},
+		{"*1\r\n:1\r\n", "", ErrProtocol},
+		{"*x\r\n", "", ErrProtocol},
+		{"*1\r\n$3\r\nabcd\r\n", "", ErrProtocol},
+		{"*1\r\n$-1\r\n", "", ErrProtocol},
+	} {
+		args, err := readCommand(bufio.NewReader(strings.NewReader(test.input)))
+		if err != test.err {
+			t.Fatalf("expected %v == %v for %q", err, test.err, test.input)
+		}
+		if joined := string(bytes.Join(args, []byte("|"))); joined != test.args {
+			t.Fatalf("expected %q == %q for %q", joined, test.args, test.input)
+		}
+	}
+}
+
+func TestReadCommandClaimedSizes(t *testing.T) {
+	// A client claiming to send the most arguments, or the largest argument, without
+	// sending them doesn't get the server to allocate room for them.
+	for _, input := range []string{"*1048576\r\n", "*1\r\n$67108864\r\nabc"} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := readCommand(bufio.NewReader(strings.NewReader(input)))
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Fatalf("expected an error for %q", input)
+		}
+		allocated := after.TotalAlloc - before.TotalAlloc
+		if allocated > 1<<20 {
+			t.Fatalf("expected %v <= %v for %q", allocated, 1<<20, input)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := writer{bufio.NewWriter(&buf)}
+	w.simple("OK")
+	w.error("ERR no")
+	w.integer(-2)
+	w.array(2)
+	w.bulk([]byte("value"))
+	w.bulk(nil)
+	w.Flush()
+	expected := "+OK\r\n-ERR no\r\n:-2\r\n*2\r\n$5\r\nvalue\r\n$-1\r\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q == %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,454 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+	"github.com/jpittis/bplus/pkg/internal/expiry"
+)
+
+const (
+	// DefaultSweepInterval is how often a server deletes the keys that have expired unless
+	// it's created with WithSweepInterval.
+	DefaultSweepInterval = 100 * time.Millisecond
+	// defaultScanCount is the number of keys SCAN looks at unless it's given a COUNT.
+	defaultScanCount = 10
+	// maxCursors is the number of SCAN cursors a server remembers. Scans with a cursor
+	// that's been forgotten fail.
+	maxCursors = 1024
+)
+
+// ErrServerClosed is returned by Serve once the server has been closed.
+var ErrServerClosed = errors.New("resp server closed")
+
+// ServerOption configures a server, see NewServer.
+type ServerOption func(*Server)
+
+// WithSweepInterval sets how often a server deletes the keys that have expired. Expired
+// keys are never returned, even before they're deleted.
+func WithSweepInterval(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.sweepInterval = interval
+	}
+}
+
+// Server serves a tree of strings to Redis clients. It supports:
+//
+//	PING [message]
+//	GET key
+//	SET key value [EX seconds | PX milliseconds]
+//	DEL key [key ...]
+//	EXPIRE key seconds
+//	TTL key
+//	SCAN cursor [MATCH pattern] [COUNT count]
+//	QUIT
+//
+// Keys and values are stored in the tree as they are, so the tree can be used directly
+// as well, while the times keys expire at are kept in a second tree. The two trees are
+// written separately, so a crash can leave a key without the expiry it was set with.
+type Server struct {
+	data     *bplus.Tree
+	expiries *expiry.Expiries
+
+	sweepInterval time.Duration
+
+	// mu is held while a command runs, so that commands see each other's changes to both
+	// trees whole.
+	mu sync.Mutex
+	// cursors maps the SCAN cursors handed out to the keys the scans continue from, with
+	// the oldest ones first in cursorOrder.
+	cursors     map[uint64]bplus.Key
+	cursorOrder []uint64
+	lastCursor  uint64
+
+	connMu    sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// NewServer returns a server that keeps keys and values in data and the times keys expire
+// at in expiries. Neither tree may be written to other than through the server while
+// it's serving, which would leave the expiries out of step with the keys.
+func NewServer(data, expiries *bplus.Tree, opts ...ServerOption) *Server {
+	s := &Server{
+		data:          data,
+		expiries:      expiry.New(data, expiries),
+		sweepInterval: DefaultSweepInterval,
+		cursors:       map[uint64]bplus.Key{},
+		listeners:     map[net.Listener]struct{}{},
+		conns:         map[net.Conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Serve accepts clients on l and serves them until the server is closed, when it returns
+// ErrServerClosed. It deletes expired keys in the background while it's serving.
+func (s *Server) Serve(l net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.connMu.Unlock()
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.expiries.SweepEvery(s.sweepInterval, &s.mu, stop)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.connMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.connMu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops serving clients and disconnects the ones connected.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// serveConn runs the commands a client sends until it disconnects. Replies are flushed
+// once every command sent so far has run, so that pipelined commands are replied to
+// together.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.connMu.Lock()
+		delete(s.conns, conn)
+		s.connMu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err == ErrProtocol {
+			w.error("ERR Protocol error")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		quit := len(args) > 0 && strings.EqualFold(string(args[0]), "quit")
+		if quit {
+			w.simple("OK")
+		} else if len(args) > 0 {
+			s.mu.Lock()
+			s.execute(w, args, time.Now())
+			s.mu.Unlock()
+		}
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// execute runs a command and writes its reply, with mu held.
+func (s *Server) execute(w writer, args [][]byte, now time.Time) {
+	name := strings.ToLower(string(args[0]))
+	arity, ok := arities[name]
+	if !ok {
+		w.error("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+	if len(args) < arity.min || arity.max > 0 && len(args) > arity.max {
+		w.error("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+	var err error
+	switch name {
+	case "ping":
+		if len(args) > 1 {
+			w.bulk(args[1])
+		} else {
+			w.simple("PONG")
+		}
+	case "get":
+		err = s.get(w, args[1], now)
+	case "set":
+		err = s.set(w, args[1:], now)
+	case "del":
+		err = s.del(w, args[1:])
+	case "expire":
+		err = s.expire(w, args[1], args[2], now)
+	case "ttl":
+		err = s.ttl(w, args[1], now)
+	case "scan":
+		err = s.scan(w, args[1:], now)
+	}
+	if err != nil {
+		w.error("ERR " + err.Error())
+	}
+}
+
+// arities holds the fewest and most arguments each command takes, counting its name,
+// where commands that take any number of them have a max of 0.
+var arities = map[string]struct{ min, max int }{
+	"ping":   {1, 2},
+	"get":    {2, 2},
+	"set":    {3, 0},
+	"del":    {2, 0},
+	"expire": {3, 3},
+	"ttl":    {2, 2},
+	"scan":   {2, 0},
+}
+
+var (
+	errSyntax     = errors.New("syntax error")
+	errNotInteger = errors.New("value is not an integer or out of range")
+	errExpireTime = errors.New("invalid expire time in 'set' command")
+	errCursor     = errors.New("invalid cursor")
+)
+
+func (s *Server) get(w writer, key []byte, now time.Time) error {
+	value, err := s.expiries.Read(key, now)
+	if err == bplus.ErrKeyNotFound {
+		w.bulk(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// An empty value is an empty string rather than the null reply of a missing key.
+	if value == nil {
+		value = bplus.Value{}
+	}
+	w.bulk(value)
+	return nil
+}
+
+func (s *Server) set(w writer, args [][]byte, now time.Time) error {
+	key, value := args[0], args[1]
+	var deadline time.Time
+	for i := 2; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		if option != "ex" && option != "px" || i+1 == len(args) || !deadline.IsZero() {
+			return errSyntax
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return errNotInteger
+		}
+		if n <= 0 {
+			return errExpireTime
+		}
+		unit := time.Second
+		if option == "px" {
+			unit = time.Millisecond
+		}
+		deadline = now.Add(time.Duration(n) * unit)
+	}
+	// A key that's set loses the expiry it had.
+	err := s.expiries.Clear(key)
+	if err != nil {
+		return err
+	}
+	err = s.data.Upsert(key, value)
+	if err != nil {
+		return err
+	}
+	if !deadline.IsZero() {
+		err = s.expiries.Set(key, deadline)
+		if err != nil {
+			return err
+		}
+	}
+	w.simple("OK")
+	return nil
+}
+
+func (s *Server) del(w writer, keys [][]byte) error {
+	var n int64
+	for _, key := range keys {
+		removed, err := s.expiries.Remove(key)
+		if err != nil {
+			return err
+		}
+		if removed {
+			n++
+		}
+	}
+	w.integer(n)
+	return nil
+}
+
+func (s *Server) expire(w writer, key, seconds []byte, now time.Time) error {
+	n, err := strconv.ParseInt(string(seconds), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	_, err = s.expiries.Read(key, now)
+	if err == bplus.ErrKeyNotFound {
+		w.integer(0)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// A key that expires straight away is deleted straight away.
+	if n <= 0 {
+		_, err = s.expiries.Remove(key)
+	} else {
+		err = s.expiries.Set(key, now.Add(time.Duration(n)*time.Second))
+	}
+	if err != nil {
+		return err
+	}
+	w.integer(1)
+	return nil
+}
+
+// ttl replies with the seconds left until key expires, rounded to the nearest second,
+// -1 if it doesn't expire, or -2 if it doesn't exist.
+func (s *Server) ttl(w writer, key []byte, now time.Time) error {
+	_, err := s.expiries.Read(key, now)
+	if err == bplus.ErrKeyNotFound {
+		w.integer(-2)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	deadline, ok, err := s.expiries.Deadline(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		w.integer(-1)
+		return nil
+	}
+	w.integer(int64((deadline.Sub(now) + time.Second/2) / time.Second))
+	return nil
+}
+
+// scan replies with the next cursor and the keys, up to COUNT of them, after the key the
+// cursor continues from. A cursor of 0 starts from the first key, and a scan that gets to
+// the last key replies with a cursor of 0.
+func (s *Server) scan(w writer, args [][]byte, now time.Time) error {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return errCursor
+	}
+	var start bplus.Key
+	if cursor != 0 {
+		var ok bool
+		start, ok = s.cursors[cursor]
+		if !ok {
+			return errCursor
+		}
+	}
+	var pattern []byte
+	count := defaultScanCount
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return errNotInteger
+			}
+			if count < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+	var keys, expired []bplus.Key
+	var next bplus.Key
+	examined := 0
+	it := s.data.Ascend(start)
+	for key := range it.Records() {
+		if examined == count {
+			next = append(bplus.Key{}, key...)
+			break
+		}
+		examined++
+		deadline, ok, err := s.expiries.Deadline(key)
+		if err != nil {
+			return err
+		}
+		if ok && !deadline.After(now) {
+			expired = append(expired, append(bplus.Key{}, key...))
+			continue
+		}
+		if pattern == nil || match(pattern, key) {
+			keys = append(keys, append(bplus.Key{}, key...))
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	for _, key := range expired {
+		_, err := s.expiries.Remove(key)
+		if err != nil {
+			return err
+		}
+	}
+	cursor = 0
+	if next != nil {
+		cursor = s.newCursor(next)
+	}
+	w.array(2)
+	w.bulk(strconv.AppendUint(nil, cursor, 10))
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk(key)
+	}
+	return nil
+}
+
+// newCursor returns a cursor for a scan that continues from next, forgetting the oldest
+// cursor if there are too many.
+func (s *Server) newCursor(next bplus.Key) uint64 {
+	s.lastCursor++
+	s.cursors[s.lastCursor] = next
+	s.cursorOrder = append(s.cursorOrder, s.lastCursor)
+	if len(s.cursorOrder) > maxCursors {
+		delete(s.cursors, s.cursorOrder[0])
+		s.cursorOrder = s.cursorOrder[1:]
+	}
+	return s.lastCursor
+}
@@ -0,0 +1,213 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func newTree(t *testing.T, filename string) *bplus.Tree {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	tree, err := bplus.NewTree(tmpfile.Name(), bplus.WithBranchingFactor(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+// client sends commands to a server and reads its replies.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, opts ...ServerOption) (*bplus.Tree, *bplus.Tree, *client) {
+	t.Helper()
+	data := newTree(t, "resp_data")
+	expiries := newTree(t, "resp_expiries")
+	server := NewServer(data, expiries, opts...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("expected %v == %v", err, ErrServerClosed)
+		}
+	})
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return data, expiries, &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns its reply, see reply.
+func (c *client) do(args ...string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.reply()
+}
+
+// reply reads a reply, which it returns as its first byte followed by its value, with
+// the elements of arrays in brackets.
+func (c *client) reply() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "nil"
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return "$" + string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		elems := make([]string, n)
+		for i := range elems {
+			elems[i] = c.reply()
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	}
+	return line
+}
+
+func (c *client) expect(expected string, args ...string) {
+	c.t.Helper()
+	if reply := c.do(args...); reply != expected {
+		c.t.Fatalf("expected %q == %q for %v", reply, expected, args)
+	}
+}
+
+func TestServer(t *testing.T) {
+	data, _, c := startServer(t)
+	c.expect("+PONG", "PING")
+	c.expect("$hi", "ping", "hi")
+	c.expect("nil", "GET", "k")
+	c.expect("+OK", "SET", "k", "v")
+	c.expect("$v", "GET", "k")
+	c.expect("+OK", "SET", "empty", "")
+	c.expect("$", "GET", "empty")
+	value, err := data.Read(bplus.Key("k"))
+	if err != nil || string(value) != "v" {
+		t.Fatalf("expected %q == %q, got %v", value, "v", err)
+	}
+	c.expect(":2", "DEL", "k", "empty", "missing")
+	c.expect("nil", "GET", "k")
+	c.expect("-ERR unknown command 'NOPE'", "NOPE")
+	c.expect("-ERR wrong number of arguments for 'get' command", "GET")
+	c.expect("-ERR syntax error", "SET", "k", "v", "NX")
+	c.expect("-ERR value is not an integer or out of range", "EXPIRE", "k", "x")
+
+	// Pipelined commands are all replied to.
+	fmt.Fprint(c.conn, "SET a 1\r\nSET b 2\r\nGET a\r\n")
+	for _, expected := range []string{"+OK", "+OK", "$1"} {
+		if reply := c.reply(); reply != expected {
+			t.Fatalf("expected %q == %q", reply, expected)
+		}
+	}
+	c.expect("+OK", "QUIT")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected %v == %v", err, io.EOF)
+	}
+}
+
+func TestServerExpire(t *testing.T) {
+	data, expiries, c := startServer(t, WithSweepInterval(10*time.Millisecond))
+	c.expect(":0", "EXPIRE", "k", "10")
+	c.expect(":-2", "TTL", "k")
+	c.expect("+OK", "SET", "k", "v")
+	c.expect(":-1", "TTL", "k")
+	c.expect(":1", "EXPIRE", "k", "10")
+	c.expect(":10", "TTL", "k")
+	// Setting a key again clears its expiry.
+	c.expect("+OK", "SET", "k", "v")
+	c.expect(":-1", "TTL", "k")
+	c.expect("+OK", "SET", "k", "v", "EX", "100")
+	c.expect(":100", "TTL", "k")
+	c.expect(":1", "EXPIRE", "k", "0")
+	c.expect("nil", "GET", "k")
+
+	// Expired keys are never returned, and are swept away in the background.
+	c.expect("+OK", "SET", "short", "v", "PX", "20")
+	c.expect("+OK", "SET", "long", "v", "EX", "100")
+	time.Sleep(30 * time.Millisecond)
+	c.expect("nil", "GET", "short")
+	c.expect(":100", "TTL", "long")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.expect("+OK", "SET", "swept", "v", "PX", "1")
+		time.Sleep(20 * time.Millisecond)
+		if _, err := data.Read(bplus.Key("swept")); err == bplus.ErrKeyNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the expired key to be swept away")
+		}
+	}
+	// Only long's expiry, and the record ordering it by when it expires, are left.
+	if expiries.Len() != 2 {
+		t.Fatalf("expected %v == %v", expiries.Len(), 2)
+	}
+}
+
+func TestServerScan(t *testing.T) {
+	_, _, c := startServer(t)
+	for i := 0; i < 25; i++ {
+		c.expect("+OK", "SET", fmt.Sprintf("key:%02d", i), "v")
+	}
+	c.expect("+OK", "SET", "other", "v")
+	c.expect("+OK", "SET", "key:gone", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+	var keys []string
+	cursor := "0"
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "key:*", "COUNT", "7")
+		fields := strings.Fields(strings.Trim(reply, "[]"))
+		cursor = strings.TrimPrefix(fields[0], "$")
+		for _, key := range fields[1:] {
+			keys = append(keys, strings.Trim(key, "[]$"))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(keys) != 25 || keys[0] != "key:00" || keys[24] != "key:24" {
+		t.Fatalf("expected the 25 keys that match, got %v", keys)
+	}
+	c.expect("-ERR invalid cursor", "SCAN", "12345")
+}
@@ -0,0 +1,481 @@
+// Package sqldriver is a database/sql driver for B+ trees from package bplus, so that
+// tooling that only speaks SQL can look at and change a tree file. It's registered as
+// "bplus", with the path of the tree's file as the data source name:
+//
+//	db, err := sql.Open("bplus", "/var/lib/app/records.db")
+//
+// A tree is seen as a single table called kv, with key and value columns of bytes, in a
+// tiny dialect of SQL:
+//
+//	SELECT * | column[, column] FROM kv [WHERE ...] [ORDER BY key [ASC | DESC]] [LIMIT n]
+//	INSERT INTO kv [(key, value)] VALUES (k, v)[, ...]
+//	DELETE FROM kv [WHERE ...]
+//
+// where a WHERE clause is one or more comparisons of the key to a string literal or a ?
+// placeholder, with =, <, <=, > or >=, joined by AND. Arguments are strings or []byte.
+// An insert fails with bplus.ErrDuplicateKey if a key already exists.
+//
+// Transactions are bplus.Tx transactions, which see their own writes, and fail to commit
+// with bplus.ErrTxConflict if something they read has been changed by another writer.
+// Statements run outside of a transaction are committed as they run, each in a single
+// batch.
+package sqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+var (
+	// ErrArgType is returned when running a statement with an argument that isn't a string
+	// or []byte, or a LIMIT that isn't a non-negative integer.
+	ErrArgType = errors.New("unsupported argument type")
+	// ErrNamedArgs is returned when running a statement with named arguments.
+	ErrNamedArgs = errors.New("named arguments are not supported")
+	// ErrIsolationLevel is returned when beginning a transaction with an isolation level
+	// other than the default.
+	ErrIsolationLevel = errors.New("isolation level is not supported")
+	// ErrTxOpen is returned when beginning a transaction on a connection that already has
+	// one open.
+	ErrTxOpen = errors.New("transaction already open")
+	// ErrNoLastInsertID is returned by the LastInsertId of a result, since keys aren't
+	// generated.
+	ErrNoLastInsertID = errors.New("last insert id is not supported")
+)
+
+func init() {
+	sql.Register("bplus", Driver{})
+}
+
+// Driver is the driver registered as "bplus".
+type Driver struct{}
+
+// Open opens a connection that has the tree in the file at name to itself, closing the
+// tree when it's closed. database/sql uses OpenConnector instead, which shares a tree
+// between its connections.
+func (d Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{tree: c.(*Connector).tree, closer: c.(*Connector)}, nil
+}
+
+// OpenConnector opens the tree in the file at name, creating it if it doesn't exist yet.
+// The tree is closed when the connector is, which sql.DB.Close does.
+func (d Driver) OpenConnector(name string) (driver.Connector, error) {
+	info, err := os.Stat(name)
+	var tree *bplus.Tree
+	switch {
+	case os.IsNotExist(err) || err == nil && info.Size() == 0:
+		tree, err = bplus.NewTree(name)
+	case err == nil:
+		tree, err = bplus.OpenTree(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Connector{tree: tree, owned: true}, nil
+}
+
+// Connector makes connections to a tree, see sql.OpenDB.
+type Connector struct {
+	tree *bplus.Tree
+	// owned is set if the tree was opened by the connector, which closes it.
+	owned bool
+}
+
+// NewConnector returns a connector to a tree that's already open, which it leaves open
+// when it's closed:
+//
+//	db := sql.OpenDB(sqldriver.NewConnector(tree))
+func NewConnector(tree *bplus.Tree) *Connector {
+	return &Connector{tree: tree}
+}
+
+// Connect returns a connection to the tree.
+func (c *Connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{tree: c.tree}, nil
+}
+
+// Driver returns the driver registered as "bplus".
+func (c *Connector) Driver() driver.Driver {
+	return Driver{}
+}
+
+// Close closes the tree if the connector opened it.
+func (c *Connector) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.tree.Close()
+}
+
+// conn is a connection to a tree.
+type conn struct {
+	tree *bplus.Tree
+	// tx is the connection's open transaction, or nil.
+	tx *bplus.Tx
+	// closer is closed with the connection, for connections made by Driver.Open.
+	closer io.Closer
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, s: s}, nil
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		c.tx.Rollback()
+		c.tx = nil
+	}
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, ErrIsolationLevel
+	}
+	if c.tx != nil {
+		return nil, ErrTxOpen
+	}
+	c.tx = c.tree.Begin(!opts.ReadOnly)
+	return tx{c}, nil
+}
+
+// tx is a connection's transaction.
+type tx struct {
+	c *conn
+}
+
+func (t tx) Commit() error {
+	err := t.c.tx.Commit()
+	t.c.tx = nil
+	return err
+}
+
+func (t tx) Rollback() error {
+	err := t.c.tx.Rollback()
+	t.c.tx = nil
+	return err
+}
+
+// stmt is a prepared statement.
+type stmt struct {
+	conn *conn
+	s    *statement
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.s.numInput
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+// named converts the arguments of the old Exec and Query to ordinal arguments.
+func named(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+func (s *stmt) ExecContext(
+	ctx context.Context, args []driver.NamedValue,
+) (driver.Result, error) {
+	var n int64
+	err := s.run(args, func(tx *bplus.Tx, b *bound) error {
+		var err error
+		n, err = b.exec(tx)
+		return err
+	})
+	return result(n), err
+}
+
+func (s *stmt) QueryContext(
+	ctx context.Context, args []driver.NamedValue,
+) (driver.Rows, error) {
+	r := &rows{columns: s.s.columns}
+	err := s.run(args, func(tx *bplus.Tx, b *bound) error {
+		var err error
+		r.records, err = b.query(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// run binds the statement's arguments and runs fn in the connection's transaction, or
+// in a transaction of its own that's committed if fn succeeds.
+func (s *stmt) run(args []driver.NamedValue, fn func(*bplus.Tx, *bound) error) error {
+	b, err := s.s.bind(args)
+	if err != nil {
+		return err
+	}
+	b.tree = s.conn.tree
+	if s.conn.tx != nil {
+		return fn(s.conn.tx, b)
+	}
+	tx := s.conn.tree.Begin(s.s.kind != selectStatement)
+	if err := fn(tx, b); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// bound is a statement with its arguments filled in.
+type bound struct {
+	s    *statement
+	tree *bplus.Tree
+	// start and end bound the keys the WHERE clause holds for, with a nil end for no
+	// bound, and empty is set if it can't hold for any key.
+	start, end []byte
+	empty      bool
+	limit      int64
+	rows       [][2][]byte
+}
+
+// bind fills in the statement's arguments.
+func (s *statement) bind(args []driver.NamedValue) (*bound, error) {
+	for _, arg := range args {
+		if arg.Name != "" {
+			return nil, ErrNamedArgs
+		}
+	}
+	value := func(o operand) ([]byte, error) {
+		if o.arg < 0 {
+			return []byte(o.literal), nil
+		}
+		switch v := args[o.arg].Value.(type) {
+		case string:
+			return []byte(v), nil
+		case []byte:
+			return v, nil
+		}
+		return nil, ErrArgType
+	}
+	b := &bound{s: s, start: []byte{}, limit: -1}
+	for _, p := range s.where {
+		v, err := value(p.operand)
+		if err != nil {
+			return nil, err
+		}
+		// The successor of v, the smallest key greater than it.
+		succ := append(append([]byte{}, v...), 0)
+		switch p.op {
+		case "=":
+			b.lower(v)
+			b.upper(succ)
+		case "<":
+			b.upper(v)
+		case "<=":
+			b.upper(succ)
+		case ">":
+			b.lower(succ)
+		case ">=":
+			b.lower(v)
+		}
+	}
+	if b.end != nil && bytes.Compare(b.start, b.end) >= 0 {
+		b.empty = true
+	}
+	if s.hasLimit {
+		var err error
+		if b.limit, err = s.bindLimit(args); err != nil {
+			return nil, err
+		}
+	}
+	for _, row := range s.rows {
+		key, err := value(row[0])
+		if err != nil {
+			return nil, err
+		}
+		v, err := value(row[1])
+		if err != nil {
+			return nil, err
+		}
+		b.rows = append(b.rows, [2][]byte{key, v})
+	}
+	return b, nil
+}
+
+func (s *statement) bindLimit(args []driver.NamedValue) (int64, error) {
+	if s.limit.arg < 0 {
+		return strconv.ParseInt(s.limit.literal, 10, 64)
+	}
+	switch v := args[s.limit.arg].Value.(type) {
+	case int64:
+		if v >= 0 {
+			return v, nil
+		}
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && n >= 0 {
+			return n, nil
+		}
+	}
+	return 0, ErrArgType
+}
+
+// lower raises the lower bound of the keys to start.
+func (b *bound) lower(start []byte) {
+	if bytes.Compare(start, b.start) > 0 {
+		b.start = start
+	}
+}
+
+// upper lowers the upper bound of the keys to end.
+func (b *bound) upper(end []byte) {
+	if b.end == nil || bytes.Compare(end, b.end) < 0 {
+		b.end = end
+	}
+}
+
+// scan returns the records the WHERE clause holds for, in order.
+func (b *bound) scan(tx *bplus.Tx) ([]bplus.Record, error) {
+	if b.empty {
+		return nil, nil
+	}
+	var records []bplus.Record
+	it := tx.Scan(b.start, b.end)
+	for k, v := range it.Records() {
+		// Without an ORDER BY DESC, the scan can stop at the limit.
+		if !b.s.desc && b.limit >= 0 && int64(len(records)) == b.limit {
+			break
+		}
+		records = append(records, bplus.Record{
+			Key:   append(bplus.Key{}, k...),
+			Value: append(bplus.Value{}, v...),
+		})
+	}
+	return records, it.Err()
+}
+
+func (b *bound) query(tx *bplus.Tx) ([]bplus.Record, error) {
+	if b.s.kind != selectStatement {
+		_, err := b.exec(tx)
+		return nil, err
+	}
+	records, err := b.scan(tx)
+	if err != nil {
+		return nil, err
+	}
+	if b.s.desc {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+		if b.limit >= 0 && int64(len(records)) > b.limit {
+			records = records[:b.limit]
+		}
+	}
+	return records, nil
+}
+
+// exec runs an insert or delete and returns how many records it wrote.
+func (b *bound) exec(tx *bplus.Tx) (int64, error) {
+	switch b.s.kind {
+	case insertStatement:
+		for _, row := range b.rows {
+			_, err := tx.Get(row[0])
+			if err == nil {
+				return 0, bplus.ErrDuplicateKey
+			}
+			if err != bplus.ErrKeyNotFound {
+				return 0, err
+			}
+			if err := tx.Put(row[0], row[1]); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(b.rows)), nil
+	case deleteStatement:
+		records, err := b.scan(tx)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range records {
+			if err := tx.Delete(r.Key); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(records)), nil
+	}
+	// A select that's executed rather than queried.
+	_, err := b.scan(tx)
+	return 0, err
+}
+
+// result is the result of an insert or delete, which is how many records it wrote.
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, ErrNoLastInsertID
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+// rows are the records a select returns.
+type rows struct {
+	columns []string
+	records []bplus.Record
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	r.records = nil
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.records) == 0 {
+		return io.EOF
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	for i, column := range r.columns {
+		if column == keyColumn {
+			dest[i] = []byte(record.Key)
+		} else {
+			dest[i] = []byte(record.Value)
+		}
+	}
+	return nil
+}
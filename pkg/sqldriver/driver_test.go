@@ -0,0 +1,187 @@
+package sqldriver
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", "sqldriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	db, err := sql.Open("bplus", tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Keep every statement on one connection, so that a test can't race itself.
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+// keys runs a query that selects keys and returns them joined by commas.
+func keys(t *testing.T, db *sql.DB, query string, args ...interface{}) string {
+	t.Helper()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestDriver(t *testing.T) {
+	db := openDB(t)
+	res, err := db.Exec("INSERT INTO kv VALUES ('a', '1'), ('b', ?), (?, '3')", "2", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 3 {
+		t.Fatalf("expected %v == %v, got %v", n, 3, err)
+	}
+	if _, err := res.LastInsertId(); err != ErrNoLastInsertID {
+		t.Fatalf("expected %v == %v", err, ErrNoLastInsertID)
+	}
+	_, err = db.Exec("INSERT INTO kv (key, value) VALUES (?, ?)", []byte("d"), []byte("4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO kv VALUES ('a', 'x')"); err != bplus.ErrDuplicateKey {
+		t.Fatalf("expected %v == %v", err, bplus.ErrDuplicateKey)
+	}
+	if _, err := db.Exec("INSERT INTO kv VALUES (?, 'x')", 1); err == nil {
+		t.Fatalf("expected an error for an integer key")
+	}
+
+	var value string
+	err = db.QueryRow("SELECT value FROM kv WHERE key = ?", "b").Scan(&value)
+	if err != nil || value != "2" {
+		t.Fatalf("expected %q == %q, got %v", value, "2", err)
+	}
+	err = db.QueryRow("SELECT value FROM kv WHERE key = 'z'").Scan(&value)
+	if err != sql.ErrNoRows {
+		t.Fatalf("expected %v == %v", err, sql.ErrNoRows)
+	}
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT key FROM kv", "a,b,c,d"},
+		{"SELECT key FROM kv WHERE key > 'a' AND key <= 'c'", "b,c"},
+		{"SELECT key FROM kv WHERE key >= 'b' AND key < 'b'", ""},
+		{"SELECT key FROM kv ORDER BY key DESC", "d,c,b,a"},
+		{"SELECT key FROM kv ORDER BY key DESC LIMIT 3", "d,c,b"},
+		{"SELECT key FROM kv LIMIT 2", "a,b"},
+	} {
+		if keys := keys(t, db, test.query); keys != test.expected {
+			t.Fatalf("expected %q == %q for %q", keys, test.expected, test.query)
+		}
+	}
+	if keys := keys(t, db, "SELECT key FROM kv LIMIT ?", 1); keys != "a" {
+		t.Fatalf("expected %q == %q", keys, "a")
+	}
+
+	res, err = db.Exec("DELETE FROM kv WHERE key >= ?", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("expected %v == %v", n, 2)
+	}
+	if keys := keys(t, db, "SELECT key FROM kv"); keys != "a,b" {
+		t.Fatalf("expected %q == %q", keys, "a,b")
+	}
+}
+
+func TestDriverTx(t *testing.T) {
+	db := openDB(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO kv VALUES ('a', '1')"); err != nil {
+		t.Fatal(err)
+	}
+	// The transaction sees its own writes.
+	var n int
+	rows, err := tx.Query("SELECT * FROM kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		n++
+	}
+	rows.Close()
+	if n != 1 {
+		t.Fatalf("expected %v == %v", n, 1)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := keys(t, db, "SELECT key FROM kv"); keys != "" {
+		t.Fatalf("expected %q == %q", keys, "")
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("INSERT INTO kv VALUES ('a', '1')")
+	tx.Exec("DELETE FROM kv WHERE key = 'a'")
+	tx.Exec("INSERT INTO kv VALUES ('b', '2')")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := keys(t, db, "SELECT key FROM kv"); keys != "b" {
+		t.Fatalf("expected %q == %q", keys, "b")
+	}
+}
+
+func TestConnector(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "sqldriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	tree, err := bplus.NewTree(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	tree.Upsert(bplus.Key("k"), bplus.Value("v"))
+
+	db := sql.OpenDB(NewConnector(tree))
+	var key, value []byte
+	if err := db.QueryRow("SELECT * FROM kv").Scan(&key, &value); err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "k" || string(value) != "v" {
+		t.Fatalf("expected %q == %q and %q == %q", key, "k", value, "v")
+	}
+	db.Close()
+	// The tree is left open.
+	if _, err := tree.Read(bplus.Key("k")); err != nil {
+		t.Fatal(err)
+	}
+}
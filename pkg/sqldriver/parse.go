@@ -0,0 +1,411 @@
+package sqldriver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Table is the name of the one table a tree is seen as, which has a key and a value
+// column.
+const Table = "kv"
+
+// SyntaxError is returned when preparing a statement that isn't in the dialect, such as
+// `syntax error at 7 ("BY"): expected FROM`.
+type SyntaxError struct {
+	// Pos is the byte offset in the statement of the token the error is at.
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d (%q): %s", e.Pos, e.Token, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPlaceholder
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token as written, or the contents of a string literal.
+	text string
+	pos  int
+}
+
+// lex splits a statement into tokens, ending with a tokEOF token.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isIdentByte(c) && !isDigit(c):
+			for i < len(query) && isIdentByte(query[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, query[start:i], start})
+		case isDigit(c):
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, query[start:i], start})
+		case c == '\'':
+			// A quote is written twice to put it in a string.
+			var b strings.Builder
+			for i++; ; i++ {
+				if i == len(query) {
+					return nil, &SyntaxError{start, query[start:], "unterminated string"}
+				}
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+				b.WriteByte(query[i])
+			}
+			i++
+			tokens = append(tokens, token{tokString, b.String(), start})
+		case c == '?':
+			i++
+			tokens = append(tokens, token{tokPlaceholder, "?", start})
+		case c == '<' || c == '>':
+			i++
+			if i < len(query) && query[i] == '=' {
+				i++
+			}
+			tokens = append(tokens, token{tokSymbol, query[start:i], start})
+		case strings.IndexByte("=(),*;", c) >= 0:
+			i++
+			tokens = append(tokens, token{tokSymbol, query[start:i], start})
+		default:
+			return nil, &SyntaxError{start, string(c), "unexpected character"}
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(query)}), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c)
+}
+
+type statementKind int
+
+const (
+	selectStatement statementKind = iota
+	insertStatement
+	deleteStatement
+)
+
+// The columns of the table.
+const (
+	keyColumn   = "key"
+	valueColumn = "value"
+)
+
+// operand is a literal or a placeholder in a statement.
+type operand struct {
+	// arg is the index of the placeholder's argument, or -1 for a literal.
+	arg     int
+	literal string
+}
+
+// predicate compares the key column to an operand.
+type predicate struct {
+	op      string
+	operand operand
+}
+
+// statement is a parsed statement.
+type statement struct {
+	kind statementKind
+	// columns are the columns a select returns.
+	columns []string
+	// where are the predicates of a select or delete, which all have to hold.
+	where []predicate
+	desc  bool
+	// limit is the most records a select returns, if hasLimit is set.
+	limit    operand
+	hasLimit bool
+	// rows are the keys and values an insert inserts.
+	rows     [][2]operand
+	numInput int
+}
+
+// parser parses the tokens of a statement.
+type parser struct {
+	tokens []token
+	stmt   *statement
+}
+
+// parse parses a statement in the dialect, see the package documentation.
+func parse(query string) (*statement, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, stmt: &statement{}}
+	switch {
+	case p.accept("SELECT"):
+		err = p.parseSelect()
+	case p.accept("INSERT"):
+		err = p.parseInsert()
+	case p.accept("DELETE"):
+		err = p.parseDelete()
+	default:
+		err = p.errorf("expected SELECT, INSERT or DELETE")
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("expected the end of the statement")
+	}
+	return p.stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[0]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[0]
+	if t.kind != tokEOF {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+// accept consumes the next token if it's the keyword or symbol s, which is matched
+// without regard to case.
+func (p *parser) accept(s string) bool {
+	t := p.peek()
+	if (t.kind == tokIdent || t.kind == tokSymbol) && strings.EqualFold(t.text, s) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("expected %s", s)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	return &SyntaxError{Pos: t.pos, Token: t.text, Msg: fmt.Sprintf(format, args...)}
+}
+
+// column parses the name of a column.
+func (p *parser) column() (string, error) {
+	switch {
+	case p.accept(keyColumn):
+		return keyColumn, nil
+	case p.accept(valueColumn):
+		return valueColumn, nil
+	}
+	return "", p.errorf("expected %s or %s", keyColumn, valueColumn)
+}
+
+func (p *parser) table() error {
+	if !p.accept(Table) {
+		return p.errorf("expected the table %s", Table)
+	}
+	return nil
+}
+
+// operand parses a string literal or a placeholder.
+func (p *parser) operand() (operand, error) {
+	switch t := p.peek(); t.kind {
+	case tokString:
+		p.next()
+		return operand{arg: -1, literal: t.text}, nil
+	case tokPlaceholder:
+		p.next()
+		p.stmt.numInput++
+		return operand{arg: p.stmt.numInput - 1}, nil
+	}
+	return operand{}, p.errorf("expected a string or ?")
+}
+
+// parseSelect parses the rest of:
+//
+//	SELECT * | column[, column] FROM kv [WHERE ...] [ORDER BY key [ASC | DESC]] [LIMIT n]
+func (p *parser) parseSelect() error {
+	if p.accept("*") {
+		p.stmt.columns = []string{keyColumn, valueColumn}
+	} else {
+		for {
+			column, err := p.column()
+			if err != nil {
+				return err
+			}
+			p.stmt.columns = append(p.stmt.columns, column)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return err
+	}
+	if err := p.table(); err != nil {
+		return err
+	}
+	if err := p.parseWhere(); err != nil {
+		return err
+	}
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return err
+		}
+		if err := p.expect(keyColumn); err != nil {
+			return err
+		}
+		if !p.accept("ASC") {
+			p.stmt.desc = p.accept("DESC")
+		}
+	}
+	if p.accept("LIMIT") {
+		switch t := p.peek(); t.kind {
+		case tokNumber:
+			p.next()
+			p.stmt.limit = operand{arg: -1, literal: t.text}
+		case tokPlaceholder:
+			p.next()
+			p.stmt.numInput++
+			p.stmt.limit = operand{arg: p.stmt.numInput - 1}
+		default:
+			return p.errorf("expected a number or ?")
+		}
+		p.stmt.hasLimit = true
+	}
+	p.stmt.kind = selectStatement
+	return nil
+}
+
+// parseWhere parses an optional:
+//
+//	WHERE key = | < | <= | > | >= operand [AND ...]
+func (p *parser) parseWhere() error {
+	if !p.accept("WHERE") {
+		return nil
+	}
+	for {
+		if err := p.expect(keyColumn); err != nil {
+			return err
+		}
+		t := p.peek()
+		if t.kind != tokSymbol || strings.IndexByte("=<>", t.text[0]) < 0 {
+			return p.errorf("expected =, <, <=, > or >=")
+		}
+		p.next()
+		o, err := p.operand()
+		if err != nil {
+			return err
+		}
+		p.stmt.where = append(p.stmt.where, predicate{op: t.text, operand: o})
+		if !p.accept("AND") {
+			return nil
+		}
+	}
+}
+
+// parseInsert parses the rest of:
+//
+//	INSERT INTO kv [(key, value)] VALUES (operand, operand)[, ...]
+func (p *parser) parseInsert() error {
+	if err := p.expect("INTO"); err != nil {
+		return err
+	}
+	if err := p.table(); err != nil {
+		return err
+	}
+	// The columns can be listed in either order.
+	swapped := false
+	if p.accept("(") {
+		first, err := p.column()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+		second, err := p.column()
+		if err != nil {
+			return err
+		}
+		if first == second {
+			return p.errorf("expected both %s and %s", keyColumn, valueColumn)
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		swapped = first == valueColumn
+	}
+	if err := p.expect("VALUES"); err != nil {
+		return err
+	}
+	for {
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		var row [2]operand
+		var err error
+		if row[0], err = p.operand(); err != nil {
+			return err
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+		if row[1], err = p.operand(); err != nil {
+			return err
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		if swapped {
+			row[0], row[1] = row[1], row[0]
+		}
+		p.stmt.rows = append(p.stmt.rows, row)
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.stmt.kind = insertStatement
+	return nil
+}
+
+// parseDelete parses the rest of:
+//
+//	DELETE FROM kv [WHERE ...]
+func (p *parser) parseDelete() error {
+	if err := p.expect("FROM"); err != nil {
+		return err
+	}
+	if err := p.table(); err != nil {
+		return err
+	}
+	p.stmt.kind = deleteStatement
+	return p.parseWhere()
+}
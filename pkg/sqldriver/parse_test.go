@@ -0,0 +1,70 @@
+package sqldriver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	s, err := parse("select key, value from KV where key >= ? and key < 'it''s' " +
+		"order by key desc limit 10;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &statement{
+		kind:    selectStatement,
+		columns: []string{keyColumn, valueColumn},
+		where: []predicate{
+			{">=", operand{arg: 0}},
+			{"<", operand{arg: -1, literal: "it's"}},
+		},
+		desc:     true,
+		limit:    operand{arg: -1, literal: "10"},
+		hasLimit: true,
+		numInput: 1,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected %+v == %+v", s, expected)
+	}
+
+	s, err = parse("INSERT INTO kv (value, key) VALUES (?, 'a'), ('b', ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][2]operand{
+		{{arg: -1, literal: "a"}, {arg: 0}},
+		{{arg: 1}, {arg: -1, literal: "b"}},
+	}
+	if s.kind != insertStatement || !reflect.DeepEqual(s.rows, rows) || s.numInput != 2 {
+		t.Fatalf("expected %+v == %+v", s.rows, rows)
+	}
+
+	s, err = parse("DELETE FROM kv WHERE key = ?")
+	if err != nil || s.kind != deleteStatement || len(s.where) != 1 {
+		t.Fatalf("expected a delete, got %+v, %v", s, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		pos   int
+	}{
+		{"UPDATE kv SET value = 'x'", 0},
+		{"SELECT * kv", 9},
+		{"SELECT * FROM other", 14},
+		{"SELECT id FROM kv", 7},
+		{"SELECT * FROM kv WHERE value = 'x'", 23},
+		{"SELECT * FROM kv WHERE key != 'x'", 27},
+		{"SELECT * FROM kv WHERE key = 'x", 29},
+		{"SELECT * FROM kv LIMIT 'x'", 23},
+		{"INSERT INTO kv (key, key) VALUES ('a', 'b')", 24},
+		{"DELETE FROM kv extra", 15},
+	} {
+		_, err := parse(test.query)
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok || syntaxErr.Pos != test.pos {
+			t.Fatalf("expected a syntax error at %d for %q, got %v", test.pos, test.query, err)
+		}
+	}
+}
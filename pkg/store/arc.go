@@ -0,0 +1,104 @@
+package store
+
+// ARC is the adaptive replacement cache policy. It splits the cache between pages that
+// have been loaded once recently and pages that have been loaded more than once, and
+// remembers the ids of the pages recently evicted from each. A page coming back soon
+// after it was evicted shows which side was short of room, and the split is moved to
+// give that side more.
+type ARC struct {
+	capacity int
+	// target is the number of slots the side for pages loaded once aims to hold.
+	target int
+	// recent holds the slots of pages loaded once and frequent the slots of pages loaded
+	// more than once, least recently used first.
+	recent   *slotList
+	frequent *slotList
+	// recentGhosts and frequentGhosts hold the ids of the pages recently evicted from
+	// each side.
+	recentGhosts   *ghostList
+	frequentGhosts *ghostList
+	evictable      []bool
+	pages          []PageID
+}
+
+// NewARC creates an ARC policy for a cache with the given number of slots.
+func NewARC(capacity int) *ARC {
+	return &ARC{
+		capacity:       capacity,
+		recent:         newSlotList(capacity),
+		frequent:       newSlotList(capacity),
+		recentGhosts:   newGhostList(),
+		frequentGhosts: newGhostList(),
+		evictable:      make([]bool, capacity),
+		pages:          make([]PageID, capacity),
+	}
+}
+
+// Loaded implements EvictionPolicy.
+func (a *ARC) Loaded(cacheID int, pageID PageID, hit bool) {
+	a.evictable[cacheID] = false
+	a.pages[cacheID] = pageID
+	if hit {
+		a.recent.remove(cacheID)
+		a.frequent.push(cacheID)
+		return
+	}
+	switch {
+	case a.recentGhosts.contains(pageID):
+		// The page would still be cached if the recent side had been bigger.
+		delta := max(a.frequentGhosts.len()/a.recentGhosts.len(), 1)
+		a.target = min(a.target+delta, a.capacity)
+		a.recentGhosts.remove(pageID)
+		a.frequent.push(cacheID)
+	case a.frequentGhosts.contains(pageID):
+		delta := max(a.recentGhosts.len()/a.frequentGhosts.len(), 1)
+		a.target = max(a.target-delta, 0)
+		a.frequentGhosts.remove(pageID)
+		a.frequent.push(cacheID)
+	default:
+		a.recent.push(cacheID)
+	}
+	// Remember at most as many pages as fit in the cache on each side.
+	a.recentGhosts.trim(max(a.capacity-a.recent.size, 0))
+	resident := a.recent.size + a.frequent.size
+	a.frequentGhosts.trim(max(2*a.capacity-resident-a.recentGhosts.len(), 0))
+}
+
+// Released implements EvictionPolicy.
+func (a *ARC) Released(cacheID int) {
+	a.evictable[cacheID] = true
+}
+
+// Evict implements EvictionPolicy.
+func (a *ARC) Evict(pageID PageID) (int, bool) {
+	evictable := func(id int) bool { return a.evictable[id] }
+	size := a.recent.size
+	fromRecent := size > 0 &&
+		(size > a.target || (size == a.target && a.frequentGhosts.contains(pageID)))
+	sides := []*slotList{a.frequent, a.recent}
+	if fromRecent {
+		sides = []*slotList{a.recent, a.frequent}
+	}
+	for _, side := range sides {
+		id, ok := side.first(evictable)
+		if !ok {
+			continue
+		}
+		side.remove(id)
+		a.evictable[id] = false
+		if side == a.recent {
+			a.recentGhosts.push(a.pages[id])
+		} else {
+			a.frequentGhosts.push(a.pages[id])
+		}
+		return id, true
+	}
+	return 0, false
+}
+
+// Remove implements EvictionPolicy.
+func (a *ARC) Remove(cacheID int) {
+	a.recent.remove(cacheID)
+	a.frequent.remove(cacheID)
+	a.evictable[cacheID] = false
+}
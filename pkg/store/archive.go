@@ -0,0 +1,351 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrArchiveGap is returned by RecoverTo when a group of writes is missing from the
+// archive, between the backup and the last group replayed, or between it and the target.
+var ErrArchiveGap = errors.New("archived write-ahead log has a gap")
+
+// lsnOffset is where the header keeps the log sequence number of the last group
+// committed to the write-ahead log, after the bytes compression dictionaries were
+// recorded in, see format.go.
+const lsnOffset = 1184
+
+// WALSegment is a stretch of the write-ahead log, made up of whole groups of writes that
+// have been committed. Every group is numbered by its log sequence number, LSN, which
+// counts the groups committed to the file's log since the file was created.
+type WALSegment struct {
+	// FirstLSN and LastLSN are the log sequence numbers of the first and last groups in
+	// the segment.
+	FirstLSN, LastLSN uint64
+	// Data holds the segment's records, laid out as they are in the log.
+	Data []byte
+}
+
+// WALArchiver keeps the groups of writes committed to the write-ahead log, so that a
+// backup can be brought forward to any point in time after it was taken, see RecoverTo.
+// Each group is handed to Archive once it has been committed to the log, before it's
+// written to the file, and again when it's replayed from the log after a crash, since
+// the crash may have come before it was archived. If Archive fails, the commit fails
+// with its error, although the group has been committed and will reach the file, and
+// the archive has a gap until it's archived again.
+type WALArchiver interface {
+	Archive(segment WALSegment) error
+}
+
+// WALArchiveFunc lets a function be used as a WALArchiver.
+type WALArchiveFunc func(segment WALSegment) error
+
+// Archive calls f.
+func (f WALArchiveFunc) Archive(segment WALSegment) error {
+	return f(segment)
+}
+
+// DirArchive is a WALArchiver that keeps segments as files in a directory, named after
+// the log sequence numbers of the groups in them. Each file is written and synced under
+// a temporary name before it's renamed into place, so the archive never holds part of a
+// segment.
+type DirArchive struct {
+	dir string
+}
+
+// NewDirArchive returns a DirArchive that keeps segments in dir, creating it if it
+// doesn't exist.
+func NewDirArchive(dir string) (*DirArchive, error) {
+	err := os.MkdirAll(dir, 0770)
+	if err != nil {
+		return nil, err
+	}
+	return &DirArchive{dir: dir}, nil
+}
+
+// Archive writes a segment to a file of its own. Archiving a segment again replaces it.
+func (a *DirArchive) Archive(segment WALSegment) error {
+	name := filepath.Join(a.dir, segmentName(segment))
+	tmp := name + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(segment.Data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Segments reads every segment in the archive, in order.
+func (a *DirArchive) Segments() ([]WALSegment, error) {
+	names, err := filepath.Glob(filepath.Join(a.dir, "*.wal"))
+	if err != nil {
+		return nil, err
+	}
+	var segments []WALSegment
+	for _, name := range names {
+		var segment WALSegment
+		_, err := fmt.Sscanf(filepath.Base(name), "%020d-%020d.wal",
+			&segment.FirstLSN, &segment.LastLSN)
+		if err != nil {
+			// Not a segment.
+			continue
+		}
+		segment.Data, err = os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	sortSegments(segments)
+	return segments, nil
+}
+
+// segmentName returns the name of the file a DirArchive keeps a segment in, which sorts
+// in the order of the segments.
+func segmentName(segment WALSegment) string {
+	return fmt.Sprintf("%020d-%020d.wal", segment.FirstLSN, segment.LastLSN)
+}
+
+func sortSegments(segments []WALSegment) {
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].FirstLSN < segments[j].FirstLSN
+	})
+}
+
+// newWALSegment describes the committed groups in data, a log's contents, returning false
+// if there are none with log sequence numbers, such as in logs written by older versions
+// of this package.
+func newWALSegment(data []byte) (WALSegment, bool, error) {
+	segment := WALSegment{Data: data}
+	_, err := readGroups(bytes.NewReader(data), func(g *walGroup) error {
+		if g.lsn == 0 {
+			return nil
+		}
+		if segment.FirstLSN == 0 {
+			segment.FirstLSN = g.lsn
+		}
+		segment.LastLSN = g.lsn
+		return nil
+	})
+	return segment, segment.FirstLSN != 0, err
+}
+
+// archive hands the groups committed to the log from the given offset on to the
+// archiver, if there is one.
+func (s *PageStore) archive(wal *WAL, offset int64) error {
+	if s.archiver == nil {
+		return nil
+	}
+	data, err := wal.contents(offset)
+	if err != nil {
+		return err
+	}
+	segment, ok, err := newWALSegment(data)
+	if err != nil || !ok {
+		return err
+	}
+	err = s.archiver.Archive(segment)
+	if err != nil {
+		s.logger.Error("archiving the write-ahead log failed",
+			"first_lsn", segment.FirstLSN, "last_lsn", segment.LastLSN, "error", err)
+	}
+	return err
+}
+
+// LSN returns the log sequence number of the last group of writes committed to the
+// write-ahead log, see WALSegment.
+func (s *PageStore) LSN() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.header.lsn
+}
+
+// RecoveryTarget is the point in time RecoverTo brings a backup forward to. The zero
+// RecoveryTarget replays every group in the archive.
+type RecoveryTarget struct {
+	// LSN stops recovery after the group with this log sequence number, unless it's zero.
+	LSN uint64
+	// Time stops recovery before the first group committed after it, unless it's zero.
+	Time time.Time
+}
+
+// reached returns whether a group is past the target, and isn't replayed.
+func (t RecoveryTarget) reached(g *walGroup) bool {
+	if t.LSN != 0 && g.lsn > t.LSN {
+		return true
+	}
+	return !t.Time.IsZero() && g.committed.After(t.Time)
+}
+
+// errTargetReached stops replaying the archive once the target has been reached.
+var errTargetReached = errors.New("recovery target reached")
+
+// RecoverTo restores a backup to backend, see Restore, then replays the groups of writes
+// archived from the write-ahead log since the backup was taken, in order, until it
+// reaches the target, see WALArchiver. It returns the log sequence number of the last
+// group replayed, or of the backup if none were. Groups the backup already holds are
+// skipped, so the archive can hold groups from before it. ErrArchiveGap is returned if
+// a group is missing, or if the archive ends before the target LSN, and ErrWrongStore
+// if a group was archived from another file than the one the backup was taken of.
+func RecoverTo(
+	backup io.Reader,
+	segments []WALSegment,
+	backend Backend,
+	target RecoveryTarget,
+) (uint64, error) {
+	err := Restore(backup, backend)
+	if err != nil {
+		return 0, err
+	}
+	header, err := readHeader(backend)
+	if err != nil {
+		return 0, err
+	}
+	lsn := header.lsn
+	segments = append([]WALSegment(nil), segments...)
+	sortSegments(segments)
+	for _, segment := range segments {
+		var reached bool
+		lsn, reached, err = replaySegment(backend, header.id, lsn, segment, target)
+		if err != nil {
+			return lsn, err
+		}
+		if reached {
+			break
+		}
+	}
+	if target.LSN != 0 && lsn < target.LSN {
+		return lsn, ErrArchiveGap
+	}
+	return lsn, markReplayed(backend)
+}
+
+// ApplyWALSegment writes the groups of writes in a segment to the file kept in backend,
+// in order, and syncs it, which is how a copy of a file, such as a replica, is kept up
+// to date with the groups committed to the original's write-ahead log. Groups the file
+// already holds are skipped, and ErrArchiveGap is returned if the group after the last
+// one it holds is missing, or ErrWrongStore if the segment was taken from the log of
+// another file than the one the copy is of. It returns the log sequence number of the
+// last group the file holds.
+func ApplyWALSegment(backend Backend, segment WALSegment) (uint64, error) {
+	header, err := readHeader(backend)
+	if err != nil {
+		return 0, err
+	}
+	lsn, _, err := replaySegment(backend, header.id, header.lsn, segment, RecoveryTarget{})
+	if err != nil {
+		return lsn, err
+	}
+	return lsn, markReplayed(backend)
+}
+
+// ReadLSN returns the log sequence number of the last group of writes the file kept in
+// backend holds, see WALSegment.
+func ReadLSN(backend Backend) (uint64, error) {
+	header, err := readHeader(backend)
+	if err != nil {
+		return 0, err
+	}
+	return header.lsn, nil
+}
+
+// replaySegment writes the groups in a segment after lsn to backend, the file with the
+// given store ID, until it reaches the target. It returns the log sequence number of the
+// last group written, or lsn if there were none, and whether the target was reached.
+func replaySegment(
+	backend Backend,
+	id StoreID,
+	lsn uint64,
+	segment WALSegment,
+	target RecoveryTarget,
+) (uint64, bool, error) {
+	_, err := readGroups(bytes.NewReader(segment.Data), func(g *walGroup) error {
+		if !g.id.matches(id) {
+			return ErrWrongStore
+		}
+		if g.lsn <= lsn {
+			return nil
+		}
+		if target.reached(g) {
+			return errTargetReached
+		}
+		if g.lsn != lsn+1 {
+			return ErrArchiveGap
+		}
+		for i, pageID := range g.pageIDs {
+			offset := pageOffset(headerImageID(pageID, g.pages[i][:]))
+			_, err := backend.WriteAt(g.pages[i][:], offset)
+			if err != nil {
+				return pageError("recover", pageID, err)
+			}
+		}
+		lsn = g.lsn
+		return nil
+	})
+	if err == errTargetReached {
+		return lsn, true, nil
+	}
+	return lsn, false, err
+}
+
+// markReplayed marks the file kept in backend as having nothing left to recover, and
+// syncs it. The header was logged while the file was being written through the log, so
+// the groups replayed leave it saying the file is.
+func markReplayed(backend Backend) error {
+	header, err := readHeader(backend)
+	if err != nil {
+		return err
+	}
+	header.dirty = 0
+	header.nextGeneration()
+	header.toBuffer()
+	setPageChecksum(&header.Buf)
+	_, err = backend.WriteAt(header.Buf[:], pageOffset(headerImageID(0, header.Buf[:])))
+	if err != nil {
+		return pageError("recover", 0, err)
+	}
+	return backend.Sync()
+}
+
+// readHeader reads the header of the file kept in backend, from whichever of its copies
+// is newest and matches its checksum, see loadHeader.
+func readHeader(backend Backend) (*headerPage, error) {
+	header := &headerPage{Page: &Page{}}
+	_, err := backend.ReadAt(header.Buf[:], 0)
+	if err != nil && err != io.EOF {
+		return nil, pageError("load", 0, err)
+	}
+	torn := !validPageChecksum(&header.Buf)
+	if mayHaveHeaderCopy(&header.Buf, torn) {
+		var second [PageSize]byte
+		n, err := backend.ReadAt(second[:], pageOffset(headerCopyID))
+		if (err == nil || err == io.EOF && n == PageSize) &&
+			useHeaderCopy(&header.Buf, torn, &second) {
+			header.Buf = second
+			torn = false
+		}
+	}
+	if torn {
+		return nil, pageError("load", 0, &ErrChecksumMismatch{PageID: 0})
+	}
+	header.fromBuffer()
+	return header, nil
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecoverTo(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "recover_to")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	defer os.Remove(walFilename(tmpfile.Name()))
+	dir, err := ioutil.TempDir("", "recover_to_archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, err := NewDirArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10), WithWALArchive(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+	var backup bytes.Buffer
+	if err := store.Backup(&backup); err != nil {
+		t.Fatal(err)
+	}
+	// Each write outside of a group is committed as a group of its own.
+	lsns := map[byte]uint64{}
+	var beforeFour time.Time
+	for b := byte(2); b <= 4; b++ {
+		if b == 4 {
+			beforeFour = time.Now()
+			time.Sleep(time.Millisecond)
+		}
+		writePages(t, store, []PageID{pageID}, b)
+		lsns[b] = store.LSN()
+	}
+	segments, err := archive.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 {
+		t.Fatalf("expected at least 3 segments, got %d", len(segments))
+	}
+
+	recover := func(target RecoveryTarget) (*MemoryBackend, uint64, error) {
+		backend := NewMemoryBackend()
+		lsn, err := RecoverTo(bytes.NewReader(backup.Bytes()), segments, backend, target)
+		return backend, lsn, err
+	}
+	for _, test := range []struct {
+		target RecoveryTarget
+		b      byte
+	}{
+		{RecoveryTarget{}, 4},
+		{RecoveryTarget{LSN: lsns[3]}, 3},
+		{RecoveryTarget{Time: beforeFour}, 3},
+		{RecoveryTarget{LSN: lsns[2]}, 2},
+	} {
+		backend, lsn, err := recover(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lsn != lsns[test.b] {
+			t.Fatalf("expected %v == %v", lsn, lsns[test.b])
+		}
+		recovered, err := OpenBackend(backend, WithCacheSize(10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recovered.Recovery().Unclean {
+			t.Fatalf("expected the recovered file not to need recovery")
+		}
+		if recovered.LSN() != lsn {
+			t.Fatalf("expected %v == %v", recovered.LSN(), lsn)
+		}
+		page, err := recovered.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(test.b)[:UsablePageSize])
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if err := recovered.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := recover(RecoveryTarget{LSN: lsns[4] + 1}); err != ErrArchiveGap {
+		t.Fatalf("expected %v == %v", err, ErrArchiveGap)
+	}
+	var gap []WALSegment
+	for _, segment := range segments {
+		if segment.FirstLSN != lsns[3] {
+			gap = append(gap, segment)
+		}
+	}
+	segments = gap
+	if _, lsn, err := recover(RecoveryTarget{}); err != ErrArchiveGap || lsn != lsns[2] {
+		t.Fatalf("expected %v == %v after %d", err, ErrArchiveGap, lsns[2])
+	}
+}
+
+func TestArchiveAfterCrash(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "archive_after_crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	defer os.Remove(walFilename(tmpfile.Name()))
+	failed := errors.New("archive unavailable")
+	failing := WALArchiveFunc(func(segment WALSegment) error { return failed })
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10), WithWALArchive(failing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	store.Begin()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+	if err := store.Commit(); err != failed {
+		t.Fatalf("expected %v == %v", err, failed)
+	}
+	lsn := store.LSN()
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The group stays in the log, and is archived when it's replayed.
+	var archived []WALSegment
+	archiver := WALArchiveFunc(func(segment WALSegment) error {
+		archived = append(archived, segment)
+		return nil
+	})
+	store, err = NewPageStore(tmpfile.Name(), WithCacheSize(10), WithWALArchive(archiver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if len(archived) != 1 || archived[0].FirstLSN != lsn || archived[0].LastLSN != lsn {
+		t.Fatalf("expected group %d to be archived, got %v", lsn, archived)
+	}
+	if store.LSN() != lsn {
+		t.Fatalf("expected %v == %v", store.LSN(), lsn)
+	}
+}
+
+func TestApplyWALSegment(t *testing.T) {
+	var segments []WALSegment
+	archiver := WALArchiveFunc(func(segment WALSegment) error {
+		segments = append(segments, segment)
+		return nil
+	})
+	store, _ := newCheckpointStore(t, "apply_wal_segment", WithWALArchive(archiver))
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if err := store.Backup(&backup); err != nil {
+		t.Fatal(err)
+	}
+	replica := NewMemoryBackend()
+	if err := Restore(&backup, replica); err != nil {
+		t.Fatal(err)
+	}
+	segments = nil
+	for b := byte(1); b <= 3; b++ {
+		writePages(t, store, []PageID{pageID}, b)
+	}
+	// The second segment is left out, then applied, and applying it again changes nothing.
+	if _, err := ApplyWALSegment(replica, segments[0]); err != nil {
+		t.Fatal(err)
+	}
+	lsn, err := ApplyWALSegment(replica, segments[2])
+	if err != ErrArchiveGap {
+		t.Fatalf("expected %v == %v", err, ErrArchiveGap)
+	}
+	if lsn != segments[0].LastLSN {
+		t.Fatalf("expected %v == %v", lsn, segments[0].LastLSN)
+	}
+	for _, segment := range append(segments[1:], segments[1]) {
+		if _, err := ApplyWALSegment(replica, segment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lsn, err = ReadLSN(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lsn != store.LSN() {
+		t.Fatalf("expected %v == %v", lsn, store.LSN())
+	}
+	reopened, err := OpenBackend(replica, WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	page, err := reopened.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(3)[:UsablePageSize])
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
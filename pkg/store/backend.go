@@ -0,0 +1,24 @@
+package store
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNoFile is returned when enabling a write-ahead log without WithWALBackend, enabling
+// a double-write buffer, or memory-mapping the file, for a store opened with
+// OpenBackend, which has no file.
+var ErrNoFile = errors.New("page store isn't kept in a file")
+
+// Backend is where a page store keeps its pages. Pages are read and written at their
+// offsets, see pageOffset, and reading past the end returns io.EOF like a file does. An
+// *os.File is a Backend, and it's what NewPageStore opens.
+type Backend interface {
+	io.ReaderAt
+	io.WriterAt
+	// Sync makes every write so far durable.
+	Sync() error
+	// Truncate cuts the backend off, or extends it with zeros, to size bytes.
+	Truncate(size int64) error
+	Close() error
+}
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrBackupCorrupt is returned when restoring a backup that isn't one, or that was
+	// cut short or damaged since it was taken.
+	ErrBackupCorrupt = errors.New("backup is corrupt")
+	// ErrBackupRunning is returned when shrinking the file while a backup is being taken,
+	// which relies on the pages that were free when it started staying where they are.
+	ErrBackupRunning = errors.New("can't shrink the file while a backup is running")
+	// ErrBackupClosed is returned when writing a backup out after it has been closed.
+	ErrBackupClosed = errors.New("backup closed")
+)
+
+const (
+	// backupMagicNumber is found in the first four bytes of a backup.
+	backupMagicNumber = 0x424B5550
+	// backupVersion is the version of the layout of backups. Backups in version 1, from
+	// before they were stamped with the store ID of the file they were taken of, are
+	// still restored.
+	backupVersion = 2
+	// backupHeaderSize is the size of the header a backup starts with: its magic number,
+	// version, the number of pages that follow it and the store ID of the file. The
+	// header of version 1 stops before the store ID.
+	backupHeaderSize  = backupHeaderSize1 + storeIDSize
+	backupHeaderSize1 = 4 + 4 + 8
+)
+
+// Backup is a copy of the file as it was at one point in time, which is written out with
+// WriteTo while the store carries on being used. A backup starts with a header, followed
+// by every page of the file in order, exactly as it's laid out in the file, so encrypted
+// pages stay that way, and each page is followed by a CRC-32 of it. See Restore for
+// turning a backup back into a file.
+//
+// In copy-on-write mode, the backup holds a snapshot of the last committed version of
+// the file, which keeps the pages it uses from being freed, and the pages allocated while
+// it's open are taken from the end of the file rather than from its free pages, so
+// nothing the backup reads is overwritten. The header and the free-space map, which are
+// written in place, are copied when the backup is started. Outside copy-on-write mode,
+// the pages are read from the file as WriteTo gets to them, so nothing may be written to
+// the store until it has returned.
+type Backup struct {
+	store *PageStore
+	// snap keeps the pages of the version being backed up from being freed, or is nil
+	// outside copy-on-write mode.
+	snap *Snapshot
+	// size is the number of pages in the file, and id is its store ID.
+	size uint64
+	id   StoreID
+	// images holds the pages that were copied when the backup was started, as they're
+	// laid out in the file.
+	images map[PageID]*[PageSize]byte
+	closed bool
+}
+
+// StartBackup starts a backup of the last committed version of the file. Pages waiting to
+// be written back are written to the file first. The backup must be closed once it has
+// been written out, so that the pages only it could read can be reused.
+func (s *PageStore) StartBackup() (*Backup, error) {
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	err := s.flush()
+	if err != nil {
+		return nil, err
+	}
+	header := Page{Buf: s.header.Buf}
+	if s.cow && s.depth > 0 {
+		// As when the group is committed, the last committed header is backed up along with
+		// the pages the group has allocated, which the backup leaks.
+		header.Buf = s.headerAtBegin
+		for _, r := range s.header.allocation() {
+			copy(header.Buf[r[0]:r[1]], s.header.Buf[r[0]:r[1]])
+		}
+	}
+	// The copy has nothing to recover from a write-ahead log, and was never opened.
+	h := &headerPage{Page: &header}
+	h.fromBuffer()
+	h.dirty = 0
+	h.closedCleanly = 0
+	h.writing = 0
+	h.toBuffer()
+	b := &Backup{store: s, size: h.size, id: h.id, images: map[PageID]*[PageSize]byte{}}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	// The header is only checksummed rather than moved on to the next generation as it
+	// would be when it's written to the file, see stampGeneration, and both of its
+	// copies hold it.
+	setPageChecksum(&header.Buf)
+	for pageID := PageID(0); pageID < headerPages(h.version); pageID++ {
+		b.images[pageID] = &header.Buf
+	}
+	if s.freeSpace != nil {
+		for group, bits := range s.freeSpace.maps {
+			pageID := s.freeSpace.mapPageID(group)
+			var buf [PageSize]byte
+			copy(buf[:], bits)
+			b.images[pageID], err = s.encodePage(pageID, &buf)
+			if err != nil {
+				return nil, pageError("back up", pageID, err)
+			}
+		}
+	}
+	if s.cow {
+		s.snapshots[s.version]++
+		b.snap = &Snapshot{store: s, version: s.version}
+	}
+	s.backups++
+	return b, nil
+}
+
+// Backup writes a backup of the last committed version of the file to w, see Backup.
+func (s *PageStore) Backup(w io.Writer) (err error) {
+	b, err := s.StartBackup()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := b.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = b.WriteTo(w)
+	return err
+}
+
+// WriteTo writes the backup to w and returns the number of bytes written.
+func (b *Backup) WriteTo(w io.Writer) (int64, error) {
+	if b.closed {
+		return 0, ErrBackupClosed
+	}
+	var written int64
+	header := make([]byte, backupHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], backupMagicNumber)
+	binary.LittleEndian.PutUint32(header[4:8], backupVersion)
+	binary.LittleEndian.PutUint64(header[8:16], b.size)
+	copy(header[backupHeaderSize1:], b.id[:])
+	n, err := w.Write(header)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	record := make([]byte, PageSize+4)
+	for pageID := PageID(0); pageID < PageID(b.size); pageID++ {
+		err = b.readImage(pageID, record[:PageSize])
+		if err != nil {
+			return written, err
+		}
+		checksum(record)
+		n, err = w.Write(record)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// readImage reads a page as it's laid out in the file into buf. Pages past the end of the
+// file, which have been allocated but not written yet, are read as zeros.
+func (b *Backup) readImage(pageID PageID, buf []byte) error {
+	if image, ok := b.images[pageID]; ok {
+		copy(buf, image[:])
+		return nil
+	}
+	s := b.store
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	n, err := s.backend.ReadAt(buf, pageOffset(pageID))
+	if err == io.EOF {
+		clear(buf[n:])
+		return nil
+	}
+	return pageError("back up", pageID, err)
+}
+
+// Close ends the backup, letting the pages only it could read be reused, and the free
+// pages be allocated again.
+func (b *Backup) Close() error {
+	if b.closed {
+		return ErrBackupClosed
+	}
+	b.closed = true
+	s := b.store
+	s.headerLock.Lock()
+	s.backups--
+	s.headerLock.Unlock()
+	if b.snap != nil {
+		return b.snap.Close()
+	}
+	return nil
+}
+
+// Restore writes the file a backup was taken of to backend, which must be empty, and
+// syncs it. The backup is checked as it's read, and ErrBackupCorrupt is returned if it
+// doesn't hold the whole of a file, in which case backend is left holding part of one.
+// A store can be opened on backend once it has been restored, with the same keys as the
+// store the backup was taken of.
+func Restore(r io.Reader, backend Backend) error {
+	size, _, err := readBackupHeader(r)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, PageSize+4)
+	for pageID := PageID(0); pageID < PageID(size); pageID++ {
+		_, err = io.ReadFull(r, record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrBackupCorrupt
+		}
+		if err != nil {
+			return err
+		}
+		if !validChecksum(record) {
+			return pageError("restore", pageID, ErrBackupCorrupt)
+		}
+		n, err := backend.WriteAt(record[:PageSize], pageOffset(pageID))
+		if err != nil {
+			return pageError("restore", pageID, err)
+		}
+		if n != PageSize {
+			return pageError("restore", pageID, ErrPageNotFullyWritten)
+		}
+	}
+	return backend.Sync()
+}
+
+// readBackupHeader reads the header a backup starts with, returning the number of pages
+// that follow it and the store ID of the file it was taken of, which is zero for backups
+// in version 1.
+func readBackupHeader(r io.Reader) (uint64, StoreID, error) {
+	var id StoreID
+	header := make([]byte, backupHeaderSize)
+	_, err := io.ReadFull(r, header[:backupHeaderSize1])
+	if err == nil && binary.LittleEndian.Uint32(header[4:8]) == backupVersion {
+		_, err = io.ReadFull(r, header[backupHeaderSize1:])
+		copy(id[:], header[backupHeaderSize1:])
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, id, ErrBackupCorrupt
+	}
+	if err != nil {
+		return 0, id, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != backupMagicNumber {
+		return 0, id, ErrBackupCorrupt
+	}
+	version := binary.LittleEndian.Uint32(header[4:8])
+	if version != 1 && version != backupVersion {
+		return 0, id, ErrUnsupportedVersion
+	}
+	return binary.LittleEndian.Uint64(header[8:16]), id, nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestBackupCOW(t *testing.T) {
+	for _, version := range []int{FormatVersion1, CurrentFormatVersion} {
+		store, err := NewMemoryPageStore(WithCacheSize(20), WithFormatVersion(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.EnableCOW(); err != nil {
+			t.Fatal(err)
+		}
+		var pages []PageID
+		store.Begin()
+		for i := 0; i < 6; i++ {
+			pageID, err := store.Allocate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, pageID)
+		}
+		writePages(t, store, pages, 1)
+		if err := store.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		freed := pages[5]
+		pages = pages[:5]
+		store.Begin()
+		if err := store.Free(freed); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		backup, err := store.StartBackup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Writes carry on while the backup is running, without reusing the free page.
+		store.Begin()
+		writePages(t, store, pages[:3], 2)
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pageID == freed {
+			t.Fatalf("expected page %d not to be reused during a backup", freed)
+		}
+		if err := store.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Shrink(false); err != ErrBackupRunning {
+			t.Fatalf("expected %v == %v", err, ErrBackupRunning)
+		}
+		var buf bytes.Buffer
+		if _, err := backup.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := backup.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := backup.Close(); err != ErrBackupClosed {
+			t.Fatalf("expected %v == %v", err, ErrBackupClosed)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		backend := NewMemoryBackend()
+		if err := Restore(&buf, backend); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := OpenBackend(backend, WithCacheSize(20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pageID := range pages {
+			page, err := restored.Load(pageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(1)[:UsablePageSize])
+			if err := page.Release(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := restored.CheckFreeSpace(); err != nil {
+			t.Fatal(err)
+		}
+		free, err := restored.FreePageIDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(free) != 1 || free[0] != freed {
+			t.Fatalf("expected %v == %v", free, []PageID{freed})
+		}
+		if err := restored.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackupWithoutCOW(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The page is still waiting to be written back when the backup is taken.
+	writePages(t, store, []PageID{pageID}, 3)
+	var buf bytes.Buffer
+	if err := store.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backend := NewMemoryBackend()
+	if err := Restore(bytes.NewReader(buf.Bytes()), backend); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	page, err := restored.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(3)[:UsablePageSize])
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := store.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backup := buf.Bytes()
+
+	cut := backup[:len(backup)-10]
+	if err := Restore(bytes.NewReader(cut), NewMemoryBackend()); err != ErrBackupCorrupt {
+		t.Fatalf("expected %v == %v", err, ErrBackupCorrupt)
+	}
+	damaged := append([]byte(nil), backup...)
+	damaged[backupHeaderSize+PageSize+4+100] ^= 1
+	err = Restore(bytes.NewReader(damaged), NewMemoryBackend())
+	var pageErr *PageError
+	corrupt := errors.Is(err, ErrBackupCorrupt) && errors.As(err, &pageErr)
+	if !corrupt || pageErr.PageID != 1 {
+		t.Fatalf("expected page 1 of the backup to be corrupt, got %v", err)
+	}
+	notBackup := append([]byte(nil), backup...)
+	notBackup[0] ^= 1
+	err = Restore(bytes.NewReader(notBackup), NewMemoryBackend())
+	if err != ErrBackupCorrupt {
+		t.Fatalf("expected %v == %v", err, ErrBackupCorrupt)
+	}
+
+	// Backups from before they were stamped with a store ID are still restored.
+	older := append([]byte(nil), backup[:backupHeaderSize1]...)
+	older = append(older, backup[backupHeaderSize:]...)
+	binary.LittleEndian.PutUint32(older[4:8], 1)
+	if err := Restore(bytes.NewReader(older), NewMemoryBackend()); err != nil {
+		t.Fatal(err)
+	}
+}
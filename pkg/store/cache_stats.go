@@ -0,0 +1,70 @@
+package store
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats counts what the page cache has done since the store was opened, so that the
+// cache can be sized from how the store is actually used rather than guessed at, see
+// WithCacheSize.
+type CacheStats struct {
+	// Hits is the number of loads of pages that were already in the cache.
+	Hits uint64
+	// Misses is the number of loads of pages that weren't in the cache.
+	Misses uint64
+	// Evictions is the number of pages evicted from the cache to make room for others.
+	Evictions uint64
+	// Loads is the number of pages read from the file, both by loads that missed the
+	// cache and by prefetches, see Prefetch.
+	Loads uint64
+	// Writes is the number of pages written to the file.
+	Writes uint64
+	// Syncs is the number of times the file was synced to disk.
+	Syncs uint64
+	// IOTime is the time spent reading pages from the file, writing them to it and
+	// syncing it.
+	IOTime time.Duration
+}
+
+// HitRatio returns the fraction of loads that hit the cache, or 0 if there haven't been
+// any.
+func (c CacheStats) HitRatio() float64 {
+	if c.Hits+c.Misses == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(c.Hits+c.Misses)
+}
+
+// cacheCounters are the counters behind CacheStats. They're atomic because pages are
+// loaded with the store's lock only held for reading.
+type cacheCounters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	loads     atomic.Uint64
+	writes    atomic.Uint64
+	syncs     atomic.Uint64
+	ioTime    atomic.Int64
+	// lockWait is the time flushes spent waiting for writeLock, see logFlush.
+	lockWait atomic.Int64
+}
+
+// observeIO adds the time since start to the time spent on I/O.
+func (c *cacheCounters) observeIO(start time.Time) {
+	c.ioTime.Add(int64(time.Since(start)))
+}
+
+// CacheStats returns a snapshot of the cache's counters. The counters are read one at a
+// time while the store is in use, so they may be a few operations apart from each other.
+func (s *PageStore) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:      s.counters.hits.Load(),
+		Misses:    s.counters.misses.Load(),
+		Evictions: s.counters.evictions.Load(),
+		Loads:     s.counters.loads.Load(),
+		Writes:    s.counters.writes.Load(),
+		Syncs:     s.counters.syncs.Load(),
+		IOTime:    time.Duration(s.counters.ioTime.Load()),
+	}
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestCacheStats(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 6; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenBackend(backend, WithCacheSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	start := store.CacheStats()
+	loadPages(t, store, pages[:1])
+	loadPages(t, store, pages[:1])
+	expectCacheStats(t, start, store.CacheStats(), CacheStats{Hits: 1, Misses: 1, Loads: 1})
+
+	// The rest of the pages don't fit alongside the header, so some have to be evicted.
+	start = store.CacheStats()
+	loadPages(t, store, pages[1:])
+	stats := store.CacheStats()
+	if stats.Evictions == start.Evictions {
+		t.Fatalf("expected pages to be evicted")
+	}
+	expectCacheStats(t, start, stats, CacheStats{
+		Misses:    5,
+		Evictions: stats.Evictions - start.Evictions,
+		Loads:     5,
+	})
+
+	start = store.CacheStats()
+	writePages(t, store, pages[5:], 2)
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// Marking the file as being written to, and clearing the mark once it's synced, each
+	// write the header and sync it.
+	expectCacheStats(t, start, store.CacheStats(), CacheStats{
+		Hits:   1,
+		Writes: 3,
+		Syncs:  3,
+	})
+	if ratio := store.CacheStats().HitRatio(); ratio <= 0 || ratio >= 1 {
+		t.Fatalf("expected 0 < %v < 1", ratio)
+	}
+}
+
+func TestCacheStatsPrefetch(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 4; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	start := store.CacheStats()
+	store.Prefetch(pages...)
+	waitForCached(t, store, pages)
+	// Prefetched pages are read from the file without missing the cache, and loading
+	// them afterwards hits it.
+	loadPages(t, store, pages)
+	expectCacheStats(t, start, store.CacheStats(), CacheStats{Hits: 4, Loads: 4})
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	if ratio := (CacheStats{}).HitRatio(); ratio != 0 {
+		t.Fatalf("expected %v == %v", ratio, 0)
+	}
+	if ratio := (CacheStats{Hits: 3, Misses: 1}).HitRatio(); ratio != 0.75 {
+		t.Fatalf("expected %v == %v", ratio, 0.75)
+	}
+}
+
+// loadPages loads and releases every page.
+func loadPages(t *testing.T, store *PageStore, pages []PageID) {
+	t.Helper()
+	for _, pageID := range pages {
+		page, err := store.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectCacheStats checks that the counters moved from start to end by expected.
+func expectCacheStats(t *testing.T, start, end, expected CacheStats) {
+	t.Helper()
+	delta := CacheStats{
+		Hits:      end.Hits - start.Hits,
+		Misses:    end.Misses - start.Misses,
+		Evictions: end.Evictions - start.Evictions,
+		Loads:     end.Loads - start.Loads,
+		Writes:    end.Writes - start.Writes,
+		Syncs:     end.Syncs - start.Syncs,
+	}
+	if delta != expected {
+		t.Fatalf("expected %+v == %+v", delta, expected)
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import "fmt"
+
+// HeaderError is returned by CheckHeader for a field of the header that doesn't fit the
+// file, such as "header root: page 12 is past the end of the file's 8 pages", and when
+// opening a file whose header describes a format this package can't read, such as
+// "header page size: 8192 bytes, expected 4096".
+type HeaderError struct {
+	// Field is the field of the header that's wrong.
+	Field  string
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("header %s: %s", e.Field, e.Reason)
+}
+
+// CheckHeader checks that the fields of the header fit the file, returning a HeaderError
+// for the first one that doesn't: the root, the head of the free list of older formats
+// and the holes punched out of the file have to be inside it, files with a free-space map
+// have to have room for its first page, and no checkpoint can be past the end of the
+// write-ahead log. The magic number and version are checked when the file is opened.
+func (s *PageStore) CheckHeader() error {
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	h := s.header
+	minSize := uint64(headerPages(h.version))
+	if s.freeSpace != nil {
+		minSize++
+	}
+	if h.size < minSize {
+		return &HeaderError{"size", fmt.Sprintf(
+			"%d pages, expected at least %d", h.size, minSize)}
+	}
+	if h.root >= h.size {
+		return &HeaderError{"root", fmt.Sprintf(
+			"page %d is past the end of the file's %d pages", h.root, h.size)}
+	}
+	if s.freeSpace != nil && h.freeList != 0 {
+		return &HeaderError{"free list", fmt.Sprintf(
+			"offset 0x%X in a file with a free-space map", h.freeList)}
+	}
+	if h.freeList%PageSize != 0 || h.freeList/PageSize >= h.size {
+		return &HeaderError{"free list", fmt.Sprintf(
+			"offset 0x%X is not a page of the file's %d pages", h.freeList, h.size)}
+	}
+	for _, hole := range h.holes {
+		if s.isHeader(PageID(hole.start)) || hole.count == 0 ||
+			hole.start+hole.count > h.size {
+			return &HeaderError{"holes", fmt.Sprintf(
+				"%d pages from page %d don't fit the file's %d pages",
+				hole.count, hole.start, h.size)}
+		}
+	}
+	if h.checkpointLSN > h.lsn {
+		return &HeaderError{"checkpoint lsn", fmt.Sprintf(
+			"%d is past the lsn %d", h.checkpointLSN, h.lsn)}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckHeader(t *testing.T) {
+	for _, version := range []int{FormatVersion1, FormatVersion3} {
+		store, err := NewMemoryPageStore(WithCacheSize(20), WithFormatVersion(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetRoot(pageID, 4); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CheckHeader(); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []struct {
+			field   string
+			corrupt func(h *headerPage)
+		}{
+			{"size", func(h *headerPage) { h.size = 0 }},
+			{"root", func(h *headerPage) { h.root = h.size }},
+			{"free list", func(h *headerPage) { h.freeList = PageSize + 1 }},
+			{"holes", func(h *headerPage) { h.holes = []hole{{start: h.size, count: 1}} }},
+			{"checkpoint lsn", func(h *headerPage) { h.checkpointLSN = h.lsn + 1 }},
+		} {
+			saved := *store.header
+			c.corrupt(store.header)
+			var headerErr *HeaderError
+			err := store.CheckHeader()
+			if !errors.As(err, &headerErr) || headerErr.Field != c.field {
+				t.Fatalf("expected a header error in the %v, got %v", c.field, err)
+			}
+			*store.header = saved
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
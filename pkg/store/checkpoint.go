@@ -0,0 +1,166 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCheckpointInGroup is returned when checkpointing between Begin and Commit, while the
+// header has changes that haven't been committed to the log yet.
+var ErrCheckpointInGroup = errors.New("can't checkpoint while a group is open")
+
+// checkpointLSNOffset is where the header keeps the log sequence number of the last group
+// a checkpoint synced to the file, after the log sequence number of the last group.
+const checkpointLSNOffset = lsnOffset + 8
+
+// By default, every group committed to the write-ahead log is written to the file, the
+// file is synced, and the log is emptied, so the log never holds more than one group.
+// With checkpoints, see WithCheckpoints, groups are still written to the file as they're
+// committed, but the file is left for the operating system to write out, and the groups
+// stay in the log. A checkpoint syncs the file, records the log sequence number of the
+// last group in the header, and only then empties the log. Recovery replays the groups
+// committed since the checkpoint, so a commit costs one sync of the log rather than a
+// sync of the log and of the file, while the log, and the time it takes to replay it,
+// is bounded by how often checkpoints are taken. A log split into segments, see
+// WithWALSegments, isn't emptied by a checkpoint, which removes the completed segments
+// it no longer needs instead, so without checkpoints, every commit takes one.
+
+// Checkpoint syncs every group committed to the write-ahead log to the file, records it
+// in the header, and empties the log, see WithCheckpoints. Without checkpoints, the log
+// is already emptied by every commit, and there's nothing to do.
+func (s *PageStore) Checkpoint() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.depth > 0 {
+		return ErrCheckpointInGroup
+	}
+	return s.checkpoint()
+}
+
+// CheckpointLSN returns the log sequence number of the last group of writes a checkpoint
+// synced to the file, see Checkpoint.
+func (s *PageStore) CheckpointLSN() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.header.checkpointLSN
+}
+
+// checkpointing returns whether groups are left in the log until a checkpoint, which is
+// the case when the log is split into segments too, since segments aren't emptied.
+func (s *PageStore) checkpointing() bool {
+	return s.checkpointBytes > 0 || s.checkpointInterval > 0 || s.walSegmentSize > 0
+}
+
+// checkpointIfDue takes a checkpoint once the log has grown past the size that triggers
+// one, or after every group if nothing else triggers them.
+func (s *PageStore) checkpointIfDue() error {
+	if s.checkpointBytes <= 0 && s.checkpointInterval <= 0 {
+		return s.checkpoint()
+	}
+	if s.checkpointBytes <= 0 || s.wal.Size() < s.checkpointBytes {
+		return nil
+	}
+	return s.checkpoint()
+}
+
+func (s *PageStore) checkpoint() error {
+	if s.wal == nil || s.wal.Size() == 0 {
+		return nil
+	}
+	size := s.wal.Size()
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+	// The pages have to be on disk before the header says they are, or recovery would
+	// skip groups whose pages were lost.
+	if s.unsynced {
+		err = s.sync()
+		if err != nil {
+			return err
+		}
+	}
+	s.header.checkpointLSN = s.header.lsn
+	s.header.toBuffer()
+	err = s.syncHeader()
+	if err != nil {
+		return err
+	}
+	// Recovery skips every group in the log from here on, so it can be let go of.
+	err = s.wal.applied(s.header.checkpointLSN)
+	if err != nil {
+		return err
+	}
+	s.logger.Debug("checkpointed the write-ahead log",
+		"lsn", s.header.checkpointLSN, "log_bytes", size)
+	return nil
+}
+
+// startCheckpointing starts taking a checkpoint in the background every interval, if
+// checkpoints are taken on an interval.
+func (s *PageStore) startCheckpointing() {
+	if s.checkpointInterval <= 0 || s.stopCheckpointing != nil {
+		return
+	}
+	s.stopCheckpointing = make(chan struct{})
+	go s.checkpointPeriodically(s.checkpointInterval, s.stopCheckpointing)
+}
+
+func (s *PageStore) checkpointPeriodically(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		s.Lock()
+		// A group that's open is checkpointed on the next tick instead.
+		if !s.closed && s.depth == 0 {
+			err := s.checkpoint()
+			if err != nil {
+				s.backgroundError("checkpoint", err)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// readCheckpointLSN reads the log sequence number of the last checkpoint from the header
+// in the file, before it's loaded, so that recovery can skip the groups in the log that
+// it already holds. A header that can't be read, such as one that was torn by a crash,
+// has no checkpoint, and the whole log is replayed, rewriting it.
+func (s *PageStore) readCheckpointLSN() uint64 {
+	header, err := readHeader(s.backend)
+	if err != nil {
+		return 0
+	}
+	return header.checkpointLSN
+}
+
+// recordRecovered records in the header in the file, before it's loaded, that recovery
+// has synced every group in the log to the file, so that they aren't replayed again if
+// the log isn't emptied. It returns the log sequence number of the last group.
+func (s *PageStore) recordRecovered() (uint64, error) {
+	header, err := readHeader(s.backend)
+	if err != nil {
+		// Logs from older versions of this package may leave a header without a checksum,
+		// and without log sequence numbers to record.
+		return 0, nil
+	}
+	header.checkpointLSN = header.lsn
+	header.nextGeneration()
+	header.toBuffer()
+	setPageChecksum(&header.Buf)
+	s.writeLock.Lock()
+	err = s.writeImage(header.ID, &header.Buf)
+	s.writeLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return header.lsn, s.sync()
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// newCheckpointStore opens a store in a temporary file with a write-ahead log enabled.
+func newCheckpointStore(
+	t *testing.T,
+	pattern string,
+	opts ...Option,
+) (*PageStore, string) {
+	tmpfile, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	t.Cleanup(func() {
+		os.Remove(tmpfile.Name())
+		os.Remove(walFilename(tmpfile.Name()))
+		segments, _ := listWALSegments(walFilename(tmpfile.Name()))
+		for _, segment := range segments {
+			os.Remove(segment.Filename)
+		}
+	})
+	store, err := NewPageStore(tmpfile.Name(), append(opts, WithCacheSize(10))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	return store, tmpfile.Name()
+}
+
+func TestCheckpointBytes(t *testing.T) {
+	// A write outside of a group logs the page and the header.
+	group := int64(2*walPageRecordSize + walCommitRecordIDSize)
+	store, _ := newCheckpointStore(t, "checkpoint_bytes", WithCheckpoints(3*group, 0))
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := store.LSN()
+	for b := byte(1); b <= 2; b++ {
+		writePages(t, store, []PageID{pageID}, b)
+	}
+	if store.wal.Size() != 2*group {
+		t.Fatalf("expected %v == %v", store.wal.Size(), 2*group)
+	}
+	if store.CheckpointLSN() != checkpoint {
+		t.Fatalf("expected %v == %v", store.CheckpointLSN(), checkpoint)
+	}
+	writePages(t, store, []PageID{pageID}, 3)
+	if store.wal.Size() != 0 {
+		t.Fatalf("expected the log to be emptied, got %d bytes", store.wal.Size())
+	}
+	if store.CheckpointLSN() != store.LSN() {
+		t.Fatalf("expected %v == %v", store.CheckpointLSN(), store.LSN())
+	}
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	store, _ := newCheckpointStore(t, "checkpoint_interval",
+		WithCheckpoints(0, 5*time.Millisecond))
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for store.CheckpointLSN() != store.LSN() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a checkpoint to be taken")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckpointInGroup(t *testing.T) {
+	store, _ := newCheckpointStore(t, "checkpoint_in_group", WithCheckpoints(1<<20, 0))
+	defer store.Close()
+	store.Begin()
+	if err := store.Checkpoint(); err != ErrCheckpointInGroup {
+		t.Fatalf("expected %v == %v", err, ErrCheckpointInGroup)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoveryAfterCheckpoint(t *testing.T) {
+	store, filename := newCheckpointStore(t, "recovery_after_checkpoint",
+		WithCheckpoints(1<<20, 0))
+	first, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{first, second}, 1)
+	// Keep the groups the checkpoint is about to empty the log of, as though the process
+	// crashed before the log was emptied.
+	checkpointed, err := store.wal.contents(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{first}, 2)
+	logged, err := store.wal.contents(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(walFilename(filename), append(checkpointed, logged...), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewPageStore(filename, WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	// Only the page and the header written since the checkpoint are replayed.
+	if reopened.Recovery().RecordsReplayed != 2 {
+		t.Fatalf("expected %v == %v", reopened.Recovery().RecordsReplayed, 2)
+	}
+	for pageID, fill := range map[PageID]byte{first: 2, second: 1} {
+		page, err := reopened.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(fill)[:UsablePageSize])
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCloseCheckpoints(t *testing.T) {
+	store, filename := newCheckpointStore(t, "close_checkpoints", WithCheckpoints(1<<20, 0))
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+	if fileSize(t, walFilename(filename)) == 0 {
+		t.Fatalf("expected the group to be left in the log")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, walFilename(filename)); size != 0 {
+		t.Fatalf("expected %v == %v", size, 0)
+	}
+}
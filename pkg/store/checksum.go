@@ -0,0 +1,41 @@
+package store
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// UsablePageSize is the number of bytes at the start of every page that are free for its
+// contents. The last four bytes of the page hold a CRC-32C of the rest, which is set
+// whenever the page is written to the file and checked whenever it's read back.
+const UsablePageSize = PageSize - 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is returned, wrapped in a PageError, when a page read from the file
+// doesn't match the checksum it was written with, meaning the page was corrupted after it
+// was written.
+type ErrChecksumMismatch struct {
+	// PageID is the corrupted page.
+	PageID PageID
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return "checksum mismatch"
+}
+
+func setPageChecksum(buf *[PageSize]byte) {
+	sum := crc32.Checksum(buf[:UsablePageSize], castagnoli)
+	binary.LittleEndian.PutUint32(buf[UsablePageSize:], sum)
+}
+
+// validPageChecksum returns whether a page matches its checksum. A page of zeros is
+// valid because it's a page that was allocated but never written, which the file system
+// fills in with zeros when a later page is written.
+func validPageChecksum(buf *[PageSize]byte) bool {
+	sum := crc32.Checksum(buf[:UsablePageSize], castagnoli)
+	if binary.LittleEndian.Uint32(buf[UsablePageSize:]) == sum {
+		return true
+	}
+	return *buf == [PageSize]byte{}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestChecksumMismatch(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "checksum_mismatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Buf = *pageFilledWith(5)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	// A page that was allocated but never written reads as zeros without a mismatch.
+	unwritten, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(unwritten); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{6}, int64(pageID)*PageSize+10); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Failed loads don't hold on to cache slots.
+	for i := 0; i < 3; i++ {
+		_, err = reopened.Load(pageID)
+		var mismatch *ErrChecksumMismatch
+		if !errors.As(err, &mismatch) || mismatch.PageID != pageID {
+			t.Fatalf("expected a checksum mismatch in page %d, got %v", pageID, err)
+		}
+	}
+}
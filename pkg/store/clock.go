@@ -0,0 +1,55 @@
+package store
+
+// Clock approximates LRU without moving slots around on every load. Slots sit on a
+// circle with a hand pointing at one of them. Loading a page sets its slot's reference
+// bit, and eviction sweeps the hand around the circle clearing reference bits until it
+// finds an unpinned slot whose bit is already clear.
+type Clock struct {
+	referenced []bool
+	evictable  []bool
+	hand       int
+}
+
+// NewClock creates a Clock policy for a cache with the given number of slots.
+func NewClock(capacity int) *Clock {
+	return &Clock{
+		referenced: make([]bool, capacity),
+		evictable:  make([]bool, capacity),
+	}
+}
+
+// Loaded implements EvictionPolicy.
+func (c *Clock) Loaded(cacheID int, pageID PageID, hit bool) {
+	c.referenced[cacheID] = true
+	c.evictable[cacheID] = false
+}
+
+// Released implements EvictionPolicy.
+func (c *Clock) Released(cacheID int) {
+	c.evictable[cacheID] = true
+}
+
+// Evict implements EvictionPolicy.
+func (c *Clock) Evict(pageID PageID) (int, bool) {
+	// Two turns are enough to clear every reference bit and come back around.
+	for i := 0; i < 2*len(c.evictable); i++ {
+		id := c.hand
+		c.hand = (c.hand + 1) % len(c.evictable)
+		if !c.evictable[id] {
+			continue
+		}
+		if c.referenced[id] {
+			c.referenced[id] = false
+			continue
+		}
+		c.evictable[id] = false
+		return id, true
+	}
+	return 0, false
+}
+
+// Remove implements EvictionPolicy.
+func (c *Clock) Remove(cacheID int) {
+	c.referenced[cacheID] = false
+	c.evictable[cacheID] = false
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"errors"
+)
+
+// ErrClosed is returned when using a page store after it has been closed.
+var ErrClosed = errors.New("page store closed")
+
+// Close shuts the page store down cleanly. Dirty pages are written back, pages held back
+// for open snapshots are freed, free pages at the end of the file are cut off so the file
+// shrinks as it would with Shrink, every write is synced to disk, and the header is
+// marked as closed cleanly before the file is closed, none of which happens to a
+// read-only store. Any use of the
+// store afterwards, including through its snapshots, returns ErrClosed. With leak
+// detection enabled, the store is still closed if any page handles were never released,
+// but a *LeakError listing them is returned.
+func (s *PageStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.stopBackground()
+	if !s.readOnly {
+		err := s.closeCleanly()
+		if err != nil {
+			return err
+		}
+	}
+	err := s.closeFiles()
+	if err != nil {
+		return err
+	}
+	if leaks := s.leaks(); len(leaks) > 0 {
+		return &LeakError{Leaks: leaks}
+	}
+	return nil
+}
+
+// Abandon closes the store without writing anything back to the file, leaving the file
+// as it would be if the process had crashed: writes that were neither written back nor
+// committed are lost, and the header isn't marked as closed cleanly. It's meant for
+// testing how files are recovered, since it lets go of the lock on the file so that it
+// can be opened again. Any use of the store afterwards returns ErrClosed.
+func (s *PageStore) Abandon() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.stopBackground()
+	return s.closeFiles()
+}
+
+// stopBackground stops the goroutines syncing, flushing and checkpointing the store in
+// the background.
+func (s *PageStore) stopBackground() {
+	if s.stopSyncing != nil {
+		close(s.stopSyncing)
+		s.stopSyncing = nil
+	}
+	if s.stopCheckpointing != nil {
+		close(s.stopCheckpointing)
+		s.stopCheckpointing = nil
+	}
+	s.stopFlusher()
+}
+
+// closeFiles marks the store as closed and closes its files, which lets go of the lock
+// on the file.
+func (s *PageStore) closeFiles() error {
+	s.closed = true
+	if s.mapping != nil {
+		err := s.mapping.close()
+		if err != nil {
+			return err
+		}
+	}
+	if s.wal != nil {
+		err := s.wal.Close()
+		if err != nil {
+			return err
+		}
+	} else if s.walBackend != nil {
+		err := s.walBackend.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if s.doubleWrite != nil {
+		err := s.doubleWrite.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return s.backend.Close()
+}
+
+// closeCleanly writes everything back to the file before it's closed, and marks the
+// header as closed cleanly.
+func (s *PageStore) closeCleanly() error {
+	// The file is about to be rewritten without going through the log, which replaying
+	// the groups left in it would undo.
+	err := s.checkpoint()
+	if err != nil {
+		return err
+	}
+	err = s.flush()
+	if err != nil {
+		return err
+	}
+	var held []PageID
+	for _, frees := range s.held {
+		held = append(held, frees.pages...)
+	}
+	s.held = nil
+	err = s.freePages(held)
+	if err != nil {
+		return err
+	}
+	err = s.shrink(false)
+	if err != nil {
+		return err
+	}
+	s.header.closedCleanly = 1
+	s.header.writing = 0
+	s.header.toBuffer()
+	return s.syncHeader()
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestClose(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := store.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pageID := range []PageID{4, 7, 6} {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != ErrClosed {
+		t.Fatalf("expected %v == %v", err, ErrClosed)
+	}
+	if _, err := store.Load(1); err != ErrClosed {
+		t.Fatalf("expected %v == %v", err, ErrClosed)
+	}
+	if _, err := store.Allocate(); err != ErrClosed {
+		t.Fatalf("expected %v == %v", err, ErrClosed)
+	}
+	// The free pages at the end of the file were cut off.
+	if fileSize(t, tmpfile.Name()) != 6*PageSize {
+		t.Fatalf("expected %v == %v", fileSize(t, tmpfile.Name()), 6*PageSize)
+	}
+
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Recovery().ClosedCleanly {
+		t.Fatal("expected the file to have been closed cleanly")
+	}
+	for _, expected := range []PageID{4, 6} {
+		pageID, err := reopened.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pageID != expected {
+			t.Fatalf("expected %v == %v", pageID, expected)
+		}
+	}
+	if err := reopened.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Recovery().ClosedCleanly {
+		t.Fatal("expected the file not to have been closed cleanly")
+	}
+}
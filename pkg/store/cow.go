@@ -0,0 +1,190 @@
+package store
+
+// EnableCOW turns on copy-on-write mode, an alternative to a write-ahead log for keeping
+// the file consistent after a crash. A page written between Begin and Commit is copied to
+// a fresh page the first time it's written rather than being overwritten, and every
+// write is held in memory until the group is committed. Committing writes the fresh pages
+// to the file, syncs it, and only then writes the header, so the root recorded in the
+// header always points at a complete tree: either the one from before the group or the
+// one after it. The pages that were copied are freed once the header has been written.
+//
+// Whoever writes pages that point at other pages has to point them at the copies, see
+// Resolve. A crash during a commit can leak the pages allocated in the group, but never
+// leaves the file inconsistent. Writes outside of a group overwrite pages in place.
+func (s *PageStore) EnableCOW() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.wal != nil {
+		return ErrWALAndCOW
+	}
+	// Copy-on-write mode writes pages itself, so the ones still waiting to be written back
+	// have to reach the file first.
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+	s.cow = true
+	s.fresh = map[PageID]bool{}
+	s.relocated = map[PageID]PageID{}
+	s.snapshots = map[uint64]int{}
+	return nil
+}
+
+// COW returns whether copy-on-write mode is on, see EnableCOW.
+func (s *PageStore) COW() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cow
+}
+
+// Resolve returns the page that pageID has been copied to in the current group, or
+// pageID if it hasn't been copied.
+func (s *PageStore) Resolve(pageID PageID) PageID {
+	s.Lock()
+	defer s.Unlock()
+	return s.resolve(pageID)
+}
+
+func (s *PageStore) resolve(pageID PageID) PageID {
+	if relocated, ok := s.relocated[pageID]; ok {
+		return relocated
+	}
+	return pageID
+}
+
+// relocate moves a loaded page to a fresh page, unless it's already fresh, and returns
+// the page it now lives in. The cache slot holding the page is moved along with it so
+// that anyone holding the page sees its new id.
+func (s *PageStore) relocate(pageID PageID) (PageID, error) {
+	s.Lock()
+	pageID = s.resolve(pageID)
+	fresh := s.fresh[pageID]
+	s.Unlock()
+	if fresh {
+		return pageID, nil
+	}
+	newID, err := s.Allocate()
+	if err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	cacheID, pageInCache := s.pinned(pageID)
+	if !pageInCache {
+		return 0, ErrPageNotLoaded
+	}
+	err = s.moveSlot(cacheID, pageID, newID)
+	if err != nil {
+		return 0, err
+	}
+	s.relocated[pageID] = newID
+	s.deferredFrees = append(s.deferredFrees, pageID)
+	return newID, nil
+}
+
+// commitCOW commits a group of writes in copy-on-write mode.
+func (s *PageStore) commitCOW() error {
+	s.header.toBuffer()
+	unchanged := s.header.Buf == s.headerAtBegin
+	unchanged = unchanged && (s.freeSpace == nil || len(s.freeSpace.changed) == 0)
+	if len(s.pendingOrder) == 0 && len(s.deferredFrees) == 0 && unchanged {
+		return nil
+	}
+	// Pages taken off the free list or out of holes are about to be overwritten, so first
+	// record that they're no longer free, leaving the rest of the committed header as it
+	// was. A crash after this leaks them rather than leaving them on the free list.
+	committed := s.headerAtBegin
+	for _, r := range s.header.allocation() {
+		copy(committed[r[0]:r[1]], s.header.Buf[r[0]:r[1]])
+	}
+	err := s.writePage(s.header.ID, &committed)
+	if err != nil {
+		return err
+	}
+	// Only allocations change the free-space map in a group, since frees are deferred, so
+	// it's written at the same time.
+	err = s.writeMapPages()
+	if err != nil {
+		return err
+	}
+	err = s.sync()
+	if err != nil {
+		return err
+	}
+	for _, pageID := range s.pendingOrder {
+		err = s.writePage(pageID, s.pending[pageID])
+		if err != nil {
+			return err
+		}
+	}
+	err = s.sync()
+	if err != nil {
+		return err
+	}
+	// Swapping in the new header commits the group.
+	err = s.syncHeader()
+	if err != nil {
+		return err
+	}
+	s.version++
+	if len(s.snapshots) > 0 && len(s.deferredFrees) > 0 {
+		// Open snapshots may still read the pages this group replaced.
+		pages := append([]PageID{}, s.deferredFrees...)
+		s.held = append(s.held, heldFrees{version: s.version, pages: pages})
+	} else {
+		err = s.freePages(s.deferredFrees)
+	}
+	s.pending = map[PageID]*[PageSize]byte{}
+	s.pendingOrder = s.pendingOrder[:0]
+	s.fresh = map[PageID]bool{}
+	s.relocated = map[PageID]PageID{}
+	s.deferredFrees = s.deferredFrees[:0]
+	if err != nil {
+		return err
+	}
+	// A snapshot may have been closed during the group.
+	return s.freeHeld()
+}
+
+// freePages puts pages that the last committed version of the file no longer uses on the
+// free list. Every page is written before the header is, so a crash part way through
+// leaks pages rather than corrupting the free list.
+func (s *PageStore) freePages(pages []PageID) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	if s.freeSpace != nil {
+		for _, pageID := range pages {
+			s.freeSpace.setFree(pageID, true)
+		}
+		err := s.writeMapPages()
+		if err != nil {
+			return err
+		}
+		return s.sync()
+	}
+	head := s.header.freeList
+	for _, pageID := range pages {
+		var buf [PageSize]byte
+		s.putFreeLink(buf[:], head)
+		err := s.writePage(pageID, &buf)
+		if err != nil {
+			return err
+		}
+		// The page may still be cached by someone who hasn't released it yet.
+		if cacheID, ok := s.shard(pageID).lookup[pageID]; ok {
+			s.cache[cacheID].Buf = buf
+		}
+		head = uint64(pageID) * PageSize
+	}
+	err := s.sync()
+	if err != nil {
+		return err
+	}
+	s.header.freeList = head
+	s.header.toBuffer()
+	return s.syncHeader()
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCOWRelocatesWrittenPages(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "cow_relocates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != ErrWALAndCOW {
+		t.Fatalf("expected %v == %v", err, ErrWALAndCOW)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Buf = *pageFilledWith(1)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Begin()
+	page.Buf = *pageFilledWith(2)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	// The write moved the page, and neither page is in the file until the group is
+	// committed.
+	moved := store.Resolve(pageID)
+	if moved == pageID || page.ID != moved {
+		t.Fatalf("expected page %d to move, got %d", pageID, page.ID)
+	}
+	if fileSize(t, tmpfile.Name()) != int64(pageID+1)*PageSize {
+		t.Fatal("expected the moved page not to be written yet")
+	}
+	if err := store.SetRoot(moved, 4); err != nil {
+		t.Fatal(err)
+	}
+	if rootInFile(t, tmpfile.Name()) == moved {
+		t.Fatal("expected the root not to be written yet")
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Root() != moved {
+		t.Fatalf("expected %v == %v", reopened.Root(), moved)
+	}
+	page, err = reopened.Load(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(2)[:UsablePageSize])
+	// The page that was copied was freed once the group was committed.
+	reused, err := reopened.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused != pageID {
+		t.Fatalf("expected %v == %v", reused, pageID)
+	}
+}
+
+func TestCOWDefersFrees(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "cow_defers_frees")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Begin()
+	if err := store.Free(pageID); err != nil {
+		t.Fatal(err)
+	}
+	// The freed page may still be part of the committed file, so it isn't reused yet.
+	allocated, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated == pageID {
+		t.Fatal("expected the freed page not to be reused before the commit")
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	allocated, err = store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated != pageID {
+		t.Fatalf("expected %v == %v", allocated, pageID)
+	}
+}
+
+// rootInFile reads the root recorded in the header in the file, without opening it as a
+// page store while another store has it locked.
+func rootInFile(t *testing.T, filename string) PageID {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	header := headerPage{Page: &Page{}}
+	if _, err := file.ReadAt(header.Buf[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	header.fromBuffer()
+	return PageID(header.root)
+}
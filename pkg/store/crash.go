@@ -0,0 +1,202 @@
+package store
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// ErrCrashed is returned by everything done with a CrashBackend once it has crashed.
+var ErrCrashed = errors.New("backend crashed")
+
+// CrashBackend is a Backend kept in memory that simulates a crash part way through what's
+// done with it, so that what a store recovers after a crash can be tested. Writes are
+// buffered until the backend is synced, like an operating system buffers writes to a
+// file, and when the backend crashes each write that hadn't been synced yet is kept,
+// lost, or torn part way through, as the disk may have done with it when the power went.
+// Writes are torn along the boundaries of the blocks the disk writes atomically: with
+// blocks of SectorSize bytes a page can be left half written, while with blocks of
+// PageSize bytes only writes of several pages are torn, which is what a store assumes of
+// the disk unless it has a double-write buffer. What's kept is drawn from a random source
+// seeded with the seed the backend is created with, so the same seed crashes the same
+// sequence of calls the same way.
+//
+// A backend can have other files on the same disk, see NewFile, which share its writes
+// and crash along with it, such as a write-ahead log kept alongside the store, see
+// WithWALBackend.
+type CrashBackend struct {
+	disk *crashDisk
+	// durable holds what has been synced, and current what's been written so far.
+	durable  *MemoryBackend
+	current  *MemoryBackend
+	unsynced []crashWrite
+}
+
+// crashDisk is the disk the files of a CrashBackend are kept on.
+type crashDisk struct {
+	lock    sync.Mutex
+	rand    *rand.Rand
+	atomic  int
+	writes  int
+	crashAt int
+	crashed bool
+}
+
+// crashWrite is a write or truncation that hasn't been synced yet.
+type crashWrite struct {
+	buf      []byte
+	off      int64
+	truncate bool
+}
+
+// NewCrashBackend returns an empty CrashBackend that crashes the way seed draws, tearing
+// writes along blocks of atomic bytes.
+func NewCrashBackend(seed int64, atomic int) *CrashBackend {
+	disk := &crashDisk{
+		rand:   rand.New(rand.NewSource(seed)),
+		atomic: atomic,
+	}
+	return disk.newFile()
+}
+
+// NewFile returns an empty file on the same disk as the backend, whose writes count
+// towards the backend's, and which crashes along with it.
+func (b *CrashBackend) NewFile() *CrashBackend {
+	return b.disk.newFile()
+}
+
+func (d *crashDisk) newFile() *CrashBackend {
+	return &CrashBackend{
+		disk:    d,
+		durable: NewMemoryBackend(),
+		current: NewMemoryBackend(),
+	}
+}
+
+// CrashAt arms the backend to crash at its write'th write from now, which is torn and
+// fails along with everything done with the backend after it. Zero disarms it.
+func (b *CrashBackend) CrashAt(write int) {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	b.disk.crashAt = 0
+	if write > 0 {
+		b.disk.crashAt = b.disk.writes + write
+	}
+}
+
+// Writes returns the number of writes made to the backend, and the other files on its
+// disk, so far.
+func (b *CrashBackend) Writes() int {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	return b.disk.writes
+}
+
+// Crashed returns whether the backend has crashed.
+func (b *CrashBackend) Crashed() bool {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	return b.disk.crashed
+}
+
+// Crash crashes the backend, if it hasn't crashed already, and returns what was left of
+// it on the disk: everything synced before the crash, along with what was kept of each
+// write since. A store can be opened on it to see what's recovered. The other files on
+// the disk crash too, and what was left of each of them is returned by its own Crash.
+func (b *CrashBackend) Crash() *MemoryBackend {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	b.disk.crashed = true
+	left := &MemoryBackend{buf: append([]byte(nil), b.durable.buf...)}
+	for _, w := range b.unsynced {
+		switch {
+		case b.disk.rand.Intn(3) == 0:
+			// The write never made it to the disk.
+		case w.truncate:
+			left.Truncate(w.off)
+		default:
+			buf := w.buf
+			if b.disk.rand.Intn(2) == 0 {
+				buf = buf[:b.tear(len(buf))]
+			}
+			left.WriteAt(buf, w.off)
+		}
+	}
+	return left
+}
+
+// ReadAt reads what has been written at off so far, synced or not.
+func (b *CrashBackend) ReadAt(p []byte, off int64) (int, error) {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	if b.disk.crashed {
+		return 0, ErrCrashed
+	}
+	return b.current.ReadAt(p, off)
+}
+
+// WriteAt buffers a write until the backend is synced, or crashes the backend if it's
+// the write it's armed to crash at, keeping only part of it.
+func (b *CrashBackend) WriteAt(p []byte, off int64) (int, error) {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	if b.disk.crashed {
+		return 0, ErrCrashed
+	}
+	b.disk.writes++
+	if b.disk.writes == b.disk.crashAt {
+		b.disk.crashed = true
+		p = p[:b.tear(len(p))]
+	}
+	b.unsynced = append(b.unsynced, crashWrite{buf: append([]byte(nil), p...), off: off})
+	if b.disk.crashed {
+		return len(p), ErrCrashed
+	}
+	return b.current.WriteAt(p, off)
+}
+
+// Sync makes the writes so far durable, so that they survive a crash.
+func (b *CrashBackend) Sync() error {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	if b.disk.crashed {
+		return ErrCrashed
+	}
+	b.durable.buf = append(b.durable.buf[:0], b.current.buf...)
+	b.unsynced = nil
+	return nil
+}
+
+// Truncate cuts the backend off, or extends it with zeros, to size bytes, which like a
+// write only survives a crash once it's synced.
+func (b *CrashBackend) Truncate(size int64) error {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	if b.disk.crashed {
+		return ErrCrashed
+	}
+	b.unsynced = append(b.unsynced, crashWrite{off: size, truncate: true})
+	return b.current.Truncate(size)
+}
+
+// Size returns the number of bytes written to the backend so far, synced or not.
+func (b *CrashBackend) Size() int64 {
+	b.disk.lock.Lock()
+	defer b.disk.lock.Unlock()
+	return b.current.Size()
+}
+
+// Close does nothing, leaving the backend to be crashed.
+func (b *CrashBackend) Close() error {
+	return nil
+}
+
+// tear draws how many bytes of a write of size bytes are kept when it's torn, a whole
+// number of atomic blocks less than size.
+func (b *CrashBackend) tear(size int) int {
+	blocks := (size + b.disk.atomic - 1) / b.disk.atomic
+	if blocks == 0 {
+		return 0
+	}
+	return b.disk.rand.Intn(blocks) * b.disk.atomic
+}
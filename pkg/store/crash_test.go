@@ -0,0 +1,151 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCrashBackendKeepsSyncedWrites(t *testing.T) {
+	backend := NewCrashBackend(1, SectorSize)
+	synced := pageFilledWith(1)
+	if _, err := backend.WriteAt(synced[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	unsynced := pageFilledWith(2)
+	if _, err := backend.WriteAt(unsynced[:], PageSize); err != nil {
+		t.Fatal(err)
+	}
+	// Reads see every write until the crash.
+	var buf [PageSize]byte
+	if _, err := backend.ReadAt(buf[:], PageSize); err != nil || buf != *unsynced {
+		t.Fatalf("expected the unsynced write to be read back, got %v", err)
+	}
+
+	disk := backend.Crash()
+	if _, err := disk.ReadAt(buf[:], 0); err != nil || buf != *synced {
+		t.Fatalf("expected the synced write to survive, got %v", err)
+	}
+	if _, err := backend.ReadAt(buf[:], 0); err != ErrCrashed {
+		t.Fatalf("expected %v == %v", err, ErrCrashed)
+	}
+	if err := backend.Sync(); err != ErrCrashed {
+		t.Fatalf("expected %v == %v", err, ErrCrashed)
+	}
+}
+
+func TestCrashBackendCrashAt(t *testing.T) {
+	backend := NewCrashBackend(1, SectorSize)
+	page := pageFilledWith(3)
+	if _, err := backend.WriteAt(page[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	backend.CrashAt(2)
+	if _, err := backend.WriteAt(page[:], PageSize); err != nil {
+		t.Fatal(err)
+	}
+	n, err := backend.WriteAt(page[:], 2*PageSize)
+	if err != ErrCrashed || n%SectorSize != 0 || n >= PageSize {
+		t.Fatalf("expected a torn write of whole sectors, wrote %d with %v", n, err)
+	}
+	if !backend.Crashed() || backend.Writes() != 3 {
+		t.Fatalf("expected a crash at the third write, made %d", backend.Writes())
+	}
+	if _, err := backend.WriteAt(page[:], 0); err != ErrCrashed {
+		t.Fatalf("expected %v == %v", err, ErrCrashed)
+	}
+}
+
+func TestCrashBackendNewFile(t *testing.T) {
+	backend := NewCrashBackend(1, SectorSize)
+	file := backend.NewFile()
+	page := pageFilledWith(4)
+	if _, err := file.WriteAt(page[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// Writes to either file count towards the crash.
+	backend.CrashAt(2)
+	if _, err := backend.WriteAt(page[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(page[:], PageSize); err != ErrCrashed {
+		t.Fatalf("expected %v == %v", err, ErrCrashed)
+	}
+	if !backend.Crashed() || backend.Writes() != 3 || file.Writes() != 3 {
+		t.Fatalf("expected a crash at the third write, made %d", backend.Writes())
+	}
+	if _, err := backend.ReadAt(page[:], 0); err != ErrCrashed {
+		t.Fatalf("expected %v == %v", err, ErrCrashed)
+	}
+	// Each file keeps what was synced to it.
+	disk := file.Crash()
+	var buf [PageSize]byte
+	if _, err := disk.ReadAt(buf[:], 0); err != nil || buf != *page {
+		t.Fatalf("expected the synced write to survive, got %v", err)
+	}
+	if size := backend.Crash().Size(); size > PageSize {
+		t.Fatalf("expected at most a page, got %d", size)
+	}
+}
+
+// TestCrashBackendTearsPages crashes with many seeds and checks that unsynced pages are
+// kept, lost and torn part way through, always along sectors.
+func TestCrashBackendTearsPages(t *testing.T) {
+	var kept, lost, torn int
+	for seed := int64(0); seed < 50; seed++ {
+		backend := NewCrashBackend(seed, SectorSize)
+		page := pageFilledWith(4)
+		if _, err := backend.WriteAt(page[:], 0); err != nil {
+			t.Fatal(err)
+		}
+		disk := backend.Crash()
+		var buf [PageSize]byte
+		n, _ := disk.ReadAt(buf[:], 0)
+		switch {
+		case n == PageSize:
+			kept++
+		case n == 0:
+			lost++
+		default:
+			torn++
+			if n%SectorSize != 0 || !bytes.Equal(buf[:n], page[:n]) {
+				t.Fatalf("expected a page torn along sectors, kept %d bytes", n)
+			}
+		}
+	}
+	if kept == 0 || lost == 0 || torn == 0 {
+		t.Fatalf("expected pages to be kept, lost and torn, got %d, %d, %d", kept, lost, torn)
+	}
+
+	// The same seed crashes the same way.
+	crash := func() []byte {
+		backend := NewCrashBackend(7, SectorSize)
+		for i := 0; i < 10; i++ {
+			backend.WriteAt(pageFilledWith(byte(i))[:], int64(i)*PageSize)
+		}
+		return backend.Crash().buf
+	}
+	if !bytes.Equal(crash(), crash()) {
+		t.Fatalf("expected the same seed to leave the same disk")
+	}
+}
+
+func TestCrashBackendPageAtomic(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		backend := NewCrashBackend(seed, PageSize)
+		pages := bytes.Repeat(pageFilledWith(5)[:], 4)
+		backend.CrashAt(1)
+		n, err := backend.WriteAt(pages, 0)
+		if err != ErrCrashed || n%PageSize != 0 {
+			t.Fatalf("expected a write torn between pages, wrote %d with %v", n, err)
+		}
+		if size := backend.Crash().Size(); size%PageSize != 0 {
+			t.Fatalf("expected whole pages to be left, got %d bytes", size)
+		}
+	}
+}
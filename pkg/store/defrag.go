@@ -0,0 +1,117 @@
+package store
+
+import "sort"
+
+// SortFreeList links the free list back together in order of page id, so that Allocate
+// and Move hand out the free pages nearest the front of the file first. Like Shrink, it
+// can't be called between Begin and Commit. Files with a free-space map always hand out
+// the first free page, so there's nothing to sort.
+func (s *PageStore) SortFreeList() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.depth > 0 {
+		return ErrShrinkInGroup
+	}
+	if s.freeSpace != nil {
+		return nil
+	}
+	// The links written by Free have to reach the file before the list is read from it.
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+	free, err := s.freePageIDs()
+	if err != nil {
+		return err
+	}
+	less := func(i, j int) bool { return free[i] < free[j] }
+	if sort.SliceIsSorted(free, less) {
+		return nil
+	}
+	sort.Slice(free, less)
+	head, err := s.linkFreePages(free)
+	if err != nil {
+		return err
+	}
+	err = s.sync()
+	if err != nil {
+		return err
+	}
+	s.header.freeList = head
+	s.header.toBuffer()
+	return s.syncHeader()
+}
+
+// Move copies a page to the first page on the free list, or the first free page in files
+// with a free-space map, if that comes before it in the file, and returns the page it was
+// copied to. Otherwise the page is left where it is and
+// its own id is returned. The caller points whatever refers to the page at the copy, then
+// frees the page.
+//
+// Once the free list has been sorted with SortFreeList, moving the pages at the end of
+// the file one at a time packs them toward the front of it, leaving free pages at the
+// end for Shrink to cut off. Pages freed while moving go on the front of the list, so
+// they should be freed once the moving is done.
+func (s *PageStore) Move(pageID PageID) (PageID, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.headerLock.Lock()
+	var newID PageID
+	var err error
+	if s.freeSpace != nil {
+		first, ok := s.freeSpace.firstFree()
+		if !ok || first >= pageID {
+			s.headerLock.Unlock()
+			return pageID, nil
+		}
+		newID, err = s.allocateFromMap()
+		if err == nil {
+			err = s.writeFreeSpace()
+		}
+	} else {
+		first := PageID(s.header.freeList / PageSize)
+		if s.header.freeList == 0 || first >= pageID {
+			s.headerLock.Unlock()
+			return pageID, nil
+		}
+		newID, err = s.allocateFromFreeList()
+	}
+	if err == nil && s.cow && s.grouped() {
+		s.Lock()
+		s.fresh[newID] = true
+		s.Unlock()
+	}
+	s.headerLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	page, err := s.Load(pageID)
+	if err != nil {
+		return 0, err
+	}
+	copied, err := s.Load(newID)
+	if err != nil {
+		page.Release()
+		return 0, err
+	}
+	copied.Buf = page.Buf
+	err = s.Write(newID)
+	releaseErr := page.Release()
+	if err == nil {
+		err = releaseErr
+	}
+	releaseErr = copied.Release()
+	if err == nil {
+		err = releaseErr
+	}
+	return newID, err
+}
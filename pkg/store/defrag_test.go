@@ -0,0 +1,107 @@
+package store
+
+import "testing"
+
+func TestSortFreeList(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(64), WithFormatVersion(FormatVersion2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var pages []PageID
+	for i := 0; i < 10; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	// Freeing pages in order leaves the last one freed at the front of the list.
+	for _, pageID := range pages[2:8] {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Begin()
+	if err := store.SortFreeList(); err != ErrShrinkInGroup {
+		t.Fatalf("expected %v == %v", err, ErrShrinkInGroup)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SortFreeList(); err != nil {
+		t.Fatal(err)
+	}
+	store.Lock()
+	free, err := store.freePageIDs()
+	store.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pageID := range free {
+		if pageID != pages[2+i] {
+			t.Fatalf("expected %v == %v", pageID, pages[2+i])
+		}
+	}
+	for _, expected := range pages[2:8] {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pageID != expected {
+			t.Fatalf("expected %v == %v", pageID, expected)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var pages []PageID
+	for i := 0; i < 10; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writePages(t, store, []PageID{pageID}, byte(i))
+	}
+	if err := store.Free(pages[3]); err != nil {
+		t.Fatal(err)
+	}
+	// A page before the first free page stays where it is.
+	pageID, err := store.Move(pages[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageID != pages[1] {
+		t.Fatalf("expected %v == %v", pageID, pages[1])
+	}
+	pageID, err = store.Move(pages[9])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageID != pages[3] {
+		t.Fatalf("expected %v == %v", pageID, pages[3])
+	}
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(9)[:UsablePageSize])
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// With no free pages left, nothing moves.
+	pageID, err = store.Move(pages[8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageID != pages[8] {
+		t.Fatalf("expected %v == %v", pageID, pages[8])
+	}
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+const (
+	// doubleWriteRecordSize is the size of the record kept in the double-write buffer: the
+	// page id, the contents of the page and a checksum.
+	doubleWriteRecordSize = 8 + PageSize + 4
+	// doubleWriteRecord32Size is the size of the record from before page ids were widened
+	// to 64 bits, which is still restored from.
+	doubleWriteRecord32Size = 4 + PageSize + 4
+)
+
+// doubleWriteBuffer holds a copy of the page being written to the page store's file. A
+// power failure part way through writing a page can leave it half old and half new, and
+// the copy is what the page is restored from when the file is next opened.
+type doubleWriteBuffer struct {
+	file *os.File
+}
+
+func openDoubleWriteBuffer(filename string) (*doubleWriteBuffer, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	return &doubleWriteBuffer{file: file}, nil
+}
+
+// doubleWriteFilename returns the name of the double-write buffer kept alongside a page
+// store's file.
+func doubleWriteFilename(filename string) string {
+	return filename + ".dwb"
+}
+
+// save replaces the copy in the buffer with the given page and syncs it to disk.
+func (d *doubleWriteBuffer) save(pageID PageID, buf *[PageSize]byte) error {
+	record := make([]byte, doubleWriteRecordSize)
+	binary.LittleEndian.PutUint64(record[0:8], uint64(pageID))
+	copy(record[8:8+PageSize], buf[:])
+	checksum(record)
+	_, err := d.file.WriteAt(record, 0)
+	if err != nil {
+		return err
+	}
+	return d.file.Sync()
+}
+
+// load returns the copy in the buffer, or false if there isn't a complete one.
+func (d *doubleWriteBuffer) load() (PageID, *[PageSize]byte, bool, error) {
+	record := make([]byte, doubleWriteRecordSize)
+	n, err := d.file.ReadAt(record, 0)
+	if err != nil && err != io.EOF {
+		return 0, nil, false, err
+	}
+	var buf [PageSize]byte
+	if n == doubleWriteRecordSize && validChecksum(record) {
+		copy(buf[:], record[8:8+PageSize])
+		return PageID(binary.LittleEndian.Uint64(record[0:8])), &buf, true, nil
+	}
+	// A buffer written before page ids were widened holds a shorter record.
+	record = record[:doubleWriteRecord32Size]
+	if n < doubleWriteRecord32Size || !validChecksum(record) {
+		// The copy itself was torn, so the page it was for was never touched.
+		return 0, nil, false, nil
+	}
+	copy(buf[:], record[4:4+PageSize])
+	return PageID(binary.LittleEndian.Uint32(record[0:4])), &buf, true, nil
+}
+
+func (d *doubleWriteBuffer) Close() error {
+	return d.file.Close()
+}
+
+// EnableDoubleWrite protects the file from torn writes: before a page is written to the
+// file, a copy of it is written to a buffer kept alongside the file and synced, and the
+// page is synced as soon as it's written. If the power fails part way through writing the
+// page, the page no longer matches its checksum, and it's restored from the copy when the
+// file is next opened. This costs an extra write and two syncs for every page written.
+func (s *PageStore) EnableDoubleWrite() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.doubleWrite != nil {
+		return nil
+	}
+	if s.filename == "" {
+		return ErrNoFile
+	}
+	doubleWrite, err := openDoubleWriteBuffer(doubleWriteFilename(s.filename))
+	if err != nil {
+		return err
+	}
+	s.doubleWrite = doubleWrite
+	return nil
+}
+
+// restoreTornPage restores the page copied to the double-write buffer if the write to
+// the file that followed was torn.
+func (s *PageStore) restoreTornPage() error {
+	_, err := os.Stat(doubleWriteFilename(s.filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	doubleWrite, err := openDoubleWriteBuffer(doubleWriteFilename(s.filename))
+	if err != nil {
+		return err
+	}
+	defer doubleWrite.Close()
+	pageID, buf, ok, err := doubleWrite.load()
+	if err != nil || !ok {
+		return err
+	}
+	var current [PageSize]byte
+	n, err := s.backend.ReadAt(current[:], pageOffset(pageID))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	// Nothing was written if the page is past the end of the file.
+	if n == 0 || n == PageSize && validPageChecksum(&current) {
+		return nil
+	}
+	// The copy is written as it is, since it was saved once it had been encrypted.
+	s.writeLock.Lock()
+	err = s.writeImage(pageID, buf)
+	s.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	s.recovery.TornPageRestored = true
+	s.logger.Warn("restored torn page from the double-write buffer", "page", pageID)
+	return s.sync()
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDoubleWriteRestoresTornPage(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "double_write_torn_page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableDoubleWrite(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Buf = *pageFilledWith(1)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// Syncing the write as it's made, rather than with Sync, leaves it as the last one in
+	// the buffer, where Sync would follow it with the header.
+	if err := store.SetDurability(SyncEveryCommit, 0); err != nil {
+		t.Fatal(err)
+	}
+	page.Buf = *pageFilledWith(2)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A page that was written in full is left alone.
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Recovery().TornPageRestored {
+		t.Fatal("expected no torn page to be restored")
+	}
+
+	// Put the old contents back in the second half of the page, as if the power failed
+	// half way through the last write.
+	file, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := pageFilledWith(1)
+	offset := int64(pageID)*PageSize + PageSize/2
+	if _, err := file.WriteAt(old[PageSize/2:], offset); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if err := reopened.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Recovery().TornPageRestored {
+		t.Fatal("expected the torn page to be restored")
+	}
+	page, err = reopened.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(2)[:UsablePageSize])
+}
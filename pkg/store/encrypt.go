@@ -0,0 +1,412 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrEncrypted is returned when opening an encrypted file without WithEncryption.
+	ErrEncrypted = errors.New("file is encrypted")
+	// ErrNotEncrypted is returned when opening a file that wasn't created with
+	// WithEncryption with it, or re-encrypting one.
+	ErrNotEncrypted = errors.New("file isn't encrypted")
+	// ErrWrongKey is returned when opening an encrypted file with keys it wasn't written
+	// with.
+	ErrWrongKey = errors.New("wrong encryption key")
+	// ErrUnknownKey is returned by Keys for a key ID it doesn't hold.
+	ErrUnknownKey = errors.New("no key with that ID")
+	// ErrPageNotAuthentic is returned when an encrypted page matches its checksum but
+	// fails to decrypt, which means it has been tampered with or moved from elsewhere.
+	ErrPageNotAuthentic = errors.New("encrypted page failed to authenticate")
+	// ErrReencryptInGroup is returned when re-encrypting the file between Begin and
+	// Commit.
+	ErrReencryptInGroup = errors.New("can't re-encrypt the file while a group is open")
+)
+
+const (
+	// keyIDSize, nonceSize and tagSize are the sizes of the fields encrypted pages keep
+	// at the end of their contents: the ID of the key the page is encrypted with, and
+	// the nonce and tag of AES-GCM.
+	keyIDSize = 4
+	nonceSize = 12
+	tagSize   = 16
+	// encryptionReserve is the number of bytes encrypted files keep at the end of every
+	// page's contents, see ContentSize.
+	encryptionReserve = keyIDSize + nonceSize + tagSize
+	// keyCheckSize is the size of the value sealed in the header of encrypted files,
+	// which is a nonce followed by a block of zeros and its tag.
+	keyCheckSize = nonceSize + aes.BlockSize + tagSize
+)
+
+// KeyProvider supplies the keys an encrypted store's pages are encrypted with, see
+// WithEncryption. Keys are AES keys of 16, 24 or 32 bytes, and every page records the ID
+// of the key it's encrypted with, so that a file can be moved to a new key while it's in
+// use, see Reencrypt.
+type KeyProvider interface {
+	// CurrentKey returns the key pages are encrypted with as they're written, and its ID.
+	CurrentKey() (uint32, []byte, error)
+	// Key returns the key with the given ID, which pages written before the current key
+	// was rotated in may still be encrypted with.
+	Key(id uint32) ([]byte, error)
+}
+
+// Keys is a KeyProvider that holds every key by its ID, where the key with the highest ID
+// is the current key. Keys are rotated by adding a key with a higher ID and calling
+// Reencrypt, after which the older keys are no longer needed.
+type Keys map[uint32][]byte
+
+// CurrentKey returns the key with the highest ID.
+func (k Keys) CurrentKey() (uint32, []byte, error) {
+	if len(k) == 0 {
+		return 0, nil, ErrUnknownKey
+	}
+	var current uint32
+	for id := range k {
+		current = max(current, id)
+	}
+	return current, k[current], nil
+}
+
+// Key returns the key with the given ID.
+func (k Keys) Key(id uint32) ([]byte, error) {
+	key, ok := k[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// StaticKey returns a KeyProvider with a single key.
+func StaticKey(key []byte) KeyProvider {
+	return Keys{1: key}
+}
+
+// ContentSize returns the number of bytes at the start of every page that are free for
+// its contents, which is UsablePageSize less the space encrypted files keep at the end of
+// every page for its nonce and tag.
+func (s *PageStore) ContentSize() int {
+	return UsablePageSize - int(s.header.reserve)
+}
+
+// Encrypted returns whether the store's pages are encrypted, see WithEncryption.
+func (s *PageStore) Encrypted() bool {
+	return s.keys != nil
+}
+
+// newEncryption sets up a file that's being created to be encrypted with keys.
+func (s *PageStore) newEncryption(keys KeyProvider) error {
+	s.keys = keys
+	s.aeads = map[uint32]cipher.AEAD{}
+	id, aead, err := s.loadCurrentKey()
+	if err != nil {
+		return err
+	}
+	s.currentKey = id
+	s.header.reserve = encryptionReserve
+	return s.sealKeyCheck(id, aead)
+}
+
+// openEncryption checks that an existing file is opened with keys if and only if it's
+// encrypted, and that they're the keys it was written with.
+func (s *PageStore) openEncryption(keys KeyProvider) error {
+	if s.keys != nil {
+		// The file was encrypted when it was created.
+		return nil
+	}
+	if s.header.reserve == 0 {
+		if keys != nil {
+			return ErrNotEncrypted
+		}
+		return nil
+	}
+	if keys == nil {
+		return ErrEncrypted
+	}
+	s.keys = keys
+	s.aeads = map[uint32]cipher.AEAD{}
+	err := s.openKeyCheck()
+	if err == nil {
+		s.currentKey, _, err = s.loadCurrentKey()
+	}
+	if err != nil {
+		s.keys = nil
+		return err
+	}
+	return nil
+}
+
+// loadCurrentKey returns the ID of the key provider's current key and its cipher.
+func (s *PageStore) loadCurrentKey() (uint32, cipher.AEAD, error) {
+	id, key, err := s.keys.CurrentKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	s.aeadsLock.Lock()
+	s.aeads[id] = aead
+	s.aeadsLock.Unlock()
+	return id, aead, nil
+}
+
+// aead returns the cipher for the key with the given ID.
+func (s *PageStore) aead(id uint32) (cipher.AEAD, error) {
+	s.aeadsLock.Lock()
+	defer s.aeadsLock.Unlock()
+	if aead, ok := s.aeads[id]; ok {
+		return aead, nil
+	}
+	key, err := s.keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[id] = aead
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealKeyCheck seals a block of zeros in the header with a key, which is opened when the
+// file is opened again to tell whether it has been given the right keys.
+func (s *PageStore) sealKeyCheck(id uint32, aead cipher.AEAD) error {
+	var check [keyCheckSize]byte
+	nonce := check[:nonceSize]
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+	var zeros [aes.BlockSize]byte
+	aead.Seal(check[nonceSize:nonceSize], nonce, zeros[:], nil)
+	s.header.keyID = id
+	s.header.keyCheck = check
+	return nil
+}
+
+// openKeyCheck returns ErrWrongKey unless the value sealed in the header opens with the
+// key it was sealed with.
+func (s *PageStore) openKeyCheck() error {
+	aead, err := s.aead(s.header.keyID)
+	if err == ErrUnknownKey {
+		return ErrWrongKey
+	}
+	if err != nil {
+		return err
+	}
+	check := s.header.keyCheck
+	_, err = aead.Open(nil, check[:nonceSize], check[nonceSize:], nil)
+	if err != nil {
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// encodePage checksums a page and returns it as it's written to the file. The pages of
+// encrypted files are encrypted into a buffer of their own, which leaves buf holding the
+// page as it's cached. The header is never encrypted, because the key check is recorded
+// in it.
+func (s *PageStore) encodePage(
+	pageID PageID,
+	buf *[PageSize]byte,
+) (*[PageSize]byte, error) {
+	setPageChecksum(buf)
+	if s.keys == nil || s.isHeader(pageID) {
+		return buf, nil
+	}
+	page := *buf
+	err := s.encrypt(pageID, &page)
+	if err != nil {
+		return nil, err
+	}
+	setPageChecksum(&page)
+	return &page, nil
+}
+
+// decodePage decrypts a page read from the file in place, and checksums it so that it's
+// cached as it was before it was written. Pages that have never been written are left
+// as zeros.
+func (s *PageStore) decodePage(pageID PageID, buf *[PageSize]byte) error {
+	if s.keys == nil || s.isHeader(pageID) || *buf == [PageSize]byte{} {
+		return nil
+	}
+	err := s.decrypt(pageID, buf)
+	if err != nil {
+		return err
+	}
+	setPageChecksum(buf)
+	return nil
+}
+
+// encrypt encrypts a page's contents in place with the current key, and fills in the
+// fields at the end of them. The page's ID is authenticated along with its contents, so
+// that pages can't be swapped around in the file. The write lock must be held.
+func (s *PageStore) encrypt(pageID PageID, page *[PageSize]byte) error {
+	aead, err := s.aead(s.currentKey)
+	if err != nil {
+		return err
+	}
+	size := s.ContentSize()
+	trailer := page[size:UsablePageSize]
+	binary.LittleEndian.PutUint32(trailer[:keyIDSize], s.currentKey)
+	nonce := trailer[keyIDSize : keyIDSize+nonceSize]
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+	sealed := aead.Seal(
+		make([]byte, 0, size+tagSize),
+		nonce,
+		page[:size],
+		additionalData(pageID, s.currentKey),
+	)
+	copy(page[:size], sealed[:size])
+	copy(trailer[keyIDSize+nonceSize:], sealed[size:])
+	return nil
+}
+
+// decrypt decrypts a page's contents in place, and clears the fields at the end of them.
+func (s *PageStore) decrypt(pageID PageID, buf *[PageSize]byte) error {
+	size := s.ContentSize()
+	trailer := buf[size:UsablePageSize]
+	id := binary.LittleEndian.Uint32(trailer[:keyIDSize])
+	aead, err := s.aead(id)
+	if err != nil {
+		return err
+	}
+	sealed := make([]byte, 0, size+tagSize)
+	sealed = append(sealed, buf[:size]...)
+	sealed = append(sealed, trailer[keyIDSize+nonceSize:]...)
+	page, err := aead.Open(
+		sealed[:0],
+		trailer[keyIDSize:keyIDSize+nonceSize],
+		sealed,
+		additionalData(pageID, id),
+	)
+	if err != nil {
+		return ErrPageNotAuthentic
+	}
+	copy(buf[:size], page)
+	clear(trailer)
+	return nil
+}
+
+// additionalData returns what's authenticated along with a page's contents. Its last
+// byte used to record how the page was compressed, and is always zero now that pages
+// aren't, so that pages encrypted before then still open.
+func additionalData(pageID PageID, keyID uint32) []byte {
+	var data [13]byte
+	binary.LittleEndian.PutUint64(data[0:8], uint64(pageID))
+	binary.LittleEndian.PutUint32(data[8:12], keyID)
+	return data[:]
+}
+
+// pageKeyID returns the ID of the key a page read from an encrypted file is encrypted
+// with.
+func (s *PageStore) pageKeyID(buf *[PageSize]byte) uint32 {
+	size := s.ContentSize()
+	return binary.LittleEndian.Uint32(buf[size : size+keyIDSize])
+}
+
+// Reencrypt rewrites every page of an encrypted file that isn't encrypted with the
+// current key, after asking the key provider for it again, so that a key can be
+// rotated in while the file is open and the keys it replaces retired once Reencrypt
+// returns. Pages written from then on are encrypted with the new key too. Every page
+// records which key it's encrypted with, so a pass that's cut short, say by a crash,
+// only has to be run again. Reencrypt returns ErrReencryptInGroup between Begin and
+// Commit.
+func (s *PageStore) Reencrypt() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.keys == nil {
+		return ErrNotEncrypted
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.depth > 0 {
+		return ErrReencryptInGroup
+	}
+	s.writeLock.Lock()
+	id, aead, err := s.loadCurrentKey()
+	if err == nil {
+		s.currentKey = id
+	}
+	s.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	// Dirty pages are encrypted with the new key as they're written back.
+	err = s.flush()
+	if err != nil {
+		return err
+	}
+	err = s.rewritePages(func(buf *[PageSize]byte) bool {
+		return s.pageKeyID(buf) != id
+	})
+	if err != nil {
+		return err
+	}
+	// The pages have to be on disk before the header stops vouching for the old key.
+	err = s.sync()
+	if err != nil {
+		return err
+	}
+	err = s.sealKeyCheck(id, aead)
+	if err != nil {
+		return err
+	}
+	s.header.toBuffer()
+	return s.syncHeader()
+}
+
+// rewritePages reads every page of the file as it's laid out in it, and writes back the
+// ones that stale returns true for, which encodes them afresh. Pages that have never
+// been written and the header and its copy are left alone. The store's lock must be
+// held, and its dirty pages flushed.
+func (s *PageStore) rewritePages(stale func(buf *[PageSize]byte) bool) error {
+	for pageID := headerPages(s.header.version); uint64(pageID) < s.header.size; pageID++ {
+		var buf [PageSize]byte
+		n, err := s.backend.ReadAt(buf[:], pageOffset(pageID))
+		if err == io.EOF && n < PageSize {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return pageError("rewrite", pageID, err)
+		}
+		if buf == [PageSize]byte{} || !stale(&buf) {
+			continue
+		}
+		if !validPageChecksum(&buf) {
+			return pageError("rewrite", pageID, &ErrChecksumMismatch{PageID: pageID})
+		}
+		err = s.decodePage(pageID, &buf)
+		if err == nil {
+			err = s.writePage(pageID, &buf)
+		}
+		if err != nil {
+			return pageError("rewrite", pageID, err)
+		}
+	}
+	return nil
+}
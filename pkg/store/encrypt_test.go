@@ -0,0 +1,253 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+var (
+	firstKey  = bytes.Repeat([]byte{1}, 32)
+	secondKey = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestEncryption(t *testing.T) {
+	backend := NewMemoryBackend()
+	keys := WithEncryption(StaticKey(firstKey))
+	store, err := OpenBackend(backend, WithCacheSize(20), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.ContentSize() != UsablePageSize-encryptionReserve {
+		t.Fatalf("expected %v == %v", store.ContentSize(), UsablePageSize-encryptionReserve)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeContents(t, store, pageID, 7)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Neither the page nor the free-space map is left in the clear, but the header is.
+	plain := pageFilledWith(7)[:64]
+	if bytes.Contains(readBackendPage(t, backend, pageID)[:], plain) {
+		t.Fatalf("expected page %v to be encrypted", pageID)
+	}
+	if magic := readBackendPage(t, backend, 0)[:4]; !bytes.Equal(magic, []byte("EKAJ")) {
+		t.Fatalf("expected %v == %v", magic, []byte("EKAJ"))
+	}
+
+	for _, test := range []struct {
+		opts     []Option
+		expected error
+	}{
+		{nil, ErrEncrypted},
+		{[]Option{WithEncryption(StaticKey(secondKey))}, ErrWrongKey},
+		{[]Option{WithEncryption(Keys{2: firstKey})}, ErrWrongKey},
+	} {
+		if _, err := OpenBackend(backend, test.opts...); err != test.expected {
+			t.Fatalf("expected %v == %v", err, test.expected)
+		}
+	}
+	reopened, err := OpenBackend(backend, WithEncryption(StaticKey(firstKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if !reopened.Encrypted() {
+		t.Fatalf("expected the store to be encrypted")
+	}
+	expectContents(t, reopened, pageID, 7)
+}
+
+func TestEncryptionOfUnencryptedFile(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenBackend(backend, WithEncryption(StaticKey(firstKey)))
+	if err != ErrNotEncrypted {
+		t.Fatalf("expected %v == %v", err, ErrNotEncrypted)
+	}
+}
+
+func TestEncryptionWithWAL(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "encryption_with_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	keys := WithEncryption(StaticKey(firstKey))
+	store, err := NewPageStore(tmpfile.Name(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The page is logged encrypted, and written to the file as it was logged.
+	store.Begin()
+	writeContents(t, store, pageID, 4)
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf := readPageFromFile(t, tmpfile.Name(), pageID)
+	if bytes.Contains(buf, pageFilledWith(4)[:64]) {
+		t.Fatalf("expected page %v to be encrypted", pageID)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	expectContents(t, reopened, pageID, 4)
+}
+
+func TestReencrypt(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithEncryption(Keys{1: firstKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 3; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writeContents(t, store, pageID, byte(i))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := Keys{1: firstKey, 2: secondKey}
+	store, err = OpenBackend(backend, WithEncryption(rotated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Begin()
+	if err := store.Reencrypt(); err != ErrReencryptInGroup {
+		t.Fatalf("expected %v == %v", err, ErrReencryptInGroup)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reencrypt(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pageID := range pages {
+		if id := store.pageKeyID(readBackendPage(t, backend, pageID)); id != 2 {
+			t.Fatalf("expected %v == %v", id, 2)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old key is no longer needed once every page has been re-encrypted.
+	reopened, err := OpenBackend(backend, WithEncryption(Keys{2: secondKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for i, pageID := range pages {
+		expectContents(t, reopened, pageID, byte(i))
+	}
+}
+
+func TestReencryptUnencryptedFile(t *testing.T) {
+	store, err := OpenBackend(NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Reencrypt(); err != ErrNotEncrypted {
+		t.Fatalf("expected %v == %v", err, ErrNotEncrypted)
+	}
+}
+
+func TestEncryptedPagesCantBeSwapped(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithEncryption(StaticKey(firstKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 2; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writeContents(t, store, pageID, byte(i))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A page copied over another matches its checksum, but was sealed for its own place.
+	buf := readBackendPage(t, backend, pages[0])
+	if _, err := backend.WriteAt(buf[:], pageOffset(pages[1])); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenBackend(backend, WithEncryption(StaticKey(firstKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.Load(pages[1]); !errors.Is(err, ErrPageNotAuthentic) {
+		t.Fatalf("expected %v == %v", err, ErrPageNotAuthentic)
+	}
+}
+
+// writeContents fills the contents of a page with b, leaving the space the store keeps
+// at the end of them alone.
+func writeContents(t *testing.T, store *PageStore, pageID PageID, b byte) {
+	t.Helper()
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(page.Buf[:store.ContentSize()], pageFilledWith(b)[:])
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectContents(t *testing.T, store *PageStore, pageID PageID, b byte) {
+	t.Helper()
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Release()
+	size := store.ContentSize()
+	assertBufEqual(t, page.Buf[:size], pageFilledWith(b)[:size])
+}
+
+func readBackendPage(t *testing.T, backend Backend, pageID PageID) *[PageSize]byte {
+	t.Helper()
+	var buf [PageSize]byte
+	if _, err := backend.ReadAt(buf[:], pageOffset(pageID)); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
@@ -0,0 +1,36 @@
+package store
+
+import "fmt"
+
+// PageError records an error hit reading or writing a page of the file, along with the
+// page, where it is in the file and what was being done with it, such as
+// "load page 8123 at offset 0x1FAB000: checksum mismatch". The error it wraps can be
+// checked for with errors.Is and errors.As.
+type PageError struct {
+	// Op is what was being done with the page, such as "load" or "write".
+	Op     string
+	PageID PageID
+	// Offset is where the page starts in the file.
+	Offset int64
+	Err    error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("%s page %d at offset 0x%X: %v", e.Op, e.PageID, e.Offset, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+// pageError wraps err in a PageError, unless it's nil or already a PageError, in which
+// case it's returned as it is.
+func pageError(op string, pageID PageID, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*PageError); ok {
+		return err
+	}
+	return &PageError{Op: op, PageID: pageID, Offset: pageOffset(pageID), Err: err}
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPageErrorOnLoad(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 5)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.WriteAt([]byte{6}, pageOffset(pageID)+10); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = store.Load(pageID)
+	var pageErr *PageError
+	if !errors.As(err, &pageErr) {
+		t.Fatalf("expected a page error, got %v", err)
+	}
+	offset := pageOffset(pageID)
+	if pageErr.Op != "load" || pageErr.PageID != pageID || pageErr.Offset != offset {
+		t.Fatalf("expected a failed load of page %d, got %+v", pageID, *pageErr)
+	}
+	var mismatch *ErrChecksumMismatch
+	if !errors.As(err, &mismatch) || mismatch.PageID != pageID {
+		t.Fatalf("expected a checksum mismatch in page %d, got %v", pageID, err)
+	}
+}
+
+func TestPageErrorMessage(t *testing.T) {
+	err := pageError("load", 8123, &ErrChecksumMismatch{PageID: 8123})
+	expected := "load page 8123 at offset 0x1FBB000: checksum mismatch"
+	if err.Error() != expected {
+		t.Fatalf("expected %v == %v", err, expected)
+	}
+	// Errors that already say which page they're about aren't wrapped again.
+	if wrapped := pageError("write", 1, err); wrapped != err {
+		t.Fatalf("expected %v == %v", wrapped, err)
+	}
+	if pageError("load", 1, nil) != nil {
+		t.Fatalf("expected no error")
+	}
+}
+
+// failingBackend fails every write made to the backend it wraps once failing is set.
+type failingBackend struct {
+	*MemoryBackend
+	failing bool
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (b *failingBackend) WriteAt(p []byte, off int64) (int, error) {
+	if b.failing {
+		return 0, errWriteFailed
+	}
+	return b.MemoryBackend.WriteAt(p, off)
+}
+
+func TestPageErrorOnFlush(t *testing.T) {
+	backend := &failingBackend{MemoryBackend: NewMemoryBackend()}
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Abandon()
+	var pages []PageID
+	for i := 0; i < 3; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, pages, 1)
+	backend.failing = true
+	err = store.Sync()
+	if !errors.Is(err, errWriteFailed) {
+		t.Fatalf("expected %v == %v", err, errWriteFailed)
+	}
+	var pageErr *PageError
+	if !errors.As(err, &pageErr) || pageErr.Op != "write" || pageErr.PageID != pages[0] {
+		t.Fatalf("expected a failed write of page %d, got %v", pages[0], err)
+	}
+}
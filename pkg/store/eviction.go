@@ -0,0 +1,125 @@
+package store
+
+import (
+	"container/list"
+)
+
+// EvictionPolicy chooses which cached page to evict when a page has to be loaded and
+// every cache slot is in use. The page store tells the policy about every load and
+// release of a cached page, and only ever asks it to evict a slot whose page isn't
+// pinned. LRU, Clock, TwoQ and ARC are provided, and any other policy can be plugged in
+// with WithEvictionPolicy.
+//
+// Cache slots are numbered from zero up to the capacity of the whole cache. A large
+// cache is split into shards that each have a policy of their own, and a policy is only
+// called while its shard is locked, so it doesn't need a lock of its own.
+type EvictionPolicy interface {
+	// Loaded is called when a page is loaded into a cache slot, which pins it. hit is set
+	// if the page was already cached, and unset if it was just read into the slot.
+	Loaded(cacheID int, pageID PageID, hit bool)
+	// Released is called when a page has been released as many times as it was loaded,
+	// after which the slot can be evicted.
+	Released(cacheID int)
+	// Evict chooses a slot that can be evicted to make room for pageID and forgets about
+	// it, returning false if every slot is pinned.
+	Evict(pageID PageID) (int, bool)
+	// Remove forgets about a slot that the page store emptied itself.
+	Remove(cacheID int)
+}
+
+// slotList is a list of cache slots linked through the slot ids themselves, so that
+// moving a slot around never allocates. The front of the list is the oldest slot.
+type slotList struct {
+	prev []int
+	next []int
+	// in is set for the slots that are in the list.
+	in   []bool
+	size int
+	// The list is circular through a sentinel that sits after the last slot.
+	sentinel int
+}
+
+func newSlotList(capacity int) *slotList {
+	l := &slotList{
+		prev:     make([]int, capacity+1),
+		next:     make([]int, capacity+1),
+		in:       make([]bool, capacity+1),
+		sentinel: capacity,
+	}
+	l.prev[l.sentinel] = l.sentinel
+	l.next[l.sentinel] = l.sentinel
+	return l
+}
+
+// push adds a slot to the back of the list, moving it there if it's already in it.
+func (l *slotList) push(id int) {
+	l.remove(id)
+	last := l.prev[l.sentinel]
+	l.next[last] = id
+	l.prev[id] = last
+	l.next[id] = l.sentinel
+	l.prev[l.sentinel] = id
+	l.in[id] = true
+	l.size++
+}
+
+// remove takes a slot out of the list if it's in it.
+func (l *slotList) remove(id int) {
+	if !l.in[id] {
+		return
+	}
+	l.next[l.prev[id]] = l.next[id]
+	l.prev[l.next[id]] = l.prev[id]
+	l.in[id] = false
+	l.size--
+}
+
+// first returns the oldest slot in the list for which ok returns true, or false if
+// there isn't one.
+func (l *slotList) first(ok func(int) bool) (int, bool) {
+	for id := l.next[l.sentinel]; id != l.sentinel; id = l.next[id] {
+		if ok(id) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// ghostList remembers the ids of recently evicted pages, oldest first, so that a policy
+// can tell when a page comes back soon after it was evicted.
+type ghostList struct {
+	order    *list.List
+	elements map[PageID]*list.Element
+}
+
+func newGhostList() *ghostList {
+	return &ghostList{order: list.New(), elements: map[PageID]*list.Element{}}
+}
+
+func (g *ghostList) push(pageID PageID) {
+	g.remove(pageID)
+	g.elements[pageID] = g.order.PushBack(pageID)
+}
+
+func (g *ghostList) contains(pageID PageID) bool {
+	_, ok := g.elements[pageID]
+	return ok
+}
+
+func (g *ghostList) remove(pageID PageID) {
+	if e, ok := g.elements[pageID]; ok {
+		g.order.Remove(e)
+		delete(g.elements, pageID)
+	}
+}
+
+// trim forgets the oldest pages until at most size are left.
+func (g *ghostList) trim(size int) {
+	for g.order.Len() > size {
+		g.remove(g.order.Front().Value.(PageID))
+	}
+}
+
+func (g *ghostList) len() int {
+	return g.order.Len()
+}
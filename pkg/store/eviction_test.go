@@ -0,0 +1,148 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	policies := map[string]func(int) EvictionPolicy{
+		"lru":   func(capacity int) EvictionPolicy { return NewLRU(capacity) },
+		"clock": func(capacity int) EvictionPolicy { return NewClock(capacity) },
+		"2q":    func(capacity int) EvictionPolicy { return NewTwoQ(capacity) },
+		"arc":   func(capacity int) EvictionPolicy { return NewARC(capacity) },
+	}
+	for name, newPolicy := range policies {
+		p := newPolicy(4)
+		if _, ok := p.Evict(0); ok {
+			t.Fatalf("%s: expected nothing to evict", name)
+		}
+		for i := 0; i < 4; i++ {
+			p.Loaded(i, PageID(i+1), false)
+		}
+		// Only released slots are evicted, and each of them only once.
+		p.Released(1)
+		p.Released(3)
+		p.Released(2)
+		p.Remove(2)
+		evicted := map[int]bool{}
+		for {
+			id, ok := p.Evict(5)
+			if !ok {
+				break
+			}
+			if evicted[id] {
+				t.Fatalf("%s: expected slot %d to be evicted once", name, id)
+			}
+			evicted[id] = true
+		}
+		if len(evicted) != 2 || !evicted[1] || !evicted[3] {
+			t.Fatalf("%s: expected %v to be slots 1 and 3", name, evicted)
+		}
+	}
+}
+
+func TestLRU(t *testing.T) {
+	l := NewLRU(5)
+	for i := 0; i < 5; i++ {
+		l.Loaded(i, PageID(i), false)
+		l.Released(i)
+	}
+	// Using a slot again makes it the most recently used.
+	l.Loaded(1, 1, true)
+	l.Released(1)
+	for _, expected := range []int{0, 2, 3, 4, 1} {
+		id, ok := l.Evict(0)
+		if !ok {
+			t.Fatal("expected a slot to evict")
+		}
+		if id != expected {
+			t.Fatalf("expected %d to be %d", id, expected)
+		}
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	// A handful of hot pages are read over and over in between scans of pages that are
+	// only read once. LRU lets every scan push the hot pages out of the cache, while 2Q
+	// and ARC keep them.
+	hitRate := func(policy EvictionPolicy) float64 {
+		counter := &countingPolicy{EvictionPolicy: policy}
+		// The cache is too small to be split into shards, so there's only one policy.
+		store, err := newPageStoreWithPolicy(
+			"scan_resistance",
+			9,
+			func(int) EvictionPolicy { return counter },
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := PageID(100)
+		for round := 0; round < 50; round++ {
+			for i := 0; i < 3; i++ {
+				for hot := PageID(1); hot <= 4; hot++ {
+					loadAndRelease(t, store, hot)
+				}
+			}
+			for i := 0; i < 6; i++ {
+				loadAndRelease(t, store, next)
+				next++
+			}
+		}
+		return float64(counter.hits) / float64(counter.hits+counter.misses)
+	}
+	lru := hitRate(NewLRU(9))
+	for name, policy := range map[string]EvictionPolicy{
+		"2q":  NewTwoQ(9),
+		"arc": NewARC(9),
+	} {
+		if rate := hitRate(policy); rate <= lru {
+			t.Fatalf("%s: expected hit rate %v to beat lru's %v", name, rate, lru)
+		}
+	}
+}
+
+// countingPolicy counts the hits and misses of the policy it wraps, which is how hit
+// rates can be compared for any policy.
+type countingPolicy struct {
+	EvictionPolicy
+	hits   int
+	misses int
+}
+
+func (c *countingPolicy) Loaded(cacheID int, pageID PageID, hit bool) {
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.EvictionPolicy.Loaded(cacheID, pageID, hit)
+}
+
+func loadAndRelease(t *testing.T, store *PageStore, pageID PageID) {
+	t.Helper()
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newPageStoreWithPolicy(
+	filename string,
+	cacheCapacity int,
+	newPolicy func(int) EvictionPolicy,
+) (*PageStore, error) {
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		return nil, err
+	}
+	tmpfile.Close()
+	return NewPageStore(
+		tmpfile.Name(),
+		WithCacheSize(cacheCapacity),
+		WithEvictionPolicy(newPolicy),
+	)
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is returned by a FaultBackend in place of the error a real file would
+// have returned.
+var ErrInjectedFault = errors.New("injected fault")
+
+// Faults sets how often a FaultBackend injects each kind of fault, as the chance between
+// 0 and 1 of each read, write or sync it's asked to do being hit by it.
+type Faults struct {
+	// ShortRead reads only part of what was asked for and returns io.ErrUnexpectedEOF.
+	ShortRead float64
+	// WriteError fails a write without writing anything.
+	WriteError float64
+	// TornWrite writes only part of what was asked for, a whole number of sectors of
+	// SectorSize bytes, and fails.
+	TornWrite float64
+	// SyncError fails a sync.
+	SyncError float64
+	// Slow delays a read, write or sync by Delay before doing it.
+	Slow  float64
+	Delay time.Duration
+}
+
+// SectorSize is the size of the sectors a FaultBackend tears writes along, the unit disks
+// write atomically.
+const SectorSize = 512
+
+// InjectedFaults counts the faults a FaultBackend has injected.
+type InjectedFaults struct {
+	ShortReads  int
+	WriteErrors int
+	TornWrites  int
+	SyncErrors  int
+	Delays      int
+}
+
+// FaultBackend wraps a Backend and injects faults into what's done with it, so that the
+// handling of errors from the file, and what's left of the file after them, can be
+// tested. Faults are drawn from a random source seeded with the seed it's created with,
+// so the same seed injects the same faults into the same sequence of calls. Truncating
+// and closing the backend are passed through untouched.
+type FaultBackend struct {
+	backend  Backend
+	lock     sync.Mutex
+	rand     *rand.Rand
+	faults   Faults
+	injected InjectedFaults
+}
+
+// NewFaultBackend wraps backend in a FaultBackend that injects faults as often as faults
+// says, drawing them from a random source seeded with seed.
+func NewFaultBackend(backend Backend, seed int64, faults Faults) *FaultBackend {
+	return &FaultBackend{
+		backend: backend,
+		rand:    rand.New(rand.NewSource(seed)),
+		faults:  faults,
+	}
+}
+
+// SetFaults changes how often faults are injected from now on. The zero Faults turns
+// them off, which lets a store be opened, or its pages checked, without faults.
+func (b *FaultBackend) SetFaults(faults Faults) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.faults = faults
+}
+
+// Injected returns the number of faults of each kind injected so far.
+func (b *FaultBackend) Injected() InjectedFaults {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.injected
+}
+
+// ReadAt reads from the wrapped backend, unless a short read is injected.
+func (b *FaultBackend) ReadAt(p []byte, off int64) (int, error) {
+	faults := b.begin()
+	if len(p) > 0 && b.hit(faults.ShortRead, &b.injected.ShortReads) {
+		n, err := b.backend.ReadAt(p[:b.intn(len(p))], off)
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrUnexpectedEOF
+	}
+	return b.backend.ReadAt(p, off)
+}
+
+// WriteAt writes to the wrapped backend, unless a write error or torn write is injected.
+func (b *FaultBackend) WriteAt(p []byte, off int64) (int, error) {
+	faults := b.begin()
+	if b.hit(faults.WriteError, &b.injected.WriteErrors) {
+		return 0, ErrInjectedFault
+	}
+	if len(p) > 0 && b.hit(faults.TornWrite, &b.injected.TornWrites) {
+		sectors := (len(p) + SectorSize - 1) / SectorSize
+		n, err := b.backend.WriteAt(p[:b.intn(sectors)*SectorSize], off)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrInjectedFault
+	}
+	return b.backend.WriteAt(p, off)
+}
+
+// Sync syncs the wrapped backend, unless a sync error is injected, in which case it's
+// not synced at all.
+func (b *FaultBackend) Sync() error {
+	faults := b.begin()
+	if b.hit(faults.SyncError, &b.injected.SyncErrors) {
+		return ErrInjectedFault
+	}
+	return b.backend.Sync()
+}
+
+// Truncate truncates the wrapped backend.
+func (b *FaultBackend) Truncate(size int64) error {
+	return b.backend.Truncate(size)
+}
+
+// Close closes the wrapped backend.
+func (b *FaultBackend) Close() error {
+	return b.backend.Close()
+}
+
+// begin returns the faults to inject into a call, after delaying it if a delay is drawn.
+func (b *FaultBackend) begin() Faults {
+	b.lock.Lock()
+	faults := b.faults
+	slow := faults.Slow > 0 && b.rand.Float64() < faults.Slow
+	if slow {
+		b.injected.Delays++
+	}
+	b.lock.Unlock()
+	if slow {
+		time.Sleep(faults.Delay)
+	}
+	return faults
+}
+
+// hit draws whether a fault with the given chance is injected, counting it in injected
+// if it is.
+func (b *FaultBackend) hit(chance float64, injected *int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if chance <= 0 || b.rand.Float64() >= chance {
+		return false
+	}
+	(*injected)++
+	return true
+}
+
+// intn draws a number in [0, n).
+func (b *FaultBackend) intn(n int) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.rand.Intn(n)
+}
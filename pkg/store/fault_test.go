@@ -0,0 +1,165 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFaultBackendIsSeeded(t *testing.T) {
+	faults := Faults{ShortRead: 0.3, WriteError: 0.2, TornWrite: 0.2, SyncError: 0.5}
+	run := func() ([]int, InjectedFaults) {
+		backend := NewFaultBackend(NewMemoryBackend(), 42, faults)
+		var ns []int
+		buf := make([]byte, PageSize)
+		for i := 0; i < 100; i++ {
+			n, _ := backend.WriteAt(buf, int64(i)*PageSize)
+			ns = append(ns, n)
+			n, _ = backend.ReadAt(buf, 0)
+			ns = append(ns, n)
+			backend.Sync()
+		}
+		return ns, backend.Injected()
+	}
+	first, firstInjected := run()
+	second, secondInjected := run()
+	if firstInjected != secondInjected {
+		t.Fatalf("expected %+v == %+v", firstInjected, secondInjected)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected %v == %v at call %d", first[i], second[i], i)
+		}
+	}
+	injected := firstInjected
+	if injected.ShortReads == 0 || injected.WriteErrors == 0 || injected.TornWrites == 0 ||
+		injected.SyncErrors == 0 {
+		t.Fatalf("expected every kind of fault to be injected, got %+v", injected)
+	}
+}
+
+func TestFaultBackendFaults(t *testing.T) {
+	memory := NewMemoryBackend()
+	backend := NewFaultBackend(memory, 1, Faults{TornWrite: 1})
+	page := pageFilledWith(7)
+	n, err := backend.WriteAt(page[:], 0)
+	if err != ErrInjectedFault {
+		t.Fatalf("expected %v == %v", err, ErrInjectedFault)
+	}
+	if n%SectorSize != 0 || n >= PageSize || memory.Size() != int64(n) {
+		t.Fatalf("expected a torn write of whole sectors, wrote %d of %d", n, memory.Size())
+	}
+
+	backend.SetFaults(Faults{ShortRead: 1})
+	if _, err := memory.WriteAt(page[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	var buf [PageSize]byte
+	n, err = backend.ReadAt(buf[:], 0)
+	if err != io.ErrUnexpectedEOF || n >= PageSize {
+		t.Fatalf("expected a short read, read %d with %v", n, err)
+	}
+
+	backend.SetFaults(Faults{WriteError: 1, SyncError: 1})
+	if n, err := backend.WriteAt(page[:], PageSize); n != 0 || err != ErrInjectedFault {
+		t.Fatalf("expected a failed write, wrote %d with %v", n, err)
+	}
+	if err := backend.Sync(); err != ErrInjectedFault {
+		t.Fatalf("expected %v == %v", err, ErrInjectedFault)
+	}
+
+	backend.SetFaults(Faults{Slow: 1, Delay: 10 * time.Millisecond})
+	start := time.Now()
+	if err := backend.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < 10*time.Millisecond {
+		t.Fatalf("expected the sync to be delayed, took %v", took)
+	}
+	expected := InjectedFaults{ShortReads: 1, WriteErrors: 1, TornWrites: 1, SyncErrors: 1,
+		Delays: 1}
+	if injected := backend.Injected(); injected != expected {
+		t.Fatalf("expected %+v == %+v", injected, expected)
+	}
+}
+
+// TestStoreSurvivesFaults writes pages through a backend that fails often, retrying each
+// write and sync until it goes through, and checks the pages read back as they were
+// written.
+func TestStoreSurvivesFaults(t *testing.T) {
+	faults := 0
+	for seed := int64(0); seed < 10; seed++ {
+		memory := NewMemoryBackend()
+		backend := NewFaultBackend(memory, seed, Faults{})
+		store, err := OpenBackend(backend, WithCacheSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pages []PageID
+		for i := 0; i < 20; i++ {
+			pageID, err := store.Allocate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, pageID)
+		}
+		backend.SetFaults(Faults{WriteError: 0.1, TornWrite: 0.1, SyncError: 0.2})
+		for round := byte(1); round <= 5; round++ {
+			// The first write after a sync marks the file as being written to.
+			for _, pageID := range pages {
+				page, err := store.Load(pageID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				page.Buf = *pageFilledWith(round)
+				failures := 0
+				for err = store.Write(pageID); err != nil; err = store.Write(pageID) {
+					if !errors.Is(err, ErrInjectedFault) {
+						t.Fatalf("seed %d: expected %v == %v", seed, err, ErrInjectedFault)
+					}
+					if failures++; failures > 100 {
+						t.Fatalf("seed %d: write kept failing", seed)
+					}
+				}
+				if err := page.Release(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			failures := 0
+			for err := store.Sync(); err != nil; err = store.Sync() {
+				if !errors.Is(err, ErrInjectedFault) {
+					t.Fatalf("seed %d: expected %v == %v", seed, err, ErrInjectedFault)
+				}
+				if failures++; failures > 100 {
+					t.Fatalf("seed %d: sync kept failing", seed)
+				}
+			}
+		}
+		injected := backend.Injected()
+		faults += injected.WriteErrors + injected.TornWrites + injected.SyncErrors
+		backend.SetFaults(Faults{})
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reopened, err := OpenBackend(memory, WithCacheSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pageID := range pages {
+			page, err := reopened.Load(pageID)
+			if err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+			if page.Buf[0] != 5 {
+				t.Fatalf("seed %d: expected %v == %v", seed, page.Buf[0], 5)
+			}
+			page.Release()
+		}
+		reopened.Close()
+	}
+	if faults == 0 {
+		t.Fatalf("expected faults to be injected")
+	}
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidFlushInterval is returned when starting the flusher without a positive
+// interval.
+var ErrInvalidFlushInterval = errors.New("flush interval must be positive")
+
+// The flusher writes dirty pages back to the file in the background, so that a burst of
+// writes doesn't leave a burst of write backs for whoever next evicts a page, syncs, or
+// closes the store. It only writes pages back. Syncing them to disk is still up to the
+// durability, see SetDurability. With a write-ahead log or in copy-on-write mode, every
+// commit writes its pages to the file, so there's nothing left for the flusher to do.
+
+// StartFlusher starts writing dirty pages back to the file in the background every
+// interval, and as soon as the dirty pages add up to threshold bytes if threshold is
+// positive. Starting the flusher again replaces the one already running. The flusher
+// stops when StopFlusher is called or the store is closed, and the first error it runs
+// into is returned by Sync.
+func (s *PageStore) StartFlusher(interval time.Duration, threshold int) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if interval <= 0 {
+		return ErrInvalidFlushInterval
+	}
+	s.stopFlusher()
+	s.stopFlushing = make(chan struct{})
+	s.flushNow = make(chan struct{}, 1)
+	s.flushThreshold = threshold
+	go s.flushPeriodically(interval, s.stopFlushing, s.flushNow)
+	return nil
+}
+
+// StopFlusher stops the flusher started by StartFlusher, if there is one.
+func (s *PageStore) StopFlusher() {
+	s.Lock()
+	defer s.Unlock()
+	s.stopFlusher()
+}
+
+func (s *PageStore) stopFlusher() {
+	if s.stopFlushing == nil {
+		return
+	}
+	close(s.stopFlushing)
+	s.stopFlushing = nil
+	s.flushNow = nil
+	s.flushThreshold = 0
+}
+
+// wakeFlusher wakes the flusher up early if the dirty pages have reached its threshold.
+func (s *PageStore) wakeFlusher() {
+	if s.flushThreshold <= 0 || int(s.dirtyPages.Load())*PageSize < s.flushThreshold {
+		return
+	}
+	select {
+	case s.flushNow <- struct{}{}:
+	default:
+		// The flusher has already been woken up.
+	}
+}
+
+func (s *PageStore) flushPeriodically(
+	interval time.Duration,
+	stop chan struct{},
+	now chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-now:
+		}
+		s.Lock()
+		if !s.closed {
+			err := s.flush()
+			if err != nil {
+				s.backgroundError("flush", err)
+			}
+		}
+		s.Unlock()
+	}
+}
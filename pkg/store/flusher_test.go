@@ -0,0 +1,94 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestFlusher(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "flusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StartFlusher(0, 0); err != ErrInvalidFlushInterval {
+		t.Fatalf("expected %v == %v", err, ErrInvalidFlushInterval)
+	}
+	// The interval is long enough that only the threshold wakes the flusher up.
+	if err := store.StartFlusher(time.Hour, 3*PageSize); err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 2; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	// The header and both pages reach the threshold.
+	waitForDirty(t, store, 0)
+	assertBufEqual(
+		t,
+		readPageFromFile(t, tmpfile.Name(), pages[1])[:UsablePageSize],
+		pageFilledWith(1)[:UsablePageSize],
+	)
+
+	store.StopFlusher()
+	writePages(t, store, pages, 2)
+	time.Sleep(10 * time.Millisecond)
+	if store.Dirty() != 2 {
+		t.Fatalf("expected %v == %v", store.Dirty(), 2)
+	}
+
+	// Without a threshold, the pages are written back on the interval.
+	if err := store.StartFlusher(time.Millisecond, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitForDirty(t, store, 0)
+	assertBufEqual(
+		t,
+		readPageFromFile(t, tmpfile.Name(), pages[1])[:UsablePageSize],
+		pageFilledWith(2)[:UsablePageSize],
+	)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StartFlusher(time.Millisecond, 0); err != ErrClosed {
+		t.Fatalf("expected %v == %v", err, ErrClosed)
+	}
+}
+
+// writePages fills every page with b.
+func writePages(t *testing.T, store *PageStore, pages []PageID, b byte) {
+	for _, pageID := range pages {
+		page, err := store.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.Buf = *pageFilledWith(b)
+		if err := store.Write(pageID); err != nil {
+			t.Fatal(err)
+		}
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitForDirty waits for the flusher to leave the given number of pages dirty.
+func waitForDirty(t *testing.T, store *PageStore, dirty int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Dirty() != dirty {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v == %v", store.Dirty(), dirty)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
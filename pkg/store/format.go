@@ -0,0 +1,178 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Every integer in the file is written little endian, whatever the byte order of the
+// machine writing it, so files can be moved between machines. The header, in the first
+// page of the file and, in FormatVersion4 and later, its copy in the second, describes
+// the rest of the file, and is laid out as follows, with the offset of each field:
+//
+//	0     magic number, see MagicNumber
+//	4     free list, size and root as 32-bit page ids, in FormatVersion1
+//	16    branching factor of the tree
+//	20    flags the tree was created with
+//	24    dirty, set while the file is written through a write-ahead log
+//	28    closed cleanly
+//	32    format version, zero in FormatVersion1
+//	36    free list, as the offset of its first page, which is zero with a free-space map
+//	44    size, in pages
+//	52    root of the tree
+//	60    pages reserved ahead of being allocated
+//	68    number of holes
+//	72    unused, where the codec of compressed files was recorded
+//	76    bytes reserved at the end of every page
+//	80    ID of the key the key check is sealed with, in encrypted files
+//	84    key check
+//	128   holes punched out of the file, 64 of 16 bytes
+//	1152  unused, where compression dictionaries were recorded, 32 bytes
+//	1184  log sequence number of the last group committed
+//	1192  log sequence number of the last checkpoint
+//	1200  generation, picking the copy of the header written
+//	1208  store ID, see StoreID
+//	1224  writing, see markWriting
+//	1228  page size
+//	1232  byte order mark
+//	1236  key type, see SetKeyType
+//	1240  features
+//
+// The last four bytes of every page, the header included, hold its checksum. Fields
+// past the end of what a format's first version wrote are zero in files written before
+// them, and are filled in the next time the header is written. The page size, byte
+// order and features are checked when the file is opened, so a file this package can't
+// read is refused with a HeaderError naming the field rather than misread.
+
+const (
+	pageSizeOffset  = writingOffset + 4
+	byteOrderOffset = pageSizeOffset + 4
+	keyTypeOffset   = byteOrderOffset + 4
+	featuresOffset  = keyTypeOffset + 4
+)
+
+// byteOrderMark is written to the header little endian, so it reads back as itself from
+// files written in the byte order this package writes, and reversed from files written
+// big endian.
+const byteOrderMark = 0x01020304
+
+// The features a file uses, which are recorded in the header so that a reader that
+// doesn't know about one, such as an older version of this package, refuses the file.
+const (
+	// featureCompression is set when the file's pages are compressed, which this package
+	// no longer does, so such files are refused, see checkFormat.
+	featureCompression uint32 = 1 << iota
+	// featureEncryption is set when the file's pages are encrypted, see WithEncryption.
+	featureEncryption
+	// featureDictionary is set when the file's pages are compressed with a dictionary,
+	// and is refused along with featureCompression.
+	featureDictionary
+	// featureFreeSpaceMap is set when the file keeps a free-space map, in FormatVersion3
+	// and later.
+	featureFreeSpaceMap
+	// featureHeaderCopy is set when the file keeps a copy of the header, in
+	// FormatVersion4 and later.
+	featureHeaderCopy
+
+	knownFeatures = featureEncryption | featureFreeSpaceMap | featureHeaderCopy
+)
+
+var featureNames = []string{
+	"compression", "encryption", "dictionary", "free-space map", "header copy",
+}
+
+// usedFeatures returns the features the file the header describes uses.
+func (p *headerPage) usedFeatures() uint32 {
+	var features uint32
+	if p.reserve != 0 {
+		features |= featureEncryption
+	}
+	if p.version >= FormatVersion3 {
+		features |= featureFreeSpaceMap
+	}
+	if p.version >= FormatVersion4 {
+		features |= featureHeaderCopy
+	}
+	return features
+}
+
+// checkFormat returns a HeaderError if the header describes a file with pages of another
+// size, in another byte order, or using features this package doesn't know about.
+func (p *headerPage) checkFormat() error {
+	if p.pageSize != 0 && p.pageSize != PageSize {
+		return &HeaderError{"page size", fmt.Sprintf(
+			"%d bytes, expected %d", p.pageSize, PageSize)}
+	}
+	switch p.byteOrder {
+	case 0, byteOrderMark:
+	case 0x04030201:
+		return &HeaderError{"byte order", "big endian, expected little endian"}
+	default:
+		return &HeaderError{"byte order", fmt.Sprintf(
+			"unknown byte order mark 0x%08X", p.byteOrder)}
+	}
+	if p.features&(featureCompression|featureDictionary) != 0 {
+		return &HeaderError{"features", "compressed pages, which are no longer supported"}
+	}
+	if unknown := p.features &^ knownFeatures; unknown != 0 {
+		return &HeaderError{"features", fmt.Sprintf(
+			"unknown features 0x%X, expected a newer version", unknown)}
+	}
+	return nil
+}
+
+// formatFieldsFromBuffer reads the fields of the header that describe the file's format.
+func (p *headerPage) formatFieldsFromBuffer() {
+	p.pageSize = binary.LittleEndian.Uint32(p.Buf[pageSizeOffset:])
+	p.byteOrder = binary.LittleEndian.Uint32(p.Buf[byteOrderOffset:])
+	p.keyType = binary.LittleEndian.Uint32(p.Buf[keyTypeOffset:])
+	p.features = binary.LittleEndian.Uint32(p.Buf[featuresOffset:])
+}
+
+// formatFieldsToBuffer writes the fields of the header that describe the file's format,
+// which are always those of the format this package writes.
+func (p *headerPage) formatFieldsToBuffer() {
+	p.pageSize = PageSize
+	p.byteOrder = byteOrderMark
+	p.features = p.usedFeatures()
+	binary.LittleEndian.PutUint32(p.Buf[pageSizeOffset:], p.pageSize)
+	binary.LittleEndian.PutUint32(p.Buf[byteOrderOffset:], p.byteOrder)
+	binary.LittleEndian.PutUint32(p.Buf[keyTypeOffset:], p.keyType)
+	binary.LittleEndian.PutUint32(p.Buf[featuresOffset:], p.features)
+}
+
+// featuresValue writes out a set of features.
+func featuresValue(features uint32) string {
+	var names []string
+	for i, name := range featureNames {
+		if features&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if unknown := features &^ knownFeatures; unknown != 0 {
+		names = append(names, fmt.Sprintf("unknown 0x%X", unknown))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// KeyType returns the type of the keys stored in this file, see SetKeyType.
+func (s *PageStore) KeyType() uint32 {
+	return s.header.keyType
+}
+
+// SetKeyType records the type of the keys stored in this file in the header, for layers
+// that encode keys of one type to check that the file is opened with the same type. Zero
+// means the type isn't known.
+func (s *PageStore) SetKeyType(keyType uint32) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.header.keyType = keyType
+	return s.writeHeader()
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestFormatFieldsLittleEndian(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetKeyType(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	header, err := readHeader(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x00, 0x10, 0x00, 0x00,
+		0x04, 0x03, 0x02, 0x01,
+		0x03, 0x00, 0x00, 0x00,
+		0x18, 0x00, 0x00, 0x00,
+	}
+	fields := header.Buf[pageSizeOffset : featuresOffset+4]
+	if !bytes.Equal(fields, expected) {
+		t.Fatalf("expected %x == %x", fields, expected)
+	}
+	store, err = OpenBackend(backend, WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.KeyType() != 3 {
+		t.Fatalf("expected %v == %v", store.KeyType(), 3)
+	}
+}
+
+func TestFormatFieldsRefused(t *testing.T) {
+	cases := []struct {
+		offset int
+		value  uint32
+		field  string
+	}{
+		{pageSizeOffset, 8192, "page size"},
+		{byteOrderOffset, 0x04030201, "byte order"},
+		{byteOrderOffset, 0xDEADBEEF, "byte order"},
+		{featuresOffset, featureHeaderCopy | 1<<20, "features"},
+		// Files whose pages were compressed can no longer be read.
+		{featuresOffset, featureHeaderCopy | featureCompression, "features"},
+	}
+	for _, c := range cases {
+		backend := newFormatBackend(t)
+		writeHeaderField(t, backend, c.offset, c.value)
+		_, err := OpenBackend(backend, WithCacheSize(10))
+		var headerErr *HeaderError
+		if !errors.As(err, &headerErr) || headerErr.Field != c.field {
+			t.Fatalf("expected %v to be a HeaderError for %v", err, c.field)
+		}
+	}
+
+	// Files written before the fields were added have them zeroed, and are opened.
+	backend := newFormatBackend(t)
+	for _, offset := range []int{pageSizeOffset, byteOrderOffset, featuresOffset} {
+		writeHeaderField(t, backend, offset, 0)
+	}
+	store, err := OpenBackend(backend, WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	header, err := readHeader(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.pageSize != PageSize {
+		t.Fatalf("expected %v == %v", header.pageSize, PageSize)
+	}
+	if header.byteOrder != byteOrderMark {
+		t.Fatalf("expected %v == %v", header.byteOrder, byteOrderMark)
+	}
+}
+
+func newFormatBackend(t *testing.T) *MemoryBackend {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+// writeHeaderField writes a new copy of the header in backend with a field set to value.
+func writeHeaderField(t *testing.T, backend *MemoryBackend, offset int, value uint32) {
+	header, err := readHeader(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.nextGeneration()
+	header.toBuffer()
+	binary.LittleEndian.PutUint32(header.Buf[offset:], value)
+	setPageChecksum(&header.Buf)
+	_, err = backend.WriteAt(header.Buf[:], pageOffset(headerImageID(0, header.Buf[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
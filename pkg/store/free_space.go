@@ -0,0 +1,465 @@
+package store
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+var (
+	// ErrInvalidRun is returned by AllocateRun for runs of no pages, and for runs longer
+	// than a page of the free-space map covers.
+	ErrInvalidRun = errors.New("invalid run of pages")
+	// ErrFreeSpaceCorrupt is returned by CheckFreeSpace when the pages a file has free
+	// don't add up.
+	ErrFreeSpaceCorrupt = errors.New("free space is corrupt")
+)
+
+// mapPageBits returns the number of pages each page of the free-space map covers, with
+// one bit for each page in every byte of a page's contents, see ContentSize.
+func (s *PageStore) mapPageBits() uint64 {
+	return uint64(s.ContentSize()) * 8
+}
+
+// freeSpace is the free-space map of a file in FormatVersion3 or later, which has a bit
+// for every page of the file that's set while the page is free. Unlike the free list of
+// older formats, free pages don't have to be read to be found, so the map can be
+// searched for the first free page or for a run of them, and the number of free pages is
+// always known.
+//
+// The file is divided into groups of as many pages as a map page has bits, and the map
+// of each group is kept in its first page, except for the first group whose first pages
+// hold the header, and whose map is kept in the page after them. The map pages are marked
+// in use, so they're never allocated. The whole map is kept in memory while the store is
+// open, and the pages of it that change are written along with the header.
+type freeSpace struct {
+	// bits is the number of pages in each group, see mapPageBits.
+	bits uint64
+	// firstMap is the map page of the first group, which follows the header and its copy.
+	firstMap PageID
+	// maps holds the bits of the map page of each group.
+	maps [][]byte
+	// changed holds the groups whose map pages have changed since they were written.
+	changed map[int]bool
+	// free is the number of bits set.
+	free int
+	// next is a page that no free page comes before, where searches start from.
+	next uint64
+}
+
+// mapPageID returns the page holding the map of a group.
+func (f *freeSpace) mapPageID(group int) PageID {
+	if group == 0 {
+		return f.firstMap
+	}
+	return PageID(uint64(group) * f.bits)
+}
+
+// isMapPage returns whether a page holds part of the free-space map.
+func (f *freeSpace) isMapPage(pageID PageID) bool {
+	return pageID == f.mapPageID(int(uint64(pageID)/f.bits))
+}
+
+// newFreeSpaceMap returns an empty free-space map laid out for the file's format.
+func (s *PageStore) newFreeSpaceMap() *freeSpace {
+	return &freeSpace{
+		bits:     s.mapPageBits(),
+		firstMap: headerPages(s.header.version),
+		changed:  map[int]bool{},
+	}
+}
+
+// newFreeSpace sets up the map of a new file, which is made up of the header, its copy in
+// newer formats, and the first map page.
+func (s *PageStore) newFreeSpace() error {
+	s.freeSpace = s.newFreeSpaceMap()
+	err := s.addMapPage()
+	if err != nil {
+		return err
+	}
+	return s.writeFreeSpace()
+}
+
+// loadFreeSpace reads the map of the file into memory.
+func (s *PageStore) loadFreeSpace() error {
+	s.freeSpace = s.newFreeSpaceMap()
+	groups := int((s.header.size-1)/s.freeSpace.bits) + 1
+	for group := 0; group < groups; group++ {
+		page, err := s.Load(s.freeSpace.mapPageID(group))
+		if err != nil {
+			return err
+		}
+		bits := s.freeSpace.grow()
+		copy(bits, page.Buf[:])
+		err = page.Release()
+		if err != nil {
+			return err
+		}
+	}
+	s.freeSpace.count()
+	return nil
+}
+
+// grow adds a group to the map, with none of its pages free, and returns its bits.
+func (f *freeSpace) grow() []byte {
+	bits := make([]byte, f.bits/8)
+	f.changed[len(f.maps)] = true
+	f.maps = append(f.maps, bits)
+	return bits
+}
+
+// count recounts the free pages.
+func (f *freeSpace) count() {
+	f.free = 0
+	for _, m := range f.maps {
+		for _, b := range m {
+			f.free += bits.OnesCount8(b)
+		}
+	}
+}
+
+// isFree returns whether a page is free.
+func (f *freeSpace) isFree(pageID PageID) bool {
+	group, bit := uint64(pageID)/f.bits, uint64(pageID)%f.bits
+	if group >= uint64(len(f.maps)) {
+		return false
+	}
+	return f.maps[group][bit/8]&(1<<(bit%8)) != 0
+}
+
+// setFree marks a page as free or in use.
+func (f *freeSpace) setFree(pageID PageID, free bool) {
+	if f.isFree(pageID) == free {
+		return
+	}
+	group, bit := uint64(pageID)/f.bits, uint64(pageID)%f.bits
+	if free {
+		f.maps[group][bit/8] |= 1 << (bit % 8)
+		f.free++
+		f.next = min(f.next, uint64(pageID))
+	} else {
+		f.maps[group][bit/8] &^= 1 << (bit % 8)
+		f.free--
+	}
+	f.changed[int(group)] = true
+}
+
+// first returns the first free page at or after from, if there is one.
+func (f *freeSpace) first(from uint64) (PageID, bool) {
+	if f.free == 0 {
+		return 0, false
+	}
+	for group := from / f.bits; group < uint64(len(f.maps)); group++ {
+		m := f.maps[group]
+		i := 0
+		if group == from/f.bits {
+			i = int(from%f.bits) / 8
+		}
+		for ; i < len(m); i++ {
+			b := m[i]
+			if group == from/f.bits && i == int(from%f.bits)/8 {
+				// Ignore the pages in the first byte that come before from.
+				b &= 0xff << (from % 8)
+			}
+			if b != 0 {
+				bit := uint64(i)*8 + uint64(bits.TrailingZeros8(b))
+				return PageID(group*f.bits + bit), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// firstFree returns the first free page in the file, if there is one.
+func (f *freeSpace) firstFree() (PageID, bool) {
+	pageID, ok := f.first(f.next)
+	if ok {
+		f.next = uint64(pageID)
+	}
+	return pageID, ok
+}
+
+// firstRun returns the first run of n free pages, if there is one.
+func (f *freeSpace) firstRun(n int) (PageID, bool) {
+	start, ok := f.firstFree()
+	for ok {
+		length := 1
+		for length < n && f.isFree(start+PageID(length)) {
+			length++
+		}
+		if length == n {
+			return start, true
+		}
+		start, ok = f.first(uint64(start) + uint64(length))
+	}
+	return 0, false
+}
+
+// allocateFromMap allocates the first free page in the file.
+func (s *PageStore) allocateFromMap() (PageID, error) {
+	pageID, ok := s.freeSpace.firstFree()
+	if !ok {
+		return s.allocateFromEndOfFile()
+	}
+	s.freeSpace.setFree(pageID, false)
+	return pageID, nil
+}
+
+// addMapPage adds the map page of the group the end of the file has reached, if it has
+// reached a new one, so that the pages after it are covered by the map before they're
+// allocated.
+func (s *PageStore) addMapPage() error {
+	if s.freeSpace == nil || !s.freeSpace.isMapPage(PageID(s.header.size)) {
+		return nil
+	}
+	err := s.reserve(s.header.size + 1)
+	if err != nil {
+		return err
+	}
+	s.freeSpace.grow()
+	s.header.size++
+	return nil
+}
+
+// writeFreeSpace writes the map pages that have changed. In copy-on-write mode, the ones
+// that change between Begin and Commit are written when the group is committed, along
+// with the header, see commitCOW.
+func (s *PageStore) writeFreeSpace() error {
+	if s.cow && s.grouped() {
+		return nil
+	}
+	for group := range s.freeSpace.changed {
+		pageID := s.freeSpace.mapPageID(group)
+		page, err := s.Load(pageID)
+		if err != nil {
+			return err
+		}
+		copy(page.Buf[:], s.freeSpace.maps[group])
+		err = s.Write(pageID)
+		releaseErr := page.Release()
+		if err != nil {
+			return err
+		}
+		if releaseErr != nil {
+			return releaseErr
+		}
+		delete(s.freeSpace.changed, group)
+	}
+	return nil
+}
+
+// writeMapPages writes the map pages that have changed straight to the file, for the
+// changes that are made with the store's lock held.
+func (s *PageStore) writeMapPages() error {
+	if s.freeSpace == nil {
+		return nil
+	}
+	for group := range s.freeSpace.changed {
+		pageID := s.freeSpace.mapPageID(group)
+		var buf [PageSize]byte
+		copy(buf[:], s.freeSpace.maps[group])
+		err := s.writePage(pageID, &buf)
+		if err != nil {
+			return err
+		}
+		// The page may be cached from when it was last written.
+		if cacheID, ok := s.shard(pageID).lookup[pageID]; ok {
+			s.cache[cacheID].Buf = buf
+			s.setDirty(cacheID, false)
+		}
+		delete(s.freeSpace.changed, group)
+	}
+	return nil
+}
+
+// cutFreeSpace takes the pages from size on out of the map, along with the map pages of
+// the groups that no longer have any pages.
+func (f *freeSpace) cutFreeSpace(size uint64) {
+	for pageID := PageID(size); pageID < PageID(uint64(len(f.maps))*f.bits); pageID++ {
+		f.setFree(pageID, false)
+	}
+	f.maps = f.maps[:int((size-1)/f.bits)+1]
+	for group := range f.changed {
+		if group >= len(f.maps) {
+			delete(f.changed, group)
+		}
+	}
+	f.next = min(f.next, size)
+}
+
+// FreePages returns the number of free pages in the file. Files in FormatVersion3 and
+// later keep count, but the free list of older formats has to be read to count them.
+func (s *PageStore) FreePages() (int, error) {
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	return s.countFreePages()
+}
+
+// countFreePages counts the free pages in the file. The header lock must be held.
+func (s *PageStore) countFreePages() (int, error) {
+	if s.freeSpace != nil {
+		return s.freeSpace.free, nil
+	}
+	free, err := s.readFreeList()
+	return len(free), err
+}
+
+// FreePageIDs returns every free page in the file, in order.
+func (s *PageStore) FreePageIDs() ([]PageID, error) {
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	if s.freeSpace == nil {
+		free, err := s.readFreeList()
+		sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+		return free, err
+	}
+	var free []PageID
+	for pageID, ok := s.freeSpace.first(0); ok; {
+		free = append(free, pageID)
+		pageID, ok = s.freeSpace.first(uint64(pageID) + 1)
+	}
+	return free, nil
+}
+
+// readFreeList reads the free list of a file in an older format, once the links written
+// by Free have reached the file. The header lock must be held.
+func (s *PageStore) readFreeList() ([]PageID, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	err := s.flush()
+	if err != nil {
+		return nil, err
+	}
+	return s.freePageIDs()
+}
+
+// AllocateRun allocates n adjacent pages and returns the first of them. In files with a
+// free-space map, the first run of n free pages is allocated if there is one, and
+// otherwise the run is allocated from the end of the file, as it always is in older
+// formats. Runs can be no longer than the pages a map page covers, less one.
+func (s *PageStore) AllocateRun(n int) (PageID, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	if n < 1 || uint64(n) >= s.mapPageBits() {
+		return 0, ErrInvalidRun
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	start, err := s.allocateRun(n)
+	if err != nil {
+		return 0, err
+	}
+	if s.cow && s.grouped() {
+		s.Lock()
+		for i := 0; i < n; i++ {
+			s.fresh[start+PageID(i)] = true
+		}
+		s.Unlock()
+	}
+	if s.freeSpace != nil {
+		err = s.writeFreeSpace()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return start, s.writeHeader()
+}
+
+func (s *PageStore) allocateRun(n int) (PageID, error) {
+	if s.freeSpace != nil {
+		start, ok := s.freeSpace.firstRun(n)
+		if ok && s.backups == 0 {
+			for i := 0; i < n; i++ {
+				s.freeSpace.setFree(start+PageID(i), false)
+			}
+			return start, nil
+		}
+		// A run that would reach into the next group starts after its map page instead,
+		// leaving the pages before it free.
+		end := s.header.size + uint64(n)
+		next := (s.header.size/s.freeSpace.bits + 1) * s.freeSpace.bits
+		if end > next {
+			err := s.reserve(next)
+			if err != nil {
+				return 0, err
+			}
+			for pageID := PageID(s.header.size); pageID < PageID(next); pageID++ {
+				s.freeSpace.setFree(pageID, true)
+			}
+			s.header.size = next
+		}
+	}
+	err := s.addMapPage()
+	if err != nil {
+		return 0, err
+	}
+	start := PageID(s.header.size)
+	err = s.reserve(s.header.size + uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	s.header.size += uint64(n)
+	return start, nil
+}
+
+// CheckFreeSpace checks that the pages the file has free add up, returning
+// ErrFreeSpaceCorrupt if they don't. With a free-space map, no page past the end of the
+// file can be free, and neither can the header or the map's own pages. With a free list,
+// every page on it has to be inside the file, and on it only once.
+func (s *PageStore) CheckFreeSpace() error {
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	if s.freeSpace == nil {
+		s.Lock()
+		defer s.Unlock()
+		if s.closed {
+			return ErrClosed
+		}
+		err := s.flush()
+		if err != nil {
+			return err
+		}
+		return s.checkFreeList()
+	}
+	for group := range s.freeSpace.maps {
+		if s.freeSpace.isFree(s.freeSpace.mapPageID(group)) {
+			return ErrFreeSpaceCorrupt
+		}
+	}
+	for pageID := PageID(0); pageID < s.freeSpace.firstMap; pageID++ {
+		if s.freeSpace.isFree(pageID) {
+			return ErrFreeSpaceCorrupt
+		}
+	}
+	if _, ok := s.freeSpace.first(s.header.size); ok {
+		return ErrFreeSpaceCorrupt
+	}
+	return nil
+}
+
+// checkFreeList walks the free list, checking that it stays inside the file and doesn't
+// visit a page twice, which would make it run forever.
+func (s *PageStore) checkFreeList() error {
+	seen := map[PageID]bool{}
+	for next := s.header.freeList; next != 0; {
+		pageID := PageID(next / PageSize)
+		if next%PageSize != 0 || uint64(pageID) >= s.header.size || seen[pageID] {
+			return pageError("check free list", pageID, ErrFreeSpaceCorrupt)
+		}
+		seen[pageID] = true
+		var buf [PageSize]byte
+		_, err := s.backend.ReadAt(buf[:], pageOffset(pageID))
+		if err == nil {
+			err = s.decodePage(pageID, &buf)
+		}
+		if err != nil {
+			return pageError("check free list", pageID, err)
+		}
+		next = s.freeLink(buf[:])
+	}
+	return nil
+}
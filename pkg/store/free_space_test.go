@@ -0,0 +1,346 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFreeSpace(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 10; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	for _, pageID := range []PageID{pages[6], pages[2], pages[4]} {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectFree(t, store, pages[2], pages[4], pages[6])
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	expectFree(t, reopened, pages[2], pages[4], pages[6])
+	// Free pages are allocated from the front of the file, whatever order they were freed
+	// in.
+	for _, expected := range []PageID{pages[2], pages[4], pages[6], pages[9] + 1} {
+		pageID, err := reopened.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pageID != expected {
+			t.Fatalf("expected %v == %v", pageID, expected)
+		}
+	}
+	expectFree(t, reopened)
+}
+
+func TestFreeSpaceCoversNewGroups(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "free_space_groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A run that doesn't fit before the next group's map page starts after it, and the
+	// pages it skips are left free.
+	bits := PageID(store.mapPageBits())
+	start, err := store.AllocateRun(int(bits) - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != bits+1 {
+		t.Fatalf("expected %v == %v", start, bits+1)
+	}
+	if !store.freeSpace.isMapPage(bits) {
+		t.Fatalf("expected page %v to hold the map", bits)
+	}
+	if err := store.CheckFreeSpace(); err != nil {
+		t.Fatal(err)
+	}
+	free, err := store.FreePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != int(bits)-3 {
+		t.Fatalf("expected %v == %v", free, int(bits)-3)
+	}
+	// The last page of the run is in the second group, which the map covers too.
+	last := start + bits - 2
+	if err := store.Free(last); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if err := reopened.CheckFreeSpace(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing cut the freed page off the end of the file.
+	free, err = reopened.FreePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != int(bits)-3 {
+		t.Fatalf("expected %v == %v", free, int(bits)-3)
+	}
+	pageID, err := reopened.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageID != 3 {
+		t.Fatalf("expected %v == %v", pageID, 3)
+	}
+}
+
+func TestAllocateRun(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var pages []PageID
+	for i := 0; i < 10; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	for _, pageID := range []PageID{pages[1], pages[4], pages[5], pages[6]} {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The single free page is too short for the run.
+	start, err := store.AllocateRun(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != pages[4] {
+		t.Fatalf("expected %v == %v", start, pages[4])
+	}
+	// With no run long enough left, the run comes from the end of the file.
+	start, err = store.AllocateRun(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != pages[9]+1 {
+		t.Fatalf("expected %v == %v", start, pages[9]+1)
+	}
+	expectFree(t, store, pages[1])
+	if _, err := store.AllocateRun(0); err != ErrInvalidRun {
+		t.Fatalf("expected %v == %v", err, ErrInvalidRun)
+	}
+	if _, err := store.AllocateRun(int(store.mapPageBits())); err != ErrInvalidRun {
+		t.Fatalf("expected %v == %v", err, ErrInvalidRun)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Free(pageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckFreeSpace(); err != nil {
+		t.Fatal(err)
+	}
+	// The map's own page can't be free.
+	store.freeSpace.setFree(store.freeSpace.mapPageID(0), true)
+	if err := store.CheckFreeSpace(); !errors.Is(err, ErrFreeSpaceCorrupt) {
+		t.Fatalf("expected %v == %v", err, ErrFreeSpaceCorrupt)
+	}
+	store.freeSpace.setFree(store.freeSpace.mapPageID(0), false)
+	// Nor can a page past the end of the file.
+	store.freeSpace.setFree(PageID(store.header.size), true)
+	if err := store.CheckFreeSpace(); !errors.Is(err, ErrFreeSpaceCorrupt) {
+		t.Fatalf("expected %v == %v", err, ErrFreeSpaceCorrupt)
+	}
+	store.freeSpace.setFree(PageID(store.header.size), false)
+}
+
+func TestCheckFreeList(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20), WithFormatVersion(FormatVersion2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing the store would walk the broken list, so it's abandoned instead.
+	defer store.Abandon()
+	var pages []PageID
+	for i := 0; i < 3; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	for _, pageID := range pages[:2] {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CheckFreeSpace(); err != nil {
+		t.Fatal(err)
+	}
+	expectFree(t, store, pages[:2]...)
+	// A free page that links back to the start of the list makes it run in a cycle.
+	page, err := store.Load(pages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.putFreeLink(page.Buf[:], uint64(pages[1])*PageSize)
+	if err := store.Write(pages[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckFreeSpace(); !errors.Is(err, ErrFreeSpaceCorrupt) {
+		t.Fatalf("expected %v == %v", err, ErrFreeSpaceCorrupt)
+	}
+}
+
+func TestShrinkFreeSpace(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "shrink_free_space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var pages []PageID
+	for i := 0; i < 20; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	for _, pageID := range append(append([]PageID{}, pages[5:10]...), pages[15:]...) {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Shrink(true); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, tmpfile.Name()); size != int64(pages[15])*PageSize {
+		t.Fatalf("expected %v == %v", size, int64(pages[15])*PageSize)
+	}
+	// The punched pages are still free, and read back as zeros once they're allocated.
+	expectFree(t, store, pages[5:10]...)
+	if err := store.CheckFreeSpace(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageID != pages[5] {
+		t.Fatalf("expected %v == %v", pageID, pages[5])
+	}
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Release()
+	assertBufEqual(t, page.Buf[:], make([]byte, PageSize))
+}
+
+func TestFreeSpaceCOW(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+	store.Begin()
+	// Writing the page in a group moves it, and the page it moved from is only freed once
+	// the group is committed.
+	writePages(t, store, []PageID{pageID}, 2)
+	expectFree(t, store)
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectFree(t, store, pageID)
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	expectFree(t, reopened, pageID)
+	if err := reopened.CheckFreeSpace(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectFree checks that the free pages are exactly the expected ones.
+func expectFree(t *testing.T, store *PageStore, expected ...PageID) {
+	t.Helper()
+	free, err := store.FreePageIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(free) != len(expected) {
+		t.Fatalf("expected %v == %v", free, expected)
+	}
+	for i := range free {
+		if free[i] != expected[i] {
+			t.Fatalf("expected %v == %v", free, expected)
+		}
+	}
+	count, err := store.FreePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(expected) {
+		t.Fatalf("expected %v == %v", count, len(expected))
+	}
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"errors"
+)
+
+// ErrHandleReleased is returned when releasing a page handle that has already been
+// released.
+var ErrHandleReleased = errors.New("page handle already released")
+
+// PageHandle is a page loaded into the cache. Every handle holds its own pin on the page,
+// so the page can't be evicted while any handle to it is held, however many users load
+// it at once, and releasing a handle only ever gives back the pin that it took. Once a
+// handle has been released, the page it points at may be evicted and replaced by another
+// page, so it mustn't be used any more.
+//
+// The page's id is kept up to date if the page is moved in copy-on-write mode, see
+// EnableCOW.
+//
+// Handles returned by View may instead point into the store's memory mapping, in which
+// case they don't pin anything and Page is nil, see ReadOnly.
+type PageHandle struct {
+	*Page
+	store    *PageStore
+	released bool
+	// view is the page in the mapping for handles that point into it, and viewID is its
+	// id.
+	view   *[PageSize]byte
+	viewID PageID
+}
+
+// Contents returns the part of the page that's free for its contents, see ContentSize.
+// Handles that weren't loaded from a store have the whole usable page.
+func (h *PageHandle) Contents() []byte {
+	buf := h.view
+	if buf == nil {
+		buf = &h.Buf
+	}
+	if h.store == nil {
+		return buf[:UsablePageSize]
+	}
+	return buf[:h.store.ContentSize()]
+}
+
+// ReadOnly returns whether the handle points into the store's memory mapping rather
+// than the cache, see View. The page can only be read through Contents, and mustn't be
+// changed.
+func (h *PageHandle) ReadOnly() bool {
+	return h.view != nil
+}
+
+// pageID returns the id of the page the handle points at.
+func (h *PageHandle) pageID() PageID {
+	if h.view != nil {
+		return h.viewID
+	}
+	return h.ID
+}
+
+// Release unpins the page. It's usually deferred straight after the page is loaded.
+func (h *PageHandle) Release() error {
+	s := h.store
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	shard := s.shard(h.pageID())
+	shard.Lock()
+	defer shard.Unlock()
+	if h.released {
+		return ErrHandleReleased
+	}
+	if h.view == nil {
+		err := s.release(h.ID)
+		if err != nil {
+			return err
+		}
+	}
+	h.released = true
+	if s.handles != nil {
+		s.handlesLock.Lock()
+		delete(s.handles, h)
+		s.handlesLock.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPageHandlesShareAPage(t *testing.T) {
+	store, err := newPageStore("page_handles_share_a_page", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				page, err := store.Load(pageID)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := page.Release(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	// Every pin was given back, so the page can be evicted.
+	if store.pins[store.shard(pageID).lookup[pageID]] != 0 {
+		t.Fatalf("expected %v == %v", store.pins[store.shard(pageID).lookup[pageID]], 0)
+	}
+}
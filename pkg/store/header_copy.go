@@ -0,0 +1,125 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// headerCopyID is the page the second copy of the header is kept in by files in
+	// FormatVersion4 and later.
+	headerCopyID PageID = 1
+	// generationOffset is where the header keeps its generation, after the log sequence
+	// number of the last checkpoint.
+	generationOffset = checkpointLSNOffset + 8
+)
+
+// Files in FormatVersion4 and later keep two copies of the header, in their first two
+// pages, so that a header torn by a crash, or corrupted since it was written, doesn't
+// lose the whole file. Every write of the header moves it on to its next generation and
+// goes to the copy the generation picks, alternating between them, so a write can only
+// ever tear one copy, and the other still holds the header as it was before. The newest
+// copy that matches its checksum is the one loaded when the file is opened.
+
+// headerPages returns the number of pages the header takes up in files in the format.
+func headerPages(version uint32) PageID {
+	if version >= FormatVersion4 {
+		return 2
+	}
+	return 1
+}
+
+// isHeader returns whether a page holds the header or its copy.
+func (s *PageStore) isHeader(pageID PageID) bool {
+	return pageID < headerPages(s.header.version)
+}
+
+// headerImageID returns the page an image of a page is written to, which is the page
+// itself for every page but the header of files in FormatVersion4 and later, whose
+// generation picks which of its copies it's written to.
+func headerImageID(pageID PageID, image []byte) PageID {
+	if pageID != 0 || binary.LittleEndian.Uint32(image[32:36]) < FormatVersion4 {
+		return pageID
+	}
+	return PageID(headerGeneration(image) % 2)
+}
+
+// headerGeneration returns the generation of a header image, see headerPage.generation.
+func headerGeneration(image []byte) uint64 {
+	return binary.LittleEndian.Uint64(image[generationOffset:])
+}
+
+// nextGeneration moves the header on to its next generation as it's about to be written
+// to the file, in files with a copy of it.
+func (p *headerPage) nextGeneration() {
+	if p.version >= FormatVersion4 {
+		p.generation++
+	}
+}
+
+// stampGeneration moves the header on to its next generation as buf, an image of it,
+// is about to be written to the file, so that it's written to the other copy from last
+// time, see headerImageID. Images of other pages are left alone.
+func (s *PageStore) stampGeneration(pageID PageID, buf *[PageSize]byte) {
+	if pageID != 0 || s.header == nil {
+		return
+	}
+	s.header.nextGeneration()
+	binary.LittleEndian.PutUint64(buf[generationOffset:], s.header.generation)
+}
+
+// isHeaderCopy returns whether a page holds a copy of the header of a file in
+// FormatVersion4 or later that matches its checksum.
+func isHeaderCopy(buf *[PageSize]byte) bool {
+	return *buf != [PageSize]byte{} && validPageChecksum(buf) &&
+		binary.LittleEndian.Uint32(buf[0:4]) == MagicNumber &&
+		binary.LittleEndian.Uint32(buf[32:36]) >= FormatVersion4
+}
+
+// mayHaveHeaderCopy returns whether the header may be in its second copy given what's
+// in the first page of the file, which failed its checksum if torn: files that have one
+// only ever write the header to the first page every other time, so it can also be empty.
+func mayHaveHeaderCopy(first *[PageSize]byte, torn bool) bool {
+	return torn || *first == [PageSize]byte{} || isHeaderCopy(first)
+}
+
+// useHeaderCopy returns whether the header should be read from its second copy rather
+// than from the first page, which failed its checksum if torn.
+func useHeaderCopy(first *[PageSize]byte, torn bool, second *[PageSize]byte) bool {
+	if !isHeaderCopy(second) {
+		return false
+	}
+	if torn || *first == [PageSize]byte{} {
+		return true
+	}
+	return headerGeneration(second[:]) > headerGeneration(first[:])
+}
+
+// loadHeader loads the header into the first slot of the page cache, from whichever of
+// its copies is newest and matches its checksum.
+func (s *PageStore) loadHeader() error {
+	err := s.loadPage(0, 0)
+	var mismatch *ErrChecksumMismatch
+	torn := errors.As(err, &mismatch)
+	if err != nil && !torn {
+		return err
+	}
+	first := &s.cache[0].Buf
+	if !mayHaveHeaderCopy(first, torn) {
+		return nil
+	}
+	var second [PageSize]byte
+	n, readErr := s.readPage(headerCopyID, &second)
+	if readErr != nil && (readErr != io.EOF || n < PageSize) {
+		return err
+	}
+	if !useHeaderCopy(first, torn, &second) {
+		return err
+	}
+	if torn {
+		s.logger.Warn("loaded the header from its copy", "page", headerCopyID)
+	}
+	*first = second
+	return nil
+}
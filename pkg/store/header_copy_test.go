@@ -0,0 +1,123 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHeaderCopy(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "header_copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both copies hold the header, a generation apart.
+	var copies [2][PageSize]byte
+	for i := range copies {
+		copy(copies[i][:], readPageFromFile(t, tmpfile.Name(), PageID(i)))
+		if !isHeaderCopy(&copies[i]) {
+			t.Fatalf("expected page %v to hold a copy of the header", i)
+		}
+	}
+	newest := headerGeneration(copies[0][:])
+	older := headerGeneration(copies[1][:])
+	if older > newest {
+		newest, older = older, newest
+	}
+	if newest != older+1 {
+		t.Fatalf("expected %v == %v", newest, older+1)
+	}
+
+	// Corrupting the newest copy leaves the file to be opened from the other.
+	corruptPage(t, tmpfile.Name(), PageID(newest%2))
+	reopened, err := NewPageStore(tmpfile.Name(), WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.header.generation != older {
+		t.Fatalf("expected %v == %v", reopened.header.generation, older)
+	}
+	if reopened.header.size != uint64(pageID)+1 {
+		t.Fatalf("expected %v == %v", reopened.header.size, uint64(pageID)+1)
+	}
+	expectContents(t, reopened, pageID, 1)
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next write of the header replaces the corrupt copy.
+	reopened, err = NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf := readPageFromFile(t, tmpfile.Name(), PageID(newest%2))
+	copy(copies[0][:], buf)
+	if !isHeaderCopy(&copies[0]) {
+		t.Fatal("expected the corrupt copy to have been rewritten")
+	}
+
+	// A file can't be opened once both copies are corrupt.
+	corruptPage(t, tmpfile.Name(), 0)
+	corruptPage(t, tmpfile.Name(), 1)
+	_, err = NewPageStore(tmpfile.Name())
+	var mismatch *ErrChecksumMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected %v to be a checksum mismatch", err)
+	}
+}
+
+func TestHeaderCopyOlderFormats(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "header_copy_older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithFormatVersion(FormatVersion3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The free-space map takes the page the copy is kept in by newer formats.
+	if !store.freeSpace.isMapPage(headerCopyID) {
+		t.Fatalf("expected page %v to hold the map", headerCopyID)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptPage(t, tmpfile.Name(), 0)
+	_, err = NewPageStore(tmpfile.Name())
+	var mismatch *ErrChecksumMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected %v to be a checksum mismatch", err)
+	}
+}
+
+// corruptPage overwrites part of a page of a file that isn't open.
+func corruptPage(t *testing.T, filename string, pageID PageID) {
+	t.Helper()
+	file, err := os.OpenFile(filename, os.O_RDWR, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt([]byte("corrupt"), int64(pageID)*PageSize+100); err != nil {
+		t.Fatal(err)
+	}
+}
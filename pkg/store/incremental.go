@@ -0,0 +1,217 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrWrongBase is returned when applying an incremental backup to a file other than the
+// one its base backup restores.
+var ErrWrongBase = errors.New("incremental backup was taken against another backup")
+
+const (
+	// incrementalMagicNumber is found in the first four bytes of an incremental backup.
+	incrementalMagicNumber = 0x424B5549
+	// incrementalVersion is the version of the layout of incremental backups. Those in
+	// version 1, which aren't stamped with a store ID, are still applied.
+	incrementalVersion = 2
+	// incrementalHeaderSize is the size of the header an incremental backup starts with:
+	// its magic number, version, the number of pages in the file it was taken of, the
+	// number of pages in its base and a digest of them, the number of pages that follow
+	// it and the store ID of the file. The header of version 1 stops before the store ID.
+	incrementalHeaderSize  = incrementalHeaderSize1 + storeIDSize
+	incrementalHeaderSize1 = 4 + 4 + 8 + 8 + sha256.Size + 8
+	// incrementalRecordSize is the size of each page of an incremental backup, which is
+	// preceded by its page id and followed by a CRC-32 of both.
+	incrementalRecordSize = 8 + PageSize + 4
+)
+
+// WriteIncrementalTo writes the pages of the backup that differ from those in base, a
+// backup of the same file taken earlier with WriteTo, to w, and returns the number of
+// bytes written. base is read to the end first. ApplyIncremental turns the file restored
+// from base into the one the backup is of, so each incremental backup only needs its
+// base, rather than the incremental backups taken before it, and it's refused by any
+// other file. ErrWrongStore is returned if base was taken of another file. Since the
+// pages are compared as they're laid out in the file, every page of an encrypted file
+// differs once its key is rotated.
+func (b *Backup) WriteIncrementalTo(w io.Writer, base io.Reader) (int64, error) {
+	if b.closed {
+		return 0, ErrBackupClosed
+	}
+	baseSize, digest, changed, err := b.compare(base)
+	if err != nil {
+		return 0, err
+	}
+	var written int64
+	header := make([]byte, incrementalHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], incrementalMagicNumber)
+	binary.LittleEndian.PutUint32(header[4:8], incrementalVersion)
+	binary.LittleEndian.PutUint64(header[8:16], b.size)
+	binary.LittleEndian.PutUint64(header[16:24], baseSize)
+	copy(header[24:24+sha256.Size], digest)
+	binary.LittleEndian.PutUint64(header[24+sha256.Size:], uint64(len(changed)))
+	copy(header[incrementalHeaderSize1:], b.id[:])
+	n, err := w.Write(header)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	record := make([]byte, incrementalRecordSize)
+	for _, pageID := range changed {
+		binary.LittleEndian.PutUint64(record[0:8], uint64(pageID))
+		err = b.readImage(pageID, record[8:8+PageSize])
+		if err != nil {
+			return written, err
+		}
+		checksum(record)
+		n, err = w.Write(record)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// compare reads the base of an incremental backup, returning the number of pages in it,
+// a digest of them, and the pages of the backup that differ from it, in order.
+func (b *Backup) compare(base io.Reader) (uint64, []byte, []PageID, error) {
+	baseSize, id, err := readBackupHeader(base)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if !id.matches(b.id) {
+		return 0, nil, nil, ErrWrongStore
+	}
+	digest := sha256.New()
+	var changed []PageID
+	record := make([]byte, PageSize+4)
+	image := make([]byte, PageSize)
+	for pageID := PageID(0); pageID < PageID(baseSize); pageID++ {
+		_, err = io.ReadFull(base, record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, nil, nil, ErrBackupCorrupt
+		}
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if !validChecksum(record) {
+			return 0, nil, nil, pageError("compare", pageID, ErrBackupCorrupt)
+		}
+		digest.Write(record[:PageSize])
+		if uint64(pageID) >= b.size {
+			continue
+		}
+		err = b.readImage(pageID, image)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if !bytes.Equal(image, record[:PageSize]) {
+			changed = append(changed, pageID)
+		}
+	}
+	for pageID := PageID(baseSize); pageID < PageID(b.size); pageID++ {
+		changed = append(changed, pageID)
+	}
+	return baseSize, digest.Sum(nil), changed, nil
+}
+
+// ApplyIncremental writes the pages of an incremental backup taken with
+// WriteIncrementalTo over the file kept in backend, which has to be the file restored
+// from its base backup with Restore, and syncs it. ErrWrongBase is returned for any other
+// file, or ErrWrongStore if it was taken of another file altogether, either of which
+// is left as it was. The backup is checked as it's read, and
+// ErrBackupCorrupt is returned if it's cut short or damaged, in which case backend is
+// left holding part of the file.
+func ApplyIncremental(r io.Reader, backend Backend) error {
+	header := make([]byte, incrementalHeaderSize)
+	_, err := io.ReadFull(r, header[:incrementalHeaderSize1])
+	if err == nil && binary.LittleEndian.Uint32(header[4:8]) == incrementalVersion {
+		_, err = io.ReadFull(r, header[incrementalHeaderSize1:])
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrBackupCorrupt
+	}
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != incrementalMagicNumber {
+		return ErrBackupCorrupt
+	}
+	version := binary.LittleEndian.Uint32(header[4:8])
+	if version != 1 && version != incrementalVersion {
+		return ErrUnsupportedVersion
+	}
+	size := binary.LittleEndian.Uint64(header[8:16])
+	baseSize := binary.LittleEndian.Uint64(header[16:24])
+	count := binary.LittleEndian.Uint64(header[24+sha256.Size:])
+	var id StoreID
+	copy(id[:], header[incrementalHeaderSize1:])
+	// A file whose header can't be read is left to fail the comparison with the base.
+	if file, err := readHeader(backend); err == nil && !id.matches(file.id) {
+		return ErrWrongStore
+	}
+	digest, err := digestFile(backend, baseSize)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest.Sum(nil), header[24:24+sha256.Size]) {
+		return ErrWrongBase
+	}
+	record := make([]byte, incrementalRecordSize)
+	for i := uint64(0); i < count; i++ {
+		_, err = io.ReadFull(r, record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrBackupCorrupt
+		}
+		if err != nil {
+			return err
+		}
+		if !validChecksum(record) {
+			return ErrBackupCorrupt
+		}
+		pageID := PageID(binary.LittleEndian.Uint64(record[0:8]))
+		if uint64(pageID) >= size {
+			return pageError("restore", pageID, ErrBackupCorrupt)
+		}
+		n, err := backend.WriteAt(record[8:8+PageSize], pageOffset(pageID))
+		if err != nil {
+			return pageError("restore", pageID, err)
+		}
+		if n != PageSize {
+			return pageError("restore", pageID, ErrPageNotFullyWritten)
+		}
+	}
+	// The file may have been shrunk since the base was taken.
+	if size < baseSize {
+		err = backend.Truncate(int64(size) * PageSize)
+		if err != nil {
+			return err
+		}
+	}
+	return backend.Sync()
+}
+
+// digestFile returns a digest of the first size pages of the file kept in backend, as a
+// backup of it would have them.
+func digestFile(backend Backend, size uint64) (hash.Hash, error) {
+	digest := sha256.New()
+	buf := make([]byte, PageSize)
+	for pageID := PageID(0); pageID < PageID(size); pageID++ {
+		n, err := backend.ReadAt(buf, pageOffset(pageID))
+		if err == io.EOF {
+			// Pages past the end of the file read as zeros, as they do in a backup.
+			clear(buf[n:])
+			err = nil
+		}
+		if err != nil {
+			return nil, pageError("compare", pageID, err)
+		}
+		digest.Write(buf)
+	}
+	return digest, nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIncrementalBackup(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 20; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	var base bytes.Buffer
+	if err := store.Backup(&base); err != nil {
+		t.Fatal(err)
+	}
+
+	// One page changes and another is added after the base is taken.
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pages[3], pageID}, 2)
+	backup, err := store.StartBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var incremental bytes.Buffer
+	_, err = backup.WriteIncrementalTo(&incremental, bytes.NewReader(base.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Only the header, its copy and the two pages written are in it.
+	expected := incrementalHeaderSize + 4*incrementalRecordSize
+	if incremental.Len() != expected {
+		t.Fatalf("expected %v == %v", incremental.Len(), expected)
+	}
+
+	backend := NewMemoryBackend()
+	if err := Restore(bytes.NewReader(base.Bytes()), backend); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyIncremental(bytes.NewReader(incremental.Bytes()), backend); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range append(pages, pageID) {
+		b := byte(1)
+		if id == pages[3] || id == pageID {
+			b = 2
+		}
+		page, err := restored.Load(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(b)[:UsablePageSize])
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := restored.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once it has been applied, the file is no longer the base.
+	err = ApplyIncremental(bytes.NewReader(incremental.Bytes()), backend)
+	if err != ErrWrongBase {
+		t.Fatalf("expected %v == %v", err, ErrWrongBase)
+	}
+	damaged := append([]byte(nil), incremental.Bytes()...)
+	damaged[incrementalHeaderSize+100] ^= 1
+	backend = NewMemoryBackend()
+	if err := Restore(bytes.NewReader(base.Bytes()), backend); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyIncremental(bytes.NewReader(damaged), backend); err != ErrBackupCorrupt {
+		t.Fatalf("expected %v == %v", err, ErrBackupCorrupt)
+	}
+}
@@ -0,0 +1,222 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// ErrPageOutOfRange is returned when inspecting a page past the end of the file.
+var ErrPageOutOfRange = errors.New("page is past the end of the file")
+
+// PageKind is what a page of a file is used for, as far as the store can tell, see
+// Inspect.
+type PageKind int
+
+const (
+	// PageData is a page holding whatever the file is used for, such as a page of a tree.
+	PageData PageKind = iota
+	// PageHeader is the first page of the file, or the copy of it kept in the second by
+	// files in FormatVersion4 and later.
+	PageHeader
+	// PageFree is a page that's been freed and not allocated again.
+	PageFree
+	// PageFreeSpaceMap is a page of the free-space map of a file in FormatVersion3 or
+	// later.
+	PageFreeSpaceMap
+)
+
+func (k PageKind) String() string {
+	switch k {
+	case PageData:
+		return "data"
+	case PageHeader:
+		return "header"
+	case PageFree:
+		return "free"
+	case PageFreeSpaceMap:
+		return "free-space map"
+	}
+	return fmt.Sprintf("PageKind(%d)", int(k))
+}
+
+// Field is a field of a page as it's laid out in the file, for tools that show what a
+// page holds, see Inspect.
+type Field struct {
+	// Offset is where the field starts in the page, and Size is its length in bytes.
+	Offset int
+	Size   int
+	Name   string
+	// Value is the field's value written out as text.
+	Value string
+}
+
+// PageInfo is a page of a file along with what it's used for and the fields of it the
+// store knows about.
+type PageInfo struct {
+	ID   PageID
+	Kind PageKind
+	// Buf is a copy of the page as it's loaded, after it's decrypted.
+	Buf [PageSize]byte
+	// ContentSize is the part of the page free for its contents, see ContentSize.
+	ContentSize int
+	// Fields are the fields the store lays out in the page, in order. The contents of
+	// data pages are left to whoever wrote them.
+	Fields []Field
+}
+
+// Inspect loads a page and works out what it's used for, for debugging the format of a
+// file. Pages of the header, the free-space map and the free list have their fields
+// decoded, and every page has its checksum and any space the store keeps at the end of
+// it, see ContentSize.
+func (s *PageStore) Inspect(pageID PageID) (*PageInfo, error) {
+	s.headerLock.Lock()
+	size := s.header.size
+	kind := PageData
+	switch {
+	case s.isHeader(pageID):
+		kind = PageHeader
+	case s.freeSpace != nil && s.freeSpace.isMapPage(pageID):
+		kind = PageFreeSpaceMap
+	case s.freeSpace != nil && s.freeSpace.isFree(pageID):
+		kind = PageFree
+	}
+	s.headerLock.Unlock()
+	if uint64(pageID) >= size {
+		return nil, pageError("inspect", pageID, ErrPageOutOfRange)
+	}
+	if kind == PageData && s.freeSpace == nil {
+		free, err := s.FreePageIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range free {
+			if id == pageID {
+				kind = PageFree
+			}
+		}
+	}
+	page, err := s.Load(pageID)
+	if err != nil {
+		return nil, err
+	}
+	info := &PageInfo{ID: pageID, Kind: kind, Buf: page.Buf, ContentSize: s.ContentSize()}
+	err = page.Release()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case PageHeader:
+		info.Fields = headerFields(info.Buf[:])
+	case PageFreeSpaceMap:
+		info.Fields = s.mapFields(info)
+	case PageFree:
+		if s.freeSpace == nil {
+			info.Fields = []Field{{
+				Size:  s.PageIDSize(),
+				Name:  "next free",
+				Value: freeLinkValue(s.freeLink(info.Buf[:])),
+			}}
+		}
+	}
+	// The header isn't encrypted, so it doesn't use the space kept for the nonce and tag.
+	if kind != PageHeader && info.ContentSize < UsablePageSize {
+		info.Fields = append(info.Fields, Field{
+			Offset: info.ContentSize,
+			Size:   UsablePageSize - info.ContentSize,
+			Name:   "reserve",
+			Value:  "nonce and tag",
+		})
+	}
+	info.Fields = append(info.Fields, Field{
+		Offset: UsablePageSize,
+		Size:   4,
+		Name:   "checksum",
+		Value:  fmt.Sprintf("0x%08X", binary.LittleEndian.Uint32(info.Buf[UsablePageSize:])),
+	})
+	return info, nil
+}
+
+// mapFields returns the fields of a page of the free-space map, which is one field for
+// the bits of the pages it covers.
+func (s *PageStore) mapFields(info *PageInfo) []Field {
+	group := uint64(info.ID) / s.freeSpace.bits
+	first := group * s.freeSpace.bits
+	free := 0
+	for _, b := range info.Buf[:info.ContentSize] {
+		free += bits.OnesCount8(b)
+	}
+	return []Field{{
+		Size: info.ContentSize,
+		Name: "free-space map",
+		Value: fmt.Sprintf("pages %d to %d, %d free",
+			first, first+s.freeSpace.bits-1, free),
+	}}
+}
+
+// headerFields returns the fields of the header page in buf, laid out as fromBuffer
+// reads them.
+func headerFields(buf []byte) []Field {
+	var fields []Field
+	add := func(offset, size int, name string, value interface{}) {
+		fields = append(fields, Field{offset, size, name, fmt.Sprint(value)})
+	}
+	u32 := func(offset int) uint32 {
+		return binary.LittleEndian.Uint32(buf[offset:])
+	}
+	u64 := func(offset int) uint64 {
+		return binary.LittleEndian.Uint64(buf[offset:])
+	}
+	version := max(u32(32), FormatVersion1)
+	add(0, 4, "magic number", fmt.Sprintf("0x%08X", u32(0)))
+	if version == FormatVersion1 {
+		add(4, 4, "free list", freeLinkValue(uint64(u32(4))))
+		add(8, 4, "size", u32(8))
+		add(12, 4, "root", u32(12))
+	}
+	add(16, 4, "branching factor", u32(16))
+	add(20, 4, "flags", fmt.Sprintf("0x%X", u32(20)))
+	add(24, 4, "dirty", u32(24))
+	add(28, 4, "closed cleanly", u32(28))
+	if version != FormatVersion1 {
+		add(32, 4, "version", u32(32))
+		add(36, 8, "free list", freeLinkValue(u64(36)))
+		add(44, 8, "size", u64(44))
+		add(52, 8, "root", u64(52))
+	}
+	add(60, 8, "reserved", u64(60))
+	holes := min(int(u32(68)), maxHoles)
+	add(68, 4, "holes", holes)
+	add(76, 4, "reserve", u32(76))
+	add(80, 4, "key id", u32(80))
+	add(84, keyCheckSize, "key check", fmt.Sprintf("%x", buf[84:84+keyCheckSize]))
+	for i := 0; i < holes; i++ {
+		offset := holesOffset + i*holeSize
+		add(offset, holeSize, fmt.Sprintf("hole %d", i),
+			fmt.Sprintf("start %d, count %d", u64(offset), u64(offset+8)))
+	}
+	add(lsnOffset, 8, "lsn", u64(lsnOffset))
+	add(checkpointLSNOffset, 8, "checkpoint lsn", u64(checkpointLSNOffset))
+	if version >= FormatVersion4 {
+		add(generationOffset, 8, "generation", u64(generationOffset))
+	}
+	var id StoreID
+	copy(id[:], buf[storeIDOffset:storeIDOffset+storeIDSize])
+	add(storeIDOffset, storeIDSize, "store id", id)
+	add(writingOffset, 4, "writing", u32(writingOffset))
+	add(pageSizeOffset, 4, "page size", u32(pageSizeOffset))
+	add(byteOrderOffset, 4, "byte order", fmt.Sprintf("0x%08X", u32(byteOrderOffset)))
+	add(keyTypeOffset, 4, "key type", u32(keyTypeOffset))
+	add(featuresOffset, 4, "features", featuresValue(u32(featuresOffset)))
+	return fields
+}
+
+// freeLinkValue writes out a link in the free list, which holds the offset of the page
+// it points at, or zero at the end of the list.
+func freeLinkValue(link uint64) string {
+	if link == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("page %d (offset 0x%X)", link/PageSize, link)
+}
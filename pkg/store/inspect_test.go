@@ -0,0 +1,92 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	versions := []int{FormatVersion1, FormatVersion2, FormatVersion3, FormatVersion4}
+	for _, version := range versions {
+		store, err := NewMemoryPageStore(WithCacheSize(20), WithFormatVersion(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pages []PageID
+		for i := 0; i < 4; i++ {
+			pageID, err := store.Allocate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, pageID)
+		}
+		for _, pageID := range pages[:2] {
+			if err := store.Free(pageID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		header := inspectPage(t, store, 0, PageHeader)
+		expectField(t, header, "magic number", "0x4A414B45")
+		// Newer formats start with a page of the free-space map after the header, and
+		// the newest with a copy of the header before it.
+		switch version {
+		case FormatVersion4:
+			expectField(t, header, "size", "7")
+			inspectPage(t, store, headerCopyID, PageHeader)
+			inspectPage(t, store, 2, PageFreeSpaceMap)
+		case FormatVersion3:
+			expectField(t, header, "size", "6")
+			inspectPage(t, store, 1, PageFreeSpaceMap)
+		default:
+			expectField(t, header, "size", "5")
+			expectField(t, header, "free list", "page 2 (offset 0x2000)")
+		}
+		inspectPage(t, store, pages[2], PageData)
+		free := inspectPage(t, store, pages[1], PageFree)
+		if version < FormatVersion3 {
+			// The free list runs from the page freed last to the one freed before it.
+			expectField(t, free, "next free", "page 1 (offset 0x1000)")
+		}
+		expectField(t, free, "checksum", "")
+
+		_, err = store.Inspect(pages[3] + 1)
+		if !errors.Is(err, ErrPageOutOfRange) {
+			t.Fatalf("expected %v == %v", err, ErrPageOutOfRange)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// inspectPage inspects a page and checks what it's used for.
+func inspectPage(t *testing.T, store *PageStore, pageID PageID, kind PageKind) *PageInfo {
+	t.Helper()
+	info, err := store.Inspect(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != pageID || info.Kind != kind {
+		t.Fatalf("expected %v %v == %v %v", info.ID, info.Kind, pageID, kind)
+	}
+	return info
+}
+
+// expectField checks that a page has a field with the given value, or with any value if
+// value is empty. Where a field appears more than once, the last one counts.
+func expectField(t *testing.T, info *PageInfo, name, value string) {
+	t.Helper()
+	var found *Field
+	for i := range info.Fields {
+		if info.Fields[i].Name == name {
+			found = &info.Fields[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("expected a %q field in %v", name, info.Fields)
+	}
+	if value != "" && found.Value != value {
+		t.Fatalf("expected %v == %v", found.Value, value)
+	}
+}
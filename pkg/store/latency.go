@@ -0,0 +1,73 @@
+package store
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the buckets that commit latencies are counted
+// in, see CommitLatency.
+var LatencyBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// LatencyHistogram is a histogram of how long something took.
+type LatencyHistogram struct {
+	// Counts holds the number of latencies that fell in each of LatencyBuckets, that is
+	// above the bucket before it and at most the bucket's own bound, followed by the
+	// number above the last bucket.
+	Counts []uint64
+	// Count is the number of latencies counted, and Sum is all of them added up.
+	Count uint64
+	Sum   time.Duration
+}
+
+// latencyHistogram is the histogram behind a LatencyHistogram, which can be added to
+// concurrently.
+type latencyHistogram struct {
+	counts [len(LatencyBuckets) + 1]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+	h.count.Add(1)
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	l := LatencyHistogram{
+		Counts: make([]uint64, len(h.counts)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		l.Counts[i] = h.counts[i].Load()
+	}
+	return l
+}
+
+// CommitLatency returns a histogram of how long the outermost Commit of every group of
+// writes has taken since the store was opened, which includes writing the group to the
+// write-ahead log and syncing it when there is one.
+func (s *PageStore) CommitLatency() LatencyHistogram {
+	return s.commitLatency.snapshot()
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	h.observe(0)
+	h.observe(LatencyBuckets[0])
+	h.observe(LatencyBuckets[0] + 1)
+	h.observe(time.Hour)
+	l := h.snapshot()
+	if l.Count != 4 {
+		t.Fatalf("expected %v == %v", l.Count, 4)
+	}
+	if l.Sum != 2*LatencyBuckets[0]+1+time.Hour {
+		t.Fatalf("expected %v == %v", l.Sum, 2*LatencyBuckets[0]+1+time.Hour)
+	}
+	// A latency on a bucket's bound is counted in that bucket, and latencies above the
+	// last bucket are counted after it.
+	expected := make([]uint64, len(LatencyBuckets)+1)
+	expected[0] = 2
+	expected[1] = 1
+	expected[len(LatencyBuckets)] = 1
+	for i := range expected {
+		if l.Counts[i] != expected[i] {
+			t.Fatalf("expected %v == %v", l.Counts, expected)
+		}
+	}
+}
+
+func TestCommitLatency(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := store.CommitLatency()
+	// Only the outermost commit of nested groups is counted.
+	store.Begin()
+	store.Begin()
+	writePages(t, store, []PageID{pageID}, 1)
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if store.CommitLatency().Count != start.Count {
+		t.Fatalf("expected %v == %v", store.CommitLatency().Count, start.Count)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if store.CommitLatency().Count != start.Count+1 {
+		t.Fatalf("expected %v == %v", store.CommitLatency().Count, start.Count+1)
+	}
+}
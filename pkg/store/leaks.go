@@ -0,0 +1,78 @@
+package store
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+)
+
+// Leak is a page handle that was loaded but never released.
+type Leak struct {
+	PageID PageID
+	Handle *PageHandle
+	// Stack is the stack trace of the Load that returned the handle.
+	Stack string
+}
+
+// LeakError is returned by Close when leak detection is enabled and some page handles
+// were never released.
+type LeakError struct {
+	Leaks []Leak
+}
+
+func (e *LeakError) Error() string {
+	return fmt.Sprintf("%d page handles were never released", len(e.Leaks))
+}
+
+// EnableLeakDetection records the stack trace of every Load from now on until the handle
+// it returns is released, so that handles that are never released can be found with
+// Leaks, and are reported by Close. It's meant for debugging, since taking a stack trace
+// on every Load is slow.
+func (s *PageStore) EnableLeakDetection() {
+	s.Lock()
+	defer s.Unlock()
+	if s.handles == nil {
+		s.handles = map[*PageHandle]string{}
+	}
+}
+
+// Leaks returns the handles loaded since leak detection was enabled that haven't been
+// released yet, ordered by page id.
+func (s *PageStore) Leaks() []Leak {
+	s.Lock()
+	defer s.Unlock()
+	return s.leaks()
+}
+
+func (s *PageStore) leaks() []Leak {
+	s.handlesLock.Lock()
+	defer s.handlesLock.Unlock()
+	var leaks []Leak
+	for h, stack := range s.handles {
+		leaks = append(leaks, Leak{PageID: h.pageID(), Handle: h, Stack: stack})
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].PageID < leaks[j].PageID
+	})
+	return leaks
+}
+
+// newHandle returns a handle to a page that has just been loaded.
+func (s *PageStore) newHandle(page *Page) *PageHandle {
+	return s.track(&PageHandle{Page: page, store: s})
+}
+
+// newView returns a handle to a page read straight out of the mapping, see View.
+func (s *PageStore) newView(pageID PageID, buf *[PageSize]byte) *PageHandle {
+	return s.track(&PageHandle{store: s, view: buf, viewID: pageID})
+}
+
+// track records the stack trace of a new handle if leak detection is enabled.
+func (s *PageStore) track(h *PageHandle) *PageHandle {
+	if s.handles != nil {
+		s.handlesLock.Lock()
+		s.handles[h] = string(debug.Stack())
+		s.handlesLock.Unlock()
+	}
+	return h
+}
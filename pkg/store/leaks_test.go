@@ -0,0 +1,48 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLeaks(t *testing.T) {
+	store, err := newPageStore("leaks", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 3; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	// Loads from before leak detection was enabled aren't tracked.
+	if _, err := store.Load(pages[0]); err != nil {
+		t.Fatal(err)
+	}
+	store.EnableLeakDetection()
+	released, err := store.Load(pages[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := released.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(pages[2]); err != nil {
+		t.Fatal(err)
+	}
+	leaks := store.Leaks()
+	if len(leaks) != 1 || leaks[0].PageID != pages[2] {
+		t.Fatalf("expected %v to be a leak of page %d", leaks, pages[2])
+	}
+	if !strings.Contains(leaks[0].Stack, "TestLeaks") {
+		t.Fatalf("expected the stack to contain the leaking load:\n%s", leaks[0].Stack)
+	}
+	var leakErr *LeakError
+	if err := store.Close(); !errors.As(err, &leakErr) || len(leakErr.Leaks) != 1 {
+		t.Fatalf("expected a leak to be reported by close, got %v", err)
+	}
+}
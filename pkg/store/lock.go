@@ -0,0 +1,33 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned when opening a file that another page store holds a conflicting
+// lock on, see WithLockTimeout.
+var ErrLocked = errors.New("page store file is locked")
+
+// lockRetryInterval is how long to wait between attempts to lock a file.
+const lockRetryInterval = 10 * time.Millisecond
+
+// lockFile takes an advisory lock on the file, which is exclusive for stores that write
+// to the file and shared for read-only stores, so that any number of processes can read a
+// file at once but only while no process is writing it. The lock is let go when the file
+// is closed. If it's held by someone else, the lock is tried again until timeout has
+// passed.
+func lockFile(file *os.File, exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(file, exclusive)
+		if err != nil || locked {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWriterLocksFile(t *testing.T) {
+	if runtime.GOOS == "plan9" || runtime.GOOS == "js" || runtime.GOOS == "wasip1" {
+		t.Skip("files can't be locked on", runtime.GOOS)
+	}
+	tmpfile, err := ioutil.TempFile("", "writer_locks_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]Option{nil, {WithReadOnly()}} {
+		if _, err := NewPageStore(tmpfile.Name(), opts...); err != ErrLocked {
+			t.Fatalf("expected %v == %v", err, ErrLocked)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers share the file with each other, but not with writers.
+	readers := make([]*PageStore, 2)
+	for i := range readers {
+		readers[i], err = NewPageStore(tmpfile.Name(), WithReadOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewPageStore(tmpfile.Name()); err != ErrLocked {
+		t.Fatalf("expected %v == %v", err, ErrLocked)
+	}
+	for _, reader := range readers {
+		if err := reader.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Abandoning a store lets go of the file too.
+	store, err = NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	if runtime.GOOS == "plan9" || runtime.GOOS == "js" || runtime.GOOS == "wasip1" {
+		t.Skip("files can't be locked on", runtime.GOOS)
+	}
+	tmpfile, err := ioutil.TempFile("", "lock_timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = NewPageStore(tmpfile.Name(), WithLockTimeout(50*time.Millisecond))
+	if err != ErrLocked {
+		t.Fatalf("expected %v == %v", err, ErrLocked)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected opening the file to wait for the lock")
+	}
+
+	// The file is opened as soon as the lock is let go of.
+	closed := make(chan error)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		closed <- store.Close()
+	}()
+	reopened, err := NewPageStore(tmpfile.Name(), WithLockTimeout(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
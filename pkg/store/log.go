@@ -0,0 +1,83 @@
+package store
+
+import (
+	"log/slog"
+	"time"
+)
+
+// DefaultSlowFlushThreshold is how long writing the dirty pages back to the file has to
+// take for it to be logged as slow, unless the store is opened with
+// WithSlowFlushThreshold.
+const DefaultSlowFlushThreshold = 100 * time.Millisecond
+
+// discardLogger is the logger of stores opened without WithLogger.
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// Logger returns the logger the store logs what happens inside it with, see WithLogger.
+func (s *PageStore) Logger() *slog.Logger {
+	return s.logger
+}
+
+// logRecovery logs what was recovered when the store was opened, if anything.
+func (s *PageStore) logRecovery() {
+	r := s.recovery
+	if r.Unclean {
+		s.logger.Warn("page store was not closed cleanly")
+	}
+	if r.WrittenSinceSync {
+		s.logger.Warn("page store was written to and not synced before it was last closed")
+	}
+	if r.RecordsReplayed > 0 || r.RecordsDiscarded > 0 {
+		s.logger.Info("recovered page store from its write-ahead log",
+			"records_replayed", r.RecordsReplayed,
+			"pages_repaired", r.PagesRepaired,
+			"records_discarded", r.RecordsDiscarded)
+	}
+}
+
+// backgroundError logs an error hit by the flusher or periodic syncs, and keeps it to be
+// returned by the next Sync if it's the first. The store's lock must be held.
+func (s *PageStore) backgroundError(what string, err error) {
+	s.logger.Error("background "+what+" failed", "error", err)
+	if s.syncErr == nil {
+		s.syncErr = err
+	}
+}
+
+// flushTiming is when a flush started, along with the time spent on I/O and waiting to
+// write pages back by then.
+type flushTiming struct {
+	start    time.Time
+	io       int64
+	lockWait int64
+}
+
+func (s *PageStore) startFlush() flushTiming {
+	return flushTiming{
+		start:    time.Now(),
+		io:       s.counters.ioTime.Load(),
+		lockWait: s.counters.lockWait.Load(),
+	}
+}
+
+// logFlush logs a flush of pages dirty pages that took longer than the slow flush
+// threshold, with the time it spent writing to the file and waiting for writes of pages
+// by loads to finish.
+func (s *PageStore) logFlush(pages int, t flushTiming) {
+	took := time.Since(t.start)
+	if s.slowFlushThreshold <= 0 || took < s.slowFlushThreshold {
+		return
+	}
+	s.logger.Warn("slow flush",
+		"pages", pages,
+		"took", took,
+		"io", time.Duration(s.counters.ioTime.Load()-t.io),
+		"lock_wait", time.Duration(s.counters.lockWait.Load()-t.lockWait))
+}
+
+// lockWrites locks writeLock, counting the time spent waiting for it.
+func (s *PageStore) lockWrites() {
+	start := time.Now()
+	s.writeLock.Lock()
+	s.counters.lockWait.Add(int64(time.Since(start)))
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogCorruption(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 5)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.WriteAt([]byte{6}, pageOffset(pageID)+10); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	store, err = OpenBackend(backend, WithCacheSize(20), WithLogger(newTestLogger(&logs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Load(pageID); err == nil {
+		t.Fatalf("expected a checksum mismatch")
+	}
+	expectLogged(t, &logs, `level=ERROR msg="page failed its checksum" page=`)
+}
+
+func TestLogCacheFull(t *testing.T) {
+	var logs bytes.Buffer
+	store, err := NewMemoryPageStore(WithCacheSize(4), WithLogger(newTestLogger(&logs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// Pin pages until there's no room for another.
+	for {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Load(pageID)
+		if err == ErrPageCacheFull {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLogged(t, &logs, `level=WARN msg="page cache full of pinned pages"`)
+}
+
+func TestLogRecovery(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "log_recovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.wal.Append(pageID, pageFilledWith(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	logger := newTestLogger(&logs)
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Logger() != logger {
+		t.Fatalf("expected the store to keep its logger")
+	}
+	expectLogged(t, &logs, `level=WARN msg="page store was not closed cleanly"`)
+	expectLogged(t, &logs, `msg="recovered page store from its write-ahead log" `+
+		`records_replayed=1 pages_repaired=1 records_discarded=0`)
+}
+
+func TestLogSlowFlush(t *testing.T) {
+	var logs bytes.Buffer
+	store, err := NewMemoryPageStore(
+		WithCacheSize(20),
+		WithLogger(newTestLogger(&logs)),
+		WithSlowFlushThreshold(time.Nanosecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var pageIDs []PageID
+	for i := 0; i < 3; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageIDs = append(pageIDs, pageID)
+	}
+	writePages(t, store, pageIDs, 1)
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	expectLogged(t, &logs, `level=WARN msg="slow flush" pages=`)
+	expectLogged(t, &logs, ` io=`)
+	expectLogged(t, &logs, ` lock_wait=`)
+
+	logs.Reset()
+	store.slowFlushThreshold = 0
+	writePages(t, store, pageIDs, 2)
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("expected nothing to be logged, got %q", logs.String())
+	}
+}
+
+func TestLogNothingByDefault(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Logger().Enabled(context.Background(), slog.LevelError) {
+		t.Fatalf("expected nothing to be logged")
+	}
+}
+
+// newTestLogger returns a logger that writes everything to w as text without times.
+func newTestLogger(w *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func expectLogged(t *testing.T, logs *bytes.Buffer, expected string) {
+	t.Helper()
+	if !strings.Contains(logs.String(), expected) {
+		t.Fatalf("expected %q to be logged, got %q", expected, logs.String())
+	}
+}
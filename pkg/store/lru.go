@@ -0,0 +1,36 @@
+package store
+
+// LRU evicts the page that was released the longest time ago. It's the default policy.
+type LRU struct {
+	// unpinned holds the slots that can be evicted, least recently released first.
+	unpinned *slotList
+}
+
+// NewLRU creates an LRU policy for a cache with the given number of slots.
+func NewLRU(capacity int) *LRU {
+	return &LRU{unpinned: newSlotList(capacity)}
+}
+
+// Loaded implements EvictionPolicy.
+func (l *LRU) Loaded(cacheID int, pageID PageID, hit bool) {
+	l.unpinned.remove(cacheID)
+}
+
+// Released implements EvictionPolicy.
+func (l *LRU) Released(cacheID int) {
+	l.unpinned.push(cacheID)
+}
+
+// Evict implements EvictionPolicy.
+func (l *LRU) Evict(pageID PageID) (int, bool) {
+	id, ok := l.unpinned.first(func(int) bool { return true })
+	if ok {
+		l.unpinned.remove(id)
+	}
+	return id, ok
+}
+
+// Remove implements EvictionPolicy.
+func (l *LRU) Remove(cacheID int) {
+	l.unpinned.remove(cacheID)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"io"
+	"sync"
+)
+
+// MemoryBackend is a Backend that keeps pages in memory, for data that doesn't need to
+// outlive the process and for tests that don't want to touch the disk. Closing a store
+// leaves its pages in the backend, so a store can be opened on the same backend again.
+type MemoryBackend struct {
+	lock sync.RWMutex
+	buf  []byte
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+// NewMemoryPageStore initializes a page store in a new MemoryBackend.
+func NewMemoryPageStore(opts ...Option) (*PageStore, error) {
+	return OpenBackend(NewMemoryBackend(), opts...)
+}
+
+// ReadAt reads len(p) bytes at offset off, returning io.EOF if they run past the end.
+func (m *MemoryBackend) ReadAt(p []byte, off int64) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if off >= int64(len(m.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p at offset off, growing the backend if it's written past the end.
+func (m *MemoryBackend) WriteAt(p []byte, off int64) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	end := off + int64(len(p))
+	if end > int64(len(m.buf)) {
+		m.grow(end)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+// Sync does nothing, since there's nowhere more durable for the pages to go.
+func (m *MemoryBackend) Sync() error {
+	return nil
+}
+
+// Truncate cuts the backend off, or extends it with zeros, to size bytes.
+func (m *MemoryBackend) Truncate(size int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if size > int64(len(m.buf)) {
+		m.grow(size)
+		return nil
+	}
+	// The pages cut off are copied out of so that their memory can be reclaimed.
+	m.buf = append([]byte(nil), m.buf[:size]...)
+	return nil
+}
+
+// Close does nothing, leaving the pages for the next store opened on the backend.
+func (m *MemoryBackend) Close() error {
+	return nil
+}
+
+// Size returns the number of bytes in the backend.
+func (m *MemoryBackend) Size() int64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return int64(len(m.buf))
+}
+
+// grow extends the backend with zeros to size bytes.
+func (m *MemoryBackend) grow(size int64) {
+	if size <= int64(cap(m.buf)) {
+		m.buf = m.buf[:size]
+		return
+	}
+	buf := make([]byte, size, 2*size)
+	copy(buf, m.buf)
+	m.buf = buf
+}
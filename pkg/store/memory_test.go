@@ -0,0 +1,95 @@
+package store
+
+import (
+	"io"
+	"testing"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	m := NewMemoryBackend()
+	buf := make([]byte, 4)
+	if n, err := m.ReadAt(buf, 0); n != 0 || err != io.EOF {
+		t.Fatalf("expected %v, %v == 0, %v", n, err, io.EOF)
+	}
+	if _, err := m.WriteAt([]byte{1, 2}, 6); err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 8 {
+		t.Fatalf("expected %v == %v", m.Size(), 8)
+	}
+	// Reads that run past the end stop short, like they do for a file.
+	n, err := m.ReadAt(buf, 4)
+	if n != 4 || err != nil {
+		t.Fatalf("expected %v, %v == 4, nil", n, err)
+	}
+	assertBufEqual(t, buf, []byte{0, 0, 1, 2})
+	n, err = m.ReadAt(buf, 6)
+	if n != 2 || err != io.EOF {
+		t.Fatalf("expected %v, %v == 2, %v", n, err, io.EOF)
+	}
+	if err := m.Truncate(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Truncate(10); err != nil {
+		t.Fatal(err)
+	}
+	n, err = m.ReadAt(buf, 6)
+	if n != 4 || err != nil {
+		t.Fatalf("expected %v, %v == 4, nil", n, err)
+	}
+	assertBufEqual(t, buf, []byte{1, 0, 0, 0})
+}
+
+func TestPageStoreInMemory(t *testing.T) {
+	backend := NewMemoryBackend()
+	// A cache smaller than the pages written makes the store write pages back.
+	store, err := OpenBackend(backend, WithCacheSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != ErrNoFile {
+		t.Fatalf("expected %v == %v", err, ErrNoFile)
+	}
+	if err := store.EnableDoubleWrite(); err != ErrNoFile {
+		t.Fatalf("expected %v == %v", err, ErrNoFile)
+	}
+	var pages []PageID
+	for i := 0; i < 10; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writePages(t, store, []PageID{pageID}, byte(i))
+	}
+	if err := store.SetRoot(pages[0], 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pages are still in the backend once the store is closed.
+	reopened, err := OpenBackend(backend, WithCacheSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if !reopened.Recovery().ClosedCleanly {
+		t.Fatal("expected the store to have been closed cleanly")
+	}
+	if reopened.Root() != pages[0] {
+		t.Fatalf("expected %v == %v", reopened.Root(), pages[0])
+	}
+	for i, pageID := range pages {
+		page, err := reopened.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fill := pageFilledWith(byte(i))
+		assertBufEqual(t, page.Buf[:UsablePageSize], fill[:UsablePageSize])
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrMmapUnsupported is returned when opening a store with WithMmap on a platform that
+// files can't be memory-mapped on.
+var ErrMmapUnsupported = errors.New("memory-mapped files are not supported")
+
+// mapping is a read-only memory mapping of a store's file, which clean pages are read
+// straight out of, see View, and other pages are loaded from with a copy rather than a
+// read syscall. Pages are still written to the file itself, and the mapping shares the
+// operating system's page cache with the file, so it sees the writes straight away.
+//
+// More of the file is mapped than it holds, so that it doesn't have to be mapped again
+// every time a page is added to the end of it. Only the first size bytes are ever read
+// from the mapping, since touching the mapping past the end of the file faults.
+type mapping struct {
+	lock sync.RWMutex
+	file *os.File
+	data []byte
+	// retired holds the mappings the file outgrew, which are only unmapped when the
+	// mapping is closed, since views may still point into them.
+	retired [][]byte
+	// size is the size of the file when it was last checked.
+	size int64
+}
+
+func newMapping(file *os.File) (*mapping, error) {
+	m := &mapping{file: file}
+	return m, m.grow(0)
+}
+
+// readAt reads len(buf) bytes at offset off, returning io.EOF if they run past the end of
+// the file.
+func (m *mapping) readAt(buf []byte, off int64) (int, error) {
+	end := off + int64(len(buf))
+	m.lock.RLock()
+	if end > m.size {
+		// The file may have grown since it was last checked.
+		m.lock.RUnlock()
+		err := m.grow(end)
+		if err != nil {
+			return 0, err
+		}
+		m.lock.RLock()
+	}
+	defer m.lock.RUnlock()
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(buf, m.data[off:m.size])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// page returns the page at offset off in the mapping, or nil if it runs past the end of
+// the file.
+func (m *mapping) page(off int64) *[PageSize]byte {
+	end := off + PageSize
+	m.lock.RLock()
+	if end > m.size {
+		m.lock.RUnlock()
+		if m.grow(end) != nil {
+			return nil
+		}
+		m.lock.RLock()
+	}
+	defer m.lock.RUnlock()
+	if end > m.size {
+		return nil
+	}
+	return (*[PageSize]byte)(m.data[off:end])
+}
+
+// grow checks the size of the file, and maps it again if it has outgrown the mapping.
+// The mapping is at least doubled, and covers at least end bytes, which may be past the
+// end of the file.
+func (m *mapping) grow(end int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	info, err := m.file.Stat()
+	if err != nil {
+		return err
+	}
+	m.size = info.Size()
+	if m.size <= int64(len(m.data)) {
+		return nil
+	}
+	length := max(2*int64(len(m.data)), m.size, end)
+	data, err := mmap(m.file, length)
+	if err != nil {
+		return err
+	}
+	if m.data != nil {
+		m.retired = append(m.retired, m.data)
+	}
+	m.data = data
+	return nil
+}
+
+// truncated records that the file was cut off to size bytes, so that the part of the
+// mapping past the new end isn't read from.
+func (m *mapping) truncated(size int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.size = min(m.size, size)
+}
+
+func (m *mapping) close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	for _, data := range m.retired {
+		if unmapErr := munmap(data); err == nil {
+			err = unmapErr
+		}
+	}
+	m.data = nil
+	m.retired = nil
+	m.size = 0
+	return err
+}
+
+// mapFile memory-maps the store's file, see WithMmap.
+func (s *PageStore) mapFile() error {
+	file, ok := backendFile(s.backend)
+	if !ok {
+		return ErrNoFile
+	}
+	m, err := newMapping(file)
+	if err != nil {
+		return err
+	}
+	s.mapping = m
+	return nil
+}
+
+// readPage reads a page from the file into buf, through the mapping if there is one. The
+// page is copied out of the mapping, since buf is a cache slot the page is changed in,
+// see View for reading pages without the copy.
+func (s *PageStore) readPage(pageID PageID, buf *[PageSize]byte) (int, error) {
+	if s.mapping != nil {
+		return s.mapping.readAt(buf[:], pageOffset(pageID))
+	}
+	return s.backend.ReadAt(buf[:], pageOffset(pageID))
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+func mmap(file *os.File, length int64) ([]byte, error) {
+	return nil, ErrMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMmap(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	// A small cache writes pages back to the file while they're being written, so that
+	// they're loaded again from the mapping.
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(4), WithMmap())
+	if err == ErrMmapUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 50; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writePages(t, store, []PageID{pageID}, byte(i))
+	}
+	expectPages := func(store *PageStore, pages []PageID) {
+		t.Helper()
+		for i, pageID := range pages {
+			page, err := store.Load(pageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fill := pageFilledWith(byte(i))
+			assertBufEqual(t, page.Buf[:UsablePageSize], fill[:UsablePageSize])
+			if err := page.Release(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expectPages(store, pages)
+	// Pages past the end of the file load as zeros rather than faulting.
+	unwritten, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Load(unwritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:], make([]byte, PageSize))
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing cuts the free pages off the end of the file.
+	for _, pageID := range append(pages[40:], unwritten) {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(4), WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	expectPages(reopened, pages[:40])
+}
+
+func TestMmapNeedsFile(t *testing.T) {
+	_, err := OpenBackend(NewMemoryBackend(), WithMmap())
+	if err != ErrNoFile {
+		t.Fatalf("expected %v == %v", err, ErrNoFile)
+	}
+}
+
+func TestMmapView(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "mmap_view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(4), WithMmap())
+	if err == ErrMmapUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 20; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writePages(t, store, []PageID{pageID}, byte(i))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewPageStore(tmpfile.Name(), WithCacheSize(4), WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Clean pages are read straight out of the mapping, so many more of them can be held
+	// at once than fit in the cache, and none of them are loaded into it.
+	before := store.CacheStats()
+	var views []*PageHandle
+	for i, pageID := range pages[4:] {
+		view, err := store.View(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !view.ReadOnly() {
+			t.Fatalf("expected page %v to be read out of the mapping", pageID)
+		}
+		fill := pageFilledWith(byte(i + 4))
+		assertBufEqual(t, view.Contents(), fill[:store.ContentSize()])
+		views = append(views, view)
+	}
+	after := store.CacheStats()
+	if after.Misses != before.Misses || after.Loads != before.Loads {
+		t.Fatalf("expected %+v == %+v", after, before)
+	}
+	// The cache is still free for pages that are going to be changed.
+	page, err := store.Load(pages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	views = append(views, page)
+	for _, view := range views {
+		if err := view.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := views[0].Release(); err != ErrHandleReleased {
+		t.Fatalf("expected %v == %v", err, ErrHandleReleased)
+	}
+
+	// A page that's been changed in the cache is viewed there until it's written back.
+	writePages(t, store, pages[4:5], 0xff)
+	view, err := store.View(pages[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.ReadOnly() {
+		t.Fatalf("expected page %v to be viewed in the cache", pages[4])
+	}
+	fill := pageFilledWith(0xff)
+	assertBufEqual(t, view.Contents(), fill[:store.ContentSize()])
+	if err := view.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmap(file *os.File, length int64) ([]byte, error) {
+	fd := int(file.Fd())
+	return syscall.Mmap(fd, 0, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// DefaultCacheSize is the number of pages a store caches unless it's opened with
+// WithCacheSize.
+const DefaultCacheSize = 256
+
+var (
+	// ErrReadOnly is returned when writing to a store opened with WithReadOnly, or when
+	// opening a file read-only that doesn't hold a page store yet.
+	ErrReadOnly = errors.New("page store is read-only")
+	// ErrNeedsRecovery is returned when opening a file read-only whose write-ahead log
+	// still has pages to replay. Opening it for writing once recovers it.
+	ErrNeedsRecovery = errors.New("page store needs recovery")
+)
+
+// Option configures a page store as it's opened, see NewPageStore.
+type Option func(*options)
+
+type options struct {
+	cacheSize    int
+	newPolicy    func(capacity int) EvictionPolicy
+	durability   Durability
+	syncInterval time.Duration
+	readOnly     bool
+	version      int
+	lockTimeout  time.Duration
+	mmap         bool
+	ioUring      bool
+	// preallocation and doubling configure how the file grows, see WithPreallocation.
+	preallocation int64
+	doubling      bool
+	keys          KeyProvider
+	logger        *slog.Logger
+	slowFlush     time.Duration
+	archiver      WALArchiver
+	// checkpointBytes and checkpointInterval trigger checkpoints, see WithCheckpoints.
+	checkpointBytes    int64
+	checkpointInterval time.Duration
+	// walSegmentSize and walRetention split the log into segments, see WithWALSegments.
+	walSegmentSize int64
+	walRetention   int
+	// walBackend keeps the log in a backend, see WithWALBackend.
+	walBackend Backend
+	// refuseUncleanShutdown refuses files that were left part way through being written,
+	// see WithRefuseUncleanShutdown.
+	refuseUncleanShutdown bool
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		cacheSize: DefaultCacheSize,
+		newPolicy: func(capacity int) EvictionPolicy { return NewLRU(capacity) },
+		version:   CurrentFormatVersion,
+		logger:    discardLogger,
+		slowFlush: DefaultSlowFlushThreshold,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithCacheSize sets the number of pages kept in memory.
+func WithCacheSize(pages int) Option {
+	return func(o *options) {
+		o.cacheSize = pages
+	}
+}
+
+// WithEvictionPolicy chooses the policy used to evict pages from the cache, which is an
+// LRU by default. A large cache is split into shards that each have their own policy, so
+// newPolicy is called once for every shard with the number of slots in the whole cache.
+func WithEvictionPolicy(newPolicy func(capacity int) EvictionPolicy) Option {
+	return func(o *options) {
+		o.newPolicy = newPolicy
+	}
+}
+
+// WithDurability chooses when the file is synced, see SetDurability. The default is
+// SyncNone.
+func WithDurability(durability Durability, interval time.Duration) Option {
+	return func(o *options) {
+		o.durability = durability
+		o.syncInterval = interval
+	}
+}
+
+// WithReadOnly opens the file for reading only. Loading pages works as usual, but
+// anything that would write to the file returns ErrReadOnly, and the file is left
+// untouched when the store is closed.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithRefuseUncleanShutdown refuses to open a file that was written to, and neither
+// synced nor closed since, the last time it was open, returning ErrUncleanShutdown,
+// rather than only logging a warning, see RecoveryStats.WrittenSinceSync. Such a file
+// can be checked once it has been opened without the option.
+func WithRefuseUncleanShutdown() Option {
+	return func(o *options) {
+		o.refuseUncleanShutdown = true
+	}
+}
+
+// WithLockTimeout sets how long to wait for another page store, in this process or
+// another one, to let go of the file before giving up with ErrLocked. Stores that write
+// to the file lock it exclusively, while read-only stores share their lock with each
+// other. By default opening a locked file fails straight away.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithMmap memory-maps the file. Clean pages viewed with View are read straight out of
+// the mapping, without a copy and without taking a cache slot, and pages loaded with
+// Load, which are about to be changed, are copied out of it into the cache without a
+// read syscall. Writes go to the file as usual. It returns ErrNoFile for stores opened
+// with OpenBackend, and ErrMmapUnsupported on platforms without memory-mapped files.
+func WithMmap() Option {
+	return func(o *options) {
+		o.mmap = true
+	}
+}
+
+// WithIOUring reads and writes the file through an io_uring, see IOUringBackend, which
+// is experimental. Where there's no io_uring, the file is read and written as usual.
+func WithIOUring() Option {
+	return func(o *options) {
+		o.ioUring = true
+	}
+}
+
+// WithPreallocation extends the file chunk bytes at a time as pages are allocated from
+// the end of it, rather than a page at a time as they're written, which leaves the file
+// less fragmented on disk and changes its size less often. With doubling, the file is
+// extended by as much as it already holds when that's more than chunk, up to
+// maxDoublingChunk at a time. On Linux the space is allocated with fallocate, and
+// elsewhere the file is only extended with zeros. How far the file has been extended is
+// recorded in the header, so it isn't extended again when it's reopened.
+func WithPreallocation(chunk int64, doubling bool) Option {
+	return func(o *options) {
+		o.preallocation = chunk
+		o.doubling = doubling
+	}
+}
+
+// WithEncryption encrypts the pages written to the file with AES-GCM, using the keys
+// supplied by keys, and decrypts them as they're loaded, so pages are only ever
+// encrypted in the file and never in the cache. Everything but the header is encrypted.
+// Encryption has to be chosen when the file is created, because every page of an
+// encrypted file keeps room for its nonce and tag, see ContentSize. Encrypted files
+// can't be opened without it, and return ErrEncrypted, and files that aren't encrypted
+// return ErrNotEncrypted when they're opened with it.
+func WithEncryption(keys KeyProvider) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithFormatVersion creates the file in an older format than CurrentFormatVersion, so
+// that it can be read by older versions of this package. Files that already exist are
+// opened in the format they're in regardless.
+func WithFormatVersion(version int) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
+// WithLogger logs what happens inside the store to logger: what was recovered when the
+// file was opened, torn pages restored, slow flushes, see WithSlowFlushThreshold, a cache
+// too full of pinned pages to load another, pages that fail their checksum and errors
+// hit in the background. Stores log nothing by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithSlowFlushThreshold logs flushes of the dirty pages back to the file that take
+// threshold or longer, with the time they spent writing and waiting for other writes.
+// Zero turns logging slow flushes off.
+func WithSlowFlushThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.slowFlush = threshold
+	}
+}
+
+// WithWALArchive hands every group of writes committed to the write-ahead log to
+// archiver, see WALArchiver. Groups are only logged once the log has been enabled with
+// EnableWAL.
+func WithWALArchive(archiver WALArchiver) Option {
+	return func(o *options) {
+		o.archiver = archiver
+	}
+}
+
+// WithCheckpoints leaves the groups committed to the write-ahead log in it until a
+// checkpoint, rather than syncing the file and emptying the log on every commit, see
+// Checkpoint. A checkpoint is taken once the log has grown to bytes, and every interval,
+// and either trigger is left out if it's zero. Checkpoints are only taken once the log
+// has been enabled with EnableWAL, and when the store is closed.
+func WithCheckpoints(bytes int64, interval time.Duration) Option {
+	return func(o *options) {
+		o.checkpointBytes = bytes
+		o.checkpointInterval = interval
+	}
+}
+
+// WithWALSegments splits the write-ahead log into segments of about size bytes. Once the
+// log's file has grown to size, the group that took it there is the last one in it, and
+// it's completed: it's renamed after the log sequence numbers of its first and last
+// groups, and logging carries on in an empty file. Groups stay in the log until a
+// checkpoint, see WithCheckpoints, or without checkpoints, until every commit has synced
+// the file, and completed segments are kept until every group in them has been synced
+// to the file, along with the last retention segments after that, for tools that
+// archive or replicate them, see WALSegments.
+func WithWALSegments(size int64, retention int) Option {
+	return func(o *options) {
+		o.walSegmentSize = size
+		o.walRetention = retention
+	}
+}
+
+// WithWALBackend keeps the write-ahead log in backend rather than in a file alongside
+// the store's file, so that stores opened with OpenBackend can enable it with EnableWAL,
+// such as to crash test them on a CrashBackend along with a file of it, see NewFile.
+// The log is replayed from backend when the store is opened, so it has to be opened with
+// the same backend every time, and the backend is closed along with the store. A log
+// kept in a backend can't be split into segments, and WithWALSegments is ignored.
+func WithWALBackend(backend Backend) Option {
+	return func(o *options) {
+		o.walBackend = backend
+	}
+}
+
+// ReadOnly returns whether the store was opened with WithReadOnly.
+func (s *PageStore) ReadOnly() bool {
+	return s.readOnly
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "read_only_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 7)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A page committed to the log has to be replayed before the file can be read.
+	wal, err := OpenWAL(walFilename(tmpfile.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Append(pageID, pageFilledWith(8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPageStore(tmpfile.Name(), WithReadOnly()); err != ErrNeedsRecovery {
+		t.Fatalf("expected %v == %v", err, ErrNeedsRecovery)
+	}
+	recovered, err := NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recovered.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly, err := NewPageStore(tmpfile.Name(), WithReadOnly(), WithCacheSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !readOnly.ReadOnly() {
+		t.Fatal("expected the store to be read-only")
+	}
+	page, err := readOnly.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(8)[:UsablePageSize])
+	if err := readOnly.Write(pageID); err != ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrReadOnly)
+	}
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readOnly.Allocate(); err != ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrReadOnly)
+	}
+	if err := readOnly.Free(pageID); err != ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrReadOnly)
+	}
+	if err := readOnly.SetRoot(pageID, 4); err != ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrReadOnly)
+	}
+	if err := readOnly.EnableCOW(); err != ErrReadOnly {
+		t.Fatalf("expected %v == %v", err, ErrReadOnly)
+	}
+	if err := readOnly.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !readOnly.Recovery().ClosedCleanly {
+		t.Fatal("expected the file to have been closed cleanly")
+	}
+}
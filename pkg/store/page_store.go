@@ -1,16 +1,20 @@
 package store
 
 import (
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"io"
+	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // PageID represents the index of a page in a file. PageID multiplied with the PageSize
 // produces the byte index of a page in a file.
-type PageID uint32
+type PageID uint64
 
 // PageSize divides files into blocks of 4K.
 const PageSize = 4096
@@ -19,6 +23,23 @@ const PageSize = 4096
 // to ASCII for fun!)
 const MagicNumber = 0x4A414B45
 
+const (
+	// FormatVersion1 is the original file format, which stores page ids and offsets in 32
+	// bits. The free list is kept by byte offset, so only the first 4GB of it is usable.
+	FormatVersion1 = 1
+	// FormatVersion2 stores page ids and offsets in 64 bits.
+	FormatVersion2 = 2
+	// FormatVersion3 keeps track of free pages with a free-space map stored in pages of
+	// its own, rather than with a list linked through the free pages, see free_space.go.
+	FormatVersion3 = 3
+	// FormatVersion4 keeps a second copy of the header in the second page of the file,
+	// see header_copy.go, which moves the first page of the free-space map to the third.
+	FormatVersion4 = 4
+	// CurrentFormatVersion is the format new files are created in. Files in older formats
+	// are read and written in the format they're in.
+	CurrentFormatVersion = FormatVersion4
+)
+
 // Page holds the id of a page as well as the bytes found in the file at that index.
 type Page struct {
 	ID  PageID
@@ -49,159 +70,850 @@ var (
 	// ErrPageNotLoaded is returned when the request page id was not found in the page
 	// cache.
 	ErrPageNotLoaded = errors.New("page not loaded")
+	// ErrWALAndCOW is returned when enabling both a write-ahead log and copy-on-write
+	// mode, which are alternative ways of keeping the file consistent after a crash.
+	ErrWALAndCOW = errors.New("write-ahead log and copy-on-write can't both be enabled")
+	// ErrSnapshotNeedsCOW is returned when taking a snapshot without copy-on-write mode
+	// enabled.
+	ErrSnapshotNeedsCOW = errors.New("snapshots need copy-on-write mode")
+	// ErrSnapshotClosed is returned when using a snapshot after it has been closed.
+	ErrSnapshotClosed = errors.New("snapshot closed")
+	// ErrUnsupportedVersion is returned when opening a file in a format newer than
+	// CurrentFormatVersion, which was written by a newer version of this package, or when
+	// creating a file in a format that doesn't exist.
+	ErrUnsupportedVersion = errors.New("unsupported format version")
 )
 
 // PageStore is a paged file store. It takes care of reading and writing pages to a given
 // file, it keeps a cache of recently read pages in memory, and it provides a way to
 // allocate and free new pages.
 type PageStore struct {
-	sync.Mutex
-	file     *os.File
-	cache    []Page
-	lookup   map[PageID]int
-	freeList *FreeList
-	header   *headerPage
+	// The lock is held for reading to load and release pages, see cacheShard, and for
+	// writing by everything else.
+	sync.RWMutex
+	// writeLock serializes writing pages back between loads that only hold the lock for
+	// reading, because they share the double-write buffer and the unsynced flag. Pages
+	// are read and written at their own offsets, so the file itself isn't shared state.
+	writeLock sync.Mutex
+	// headerLock serializes allocating, freeing and the other changes to the header, which
+	// can happen at once when trees are written by many goroutines.
+	headerLock sync.Mutex
+	backend    Backend
+	cache      []Page
+	// pins counts the number of times the page in each cache slot has been loaded without
+	// being released.
+	pins []int
+	// dirty is set for each cache slot holding a page that has been written but not yet
+	// written back to the file, see flush, and dirtyPages counts the slots it's set for.
+	// Loads evicting dirty pages from different shards clean them at once, with the lock
+	// only held for reading, so the count is atomic.
+	dirty      []bool
+	dirtyPages atomic.Int64
+	shards     []*cacheShard
+	header     *headerPage
+	// filename is the name of the file the store is kept in, which the write-ahead log and
+	// double-write buffer are named after, or empty for stores opened with OpenBackend.
+	filename string
+	// mapping is the memory mapping pages are loaded from, or nil if they're read from the
+	// backend, see WithMmap.
+	mapping *mapping
+	// wal is the write-ahead log that writes go through, or nil if writes go straight to
+	// the file.
+	wal *WAL
+	// depth is the number of calls to Begin that have yet to be matched by a Commit.
+	depth int
+	// pending holds the pages written since the outermost Begin, which are only written to
+	// the file once they've been committed to the log. The order they were first written
+	// in is kept so that they're logged in a deterministic order.
+	pending      map[PageID]*[PageSize]byte
+	pendingOrder []PageID
+	// recovery describes the recovery run when the store was opened.
+	recovery RecoveryStats
+	// cow is set when grouped writes are copied to fresh pages rather than overwriting the
+	// pages they were read from, see EnableCOW.
+	cow bool
+	// fresh holds the pages allocated since the outermost Begin, which can be written in
+	// place because the file's last committed tree doesn't use them.
+	fresh map[PageID]bool
+	// relocated maps pages written since the outermost Begin to the fresh pages they were
+	// copied to.
+	relocated map[PageID]PageID
+	// deferredFrees holds the pages freed since the outermost Begin, which are only put
+	// on the free list once the group has been committed.
+	deferredFrees []PageID
+	// headerAtBegin is the header as it was at the outermost Begin.
+	headerAtBegin [PageSize]byte
+	// version counts the groups committed in copy-on-write mode.
+	version uint64
+	// snapshots counts the open snapshots of each version, see Snapshot.
+	snapshots map[uint64]int
+	// held holds the pages freed by groups committed while snapshots were open, which are
+	// only put on the free list once no snapshot can read them.
+	held []heldFrees
+	// doubleWrite is the buffer pages are copied to before they're written to the file,
+	// or nil if torn writes aren't guarded against, see EnableDoubleWrite.
+	doubleWrite *doubleWriteBuffer
+	// durability chooses when writes that go straight to the file are synced, and
+	// unsynced is set when there are writes that haven't been synced yet.
+	durability Durability
+	unsynced   bool
+	// stopSyncing stops the goroutine syncing the file with SyncPeriodic, and syncErr is
+	// the first error it or the flusher ran into.
+	stopSyncing chan struct{}
+	syncErr     error
+	// stopFlushing stops the flusher, see StartFlusher, and flushNow wakes it up early
+	// once flushThreshold bytes of pages are dirty.
+	stopFlushing   chan struct{}
+	flushNow       chan struct{}
+	flushThreshold int
+	// closed is set once the store has been closed, see Close.
+	closed bool
+	// readOnly is set when the store was opened with WithReadOnly.
+	readOnly bool
+	// handles maps the handles that haven't been released yet to the stack traces of the
+	// loads that returned them, or is nil if leaks aren't being detected, see
+	// EnableLeakDetection. It's guarded by handlesLock because handles are made and
+	// released with the store's lock only held for reading.
+	handles     map[*PageHandle]string
+	handlesLock sync.Mutex
+	// writeBacks counts the writes of cached pages back to the file, which tells
+	// prefetches whether a page may have changed while they were reading it.
+	writeBacks atomic.Uint64
+	// prefetches holds a token for every prefetch running, see Prefetch.
+	prefetches chan struct{}
+	// preallocation is the number of bytes the file is extended by at a time, and
+	// doubling is set if it's extended by as much as it holds when that's more, see
+	// WithPreallocation.
+	preallocation int64
+	doubling      bool
+	// freeSpace is the free-space map of files in FormatVersion3 and later, or nil for
+	// files in older formats, which keep a free list. It's guarded by headerLock.
+	freeSpace *freeSpace
+	// keys supplies the keys the store's pages are encrypted with, or is nil if they
+	// aren't encrypted, see WithEncryption. currentKey is the ID of the key pages are
+	// encrypted with as they're written, which is guarded by writeLock, and aeads caches
+	// a cipher for every key that has been used, guarded by aeadsLock.
+	keys       KeyProvider
+	currentKey uint32
+	aeads      map[uint32]cipher.AEAD
+	aeadsLock  sync.Mutex
+	// counters count what the cache has done, see CacheStats.
+	counters cacheCounters
+	// commitLatency counts how long commits take, see CommitLatency.
+	commitLatency latencyHistogram
+	// logger logs what happens inside the store, see WithLogger.
+	logger *slog.Logger
+	// slowFlushThreshold is how long a flush takes to be logged, see
+	// WithSlowFlushThreshold.
+	slowFlushThreshold time.Duration
+	// archiver is handed the groups committed to the write-ahead log, or is nil if they
+	// aren't archived, see WithWALArchive.
+	archiver WALArchiver
+	// checkpointBytes and checkpointInterval are the size the log grows to and the time
+	// between checkpoints that trigger one, either of which is zero if it doesn't, see
+	// WithCheckpoints. stopCheckpointing stops the goroutine taking checkpoints on the
+	// interval.
+	checkpointBytes    int64
+	checkpointInterval time.Duration
+	stopCheckpointing  chan struct{}
+	// walSegmentSize and walRetention split the log into segments, see WithWALSegments.
+	walSegmentSize int64
+	walRetention   int
+	// walBackend is the backend the log is kept in, or nil if it's kept in a file
+	// alongside the store's file, see WithWALBackend.
+	walBackend Backend
+	// backups counts the backups running, see StartBackup. Pages are allocated from the
+	// end of the file while there are any. It's guarded by headerLock.
+	backups int
 }
 
 // NewPageStore is used to initialize a page store for a given file.
 // If the file has yet to be used as a page store, it will be initialized.
-func NewPageStore(filename string, cacheCapacity int) (*PageStore, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0660)
+func NewPageStore(filename string, opts ...Option) (*PageStore, error) {
+	o := newOptions(opts)
+	flag := os.O_RDWR | os.O_CREATE
+	if o.readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filename, flag, 0660)
+	if err != nil {
+		return nil, err
+	}
+	// The lock is taken before recovery, which writes to the file.
+	err = lockFile(file, !o.readOnly, o.lockTimeout)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if o.ioUring {
+		backend, err := NewIOUringBackend(file)
+		if err == nil {
+			return openBackend(backend, filename, o)
+		}
+		if err != ErrIOUringUnsupported {
+			file.Close()
+			return nil, err
+		}
+	}
+	return openBackend(file, filename, o)
+}
+
+// OpenBackend initializes a page store kept in the given backend rather than in a file,
+// such as a MemoryBackend. The write-ahead log and double-write buffer are kept in files
+// alongside the store's file, so stores opened this way can't use them, and return
+// ErrNoFile from EnableDoubleWrite, and from EnableWAL unless the log is kept in a
+// backend of its own, see WithWALBackend.
+func OpenBackend(backend Backend, opts ...Option) (*PageStore, error) {
+	return openBackend(backend, "", newOptions(opts))
+}
+
+// openBackend initializes a page store kept in the backend, which is named filename if
+// it's a file. The backend is closed if it fails.
+func openBackend(backend Backend, filename string, o options) (*PageStore, error) {
+	shards, err := newCacheShards(o.cacheSize, o.newPolicy)
 	if err != nil {
+		backend.Close()
 		return nil, err
 	}
 	store := &PageStore{
-		file:   file,
-		cache:  make([]Page, cacheCapacity),
-		lookup: map[PageID]int{},
+		backend:            backend,
+		cache:              make([]Page, o.cacheSize),
+		pins:               make([]int, o.cacheSize),
+		dirty:              make([]bool, o.cacheSize),
+		shards:             shards,
+		filename:           filename,
+		pending:            map[PageID]*[PageSize]byte{},
+		readOnly:           o.readOnly,
+		prefetches:         make(chan struct{}, maxPrefetches),
+		preallocation:      o.preallocation,
+		doubling:           o.doubling,
+		logger:             o.logger,
+		slowFlushThreshold: o.slowFlush,
+		archiver:           o.archiver,
+		checkpointBytes:    o.checkpointBytes,
+		checkpointInterval: o.checkpointInterval,
+		walSegmentSize:     o.walSegmentSize,
+		walRetention:       o.walRetention,
+		walBackend:         o.walBackend,
+	}
+	if o.walBackend != nil {
+		store.walSegmentSize = 0
+		store.walRetention = 0
+	}
+	// Finish any writes that were committed to the log before the file was last closed,
+	// which may include writes to the header, before loading it.
+	if o.readOnly {
+		err = store.checkRecovered()
+	} else {
+		err = store.recover()
+	}
+	if err != nil {
+		backend.Close()
+		return nil, err
 	}
 
 	// Load the header page into the first slot of the page cache.
-	err = store.loadPage(PageID(0), 0)
+	err = store.loadHeader()
 	if err != nil {
+		backend.Close()
 		return nil, err
 	}
+	store.pins[0] = 1
 	store.header = &headerPage{
 		Page: &store.cache[0],
 	}
 	store.header.fromBuffer()
+	// Files written by a newer version of this package may be laid out in ways this one
+	// doesn't know about, so they're left alone rather than misread.
+	newer := store.header.version > CurrentFormatVersion
+	if store.header.magicNumber == MagicNumber && newer {
+		backend.Close()
+		return nil, ErrUnsupportedVersion
+	}
+	if store.header.magicNumber == MagicNumber {
+		err = store.header.checkFormat()
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+	}
 	// If the MagicNumber is not set, then we need to setup the page store.
+	var created bool
 	if store.header.magicNumber != MagicNumber {
+		if store.readOnly {
+			backend.Close()
+			return nil, ErrReadOnly
+		}
+		if o.version < FormatVersion1 || o.version > CurrentFormatVersion {
+			backend.Close()
+			return nil, ErrUnsupportedVersion
+		}
 		// Identify this file as a page store file.
 		store.header.magicNumber = MagicNumber
 		// A page has yet to be deallocated.
 		store.header.freeList = 0
-		// We're writing this header to the first page but the rest of the file is unused.
-		store.header.size = 1
+		store.header.version = uint32(o.version)
+		// We're writing this header to the first page, and its copy to the second in newer
+		// formats, but the rest of the file is unused.
+		store.header.size = uint64(headerPages(store.header.version))
+		// A tree has yet to be stored in this file.
+		store.header.root = 0
+		store.header.branchingFactor = 0
+		err = store.assignID()
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+		// Nothing has been synced yet.
+		store.header.writing = 1
+		created = true
+		// The space encrypted pages keep for their nonces and tags has to be set aside
+		// before anything is laid out in them.
+		if o.keys != nil {
+			err = store.newEncryption(o.keys)
+			if err != nil {
+				backend.Close()
+				return nil, err
+			}
+		}
+		if o.version >= FormatVersion3 {
+			err = store.newFreeSpace()
+			if err != nil {
+				backend.Close()
+				return nil, err
+			}
+		}
 		store.header.toBuffer()
 		err = store.Write(store.header.ID)
 		if err != nil {
+			backend.Close()
 			return nil, err
 		}
 	}
-
-	// Populate free list with the rest of the page cache slots because the cache is
-	// completely empty except the first slot.
-	store.freeList = NewFreeList(cacheCapacity)
-	for id := 1; id < cacheCapacity; id++ {
-		err := store.freeList.Enqueue(id)
+	// Record how the file was left when it was last open, then clear the marks so that
+	// they describe this time when the file is next opened.
+	store.recovery.Unclean = store.header.dirty != 0
+	store.recovery.ClosedCleanly = store.header.closedCleanly != 0
+	store.recovery.WrittenSinceSync = store.header.writing != 0 && !created
+	store.logRecovery()
+	if store.recovery.WrittenSinceSync && o.refuseUncleanShutdown {
+		backend.Close()
+		return nil, ErrUncleanShutdown
+	}
+	// Files written by older versions of this package are given a store ID at the same
+	// time.
+	marked := store.header.dirty != 0 || store.header.closedCleanly != 0 ||
+		store.recovery.WrittenSinceSync || store.header.id.IsZero()
+	if marked && !store.readOnly {
+		store.header.dirty = 0
+		store.header.closedCleanly = 0
+		store.header.writing = 0
+		err = store.assignID()
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+		store.header.toBuffer()
+		err = store.syncHeader()
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+	}
+	err = store.openEncryption(o.keys)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	if store.header.version >= FormatVersion3 && store.freeSpace == nil {
+		err = store.loadFreeSpace()
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+	}
+	if o.mmap {
+		err = store.mapFile()
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+	}
+	if o.durability != SyncNone {
+		err = store.SetDurability(o.durability, o.syncInterval)
 		if err != nil {
 			return nil, err
 		}
 	}
-
 	return store, nil
 }
 
-// Load reads a page from a file into memory.
-func (s *PageStore) Load(pageID PageID) (*Page, error) {
-	s.Lock()
-	defer s.Unlock()
-	cacheID, alreadyInCache := s.lookup[pageID]
+// Load reads a page from a file into memory. The page is pinned in the cache until the
+// handle returned for it has been released, along with every other handle to it. Pages
+// that are only going to be read can be viewed instead, see View.
+func (s *PageStore) Load(pageID PageID) (*PageHandle, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.load(s.resolve(pageID))
+}
+
+// load loads a page and returns a handle to it. The store's lock must be held for
+// reading.
+func (s *PageStore) load(pageID PageID) (*PageHandle, error) {
+	if s.closed {
+		return nil, ErrClosed
+	}
+	shard := s.shard(pageID)
+	shard.Lock()
+	defer shard.Unlock()
+	cacheID, alreadyInCache := shard.lookup[pageID]
 	if alreadyInCache {
-		return &s.cache[cacheID], nil
+		s.counters.hits.Add(1)
+		s.pins[cacheID]++
+		shard.policy.Loaded(cacheID, pageID, true)
+		return s.newHandle(&s.cache[cacheID]), nil
 	}
-	cacheID, noMoreSpace := s.nextFreeCacheSlot()
-	if noMoreSpace {
-		return nil, ErrPageCacheFull
+	s.counters.misses.Add(1)
+	cacheID, err := s.nextFreeCacheSlot(shard, pageID)
+	if err != nil {
+		return nil, err
 	}
-	err := s.loadPage(pageID, cacheID)
+	err = s.loadPage(pageID, cacheID)
 	if err != nil {
+		// Give the slot back rather than leaving a page that failed to load in the cache.
+		delete(shard.lookup, pageID)
+		shard.freeList.Enqueue(cacheID)
 		return nil, err
 	}
-	return &s.cache[pageID], nil
+	s.pins[cacheID] = 1
+	shard.policy.Loaded(cacheID, pageID, false)
+	return s.newHandle(&s.cache[cacheID]), nil
 }
 
-func (s *PageStore) nextFreeCacheSlot() (int, bool) {
-	id, err := s.freeList.Dequeue()
-	return id, err == ErrFreeListFull
+// View returns a read-only handle to a page. With WithMmap, a clean page that isn't in
+// the cache is read straight out of the mapping, without being copied or taking a cache
+// slot, and the handle's Page is nil, so the page can only be read through Contents, see
+// ReadOnly. Every other page is loaded as with Load. The mapping is read as the file is,
+// so the page mustn't be written while the handle is held, and a page that's going to
+// be changed must be loaded with Load, which copies it into the cache.
+func (s *PageStore) View(pageID PageID) (*PageHandle, error) {
+	s.RLock()
+	defer s.RUnlock()
+	pageID = s.resolve(pageID)
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if s.mapping == nil || s.keys != nil {
+		return s.load(pageID)
+	}
+	shard := s.shard(pageID)
+	shard.Lock()
+	_, cached := shard.lookup[pageID]
+	shard.Unlock()
+	if cached || s.pending[pageID] != nil {
+		return s.load(pageID)
+	}
+	buf := s.mapping.page(pageOffset(pageID))
+	// Pages that fail their checksum take the usual path, which reports the failure.
+	if buf == nil || !validPageChecksum(buf) {
+		return s.load(pageID)
+	}
+	return s.newView(pageID, buf), nil
 }
 
-func (s *PageStore) loadPage(pageID PageID, cacheID int) error {
-	err := s.seekPageStart(pageID)
-	if err != nil {
-		return err
+// EnableWAL sends every write through a write-ahead log kept in a file alongside the page
+// store's file, or in the backend given with WithWALBackend, so that a crash part way
+// through writing a group of pages can't leave the file with only some of them. See
+// Begin for grouping writes.
+func (s *PageStore) EnableWAL() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.readOnly {
+		return ErrReadOnly
 	}
-	n, err := s.file.Read(s.cache[cacheID].Buf[:])
-	s.cache[cacheID].ID = pageID
-	s.lookup[pageID] = cacheID
-	unwrittenPartOfFile := err == io.EOF
-	if unwrittenPartOfFile {
+	if s.wal != nil {
 		return nil
 	}
+	if s.filename == "" && s.walBackend == nil {
+		return ErrNoFile
+	}
+	if s.cow {
+		return ErrWALAndCOW
+	}
+	// Pages written from here on go through the log, so the ones still waiting to be
+	// written back have to reach the file first.
+	err := s.flush()
 	if err != nil {
 		return err
 	}
-	if n != PageSize {
-		return ErrPageNotFullyRead
+	// Mark the file as being written through the log until it's recovered, so that the
+	// next time it's opened the log is replayed. This is written straight to the file
+	// because the log isn't in use yet.
+	s.header.dirty = 1
+	s.header.writing = 0
+	s.header.toBuffer()
+	err = s.syncHeader()
+	if err != nil {
+		return err
 	}
+	wal, err := s.openWAL()
+	if err != nil {
+		return err
+	}
+	wal.id = s.header.id
+	s.wal = wal
+	s.startCheckpointing()
 	return nil
 }
 
-// Release pushes a page that was previously loaded into memory out of the cache so that
-// the slot can be used to load a different page.
-func (s *PageStore) Release(pageID PageID) error {
+// syncHeader writes the header straight to the file and syncs it to disk.
+func (s *PageStore) syncHeader() error {
+	err := s.writePage(s.header.ID, &s.header.Buf)
+	if err != nil {
+		return err
+	}
+	// The header always lives in the first cache slot.
+	s.setDirty(0, false)
+	return s.sync()
+}
+
+// Begin starts a group of writes which are committed to the write-ahead log together
+// when the matching Commit is called, so that after a crash either all of them or none
+// of them make it to the file. Groups can be nested, in which case the writes are
+// committed by the outermost Commit. Without a write-ahead log or copy-on-write mode,
+// writes go straight to the file and groups only decide when it's synced, see
+// SetDurability.
+func (s *PageStore) Begin() {
 	s.Lock()
 	defer s.Unlock()
-	cacheID, pageInCache := s.lookup[pageID]
-	if !pageInCache {
-		return ErrPageNotLoaded
+	if s.depth == 0 {
+		s.headerAtBegin = s.header.Buf
+	}
+	s.depth++
+}
+
+// Commit ends a group of writes started by Begin. Ending the outermost group appends the
+// pages written in the group to the write-ahead log, syncs the log to disk and only then
+// writes the pages to the file.
+func (s *PageStore) Commit() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.depth--
+	if s.depth > 0 {
+		return nil
+	}
+	start := time.Now()
+	defer func() { s.commitLatency.observe(time.Since(start)) }()
+	if s.cow {
+		return s.commitCOW()
+	}
+	err := s.commitPending()
+	if err != nil {
+		return err
+	}
+	return s.syncCommitted()
+}
+
+func (s *PageStore) commitPending() error {
+	if len(s.pendingOrder) == 0 {
+		return nil
+	}
+	// The header is logged with every group, recording the group's log sequence number.
+	s.header.lsn++
+	s.header.toBuffer()
+	s.stage(s.header.ID, &s.header.Buf)
+	err := s.logPending()
+	if err != nil {
+		return err
+	}
+	s.pending = map[PageID]*[PageSize]byte{}
+	s.pendingOrder = s.pendingOrder[:0]
+	err = s.wal.rotate()
+	if err != nil {
+		return err
+	}
+	if s.checkpointing() {
+		// The group stays in the log until the next checkpoint syncs the file.
+		return s.checkpointIfDue()
+	}
+	// The log can only be emptied once the pages are safely in the file.
+	err = s.sync()
+	if err != nil {
+		return err
+	}
+	return s.wal.Reset()
+}
+
+// logPending commits the pages written in the group to the log, hands them to the
+// archiver, and then writes them to the file. If the group can't be committed to the log,
+// whatever was logged of it is taken back.
+func (s *PageStore) logPending() error {
+	// Pages are logged as they're written to the file, encrypted, so that the log
+	// doesn't hold anything the file doesn't.
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	start := s.wal.size
+	images, err := s.appendPending()
+	if err != nil {
+		truncateErr := s.wal.truncate(start)
+		if truncateErr != nil {
+			s.logger.Error("write-ahead log left with a partly logged group",
+				"offset", start, "error", truncateErr)
+		}
+		return err
+	}
+	err = s.archive(s.wal, start)
+	if err != nil {
+		return err
+	}
+	for i, pageID := range s.pendingOrder {
+		err = s.writeImage(pageID, images[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendPending appends the pages written in the group to the log and commits them,
+// returning the images of the pages that were logged.
+func (s *PageStore) appendPending() ([]*[PageSize]byte, error) {
+	committed := time.Now()
+	images := make([]*[PageSize]byte, len(s.pendingOrder))
+	for i, pageID := range s.pendingOrder {
+		s.stampGeneration(pageID, s.pending[pageID])
+		image, err := s.encodePage(pageID, s.pending[pageID])
+		if err != nil {
+			return nil, err
+		}
+		images[i] = image
+		err = s.wal.Append(pageID, image)
+		if err != nil {
+			return nil, err
+		}
 	}
-	delete(s.lookup, pageID)
-	return s.releaseCacheSlot(cacheID)
+	return images, s.wal.CommitAt(s.header.lsn, committed)
 }
 
-func (s *PageStore) releaseCacheSlot(cacheID int) error {
-	return s.freeList.Enqueue(cacheID)
+// pinned returns the cache slot holding a page if it's loaded and hasn't been released
+// yet. A page that has been released may still be cached, but can't be used until it's
+// loaded again.
+func (s *PageStore) pinned(pageID PageID) (int, bool) {
+	cacheID, ok := s.shard(pageID).lookup[pageID]
+	if !ok || s.pins[cacheID] == 0 {
+		return 0, false
+	}
+	return cacheID, true
+}
+
+// nextFreeCacheSlot returns a free cache slot in a shard for pageID, evicting a page that
+// isn't pinned if there aren't any. A dirty page is written back before it's evicted.
+func (s *PageStore) nextFreeCacheSlot(shard *cacheShard, pageID PageID) (int, error) {
+	id, err := shard.freeList.Dequeue()
+	if err == nil {
+		return id, nil
+	}
+	id, ok := shard.policy.Evict(pageID)
+	if !ok {
+		s.logger.Warn("page cache full of pinned pages", "page", pageID,
+			"cache_size", len(s.cache))
+		return 0, ErrPageCacheFull
+	}
+	err = s.flushSlot(id)
+	if err != nil {
+		// The page stays cached, so give it back to the policy.
+		shard.policy.Loaded(id, s.cache[id].ID, false)
+		shard.policy.Released(id)
+		return 0, err
+	}
+	delete(shard.lookup, s.cache[id].ID)
+	s.counters.evictions.Add(1)
+	return id, nil
+}
+
+func (s *PageStore) loadPage(pageID PageID, cacheID int) error {
+	if buf, ok := s.pending[pageID]; ok {
+		// The page was written in the current group but hasn't made it to the file yet.
+		s.cache[cacheID].ID = pageID
+		s.cache[cacheID].Buf = *buf
+		s.shard(pageID).lookup[pageID] = cacheID
+		return nil
+	}
+	start := time.Now()
+	n, err := s.readPage(pageID, &s.cache[cacheID].Buf)
+	s.counters.observeIO(start)
+	s.counters.loads.Add(1)
+	s.cache[cacheID].ID = pageID
+	s.shard(pageID).lookup[pageID] = cacheID
+	unwrittenPartOfFile := err == io.EOF && n < PageSize
+	if unwrittenPartOfFile {
+		// The slot may hold the contents of a previously released page.
+		for i := n; i < PageSize; i++ {
+			s.cache[cacheID].Buf[i] = 0
+		}
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return pageError("load", pageID, err)
+	}
+	if n != PageSize {
+		return pageError("load", pageID, ErrPageNotFullyRead)
+	}
+	if !validPageChecksum(&s.cache[cacheID].Buf) {
+		s.logger.Error("page failed its checksum", "page", pageID)
+		return pageError("load", pageID, &ErrChecksumMismatch{PageID: pageID})
+	}
+	err = s.decodePage(pageID, &s.cache[cacheID].Buf)
+	if err != nil {
+		s.logger.Error("page failed to decode", "page", pageID, "error", err)
+	}
+	return pageError("load", pageID, err)
 }
 
-// Write dumps the contents of a pages buffer to the file.
+// release unpins a page that was previously loaded into memory. Once the page has been
+// released as many times as it was loaded, it stays in the cache until its slot is
+// needed to load a different page, see nextFreeCacheSlot. The store's lock must be held
+// for reading along with the lock of the page's shard, or held for writing.
+func (s *PageStore) release(pageID PageID) error {
+	if s.closed {
+		return ErrClosed
+	}
+	cacheID, pageInCache := s.pinned(pageID)
+	if !pageInCache {
+		return ErrPageNotLoaded
+	}
+	s.pins[cacheID]--
+	if s.pins[cacheID] == 0 {
+		s.shard(pageID).policy.Released(cacheID)
+	}
+	return nil
+}
+
+// Write dumps the contents of a pages buffer to the file. With a write-ahead log enabled,
+// the page is committed to the log first, along with the rest of its group if it's
+// written between Begin and Commit. In copy-on-write mode, a page written between Begin
+// and Commit is moved to a fresh page the first time it's written, see EnableCOW.
+// Otherwise the page is only marked dirty, and it's written back to the file once it's
+// evicted from the cache, or when the store is synced or closed.
 func (s *PageStore) Write(pageID PageID) error {
+	if s.cow && s.grouped() && pageID != s.header.ID {
+		var err error
+		pageID, err = s.relocate(pageID)
+		if err != nil {
+			return err
+		}
+	}
 	s.Lock()
 	defer s.Unlock()
-	cacheID, pageInCache := s.lookup[pageID]
+	if s.closed {
+		return ErrClosed
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	pageID = s.resolve(pageID)
+	cacheID, pageInCache := s.pinned(pageID)
 	if !pageInCache {
 		return ErrPageNotLoaded
 	}
-	page := s.cache[cacheID]
-	err := s.seekPageStart(pageID)
+	err := s.markWriting()
 	if err != nil {
 		return err
 	}
-	n, err := s.file.Write(page.Buf[:])
+	if s.cow && s.depth > 0 {
+		// The header is written when the group is committed.
+		if pageID != s.header.ID {
+			s.stage(pageID, &s.cache[cacheID].Buf)
+		}
+		return nil
+	}
+	if s.wal == nil {
+		if s.cow {
+			// Writes outside of a group overwrite the page in place.
+			err := s.writePage(pageID, &s.cache[cacheID].Buf)
+			if err != nil {
+				return err
+			}
+		} else {
+			s.setDirty(cacheID, true)
+		}
+		if s.depth > 0 {
+			return nil
+		}
+		return s.syncCommitted()
+	}
+	s.stage(pageID, &s.cache[cacheID].Buf)
+	if s.depth > 0 {
+		return nil
+	}
+	// A write outside of a group is a group of its own.
+	return s.commitPending()
+}
+
+// stage holds a copy of a page written in a group until the group is committed.
+func (s *PageStore) stage(pageID PageID, page *[PageSize]byte) {
+	buf, ok := s.pending[pageID]
+	if !ok {
+		buf = new([PageSize]byte)
+		s.pending[pageID] = buf
+		s.pendingOrder = append(s.pendingOrder, pageID)
+	}
+	*buf = *page
+}
+
+func (s *PageStore) grouped() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.depth > 0
+}
+
+func (s *PageStore) writePage(pageID PageID, buf *[PageSize]byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	s.stampGeneration(pageID, buf)
+	image, err := s.encodePage(pageID, buf)
 	if err != nil {
-		return err
+		return pageError("write", pageID, err)
+	}
+	return s.writeImage(pageID, image)
+}
+
+// writeImage writes a page as it's laid out in the file, once it has been checksummed
+// and encrypted, see encodePage. The header is written to whichever of its
+// copies its generation picks, see headerImageID. The write lock must be held.
+func (s *PageStore) writeImage(pageID PageID, buf *[PageSize]byte) error {
+	pageID = headerImageID(pageID, buf[:])
+	if s.doubleWrite != nil {
+		err := s.doubleWrite.save(pageID, buf)
+		if err != nil {
+			return pageError("write", pageID, err)
+		}
+	}
+	start := time.Now()
+	n, err := s.backend.WriteAt(buf[:], pageOffset(pageID))
+	s.counters.observeIO(start)
+	if err != nil {
+		return pageError("write", pageID, err)
 	}
 	if n != PageSize {
-		return ErrPageNotFullyWritten
+		return pageError("write", pageID, ErrPageNotFullyWritten)
+	}
+	s.unsynced = true
+	s.writeBacks.Add(1)
+	s.counters.writes.Add(1)
+	if s.doubleWrite != nil {
+		// The page has to be on disk before the copy is replaced by the next write.
+		return pageError("write", pageID, s.sync())
 	}
 	return nil
 }
 
-func (s *PageStore) seekPageStart(pageID PageID) error {
-	pageAddr := pageID * PageSize
-	_, err := s.file.Seek(int64(pageAddr), io.SeekStart)
-	return err
+// pageOffset returns the offset of a page in the file.
+func pageOffset(pageID PageID) int64 {
+	return int64(pageID) * PageSize
 }
 
 // headerPage represents the metadata schema of the first page in a page store's file.
@@ -210,31 +922,266 @@ type headerPage struct {
 	// magicNumber identifies whether the current file has been previously used as a page
 	// cache.
 	magicNumber uint32
-	// FreeList is the start a linked list of deallocated / unused pages.
-	freeList uint32
+	// FreeList is the start a linked list of deallocated / unused pages. Files in
+	// FormatVersion3 and later keep a free-space map instead, and leave it at zero.
+	freeList uint64
 	// Size is the number of pages that the page cache has alreaedy allocated.
-	size uint32
+	size uint64
+	// root is the page id of the root of the tree stored in this file, or zero if a tree
+	// has yet to be created.
+	root uint64
+	// branchingFactor is the branching factor of the tree stored in this file.
+	branchingFactor uint32
+	// flags records the options the tree stored in this file was created with.
+	flags uint32
+	// dirty is set while the file is being written through a write-ahead log, and cleared
+	// once the log has been replayed when the file is reopened.
+	dirty uint32
+	// closedCleanly is set when the file is closed with Close, and cleared when it's
+	// opened again.
+	closedCleanly uint32
+	// version is the format the file is in. Files from before the version was recorded
+	// have a zero there, and are in FormatVersion1.
+	version uint32
+	// reserved is the number of pages the file has been extended to ahead of them being
+	// allocated, see WithPreallocation. Files that have never been preallocated have a
+	// zero there.
+	reserved uint64
+	// holes are the runs of free pages that have been punched out of the file, which are
+	// allocated from once the free list is empty, see Shrink.
+	holes []hole
+	// reserve is the number of bytes kept at the end of every page's contents for the
+	// store, which encrypted files keep their pages' nonces and tags in, see
+	// WithEncryption.
+	reserve uint32
+	// keyID is the ID of the key keyCheck is sealed with, which tells whether the keys
+	// an encrypted file is opened with are the ones it was written with.
+	keyID    uint32
+	keyCheck [keyCheckSize]byte
+	// lsn is the log sequence number of the last group committed to the write-ahead log,
+	// which counts the groups committed through it since the file was created.
+	lsn uint64
+	// checkpointLSN is the log sequence number of the last group a checkpoint synced to
+	// the file, which recovery doesn't replay, see Checkpoint.
+	checkpointLSN uint64
+	// generation counts the times the header has been written to the file, which picks
+	// the copy of it that's written in FormatVersion4 and later, see headerImageID.
+	generation uint64
+	// id identifies the file, see StoreID.
+	id StoreID
+	// writing is set while the file has been written to since it was last synced or
+	// closed, see markWriting.
+	writing uint32
+	// pageSize, byteOrder and features describe the format of the file, see checkFormat,
+	// and keyType the type of the keys stored in it, see SetKeyType.
+	pageSize  uint32
+	byteOrder uint32
+	keyType   uint32
+	features  uint32
 }
 
 func (p *headerPage) fromBuffer() {
 	p.magicNumber = binary.LittleEndian.Uint32(p.Buf[0:4])
-	p.freeList = binary.LittleEndian.Uint32(p.Buf[4:8])
-	p.size = binary.LittleEndian.Uint32(p.Buf[8:12])
+	p.branchingFactor = binary.LittleEndian.Uint32(p.Buf[16:20])
+	p.flags = binary.LittleEndian.Uint32(p.Buf[20:24])
+	p.dirty = binary.LittleEndian.Uint32(p.Buf[24:28])
+	p.closedCleanly = binary.LittleEndian.Uint32(p.Buf[28:32])
+	p.version = max(binary.LittleEndian.Uint32(p.Buf[32:36]), FormatVersion1)
+	// The reservation is kept past the end of the header in every format, where older
+	// versions of this package don't look.
+	p.reserved = binary.LittleEndian.Uint64(p.Buf[60:68])
+	p.reserve = binary.LittleEndian.Uint32(p.Buf[76:80])
+	p.keyID = binary.LittleEndian.Uint32(p.Buf[80:84])
+	copy(p.keyCheck[:], p.Buf[84:84+keyCheckSize])
+	p.lsn = binary.LittleEndian.Uint64(p.Buf[lsnOffset : lsnOffset+8])
+	p.checkpointLSN = binary.LittleEndian.Uint64(p.Buf[checkpointLSNOffset:])
+	p.generation = binary.LittleEndian.Uint64(p.Buf[generationOffset:])
+	copy(p.id[:], p.Buf[storeIDOffset:storeIDOffset+storeIDSize])
+	p.writing = binary.LittleEndian.Uint32(p.Buf[writingOffset:])
+	p.formatFieldsFromBuffer()
+	p.holes = p.holes[:0]
+	count := min(int(binary.LittleEndian.Uint32(p.Buf[68:72])), maxHoles)
+	for i := 0; i < count; i++ {
+		entry := p.Buf[holesOffset+i*holeSize:]
+		p.holes = append(p.holes, hole{
+			start: binary.LittleEndian.Uint64(entry[0:8]),
+			count: binary.LittleEndian.Uint64(entry[8:16]),
+		})
+	}
+	if p.version == FormatVersion1 {
+		p.freeList = uint64(binary.LittleEndian.Uint32(p.Buf[4:8]))
+		p.size = uint64(binary.LittleEndian.Uint32(p.Buf[8:12]))
+		p.root = uint64(binary.LittleEndian.Uint32(p.Buf[12:16]))
+		return
+	}
+	p.freeList = binary.LittleEndian.Uint64(p.Buf[36:44])
+	p.size = binary.LittleEndian.Uint64(p.Buf[44:52])
+	p.root = binary.LittleEndian.Uint64(p.Buf[52:60])
 }
 
 func (p *headerPage) toBuffer() {
 	binary.LittleEndian.PutUint32(p.Buf[0:4], p.magicNumber)
-	binary.LittleEndian.PutUint32(p.Buf[4:8], p.freeList)
-	binary.LittleEndian.PutUint32(p.Buf[8:12], p.size)
+	binary.LittleEndian.PutUint32(p.Buf[16:20], p.branchingFactor)
+	binary.LittleEndian.PutUint32(p.Buf[20:24], p.flags)
+	binary.LittleEndian.PutUint32(p.Buf[24:28], p.dirty)
+	binary.LittleEndian.PutUint32(p.Buf[28:32], p.closedCleanly)
+	binary.LittleEndian.PutUint64(p.Buf[60:68], p.reserved)
+	binary.LittleEndian.PutUint32(p.Buf[68:72], uint32(len(p.holes)))
+	binary.LittleEndian.PutUint32(p.Buf[76:80], p.reserve)
+	binary.LittleEndian.PutUint32(p.Buf[80:84], p.keyID)
+	copy(p.Buf[84:84+keyCheckSize], p.keyCheck[:])
+	binary.LittleEndian.PutUint64(p.Buf[lsnOffset:lsnOffset+8], p.lsn)
+	binary.LittleEndian.PutUint64(p.Buf[checkpointLSNOffset:], p.checkpointLSN)
+	binary.LittleEndian.PutUint64(p.Buf[generationOffset:], p.generation)
+	copy(p.Buf[storeIDOffset:storeIDOffset+storeIDSize], p.id[:])
+	binary.LittleEndian.PutUint32(p.Buf[writingOffset:], p.writing)
+	p.formatFieldsToBuffer()
+	for i := 0; i < maxHoles; i++ {
+		var h hole
+		if i < len(p.holes) {
+			h = p.holes[i]
+		}
+		entry := p.Buf[holesOffset+i*holeSize:]
+		binary.LittleEndian.PutUint64(entry[0:8], h.start)
+		binary.LittleEndian.PutUint64(entry[8:16], h.count)
+	}
+	if p.version == FormatVersion1 {
+		binary.LittleEndian.PutUint32(p.Buf[4:8], uint32(p.freeList))
+		binary.LittleEndian.PutUint32(p.Buf[8:12], uint32(p.size))
+		binary.LittleEndian.PutUint32(p.Buf[12:16], uint32(p.root))
+		return
+	}
+	binary.LittleEndian.PutUint32(p.Buf[32:36], p.version)
+	binary.LittleEndian.PutUint64(p.Buf[36:44], p.freeList)
+	binary.LittleEndian.PutUint64(p.Buf[44:52], p.size)
+	binary.LittleEndian.PutUint64(p.Buf[52:60], p.root)
+}
+
+// allocation returns the ranges of the header that allocating and freeing pages change:
+// the free list and size, and the reservation and holes. The free-space map of newer
+// formats is kept in pages of its own, see commitCOW.
+func (p *headerPage) allocation() [][2]int {
+	holes := [2]int{60, holesOffset + maxHoles*holeSize}
+	if p.version == FormatVersion1 {
+		return [][2]int{{4, 12}, holes}
+	}
+	return [][2]int{{36, 52}, holes}
+}
+
+// Version returns the format the file is in, see CurrentFormatVersion.
+func (s *PageStore) Version() int {
+	return int(s.header.version)
+}
+
+// PageIDSize returns the number of bytes a page id takes up in the file's format, for
+// the pages that point at other pages.
+func (s *PageStore) PageIDSize() int {
+	if s.header.version == FormatVersion1 {
+		return 4
+	}
+	return 8
+}
+
+// putFreeLink stores the offset of the next page on the free list in a free page.
+func (s *PageStore) putFreeLink(buf []byte, next uint64) {
+	if s.header.version == FormatVersion1 {
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(next))
+		return
+	}
+	binary.LittleEndian.PutUint64(buf[0:8], next)
+}
+
+// freeLink returns the offset of the next page on the free list stored in a free page.
+func (s *PageStore) freeLink(buf []byte) uint64 {
+	if s.header.version == FormatVersion1 {
+		return uint64(binary.LittleEndian.Uint32(buf[0:4]))
+	}
+	return binary.LittleEndian.Uint64(buf[0:8])
+}
+
+// Root returns the page id of the tree root recorded in the header. A zero PageID means
+// that no tree has been stored in this file yet.
+func (s *PageStore) Root() PageID {
+	return PageID(s.header.root)
+}
+
+// BranchingFactor returns the branching factor of the tree recorded in the header.
+func (s *PageStore) BranchingFactor() int {
+	return int(s.header.branchingFactor)
+}
+
+// SetRoot records the page id of the tree root and the tree's branching factor in the
+// header so that the tree can be found again when the file is reopened.
+func (s *PageStore) SetRoot(root PageID, branchingFactor int) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.header.root = uint64(root)
+	s.header.branchingFactor = uint32(branchingFactor)
+	return s.writeHeader()
+}
+
+// Flags returns the tree options recorded in the header.
+func (s *PageStore) Flags() uint32 {
+	return s.header.flags
+}
+
+// SetFlags records the options a tree was created with in the header.
+func (s *PageStore) SetFlags(flags uint32) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.header.flags = flags
+	return s.writeHeader()
+}
+
+// writeHeader encodes the header into its page and writes it. The page is encoded with
+// the lock held, because writing back other pages can write back the header too.
+func (s *PageStore) writeHeader() error {
+	s.Lock()
+	s.header.toBuffer()
+	s.Unlock()
+	return s.Write(s.header.ID)
 }
 
 // Allocate and attempt to load a page from either the free list of deallocated pages or
-// from the end of the file.
+// from the end of the file. In files with a free-space map, the first free page in the
+// file is allocated.
 func (s *PageStore) Allocate() (PageID, error) {
-	if s.header.freeList != 0 {
-		return s.allocateFromFreeList()
+	if s.readOnly {
+		return 0, ErrReadOnly
 	}
-	return s.allocateFromEndOfFile()
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	var pageID PageID
+	var err error
+	if s.backups > 0 {
+		pageID, err = s.allocateFromEndOfFile()
+		if err == nil && s.freeSpace != nil {
+			err = s.writeFreeSpace()
+		}
+	} else if s.freeSpace != nil {
+		pageID, err = s.allocateFromMap()
+		if err == nil {
+			err = s.writeFreeSpace()
+		}
+	} else if s.header.freeList != 0 {
+		pageID, err = s.allocateFromFreeList()
+	} else if len(s.header.holes) > 0 {
+		pageID, err = s.allocateFromHole()
+	} else {
+		pageID, err = s.allocateFromEndOfFile()
+	}
+	if err == nil && s.cow && s.grouped() {
+		s.Lock()
+		s.fresh[pageID] = true
+		s.Unlock()
+	}
+	return pageID, err
 }
 
 func (s *PageStore) allocateFromFreeList() (PageID, error) {
@@ -246,63 +1193,83 @@ func (s *PageStore) allocateFromFreeList() (PageID, error) {
 	if err != nil {
 		return 0, err
 	}
-	free := freePage{
-		Page: page,
+	next := s.freeLink(page.Buf[:])
+	err = page.Release()
+	if err != nil {
+		return 0, err
 	}
-	free.fromBuffer()
-	// If we've reached the end of the free list, nextFreePage will be zero and the
-	// freeList will be marked as empty.
-	s.header.freeList = free.nextFreePage
-	s.header.toBuffer()
-	err = s.Write(s.header.ID)
+	// If we've reached the end of the free list, next will be zero and the freeList will be
+	// marked as empty.
+	s.header.freeList = next
+	err = s.writeHeader()
 	return firstFreePageID, err
 }
 
-type freePage struct {
-	*Page
-	nextFreePage uint32
-}
-
-func (p *freePage) fromBuffer() {
-	p.nextFreePage = binary.LittleEndian.Uint32(p.Buf[0:4])
-}
-
-func (p *freePage) toBuffer() {
-	binary.LittleEndian.PutUint32(p.Buf[0:4], p.nextFreePage)
-}
-
 func (s *PageStore) allocateFromEndOfFile() (PageID, error) {
+	err := s.addMapPage()
+	if err != nil {
+		return 0, err
+	}
+	err = s.reserve(s.header.size + 1)
+	if err != nil {
+		return 0, err
+	}
 	nextFreePageID := PageID(s.header.size)
 	s.header.size++
-	s.header.toBuffer()
-	err := s.Write(s.header.ID)
+	err = s.writeHeader()
 	if err != nil {
 		return 0, err
 	}
 	return nextFreePageID, nil
 }
 
-// Free places a page onto the free list so that it will be used by future allocations.
-func (s *PageStore) Free(id PageID) error {
+// Free places a page onto the free list, or marks it free in the free-space map, so that
+// it will be used by future allocations. The page mustn't be used once it has been
+// freed, even through a handle that's still held.
+func (s *PageStore) Free(id PageID) (err error) {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	return s.free(id)
+}
+
+// free frees a page, see Free. The header lock must be held.
+func (s *PageStore) free(id PageID) (err error) {
 	currentFirstFreePage := s.header.freeList
 	page, err := s.Load(id)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		releaseErr := page.Release()
+		if err == nil {
+			err = releaseErr
+		}
+	}()
+	if s.cow && s.grouped() {
+		// The page may be part of the last committed tree, so it can't be reused until the
+		// group has been committed.
+		s.Lock()
+		s.deferredFrees = append(s.deferredFrees, page.ID)
+		s.Unlock()
+		return nil
+	}
+	if s.freeSpace != nil {
+		// Pages marked free in the map don't link to anything, so they're left as they are.
+		s.freeSpace.setFree(page.ID, true)
+		return s.writeFreeSpace()
+	}
 	// Clear the buffer.
 	for i := 0; i < PageSize; i++ {
 		page.Buf[i] = 0
 	}
-	free := freePage{
-		Page:         page,
-		nextFreePage: currentFirstFreePage,
-	}
-	free.toBuffer()
-	err = s.Write(free.ID)
+	s.putFreeLink(page.Buf[:], currentFirstFreePage)
+	err = s.Write(page.ID)
 	if err != nil {
 		return err
 	}
-	s.header.freeList = uint32(id) * PageSize
-	s.header.toBuffer()
-	return s.Write(free.ID)
+	s.header.freeList = uint64(id) * PageSize
+	return s.writeHeader()
 }
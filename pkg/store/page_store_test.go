@@ -1,7 +1,9 @@
 package store
 
 import (
+	"encoding/binary"
 	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -20,16 +22,23 @@ func TestPageStoreHeaderIsFormattedCorrectly(t *testing.T) {
 		t.Fatalf("%v != %v", store.header.magicNumber, MagicNumber)
 	}
 
-	expectedFreeList := []byte{0, 0, 0, 0}
-	assertBufEqual(t, expectedFreeList, page.Buf[4:8])
+	expectedVersion := []byte{FormatVersion4, 0, 0, 0}
+	assertBufEqual(t, expectedVersion, page.Buf[32:36])
+	if store.Version() != FormatVersion4 {
+		t.Fatalf("%v != %v", store.Version(), FormatVersion4)
+	}
+
+	expectedFreeList := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	assertBufEqual(t, expectedFreeList, page.Buf[36:44])
 	if store.header.freeList != 0 {
 		t.Fatalf("%v != 0", store.header.freeList)
 	}
 
-	expectedSize := []byte{1, 0, 0, 0}
-	assertBufEqual(t, expectedSize, page.Buf[8:12])
-	if store.header.size != 1 {
-		t.Fatalf("%v != 1", store.header.size)
+	// The header is followed by its copy, and then by the free-space map.
+	expectedSize := []byte{3, 0, 0, 0, 0, 0, 0, 0}
+	assertBufEqual(t, expectedSize, page.Buf[44:52])
+	if store.header.size != 3 {
+		t.Fatalf("%v != 3", store.header.size)
 	}
 }
 
@@ -43,43 +52,154 @@ func TestPageStoreAllocatesAndDeallocatesPages(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if pageID != PageID(i+1) {
-			t.Fatalf("expected %d == %d", pageID, i+1)
+		if pageID != PageID(i+3) {
+			t.Fatalf("expected %d == %d", pageID, i+3)
 		}
 	}
-	if store.header.size != 11 {
-		t.Fatalf("expected %d == 11", store.header.size)
+	if store.header.size != 13 {
+		t.Fatalf("expected %d == 13", store.header.size)
 	}
 	for i := 0; i < 5; i++ {
-		err := store.Free(PageID(i + 1))
+		err := store.Free(PageID(i + 3))
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
-	if store.header.size != 11 {
-		t.Fatalf("expected %d == 11", store.header.size)
+	if store.header.size != 13 {
+		t.Fatalf("expected %d == 13", store.header.size)
 	}
+	// The first free page in the file is allocated first.
 	for i := 0; i < 5; i++ {
 		pageID, err := store.Allocate()
 		if err != nil {
 			t.Fatal(err)
 		}
-		if pageID != PageID(5-i) {
-			t.Fatalf("expected %d == %d", pageID, i+1)
+		if pageID != PageID(i+3) {
+			t.Fatalf("expected %d == %d", pageID, i+3)
+		}
+	}
+	if store.header.size != 13 {
+		t.Fatalf("expected %d == 13", store.header.size)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageID != PageID(13) {
+		t.Fatalf("expected %d == 13", pageID)
+	}
+	if store.header.size != 14 {
+		t.Fatalf("expected %d == 14", store.header.size)
+	}
+}
+
+func TestPageStoreFreeListSurvivesReopen(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "free_list_survives_reopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := store.Allocate(); err != nil {
+			t.Fatal(err)
 		}
 	}
-	if store.header.size != 11 {
-		t.Fatalf("expected %d == 11", store.header.size)
+	if err := store.Free(PageID(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := reopened.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageID != PageID(2) {
+		t.Fatalf("expected %d == 2", pageID)
+	}
+}
+
+func TestPageStoreRootAndFlagsSurviveReopen(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "root_and_flags_survive_reopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetRoot(PageID(3), 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetFlags(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Root() != PageID(3) {
+		t.Fatalf("expected %d == 3", reopened.Root())
+	}
+	if reopened.BranchingFactor() != 16 {
+		t.Fatalf("expected %d == 16", reopened.BranchingFactor())
+	}
+	if reopened.Flags() != 5 {
+		t.Fatalf("expected %d == 5", reopened.Flags())
+	}
+}
+
+func TestPageStoreKeepsPinnedPagesLoaded(t *testing.T) {
+	store, err := newPageStore("keeps_pinned_pages_loaded", 10)
+	if err != nil {
+		t.Fatal(err)
 	}
 	pageID, err := store.Allocate()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if pageID != PageID(11) {
-		t.Fatalf("expected %d == 11", pageID)
+	var handles []*PageHandle
+	for i := 0; i < 2; i++ {
+		page, err := store.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, page)
+	}
+	if err := handles[0].Release(); err != nil {
+		t.Fatal(err)
+	}
+	// Releasing the same handle twice doesn't give back the pin held by the other one.
+	if err := handles[0].Release(); err != ErrHandleReleased {
+		t.Fatalf("expected %v == %v", err, ErrHandleReleased)
+	}
+	// The page was loaded twice so it's still pinned by the second load.
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := handles[1].Release(); err != nil {
+		t.Fatal(err)
 	}
-	if store.header.size != 12 {
-		t.Fatalf("expected %d == 12", store.header.size)
+	if err := store.Write(pageID); err != ErrPageNotLoaded {
+		t.Fatalf("expected %v == %v", err, ErrPageNotLoaded)
 	}
 }
 
@@ -89,7 +209,7 @@ func newPageStore(filename string, cacheCapacity int) (*PageStore, error) {
 		return nil, err
 	}
 	tmpfile.Close()
-	return NewPageStore(tmpfile.Name(), cacheCapacity)
+	return NewPageStore(tmpfile.Name(), WithCacheSize(cacheCapacity))
 }
 
 func assertBufEqual(t *testing.T, got, expected []byte) {
@@ -102,3 +222,197 @@ func assertBufEqual(t *testing.T, got, expected []byte) {
 		}
 	}
 }
+
+func TestPageStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	// The header takes up one of the three slots.
+	store, err := newPageStore("evicts_least_recently_used", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 3; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	var handles []*PageHandle
+	for _, pageID := range pages[:2] {
+		page, err := store.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, page)
+	}
+	if _, err := store.Load(pages[2]); err != ErrPageCacheFull {
+		t.Fatalf("expected %v == %v", err, ErrPageCacheFull)
+	}
+	for _, page := range handles {
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Use the first page again so that the second is the one evicted.
+	loadAndRelease(t, store, pages[0])
+	if _, err := store.Load(pages[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.shard(pages[0]).lookup[pages[0]]; !ok {
+		t.Fatal("expected the most recently used page to stay cached")
+	}
+	if _, ok := store.shard(pages[1]).lookup[pages[1]]; ok {
+		t.Fatal("expected the least recently used page to be evicted")
+	}
+}
+
+// newVersion1File creates a file in FormatVersion1, whose header has no version and
+// keeps its page ids in 32 bits.
+func newVersion1File(t *testing.T) string {
+	tmpfile, err := ioutil.TempFile("", "format_v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithFormatVersion(FormatVersion1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tmpfile.Name()
+}
+
+func TestFormatVersion1IsReadAndWritten(t *testing.T) {
+	filename := newVersion1File(t)
+	store, err := NewPageStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Version() != FormatVersion1 {
+		t.Fatalf("expected %v == %v", store.Version(), FormatVersion1)
+	}
+	if store.PageIDSize() != 4 {
+		t.Fatalf("expected %v == %v", store.PageIDSize(), 4)
+	}
+	var ids []PageID
+	for i := 0; i < 4; i++ {
+		id, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := store.Free(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Free(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetRoot(ids[3], 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The header is still in the old layout, with the version left unset.
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf [PageSize]byte
+	if _, err := file.ReadAt(buf[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if version := binary.LittleEndian.Uint32(buf[32:36]); version != 0 {
+		t.Fatalf("expected %v == %v", version, 0)
+	}
+	if root := binary.LittleEndian.Uint32(buf[12:16]); PageID(root) != ids[3] {
+		t.Fatalf("expected %v == %v", root, ids[3])
+	}
+
+	store, err = NewPageStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Root() != ids[3] {
+		t.Fatalf("expected %v == %v", store.Root(), ids[3])
+	}
+	// Freed pages are handed back out in the reverse order they were freed in.
+	for _, expected := range []PageID{ids[2], ids[1], ids[3] + 1} {
+		id, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != expected {
+			t.Fatalf("expected %v == %v", id, expected)
+		}
+	}
+}
+
+func TestFormatVersion2StoresLargePageIDs(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "format_v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A page id past what 32 bits can hold, without writing out a file that large.
+	large := PageID(1<<32 + 5)
+	if err := store.SetRoot(large, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Root() != large {
+		t.Fatalf("expected %v == %v", store.Root(), large)
+	}
+	var buf [PageSize]byte
+	store.putFreeLink(buf[:], uint64(large)*PageSize)
+	if next := store.freeLink(buf[:]); next != uint64(large)*PageSize {
+		t.Fatalf("expected %v == %v", next, uint64(large)*PageSize)
+	}
+}
+
+func TestNewerFormatVersionIsRefused(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "format_newer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	_, err = NewPageStore(tmpfile.Name(), WithFormatVersion(CurrentFormatVersion+1))
+	if err != ErrUnsupportedVersion {
+		t.Fatalf("expected %v == %v", err, ErrUnsupportedVersion)
+	}
+	store, err := NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pretend the file was written by a newer version of the package.
+	store.header.version = CurrentFormatVersion + 1
+	if err := store.writeHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]Option{nil, {WithReadOnly()}} {
+		_, err = NewPageStore(tmpfile.Name(), opts...)
+		if err != ErrUnsupportedVersion {
+			t.Fatalf("expected %v == %v", err, ErrUnsupportedVersion)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package store
+
+import "os"
+
+// maxDoublingChunk bounds how much a file that grows by doubling is extended by at once,
+// see WithPreallocation.
+const maxDoublingChunk = 64 << 20
+
+// reserve makes sure the file has been extended to hold size pages when it's being
+// preallocated, extending it by another chunk if it hasn't. The header lock must be held,
+// and the new reservation is written along with the rest of the header.
+func (s *PageStore) reserve(size uint64) error {
+	if s.preallocation <= 0 || size <= s.header.reserved {
+		return nil
+	}
+	chunk := uint64(max(s.preallocation/PageSize, 1))
+	if s.doubling {
+		chunk = max(chunk, min(s.header.size, maxDoublingChunk/PageSize))
+	}
+	reserved := max(max(s.header.size, s.header.reserved)+chunk, size)
+	err := s.extend(pageOffset(PageID(s.header.size)), pageOffset(PageID(reserved)))
+	if err != nil {
+		return err
+	}
+	s.header.reserved = reserved
+	return nil
+}
+
+// extend makes the file at least to bytes long, allocating the space between from and to
+// on disk where it can.
+func (s *PageStore) extend(from, to int64) error {
+	file, ok := backendFile(s.backend)
+	if ok {
+		return fallocate(file, from, to)
+	}
+	// Past the last allocated page the backend only holds pages that were never
+	// allocated, so it's safe to cut them off too.
+	return s.backend.Truncate(to)
+}
+
+// extendFile extends the file with zeros to be at least size bytes long.
+func extendFile(file *os.File, size int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= size {
+		return nil
+	}
+	return file.Truncate(size)
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate allocates the space between from and to in the file, extending it to be at
+// least to bytes long. File systems that can't allocate space ahead of time have the
+// file extended with zeros instead.
+func fallocate(file *os.File, from, to int64) error {
+	err := syscall.Fallocate(int(file.Fd()), 0, from, to-from)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return extendFile(file, to)
+	}
+	return err
+}
+
+// punchHole deallocates length bytes of the file at offset, which read as zeros from then
+// on. File systems that can't punch holes leave the file as it is.
+func punchHole(file *os.File, offset, length int64) error {
+	const mode = 0x01 | 0x02 // FALLOC_FL_KEEP_SIZE | FALLOC_FL_PUNCH_HOLE
+	err := syscall.Fallocate(int(file.Fd()), mode, offset, length)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return nil
+	}
+	return err
+}
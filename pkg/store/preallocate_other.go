@@ -0,0 +1,15 @@
+//go:build !linux
+
+package store
+
+import "os"
+
+// fallocate extends the file with zeros to be at least to bytes long.
+func fallocate(file *os.File, from, to int64) error {
+	return extendFile(file, to)
+}
+
+// punchHole leaves the file as it is, since holes can't be punched on this platform.
+func punchHole(file *os.File, offset, length int64) error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestPreallocation(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "preallocation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithPreallocation(16*PageSize, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file is extended past the header, its copy and the free-space map by a whole
+	// chunk.
+	first, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, tmpfile.Name()); size != 18*PageSize {
+		t.Fatalf("expected %v == %v", size, 18*PageSize)
+	}
+	// A preallocated page that hasn't been written loads as zeros.
+	page, err := store.Load(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:], make([]byte, PageSize))
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 16; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	if size := fileSize(t, tmpfile.Name()); size != 34*PageSize {
+		t.Fatalf("expected %v == %v", size, 34*PageSize)
+	}
+	writePages(t, store, pages, 1)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reservation is kept in the header, so the file isn't extended again until it's
+	// used up.
+	reopened, err := NewPageStore(tmpfile.Name(), WithPreallocation(16*PageSize, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.header.reserved != 34 || reopened.header.size != 20 {
+		t.Fatalf("expected %v, %v == 34, 20", reopened.header.reserved, reopened.header.size)
+	}
+	last, err := reopened.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, tmpfile.Name()); size != 34*PageSize {
+		t.Fatalf("expected %v == %v", size, 34*PageSize)
+	}
+	// Freeing the pages at the end of the file cuts them off along with the reservation
+	// when it's closed.
+	for _, pageID := range append(pages[8:], last) {
+		if err := reopened.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, tmpfile.Name()); size != 12*PageSize {
+		t.Fatalf("expected %v == %v", size, 12*PageSize)
+	}
+}
+
+func TestPreallocationDoubling(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := OpenBackend(backend, WithPreallocation(PageSize, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var sizes []int64
+	for i := 0; i < 20; i++ {
+		if _, err := store.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+		if size := backend.Size(); len(sizes) == 0 || sizes[len(sizes)-1] != size {
+			sizes = append(sizes, size)
+		}
+	}
+	// The file grows by as much as it holds every time it runs out of pages.
+	expected := []int64{4, 8, 16, 32}
+	if len(sizes) != len(expected) {
+		t.Fatalf("expected %v == %v", sizes, expected)
+	}
+	for i := range sizes {
+		if sizes[i] != expected[i]*PageSize {
+			t.Fatalf("expected %v == %v", sizes[i], expected[i]*PageSize)
+		}
+	}
+}
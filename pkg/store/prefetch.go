@@ -0,0 +1,124 @@
+package store
+
+import "time"
+
+// maxPrefetches bounds the prefetches running at once. Prefetches asked for while that
+// many are running are dropped, since they're only hints.
+const maxPrefetches = 4
+
+// Prefetch reads pages into the cache in the background, ahead of them being loaded, so
+// that loading them later doesn't have to wait on the file. The pages are read with one
+// batch when the backend is a BatchBackend. Prefetched pages aren't pinned, and they're
+// evicted like any other page that has been released.
+//
+// Prefetching is only a hint. Pages that are already cached are skipped, and pages that
+// fail to read, or that there's no room for, are left to be loaded as usual.
+func (s *PageStore) Prefetch(pageIDs ...PageID) {
+	select {
+	case s.prefetches <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-s.prefetches }()
+		s.prefetch(pageIDs)
+	}()
+}
+
+// prefetch reads the pages that aren't cached yet and caches them. The store's lock is
+// held for reading throughout, so that nothing but write backs can change the file.
+func (s *PageStore) prefetch(pageIDs []PageID) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return
+	}
+	writeBacks := s.writeBacks.Load()
+	var ids []PageID
+	var ops []BatchOp
+	for _, pageID := range pageIDs {
+		pageID = s.resolve(pageID)
+		if s.cached(pageID) || s.pending[pageID] != nil {
+			continue
+		}
+		ids = append(ids, pageID)
+		ops = append(ops, BatchOp{Buf: make([]byte, PageSize), Off: pageOffset(pageID)})
+	}
+	start := time.Now()
+	s.readBatch(ops)
+	s.counters.observeIO(start)
+	for i, op := range ops {
+		if op.Err != nil || op.N != PageSize {
+			continue
+		}
+		s.counters.loads.Add(1)
+		buf := (*[PageSize]byte)(op.Buf)
+		if !validPageChecksum(buf) || s.decodePage(ids[i], buf) != nil {
+			continue
+		}
+		s.cachePrefetched(ids[i], buf, writeBacks)
+	}
+}
+
+// cached returns whether a page is in the cache.
+func (s *PageStore) cached(pageID PageID) bool {
+	shard := s.shard(pageID)
+	shard.Lock()
+	defer shard.Unlock()
+	_, ok := shard.lookup[pageID]
+	return ok
+}
+
+// readBatch reads every op, through the mapping if there is one, and otherwise with one
+// batch if the backend can read batches.
+func (s *PageStore) readBatch(ops []BatchOp) {
+	batch, ok := s.backend.(BatchBackend)
+	if s.mapping == nil && ok {
+		err := batch.ReadBatch(ops)
+		if err != nil {
+			for i := range ops {
+				ops[i].Err = err
+			}
+		}
+		return
+	}
+	for i := range ops {
+		op := &ops[i]
+		if s.mapping != nil {
+			op.N, op.Err = s.mapping.readAt(op.Buf, op.Off)
+		} else {
+			op.N, op.Err = s.backend.ReadAt(op.Buf, op.Off)
+		}
+	}
+}
+
+// cachePrefetched puts a prefetched page in the cache, unless it was loaded in the
+// meantime. writeBacks is the count of write backs from before the page was read.
+func (s *PageStore) cachePrefetched(
+	pageID PageID,
+	buf *[PageSize]byte,
+	writeBacks uint64,
+) {
+	shard := s.shard(pageID)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, ok := shard.lookup[pageID]; ok {
+		return
+	}
+	// A page that was cached and dirty when it was read may have been written back and
+	// evicted since, in which case what was read is out of date. A page that's being
+	// written back is still cached, so once it's gone the count has moved on.
+	if s.writeBacks.Load() != writeBacks {
+		return
+	}
+	cacheID, err := s.nextFreeCacheSlot(shard, pageID)
+	if err != nil {
+		return
+	}
+	s.cache[cacheID].ID = pageID
+	s.cache[cacheID].Buf = *buf
+	shard.lookup[pageID] = cacheID
+	s.pins[cacheID] = 0
+	shard.policy.Loaded(cacheID, pageID, false)
+	shard.policy.Released(cacheID)
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readCountingBackend counts the reads made from the backend it wraps.
+type readCountingBackend struct {
+	*MemoryBackend
+	reads atomic.Int64
+}
+
+func (b *readCountingBackend) ReadAt(p []byte, off int64) (int, error) {
+	b.reads.Add(1)
+	return b.MemoryBackend.ReadAt(p, off)
+}
+
+func TestPrefetch(t *testing.T) {
+	backend := &readCountingBackend{MemoryBackend: NewMemoryBackend()}
+	store, err := OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 10; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writePages(t, store, []PageID{pageID}, byte(i))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenBackend(backend, WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// A page that's dirty in the cache isn't replaced by what's in the backend.
+	writePages(t, store, pages[:1], 100)
+	store.Prefetch(pages...)
+	waitForCached(t, store, pages)
+	reads := backend.reads.Load()
+	for i, pageID := range pages {
+		expected := pageFilledWith(byte(i))
+		if i == 0 {
+			expected = pageFilledWith(100)
+		}
+		page, err := store.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBufEqual(t, page.Buf[:UsablePageSize], expected[:UsablePageSize])
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every page was loaded from the cache.
+	if backend.reads.Load() != reads {
+		t.Fatalf("expected %v == %v", backend.reads.Load(), reads)
+	}
+}
+
+func TestPrefetchSkipsUnwrittenPages(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Prefetch(pageID, pageID+1000)
+	// Pages that were never written can't be checked, so they're left to be loaded.
+	time.Sleep(10 * time.Millisecond)
+	store.RLock()
+	defer store.RUnlock()
+	if store.cached(pageID) || store.cached(pageID+1000) {
+		t.Fatal("expected pages that were never written not to be prefetched")
+	}
+}
+
+func waitForCached(t *testing.T, store *PageStore, pages []PageID) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, pageID := range pages {
+		for !cachedWithLock(store, pageID) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected page %v to be prefetched", pageID)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func cachedWithLock(store *PageStore, pageID PageID) bool {
+	store.RLock()
+	defer store.RUnlock()
+	return store.cached(pageID)
+}
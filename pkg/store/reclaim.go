@@ -0,0 +1,90 @@
+package store
+
+// Orphans returns the pages of the file that are neither free nor in use, in order.
+// Pages are orphaned when a crash or a bug loses track of them after they're allocated,
+// and they take up space in the file until they're reclaimed. inUse holds the pages that
+// what's stored in the file uses, such as the pages of its trees. The header, the pages
+// of the free-space map, and the pages held back for open snapshots belong to the store,
+// and are never orphaned.
+func (s *PageStore) Orphans(inUse map[PageID]bool) ([]PageID, error) {
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	return s.orphans(inUse)
+}
+
+// Reclaim frees the pages that Orphans returns, so that they can be allocated again, and
+// returns them. Every page that's in use has to be in inUse, since any page that isn't is
+// freed. Like Shrink, it can't be called between Begin and Commit or while a backup is
+// running. In files with a free list, the reclaimed pages are linked into it and synced
+// before the header points at them, so a crash part way through leaks them again rather
+// than corrupting the list.
+func (s *PageStore) Reclaim(inUse map[PageID]bool) ([]PageID, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if s.depth > 0 {
+		return nil, ErrShrinkInGroup
+	}
+	if s.backups > 0 {
+		return nil, ErrBackupRunning
+	}
+	orphans, err := s.orphans(inUse)
+	if err != nil {
+		return nil, err
+	}
+	return orphans, s.freePages(orphans)
+}
+
+// orphans finds the orphaned pages, see Orphans. The header lock and the store's lock
+// must both be held.
+func (s *PageStore) orphans(inUse map[PageID]bool) ([]PageID, error) {
+	owned := map[PageID]bool{}
+	if s.freeSpace == nil {
+		// The links written by Free have to reach the file before the list is read.
+		err := s.flush()
+		if err != nil {
+			return nil, err
+		}
+		free, err := s.freePageIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, pageID := range free {
+			owned[pageID] = true
+		}
+		for _, h := range s.header.holes {
+			for i := uint64(0); i < h.count; i++ {
+				owned[PageID(h.start+i)] = true
+			}
+		}
+	}
+	for _, frees := range s.held {
+		for _, pageID := range frees.pages {
+			owned[pageID] = true
+		}
+	}
+	var orphans []PageID
+	for pageID := headerPages(s.header.version); uint64(pageID) < s.header.size; pageID++ {
+		if inUse[pageID] || owned[pageID] {
+			continue
+		}
+		if s.freeSpace != nil &&
+			(s.freeSpace.isMapPage(pageID) || s.freeSpace.isFree(pageID)) {
+			continue
+		}
+		orphans = append(orphans, pageID)
+	}
+	return orphans, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestReclaim(t *testing.T) {
+	for _, version := range []int{FormatVersion2, FormatVersion3} {
+		tmpfile, err := ioutil.TempFile("", "reclaim")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+		store, err := NewPageStore(
+			tmpfile.Name(),
+			WithCacheSize(64),
+			WithFormatVersion(version),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pages []PageID
+		for i := 0; i < 10; i++ {
+			pageID, err := store.Allocate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, pageID)
+		}
+		writePages(t, store, pages, 1)
+		// The first six pages are in use, the next two are freed, and the last two are
+		// orphaned.
+		inUse := map[PageID]bool{}
+		for _, pageID := range pages[:6] {
+			inUse[pageID] = true
+		}
+		for _, pageID := range pages[6:8] {
+			if err := store.Free(pageID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		orphans, err := store.Orphans(inUse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(orphans, pages[8:]) {
+			t.Fatalf("expected %v == %v", orphans, pages[8:])
+		}
+
+		store.Begin()
+		if _, err := store.Reclaim(inUse); err != ErrShrinkInGroup {
+			t.Fatalf("expected %v == %v", err, ErrShrinkInGroup)
+		}
+		if err := store.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		reclaimed, err := store.Reclaim(inUse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reclaimed, pages[8:]) {
+			t.Fatalf("expected %v == %v", reclaimed, pages[8:])
+		}
+		if free, err := store.FreePages(); err != nil || free != 4 {
+			t.Fatalf("expected %v == %v (%v)", free, 4, err)
+		}
+		if err := store.CheckFreeSpace(); err != nil {
+			t.Fatal(err)
+		}
+		orphans, err = store.Orphans(inUse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orphans) != 0 {
+			t.Fatalf("expected %v == %v", len(orphans), 0)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// What was reclaimed is still free, or cut off the end, once the file is reopened.
+		store, err = NewPageStore(tmpfile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		orphans, err = store.Orphans(inUse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orphans) != 0 {
+			t.Fatalf("expected %v == %v", len(orphans), 0)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"os"
+)
+
+// RecoveryStats describes the recovery run when a page store was opened.
+type RecoveryStats struct {
+	// Unclean is set if the file was being written through a write-ahead log when it was
+	// last closed, which means the process may have crashed part way through a write.
+	Unclean bool
+	// RecordsReplayed is the number of committed page writes replayed from the log.
+	RecordsReplayed int
+	// PagesRepaired is the number of distinct pages written by replaying the log.
+	PagesRepaired int
+	// RecordsDiscarded is the number of page writes in the log that were thrown away
+	// because they were never committed.
+	RecordsDiscarded int
+	// TornPageRestored is set if a page was only partly written when the file was last
+	// closed and was restored from the double-write buffer, see EnableDoubleWrite.
+	TornPageRestored bool
+	// ClosedCleanly is set if the file was closed with Close the last time it was open.
+	ClosedCleanly bool
+	// WrittenSinceSync is set if the file had been written to, and neither synced with
+	// Sync nor closed with Close since, when it was last open, which means the process
+	// crashed or was killed part way through writing it, and the file may need checking.
+	// Files written through a write-ahead log set Unclean instead.
+	WrittenSinceSync bool
+}
+
+// Recovery returns what was recovered when the store was opened.
+func (s *PageStore) Recovery() RecoveryStats {
+	return s.recovery
+}
+
+// checkRecovered returns ErrNeedsRecovery if the write-ahead log has pages in it that
+// recover would write to the file. A torn page is caught by its checksum once it's
+// loaded instead.
+func (s *PageStore) checkRecovered() error {
+	if s.walBackend != nil {
+		size, err := backendSize(s.walBackend)
+		if err != nil {
+			return err
+		}
+		if size > 0 {
+			return ErrNeedsRecovery
+		}
+		return nil
+	}
+	if s.filename == "" {
+		return nil
+	}
+	segments, err := listWALSegments(walFilename(s.filename))
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 && segments[len(segments)-1].LastLSN > s.readCheckpointLSN() {
+		return ErrNeedsRecovery
+	}
+	info, err := os.Stat(walFilename(s.filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		return ErrNeedsRecovery
+	}
+	return nil
+}
+
+// recover restores a torn page from the double-write buffer, then writes every page
+// committed to the write-ahead log to the file, discards the writes that were never
+// committed, and empties the log, or removes the segments of it that are no longer
+// needed. Nothing is replayed, and ErrWrongStore is returned, if the log belongs to
+// another file.
+func (s *PageStore) recover() error {
+	if s.walBackend != nil {
+		return s.replay()
+	}
+	// Without a file, there's no log or double-write buffer kept alongside it.
+	if s.filename == "" {
+		return nil
+	}
+	err := s.restoreTornPage()
+	if err != nil {
+		return err
+	}
+	segments, err := listWALSegments(walFilename(s.filename))
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(walFilename(s.filename))
+	if os.IsNotExist(err) && len(segments) == 0 {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.replay()
+}
+
+// replay writes every page committed to the write-ahead log after the last checkpoint
+// to the file, see recover.
+func (s *PageStore) replay() error {
+	wal, err := s.openWAL()
+	if err != nil {
+		return err
+	}
+	// A log kept in a backend is left open for EnableWAL, and closed along with the
+	// store.
+	if s.walBackend == nil {
+		defer wal.Close()
+	}
+	// A log left alongside the file by another one, such as one it replaced, isn't
+	// replayed into it.
+	wal.id = s.readID()
+	// The groups up to the last checkpoint are already in the file.
+	checkpoint := s.readCheckpointLSN()
+	repaired := map[PageID]bool{}
+	replayed, discarded, err := wal.replayAfter(checkpoint, func(
+		pageID PageID,
+		buf *[PageSize]byte,
+	) error {
+		repaired[pageID] = true
+		// Pages are logged as they're written to the file, but logs written by older
+		// versions of this package hold pages that haven't been checksummed yet.
+		if !validPageChecksum(buf) {
+			return s.writePage(pageID, buf)
+		}
+		s.writeLock.Lock()
+		defer s.writeLock.Unlock()
+		return s.writeImage(pageID, buf)
+	})
+	if err != nil {
+		return err
+	}
+	s.recovery.RecordsReplayed = replayed
+	s.recovery.PagesRepaired = len(repaired)
+	s.recovery.RecordsDiscarded = discarded
+	if replayed > 0 {
+		err = s.sync()
+		if err != nil {
+			return err
+		}
+		// The crash may have come before the groups were archived.
+		err = s.archive(wal, 0)
+		if err != nil {
+			return err
+		}
+		checkpoint, err = s.recordRecovered()
+		if err != nil {
+			return err
+		}
+	}
+	return wal.applied(checkpoint)
+}
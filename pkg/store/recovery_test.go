@@ -0,0 +1,84 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestRecoveryAfterCrash(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "recovery_after_crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Recovery() != (RecoveryStats{}) {
+		t.Fatalf("expected %+v == %+v", store.Recovery(), RecoveryStats{})
+	}
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Commit three writes to two pages, then crash before they're written to the file and
+	// part way through a fourth write.
+	for _, write := range []struct {
+		pageID PageID
+		fill   byte
+	}{{first, 1}, {second, 2}, {first, 3}} {
+		if err := store.wal.Append(write.pageID, pageFilledWith(write.fill)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.wal.Append(second, pageFilledWith(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := RecoveryStats{
+		Unclean:          true,
+		RecordsReplayed:  3,
+		PagesRepaired:    2,
+		RecordsDiscarded: 1,
+	}
+	if reopened.Recovery() != expected {
+		t.Fatalf("expected %+v == %+v", reopened.Recovery(), expected)
+	}
+	for pageID, fill := range map[PageID]byte{first: 3, second: 2} {
+		page, err := reopened.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(fill)[:UsablePageSize])
+	}
+
+	// Recovery leaves the file clean.
+	if err := reopened.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Recovery() != (RecoveryStats{}) {
+		t.Fatalf("expected %+v == %+v", again.Recovery(), RecoveryStats{})
+	}
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WALSegmentFile is a completed segment of the write-ahead log, see WithWALSegments. A
+// segment is synced before it's completed, and never written to again, so it can be
+// copied or shipped elsewhere while the store carries on writing to the log.
+type WALSegmentFile struct {
+	// FirstLSN and LastLSN are the log sequence numbers of the first and last groups in
+	// the segment.
+	FirstLSN, LastLSN uint64
+	// Filename is the name of the file the segment is kept in, and Size is its size.
+	Filename string
+	Size     int64
+}
+
+// Read reads the segment, which can be handed to RecoverTo along with the other
+// segments. Segments are removed once they're no longer retained, see WithWALSegments,
+// and reading one that has been returns an error for which os.IsNotExist is true.
+func (f WALSegmentFile) Read() (WALSegment, error) {
+	data, err := os.ReadFile(f.Filename)
+	if err != nil {
+		return WALSegment{}, err
+	}
+	return WALSegment{FirstLSN: f.FirstLSN, LastLSN: f.LastLSN, Data: data}, nil
+}
+
+// WALSegments returns the completed segments of the write-ahead log, oldest first, or
+// none if it isn't enabled or isn't split into segments, see WithWALSegments.
+func (s *PageStore) WALSegments() []WALSegmentFile {
+	s.RLock()
+	defer s.RUnlock()
+	if s.wal == nil {
+		return nil
+	}
+	return append([]WALSegmentFile(nil), s.wal.segments...)
+}
+
+// walSegmentFilename returns the name of the file a completed segment of the log kept in
+// filename is kept in, which sorts in the order of the segments.
+func walSegmentFilename(filename string, first, last uint64) string {
+	return fmt.Sprintf("%s.%020d-%020d", filename, first, last)
+}
+
+// listWALSegments finds the completed segments of the log kept in filename.
+func listWALSegments(filename string) ([]WALSegmentFile, error) {
+	names, err := filepath.Glob(filename + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var segments []WALSegmentFile
+	for _, name := range names {
+		segment := WALSegmentFile{Filename: name}
+		suffix := strings.TrimPrefix(name, filename+".")
+		_, err := fmt.Sscanf(suffix, "%020d-%020d", &segment.FirstLSN, &segment.LastLSN)
+		if err != nil || name != walSegmentFilename(filename, segment.FirstLSN,
+			segment.LastLSN) {
+			// Not a segment.
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		segment.Size = info.Size()
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].FirstLSN < segments[j].FirstLSN
+	})
+	return segments, nil
+}
+
+// scan finds the log sequence numbers of the groups in the log's file.
+func (w *WAL) scan() error {
+	_, err := readGroups(w.reader(), func(g *walGroup) error {
+		if g.lsn == 0 {
+			w.unnumbered = true
+			return nil
+		}
+		if w.first == 0 {
+			w.first = g.lsn
+		}
+		w.last = g.lsn
+		return nil
+	})
+	return err
+}
+
+// rotate completes the log's file once it has grown to the segment size.
+func (w *WAL) rotate() error {
+	if w.segmentSize <= 0 || w.size < w.segmentSize || w.last == 0 || w.unnumbered {
+		return nil
+	}
+	segment := WALSegmentFile{
+		FirstLSN: w.first,
+		LastLSN:  w.last,
+		Filename: walSegmentFilename(w.filename, w.first, w.last),
+		Size:     w.size,
+	}
+	// The file was synced when its last group was committed.
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+	renameErr := os.Rename(w.filename, segment.Filename)
+	flag := os.O_RDWR | os.O_CREATE | os.O_EXCL
+	if renameErr != nil {
+		// Carry on logging to the file as it is.
+		flag = os.O_RDWR
+	}
+	file, err := os.OpenFile(w.filename, flag, 0660)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	w.size = 0
+	w.first, w.last = 0, 0
+	w.segments = append(w.segments, segment)
+	return nil
+}
+
+// applied lets go of the groups in the log up to the given log sequence number once
+// they've been synced to the file, such as by a checkpoint. A log that isn't split into
+// segments is emptied. Otherwise the log's file is left to be completed once it's full,
+// and the completed segments that only hold groups that have been applied are removed,
+// other than the last of them that are retained.
+func (w *WAL) applied(lsn uint64) error {
+	if w.segmentSize <= 0 || w.unnumbered {
+		err := w.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	w.logged = 0
+	done := 0
+	for done < len(w.segments) && w.segments[done].LastLSN <= lsn {
+		done++
+	}
+	remove := max(done-w.retention, 0)
+	for _, segment := range w.segments[:remove] {
+		err := os.Remove(segment.Filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w.segments = w.segments[remove:]
+	return nil
+}
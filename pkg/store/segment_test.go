@@ -0,0 +1,121 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWALSegments(t *testing.T) {
+	// A write outside of a group logs the page and the header, so segments hold two.
+	group := int64(2*walPageRecordSize + walCommitRecordIDSize)
+	store, _ := newCheckpointStore(t, "wal_segments", WithWALSegments(2*group, 2))
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.WALSegments()) != 0 {
+		t.Fatalf("expected %v == %v", store.WALSegments(), nil)
+	}
+	var removed []WALSegmentFile
+	for b := byte(1); b <= 7; b++ {
+		writePages(t, store, []PageID{pageID}, b)
+		if len(store.WALSegments()) == 1 && len(removed) == 0 {
+			removed = store.WALSegments()
+		}
+	}
+	// Every group is checkpointed as it's committed, so only the retained segments are
+	// kept.
+	if store.CheckpointLSN() != store.LSN() {
+		t.Fatalf("expected %v == %v", store.CheckpointLSN(), store.LSN())
+	}
+	segments := store.WALSegments()
+	if len(segments) != 2 {
+		t.Fatalf("expected %v == %v", len(segments), 2)
+	}
+	for i, segment := range segments {
+		if segment.LastLSN != segment.FirstLSN+1 || segment.Size != 2*group {
+			t.Fatalf("expected segment %d to hold two groups, got %+v", i, segment)
+		}
+		if i > 0 && segment.FirstLSN != segments[i-1].LastLSN+1 {
+			t.Fatalf("expected %v == %v", segment.FirstLSN, segments[i-1].LastLSN+1)
+		}
+		read, err := segment.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		described, ok, err := newWALSegment(read.Data)
+		if err != nil || !ok {
+			t.Fatalf("expected segment %d to hold groups, got %v", i, err)
+		}
+		if described.FirstLSN != segment.FirstLSN || described.LastLSN != segment.LastLSN {
+			t.Fatalf("expected %+v == %+v", described, segment)
+		}
+	}
+	if segments[1].LastLSN != store.LSN()-1 {
+		t.Fatalf("expected %v == %v", segments[1].LastLSN, store.LSN()-1)
+	}
+	if len(removed) != 1 || removed[0].FirstLSN == segments[0].FirstLSN {
+		t.Fatalf("expected the oldest segment to have been removed, got %v", removed)
+	}
+	if _, err := removed[0].Read(); !os.IsNotExist(err) {
+		t.Fatalf("expected the segment's file to be removed, got %v", err)
+	}
+}
+
+func TestRecoveryFromWALSegments(t *testing.T) {
+	group := int64(2*walPageRecordSize + walCommitRecordIDSize)
+	opts := []Option{WithWALSegments(2*group, 10), WithCheckpoints(1<<30, 0)}
+	store, filename := newCheckpointStore(t, "recovery_from_wal_segments", opts...)
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := store.LSN()
+	for b := byte(1); b <= 4; b++ {
+		writePages(t, store, []PageID{pageID}, b)
+	}
+	segments := store.WALSegments()
+	if len(segments) < 2 || fileSize(t, walFilename(filename)) != 0 {
+		t.Fatalf("expected every group to be in a completed segment, got %v", segments)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log's file is empty, but its completed segments hold groups since the
+	// checkpoint.
+	_, err = NewPageStore(filename, WithCacheSize(10), WithReadOnly())
+	if err != ErrNeedsRecovery {
+		t.Fatalf("expected %v == %v", err, ErrNeedsRecovery)
+	}
+	reopened, err := NewPageStore(filename, append(opts, WithCacheSize(10))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Recovery().RecordsReplayed != 8 {
+		t.Fatalf("expected %v == %v", reopened.Recovery().RecordsReplayed, 8)
+	}
+	if reopened.CheckpointLSN() != checkpoint+4 {
+		t.Fatalf("expected %v == %v", reopened.CheckpointLSN(), checkpoint+4)
+	}
+	page, err := reopened.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(4)[:UsablePageSize])
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// The segments are retained after recovery.
+	if err := reopened.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.WALSegments()) != len(segments) {
+		t.Fatalf("expected %v == %v", reopened.WALSegments(), segments)
+	}
+}
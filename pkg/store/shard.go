@@ -0,0 +1,105 @@
+package store
+
+import (
+	"sync"
+)
+
+const (
+	// maxCacheShards bounds the number of shards the page cache is split into.
+	maxCacheShards = 16
+	// slotsPerCacheShard is the fewest cache slots a shard is given, so that a small cache
+	// isn't split into shards too small to hold on to anything.
+	slotsPerCacheShard = 64
+)
+
+// cacheShard is one part of the page cache. Pages are spread across the shards by a hash
+// of their id, and every shard has its own lock, so that loading and releasing pages
+// that fall in different shards doesn't contend.
+//
+// Loads and releases hold the store's lock for reading and the shard's lock. Anything
+// holding the store's lock for writing can use every shard without their locks.
+type cacheShard struct {
+	sync.Mutex
+	lookup map[PageID]int
+	// freeList holds the cache slots owned by the shard that aren't in use. Once there
+	// are none left, policy chooses a slot whose page is no longer pinned to evict.
+	freeList *FreeList
+	policy   EvictionPolicy
+}
+
+// newCacheShards splits a cache with the given number of slots into shards and hands
+// out every slot but the first, which holds the header, between them.
+func newCacheShards(
+	capacity int,
+	newPolicy func(int) EvictionPolicy,
+) ([]*cacheShard, error) {
+	n := min(max(capacity/slotsPerCacheShard, 1), maxCacheShards)
+	shards := make([]*cacheShard, n)
+	for i := range shards {
+		// Slots move between shards when pages do, so every shard has to have room to
+		// hold any of them.
+		shards[i] = &cacheShard{
+			lookup:   map[PageID]int{},
+			freeList: NewFreeList(capacity),
+			policy:   newPolicy(capacity),
+		}
+	}
+	for id := 1; id < capacity; id++ {
+		err := shards[id%n].freeList.Enqueue(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return shards, nil
+}
+
+// shard returns the shard a page is cached in.
+func (s *PageStore) shard(pageID PageID) *cacheShard {
+	// Multiplying by a large odd constant spreads out ids that follow a pattern.
+	hash := (uint32(pageID^pageID>>32) * 0x9E3779B1) >> 16
+	return s.shards[hash%uint32(len(s.shards))]
+}
+
+// moveSlot moves the cache slot holding a pinned page from the shard of its old id to
+// the shard of its new one, when the page is moved in copy-on-write mode. The new shard
+// gives the old one a slot in return if it can spare one, so that the shards keep their
+// share of the cache. The store's lock must be held for writing.
+func (s *PageStore) moveSlot(cacheID int, from, to PageID) error {
+	src, dst := s.shard(from), s.shard(to)
+	// The fresh page may still be cached from before it was freed.
+	err := s.uncache(to)
+	if err != nil {
+		return err
+	}
+	delete(src.lookup, from)
+	src.policy.Remove(cacheID)
+	dst.lookup[to] = cacheID
+	s.cache[cacheID].ID = to
+	dst.policy.Loaded(cacheID, to, false)
+	if src == dst {
+		return nil
+	}
+	spare, err := s.nextFreeCacheSlot(dst, to)
+	if err == ErrPageCacheFull {
+		// Every page in the new shard is pinned, so it keeps the extra slot for now.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return src.freeList.Enqueue(spare)
+}
+
+// uncache drops a page that has been freed from the cache, if it's cached, without
+// writing it back. The store's lock must be held for writing.
+func (s *PageStore) uncache(pageID PageID) error {
+	shard := s.shard(pageID)
+	cacheID, ok := shard.lookup[pageID]
+	if !ok {
+		return nil
+	}
+	shard.policy.Remove(cacheID)
+	s.setDirty(cacheID, false)
+	delete(shard.lookup, pageID)
+	return shard.freeList.Enqueue(cacheID)
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestShardedCache(t *testing.T) {
+	store, err := newPageStore("sharded_cache", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.shards) != 4 {
+		t.Fatalf("expected %v == %v", len(store.shards), 4)
+	}
+	loadConcurrently(t, store)
+}
+
+func TestShardedCacheWithDoubleWrite(t *testing.T) {
+	store, err := newPageStore("sharded_cache_double_write", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages written back by different shards at once share the double-write buffer.
+	if err := store.EnableDoubleWrite(); err != nil {
+		t.Fatal(err)
+	}
+	loadConcurrently(t, store)
+}
+
+func loadConcurrently(t *testing.T, store *PageStore) {
+	var pages []PageID
+	for i := 0; i < 400; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := store.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.Buf = *pageFilledWith(byte(pageID))
+		if err := store.Write(pageID); err != nil {
+			t.Fatal(err)
+		}
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	// Readers in different goroutines load pages from every shard at once, evicting and
+	// writing back dirty pages as they go.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				pageID := pages[(i*131+j*17)%len(pages)]
+				page, err := store.Load(pageID)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if page.Buf[0] != byte(pageID) {
+					t.Errorf("expected %v == %v", page.Buf[0], byte(pageID))
+				}
+				if err := page.Release(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
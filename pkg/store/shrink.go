@@ -0,0 +1,296 @@
+package store
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrShrinkInGroup is returned when shrinking the file or sorting the free list between
+// Begin and Commit, while the free list may have changes that haven't reached the file
+// yet.
+var ErrShrinkInGroup = errors.New("can't rewrite the free list while a group is open")
+
+const (
+	// holesOffset is where the header's table of holes starts, and holeSize is the size
+	// of each of its entries. The number of entries is kept before the table.
+	holesOffset = 128
+	holeSize    = 16
+	// maxHoles is the most holes the header has room to record. Once it's full, the
+	// rest of the free pages stay on the free list, keeping their space.
+	maxHoles = 64
+)
+
+// hole is a run of free pages that has been punched out of the file, so that it no
+// longer takes up space on disk. The pages of a hole aren't on the free list, since
+// punching them loses the links between them, so the header keeps track of them instead.
+type hole struct {
+	start uint64
+	count uint64
+}
+
+// Shrink gives the space taken up by free pages back to the file system while the store
+// is open, rather than waiting for Close. Free pages at the end of the file are cut off,
+// and if punchHoles is set, the longest runs of free pages left inside the file are
+// punched out of it with FALLOC_FL_PUNCH_HOLE, up to maxHoles of them. Punched pages
+// are allocated again once the free list is empty, before the file is made any bigger.
+// Holes are only punched on Linux, on file systems that support it. Elsewhere, and for
+// stores that aren't kept in a file, the runs are still taken off the free list, but
+// keep their space. In files with a free-space map, every run of free pages is punched,
+// and punched pages stay free in the map, since they don't need to be read to be found.
+func (s *PageStore) Shrink(punchHoles bool) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.depth > 0 {
+		return ErrShrinkInGroup
+	}
+	if s.backups > 0 {
+		return ErrBackupRunning
+	}
+	// Freeing a page writes its link to the rest of the free list, which has to reach
+	// the file before the list is read from it.
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+	return s.shrink(punchHoles)
+}
+
+// shrink cuts the free pages at the end of the file off, and punches runs of free pages
+// out of it if punchHoles is set. The pages left on the free list are linked back
+// together and synced before the header stops pointing at the old list, so a crash part
+// way through leaks pages rather than corrupting the list. The store's lock must be held
+// for writing.
+func (s *PageStore) shrink(punchHoles bool) error {
+	if s.freeSpace != nil {
+		return s.shrinkFreeSpace(punchHoles)
+	}
+	free, err := s.freePageIDs()
+	if err != nil {
+		return err
+	}
+	isFree := map[PageID]bool{}
+	for _, pageID := range free {
+		isFree[pageID] = true
+	}
+	for _, h := range s.header.holes {
+		for i := uint64(0); i < h.count; i++ {
+			isFree[PageID(h.start+i)] = true
+		}
+	}
+	size := s.header.size
+	for size > 1 && isFree[PageID(size-1)] {
+		size--
+	}
+	var holes []hole
+	for _, h := range s.header.holes {
+		if h.start+h.count > size {
+			h.count = size - min(h.start, size)
+		}
+		if h.count > 0 {
+			holes = append(holes, h)
+		}
+	}
+	var punched []hole
+	if punchHoles {
+		punched = freeRuns(free, size, maxHoles-len(holes))
+	}
+	if size == s.header.size && len(punched) == 0 {
+		return nil
+	}
+	isPunched := map[PageID]bool{}
+	for _, h := range punched {
+		for i := uint64(0); i < h.count; i++ {
+			isPunched[PageID(h.start+i)] = true
+		}
+	}
+	var kept []PageID
+	for _, pageID := range free {
+		if uint64(pageID) < size && !isPunched[pageID] {
+			kept = append(kept, pageID)
+		}
+	}
+	head, err := s.linkFreePages(kept)
+	if err != nil {
+		return err
+	}
+	err = s.sync()
+	if err != nil {
+		return err
+	}
+	s.header.freeList = head
+	s.header.holes = append(holes, punched...)
+	// The file is cut off at the last page in use, taking any preallocated pages with it.
+	s.header.reserved = min(s.header.reserved, size)
+	oldSize := s.header.size
+	s.header.size = size
+	s.header.toBuffer()
+	err = s.syncHeader()
+	if err != nil {
+		return err
+	}
+	return s.cut(oldSize, punched)
+}
+
+// shrinkFreeSpace is shrink for files with a free-space map. The map doesn't need to be
+// able to find punched pages by reading them, so they're left free in it rather than
+// being recorded as holes, and every run of free pages is punched.
+func (s *PageStore) shrinkFreeSpace(punchHoles bool) error {
+	f := s.freeSpace
+	size := s.header.size
+	// A map page at the end of the file has no pages of its own left to cover.
+	for size > 2 && (f.isFree(PageID(size-1)) || f.isMapPage(PageID(size-1))) {
+		size--
+	}
+	var punched []hole
+	if punchHoles {
+		var free []PageID
+		for pageID, ok := f.first(0); ok && uint64(pageID) < size; {
+			free = append(free, pageID)
+			pageID, ok = f.first(uint64(pageID) + 1)
+		}
+		punched = freeRuns(free, size, len(free))
+	}
+	if size == s.header.size && len(punched) == 0 {
+		return nil
+	}
+	// The pages cut off are taken out of the map before the header stops counting them,
+	// so a crash in between leaks them rather than leaving them free past the end.
+	f.cutFreeSpace(size)
+	err := s.writeMapPages()
+	if err != nil {
+		return err
+	}
+	err = s.sync()
+	if err != nil {
+		return err
+	}
+	s.header.reserved = min(s.header.reserved, size)
+	oldSize := s.header.size
+	s.header.size = size
+	s.header.toBuffer()
+	err = s.syncHeader()
+	if err != nil {
+		return err
+	}
+	return s.cut(oldSize, punched)
+}
+
+// cut gives the space of the pages from the header's size to oldSize, and of the punched
+// runs, back to the file system, once the header no longer counts them as in use.
+func (s *PageStore) cut(oldSize uint64, punched []hole) error {
+	size := s.header.size
+	// Cached copies of the pages cut off or punched out may no longer match the file.
+	for pageID := PageID(size); pageID < PageID(oldSize); pageID++ {
+		err := s.uncache(pageID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range punched {
+		for i := uint64(0); i < h.count; i++ {
+			err := s.uncache(PageID(h.start + i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	file, ok := backendFile(s.backend)
+	if ok {
+		for _, h := range punched {
+			err := punchHole(file, pageOffset(PageID(h.start)), int64(h.count)*PageSize)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if size == oldSize {
+		return nil
+	}
+	err := s.backend.Truncate(int64(size) * PageSize)
+	if err != nil || s.mapping == nil {
+		return err
+	}
+	s.mapping.truncated(int64(size) * PageSize)
+	return nil
+}
+
+// freePageIDs reads the free list from the file, from its first page to its last.
+func (s *PageStore) freePageIDs() ([]PageID, error) {
+	var free []PageID
+	for next := s.header.freeList; next != 0; {
+		pageID := PageID(next / PageSize)
+		var buf [PageSize]byte
+		_, err := s.backend.ReadAt(buf[:], pageOffset(pageID))
+		if err == nil {
+			err = s.decodePage(pageID, &buf)
+		}
+		if err != nil {
+			return nil, pageError("read free list", pageID, err)
+		}
+		free = append(free, pageID)
+		next = s.freeLink(buf[:])
+	}
+	return free, nil
+}
+
+// linkFreePages links the pages into a free list in the order given and returns its
+// head. The pages are linked from the back of the list to the front, so a page is only
+// ever linked to pages that have already been linked, and a crash part way through
+// leaks pages from the list the header points at rather than leaving a cycle in it.
+func (s *PageStore) linkFreePages(pages []PageID) (uint64, error) {
+	head := uint64(0)
+	for i := len(pages) - 1; i >= 0; i-- {
+		var buf [PageSize]byte
+		s.putFreeLink(buf[:], head)
+		err := s.writePage(pages[i], &buf)
+		if err != nil {
+			return 0, err
+		}
+		// The page may still be cached from before it was freed.
+		if cacheID, ok := s.shard(pages[i]).lookup[pages[i]]; ok {
+			s.cache[cacheID].Buf = buf
+		}
+		head = uint64(pages[i]) * PageSize
+	}
+	return head, nil
+}
+
+// freeRuns returns up to n of the longest runs of adjacent free pages before size.
+func freeRuns(free []PageID, size uint64, n int) []hole {
+	sorted := append([]PageID{}, free...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var runs []hole
+	for _, pageID := range sorted {
+		if uint64(pageID) >= size {
+			break
+		}
+		last := len(runs) - 1
+		if last >= 0 && runs[last].start+runs[last].count == uint64(pageID) {
+			runs[last].count++
+			continue
+		}
+		runs = append(runs, hole{start: uint64(pageID), count: 1})
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].count > runs[j].count })
+	return runs[:min(len(runs), max(n, 0))]
+}
+
+// allocateFromHole allocates the last page of the last hole. Unlike pages on the free
+// list, punched pages don't link to anything, so they don't need to be read first.
+func (s *PageStore) allocateFromHole() (PageID, error) {
+	last := &s.header.holes[len(s.header.holes)-1]
+	last.count--
+	pageID := PageID(last.start + last.count)
+	if last.count == 0 {
+		s.header.holes = s.header.holes[:len(s.header.holes)-1]
+	}
+	return pageID, s.writeHeader()
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestShrink(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "shrink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	// Holes are only recorded in files with a free list.
+	store, err := NewPageStore(
+		tmpfile.Name(),
+		WithCacheSize(64),
+		WithFormatVersion(FormatVersion2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PageID
+	for i := 0; i < 30; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// Pages 6 to 15 are a run inside the file, and pages 26 to 30 are at the end of it.
+	freed := append(append([]PageID{}, pages[5:15]...), pages[25:]...)
+	for _, pageID := range freed {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.Begin()
+	if err := store.Shrink(false); err != ErrShrinkInGroup {
+		t.Fatalf("expected %v == %v", err, ErrShrinkInGroup)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Shrink(false); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, tmpfile.Name()); size != 26*PageSize {
+		t.Fatalf("expected %v == %v", size, 26*PageSize)
+	}
+	expectFreePages(t, store, 10)
+	if len(store.header.holes) != 0 {
+		t.Fatalf("expected %v == %v", len(store.header.holes), 0)
+	}
+
+	if err := store.Shrink(true); err != nil {
+		t.Fatal(err)
+	}
+	expectFreePages(t, store, 0)
+	expected := []hole{{start: 6, count: 10}}
+	if len(store.header.holes) != 1 || store.header.holes[0] != expected[0] {
+		t.Fatalf("expected %v == %v", store.header.holes, expected)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The holes are kept in the header, and are allocated from before the file grows.
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if len(reopened.header.holes) != 1 || reopened.header.holes[0] != expected[0] {
+		t.Fatalf("expected %v == %v", reopened.header.holes, expected)
+	}
+	for i := 0; i < 11; i++ {
+		pageID, err := reopened.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := PageID(15 - i)
+		if i == 10 {
+			want = 26
+		}
+		if pageID != want {
+			t.Fatalf("expected %v == %v", pageID, want)
+		}
+	}
+	if len(reopened.header.holes) != 0 {
+		t.Fatalf("expected %v == %v", len(reopened.header.holes), 0)
+	}
+}
+
+func TestShrinkCutsOffHolesAtTheEnd(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(64), WithFormatVersion(FormatVersion2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var pages []PageID
+	for i := 0; i < 20; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	writePages(t, store, pages, 1)
+	for _, pageID := range pages[10:15] {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Shrink(true); err != nil {
+		t.Fatal(err)
+	}
+	// Once the pages after the hole are freed, the hole is at the end of the file and is
+	// cut off along with them.
+	for _, pageID := range pages[15:] {
+		if err := store.Free(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Shrink(true); err != nil {
+		t.Fatal(err)
+	}
+	if store.header.size != 11 {
+		t.Fatalf("expected %v == %v", store.header.size, 11)
+	}
+	if len(store.header.holes) != 0 {
+		t.Fatalf("expected %v == %v", len(store.header.holes), 0)
+	}
+	expectFreePages(t, store, 0)
+}
+
+func expectFreePages(t *testing.T, store *PageStore, expected int) {
+	t.Helper()
+	store.Lock()
+	free, err := store.freePageIDs()
+	store.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(free) != expected {
+		t.Fatalf("expected %v == %v", len(free), expected)
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrUncleanShutdown is returned when opening a file WithRefuseUncleanShutdown that was
+// written to, and neither synced nor closed since, the last time it was open.
+var ErrUncleanShutdown = errors.New("file was written to and not synced or closed")
+
+// writingOffset is where the header keeps whether the file is being written to, after
+// its store ID.
+const writingOffset = storeIDOffset + storeIDSize
+
+// The header records whether the file has been written to since it was last synced with
+// Sync or closed with Close. It's set straight in the file, and synced, before the first
+// page written with Write after the file is opened or synced can reach it, so a file
+// that's opened with it set was left part way through being written by a crash, or by
+// a process that was killed, and may need to be checked. Files written through a
+// write-ahead log are marked dirty instead, see RecoveryStats.Unclean, since replaying
+// the log finishes their writes.
+
+// markWriting records in the file that it's being written to, if it hasn't been already.
+// The store's lock must be held.
+func (s *PageStore) markWriting() error {
+	if s.header.writing != 0 || s.wal != nil {
+		return nil
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.setWriting(1)
+}
+
+// clearWriting records in the file that everything written to it has been synced, once
+// it has. Writes staged in a group that hasn't been committed yet have still to reach
+// it, so it's left set until they have. The store's lock must be held.
+func (s *PageStore) clearWriting() error {
+	if s.header.writing == 0 || s.wal != nil || s.depth > 0 {
+		return nil
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.setWriting(0)
+}
+
+// setWriting writes the header as it was last written to the file, with writing set to
+// the given value, and syncs it. The header loaded into the cache may have changes that
+// are yet to be written back, so only the field is changed there. The write lock must be
+// held.
+func (s *PageStore) setWriting(writing uint32) error {
+	header, err := readHeader(s.backend)
+	if err != nil {
+		return err
+	}
+	header.writing = writing
+	header.generation = s.header.generation
+	header.nextGeneration()
+	header.toBuffer()
+	setPageChecksum(&header.Buf)
+	err = s.writeImage(0, &header.Buf)
+	if err == nil {
+		err = s.sync()
+	}
+	if err != nil {
+		return err
+	}
+	s.header.writing = writing
+	s.header.generation = header.generation
+	binary.LittleEndian.PutUint32(s.header.Buf[writingOffset:], writing)
+	// A group committed in copy-on-write mode writes the header it began with first.
+	binary.LittleEndian.PutUint32(s.headerAtBegin[writingOffset:], writing)
+	return nil
+}
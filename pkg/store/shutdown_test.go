@@ -0,0 +1,128 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUncleanShutdown(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "unclean_shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopen := func(opts ...Option) *PageStore {
+		t.Helper()
+		reopened, err := NewPageStore(tmpfile.Name(), append(opts, WithCacheSize(10))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reopened
+	}
+
+	// Opening and reading the file leaves it as it was.
+	store = reopen()
+	if store.Recovery().WrittenSinceSync {
+		t.Fatal("expected a file that was closed not to have been written since a sync")
+	}
+	if readPageFromFile(t, tmpfile.Name(), 0)[writingOffset] != 0 {
+		t.Fatal("expected the file not to be marked as being written to")
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes that were synced are safe.
+	store = reopen(WithRefuseUncleanShutdown())
+	if store.Recovery().WrittenSinceSync {
+		t.Fatal("expected an unwritten file not to have been written since a sync")
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes that weren't leave the file marked, even before they reach it.
+	store = reopen(WithRefuseUncleanShutdown())
+	writePages(t, store, []PageID{pageID}, 2)
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPageStore(tmpfile.Name(), WithRefuseUncleanShutdown())
+	if err != ErrUncleanShutdown {
+		t.Fatalf("expected %v == %v", err, ErrUncleanShutdown)
+	}
+	var logs bytes.Buffer
+	store = reopen(WithLogger(newTestLogger(&logs)))
+	if !store.Recovery().WrittenSinceSync {
+		t.Fatal("expected the file to have been written since it was last synced")
+	}
+	expectLogged(t, &logs, "page store was written to and not synced")
+	expectContents(t, store, pageID, 1)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mark is cleared once the file has been opened again.
+	store = reopen(WithRefuseUncleanShutdown())
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUncleanShutdownCOW(t *testing.T) {
+	store, err := NewMemoryPageStore(WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// Committing a group doesn't clear the mark its writes set.
+	store.Begin()
+	writePages(t, store, []PageID{pageID}, 1)
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	header, err := readHeader(store.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.writing == 0 {
+		t.Fatal("expected the file to be marked as being written to")
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	header, err = readHeader(store.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.writing != 0 {
+		t.Fatal("expected the mark to be cleared once the file was synced")
+	}
+}
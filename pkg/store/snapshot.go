@@ -0,0 +1,96 @@
+package store
+
+// Snapshot gives read access to the pages of the file as they were at the last commit
+// while later groups of writes are committed. It's only available in copy-on-write mode,
+// where committing a group never overwrites the pages the last committed version uses
+// but frees them instead. While a snapshot is open, those frees are held back until no
+// open snapshot is old enough to read the pages. Held back pages are leaked rather than
+// freed if the process crashes before they're put on the free list.
+type Snapshot struct {
+	store   *PageStore
+	version uint64
+	closed  bool
+}
+
+// heldFrees are the pages freed by the group that committed a version.
+type heldFrees struct {
+	version uint64
+	pages   []PageID
+}
+
+// Snapshot opens a snapshot of the last committed version of the file. It must be closed
+// once it's no longer needed so that the pages it can read can be reused.
+func (s *PageStore) Snapshot() (*Snapshot, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if !s.cow {
+		return nil, ErrSnapshotNeedsCOW
+	}
+	s.snapshots[s.version]++
+	return &Snapshot{store: s, version: s.version}, nil
+}
+
+// Load reads a page as it was when the snapshot was taken. Pages written since then have
+// moved to fresh pages, so unlike PageStore.Load this doesn't follow them. The page must
+// be released before the next write to the store, which may move it.
+func (snap *Snapshot) Load(pageID PageID) (*PageHandle, error) {
+	snap.store.RLock()
+	defer snap.store.RUnlock()
+	if snap.closed {
+		return nil, ErrSnapshotClosed
+	}
+	return snap.store.load(pageID)
+}
+
+// Close closes the snapshot and frees any pages that were only kept for it.
+func (snap *Snapshot) Close() error {
+	s := snap.store
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		// Closing the store freed the pages held back for its snapshots.
+		return ErrClosed
+	}
+	if snap.closed {
+		return ErrSnapshotClosed
+	}
+	snap.closed = true
+	s.snapshots[snap.version]--
+	if s.snapshots[snap.version] == 0 {
+		delete(s.snapshots, snap.version)
+	}
+	if s.depth > 0 {
+		// The group's commit frees them.
+		return nil
+	}
+	return s.freeHeld()
+}
+
+// freeHeld frees the held back pages that no open snapshot can read. A page freed by the
+// group that committed a version can only be read by snapshots of earlier versions.
+func (s *PageStore) freeHeld() error {
+	var pages []PageID
+	kept := s.held[:0]
+	for _, frees := range s.held {
+		if s.snapshotBefore(frees.version) {
+			kept = append(kept, frees)
+			continue
+		}
+		pages = append(pages, frees.pages...)
+	}
+	s.held = kept
+	return s.freePages(pages)
+}
+
+// snapshotBefore returns whether any open snapshot is of a version earlier than version.
+func (s *PageStore) snapshotBefore(version uint64) bool {
+	for v := range s.snapshots {
+		if v < version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestSnapshotHoldsFreedPages(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "snapshot_holds_frees")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Snapshot(); err != ErrSnapshotNeedsCOW {
+		t.Fatalf("expected %v == %v", err, ErrSnapshotNeedsCOW)
+	}
+	if err := store.EnableCOW(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Buf = *pageFilledWith(1)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Begin()
+	page.Buf = *pageFilledWith(2)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// The snapshot still reads the page as it was, and it isn't reused while the snapshot
+	// is open.
+	old, err := snap.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, old.Buf[:UsablePageSize], pageFilledWith(1)[:UsablePageSize])
+	if err := old.Release(); err != nil {
+		t.Fatal(err)
+	}
+	allocated, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated == pageID {
+		t.Fatal("expected the page not to be reused while the snapshot is open")
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snap.Load(pageID); err != ErrSnapshotClosed {
+		t.Fatalf("expected %v == %v", err, ErrSnapshotClosed)
+	}
+	allocated, err = store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated != pageID {
+		t.Fatalf("expected %v == %v", allocated, pageID)
+	}
+}
@@ -0,0 +1,39 @@
+package store
+
+// Stats describes how the pages of a store's file are used, so that its growth can be
+// watched and it can be compacted once enough of it is free.
+type Stats struct {
+	// Pages is the number of pages allocated in the file so far, counting the header, the
+	// pages of the free-space map and the pages that have been freed since.
+	Pages int
+	// FreePages is the number of pages that have been freed and not allocated again.
+	FreePages int
+	// FileSize is the length of the file in bytes, which includes the space reserved ahead
+	// of allocations when the file is preallocated, see WithPreallocation. Free pages
+	// punched out of the file by Shrink still count towards it.
+	FileSize int64
+	// Fragmentation is the fraction of the file's pages that are free, from 0 for a file
+	// with no free pages up to 1. It's how much of the file could be given back by moving
+	// the pages in use to the front of it and shrinking it.
+	Fragmentation float64
+}
+
+// Stats returns how the pages of the file are used. Files in formats older than
+// FormatVersion3 have to read their free list to count the free pages.
+func (s *PageStore) Stats() (Stats, error) {
+	s.headerLock.Lock()
+	defer s.headerLock.Unlock()
+	free, err := s.countFreePages()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{
+		Pages:     int(s.header.size),
+		FreePages: free,
+		FileSize:  pageOffset(PageID(max(s.header.size, s.header.reserved))),
+	}
+	if stats.Pages > 0 {
+		stats.Fragmentation = float64(stats.FreePages) / float64(stats.Pages)
+	}
+	return stats, nil
+}
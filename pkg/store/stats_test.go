@@ -0,0 +1,82 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	for _, version := range []int{FormatVersion2, FormatVersion3} {
+		store, err := NewMemoryPageStore(WithCacheSize(20), WithFormatVersion(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := store.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A new file only holds its header, and in newer formats the free-space map.
+		initial := stats.Pages
+		expectStats(t, stats, Stats{Pages: initial, FileSize: int64(initial) * PageSize})
+		var pages []PageID
+		for i := 0; i < 8; i++ {
+			pageID, err := store.Allocate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, pageID)
+		}
+		for _, pageID := range pages[:2] {
+			if err := store.Free(pageID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stats, err = store.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStats(t, stats, Stats{
+			Pages:         initial + 8,
+			FreePages:     2,
+			FileSize:      int64(initial+8) * PageSize,
+			Fragmentation: 2 / float64(initial+8),
+		})
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStatsPreallocated(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "stats_preallocated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithPreallocation(16*PageSize, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The space reserved ahead of allocations is part of the file, but not of its pages.
+	if stats.Pages != 4 {
+		t.Fatalf("expected %v == %v", stats.Pages, 4)
+	}
+	if size := fileSize(t, tmpfile.Name()); stats.FileSize != size {
+		t.Fatalf("expected %v == %v", stats.FileSize, size)
+	}
+}
+
+func expectStats(t *testing.T, stats, expected Stats) {
+	t.Helper()
+	if stats != expected {
+		t.Fatalf("expected %+v == %+v", stats, expected)
+	}
+}
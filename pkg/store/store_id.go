@@ -0,0 +1,117 @@
+package store
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrWrongStore is returned when replaying a write-ahead log, or applying a segment of
+// one or an incremental backup, to a file other than the one it was written for, as told
+// by their store IDs, see PageStore.ID.
+var ErrWrongStore = errors.New("write-ahead log or backup belongs to another file")
+
+const (
+	// storeIDOffset is where the header keeps the file's store ID, after its generation.
+	storeIDOffset = generationOffset + 8
+	// storeIDSize is the size of a store ID.
+	storeIDSize = 16
+)
+
+// StoreID identifies a file, from when it's created, so that the groups of writes
+// committed to its write-ahead log, and the backups taken of it, can be told apart from
+// those of any other file. It's a randomly generated, version 4 UUID. Files written by
+// older versions of this package are given one the first time they're opened for
+// writing, and the logs and backups they wrote before then have the zero StoreID, which
+// is taken to belong to any file.
+type StoreID [storeIDSize]byte
+
+// newStoreID generates a new, random StoreID.
+func newStoreID() (StoreID, error) {
+	var id StoreID
+	_, err := io.ReadFull(rand.Reader, id[:])
+	if err != nil {
+		return id, err
+	}
+	// Mark it as a version 4, RFC 4122 variant UUID.
+	id[6] = id[6]&0x0F | 0x40
+	id[8] = id[8]&0x3F | 0x80
+	return id, nil
+}
+
+// String writes the ID out in the canonical form of a UUID.
+func (id StoreID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// IsZero returns whether the ID is the zero StoreID, of a file that hasn't been given
+// one.
+func (id StoreID) IsZero() bool {
+	return id == StoreID{}
+}
+
+// matches returns whether a log or backup stamped with the ID can be applied to the file
+// with the other, which it can unless both are known and differ.
+func (id StoreID) matches(other StoreID) bool {
+	return id.IsZero() || other.IsZero() || id == other
+}
+
+// ID returns the file's store ID, which is stamped into the groups committed to its
+// write-ahead log and the backups taken of it.
+func (s *PageStore) ID() StoreID {
+	s.RLock()
+	defer s.RUnlock()
+	return s.header.id
+}
+
+// assignID gives the file a new store ID, if it doesn't have one yet.
+func (s *PageStore) assignID() error {
+	if !s.header.id.IsZero() {
+		return nil
+	}
+	id, err := newStoreID()
+	if err != nil {
+		return err
+	}
+	s.header.id = id
+	return nil
+}
+
+// readID reads the store ID from the header in the file, before it's loaded, or returns
+// the zero StoreID if the header can't be read.
+func (s *PageStore) readID() StoreID {
+	header, err := readHeader(s.backend)
+	if err != nil {
+		return StoreID{}
+	}
+	return header.id
+}
+
+// checkID returns ErrWrongStore if any group in the log, or in its completed segments,
+// is stamped with a store ID other than the log's.
+func (w *WAL) checkID() error {
+	if w.id.IsZero() {
+		return nil
+	}
+	check := func(g *walGroup) error {
+		if !g.id.matches(w.id) {
+			return ErrWrongStore
+		}
+		return nil
+	}
+	for _, segment := range w.segments {
+		file, err := os.Open(segment.Filename)
+		if err != nil {
+			return err
+		}
+		_, err = readGroups(file, check)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := readGroups(w.reader(), check)
+	return err
+}
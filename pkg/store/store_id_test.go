@@ -0,0 +1,169 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStoreID(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "store_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := store.ID()
+	if id.IsZero() || id[6]>>4 != 4 || len(id.String()) != 36 {
+		t.Fatalf("expected %v to be a version 4 UUID", id)
+	}
+	other, err := OpenBackend(NewMemoryBackend(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID() == id {
+		t.Fatalf("expected %v != %v", other.ID(), id)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files from before store IDs are given one when they're next opened for writing.
+	store.header.id = StoreID{}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.ID().IsZero() {
+		t.Fatalf("expected %v to be zero", reopened.ID())
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = NewPageStore(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id = reopened.ID()
+	if id.IsZero() {
+		t.Fatal("expected the file to have been given a store ID")
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = NewPageStore(tmpfile.Name(), WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.ID() != id {
+		t.Fatalf("expected %v == %v", reopened.ID(), id)
+	}
+}
+
+func TestStoreIDRefusesOtherLogs(t *testing.T) {
+	var segments []WALSegment
+	archiver := WALArchiveFunc(func(segment WALSegment) error {
+		segments = append(segments, segment)
+		return nil
+	})
+	store, _ := newCheckpointStore(t, "store_id_logs", WithWALArchive(archiver))
+	defer store.Close()
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherFilename := newCheckpointStore(t, "store_id_other_logs")
+	if _, err := other.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if err := other.Backup(&backup); err != nil {
+		t.Fatal(err)
+	}
+	writePages(t, store, []PageID{pageID}, 1)
+
+	// A segment of one file's log can't be applied to a copy of another.
+	replica := NewMemoryBackend()
+	if err := Restore(&backup, replica); err != nil {
+		t.Fatal(err)
+	}
+	segment := segments[len(segments)-1]
+	if _, err := ApplyWALSegment(replica, segment); err != ErrWrongStore {
+		t.Fatalf("expected %v == %v", err, ErrWrongStore)
+	}
+
+	// Nor is it replayed into another file left with it in place of its own log.
+	if err := other.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(walFilename(otherFilename), segment.Data, 0660); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPageStore(otherFilename, WithCacheSize(10))
+	if err != ErrWrongStore {
+		t.Fatalf("expected %v == %v", err, ErrWrongStore)
+	}
+}
+
+func TestStoreIDRefusesOtherBackups(t *testing.T) {
+	store, err := OpenBackend(NewMemoryBackend(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	other, err := OpenBackend(NewMemoryBackend(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	var base, otherBase bytes.Buffer
+	if err := store.Backup(&base); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Backup(&otherBase); err != nil {
+		t.Fatal(err)
+	}
+
+	// An incremental backup can't be taken against a backup of another file.
+	backup, err := other.StartBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var incremental bytes.Buffer
+	_, err = backup.WriteIncrementalTo(&incremental, bytes.NewReader(base.Bytes()))
+	if err != ErrWrongStore {
+		t.Fatalf("expected %v == %v", err, ErrWrongStore)
+	}
+	if err := backup.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nor applied to another file.
+	backup, err = store.StartBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backup.WriteIncrementalTo(&incremental, bytes.NewReader(base.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backend := NewMemoryBackend()
+	if err := Restore(&otherBase, backend); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyIncremental(&incremental, backend); err != ErrWrongStore {
+		t.Fatalf("expected %v == %v", err, ErrWrongStore)
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidSyncInterval is returned when asking for periodic syncs without a positive
+// interval.
+var ErrInvalidSyncInterval = errors.New("sync interval must be positive")
+
+// Durability chooses when writes that go straight to the file are synced to disk. A
+// write-ahead log and copy-on-write mode sync as part of every commit whatever the
+// durability, because they rely on the order writes reach the disk in.
+type Durability int
+
+const (
+	// SyncNone leaves syncing to the operating system, or to calls to Sync. It's the
+	// fastest, but writes made shortly before a power failure can be lost.
+	SyncNone Durability = iota
+	// SyncEveryCommit syncs the file at the end of every outermost Commit and after every
+	// write outside of a group, so nothing that was committed is lost.
+	SyncEveryCommit
+	// SyncPeriodic syncs the file in the background on a fixed interval, bounding how
+	// much can be lost to the writes made in the last interval.
+	SyncPeriodic
+)
+
+// SetDurability chooses when the file is synced. The interval is only used by
+// SyncPeriodic.
+func (s *PageStore) SetDurability(durability Durability, interval time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	if durability == SyncPeriodic && interval <= 0 {
+		return ErrInvalidSyncInterval
+	}
+	if s.stopSyncing != nil {
+		close(s.stopSyncing)
+		s.stopSyncing = nil
+	}
+	s.durability = durability
+	if durability == SyncPeriodic {
+		s.stopSyncing = make(chan struct{})
+		go s.syncPeriodically(interval, s.stopSyncing)
+	}
+	return nil
+}
+
+// Sync writes every dirty page back to the file and syncs it to disk. Writes staged in a
+// group that hasn't been committed yet aren't in the file, so they aren't synced. It also
+// returns the error from a failed background sync, see SyncPeriodic. Once everything has
+// been synced, the file is no longer marked as being written to, see markWriting.
+func (s *PageStore) Sync() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	err := s.flush()
+	if err == nil {
+		err = s.sync()
+	}
+	if err == nil {
+		err = s.syncErr
+	}
+	if err == nil {
+		err = s.clearWriting()
+	}
+	s.syncErr = nil
+	return err
+}
+
+func (s *PageStore) sync() error {
+	start := time.Now()
+	err := s.backend.Sync()
+	s.counters.observeIO(start)
+	s.counters.syncs.Add(1)
+	if err != nil {
+		return err
+	}
+	s.unsynced = false
+	return nil
+}
+
+// syncCommitted writes back the dirty pages and syncs the file at the end of a commit if
+// the durability asks for it.
+func (s *PageStore) syncCommitted() error {
+	if s.durability != SyncEveryCommit {
+		return nil
+	}
+	err := s.flush()
+	if err != nil || !s.unsynced {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *PageStore) syncPeriodically(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.Lock()
+			err := s.flush()
+			if err == nil && s.unsynced {
+				err = s.sync()
+			}
+			if err != nil {
+				s.backgroundError("sync", err)
+			}
+			s.Unlock()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDurability(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "durability")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetDurability(SyncPeriodic, 0); err != ErrInvalidSyncInterval {
+		t.Fatalf("expected %v == %v", err, ErrInvalidSyncInterval)
+	}
+	if _, err := store.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasUnsyncedWrites(store) {
+		t.Fatal("expected writes to be left unsynced")
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if hasUnsyncedWrites(store) {
+		t.Fatal("expected Sync to sync the writes")
+	}
+
+	if err := store.SetDurability(SyncEveryCommit, 0); err != nil {
+		t.Fatal(err)
+	}
+	store.Begin()
+	if _, err := store.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasUnsyncedWrites(store) {
+		t.Fatal("expected writes in a group to be synced by the commit")
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if hasUnsyncedWrites(store) {
+		t.Fatal("expected the commit to sync the writes")
+	}
+
+	if err := store.SetDurability(SyncPeriodic, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if !hasUnsyncedWrites(store) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the writes to be synced in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := store.SetDurability(SyncNone, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hasUnsyncedWrites returns whether the store holds writes that haven't been synced to
+// disk, whether they've been written back to the file yet or not.
+func hasUnsyncedWrites(s *PageStore) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, dirty := range s.dirty {
+		if dirty {
+			return true
+		}
+	}
+	return s.unsynced
+}
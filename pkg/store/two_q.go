@@ -0,0 +1,94 @@
+package store
+
+// TwoQ is the 2Q policy, which keeps a scan of pages that are only read once from
+// pushing the pages that are read over and over out of the cache. Pages start out in a
+// small first-in first-out queue, and they're only promoted to the main LRU queue if
+// they're loaded again soon after being evicted from it, which is remembered with a
+// list of the ids of recently evicted pages.
+type TwoQ struct {
+	// in holds the slots of pages that have been loaded once, oldest first, and main
+	// holds the slots of pages that have been promoted, least recently used first.
+	in   *slotList
+	main *slotList
+	// out holds the ids of the pages recently evicted from in.
+	out       *ghostList
+	evictable []bool
+	pages     []PageID
+	// inSize is how many slots in can take up before its pages are evicted first, and
+	// outSize is how many evicted pages are remembered.
+	inSize  int
+	outSize int
+}
+
+// NewTwoQ creates a 2Q policy for a cache with the given number of slots.
+func NewTwoQ(capacity int) *TwoQ {
+	return &TwoQ{
+		in:        newSlotList(capacity),
+		main:      newSlotList(capacity),
+		out:       newGhostList(),
+		evictable: make([]bool, capacity),
+		pages:     make([]PageID, capacity),
+		inSize:    max(capacity/4, 1),
+		outSize:   max(capacity/2, 1),
+	}
+}
+
+// Loaded implements EvictionPolicy.
+func (q *TwoQ) Loaded(cacheID int, pageID PageID, hit bool) {
+	q.evictable[cacheID] = false
+	q.pages[cacheID] = pageID
+	if hit {
+		// Loading a page again while it's still in the first queue doesn't count, because
+		// those loads tend to come in bursts.
+		if q.main.in[cacheID] {
+			q.main.push(cacheID)
+		}
+		return
+	}
+	if q.out.contains(pageID) {
+		q.out.remove(pageID)
+		q.main.push(cacheID)
+		return
+	}
+	q.in.push(cacheID)
+}
+
+// Released implements EvictionPolicy.
+func (q *TwoQ) Released(cacheID int) {
+	q.evictable[cacheID] = true
+}
+
+// Evict implements EvictionPolicy.
+func (q *TwoQ) Evict(pageID PageID) (int, bool) {
+	evictable := func(id int) bool { return q.evictable[id] }
+	if q.in.size > q.inSize {
+		if id, ok := q.in.first(evictable); ok {
+			return q.evictIn(id), true
+		}
+	}
+	if id, ok := q.main.first(evictable); ok {
+		q.main.remove(id)
+		q.evictable[id] = false
+		return id, true
+	}
+	if id, ok := q.in.first(evictable); ok {
+		return q.evictIn(id), true
+	}
+	return 0, false
+}
+
+// evictIn evicts a slot from the first queue, remembering its page.
+func (q *TwoQ) evictIn(id int) int {
+	q.in.remove(id)
+	q.evictable[id] = false
+	q.out.push(q.pages[id])
+	q.out.trim(q.outSize)
+	return id
+}
+
+// Remove implements EvictionPolicy.
+func (q *TwoQ) Remove(cacheID int) {
+	q.in.remove(cacheID)
+	q.main.remove(cacheID)
+	q.evictable[cacheID] = false
+}
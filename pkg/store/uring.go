@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrIOUringUnsupported is returned by NewIOUringBackend on platforms without io_uring,
+// and on Linux kernels that don't have it or don't let this process use it.
+var ErrIOUringUnsupported = errors.New("io_uring is not supported")
+
+// ringEntries is the number of reads or writes an IOUringBackend has in flight at once.
+// Bigger batches are submitted a ring at a time.
+const ringEntries = 64
+
+// BatchOp is one read or write of a batch, see BatchBackend.
+type BatchOp struct {
+	Buf []byte
+	Off int64
+	// N and Err are what reading or writing Buf at Off returned, as ReadAt or WriteAt
+	// would have.
+	N   int
+	Err error
+}
+
+// BatchBackend is a Backend that can read or write many pages with one call, overlapping
+// them rather than waiting for each in turn. When a store's backend is a BatchBackend,
+// it writes its dirty pages back with one batch, see flush.
+type BatchBackend interface {
+	Backend
+	// ReadBatch reads every op, setting its N and Err. The error returned is for the batch
+	// as a whole, which leaves the ops that hadn't finished without their N and Err.
+	ReadBatch(ops []BatchOp) error
+	// WriteBatch writes every op, setting its N and Err like ReadBatch.
+	WriteBatch(ops []BatchOp) error
+}
+
+// IOUringBackend is an experimental BatchBackend for files on Linux, which submits reads
+// and writes to the kernel through an io_uring. The reads or writes of a batch are all
+// submitted with one syscall and run at once, which lets the disk work through them in
+// whatever order suits it. Syncing and truncating go to the file as usual.
+type IOUringBackend struct {
+	file *os.File
+	ring *ring
+}
+
+// NewIOUringBackend returns an IOUringBackend for the file, which it takes ownership of.
+// It returns ErrIOUringUnsupported where there's no io_uring, leaving the file open.
+func NewIOUringBackend(file *os.File) (*IOUringBackend, error) {
+	r, err := newRing(file, ringEntries)
+	if err != nil {
+		return nil, err
+	}
+	return &IOUringBackend{file: file, ring: r}, nil
+}
+
+// ReadAt reads len(p) bytes at offset off, returning io.EOF if they run past the end of
+// the file.
+func (b *IOUringBackend) ReadAt(p []byte, off int64) (int, error) {
+	ops := []BatchOp{{Buf: p, Off: off}}
+	err := b.ReadBatch(ops)
+	if err != nil {
+		return 0, err
+	}
+	return ops[0].N, ops[0].Err
+}
+
+// WriteAt writes p at offset off.
+func (b *IOUringBackend) WriteAt(p []byte, off int64) (int, error) {
+	ops := []BatchOp{{Buf: p, Off: off}}
+	err := b.WriteBatch(ops)
+	if err != nil {
+		return 0, err
+	}
+	return ops[0].N, ops[0].Err
+}
+
+// ReadBatch reads every op at once.
+func (b *IOUringBackend) ReadBatch(ops []BatchOp) error {
+	err := b.ring.submit(ops, false)
+	if err != nil {
+		return err
+	}
+	b.finish(ops, false)
+	return nil
+}
+
+// WriteBatch writes every op at once.
+func (b *IOUringBackend) WriteBatch(ops []BatchOp) error {
+	err := b.ring.submit(ops, true)
+	if err != nil {
+		return err
+	}
+	b.finish(ops, true)
+	return nil
+}
+
+// finish completes the ops that the ring only read or wrote part of. Like a read or
+// write syscall, the kernel can stop short, which ReadAt and WriteAt on the file carry on
+// from until they're done or reach the end of the file.
+func (b *IOUringBackend) finish(ops []BatchOp, write bool) {
+	for i := range ops {
+		op := &ops[i]
+		if op.Err != nil || op.N == len(op.Buf) {
+			continue
+		}
+		var n int
+		if write {
+			n, op.Err = b.file.WriteAt(op.Buf[op.N:], op.Off+int64(op.N))
+		} else {
+			n, op.Err = b.file.ReadAt(op.Buf[op.N:], op.Off+int64(op.N))
+		}
+		op.N += n
+	}
+}
+
+// Sync makes every write so far durable.
+func (b *IOUringBackend) Sync() error {
+	return b.file.Sync()
+}
+
+// Truncate cuts the file off, or extends it with zeros, to size bytes.
+func (b *IOUringBackend) Truncate(size int64) error {
+	return b.file.Truncate(size)
+}
+
+// Close tears down the ring and closes the file.
+func (b *IOUringBackend) Close() error {
+	err := b.ring.close()
+	closeErr := b.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// backendFile returns the file a backend reads and writes, if it's kept in one.
+func backendFile(backend Backend) (*os.File, bool) {
+	switch b := backend.(type) {
+	case *os.File:
+		return b, true
+	case *IOUringBackend:
+		return b.file, true
+	}
+	return nil, false
+}
@@ -0,0 +1,287 @@
+//go:build linux
+
+package store
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The io_uring syscalls have the same numbers on every architecture.
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+)
+
+const (
+	// The offsets the rings and submission queue entries are mapped at.
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+	// Vectored reads and writes are used rather than plain ones because they've been
+	// around since io_uring was added.
+	ioringOpReadv  = 1
+	ioringOpWritev = 2
+	// ioringEnterGetEvents waits for completions as well as submitting entries.
+	ioringEnterGetEvents = 1
+)
+
+// errRingBroken is returned by a ring that failed part way through a batch, since the
+// kernel may still have been using the batch's buffers.
+var errRingBroken = errors.New("io_uring failed part way through a batch")
+
+// ioSQRingOffsets, ioCQRingOffsets and ioUringParams are laid out like the kernel's
+// io_sqring_offsets, io_cqring_offsets and io_uring_params.
+type ioSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type ioCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+// ioUringSQE and ioUringCQE are laid out like the kernel's io_uring_sqe and io_uring_cqe.
+type ioUringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	rwFlags  uint32
+	userData uint64
+	pad      [3]uint64
+}
+
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ring is an io_uring for reading and writing one file. Batches are submitted one at a
+// time, and each waits for all of its completions before the next is submitted, so the
+// queues never hold more than one batch.
+type ring struct {
+	lock   sync.Mutex
+	fd     int
+	fileFd int32
+	// sqRing, cqRing and sqeData are the kernel's memory that the queues are mapped from.
+	sqRing, cqRing, sqeData []byte
+	sqTail, sqMask          *uint32
+	sqArray                 []uint32
+	sqes                    []ioUringSQE
+	cqHead, cqTail, cqMask  *uint32
+	cqes                    []ioUringCQE
+	entries                 int
+	// iovecs holds the buffer of each entry in flight. The kernel reads them while the
+	// entries are submitted, so they're kept on the heap where they won't move.
+	iovecs []syscall.Iovec
+	broken bool
+}
+
+func newRing(file *os.File, entries int) (*ring, error) {
+	var params ioUringParams
+	fd, _, errno := syscall.Syscall(
+		sysIOUringSetup,
+		uintptr(entries),
+		uintptr(unsafe.Pointer(&params)),
+		0,
+	)
+	if errno != 0 {
+		if errno == syscall.ENOSYS || errno == syscall.EPERM || errno == syscall.EACCES {
+			// The kernel is too old, or io_uring has been turned off or filtered out.
+			return nil, ErrIOUringUnsupported
+		}
+		return nil, errno
+	}
+	r := &ring{
+		fd:      int(fd),
+		fileFd:  int32(file.Fd()),
+		entries: int(params.sqEntries),
+		iovecs:  make([]syscall.Iovec, params.sqEntries),
+	}
+	err := r.mapQueues(&params)
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// mapQueues maps the submission and completion queues the kernel set up.
+func (r *ring) mapQueues(p *ioUringParams) error {
+	var err error
+	flags := syscall.MAP_SHARED | syscall.MAP_POPULATE
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	r.sqRing, err = syscall.Mmap(r.fd, ioringOffSQRing, sqSize, prot, flags)
+	if err != nil {
+		return err
+	}
+	cqSize := int(p.cqOff.cqes) + int(p.cqEntries)*int(unsafe.Sizeof(ioUringCQE{}))
+	r.cqRing, err = syscall.Mmap(r.fd, ioringOffCQRing, cqSize, prot, flags)
+	if err != nil {
+		return err
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(ioUringSQE{}))
+	r.sqeData, err = syscall.Mmap(r.fd, ioringOffSQEs, sqeSize, prot, flags)
+	if err != nil {
+		return err
+	}
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	array := (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array]))
+	r.sqArray = unsafe.Slice(array, p.sqEntries)
+	r.sqes = unsafe.Slice((*ioUringSQE)(unsafe.Pointer(&r.sqeData[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	cqes := (*ioUringCQE)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes]))
+	r.cqes = unsafe.Slice(cqes, p.cqEntries)
+	return nil
+}
+
+// submit reads or writes every op, a ring at a time.
+func (r *ring) submit(ops []BatchOp, write bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.broken {
+		return errRingBroken
+	}
+	var opcode uint8 = ioringOpReadv
+	if write {
+		opcode = ioringOpWritev
+	}
+	for len(ops) > 0 {
+		n := min(len(ops), r.entries)
+		err := r.run(ops[:n], opcode)
+		if err != nil {
+			r.broken = true
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// run submits no more ops than the ring has entries, and waits for all of them to
+// complete.
+func (r *ring) run(ops []BatchOp, opcode uint8) error {
+	// Only this side of the ring moves the submission queue's tail and the completion
+	// queue's head, so they're read without waiting on the kernel.
+	tail := atomic.LoadUint32(r.sqTail)
+	mask := atomic.LoadUint32(r.sqMask)
+	for i := range ops {
+		iovec := &r.iovecs[i]
+		iovec.Base = nil
+		if len(ops[i].Buf) > 0 {
+			iovec.Base = &ops[i].Buf[0]
+		}
+		iovec.SetLen(len(ops[i].Buf))
+		index := tail & mask
+		r.sqes[index] = ioUringSQE{
+			opcode:   opcode,
+			fd:       r.fileFd,
+			off:      uint64(ops[i].Off),
+			addr:     uint64(uintptr(unsafe.Pointer(iovec))),
+			len:      1,
+			userData: uint64(i),
+		}
+		r.sqArray[index] = index
+		tail++
+	}
+	// The entries have to be filled in before the kernel sees the new tail.
+	atomic.StoreUint32(r.sqTail, tail)
+	toSubmit := len(ops)
+	completed := 0
+	for completed < len(ops) {
+		n, _, errno := syscall.Syscall6(
+			sysIOUringEnter,
+			uintptr(r.fd),
+			uintptr(toSubmit),
+			1,
+			ioringEnterGetEvents,
+			0,
+			0,
+		)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		toSubmit -= int(n)
+		completed += r.reap(ops)
+	}
+	// The buffers have to outlive the kernel's use of them.
+	runtime.KeepAlive(ops)
+	return nil
+}
+
+// reap records the results of the completions that have come in, returning how many
+// there were.
+func (r *ring) reap(ops []BatchOp) int {
+	head := atomic.LoadUint32(r.cqHead)
+	tail := atomic.LoadUint32(r.cqTail)
+	mask := atomic.LoadUint32(r.cqMask)
+	reaped := 0
+	for ; head != tail; head++ {
+		cqe := r.cqes[head&mask]
+		op := &ops[cqe.userData]
+		if cqe.res < 0 {
+			op.Err = syscall.Errno(-cqe.res)
+		} else {
+			op.N = int(cqe.res)
+		}
+		reaped++
+	}
+	// The kernel can reuse the completions once it sees the new head.
+	atomic.StoreUint32(r.cqHead, head)
+	return reaped
+}
+
+// close unmaps the queues and closes the ring.
+func (r *ring) close() error {
+	for _, data := range [][]byte{r.sqeData, r.cqRing, r.sqRing} {
+		if data != nil {
+			syscall.Munmap(data)
+		}
+	}
+	return syscall.Close(r.fd)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package store
+
+import "os"
+
+type ring struct{}
+
+func newRing(file *os.File, entries int) (*ring, error) {
+	return nil, ErrIOUringUnsupported
+}
+
+func (r *ring) submit(ops []BatchOp, write bool) error {
+	return ErrIOUringUnsupported
+}
+
+func (r *ring) close() error {
+	return nil
+}
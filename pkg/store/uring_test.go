@@ -0,0 +1,146 @@
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newIOUringBackend(t *testing.T) (*IOUringBackend, string) {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", "uring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewIOUringBackend(tmpfile)
+	if err == ErrIOUringUnsupported {
+		tmpfile.Close()
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, tmpfile.Name()
+}
+
+func TestIOUringBackendBatches(t *testing.T) {
+	backend, filename := newIOUringBackend(t)
+	defer backend.Close()
+	// More pages than the ring has entries are written a ring at a time.
+	ops := make([]BatchOp, ringEntries*2+1)
+	for i := range ops {
+		ops[i] = BatchOp{Buf: pageFilledWith(byte(i))[:], Off: int64(i) * PageSize}
+	}
+	if err := backend.WriteBatch(ops); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range ops {
+		if op.Err != nil {
+			t.Fatal(op.Err)
+		}
+		if op.N != PageSize {
+			t.Fatalf("expected %v == %v", op.N, PageSize)
+		}
+	}
+	for i := range ops {
+		assertBufEqual(t, readPageFromFile(t, filename, PageID(i)), pageFilledWith(byte(i))[:])
+	}
+
+	reads := make([]BatchOp, len(ops))
+	for i := range reads {
+		// Read the pages back in reverse, so that the batch isn't in the file's order.
+		reads[i] = BatchOp{Buf: make([]byte, PageSize), Off: ops[len(ops)-1-i].Off}
+	}
+	if err := backend.ReadBatch(reads); err != nil {
+		t.Fatal(err)
+	}
+	for i, op := range reads {
+		if op.Err != nil {
+			t.Fatal(op.Err)
+		}
+		assertBufEqual(t, op.Buf, ops[len(ops)-1-i].Buf)
+	}
+}
+
+func TestIOUringBackendReadsPastTheEnd(t *testing.T) {
+	backend, _ := newIOUringBackend(t)
+	defer backend.Close()
+	if _, err := backend.WriteAt(pageFilledWith(1)[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, PageSize)
+	n, err := backend.ReadAt(buf, PageSize/2)
+	if err != io.EOF {
+		t.Fatalf("expected %v == %v", err, io.EOF)
+	}
+	if n != PageSize/2 {
+		t.Fatalf("expected %v == %v", n, PageSize/2)
+	}
+	n, err = backend.ReadAt(buf, PageSize)
+	if err != io.EOF || n != 0 {
+		t.Fatalf("expected %v, %v == 0, %v", n, err, io.EOF)
+	}
+}
+
+func TestIOUringFlush(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "uring_flush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(64), WithIOUring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.backend.(*IOUringBackend); !ok {
+		// Without io_uring the store falls back to reading and writing the file.
+		if _, ok := store.backend.(*os.File); !ok {
+			t.Fatalf("expected the store to fall back to the file, got %T", store.backend)
+		}
+		store.Close()
+		t.Skip(ErrIOUringUnsupported)
+	}
+	var pages []PageID
+	for i := 0; i < 40; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+		writePages(t, store, []PageID{pageID}, byte(i))
+	}
+	// Every dirty page is written back with one batch.
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Dirty() != 0 {
+		t.Fatalf("expected %v == %v", store.Dirty(), 0)
+	}
+	for i, pageID := range pages {
+		assertBufEqual(
+			t,
+			readPageFromFile(t, tmpfile.Name(), pageID)[:UsablePageSize],
+			pageFilledWith(byte(i))[:UsablePageSize],
+		)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(4), WithIOUring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for i, pageID := range pages {
+		page, err := reopened.Load(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(byte(i))[:UsablePageSize])
+		if err := page.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// walPageRecord32 is the page record of logs written before page ids were widened to
+	// 64 bits, which are still replayed.
+	walPageRecord32 = 1
+	walCommitRecord = 2
+	walPageRecord   = 3
+	// walCommitRecordLSN is a commit record that also records the log sequence number of
+	// the group it commits and when it was committed, see CommitAt.
+	walCommitRecordLSN = 4
+	// walCommitRecordID is a walCommitRecordLSN that also records the store ID of the
+	// file the log belongs to, see StoreID.
+	walCommitRecordID = 5
+	// walPageRecordSize is the size of a page record: its kind, page id, the contents of
+	// the page and a checksum.
+	walPageRecordSize   = 1 + 8 + PageSize + 4
+	walPageRecord32Size = 1 + 4 + PageSize + 4
+	// walCommitRecordSize is the size of a commit record: its kind, the number of page
+	// records it commits and a checksum.
+	walCommitRecordSize = 1 + 4 + 4
+	// walCommitRecordLSNSize is the size of a commit record that also records the log
+	// sequence number of the group and the time it was committed, in nanoseconds since
+	// the Unix epoch.
+	walCommitRecordLSNSize = 1 + 4 + 8 + 8 + 4
+	// walCommitRecordIDSize is the size of a commit record that also records the store
+	// ID, after the time.
+	walCommitRecordIDSize = 1 + 4 + 8 + 8 + storeIDSize + 4
+)
+
+// ErrWALFailed is returned when writing to a write-ahead log that couldn't take back a
+// group it failed to log, see WAL.truncate.
+var ErrWALFailed = errors.New("write-ahead log failed")
+
+// WAL is a write-ahead log of page writes. Pages are appended to the log and then
+// committed together by a commit record, and the log is synced to disk before any of the
+// pages are written to the page store's file. If the process crashes while the pages are
+// being written, replaying the log when the file is opened again finishes the job.
+//
+// Every record ends with a CRC-32 of the rest of the record, so a record that was only
+// partly written when the process crashed is detected and ignored along with any pages
+// that were appended after the last commit record.
+//
+// The log can also be split into segments, see WithWALSegments, in which case the file
+// is completed once it has grown to the segment size, and replaced by an empty one. A log
+// kept in a backend rather than a file, see WithWALBackend, can't be split into segments.
+type WAL struct {
+	file Backend
+	// uncommitted is the number of pages appended since the last commit record.
+	uncommitted uint32
+	// size is the number of bytes in the log's file, and logged is the number of bytes
+	// logged since the log was last emptied, including those in completed segments.
+	size   int64
+	logged int64
+	// filename is the name of the log's file, which its completed segments are named
+	// after, or empty if the log is kept in a backend. segmentSize is the size the file
+	// is completed at, or zero if the log isn't split into segments, and retention is
+	// the number of completed segments kept once they're no longer needed, see
+	// WithWALSegments.
+	filename    string
+	segmentSize int64
+	retention   int
+	// id is the store ID of the file the log belongs to, which commit records are
+	// stamped with and the groups replayed from the log have to match, or zero if it
+	// isn't known.
+	id StoreID
+	// segments are the completed segments of the log, oldest first.
+	segments []WALSegmentFile
+	// first and last are the log sequence numbers of the first and last groups in the
+	// log's file, or zero if it holds none, and unnumbered is set if it holds groups
+	// without one, which were logged by older versions of this package.
+	first, last uint64
+	unnumbered  bool
+	// failed is set once the log couldn't be truncated back after a failed write, after
+	// which every write is refused with ErrWALFailed.
+	failed bool
+}
+
+// OpenWAL opens the log in the given file, creating it if it doesn't exist.
+func OpenWAL(filename string) (*WAL, error) {
+	return openWAL(filename, 0, 0)
+}
+
+// openWAL opens the log in the given file along with its completed segments, splitting
+// it into segments of segmentSize bytes if it's positive.
+func openWAL(filename string, segmentSize int64, retention int) (*WAL, error) {
+	segments, err := listWALSegments(filename)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		file:        file,
+		filename:    filename,
+		segmentSize: segmentSize,
+		retention:   retention,
+		segments:    segments,
+	}
+	err = w.open()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// openWALBackend opens the log kept in backend, see WithWALBackend.
+func openWALBackend(backend Backend) (*WAL, error) {
+	w := &WAL{file: backend}
+	err := w.open()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open finds the size of the log and the groups in it.
+func (w *WAL) open() error {
+	size, err := backendSize(w.file)
+	if err != nil {
+		return err
+	}
+	w.size = size
+	w.logged = size
+	if size == 0 {
+		return nil
+	}
+	return w.scan()
+}
+
+// backendSize returns the number of bytes in a backend, which must be an *os.File or
+// have a Size method like MemoryBackend's.
+func backendSize(backend Backend) (int64, error) {
+	switch b := backend.(type) {
+	case interface{ Size() int64 }:
+		return b.Size(), nil
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := b.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	return 0, errors.New("can't find the size of the write-ahead log's backend")
+}
+
+// reader returns a reader of everything in the log's file.
+func (w *WAL) reader() io.Reader {
+	return io.NewSectionReader(w.file, 0, w.size)
+}
+
+// write appends a record to the log's file.
+func (w *WAL) write(record []byte) error {
+	n, err := w.file.WriteAt(record, w.size)
+	w.size += int64(n)
+	w.logged += int64(n)
+	return err
+}
+
+// openWAL opens the store's log, see EnableWAL.
+func (s *PageStore) openWAL() (*WAL, error) {
+	if s.walBackend != nil {
+		return openWALBackend(s.walBackend)
+	}
+	return openWAL(walFilename(s.filename), s.walSegmentSize, s.walRetention)
+}
+
+// walFilename returns the name of the log kept alongside a page store's file.
+func walFilename(filename string) string {
+	return filename + ".wal"
+}
+
+// Append adds a page to the log. It isn't synced to disk, or replayed, until Commit is
+// called.
+func (w *WAL) Append(pageID PageID, buf *[PageSize]byte) error {
+	if w.failed {
+		return ErrWALFailed
+	}
+	record := make([]byte, walPageRecordSize)
+	record[0] = walPageRecord
+	binary.LittleEndian.PutUint64(record[1:9], uint64(pageID))
+	copy(record[9:9+PageSize], buf[:])
+	checksum(record)
+	err := w.write(record)
+	if err != nil {
+		return err
+	}
+	w.uncommitted++
+	return nil
+}
+
+// Commit adds a commit record for every page appended since the last commit and syncs
+// the log to disk. Once Commit returns the pages are guaranteed to be replayed if the
+// process crashes before they're written to the page store's file.
+func (w *WAL) Commit() error {
+	if w.failed {
+		return ErrWALFailed
+	}
+	record := make([]byte, walCommitRecordSize)
+	record[0] = walCommitRecord
+	binary.LittleEndian.PutUint32(record[1:5], w.uncommitted)
+	checksum(record)
+	err := w.write(record)
+	if err != nil {
+		return err
+	}
+	w.uncommitted = 0
+	return w.file.Sync()
+}
+
+// CommitAt is Commit for a group with the given log sequence number, committed at the
+// given time, which are recorded in its commit record so that the group can be found
+// when the log is archived, see WALArchiver. The record is also stamped with the store
+// ID of the file the log belongs to, if it's known.
+func (w *WAL) CommitAt(lsn uint64, committed time.Time) error {
+	if w.failed {
+		return ErrWALFailed
+	}
+	record := make([]byte, walCommitRecordLSNSize)
+	record[0] = walCommitRecordLSN
+	if !w.id.IsZero() {
+		record = make([]byte, walCommitRecordIDSize)
+		record[0] = walCommitRecordID
+		copy(record[21:21+storeIDSize], w.id[:])
+	}
+	binary.LittleEndian.PutUint32(record[1:5], w.uncommitted)
+	binary.LittleEndian.PutUint64(record[5:13], lsn)
+	binary.LittleEndian.PutUint64(record[13:21], uint64(committed.UnixNano()))
+	checksum(record)
+	err := w.write(record)
+	if err != nil {
+		return err
+	}
+	err = w.file.Sync()
+	if err != nil {
+		return err
+	}
+	w.uncommitted = 0
+	if w.first == 0 {
+		w.first = lsn
+	}
+	w.last = lsn
+	return nil
+}
+
+// Replay calls apply with every committed page in the log, in the order they were
+// appended, starting with the log's completed segments. Pages that were appended after
+// the last commit record are skipped. It returns the number of pages replayed and the
+// number skipped.
+func (w *WAL) Replay(apply func(PageID, *[PageSize]byte) error) (int, int, error) {
+	return w.replayAfter(0, apply)
+}
+
+// replayAfter is Replay for the groups committed after the group with the given log
+// sequence number, such as the last one a checkpoint wrote to the file. Groups without a
+// log sequence number are always replayed. ErrWrongStore is returned, before anything is
+// replayed, if the log holds a group stamped with a store ID other than the log's.
+func (w *WAL) replayAfter(
+	lsn uint64,
+	apply func(PageID, *[PageSize]byte) error,
+) (int, int, error) {
+	err := w.checkID()
+	if err != nil {
+		return 0, 0, err
+	}
+	replayed := 0
+	replay := func(g *walGroup) error {
+		if g.lsn != 0 && g.lsn <= lsn {
+			return nil
+		}
+		for i, pageID := range g.pageIDs {
+			err := apply(pageID, &g.pages[i])
+			if err != nil {
+				return err
+			}
+			replayed++
+		}
+		return nil
+	}
+	for _, segment := range w.segments {
+		if segment.LastLSN <= lsn {
+			continue
+		}
+		file, err := os.Open(segment.Filename)
+		if err != nil {
+			return replayed, 0, err
+		}
+		_, err = readGroups(file, replay)
+		file.Close()
+		if err != nil {
+			return replayed, 0, err
+		}
+	}
+	discarded, err := readGroups(w.reader(), replay)
+	if err != nil {
+		return replayed, 0, err
+	}
+	return replayed, discarded, nil
+}
+
+// walGroup is a group of pages committed to the log together.
+type walGroup struct {
+	// lsn is the log sequence number of the group, and committed is when it was
+	// committed, or zero for groups committed with Commit rather than CommitAt.
+	lsn       uint64
+	committed time.Time
+	// id is the store ID the group was stamped with, or zero if it wasn't.
+	id      StoreID
+	pageIDs []PageID
+	pages   [][PageSize]byte
+}
+
+// readGroups reads a log from r, calling apply with every group of pages in it that was
+// committed, in order. It stops at the first record that was only partly written, and
+// returns the number of pages appended after the last commit record before it.
+func readGroups(r io.Reader, apply func(*walGroup) error) (int, error) {
+	br := bufio.NewReader(r)
+	g := &walGroup{}
+	for {
+		kind, err := br.Peek(1)
+		if err != nil {
+			break
+		}
+		size := walPageRecordSize
+		switch kind[0] {
+		case walCommitRecord:
+			size = walCommitRecordSize
+		case walCommitRecordLSN:
+			size = walCommitRecordLSNSize
+		case walCommitRecordID:
+			size = walCommitRecordIDSize
+		case walPageRecord32:
+			size = walPageRecord32Size
+		}
+		record := make([]byte, size)
+		_, err = io.ReadFull(br, record)
+		if err != nil || !validChecksum(record) {
+			// The tail of the log was only partly written.
+			break
+		}
+		if record[0] == walPageRecord32 {
+			g.pageIDs = append(g.pageIDs, PageID(binary.LittleEndian.Uint32(record[1:5])))
+			var page [PageSize]byte
+			copy(page[:], record[5:5+PageSize])
+			g.pages = append(g.pages, page)
+			continue
+		}
+		if record[0] == walPageRecord {
+			g.pageIDs = append(g.pageIDs, PageID(binary.LittleEndian.Uint64(record[1:9])))
+			var page [PageSize]byte
+			copy(page[:], record[9:9+PageSize])
+			g.pages = append(g.pages, page)
+			continue
+		}
+		if int(binary.LittleEndian.Uint32(record[1:5])) != len(g.pageIDs) {
+			break
+		}
+		if record[0] == walCommitRecordLSN || record[0] == walCommitRecordID {
+			g.lsn = binary.LittleEndian.Uint64(record[5:13])
+			g.committed = time.Unix(0, int64(binary.LittleEndian.Uint64(record[13:21])))
+		}
+		if record[0] == walCommitRecordID {
+			copy(g.id[:], record[21:21+storeIDSize])
+		}
+		err = apply(g)
+		if err != nil {
+			return 0, err
+		}
+		g = &walGroup{}
+	}
+	return len(g.pageIDs), nil
+}
+
+// Size returns the number of bytes logged since the log was last emptied, including
+// those in its completed segments.
+func (w *WAL) Size() int64 {
+	return w.logged
+}
+
+// contents returns everything in the log from the given offset on.
+func (w *WAL) contents(offset int64) ([]byte, error) {
+	data := make([]byte, max(w.size-offset, 0))
+	_, err := w.file.ReadAt(data, offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return data, err
+}
+
+// Reset empties the log once every committed page has made it to the page store's file.
+func (w *WAL) Reset() error {
+	err := w.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	w.uncommitted = 0
+	w.size = 0
+	w.logged = 0
+	w.first, w.last = 0, 0
+	w.unnumbered = false
+	return w.file.Sync()
+}
+
+// truncate takes back everything logged from the given offset in the log's file on, such
+// as a group that was only partly logged when a write failed. Otherwise the groups
+// committed after it would be appended behind a record that doesn't match its checksum,
+// and never replayed. If the file can't be truncated, the log refuses every write from
+// then on.
+func (w *WAL) truncate(offset int64) error {
+	err := w.file.Truncate(offset)
+	if err != nil {
+		w.failed = true
+		return err
+	}
+	w.logged -= w.size - offset
+	w.size = offset
+	w.uncommitted = 0
+	return nil
+}
+
+// Close closes the log's file.
+func (w *WAL) Close() error {
+	return w.file.Close()
+}
+
+// checksum stores the CRC-32 of a record in its last four bytes.
+func checksum(record []byte) {
+	n := len(record) - 4
+	binary.LittleEndian.PutUint32(record[n:], crc32.ChecksumIEEE(record[:n]))
+}
+
+func validChecksum(record []byte) bool {
+	n := len(record) - 4
+	return binary.LittleEndian.Uint32(record[n:]) == crc32.ChecksumIEEE(record[:n])
+}
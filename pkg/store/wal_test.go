@@ -0,0 +1,322 @@
+package store
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWALReplaysCommittedPages(t *testing.T) {
+	wal := newWAL(t, "wal_replays_committed")
+	for i := 1; i <= 3; i++ {
+		if err := wal.Append(PageID(i), pageFilledWith(byte(i))); err != nil {
+			t.Fatal(err)
+		}
+		// The first two pages are committed together.
+		if i == 2 {
+			if err := wal.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expectReplay(t, wal, []PageID{1, 2})
+	if err := wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectReplay(t, wal, []PageID{1, 2, 3})
+	if err := wal.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	expectReplay(t, wal, nil)
+}
+
+func TestWALIgnoresTornTail(t *testing.T) {
+	wal := newWAL(t, "wal_torn_tail")
+	for i := 1; i <= 2; i++ {
+		if err := wal.Append(PageID(i), pageFilledWith(byte(i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := wal.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Cut the last commit record short, as if the process crashed while writing it.
+	wal.size -= 2
+	if err := wal.file.Truncate(wal.size); err != nil {
+		t.Fatal(err)
+	}
+	expectReplay(t, wal, []PageID{1})
+}
+
+func TestPageStoreReplaysWALOnOpen(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "replays_wal_on_open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Commit a page to the log without writing it to the file, as if the process crashed
+	// right after the commit.
+	wal, err := OpenWAL(walFilename(tmpfile.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Append(pageID, pageFilledWith(7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Append(pageID, pageFilledWith(8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := reopened.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(7)[:UsablePageSize])
+	info, err := os.Stat(walFilename(tmpfile.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("expected %d == 0", info.Size())
+	}
+}
+
+func TestPageStoreReplaysWALBackend(t *testing.T) {
+	backend, walBackend := NewMemoryBackend(), NewMemoryBackend()
+	store, err := OpenBackend(backend, WithCacheSize(10), WithWALBackend(walBackend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Commit a page to the log without writing it to the backend, as if the process
+	// crashed right after the commit.
+	wal, err := openWALBackend(walBackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Append(pageID, pageFilledWith(7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenBackend(backend, WithReadOnly(), WithWALBackend(walBackend))
+	if err != ErrNeedsRecovery {
+		t.Fatalf("expected %v == %v", err, ErrNeedsRecovery)
+	}
+	reopened, err := OpenBackend(backend, WithCacheSize(10), WithWALBackend(walBackend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := reopened.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(7)[:UsablePageSize])
+	if walBackend.Size() != 0 {
+		t.Fatalf("expected %d == 0", walBackend.Size())
+	}
+}
+
+func TestPageStoreGroupsWritesInWAL(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "groups_writes_in_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableWAL(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Begin()
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Buf = *pageFilledWith(3)
+	if err := store.Write(pageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// The page isn't in the file until the group is committed, but loading it again sees
+	// the write.
+	if fileSize(t, tmpfile.Name()) > int64(pageID)*PageSize {
+		t.Fatal("expected the page not to be written yet")
+	}
+	page, err = store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(3)[:UsablePageSize])
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if fileSize(t, tmpfile.Name()) != int64(pageID+1)*PageSize {
+		t.Fatal("expected the page to be written")
+	}
+	if err := store.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = reopened.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBufEqual(t, page.Buf[:UsablePageSize], pageFilledWith(3)[:UsablePageSize])
+}
+
+func TestWALReplaysNarrowPageRecords(t *testing.T) {
+	wal := newWAL(t, "wal_narrow_records")
+	// Logs written before page ids were widened to 64 bits hold 32 bit page records.
+	for i := 1; i <= 2; i++ {
+		record := make([]byte, walPageRecord32Size)
+		record[0] = walPageRecord32
+		binary.LittleEndian.PutUint32(record[1:5], uint32(i))
+		copy(record[5:5+PageSize], pageFilledWith(byte(i))[:])
+		checksum(record)
+		if err := wal.write(record); err != nil {
+			t.Fatal(err)
+		}
+		wal.uncommitted++
+	}
+	if err := wal.Append(3, pageFilledWith(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectReplay(t, wal, []PageID{1, 2, 3})
+}
+
+func TestWALTakesBackFailedGroup(t *testing.T) {
+	wal := newWAL(t, "wal_takes_back_failed_group")
+	if err := wal.Append(1, pageFilledWith(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Log a page and half of another, as if writing the second one came up short.
+	start := wal.size
+	if err := wal.Append(2, pageFilledWith(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.write(make([]byte, walPageRecordSize/2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.truncate(start); err != nil {
+		t.Fatal(err)
+	}
+	if wal.Size() != start {
+		t.Fatalf("expected %v == %v", wal.Size(), start)
+	}
+	// The group committed after the failed one is replayed.
+	if err := wal.Append(3, pageFilledWith(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectReplay(t, wal, []PageID{1, 3})
+	// A log that can't be truncated refuses to be written to.
+	if err := wal.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.truncate(start); err == nil {
+		t.Fatal("expected truncating a closed log to fail")
+	}
+	if err := wal.Append(4, pageFilledWith(4)); err != ErrWALFailed {
+		t.Fatalf("expected %v == %v", err, ErrWALFailed)
+	}
+	if err := wal.Commit(); err != ErrWALFailed {
+		t.Fatalf("expected %v == %v", err, ErrWALFailed)
+	}
+}
+
+func newWAL(t *testing.T, filename string) *WAL {
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	wal, err := OpenWAL(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wal
+}
+
+func expectReplay(t *testing.T, wal *WAL, expected []PageID) {
+	var got []PageID
+	_, _, err := wal.Replay(func(pageID PageID, buf *[PageSize]byte) error {
+		assertBufEqual(t, buf[:], pageFilledWith(byte(pageID))[:])
+		got = append(got, pageID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("%v != %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("%v != %v", got, expected)
+		}
+	}
+}
+
+func pageFilledWith(b byte) *[PageSize]byte {
+	var buf [PageSize]byte
+	for i := range buf {
+		buf[i] = b
+	}
+	return &buf
+}
+
+func fileSize(t *testing.T, filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
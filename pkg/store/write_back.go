@@ -0,0 +1,197 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// Without a write-ahead log or copy-on-write mode, Write doesn't write the page to the
+// file straight away. The page's cache slot is marked dirty instead, and the page is
+// written back once it's evicted from the cache, when the file is synced, or when the
+// store is closed. A page that is written many times while it's cached, like the root of
+// a tree, only reaches the file once.
+
+// flushSlot writes the page in a dirty cache slot back to the file.
+func (s *PageStore) flushSlot(cacheID int) error {
+	if !s.dirty[cacheID] {
+		return nil
+	}
+	err := s.writePage(s.cache[cacheID].ID, &s.cache[cacheID].Buf)
+	if err != nil {
+		return err
+	}
+	s.setDirty(cacheID, false)
+	return nil
+}
+
+// setDirty marks whether the page in a cache slot needs to be written back.
+func (s *PageStore) setDirty(cacheID int, dirty bool) {
+	if s.dirty[cacheID] == dirty {
+		return
+	}
+	s.dirty[cacheID] = dirty
+	if !dirty {
+		s.dirtyPages.Add(-1)
+		return
+	}
+	s.dirtyPages.Add(1)
+	s.wakeFlusher()
+}
+
+// maxFlushRun is the most pages flush writes back with one write, which bounds the buffer
+// a run of adjacent pages is copied into.
+const maxFlushRun = 64
+
+// flush writes every dirty page in the cache back to the file, in the order the pages
+// are found in the file. Runs of dirty pages that are next to each other in the file are
+// written with one write rather than a write for every page.
+func (s *PageStore) flush() error {
+	var dirty []int
+	for cacheID := range s.dirty {
+		if s.dirty[cacheID] {
+			dirty = append(dirty, cacheID)
+		}
+	}
+	sort.Slice(dirty, func(i, j int) bool {
+		return s.cache[dirty[i]].ID < s.cache[dirty[j]].ID
+	})
+	defer s.logFlush(len(dirty), s.startFlush())
+	if s.doubleWrite != nil {
+		// The double-write buffer only holds one page, so each page has to be written on
+		// its own when there is one.
+		for _, cacheID := range dirty {
+			err := s.flushSlot(cacheID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	runs := s.dirtyRuns(dirty)
+	batch, ok := s.backend.(BatchBackend)
+	if ok && len(runs) > 1 {
+		return s.flushBatch(batch, runs)
+	}
+	for _, run := range runs {
+		err := s.flushRun(run)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirtyRuns splits the dirty cache slots, sorted by their pages, into runs of slots
+// holding pages that are next to each other in the file.
+func (s *PageStore) dirtyRuns(dirty []int) [][]int {
+	var runs [][]int
+	start := 0
+	for i := 1; i <= len(dirty); i++ {
+		if i < len(dirty) && i-start < maxFlushRun {
+			if s.cache[dirty[i]].ID == s.cache[dirty[i-1]].ID+1 {
+				continue
+			}
+		}
+		runs = append(runs, dirty[start:i])
+		start = i
+	}
+	return runs
+}
+
+// runBuffer checksums and encrypts the pages in a run of dirty cache slots and returns
+// them laid out as they are in the file. A run of one page is written straight from its
+// slot unless it's encrypted, while longer runs are copied into a buffer of their own.
+func (s *PageStore) runBuffer(run []int) ([]byte, error) {
+	images := make([]*[PageSize]byte, len(run))
+	for i, cacheID := range run {
+		s.stampGeneration(s.cache[cacheID].ID, &s.cache[cacheID].Buf)
+		image, err := s.encodePage(s.cache[cacheID].ID, &s.cache[cacheID].Buf)
+		if err != nil {
+			return nil, pageError("write", s.cache[cacheID].ID, err)
+		}
+		images[i] = image
+	}
+	if len(run) == 1 {
+		return images[0][:], nil
+	}
+	buf := make([]byte, 0, len(run)*PageSize)
+	for _, image := range images {
+		buf = append(buf, image[:]...)
+	}
+	return buf, nil
+}
+
+// flushRun writes the pages in a run of dirty cache slots back to the file with one
+// write.
+func (s *PageStore) flushRun(run []int) error {
+	s.lockWrites()
+	defer s.writeLock.Unlock()
+	buf, err := s.runBuffer(run)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	// The header is never next to another page it's written with, since it's followed by
+	// its copy in files that write it to either of them.
+	n, err := s.backend.WriteAt(buf, pageOffset(headerImageID(s.cache[run[0]].ID, buf)))
+	s.counters.observeIO(start)
+	s.unsynced = true
+	s.writeBacks.Add(1)
+	s.counters.writes.Add(uint64(n / PageSize))
+	if err != nil {
+		// The error is put down to the first page that wasn't written.
+		return pageError("write", s.cache[run[min(n/PageSize, len(run)-1)]].ID, err)
+	}
+	if n != len(buf) {
+		return pageError("write", s.cache[run[n/PageSize]].ID, ErrPageNotFullyWritten)
+	}
+	for _, cacheID := range run {
+		s.setDirty(cacheID, false)
+	}
+	return nil
+}
+
+// flushBatch writes the runs of dirty cache slots back to the file with one batch, so
+// that the runs are written at once rather than one after another.
+func (s *PageStore) flushBatch(batch BatchBackend, runs [][]int) error {
+	s.lockWrites()
+	defer s.writeLock.Unlock()
+	ops := make([]BatchOp, len(runs))
+	for i, run := range runs {
+		buf, err := s.runBuffer(run)
+		if err != nil {
+			return err
+		}
+		ops[i] = BatchOp{Buf: buf, Off: pageOffset(headerImageID(s.cache[run[0]].ID, buf))}
+	}
+	start := time.Now()
+	err := batch.WriteBatch(ops)
+	s.counters.observeIO(start)
+	s.unsynced = true
+	s.writeBacks.Add(1)
+	if err != nil {
+		return err
+	}
+	for i, run := range runs {
+		if ops[i].Err != nil {
+			return pageError("write", s.cache[run[0]].ID, ops[i].Err)
+		}
+		if ops[i].N != len(ops[i].Buf) {
+			written := ops[i].N / PageSize
+			return pageError("write", s.cache[run[written]].ID, ErrPageNotFullyWritten)
+		}
+		s.counters.writes.Add(uint64(len(run)))
+		for _, cacheID := range run {
+			s.setDirty(cacheID, false)
+		}
+	}
+	return nil
+}
+
+// Dirty returns the number of pages in the cache that have been written but not yet
+// written back to the file.
+func (s *PageStore) Dirty() int {
+	s.Lock()
+	defer s.Unlock()
+	return int(s.dirtyPages.Load())
+}
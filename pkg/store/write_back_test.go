@@ -0,0 +1,164 @@
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWriteBack(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "write_back")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	store, err := NewPageStore(tmpfile.Name(), WithCacheSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := store.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Load(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := byte(1); i <= 3; i++ {
+		page.Buf = *pageFilledWith(i)
+		if err := store.Write(pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The header and the page are only dirty in the cache.
+	if store.Dirty() != 2 {
+		t.Fatalf("expected %v == %v", store.Dirty(), 2)
+	}
+	assertBufEqual(t, readPageFromFile(t, tmpfile.Name(), pageID), make([]byte, PageSize))
+	// Releasing the page leaves it dirty in the cache until it's evicted to make room
+	// for other pages.
+	if err := page.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Dirty() != 2 {
+		t.Fatalf("expected %v == %v", store.Dirty(), 2)
+	}
+	for i := 0; i < 2; i++ {
+		other, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Load(other); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.Dirty() != 1 {
+		t.Fatalf("expected %v == %v", store.Dirty(), 1)
+	}
+	assertBufEqual(
+		t,
+		readPageFromFile(t, tmpfile.Name(), pageID)[:UsablePageSize],
+		pageFilledWith(3)[:UsablePageSize],
+	)
+	// So does syncing, and closing.
+	if err := store.SetRoot(pageID, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Dirty() != 0 {
+		t.Fatalf("expected %v == %v", store.Dirty(), 0)
+	}
+	if err := store.SetFlags(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPageStore(tmpfile.Name(), WithCacheSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Root() != pageID {
+		t.Fatalf("expected %v == %v", reopened.Root(), pageID)
+	}
+	if reopened.Flags() != 7 {
+		t.Fatalf("expected %v == %v", reopened.Flags(), 7)
+	}
+}
+
+// countingBackend counts the writes made to the backend it wraps.
+type countingBackend struct {
+	*MemoryBackend
+	writes int
+}
+
+func (b *countingBackend) WriteAt(p []byte, off int64) (int, error) {
+	b.writes++
+	return b.MemoryBackend.WriteAt(p, off)
+}
+
+func TestFlushCoalescesAdjacentPages(t *testing.T) {
+	backend := &countingBackend{MemoryBackend: NewMemoryBackend()}
+	store, err := OpenBackend(backend, WithCacheSize(maxFlushRun*2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var pages []PageID
+	for i := 0; i < maxFlushRun+10; i++ {
+		pageID, err := store.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, pageID)
+	}
+	// The header is written on its own, since its copy is never dirty, and leaving out
+	// pages 8 and 9 splits the rest of the dirty pages into a run starting with the
+	// free-space map, and a run too long for one write. The header is then written again
+	// to record that everything has been synced.
+	written := append(pages[:5:5], pages[7:]...)
+	for i, pageID := range written {
+		writePages(t, store, []PageID{pageID}, byte(i))
+	}
+	backend.writes = 0
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if backend.writes != 5 {
+		t.Fatalf("expected %v == %v", backend.writes, 5)
+	}
+	if store.Dirty() != 0 {
+		t.Fatalf("expected %v == %v", store.Dirty(), 0)
+	}
+	buf := make([]byte, PageSize)
+	for i, pageID := range written {
+		if _, err := backend.ReadAt(buf, pageOffset(pageID)); err != nil {
+			t.Fatal(err)
+		}
+		if !validPageChecksum((*[PageSize]byte)(buf)) {
+			t.Fatalf("expected page %v to have a valid checksum", pageID)
+		}
+		assertBufEqual(t, buf[:UsablePageSize], pageFilledWith(byte(i))[:UsablePageSize])
+	}
+}
+
+func readPageFromFile(t *testing.T, filename string, pageID PageID) []byte {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// Past the end of the file reads as zeros.
+	buf := make([]byte, PageSize)
+	if _, err := file.ReadAt(buf, int64(pageID)*PageSize); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return buf
+}
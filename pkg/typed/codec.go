@@ -0,0 +1,163 @@
+package typed
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrInvalidEncoding is returned when bytes read from a tree can't be decoded by a
+	// codec.
+	ErrInvalidEncoding = errors.New("invalid encoding")
+	// ErrKeyType is returned when opening a tree with a key codec of another type than the
+	// one it was created with.
+	ErrKeyType = errors.New("tree was created with keys of another type")
+)
+
+// KeyType identifies the type of the keys a codec encodes, which is recorded in a tree's
+// file when it's created so that it isn't opened with keys of another type.
+type KeyType uint32
+
+const (
+	// KeyUnknown is the type of keys encoded by codecs that don't have a KeyType method.
+	KeyUnknown KeyType = iota
+	KeyUint32
+	KeyUint64
+	KeyInt64
+	KeyString
+	KeyBytes
+)
+
+// keyTypeOf returns the type of the keys a codec encodes, if it says.
+func keyTypeOf[T any](codec Codec[T]) KeyType {
+	typed, ok := codec.(interface{ KeyType() KeyType })
+	if !ok {
+		return KeyUnknown
+	}
+	return typed.KeyType()
+}
+
+// Codec converts values to and from the bytes stored in a B+ tree. Codecs used for keys
+// must preserve ordering: if a < b then Encode(a) must sort before Encode(b) when their
+// bytes are compared. They may also have a KeyType method returning the KeyType of their
+// keys, as the codecs in this package do. Encode returns an error for values it can't
+// encode.
+type Codec[T any] interface {
+	Encode(T) ([]byte, error)
+	Decode([]byte) (T, error)
+}
+
+var (
+	// Uint32 encodes uint32s as 4 big endian bytes.
+	Uint32 Codec[uint32] = uint32Codec{}
+	// Uint64 encodes uint64s as 8 big endian bytes.
+	Uint64 Codec[uint64] = uint64Codec{}
+	// Int64 encodes int64s as 8 big endian bytes with the sign bit flipped so that
+	// negative numbers sort before positive ones.
+	Int64 Codec[int64] = int64Codec{}
+	// String encodes strings as their raw bytes.
+	String Codec[string] = stringCodec{}
+	// Bytes stores byte slices as they are.
+	Bytes Codec[[]byte] = bytesCodec{}
+)
+
+// JSON returns a codec which encodes values with encoding/json. It does not preserve
+// ordering so it should only be used for values. Values json can't marshal, such as
+// channels, functions or NaN, are returned as an error by Encode.
+func JSON[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+type uint32Codec struct{}
+
+func (uint32Codec) KeyType() KeyType {
+	return KeyUint32
+}
+
+func (uint32Codec) Encode(v uint32) ([]byte, error) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return buf, nil
+}
+
+func (uint32Codec) Decode(buf []byte) (uint32, error) {
+	if len(buf) != 4 {
+		return 0, ErrInvalidEncoding
+	}
+	return binary.BigEndian.Uint32(buf), nil
+}
+
+type uint64Codec struct{}
+
+func (uint64Codec) KeyType() KeyType {
+	return KeyUint64
+}
+
+func (uint64Codec) Encode(v uint64) ([]byte, error) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf, nil
+}
+
+func (uint64Codec) Decode(buf []byte) (uint64, error) {
+	if len(buf) != 8 {
+		return 0, ErrInvalidEncoding
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+type int64Codec struct{}
+
+func (int64Codec) KeyType() KeyType {
+	return KeyInt64
+}
+
+func (int64Codec) Encode(v int64) ([]byte, error) {
+	return uint64Codec{}.Encode(uint64(v) ^ (1 << 63))
+}
+
+func (int64Codec) Decode(buf []byte) (int64, error) {
+	v, err := uint64Codec{}.Decode(buf)
+	return int64(v ^ (1 << 63)), err
+}
+
+type stringCodec struct{}
+
+func (stringCodec) KeyType() KeyType {
+	return KeyString
+}
+
+func (stringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (stringCodec) Decode(buf []byte) (string, error) {
+	return string(buf), nil
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) KeyType() KeyType {
+	return KeyBytes
+}
+
+func (bytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (bytesCodec) Decode(buf []byte) ([]byte, error) {
+	return buf, nil
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Decode(buf []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(buf, &v)
+	return v, err
+}
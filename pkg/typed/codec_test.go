@@ -0,0 +1,67 @@
+package typed
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestIntegerCodecsPreserveOrdering(t *testing.T) {
+	ints := []int64{-1 << 63, -1000, -1, 0, 1, 1000, 1<<63 - 1}
+	for i := 1; i < len(ints); i++ {
+		a, b := encode(t, Int64, ints[i-1]), encode(t, Int64, ints[i])
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("expected %d to sort before %d", ints[i-1], ints[i])
+		}
+		decoded, err := Int64.Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != ints[i] {
+			t.Fatalf("expected %d == %d", decoded, ints[i])
+		}
+	}
+	uints := []uint64{0, 1, 255, 256, 1<<64 - 1}
+	for i := 1; i < len(uints); i++ {
+		a, b := encode(t, Uint64, uints[i-1]), encode(t, Uint64, uints[i])
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("expected %d to sort before %d", uints[i-1], uints[i])
+		}
+	}
+	if _, err := Uint32.Decode([]byte{1, 2}); err != ErrInvalidEncoding {
+		t.Fatalf("expected %v == %v", err, ErrInvalidEncoding)
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	codec := JSON[user]()
+	got, err := codec.Decode(encode(t, codec, user{Name: "jake", Age: 7}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "jake" || got.Age != 7 {
+		t.Fatalf("unexpected %+v", got)
+	}
+}
+
+func TestJSONCodecRejectsUnmarshalableValues(t *testing.T) {
+	if _, err := JSON[float64]().Encode(math.NaN()); err == nil {
+		t.Fatal("expected NaN to fail to encode")
+	}
+	if _, err := JSON[chan int]().Encode(make(chan int)); err == nil {
+		t.Fatal("expected a channel to fail to encode")
+	}
+}
+
+func encode[T any](t *testing.T, codec Codec[T], v T) []byte {
+	t.Helper()
+	buf, err := codec.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
@@ -0,0 +1,134 @@
+// Package typed layers a type-parameterized API on top of the byte-level B+ tree in
+// package bplus so that callers don't have to hand-roll serialization.
+package typed
+
+import (
+	"cmp"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+// Tree is a persisted B+ tree of K keys and V values. Keys and values are converted to
+// and from bytes with the codecs given when the tree is created or opened.
+type Tree[K cmp.Ordered, V any] struct {
+	tree   *bplus.Tree
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// NewTree constructs a persisted B+ tree in the given file, see bplus.NewTree.
+func NewTree[K cmp.Ordered, V any](
+	filename string,
+	keys Codec[K],
+	values Codec[V],
+	opts ...bplus.Option,
+) (*Tree[K, V], error) {
+	tree, err := bplus.NewTree(filename, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if keyType := keyTypeOf(keys); keyType != KeyUnknown {
+		err = tree.SetKeyType(uint32(keyType))
+		if err != nil {
+			tree.Close()
+			return nil, err
+		}
+	}
+	return &Tree[K, V]{tree: tree, keys: keys, values: values}, nil
+}
+
+// OpenTree reattaches to a persisted B+ tree that was created in the given file. It
+// returns ErrKeyType if the tree was created with keys of another type.
+func OpenTree[K cmp.Ordered, V any](
+	filename string,
+	keys Codec[K],
+	values Codec[V],
+	opts ...bplus.Option,
+) (*Tree[K, V], error) {
+	tree, err := bplus.OpenTree(filename, opts...)
+	if err != nil {
+		return nil, err
+	}
+	recorded, keyType := KeyType(tree.KeyType()), keyTypeOf(keys)
+	if recorded != KeyUnknown && keyType != KeyUnknown && recorded != keyType {
+		tree.Close()
+		return nil, ErrKeyType
+	}
+	return &Tree[K, V]{tree: tree, keys: keys, values: values}, nil
+}
+
+// Bytes returns the underlying byte-level tree.
+func (t *Tree[K, V]) Bytes() *bplus.Tree {
+	return t.tree
+}
+
+// Read a value from the tree, return bplus.ErrKeyNotFound if it's not found.
+func (t *Tree[K, V]) Read(key K) (V, error) {
+	var zero V
+	k, err := t.keys.Encode(key)
+	if err != nil {
+		return zero, err
+	}
+	buf, err := t.tree.Read(k)
+	if err != nil {
+		return zero, err
+	}
+	return t.values.Decode(buf)
+}
+
+// Insert a key value pair into the tree. Duplicate keys are not allowed.
+func (t *Tree[K, V]) Insert(key K, value V) error {
+	k, v, err := t.encode(key, value)
+	if err != nil {
+		return err
+	}
+	return t.tree.Insert(k, v)
+}
+
+// Upsert inserts a key value pair into the tree, overwriting the value if the key is
+// already present.
+func (t *Tree[K, V]) Upsert(key K, value V) error {
+	k, v, err := t.encode(key, value)
+	if err != nil {
+		return err
+	}
+	return t.tree.Upsert(k, v)
+}
+
+// GetOrInsert returns the value of key if it's already in the tree, otherwise it inserts
+// value. The returned bool is true if the value was already present.
+func (t *Tree[K, V]) GetOrInsert(key K, value V) (V, bool, error) {
+	var zero V
+	k, v, err := t.encode(key, value)
+	if err != nil {
+		return zero, false, err
+	}
+	buf, loaded, err := t.tree.GetOrInsert(k, v)
+	if err != nil {
+		return zero, false, err
+	}
+	if !loaded {
+		return value, false, nil
+	}
+	existing, err := t.values.Decode(buf)
+	return existing, true, err
+}
+
+// Delete a key value pair from the tree.
+func (t *Tree[K, V]) Delete(key K) error {
+	k, err := t.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	return t.tree.Delete(k)
+}
+
+// encode encodes a key and value with the tree's codecs.
+func (t *Tree[K, V]) encode(key K, value V) (bplus.Key, bplus.Value, error) {
+	k, err := t.keys.Encode(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := t.values.Encode(value)
+	return k, v, err
+}
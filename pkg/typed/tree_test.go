@@ -0,0 +1,151 @@
+package typed
+
+import (
+	"cmp"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/jpittis/bplus/pkg/bplus"
+)
+
+func TestTypedTreeReadEmpty(t *testing.T) {
+	tree, err := newTree[string, int64]("typed_tree", String, Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := tree.Read("missing")
+	if err != bplus.ErrKeyNotFound {
+		t.Fatalf("found unexpected value %+v", value)
+	}
+}
+
+func TestTypedTreeInsertAndRead(t *testing.T) {
+	tree, err := newTree[string, int64]("typed_insert", String, Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"mango", "apple", "kiwi", "banana", "cherry", "fig"}
+	for i, name := range names {
+		err := tree.Insert(name, int64(-i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range names {
+		value, err := tree.Read(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != int64(-i) {
+			t.Fatalf("expected %d == %d", value, -i)
+		}
+	}
+	value, loaded, err := tree.GetOrInsert("kiwi", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded || value != -2 {
+		t.Fatalf("expected kiwi to be loaded with -2, got %d", value)
+	}
+}
+
+func TestTypedTreeRejectsUnencodableValues(t *testing.T) {
+	tree, err := newTree("typed_unencodable", String, JSON[float64]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert("nan", math.NaN()); err == nil {
+		t.Fatal("expected inserting NaN to fail")
+	}
+	if err := tree.Upsert("nan", math.NaN()); err == nil {
+		t.Fatal("expected upserting NaN to fail")
+	}
+	if _, _, err := tree.GetOrInsert("nan", math.NaN()); err == nil {
+		t.Fatal("expected inserting NaN to fail")
+	}
+	if _, err := tree.Read("nan"); err != bplus.ErrKeyNotFound {
+		t.Fatalf("expected %v == %v", err, bplus.ErrKeyNotFound)
+	}
+}
+
+func TestTypedTreeKeyType(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "typed_key_type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	filename := tmpfile.Name()
+	defer os.Remove(filename)
+	tree, err := NewTree[string, int64](filename, String, Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert("kiwi", 1); err != nil {
+		t.Fatal(err)
+	}
+	if KeyType(tree.Bytes().KeyType()) != KeyString {
+		t.Fatalf("expected %v == %v", tree.Bytes().KeyType(), KeyString)
+	}
+	if err := tree.Bytes().Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tree can't be opened with keys of another type.
+	_, err = OpenTree[uint64, int64](filename, Uint64, Int64)
+	if err != ErrKeyType {
+		t.Fatalf("expected %v == %v", err, ErrKeyType)
+	}
+
+	// But can with the same type, or a codec that doesn't say.
+	reopened, err := OpenTree[string, int64](filename, plainCodec{}, Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Bytes().Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = OpenTree[string, int64](filename, String, Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Bytes().Close()
+	value, err := reopened.Read("kiwi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 1 {
+		t.Fatalf("expected %d == %d", value, 1)
+	}
+}
+
+// plainCodec encodes strings without saying what type of keys it encodes.
+type plainCodec struct{}
+
+func (plainCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (plainCodec) Decode(buf []byte) (string, error) {
+	return string(buf), nil
+}
+
+func newTree[K cmp.Ordered, V any](
+	filename string,
+	keys Codec[K],
+	values Codec[V],
+) (*Tree[K, V], error) {
+	tmpfile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		return nil, err
+	}
+	tmpfile.Close()
+	return NewTree(
+		tmpfile.Name(),
+		keys,
+		values,
+		bplus.WithBranchingFactor(4),
+		bplus.WithCacheSize(20),
+	)
+}